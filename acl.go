@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/*
+Authentication says who a client is; the access control list says what
+they may touch. Once a server requires auth (see auth.go), every user but
+an admin may only read and write keys under the prefixes they've been
+granted:
+
+	grant alice app/ rw     alice may read and write keys starting app/
+	grant bob app/cfg/ r    bob may only read them
+	revoke alice app/
+	grants [user]           list the grants, of one user or everyone
+
+A command that touches a key, or a range of keys, nobody has granted its
+user is refused with ErrPermissionDenied before it runs. A scan or keys
+must fall within a single granted prefix. Commands that don't name their
+keys, like call, and those that manage the database are for admins only,
+as are grant and revoke.
+
+Grants are made in a transaction like any write, and take effect when it
+commits; if it aborts, they never do. So a batch of changes to someone's
+access is seen all at once or not at all. Connections that didn't have to
+authenticate aren't subject to the list.
+*/
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+type Permission uint8
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermRead:
+		return "r"
+	case PermWrite:
+		return "w"
+	case PermRead | PermWrite:
+		return "rw"
+	}
+	return "-"
+}
+
+func parsePermission(s string) (Permission, error) {
+	switch s {
+	case "r":
+		return PermRead, nil
+	case "w":
+		return PermWrite, nil
+	case "rw":
+		return PermRead | PermWrite, nil
+	}
+	return 0, fmt.Errorf("permission must be r, w or rw, not %q", s)
+}
+
+type Grant struct {
+	User       string
+	Prefix     string
+	Permission Permission
+}
+
+type acl struct {
+	mu sync.RWMutex
+	// By user, then prefix.
+	grants map[string]map[string]Permission
+}
+
+// set replaces the user's permission on prefix; zero removes it.
+func (a *acl) set(user, prefix string, perm Permission) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if perm == 0 {
+		delete(a.grants[user], prefix)
+		if len(a.grants[user]) == 0 {
+			delete(a.grants, user)
+		}
+		return
+	}
+	if a.grants == nil {
+		a.grants = map[string]map[string]Permission{}
+	}
+	if a.grants[user] == nil {
+		a.grants[user] = map[string]Permission{}
+	}
+	a.grants[user][prefix] = perm
+}
+
+// allows reports whether a single prefix granted to user with perm covers
+// every key in [start, end). An empty end means no upper bound.
+func (a *acl) allows(user string, perm Permission, start, end string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for prefix, granted := range a.grants[user] {
+		if granted&perm != perm || !strings.HasPrefix(start, prefix) {
+			continue
+		}
+		if limit := prefixEnd(prefix); limit == "" || (end != "" && end <= limit) {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the grants to user, or to everyone if user is empty,
+// ordered by user and prefix.
+func (a *acl) list(user string) []Grant {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var grants []Grant
+	for _, u := range slices.Sorted(maps.Keys(a.grants)) {
+		if user != "" && u != user {
+			continue
+		}
+		for _, prefix := range slices.Sorted(maps.Keys(a.grants[u])) {
+			grants = append(grants, Grant{u, prefix, a.grants[u][prefix]})
+		}
+	}
+	return grants
+}
+
+// Grant gives user perm on the keys starting with prefix, replacing
+// whatever they had on it, once the transaction commits.
+func (t *Transaction) Grant(user, prefix string, perm Permission) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return err
+	}
+	t.onCommit = append(t.onCommit, func() { t.db.acl.set(user, prefix, perm) })
+	return nil
+}
+
+// Revoke takes away what user was granted on prefix, once the transaction
+// commits. Grants on longer or shorter prefixes are unaffected.
+func (t *Transaction) Revoke(user, prefix string) error {
+	return t.Grant(user, prefix, 0)
+}
+
+// applyGrants makes grants in a single transaction.
+func (d *Database) applyGrants(grants []Grant) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	for _, g := range grants {
+		if err := tx.Grant(g.User, g.Prefix, g.Permission); err != nil {
+			tx.Abort()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// A keyRange is a run of keys, [start, end), that a command needs perm
+// on.
+type keyRange struct {
+	perm       Permission
+	start, end string
+}
+
+func keyAccess(perm Permission, keys ...string) []keyRange {
+	ranges := make([]keyRange, len(keys))
+	for i, key := range keys {
+		ranges[i] = keyRange{perm, key, key + "\x00"}
+	}
+	return ranges
+}
+
+// Commands anyone may run.
+var openCommands = map[string]bool{
+	"auth":       true,
+	"begin":      true,
+	"commit":     true,
+	"abort":      true,
+	"savepoint":  true,
+	"rollback":   true,
+	"prepare":    true,
+	"autocommit": true,
+	"use":        true,
+}
+
+// The keys each statement touches, given well-formed arguments. Commands
+// not listed here or in openCommands are for admins only.
+var commandKeys = map[string]func(args []string) []keyRange{
+	"get":      func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"meta":     func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"exists":   func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"ttl":      func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"type":     func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"jget":     func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"jset":     func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"gets":     func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"lrange":   func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"lpush":    func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"rpush":    func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"lpop":     func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"hget":     func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"hgetall":  func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"hset":     func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"hdel":     func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"smembers": func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"zrange":   func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"sadd":     func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"srem":     func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"zadd":     func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"mget":     func(args []string) []keyRange { return keyAccess(PermRead, args...) },
+	"set":      func(args []string) []keyRange { return keyAccess(PermWrite, args[0]) },
+	"delete":   func(args []string) []keyRange { return keyAccess(PermWrite, args[0]) },
+	"setnx":    func(args []string) []keyRange { return keyAccess(PermWrite, args[0]) },
+	"expire":   func(args []string) []keyRange { return keyAccess(PermWrite, args[0]) },
+	"mdel":     func(args []string) []keyRange { return keyAccess(PermWrite, args...) },
+	"incr":     func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"decr":     func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"cincr":    func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"cdecr":    func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"getdel":   func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"cas":      func(args []string) []keyRange { return keyAccess(PermRead|PermWrite, args[0]) },
+	"rename": func(args []string) []keyRange {
+		return append(keyAccess(PermRead|PermWrite, args[0]), keyAccess(PermWrite, args[1])...)
+	},
+	"copy": func(args []string) []keyRange {
+		return append(keyAccess(PermRead, args[0]), keyAccess(PermWrite, args[1])...)
+	},
+	"mset": func(args []string) []keyRange {
+		var keys []string
+		for i := 0; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+		return keyAccess(PermWrite, keys...)
+	},
+	// Whatever keys the index names.
+	"lookup": func(args []string) []keyRange { return []keyRange{{PermRead, "", ""}} },
+	"watch":  func(args []string) []keyRange { return keyAccess(PermRead, args[0]) },
+	"watch-prefix": func(args []string) []keyRange {
+		return []keyRange{{PermRead, args[0], prefixEnd(args[0])}}
+	},
+	"keys": func(args []string) []keyRange {
+		prefix := globPrefix(args[0])
+		return []keyRange{{PermRead, prefix, prefixEnd(prefix)}}
+	},
+	"scan": func(args []string) []keyRange {
+		opts, err := parseScanArgs(args)
+		if err != nil {
+			// Let scan report it.
+			return nil
+		}
+		start, end := opts.bounds()
+		return []keyRange{{PermRead, start, end}}
+	},
+	"dbsize": func(args []string) []keyRange { return []keyRange{{PermRead, "", ""}} },
+	"explain": func(args []string) []keyRange {
+		if args[0] == "get" && len(args) == 2 {
+			return keyAccess(PermRead, args[1])
+		}
+		return nil
+	},
+}
+
+// authorize refuses command if the connection's user may not run it. It
+// runs after the command's arity has been checked.
+func (c *Connection) authorize(command string, args []string) error {
+	if c.user == nil || c.user.Admin || openCommands[command] {
+		return nil
+	}
+
+	touches, ok := commandKeys[command]
+	if !ok {
+		return fmt.Errorf("%w: %s is for admins only", ErrPermissionDenied, command)
+	}
+	for _, r := range touches(args) {
+		if c.db.acl.allows(c.user.Name, r.perm, r.start, r.end) {
+			continue
+		}
+		if r.end == r.start+"\x00" {
+			return fmt.Errorf("%w: %s needs %s on %q", ErrPermissionDenied, command, r.perm, r.start)
+		}
+		return fmt.Errorf("%w: %s needs %s on [%q, %q)", ErrPermissionDenied, command, r.perm, r.start, r.end)
+	}
+	return nil
+}
+
+// grant user prefix r|w|rw
+func (c *Connection) grant(args []string) (Result, error) {
+	perm, err := parsePermission(args[2])
+	if err != nil {
+		return Result{}, fmt.Errorf("grant: %w", err)
+	}
+	return Result{TxId: c.tx.id}, c.tx.Grant(args[0], args[1], perm)
+}
+
+// revoke user prefix
+func (c *Connection) revoke(args []string) (Result, error) {
+	return Result{TxId: c.tx.id}, c.tx.Revoke(args[0], args[1])
+}
+
+// grants [user]
+//
+// One "user prefix permission" line per grant, as committed, with the
+// prefix quoted.
+func (c *Connection) grants(args []string) (Result, error) {
+	var user string
+	if len(args) > 0 {
+		user = args[0]
+	}
+	var lines []string
+	for _, g := range c.db.acl.list(user) {
+		lines = append(lines, fmt.Sprintf("%s %q %s", g.User, g.Prefix, g.Permission))
+	}
+	return Result{Value: strings.Join(lines, "\n")}, nil
+}
+
+// loadGrants reads grants from a file of "user prefix permission" lines,
+// the format grants lists them in. Words are quoted as in the line
+// protocol. Blank lines and lines starting with # are ignored.
+func loadGrants(path string) ([]Grant, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var grants []Grant
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields, err := parseCommandLine(line)
+		if err != nil || len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected user prefix permission", path, n)
+		}
+		perm, err := parsePermission(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		grants = append(grants, Grant{fields[0], fields[1], perm})
+	}
+	return grants, scanner.Err()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func authedConnection(database *Database, name string) *Connection {
+	c := database.newConnection()
+	c.users = map[string]User{
+		"root":  {Name: "root", Password: "pw", Admin: true},
+		"alice": {Name: "alice", Password: "pw"},
+		"bob":   {Name: "bob", Password: "pw"},
+	}
+	c.mustExecCommand("auth", []string{name, "pw"})
+	return c
+}
+
+func denied(c *Connection, command string, args ...string) bool {
+	_, err := c.execCommand(command, args)
+	return errors.Is(err, ErrPermissionDenied)
+}
+
+func TestACL(t *testing.T) {
+	database := newDatabase()
+	root := authedConnection(&database, "root")
+	alice := authedConnection(&database, "alice")
+	bob := authedConnection(&database, "bob")
+
+	root.mustExecCommand("begin", nil)
+	root.mustExecCommand("grant", []string{"alice", "app/", "rw"})
+	root.mustExecCommand("grant", []string{"bob", "app/cfg/", "r"})
+	assert(denied(alice, "set", "app/x", "1"), "grant not yet committed")
+	root.mustExecCommand("commit", nil)
+
+	assertEq(alice.mustExecCommand("set", []string{"app/cfg/mode", "fast"}), "fast", "alice writes")
+	assert(denied(alice, "set", "other", "1"), "alice outside her prefix")
+	assert(denied(alice, "rename", "app/cfg/mode", "other"), "rename out of the prefix")
+	assertEq(alice.mustExecCommand("scan", []string{"app/"}), "app/cfg/mode=fast", "alice scans her prefix")
+	assert(denied(alice, "scan", "a", "b"), "alice scans beyond it")
+	assert(denied(alice, "keys", "*"), "alice lists every key")
+	assert(denied(alice, "dbsize"), "dbsize needs the whole keyspace")
+	assert(denied(alice, "vacuum"), "admin command")
+	assert(denied(alice, "grant", "alice", "", "rw"), "alice grants herself")
+
+	assertEq(bob.mustExecCommand("get", []string{"app/cfg/mode"}), "fast", "bob reads")
+	assertEq(bob.mustExecCommand("keys", []string{"app/cfg/*"}), "app/cfg/mode", "bob lists")
+	assert(denied(bob, "set", "app/cfg/mode", "slow"), "bob may not write")
+	assert(denied(bob, "incr", "app/cfg/n"), "incr needs write too")
+	assert(denied(bob, "get", "app/x"), "bob outside his prefix")
+
+	// An aborted revoke never takes effect.
+	root.mustExecCommand("begin", nil)
+	root.mustExecCommand("revoke", []string{"bob", "app/cfg/"})
+	root.mustExecCommand("abort", nil)
+	assert(!denied(bob, "get", "app/cfg/mode"), "revoke aborted")
+
+	root.mustExecCommand("revoke", []string{"bob", "app/cfg/"})
+	assert(denied(bob, "get", "app/cfg/mode"), "revoked")
+
+	assertEq(root.mustExecCommand("grants", nil), `alice "app/" rw`, "grants")
+	assertEq(root.mustExecCommand("grants", []string{"bob"}), "", "no grants")
+}
+
+func TestACL_unauthenticated(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "1"})
+	c.mustExecCommand("vacuum", nil)
+}
+
+func TestLoadGrants(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grants")
+	os.WriteFile(path, []byte("# readers\nbob \"\" r\nalice \"app cfg/\" rw\n"), 0o600)
+	grants, err := loadGrants(path)
+	assertEq(err, nil, "load")
+	assertEq(len(grants), 2, "grants")
+	assertEq(grants[0], Grant{"bob", "", PermRead}, "empty prefix")
+	assertEq(grants[1], Grant{"alice", "app cfg/", PermRead | PermWrite}, "quoted prefix")
+
+	database := newDatabase()
+	assertEq(database.applyGrants(grants), nil, "apply")
+	assert(database.acl.allows("bob", PermRead, "", ""), "bob reads everything")
+	assert(!database.acl.allows("bob", PermWrite, "x", "x\x00"), "but writes nothing")
+
+	os.WriteFile(path, []byte("bob x rwx\n"), 0o600)
+	_, err = loadGrants(path)
+	assertEq(err.Error(), path+`:1: permission must be r, w or rw, not "rwx"`, "bad permission")
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Admin commands act on transactions that belong to other connections. They
+are for incident response: a forgotten transaction pins the GC horizon,
+versions pile up, memory climbs, and somebody needs to make it stop.
+
+Whatever they do is written to the database's admin log (when one is
+configured) so there is a record of what was killed and why.
+*/
+
+// ErrTransactionKilled is what the owner of a killed transaction gets. It
+// is an ErrTransactionAborted too.
+var ErrTransactionKilled = fmt.Errorf("%w by an administrator", ErrTransactionAborted)
+
+// WithAdminLog makes admin commands record what they did to w, a line at
+// a time.
+func WithAdminLog(w io.Writer) Option {
+	return func(d *Database) {
+		d.adminLog = w
+	}
+}
+
+func (d *Database) adminAudit(format string, args ...any) {
+	if d.adminLog == nil {
+		return
+	}
+	fmt.Fprintf(d.adminLog, "%s %s\n", d.clock().UTC().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+// forceAbort aborts every in-progress transaction matching pred and
+// returns their ids. Their owners find out through ErrTransactionAborted
+// on their next statement.
+func (d *Database) forceAbort(pred func(*Transaction) bool) []uint64 {
+	d.mu.Lock()
+	var ids []uint64
+	var hooks []func()
+	// Completing a transaction changes the running set, so iterate over a
+	// copy.
+	running := d.running.Copy()
+	iter := running.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		// Aborting a transaction aborts the one nested in it too, which
+		// may be pruned before the copy gets to it.
+		t, found := d.transactions.Get(iter.Key())
+		if !found || !d.running.Contains(iter.Key()) {
+			continue
+		}
+		// Nested transactions go with their outermost ones, and prepared
+		// ones are left for their coordinator.
+		if t.parent == nil && t.state == InProgressTransaction && t.prepared == "" && pred(t) {
+			d.logger.Info("force aborting transaction", "tx", t.id, "isolation", t.isolation.String())
+			d.completeTransaction(t, AbortedTransaction)
+			ids = append(ids, t.id)
+			hooks = append(hooks, t.takeHooks()...)
+		}
+	}
+	d.mu.Unlock()
+
+	runHooks(hooks)
+	return ids
+}
+
+// Transactions describes the transactions in progress, oldest first.
+func (d *Database) Transactions() []TxInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.activeTransactions()
+}
+
+// txlist
+//
+// One line per transaction in progress, oldest first. Those marked
+// holds-horizon are what stops vacuum from reclaiming more.
+func (c *Connection) txlist() (Result, error) {
+	now := c.db.clock()
+	var lines []string
+	for _, tx := range c.db.Transactions() {
+		line := fmt.Sprintf("id=%d isolation=%s age=%s reads=%d writes=%d",
+			tx.Id, tx.Isolation, now.Sub(tx.Started).Round(time.Millisecond), tx.Reads, tx.Writes)
+		if tx.Priority != NormalPriority {
+			line += " priority=" + tx.Priority.String()
+		}
+		if tx.Prepared != "" {
+			line += fmt.Sprintf(" prepared=%q", tx.Prepared)
+		}
+		if tx.HoldsHorizon {
+			line += " holds-horizon"
+		}
+		lines = append(lines, line)
+	}
+	return Result{Value: strings.Join(lines, "\n")}, nil
+}
+
+// AbortAll force-aborts every in-progress transaction that has been
+// running for at least olderThan, returning their ids.
+func (d *Database) AbortAll(olderThan time.Duration) []uint64 {
+	return d.abortAll(olderThan, nil)
+}
+
+// abortAll is AbortAll, sparing except.
+func (d *Database) abortAll(olderThan time.Duration, except *Transaction) []uint64 {
+	now := d.clock()
+	ids := d.forceAbort(func(t *Transaction) bool {
+		return t != except && now.Sub(t.started) >= olderThan
+	})
+	d.adminAudit("abortall older-than=%s aborted=%v", olderThan, ids)
+	return ids
+}
+
+// abortall [--older-than <dur>]
+//
+// The connection's own transaction, if it has one, is left alone.
+func (c *Connection) abortAll(args []string) (Result, error) {
+	flags := flag.NewFlagSet("abortall", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	olderThan := flags.Duration("older-than", 0, "")
+	if err := flags.Parse(args); err != nil {
+		return Result{}, fmt.Errorf("abortall: %w", err)
+	}
+
+	// Sparing the transaction the connection's is nested in, if it is.
+	var own *Transaction
+	if c.tx != nil {
+		own = c.tx.root()
+	}
+	ids := c.db.abortAll(*olderThan, own)
+	aborted := make([]string, len(ids))
+	for i, id := range ids {
+		aborted[i] = fmt.Sprintf("%d", id)
+	}
+	return Result{Value: strings.Join(aborted, " ")}, nil
+}
+
+// Kill force-aborts the transaction id, which must be in progress, along
+// with whatever it is nested in. Its owner gets ErrTransactionKilled on
+// its next statement.
+func (d *Database) Kill(id uint64) error {
+	d.mu.Lock()
+	t, ok := d.transactions.Get(id)
+	if !ok || !d.running.Contains(id) || t.state != InProgressTransaction {
+		d.mu.Unlock()
+		return fmt.Errorf("no transaction %d in progress", id)
+	}
+	t = t.root()
+	if t.prepared != "" {
+		d.mu.Unlock()
+		return fmt.Errorf("transaction %d is prepared as %q: %w", id, t.prepared, ErrTransactionPrepared)
+	}
+
+	d.logger.Info("killing transaction", "tx", t.id, "isolation", t.isolation.String())
+	for nested := t; nested != nil; nested = nested.child {
+		nested.killed = true
+	}
+	d.completeTransaction(t, AbortedTransaction)
+	hooks := t.takeHooks()
+	d.mu.Unlock()
+
+	runHooks(hooks)
+	d.adminAudit("kill tx=%d", id)
+	return nil
+}
+
+// kill transaction <id>
+func (c *Connection) kill(args []string) (Result, error) {
+	if args[0] != "transaction" {
+		return Result{}, errors.New("kill: expected kill transaction <id>")
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("kill: bad transaction id %q", args[1])
+	}
+	if err := c.db.Kill(id); err != nil {
+		return Result{}, fmt.Errorf("kill: %w", err)
+	}
+	return Result{Value: args[1]}, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAbortAll(t *testing.T) {
+	database := newDatabase()
+	var log strings.Builder
+	database.apply(WithAdminLog(&log))
+
+	old := database.newConnection()
+	old.mustExecCommand("begin", nil)
+	old.mustExecCommand("set", []string{"x", "hey"})
+	old.tx.started = time.Now().Add(-time.Hour)
+
+	young := database.newConnection()
+	young.mustExecCommand("begin", nil)
+
+	admin := database.newConnection()
+	admin.mustExecCommand("begin", nil)
+
+	res := admin.mustExecCommand("abortall", []string{"--older-than", "1m"})
+	assertEq(res, fmt.Sprintf("%d", old.tx.id), "abortall older than 1m")
+	assert(strings.Contains(log.String(), "aborted=["+res+"]"), "audit record")
+
+	_, err := old.execCommand("get", []string{"x"})
+	assert(errors.Is(err, ErrTransactionAborted), "old aborted")
+
+	// Without a filter everything but the caller's own transaction goes.
+	res = admin.mustExecCommand("abortall", nil)
+	assertEq(res, fmt.Sprintf("%d", young.tx.id), "abortall")
+	admin.mustExecCommand("commit", nil)
+
+	_, err = admin.execCommand("abortall", []string{"--older-than", "soon"})
+	assert(err != nil, "bad duration")
+}
+
+func TestAbortAll_nested(t *testing.T) {
+	database := newDatabase()
+
+	nested := database.newConnection()
+	nested.mustExecCommand("begin", nil)
+	nested.mustExecCommand("set", []string{"x", "1"})
+	nested.mustExecCommand("begin", nil)
+	outer := nested.tx.root().id
+
+	admin := database.newConnection()
+	assertEq(admin.mustExecCommand("abortall", nil), fmt.Sprint(outer), "the outermost transaction goes")
+	_, err := nested.execCommand("get", []string{"x"})
+	assert(errors.Is(err, ErrTransactionAborted), "nested aborted")
+
+	// Run from inside a nested transaction, abortall spares it and what
+	// it is nested in.
+	admin.mustExecCommand("begin", nil)
+	admin.mustExecCommand("begin", nil)
+	assertEq(admin.mustExecCommand("abortall", nil), "", "own transactions spared")
+	admin.mustExecCommand("commit", nil)
+	admin.mustExecCommand("commit", nil)
+}
+
+func TestTxList(t *testing.T) {
+	database := newDatabase()
+	admin := database.newConnection()
+	admin.mustExecCommand("set", []string{"x", "hey"})
+
+	old := database.newConnection()
+	old.mustExecCommand("begin", nil)
+	old.mustExecCommand("get", []string{"x"})
+	old.mustExecCommand("set", []string{"y", "there"})
+	old.tx.started = time.Now().Add(-time.Hour)
+
+	young := database.newConnection()
+	young.mustExecCommand("begin", nil)
+
+	lines := strings.Split(admin.mustExecCommand("txlist", nil), "\n")
+	assertEq(len(lines), 2, "one line per transaction")
+	assert(strings.HasPrefix(lines[0], fmt.Sprintf("id=%d isolation=%s age=1h0m0", old.tx.id, old.tx.isolation)), "old listed first")
+	assert(strings.HasSuffix(lines[0], "reads=1 writes=1 holds-horizon"), "old holds the horizon")
+	// Young's snapshot keeps out what old writes, so it holds the horizon
+	// too, even once old is done.
+	assertEq(lines[1], fmt.Sprintf("id=%d isolation=%s age=0s reads=0 writes=0 holds-horizon", young.tx.id, young.tx.isolation), "young")
+	old.mustExecCommand("commit", nil)
+	assertEq(admin.mustExecCommand("txlist", nil), lines[1], "young alone")
+
+	later := database.newConnection()
+	later.mustExecCommand("begin", nil)
+	lines = strings.Split(admin.mustExecCommand("txlist", nil), "\n")
+	assert(!strings.HasSuffix(lines[1], "holds-horizon"), "later doesn't hold the horizon")
+}
+
+func TestKill(t *testing.T) {
+	database := newDatabase()
+	database.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	var log strings.Builder
+	database.apply(WithAdminLog(&log))
+	admin := database.newConnection()
+
+	victim := database.newConnection()
+	victim.mustExecCommand("begin", nil)
+	victim.mustExecCommand("set", []string{"x", "killed"})
+	victim.mustExecCommand("begin", nil)
+	id := fmt.Sprintf("%d", victim.tx.id)
+	bystander := database.newConnection()
+	bystander.mustExecCommand("begin", nil)
+
+	assertEq(admin.mustExecCommand("kill", []string{"transaction", id}), id, "kill the nested transaction")
+	assertEq(log.String(), "2024-01-02T03:04:05Z kill tx="+id+"\n", "audit record")
+	_, err := victim.execCommand("get", []string{"x"})
+	assertEq(err, ErrTransactionKilled, "owner finds out")
+	assert(errors.Is(err, ErrTransactionAborted), "killed is aborted")
+	assertEq(len(database.Transactions()), 1, "its parent went too")
+	victim.mustExecCommand("begin", nil)
+	_, err = victim.execCommand("get", []string{"x"})
+	assertEq(err, ErrKeyNotFound, "write discarded")
+
+	_, err = admin.execCommand("kill", []string{"transaction", id})
+	assert(err != nil && err.Error() == "kill: no transaction "+id+" in progress", "already gone")
+	_, err = admin.execCommand("kill", []string{"transaction", "x"})
+	assert(err != nil, "bad id")
+	_, err = admin.execCommand("kill", []string{"connection", id})
+	assert(err != nil, "only transactions")
+
+	bystander.tx.Prepare("g")
+	_, err = admin.execCommand("kill", []string{"transaction", fmt.Sprintf("%d", bystander.tx.id)})
+	assert(errors.Is(err, ErrTransactionPrepared), "prepared transactions are left to rollback-prepared")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"cmp"
+	"html/template"
+	"net/http"
+	"slices"
+	"time"
+)
+
+/*
+A small read-only admin UI, so operators can triage without CLI access:
+what is in progress and for how long, which keys have the longest version
+chains, and the full version history of any one key.
+
+Everything is rendered through html/template, so keys and values are
+escaped however hostile they are. The UI is just an http.Handler; mount it
+on whatever internal-only listener the embedding application has. It shows
+every key, whatever the access control list says, so where there are
+users, RequireAdmin keeps it to the admins among them.
+*/
+
+// TxInfo describes an in-progress transaction.
+type TxInfo struct {
+	Id        uint64
+	Isolation IsolationLevel
+	Started   time.Time
+	Reads     int
+	Writes    int
+	// Whether the transaction is what keeps the horizon where it is, so
+	// that vacuum can't reclaim versions that ended after it: it is the
+	// oldest, or its snapshot began while the oldest was in progress.
+	HoldsHorizon bool
+	// The global id it is prepared under, if it is prepared.
+	Prepared string
+	Priority Priority
+}
+
+// VersionInfo describes one version of a key.
+type VersionInfo struct {
+	TxStartId uint64
+	TxEndId   uint64
+	Value     string
+	// The final state of the transaction that wrote the version.
+	State TransactionState
+}
+
+type keyVersions struct {
+	Key      string
+	Versions int
+}
+
+func (l IsolationLevel) String() string {
+	switch l {
+	case ReadUncommitedIsolation:
+		return "read-uncommitted"
+	case ReadCommitedIsolation:
+		return "read-committed"
+	case RepeatableReadIsolation:
+		return "repeatable-read"
+	case SnapshotIsolation:
+		return "snapshot"
+	case SerializableIsolation:
+		return "serializable"
+	}
+	return "unknown"
+}
+
+func (d *Database) activeTransactions() []TxInfo {
+	horizon := d.horizon()
+	var txs []TxInfo
+	iter := d.transactions.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		t := iter.Value()
+		if t.state != InProgressTransaction {
+			continue
+		}
+		txs = append(txs, TxInfo{
+			Id:        t.id,
+			Isolation: t.isolation,
+			Started:   t.started,
+			Reads:     t.readset.Len(),
+			Writes:    t.writeset.Len(),
+			Prepared:  t.prepared,
+			Priority:  t.priority,
+		})
+		oldest, ok := t.inprogress.oldest()
+		txs[len(txs)-1].HoldsHorizon = t.id == horizon || (ok && oldest == horizon)
+	}
+	return txs
+}
+
+func (d *Database) keyHistory(key string) []VersionInfo {
+	var history []VersionInfo
+	for _, v := range d.versions(key) {
+		value, err := d.read(&v)
+		if err != nil {
+			value = "(" + err.Error() + ")"
+		}
+		history = append(history, VersionInfo{
+			TxStartId: v.txStartId,
+			TxEndId:   v.txEndId,
+			Value:     value,
+			State:     d.transactionState(v.txStartId),
+		})
+	}
+	return history
+}
+
+// hotKeys returns the n keys with the longest version chains.
+func (d *Database) hotKeys(n int) []keyVersions {
+	var keys []keyVersions
+	iter := d.store.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		keys = append(keys, keyVersions{iter.Key(), len(iter.Value())})
+	}
+	slices.SortStableFunc(keys, func(a, b keyVersions) int {
+		return cmp.Compare(b.Versions, a.Versions)
+	})
+	return keys[:min(n, len(keys))]
+}
+
+var adminTemplate = template.Must(template.New("admin").Funcs(template.FuncMap{
+	"age": func(started time.Time) time.Duration {
+		return time.Since(started).Round(time.Millisecond)
+	},
+}).Parse(`<!doctype html>
+<title>mvcc admin</title>
+<h1>mvcc admin</h1>
+<p>{{.Keys}} keys, {{.Versions}} versions, next transaction id {{.NextTxId}}</p>
+
+<h2>Active transactions</h2>
+<table>
+<tr><th>id</th><th>isolation</th><th>age</th><th>reads</th><th>writes</th><th>holds horizon</th></tr>
+{{range .Active}}<tr><td>{{.Id}}</td><td>{{.Isolation}}</td><td>{{age .Started}}</td><td>{{.Reads}}</td><td>{{.Writes}}</td><td>{{if .HoldsHorizon}}yes{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Hot keys</h2>
+<table>
+<tr><th>key</th><th>versions</th></tr>
+{{range .Hot}}<tr><td><a href="?key={{.Key}}">{{.Key}}</a></td><td>{{.Versions}}</td></tr>
+{{end}}</table>
+
+{{if .Key}}<h2>History of {{.Key}}</h2>
+<table>
+<tr><th>txStartId</th><th>txEndId</th><th>writer</th><th>value</th></tr>
+{{range .History}}<tr><td>{{.TxStartId}}</td><td>{{.TxEndId}}</td><td>{{.State}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>{{end}}
+`))
+
+// AdminHandler serves the admin UI. Pass ?key=<key> to see a key's
+// version history.
+func (d *Database) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+
+		d.mu.Lock()
+		page := struct {
+			Keys, Versions int
+			NextTxId       uint64
+			Active         []TxInfo
+			Hot            []keyVersions
+			Key            string
+			History        []VersionInfo
+		}{
+			NextTxId: d.nextTransactionId,
+			Active:   d.activeTransactions(),
+			Hot:      d.hotKeys(20),
+			Key:      key,
+		}
+		iter := d.store.Iter()
+		for ok := iter.First(); ok; ok = iter.Next() {
+			page.Keys++
+			page.Versions += len(iter.Value())
+		}
+		if key != "" {
+			page.History = d.keyHistory(key)
+		}
+		d.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := adminTemplate.Execute(w, page)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// RequireAdmin makes requests to h authenticate as one of the admins among
+// users, with HTTP Basic credentials.
+func RequireAdmin(users []User, h http.Handler) http.Handler {
+	byName := usersByName(users)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, password, given := r.BasicAuth()
+		u, found := byName[name]
+		// As auth does.
+		if !given || !passwordsMatch(u.Password, password) || !found {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mvcc admin"`)
+			http.Error(w, ErrBadCredentials.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !u.Admin {
+			http.Error(w, ErrPermissionDenied.Error(), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	database := newDatabase()
+	database.Set("<script>", "alert(1)")
+	database.Set("<script>", "<b>bold</b>")
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "hey"})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?key=%3Cscript%3E", nil)
+	database.AdminHandler().ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	assertEq(rec.Code, 200, "status")
+	assert(!strings.Contains(body, "<script>"), "key is escaped")
+	assert(!strings.Contains(body, "<b>"), "value is escaped")
+	assert(strings.Contains(body, "&lt;b&gt;bold&lt;/b&gt;"), "history shows value")
+	assert(strings.Contains(body, "<td>read-committed</td>"), "active transaction listed")
+	assert(strings.Contains(body, "2 keys, 3 versions"), "summary")
+}
+
+func TestRequireAdmin(t *testing.T) {
+	database := newDatabase()
+	h := RequireAdmin([]User{{Name: "alice", Password: "pw", Admin: true}, {Name: "bob", Password: "pw"}}, database.AdminHandler())
+	get := func(name, password string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		if name != "" {
+			req.SetBasicAuth(name, password)
+		}
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assertEq(get("", ""), 401, "no credentials")
+	assertEq(get("alice", "wrong"), 401, "wrong password")
+	assertEq(get("bob", "pw"), 403, "not an admin")
+	assertEq(get("alice", "pw"), 200, "admin")
+}
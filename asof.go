@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/*
+Every version is kept (until vacuumed), so the database can answer "what
+did this key look like when transaction N began?". That is just the
+question a Repeatable Read transaction N would have answered about its own
+snapshot, so a time-travel read builds a synthetic, read-only transaction
+with N's id and N's record of what was in progress when it began, and
+runs the normal visibility rules with it.
+
+Transaction N itself counts as in progress in that snapshot, so none of
+its own writes show up, and neither do its deletes hide anything: the
+snapshot is of the moment N began.
+
+The registry forgets transactions once nobody is left who could see
+what they saw (see registry.go), but their snapshots stay until vacuum
+removes a version one of them could see. Then reads as of them fail
+rather than miss it. Versions outlive the transactions that can see
+them only as far as the retention policy keeps them (see retention.go),
+so that is what keeps old snapshots readable for long.
+
+Reads as of a time, rather than a transaction, build their snapshot from
+commit timestamps instead; see hlc.go.
+*/
+
+// pastSnapshot is what a read as of a pruned transaction needs of it.
+type pastSnapshot struct {
+	started    time.Time
+	inprogress snapshot
+}
+
+// historicalSnapshot returns a read-only transaction that sees what
+// transaction txId saw when it began.
+func (d *Database) historicalSnapshot(txId uint64) (*Transaction, error) {
+	past, ok := d.pastSnapshots.Get(txId)
+	if original, found := d.transactions.Get(txId); found {
+		past, ok = pastSnapshot{original.started, original.inprogress}, true
+	}
+	// It counts as in progress itself.
+	inprogress := past.inprogress.with(txId)
+	if !ok || inprogress.xmin <= d.vacuumedEnd {
+		return nil, fmt.Errorf("no transaction %d to read as of", txId)
+	}
+
+	t := &Transaction{
+		isolation:  RepeatableReadIsolation,
+		id:         txId,
+		state:      InProgressTransaction,
+		started:    past.started,
+		historical: true,
+		db:         d,
+	}
+	t.inprogress = inprogress
+	return t, nil
+}
+
+// vacuumedVersion notes that vacuum removed a version transaction end
+// ended, and drops the snapshots of pruned transactions that could have
+// seen it.
+func (d *Database) vacuumedVersion(end uint64) {
+	if end <= d.vacuumedEnd {
+		return
+	}
+	d.vacuumedEnd = end
+
+	var ids []uint64
+	iter := d.pastSnapshots.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		// It could see the version unless it began after the end did,
+		// with every transaction up to the end finished.
+		if min(iter.Key(), iter.Value().inprogress.xmin) <= end {
+			ids = append(ids, iter.Key())
+		}
+	}
+	for _, id := range ids {
+		d.pastSnapshots.Delete(id)
+	}
+}
+
+// GetAsOf returns the value key had when transaction txId began.
+func (d *Database) GetAsOf(key string, txId uint64) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, err := d.historicalSnapshot(txId)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := t.get(key)
+	if err != nil {
+		return "", err
+	}
+	return t.db.read(value)
+}
+
+// get key asof <txid|time>
+//
+// The time is in RFC 3339 format, or a commit timestamp (see hlc.go).
+func (c *Connection) getAsOf(args []string) (Result, error) {
+	txId, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		ts, err := parseHLC(args[2])
+		if err != nil {
+			return Result{}, fmt.Errorf("get: asof wants a transaction id or a time: %w", err)
+		}
+		res := Result{Keys: args[:1]}
+		value, err := c.db.getAsOfHLC(args[0], ts)
+		res.Value = value
+		res.NotFound = errors.Is(err, ErrKeyNotFound)
+		return res, err
+	}
+
+	res := Result{Keys: args[:1], TxId: txId}
+	value, err := c.db.GetAsOf(args[0], txId)
+	res.Value = value
+	res.NotFound = errors.Is(err, ErrKeyNotFound)
+	return res, err
+}
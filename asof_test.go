@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestGetAsOf(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "one"})
+	c1.mustExecCommand("commit", nil)
+
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+	long := c2.tx.id
+
+	// Committed after long began, so invisible as of long.
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "two"})
+	c1.mustExecCommand("commit", nil)
+
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("delete", []string{"x"})
+	deleter := c1.tx.id
+	c1.mustExecCommand("commit", nil)
+
+	c3 := database.newConnection()
+	asof := func(txId uint64) (string, error) {
+		res, err := c3.execCommand("get", []string{"x", "asof", fmt.Sprint(txId)})
+		return res.Value, err
+	}
+
+	res, _ := asof(long)
+	assertEq(res, "one", "x as of long")
+
+	// As of the deleter's start, its own delete hasn't happened yet.
+	res, _ = asof(deleter)
+	assertEq(res, "two", "x as of deleter")
+
+	_, err := asof(deleter + 100)
+	assertEq(err.Error(), fmt.Sprintf("no transaction %d to read as of", deleter+100), "x as of the future")
+
+	// Even as of deleter, the version it ended is still there.
+	c3.mustExecCommand("vacuum", nil)
+	res, _ = asof(deleter)
+	assertEq(res, "two", "x as of deleter after vacuum")
+
+	_, err = asof(1)
+	assert(errors.Is(err, ErrKeyNotFound), "x before anything")
+
+	// Reading as of a transaction doesn't disturb it.
+	c2.mustExecCommand("commit", nil)
+}
+
+func TestGetAsOf_pruned(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "one"})
+	c.mustExecCommand("set", []string{"x", "two"})
+	c.mustExecCommand("set", []string{"x", "three"})
+	assertEq(database.transactions.Len(), 0, "pruned")
+
+	res, err := c.execCommand("get", []string{"x", "asof", "2"})
+	assertEq(err, nil, "x as of a pruned transaction")
+	assertEq(res.Value, "one", "x as of 2")
+
+	// Once vacuum removes what it saw, it can't be read as of.
+	c.mustExecCommand("vacuum", nil)
+	_, err = c.execCommand("get", []string{"x", "asof", "2"})
+	assertEq(err.Error(), "no transaction 2 to read as of", "x as of 2 after vacuum")
+}
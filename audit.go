@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+/*
+For compliance's sake the database can keep an audit trail of everything
+committed: for every key a transaction changed, what it held before, what
+it holds after, which transaction changed it, when, and on whose behalf.
+
+Whose behalf is an identity carried in the context a transaction begins
+in. Connections served by a Server identify as their user, if they
+authenticated, and the address they connected from, as "name@address".
+Applications using the database directly attribute their transactions by
+beginning them with a context from WithIdentity.
+
+The sink is called while the database lock is held, once per key, in
+commit order, so the trail has the same order as the log. It must not use
+the database, and every commit waits for it.
+*/
+
+// An AuditRecord is a change a committed transaction made to a key.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	TxId uint64    `json:"tx"`
+	// Who the transaction ran for, if known.
+	Identity string `json:"identity,omitempty"`
+	Key      string `json:"key"`
+	// The value before and after the change. Old is nil if there was
+	// none, and New is nil if the key was deleted.
+	Old *string `json:"old"`
+	New *string `json:"new"`
+}
+
+// WithAuditLog makes the database pass every change it commits to sink.
+func WithAuditLog(sink func(AuditRecord)) Option {
+	return func(d *Database) {
+		d.audit = sink
+	}
+}
+
+// WriteAuditLog returns an audit sink writing records to w as lines of
+// JSON.
+func WriteAuditLog(w io.Writer) func(AuditRecord) {
+	enc := json.NewEncoder(w)
+	return func(r AuditRecord) {
+		enc.Encode(r)
+	}
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context whose transactions are audited as run on
+// behalf of who.
+func WithIdentity(ctx context.Context, who string) context.Context {
+	return context.WithValue(ctx, identityKey{}, who)
+}
+
+func identityFrom(ctx context.Context) string {
+	who, _ := ctx.Value(identityKey{}).(string)
+	return who
+}
+
+// identity is who the connection's transactions are run on behalf of.
+func (c *Connection) identity() string {
+	switch {
+	case c.user != nil && c.remote != "":
+		return c.user.Name + "@" + c.remote
+	case c.user != nil:
+		return c.user.Name
+	}
+	return c.remote
+}
+
+// auditOld remembers what key held before the transaction first changed
+// it, given the index of the newest version visible to the transaction.
+func (t *Transaction) auditOld(key string, versions []Value, visible int) {
+	if t.db.audit == nil {
+		return
+	}
+	if _, ok := t.audited[key]; ok {
+		return
+	}
+	if t.audited == nil {
+		t.audited = map[string]*string{}
+	}
+	var old *string
+	if visible >= 0 {
+		value, err := t.db.read(&versions[visible])
+		if err != nil {
+			t.readFailed(err)
+		}
+		old = &value
+	}
+	t.audited[key] = old
+}
+
+// auditCommit passes the changes t made to the audit sink, now that t has
+// committed.
+func (d *Database) auditCommit(t *Transaction) {
+	if d.audit == nil {
+		return
+	}
+	now := d.clock()
+	iter := t.writeset.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		key := iter.Key()
+		record := AuditRecord{Time: now, TxId: t.id, Identity: t.identity, Key: key, Old: t.audited[key]}
+		versions := d.versions(key)
+		for i := range versions {
+			if t.owns(versions[i].txStartId) && !t.owns(versions[i].txEndId) {
+				value, err := d.read(&versions[i])
+				if err != nil {
+					// Too late to fail the commit; the record goes without it.
+					d.logger.Error("audit: can't read a committed value", "tx", t.id, "key", key, "err", err)
+					continue
+				}
+				record.New = &value
+			}
+		}
+		d.audit(record)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	database := newDatabase()
+	var records []AuditRecord
+	database.apply(WithAuditLog(func(r AuditRecord) { records = append(records, r) }))
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "1"})
+	assertEq(len(records), 1, "autocommitted set")
+	assert(records[0].Old == nil, "x was new")
+	assertEq(*records[0].New, "1", "x set")
+
+	c.mustExecCommand("begin", nil)
+	id := c.tx.id
+	c.mustExecCommand("set", []string{"x", "2"})
+	c.mustExecCommand("set", []string{"x", "3"})
+	c.mustExecCommand("set", []string{"y", "4"})
+	c.mustExecCommand("delete", []string{"y"})
+	c.mustExecCommand("set", []string{"z", "5"})
+	assertEq(len(records), 1, "nothing audited before commit")
+	c.mustExecCommand("commit", nil)
+
+	// One record per key, in key order, from before the transaction to
+	// after it.
+	assertEq(len(records), 4, "one record per key")
+	x, y, z := records[1], records[2], records[3]
+	assertEq(x.TxId, id, "tx id")
+	assertEq(x.Key, "x", "x")
+	assertEq(*x.Old, "1", "x before")
+	assertEq(*x.New, "3", "x after")
+	assertEq(y.Key, "y", "y")
+	assert(y.Old == nil && y.New == nil, "y came and went")
+	assertEq(z.Key, "z", "z")
+	assert(z.Old == nil, "z was new")
+	assertEq(*z.New, "5", "z set")
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "6"})
+	c.mustExecCommand("abort", nil)
+	assertEq(len(records), 4, "aborted changes aren't audited")
+
+	tx, err := database.BeginContext(WithIdentity(context.Background(), "batch job"))
+	assertEq(err, nil, "begin")
+	tx.Delete("x")
+	assertEq(tx.Commit(), nil, "commit")
+	assertEq(records[4].Identity, "batch job", "identity")
+	assertEq(*records[4].Old, "3", "x deleted")
+	assert(records[4].New == nil, "x gone")
+}
+
+func TestAuditLog_identity(t *testing.T) {
+	database := newDatabase()
+	lines, w := io.Pipe()
+	database.apply(WithAuditLog(WriteAuditLog(w)))
+	srv := NewServer(&database)
+	srv.RequireAuth([]User{{Name: "alice", Password: "secret", Admin: true}})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assertEq(err, nil, "listen")
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	assertEq(err, nil, "dial")
+	defer conn.Close()
+	conn.Write([]byte("auth alice secret\nset x hey\n"))
+
+	var record AuditRecord
+	assertEq(json.NewDecoder(lines).Decode(&record), nil, "a line of JSON")
+	assertEq(record.Identity, "alice@"+conn.LocalAddr().String(), "user and address")
+	assertEq(*record.New, "hey", "value")
+}
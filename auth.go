@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+A server bound to anything but loopback shouldn't be open to whoever can
+reach it. UseTLS encrypts its connections, and RequireAuth makes every
+connection authenticate before anything else, with
+
+	auth name password
+
+(AUTH over RESP). Until it does, every other command fails with
+ErrAuthRequired.
+
+The gRPC and HTTP frontends have no connection to authenticate, so with
+RequireAuth each request does, with HTTP Basic credentials: an
+Authorization header, or "authorization" metadata over gRPC.
+*/
+
+var (
+	ErrAuthRequired   = errors.New("authentication required")
+	ErrBadCredentials = errors.New("invalid user name or password")
+)
+
+type User struct {
+	Name     string
+	Password string
+	// Admins aren't subject to the access control list (see acl.go).
+	Admin bool
+}
+
+// UseTLS makes the server accept only TLS connections, configured by cfg.
+// It must be called before the server starts serving.
+func (s *Server) UseTLS(cfg *tls.Config) {
+	s.tlsConfig = cfg
+}
+
+// loadPeerTLS returns the configuration to dial other nodes with over
+// TLS, checking their certificates against the authority in caFile, or
+// the system's if it is empty.
+func loadPeerTLS(caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.RootCAs = x509.NewCertPool()
+	if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates", caFile)
+	}
+	return cfg, nil
+}
+
+// loadSecret reads a secret shared by the nodes of a cluster from path,
+// ignoring surrounding whitespace.
+func loadSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("%s: empty secret", path)
+	}
+	return secret, nil
+}
+
+// loadCredentials reads the "name:password" a node authenticates to
+// another with from path.
+func loadCredentials(path string) (name, password string, err error) {
+	s, err := loadSecret(path)
+	if err != nil {
+		return "", "", err
+	}
+	name, password, ok := strings.Cut(s, ":")
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("%s: expected name:password", path)
+	}
+	return name, password, nil
+}
+
+// RequireAuth makes clients authenticate as one of users before they can
+// run any command. It must be called before the server starts serving.
+func (s *Server) RequireAuth(users []User) {
+	s.users = usersByName(users)
+}
+
+func usersByName(users []User) map[string]User {
+	byName := map[string]User{}
+	for _, u := range users {
+		byName[u.Name] = u
+	}
+	return byName
+}
+
+// parseBasicAuth parses the credentials of an HTTP Basic authorization,
+// "Basic " and the base64 of name:password, which gRPC clients send in
+// their metadata too.
+func parseBasicAuth(auth string) (name, password string, ok bool) {
+	encoded, ok := strings.CutPrefix(auth, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// auth name password
+func (c *Connection) auth(args []string) (Result, error) {
+	if c.users == nil {
+		return Result{}, errors.New("auth: authentication is not enabled")
+	}
+
+	u, found := c.users[args[0]]
+	// Compare the password even when the user doesn't exist, so as not to
+	// give away which users do.
+	if !passwordsMatch(u.Password, args[1]) || !found {
+		return Result{}, ErrBadCredentials
+	}
+	c.user = &u
+	return Result{Value: u.Name}, nil
+}
+
+// passwordsMatch compares in constant time, whatever the lengths.
+func passwordsMatch(want, got string) bool {
+	w, g := sha256.Sum256([]byte(want)), sha256.Sum256([]byte(got))
+	return subtle.ConstantTimeCompare(w[:], g[:]) == 1
+}
+
+// loadUsers reads users from a file of "name:password" lines, or "admin
+// name:password" for admins. Blank lines and lines starting with # are
+// ignored.
+func loadUsers(path string) ([]User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var users []User
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rest, admin := strings.CutPrefix(line, "admin ")
+		name, password, ok := strings.Cut(strings.TrimSpace(rest), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected name:password", path, n)
+		}
+		users = append(users, User{name, password, admin})
+	}
+	return users, scanner.Err()
+}
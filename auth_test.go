@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func selfSignedCert() (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assertEq(err, nil, "key")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assertEq(err, nil, "certificate")
+	cert, err := x509.ParseCertificate(der)
+	assertEq(err, nil, "parse certificate")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestServer_TLSAndAuth(t *testing.T) {
+	cert, pool := selfSignedCert()
+	database := newDatabase()
+	srv := NewServer(&database)
+	srv.UseTLS(&tls.Config{Certificates: []tls.Certificate{cert}})
+	srv.RequireAuth([]User{{Name: "alice", Password: "s3cret", Admin: true}})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assertEq(err, nil, "listen")
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: pool})
+	assertEq(err, nil, "TLS dial")
+	c := &testClient{conn, bufio.NewReader(conn)}
+
+	assertEq(c.do("get x"), "ERR authentication required", "command before auth")
+	assertEq(c.do("auth alice wrong"), "ERR invalid user name or password", "wrong password")
+	assertEq(c.do("auth mallory s3cret"), "ERR invalid user name or password", "unknown user")
+	assertEq(c.do("auth alice s3cret"), `OK "alice"`, "auth")
+	assertEq(c.do("set x 1"), `OK "1"`, "command after auth")
+
+	// A plaintext client gets nowhere.
+	plain := dial(ln.Addr().String())
+	plain.send("get x")
+	plain.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, _ := plain.r.ReadString('\n')
+	assert(line != `OK "1"`+"\n", "plaintext refused")
+}
+
+func TestRESP_auth(t *testing.T) {
+	database := newDatabase()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assertEq(err, nil, "listen")
+	srv := NewRESPServer(&database)
+	srv.RequireAuth([]User{{Name: "default", Password: "pw", Admin: true}})
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	c := dial(ln.Addr().String())
+	assertEq(respCall(c, "GET", "x"), "-ERR authentication required", "before auth")
+	assertEq(respCall(c, "MULTI"), "-ERR authentication required", "multi before auth")
+	assertEq(respCall(c, "AUTH", "pw"), "+OK", "auth as the default user")
+	assertEq(respCall(c, "GET", "x"), "$-1", "after auth")
+}
+
+func TestConnection_authNotEnabled(t *testing.T) {
+	database := newDatabase()
+	_, err := database.newConnection().execCommand("auth", []string{"alice", "pw"})
+	assertEq(err.Error(), "auth: authentication is not enabled", "auth without users")
+}
+
+func TestLoadUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	os.WriteFile(path, []byte("# admins\nadmin alice:pa:ss\n\nbob:hunter2\n"), 0o600)
+	users, err := loadUsers(path)
+	assertEq(err, nil, "load")
+	assertEq(len(users), 2, "users")
+	assertEq(users[0], User{"alice", "pa:ss", true}, "admin with a colon in the password")
+	assertEq(users[1], User{"bob", "hunter2", false}, "user")
+
+	os.WriteFile(path, []byte("alice\n"), 0o600)
+	_, err = loadUsers(path)
+	assertEq(err.Error(), path+":1: expected name:password", "malformed line")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+Requiring an explicit begin for every statement is tedious, and no real
+database does it. In autocommit mode (the default for new connections) a
+statement issued outside a transaction runs in a transaction of its own at
+the default isolation level: begun just before it, and committed right
+after it, or aborted if it failed.
+
+Single-key writes go through the write batcher, so a server full of
+autocommitting clients gets its small writes coalesced.
+*/
+
+// Commands that read or write keys, as opposed to those that manage
+// transactions or the database itself.
+var statementCommands = map[string]bool{
+	"get":      true,
+	"set":      true,
+	"delete":   true,
+	"meta":     true,
+	"scan":     true,
+	"keys":     true,
+	"call":     true,
+	"mget":     true,
+	"mset":     true,
+	"mdel":     true,
+	"exists":   true,
+	"type":     true,
+	"lookup":   true,
+	"jget":     true,
+	"jset":     true,
+	"lpush":    true,
+	"rpush":    true,
+	"lpop":     true,
+	"lrange":   true,
+	"hset":     true,
+	"hget":     true,
+	"hdel":     true,
+	"hgetall":  true,
+	"sadd":     true,
+	"srem":     true,
+	"smembers": true,
+	"zadd":     true,
+	"zrange":   true,
+	"dbsize":   true,
+	"incr":     true,
+	"decr":     true,
+	"cincr":    true,
+	"cdecr":    true,
+	"setnx":    true,
+	"getdel":   true,
+	"gets":     true,
+	"cas":      true,
+	"rename":   true,
+	"copy":     true,
+	"expire":   true,
+	"ttl":      true,
+	"grant":    true,
+	"revoke":   true,
+}
+
+func (c *Connection) autocommitStatement(ctx context.Context, command string, args []string) (Result, error) {
+	if !c.autocommit {
+		return Result{}, ErrNoTransaction
+	}
+
+	if command == "set" || command == "delete" {
+		w := &batchedWrite{key: args[0], delete: command == "delete", ctx: ctx}
+		if command == "set" {
+			w.value = args[1]
+		}
+		err := c.db.batchWrite(w)
+		res := Result{Keys: args[:1], TxId: w.txId}
+		if err == nil && command == "set" {
+			res.Value = w.value
+		}
+		return res, err
+	}
+
+	tx, err := c.db.beginAt(ctx, c.isolation())
+	if err != nil {
+		return Result{}, err
+	}
+
+	c.tx = tx
+	res, err := c.runStatement(command, args)
+	c.tx = nil
+
+	if err != nil {
+		tx.Abort()
+		return res, err
+	}
+	return res, tx.Commit()
+}
+
+// autocommit [on|off]
+func (c *Connection) setAutocommit(args []string) (Result, error) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "on":
+			c.autocommit = true
+		case "off":
+			c.autocommit = false
+		default:
+			return Result{}, fmt.Errorf("autocommit must be on or off, not %q", args[0])
+		}
+	}
+
+	if c.autocommit {
+		return Result{Value: "on"}, nil
+	}
+	return Result{Value: "off"}, nil
+}
+
+// atomically runs fn in the connection's transaction, or in one of its
+// own if there is none, for frontend commands made of several of ours.
+func (c *Connection) atomically(fn func() error) error {
+	if c.tx != nil {
+		return fn()
+	}
+
+	if _, err := c.execCommand("begin", nil); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if c.tx != nil {
+			c.execCommand("abort", nil)
+		}
+		return err
+	}
+	_, err := c.execCommand("commit", nil)
+	return err
+}
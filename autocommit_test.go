@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAutocommit(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+
+	// Without a transaction, each statement commits on its own.
+	c1.mustExecCommand("set", []string{"x", "hey"})
+	res := c2.mustExecCommand("get", []string{"x"})
+	assertEq(res, "hey", "c2 get x")
+
+	res = c1.mustExecCommand("get", []string{"x"})
+	assertEq(res, "hey", "c1 get x")
+
+	c1.mustExecCommand("delete", []string{"x"})
+	_, err := c2.execCommand("get", []string{"x"})
+	assert(errors.Is(err, ErrKeyNotFound), "c2 sees x deleted")
+
+	// A failed statement leaves nothing behind.
+	result, err := c1.execCommand("delete", []string{"x"})
+	assertEq(err.Error(), "cannot delete key that does not exist", "c1 delete x")
+	assert(result.TxId != 0, "delete ran in a transaction")
+	assertEq(c1.tx, nil, "c1 has no transaction")
+
+	assertEq(c1.mustExecCommand("autocommit", []string{"off"}), "off", "autocommit off")
+	_, err = c1.execCommand("get", []string{"x"})
+	assertEq(err, ErrNoTransaction, "c1 get x without autocommit")
+}
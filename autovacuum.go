@@ -0,0 +1,51 @@
+package main
+
+import "time"
+
+/*
+Auto-vacuum keeps the store from growing without bound in a long-running process. Every Interval it vacuums the
+next BatchSize keys, picking up where the previous batch left off, and
+starts a new pass (with a new horizon) once the last one has covered the
+whole store. Small batches keep the time other transactions spend waiting
+on the lock short.
+*/
+
+type AutoVacuumOptions struct {
+	Interval time.Duration
+	// Keys to vacuum per interval. Zero means the whole store.
+	BatchSize int
+}
+
+// StartAutoVacuum vacuums in the background until the returned function
+// is called or the database shuts down.
+func (d *Database) StartAutoVacuum(opts AutoVacuumOptions) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+
+		var pass *vacuumPass
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+
+			d.mu.Lock()
+			if d.shutdown {
+				d.mu.Unlock()
+				return
+			}
+			if pass == nil {
+				pass = d.newVacuumPass()
+			}
+			if d.vacuumStep(pass, opts.BatchSize) {
+				d.finishVacuum(pass)
+				pass = nil
+			}
+			d.mu.Unlock()
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVacuumBatches(t *testing.T) {
+	database := newDatabase()
+
+	c := database.newConnection()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		c.mustExecCommand("set", []string{key, "old"})
+		c.mustExecCommand("set", []string{key, "new"})
+	}
+
+	// A rolled back delete leaves its id behind until vacuumed.
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("delete", []string{"a"})
+	c.mustExecCommand("abort", nil)
+	assert(database.aborted.Contains(11), "aborted delete remembered")
+
+	database.mu.Lock()
+	p := database.newVacuumPass()
+	assert(!database.vacuumStep(p, 2), "first batch")
+	assertEq(p.removed, 2, "removed after one batch")
+	assert(!database.vacuumStep(p, 2), "second batch")
+	assert(database.vacuumStep(p, 2), "last batch")
+	assertEq(p.removed, 5, "removed after the pass")
+	database.finishVacuum(p)
+	database.mu.Unlock()
+
+	assertEq(database.aborted.Len(), 0, "aborted transactions forgotten")
+	assertEq(database.versions("a")[0].txEndId, uint64(0), "rolled back delete cleared")
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		assertEq(c.mustExecCommand("get", []string{key}), "new", "value after vacuum")
+	}
+}
+
+func TestAutoVacuum(t *testing.T) {
+	database := newDatabase()
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "one"})
+	c.mustExecCommand("set", []string{"x", "two"})
+
+	stop := database.StartAutoVacuum(AutoVacuumOptions{Interval: time.Millisecond, BatchSize: 1})
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		database.mu.Lock()
+		versions := len(database.versions("x"))
+		database.mu.Unlock()
+		if versions == 1 {
+			break
+		}
+		assert(time.Now().Before(deadline), "auto-vacuum ran")
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+A backup is a self-contained file holding every key visible at a single
+snapshot, with its value and expiry. Unlike a checkpoint it carries no
+history and no transaction ids, so it can be restored into any empty
+database. It is a header frame (see format.go) with a magic string and
+the number of keys, then one frame per key:
+
+	key, value, expiresAt[, type]
+
+where the type (see types.go) is left out for strings.
+
+The whole file is checked before anything is restored, so a damaged or
+truncated backup is refused rather than half loaded.
+*/
+
+const backupMagic = "MVCCBAK"
+
+var ErrCorruptBackup = errors.New("corrupt backup")
+
+// Backup writes the keys visible to a new snapshot to w.
+func (d *Database) Backup(w io.Writer) error {
+	entries, err := d.snapshotEntries()
+	if err != nil {
+		return err
+	}
+	return encodeBackup(w, entries)
+}
+
+func encodeBackup(w io.Writer, entries []backupEntry) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(appendFrame(nil, frameBody(nil).string(backupMagic).uvarint(uint64(len(entries)))))
+	for _, e := range entries {
+		body := frameBody(nil).string(e.key).string(e.value).uint32(e.expiresAt)
+		if e.kind != StringType {
+			body = append(body, byte(e.kind))
+		}
+		bw.Write(appendFrame(nil, body))
+	}
+	return bw.Flush()
+}
+
+// Restore loads a backup into the database, which must be empty. The
+// restored keys are written by a single transaction.
+func (d *Database) Restore(r io.Reader) error {
+	entries, err := decodeBackup(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.shutdown {
+		return ErrDatabaseShutdown
+	}
+	if d.store.Len() > 0 {
+		return errors.New("restore: database is not empty")
+	}
+
+	t := d.newTransaction(context.Background())
+	for _, e := range entries {
+		t.write(e.key, e.value, e.kind, e.expiresAt)
+	}
+	return d.completeTransaction(t, CommittedTransaction)
+}
+
+type backupEntry struct {
+	// The value as stored, of type kind.
+	key, value string
+	expiresAt  uint32
+	kind       ValueType
+}
+
+// snapshotEntries returns the keys visible to a new snapshot, in order.
+func (d *Database) snapshotEntries() ([]backupEntry, error) {
+	d.mu.Lock()
+	if d.shutdown {
+		d.mu.Unlock()
+		return nil, ErrDatabaseShutdown
+	}
+	snapshot := d.newTransaction(context.Background())
+	snapshot.isolation = RepeatableReadIsolation
+	f := d.freeze()
+	d.mu.Unlock()
+
+	// Scanning a frozen store lets writers carry on (see frozen.go).
+	var entries []backupEntry
+	var readErr error
+	d.scanFrozen(f, snapshot, func(key string, _ []Value, value *Value) {
+		if value != nil && !isInternalKey(key) && readErr == nil {
+			raw, err := d.readRaw(value)
+			readErr = err
+			entries = append(entries, backupEntry{key, raw, value.expiresAt, value.data.kind})
+		}
+	}, nil)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.completeTransaction(snapshot, AbortedTransaction)
+	if readErr != nil {
+		return nil, readErr
+	}
+	return entries, nil
+}
+
+func decodeBackup(r io.Reader) ([]backupEntry, error) {
+	corrupt := func(err error) error {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("%w: %w", ErrCorruptBackup, err)
+	}
+
+	body, _, err := readFrame(r)
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	br := frameReader{b: body}
+	if br.string() != backupMagic {
+		return nil, fmt.Errorf("%w: not a backup", ErrCorruptBackup)
+	}
+	count := br.uvarint()
+	if err := br.done(); err != nil {
+		return nil, corrupt(err)
+	}
+
+	var entries []backupEntry
+	for range count {
+		body, _, err := readFrame(r)
+		if err != nil {
+			return nil, corrupt(err)
+		}
+		br := frameReader{b: body}
+		e := backupEntry{key: br.string(), value: br.string(), expiresAt: br.uint32()}
+		if len(br.b) > 0 {
+			e.kind = ValueType(br.byte())
+		}
+		if err := br.done(); err != nil {
+			return nil, corrupt(err)
+		}
+		entries = append(entries, e)
+	}
+	if _, _, err := readFrame(r); err != io.EOF {
+		return nil, fmt.Errorf("%w: trailing data", ErrCorruptBackup)
+	}
+	return entries, nil
+}
+
+// backup path
+func (c *Connection) backup(args []string) (Result, error) {
+	if len(args) != 1 {
+		return Result{}, fmt.Errorf("backup: expected a path")
+	}
+
+	f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return Result{}, fmt.Errorf("backup: %w", err)
+	}
+	err = c.db.Backup(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(args[0])
+		return Result{}, fmt.Errorf("backup: %w", err)
+	}
+	return Result{Value: args[0]}, nil
+}
+
+// restore path
+func (c *Connection) restore(args []string) (Result, error) {
+	if len(args) != 1 {
+		return Result{}, fmt.Errorf("restore: expected a path")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return Result{}, fmt.Errorf("restore: %w", err)
+	}
+	defer f.Close()
+
+	if err := c.db.Restore(f); err != nil {
+		return Result{}, err
+	}
+	return Result{Value: args[0]}, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupRestore(t *testing.T) {
+	now := time.Now()
+	source := newDatabase()
+	source.now = func() time.Time { return now }
+	c := source.newConnection()
+	c.mustExecCommand("set", []string{"a", "1"})
+	c.mustExecCommand("set", []string{"b", "gone"})
+	c.mustExecCommand("delete", []string{"b"})
+	c.mustExecCommand("set", []string{"c", "expires"})
+	c.mustExecCommand("expire", []string{"c", "100"})
+
+	// Not committed, so not backed up.
+	open := source.newConnection()
+	open.mustExecCommand("begin", nil)
+	open.mustExecCommand("set", []string{"d", "uncommitted"})
+
+	path := filepath.Join(t.TempDir(), "backup")
+	assertEq(c.mustExecCommand("backup", []string{path}), path, "backup")
+	_, err := c.execCommand("backup", []string{path})
+	assert(err != nil, "backup refuses to overwrite")
+
+	target := newDatabase()
+	target.now = source.now
+	c2 := target.newConnection()
+	assertEq(c2.mustExecCommand("restore", []string{path}), path, "restore")
+	assertEq(c2.mustExecCommand("keys", []string{"*"}), "a\nc", "restored keys")
+	assertEq(c2.mustExecCommand("get", []string{"c"}), "expires", "restored value")
+	assertEq(c2.mustExecCommand("ttl", []string{"c"}), c.mustExecCommand("ttl", []string{"c"}), "restored expiry")
+
+	_, err = c2.execCommand("restore", []string{path})
+	assertEq(err.Error(), "restore: database is not empty", "restore into a full database")
+}
+
+func TestRestore_corrupt(t *testing.T) {
+	source := newDatabase()
+	c := source.newConnection()
+	c.mustExecCommand("set", []string{"a", "1"})
+
+	var buf bytes.Buffer
+	assertEq(source.Backup(&buf), nil, "backup")
+	data := buf.Bytes()
+	data[len(data)/2] ^= 0xff
+
+	target := newDatabase()
+	err := target.Restore(bytes.NewReader(data))
+	assert(errors.Is(err, ErrCorruptBackup), "corrupt backup refused")
+	assertEq(target.store.Len(), 0, "nothing restored")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+A lot of traffic is tiny auto-commit writes: begin, set one key, commit.
+Done one at a time, each of those pays for its own trip through the
+database lock and its own sync of the write-ahead log. When many of them
+arrive concurrently we coalesce them instead.
+
+The first writer to arrive becomes the leader. It takes everything queued
+so far, applies it under a single acquisition of the database lock, and
+hands every writer its own result. The commit records of the whole batch
+are appended to the log and synced once, and no writer hears its write
+committed before that sync. Anything that queued up while the leader was
+busy gets picked up in its next round, so no writer waits for more than
+one batch ahead of it.
+
+Each write still runs in its own transaction, so one failing (a delete of
+a missing key, a conflict with a long-running transaction) doesn't take
+the rest of its batch down with it.
+*/
+
+type batchedWrite struct {
+	key    string
+	value  string
+	delete bool
+	done   chan error
+	// Where the write's transaction is traced from.
+	ctx context.Context
+
+	// The transaction the write ran in, set before done is signalled.
+	txId uint64
+}
+
+type writeBatcher struct {
+	mu      sync.Mutex
+	pending []*batchedWrite
+	leading bool
+}
+
+// Set writes key in its own single-statement transaction.
+func (d *Database) Set(key, value string) error {
+	return d.batchWrite(&batchedWrite{key: key, value: value, ctx: context.Background()})
+}
+
+// Delete removes key in its own single-statement transaction.
+func (d *Database) Delete(key string) error {
+	return d.batchWrite(&batchedWrite{key: key, delete: true, ctx: context.Background()})
+}
+
+func (d *Database) batchWrite(w *batchedWrite) error {
+	if !w.delete {
+		defer d.metrics.observe("set", time.Now())
+	}
+	w.done = make(chan error, 1)
+
+	b := &d.batcher
+	b.mu.Lock()
+	b.pending = append(b.pending, w)
+	if b.leading {
+		b.mu.Unlock()
+		return <-w.done
+	}
+
+	b.leading = true
+	for len(b.pending) > 0 {
+		batch := b.pending
+		b.pending = nil
+		b.mu.Unlock()
+
+		d.applyBatch(batch)
+
+		b.mu.Lock()
+	}
+	b.leading = false
+	b.mu.Unlock()
+
+	return <-w.done
+}
+
+func (d *Database) applyBatch(batch []*batchedWrite) {
+	// The writes' hooks (a slow transaction's report, say) run once the
+	// lock is released, as Commit's do.
+	var hooks []func()
+	d.mu.Lock()
+	defer func() {
+		d.mu.Unlock()
+		runHooks(hooks)
+	}()
+
+	d.logger.Debug("applying write batch", "writes", len(batch))
+
+	// An index write can touch any key, and a unique index is only
+	// checked against what has committed, so with indexes each write
+	// syncs by itself.
+	if d.wal != nil && len(d.allIndexes()) == 0 {
+		d.group = &commitGroup{}
+	}
+
+	for _, w := range batch {
+		if d.shutdown {
+			w.done <- ErrDatabaseShutdown
+			continue
+		}
+		// A write sees the earlier ones in its batch only once they have
+		// committed.
+		if d.group.wrote(w.key) {
+			hooks = append(hooks, d.syncGroup()...)
+			d.group = &commitGroup{}
+		}
+
+		t := d.newTransaction(w.ctx)
+		w.txId = t.id
+		if w.delete {
+			if err := t.delete(w.key); err != nil {
+				d.completeTransaction(t, AbortedTransaction)
+				hooks = append(hooks, d.recycle(t)...)
+				w.done <- err
+				continue
+			}
+		} else {
+			t.set(w.key, w.value)
+		}
+
+		err := d.completeTransaction(t, CommittedTransaction)
+		if err == nil && d.group.holds(t) {
+			d.group.writes = append(d.group.writes, w)
+			continue
+		}
+		w.done <- err
+		hooks = append(hooks, d.recycle(t)...)
+	}
+	hooks = append(hooks, d.syncGroup()...)
+}
+
+// A commitGroup is the writes of a batch whose commit records are logged
+// but not yet synced. They finish committing together once they are, or
+// are all aborted if the sync fails.
+type commitGroup struct {
+	txs     []*Transaction
+	commits []WALRecord
+	writes  []*batchedWrite
+}
+
+func (g *commitGroup) add(t *Transaction, commit WALRecord) {
+	g.txs = append(g.txs, t)
+	g.commits = append(g.commits, commit)
+}
+
+// holds reports whether t is the group's latest commit.
+func (g *commitGroup) holds(t *Transaction) bool {
+	return g != nil && len(g.txs) > 0 && g.txs[len(g.txs)-1] == t
+}
+
+func (g *commitGroup) wrote(key string) bool {
+	if g == nil {
+		return false
+	}
+	for _, t := range g.txs {
+		if t.wrote(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncGroup syncs the log once for every commit in the group, finishes
+// or aborts them, and hands each writer its result. It returns their
+// hooks, to run once the caller, who must hold the lock, releases it.
+func (d *Database) syncGroup() []func() {
+	g := d.group
+	d.group = nil
+	if g == nil || len(g.txs) == 0 {
+		return nil
+	}
+
+	err := d.wal.Sync()
+	if err != nil {
+		err = fmt.Errorf("write-ahead log: %w", err)
+	}
+	var hooks []func()
+	for i, t := range g.txs {
+		if err != nil {
+			d.completeTransaction(t, AbortedTransaction)
+		} else {
+			d.finishCompletion(t, CommittedTransaction)
+			// Followers only hear of the commit once it is durable.
+			d.ship(g.commits[i])
+		}
+		hooks = append(hooks, d.recycle(t)...)
+		g.writes[i].done <- err
+	}
+	return hooks
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBatchedWrites(t *testing.T) {
+	database := newDatabase()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 100)
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("k%d", i%50)
+			if i < 50 {
+				errs[i] = database.Set(key, "v")
+			} else {
+				// Half of these race with the sets above; the ones that
+				// lose must still get their own error back.
+				errs[i] = database.Delete(key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs[:50] {
+		assertEq(err, nil, fmt.Sprintf("set k%d", i))
+	}
+	for _, err := range errs[50:] {
+		if err != nil {
+			assertEq(err.Error(), "cannot delete key that does not exist", "delete")
+		}
+	}
+
+	err := database.Delete("missing")
+	assertEq(err.Error(), "cannot delete key that does not exist", "delete missing")
+
+	database.Set("x", "hey")
+	tx, _ := database.Begin()
+	res, _ := tx.Get("x")
+	assertEq(res, "hey", "get x")
+}
+
+func TestBatchedWrites_syncOnce(t *testing.T) {
+	syncs := &countedSyncs{}
+	database, err := NewDatabase(t.TempDir(), WithFaults(syncs))
+	assertEq(err, nil, "open")
+	defer database.Close()
+
+	write := func(key string, delete bool) *batchedWrite {
+		return &batchedWrite{key: key, value: "v", delete: delete, done: make(chan error, 1), ctx: context.Background()}
+	}
+	batch := []*batchedWrite{write("a", false), write("b", false), write("c", false), write("missing", true)}
+	database.applyBatch(batch)
+	for _, w := range batch[:3] {
+		assertEq(<-w.done, nil, "set "+w.key)
+	}
+	assertEq((<-batch[3].done).Error(), "cannot delete key that does not exist", "delete missing")
+	assertEq(syncs.count("wal"), 1, "one sync for the batch")
+
+	// A write of a key written earlier in its batch waits for that one to
+	// be synced.
+	batch = []*batchedWrite{write("a", false), write("b", false), write("a", true)}
+	database.applyBatch(batch)
+	for _, w := range batch {
+		assertEq(<-w.done, nil, w.key)
+	}
+	assertEq(syncs.count("wal"), 3, "a sync before the rewrite")
+	_, err = database.newConnection().execCommand("get", []string{"a"})
+	assertEq(err, ErrKeyNotFound, "deleted")
+}
+
+func TestBatchedWrites_failedSync(t *testing.T) {
+	faults := &Faults{}
+	database, err := NewDatabase(t.TempDir(), WithFaults(faults))
+	assertEq(err, nil, "open")
+	defer database.Close()
+
+	batch := []*batchedWrite{
+		{key: "a", value: "v", done: make(chan error, 1), ctx: context.Background()},
+		{key: "b", value: "v", done: make(chan error, 1), ctx: context.Background()},
+	}
+	faults.FailNextSync("wal")
+	database.applyBatch(batch)
+	c := database.newConnection()
+	for _, w := range batch {
+		assert(errors.Is(<-w.done, ErrInjectedFault), "set "+w.key)
+		_, err := c.execCommand("get", []string{w.key})
+		assertEq(err, ErrKeyNotFound, "aborted "+w.key)
+	}
+}
+
+func BenchmarkAutocommitSet(b *testing.B) {
+	var n atomic.Uint64
+	key := func() string {
+		return fmt.Sprintf("k%d", n.Add(1)%1024)
+	}
+
+	b.Run("transaction", func(b *testing.B) {
+		database := newDatabase()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				tx, _ := database.Begin()
+				tx.Set(key(), "hey")
+				tx.Commit()
+			}
+		})
+	})
+
+	b.Run("batched", func(b *testing.B) {
+		database := newDatabase()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				database.Set(key(), "hey")
+			}
+		})
+	})
+}
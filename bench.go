@@ -0,0 +1,255 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+The bench subcommand measures the database under a generated workload,
+in the manner of YCSB: clients run transactions back to back, each of a
+few operations on keys drawn from a fixed keyspace, each operation a read
+or else a write of a value of a given size. Keys are drawn uniformly, or
+from a Zipfian distribution, in which a few keys take most of the
+operations, as in most real workloads, and which is what makes
+transactions conflict. YCSB's workload A is half reads, B 95% reads, and
+C all reads.
+
+The same workload runs at each isolation level asked for, each on a
+fresh database in memory, and for each it reports the rate of
+transactions, their latency, and how many aborted for conflicts.
+Aborted transactions are not retried.
+*/
+
+// Workload describes a generated workload.
+type Workload struct {
+	Clients int
+	// How many keys there are, and how they are drawn: "uniform" or
+	// "zipfian".
+	Keys         int
+	Distribution string
+	// Operations per transaction, the fraction of them that are reads,
+	// and how long the values written are.
+	Ops       int
+	Reads     float64
+	ValueSize int
+	Seed      uint64
+}
+
+// BenchResult is what a run of a workload measured.
+type BenchResult struct {
+	Isolation IsolationLevel
+	Elapsed   time.Duration
+	Commits   int
+	Aborts    int
+	// Transaction latencies, committed or not, at the 50th, 95th and
+	// 99th percentiles, and the longest.
+	P50, P95, P99, Max time.Duration
+}
+
+// Throughput returns transactions committed per second.
+func (r BenchResult) Throughput() float64 {
+	return float64(r.Commits) / r.Elapsed.Seconds()
+}
+
+// AbortRate returns the fraction of transactions that aborted.
+func (r BenchResult) AbortRate() float64 {
+	if r.Commits+r.Aborts == 0 {
+		return 0
+	}
+	return float64(r.Aborts) / float64(r.Commits+r.Aborts)
+}
+
+func (w *Workload) defaults() {
+	w.Clients = cmp.Or(w.Clients, 1)
+	w.Keys = cmp.Or(w.Keys, 1000)
+	w.Distribution = cmp.Or(w.Distribution, "uniform")
+	w.Ops = cmp.Or(w.Ops, 1)
+}
+
+// load writes every key of the workload to d.
+func (w *Workload) load(d *Database) error {
+	value := strings.Repeat("x", w.ValueSize)
+	for start := 0; start < w.Keys; start += 1000 {
+		tx, err := d.Begin()
+		if err != nil {
+			return err
+		}
+		for i := start; i < min(start+1000, w.Keys); i++ {
+			tx.Set(benchKey(i), value)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func benchKey(i int) string {
+	return "key" + strconv.Itoa(i)
+}
+
+// benchClient is one client's generator of transactions.
+type benchClient struct {
+	w     *Workload
+	rng   *rand.Rand
+	zipf  *rand.Zipf
+	value []byte
+}
+
+func (w *Workload) client(i int) *benchClient {
+	c := &benchClient{w: w, rng: rand.New(rand.NewPCG(w.Seed, uint64(i))), value: make([]byte, w.ValueSize)}
+	if w.Distribution == "zipfian" {
+		c.zipf = rand.NewZipf(c.rng, 1.1, 1, uint64(w.Keys-1))
+	}
+	return c
+}
+
+func (c *benchClient) key() string {
+	if c.zipf != nil {
+		return benchKey(int(c.zipf.Uint64()))
+	}
+	return benchKey(c.rng.IntN(c.w.Keys))
+}
+
+// run runs one transaction on d, reporting whether it committed. Errors
+// other than conflicts are returned.
+func (c *benchClient) run(d *Database) (bool, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return false, err
+	}
+	for range c.w.Ops {
+		key := c.key()
+		if c.rng.Float64() < c.w.Reads {
+			_, err = tx.Get(key)
+		} else {
+			// A fresh string each time, as if parsed off the wire.
+			if len(c.value) > 0 {
+				c.value[0] = byte('a' + c.rng.IntN(26))
+			}
+			err = tx.Set(key, string(c.value))
+		}
+		if err != nil {
+			tx.Abort()
+			return false, err
+		}
+	}
+	var conflict *ConflictError
+	if err := tx.Commit(); errors.As(err, &conflict) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RunWorkload runs w at level for duration, on a fresh database in
+// memory.
+func RunWorkload(w Workload, level IsolationLevel, duration time.Duration) (BenchResult, error) {
+	w.defaults()
+	if w.Distribution != "uniform" && w.Distribution != "zipfian" {
+		return BenchResult{}, fmt.Errorf("bench: unknown distribution %q", w.Distribution)
+	}
+	database := newDatabase()
+	database.defaultIsolation = level
+	if err := w.load(&database); err != nil {
+		return BenchResult{}, err
+	}
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		result    = BenchResult{Isolation: level}
+		errs      []error
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	deadline := start.Add(duration)
+	for i := range w.Clients {
+		c := w.client(i)
+		wg.Go(func() {
+			var mine []time.Duration
+			commits, aborts := 0, 0
+			var err error
+			for err == nil && time.Now().Before(deadline) {
+				began := time.Now()
+				var committed bool
+				committed, err = c.run(&database)
+				mine = append(mine, time.Since(began))
+				if committed {
+					commits++
+				} else if err == nil {
+					aborts++
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			latencies = append(latencies, mine...)
+			result.Commits += commits
+			result.Aborts += aborts
+			errs = append(errs, err)
+		})
+	}
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+	if err := errors.Join(errs...); err != nil {
+		return result, err
+	}
+
+	slices.Sort(latencies)
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[min(int(p*float64(len(latencies))), len(latencies)-1)]
+	}
+	result.P50, result.P95, result.P99 = percentile(0.50), percentile(0.95), percentile(0.99)
+	result.Max = percentile(1)
+	return result, nil
+}
+
+// runBench runs the bench subcommand with args, writing a line for each
+// isolation level to out.
+func runBench(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	var w Workload
+	fs.IntVar(&w.Clients, "clients", 8, "how many clients run transactions concurrently")
+	fs.IntVar(&w.Keys, "keys", 10000, "how many keys there are")
+	fs.StringVar(&w.Distribution, "distribution", "zipfian", "how keys are drawn: uniform or zipfian")
+	fs.IntVar(&w.Ops, "ops", 4, "operations per transaction")
+	fs.Float64Var(&w.Reads, "reads", 0.5, "the fraction of operations that are reads: 0.5 for YCSB's workload A, 0.95 for B, 1 for C")
+	fs.IntVar(&w.ValueSize, "value-size", 100, "bytes per value written")
+	fs.Uint64Var(&w.Seed, "seed", 1, "seed for the clients' choices")
+	duration := fs.Duration("duration", 5*time.Second, "how long to run at each isolation level")
+	levels := fs.String("isolation", "read-committed,repeatable-read,snapshot,serializable", "comma-separated isolation levels to run at")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%-17s %12s %10s %10s %10s %10s %8s\n", "isolation", "commits/s", "p50", "p95", "p99", "max", "aborts")
+	for name := range strings.SplitSeq(*levels, ",") {
+		level, err := parseIsolation(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		r, err := RunWorkload(w, level, *duration)
+		if err != nil {
+			return err
+		}
+		round := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+		fmt.Fprintf(out, "%-17s %12.0f %10s %10s %10s %10s %7.2f%%\n",
+			r.Isolation, r.Throughput(), round(r.P50), round(r.P95), round(r.P99), round(r.Max), 100*r.AbortRate())
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunWorkload(t *testing.T) {
+	w := Workload{Clients: 4, Keys: 10, Distribution: "zipfian", Ops: 4, Reads: 0.5, ValueSize: 8}
+	snapshot, err := RunWorkload(w, SnapshotIsolation, 50*time.Millisecond)
+	assertEq(err, nil, "run")
+	assert(snapshot.Commits > 0, "committed")
+	assert(snapshot.P50 <= snapshot.P99 && snapshot.P99 <= snapshot.Max, "percentiles in order")
+
+	// Nothing conflicts when nothing writes.
+	w.Reads = 1
+	readOnly, err := RunWorkload(w, SerializableIsolation, 50*time.Millisecond)
+	assertEq(err, nil, "run")
+	assertEq(readOnly.Aborts, 0, "no aborts")
+	assertEq(readOnly.AbortRate(), 0.0, "abort rate")
+
+	_, err = RunWorkload(Workload{Distribution: "normal"}, SnapshotIsolation, time.Millisecond)
+	assert(err != nil, "unknown distribution")
+}
+
+func TestRunWorkload_conflicts(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	w := Workload{Keys: 1, Ops: 1}
+	w.defaults()
+	assertEq(w.load(&database), nil, "load")
+
+	// A transaction of higher priority holding the only key makes any
+	// transaction writing it yield, however the two are scheduled.
+	hot, _ := database.Begin()
+	hot.SetPriority(HighPriority)
+	hot.Set(benchKey(0), "hot")
+	committed, err := w.client(0).run(&database)
+	assertEq(err, nil, "a conflict isn't an error")
+	assert(!committed, "aborted")
+
+	hot.Abort()
+	committed, err = w.client(0).run(&database)
+	assertEq(err, nil, "run")
+	assert(committed, "committed once the key is free")
+}
+
+func TestRunBench(t *testing.T) {
+	var out bytes.Buffer
+	err := runBench([]string{"-duration", "10ms", "-keys", "100", "-isolation", "read-committed,serializable"}, &out)
+	assertEq(err, nil, "bench")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assertEq(len(lines), 3, "header and a line per level")
+	assert(strings.HasPrefix(lines[0], "isolation "), "header")
+	assert(strings.HasPrefix(lines[1], "read-committed "), "read committed")
+	assert(strings.HasPrefix(lines[2], "serializable "), "serializable")
+
+	assert(runBench([]string{"-isolation", "strict"}, &out) != nil, "unknown level")
+}
+
+// BenchmarkWorkload runs YCSB's workloads A, B and C at each isolation
+// level, reporting the fraction of transactions that aborted.
+func BenchmarkWorkload(b *testing.B) {
+	for _, mix := range []struct {
+		name  string
+		reads float64
+	}{{"A", 0.5}, {"B", 0.95}, {"C", 1}} {
+		for level := ReadCommitedIsolation; level <= SerializableIsolation; level++ {
+			b.Run(fmt.Sprintf("%s/%s", mix.name, level), func(b *testing.B) {
+				benchmarkWorkload(b, Workload{Keys: 10000, Distribution: "zipfian", Ops: 4, Reads: mix.reads, ValueSize: 100}, level)
+			})
+		}
+	}
+}
+
+// benchmarkWorkload runs w's transactions at level in parallel, one per
+// iteration.
+func benchmarkWorkload(b *testing.B, w Workload, level IsolationLevel) {
+	w.defaults()
+	database := newDatabase()
+	database.defaultIsolation = level
+	if err := w.load(&database); err != nil {
+		b.Fatal(err)
+	}
+
+	var clients atomic.Int64
+	var aborts atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		c := w.client(int(clients.Add(1)))
+		for pb.Next() {
+			committed, err := c.run(&database)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if !committed {
+				aborts.Add(1)
+			}
+		}
+	})
+	b.ReportMetric(float64(aborts.Load())/float64(b.N), "aborts/op")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"hash/maphash"
+	"math"
+)
+
+/*
+A get of a key that isn't there still has to look for it in the store,
+which for a large one means a walk down a deep tree, missing the cache at
+every level. With a bloom filter, most such gets stop before they start:
+the filter is a few bits per key, and if any of the bits a key hashes to
+is unset, the key was never written. If they are all set the key probably
+was, and the get looks in the store as usual, so the filter only ever
+costs a false positive, never a wrong answer.
+
+Each database keeps its own filter, so a cluster keeps one per shard. It
+is sized for twice the keys in the store and a chosen false-positive
+rate, and rebuilt from the store once more keys than that have been
+added, or once vacuum has removed half as many, whose bits, which can't
+be unset, make false positives more likely than the rate allows. Either
+costs a walk of the store, but only after as many new keys as the store
+had, or half as many removed.
+
+The metrics count how each lookup went: absent, when the filter ruled the
+key out; present, when it let the get through and the key was there; and
+false_positive, when it let the get through for nothing.
+*/
+
+// WithBloomFilter keeps a bloom filter of the store's keys, with a false
+// positive rate of fpRate, to answer gets of keys that aren't there
+// without looking. A rate not between 0 and 1 keeps none.
+func WithBloomFilter(fpRate float64) Option {
+	return func(d *Database) {
+		d.bloomRate = fpRate
+		d.rebuildBloom()
+	}
+}
+
+// Filters are sized for at least this many keys.
+const minBloomKeys = 1024
+
+type bloomFilter struct {
+	bits []uint64
+	// How many bits each key sets.
+	hashes int
+	seed   maphash.Seed
+	// How many keys it was sized for, how many have been added, and
+	// how many of those have since been removed from the store.
+	capacity int
+	added    int
+	removed  int
+}
+
+func newBloomFilter(keys int, fpRate float64) *bloomFilter {
+	keys = max(keys, minBloomKeys)
+	// The optimal size, and number of hashes for it.
+	m := math.Ceil(-float64(keys) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	hashes := max(1, int(math.Round(m/float64(keys)*math.Ln2)))
+	return &bloomFilter{
+		bits:     make([]uint64, (int(m)+63)/64),
+		hashes:   hashes,
+		seed:     maphash.MakeSeed(),
+		capacity: keys,
+	}
+}
+
+// positions returns where in the filter key's bits start, and the step
+// between them, by double hashing.
+func (f *bloomFilter) positions(key string) (uint64, uint64) {
+	h := maphash.String(f.seed, key)
+	return h, h>>32 | h<<32 | 1
+}
+
+func (f *bloomFilter) add(key string) {
+	n := uint64(len(f.bits) * 64)
+	h, step := f.positions(key)
+	for range f.hashes {
+		bit := h % n
+		f.bits[bit/64] |= 1 << (bit % 64)
+		h += step
+	}
+	f.added++
+}
+
+// mayContain reports whether key may have been added. If not, it
+// certainly wasn't.
+func (f *bloomFilter) mayContain(key string) bool {
+	n := uint64(len(f.bits) * 64)
+	h, step := f.positions(key)
+	for range f.hashes {
+		bit := h % n
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+		h += step
+	}
+	return true
+}
+
+// stale reports whether the filter should be rebuilt.
+func (f *bloomFilter) stale() bool {
+	return f.added > f.capacity || f.removed > f.capacity/2
+}
+
+// rebuildBloom builds the filter anew from the keys in the store, if the
+// database keeps one.
+func (d *Database) rebuildBloom() {
+	if d.bloomRate <= 0 || d.bloomRate >= 1 {
+		d.bloom = nil
+		return
+	}
+	d.bloom = newBloomFilter(2*d.store.Len(), d.bloomRate)
+	iter := d.store.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		d.bloom.add(iter.Key())
+	}
+}
+
+// keyAdded notes that key has been added to the store.
+func (d *Database) keyAdded(key string) {
+	if d.bloom == nil {
+		return
+	}
+	if d.bloom.add(key); d.bloom.stale() {
+		d.rebuildBloom()
+	}
+}
+
+// keysRemoved notes that n keys have been removed from the store. The
+// filter can't forget them, but too many such keys call for a rebuild.
+func (d *Database) keysRemoved(n int) {
+	if d.bloom == nil {
+		return
+	}
+	if d.bloom.removed += n; d.bloom.stale() {
+		d.rebuildBloom()
+	}
+}
+
+// lookup returns the versions of key, for a get, consulting the filter
+// first.
+func (d *Database) lookup(key string) []Value {
+	if d.bloom == nil {
+		return d.versions(key)
+	}
+	if !d.bloom.mayContain(key) {
+		d.metrics.bloomAbsent.Inc()
+		return nil
+	}
+	versions, ok := d.store.Get(key)
+	if ok {
+		d.metrics.bloomPresent.Inc()
+	} else {
+		d.metrics.bloomFalsePositive.Inc()
+	}
+	return versions
+}
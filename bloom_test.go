@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(10000, 0.01)
+	for i := range 10000 {
+		f.add(benchKey(i))
+	}
+	for i := range 10000 {
+		assert(f.mayContain(benchKey(i)), "no false negatives")
+	}
+
+	positives := 0
+	for i := range 10000 {
+		if f.mayContain(fmt.Sprint("absent", i)) {
+			positives++
+		}
+	}
+	assert(positives < 200, fmt.Sprintf("%d false positives in 10000", positives))
+}
+
+func TestWithBloomFilter(t *testing.T) {
+	database := newDatabase()
+	database.apply(WithBloomFilter(0.01))
+	c := database.newConnection()
+	for i := range 3000 {
+		c.mustExecCommand("set", []string{benchKey(i), "x"})
+	}
+	// Added more keys than it was sized for, so it was rebuilt.
+	assert(database.bloom.capacity >= 3000, "rebuilt to fit")
+
+	for i := range 3000 {
+		assertEq(c.mustExecCommand("get", []string{benchKey(i)}), "x", "get")
+	}
+	for i := range 1000 {
+		_, err := c.execCommand("get", []string{fmt.Sprint("absent", i)})
+		assertEq(err, ErrKeyNotFound, "absent")
+	}
+	absent := counterValue(database.metrics.bloomAbsent)
+	assertEq(counterValue(database.metrics.bloomPresent), 3000, "present")
+	assertEq(absent+counterValue(database.metrics.bloomFalsePositive), 1000, "absent and false positives")
+	assert(absent > 950, "mostly ruled out")
+
+	// Removing keys leaves their bits set, until there are enough to
+	// rebuild for.
+	for i := range 2500 {
+		c.mustExecCommand("delete", []string{benchKey(i)})
+	}
+	c.mustExecCommand("vacuum", nil)
+	assertEq(database.bloom.added, 500, "rebuilt")
+	_, err := c.execCommand("get", []string{benchKey(0)})
+	assertEq(err, ErrKeyNotFound, "deleted")
+	assertEq(c.mustExecCommand("get", []string{benchKey(2500)}), "x", "kept")
+}
+
+func TestWithBloomFilter_recovery(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir, WithBloomFilter(0.01))
+	assertEq(err, nil, "open")
+	database.Set("x", "1")
+	database.Checkpoint()
+	database.Set("y", "2")
+	database.Close()
+
+	database, err = NewDatabase(dir, WithBloomFilter(0.01))
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	c := database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"x"}), "1", "from the checkpoint")
+	assertEq(c.mustExecCommand("get", []string{"y"}), "2", "from the log")
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+/*
+Buckets split the keyspace, so applications sharing a database don't have
+to agree on key prefixes to stay out of each other's way. A bucket's keys
+are kept under a reserved prefix naming it, which scans of the rest of the
+keyspace skip, so "orders" can have a key "1" as well as "customers" and
+the default keyspace. Buckets are declared when the database is opened,
+each with the isolation level its transactions begin at by default.
+
+Applications reach a bucket through Database.Bucket, whose methods take
+keys within it, or a connection can "use orders", after which its
+commands' keys are within the bucket, and keys in results are too, until
+it "use"s another, or none to return to the default keyspace. Grants (see
+acl.go) are on the underlying keys, so a user that may touch the bucket's
+keys needs a grant on its prefix, Bucket.Key(""). Lookups in an index
+(see index.go) from a bucket only return its keys, but scripts use keys
+as they are. Autocommitted single-key writes go through the write batcher
+(see batch.go), so they run at the database's default isolation.
+
+Stats and vacuum can be limited to a bucket's keys. A vacuum of a single
+bucket leaves aborted transactions for a whole-store vacuum to forget.
+*/
+
+var ErrUnknownBucket = errors.New("no such bucket")
+
+const bucketPrefix = internalPrefix + "bucket\x00"
+
+var bucketName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+type Bucket struct {
+	db   *Database
+	name string
+	// What transactions begun in the bucket run at.
+	isolation IsolationLevel
+}
+
+// WithBucket declares a bucket whose transactions begin at isolation.
+func WithBucket(name string, isolation IsolationLevel) Option {
+	return func(d *Database) {
+		if !bucketName.MatchString(name) {
+			panic(fmt.Sprintf("bad bucket name %q", name))
+		}
+		if d.buckets == nil {
+			d.buckets = map[string]*Bucket{}
+		}
+		d.buckets[name] = &Bucket{db: d, name: name, isolation: isolation}
+	}
+}
+
+// Bucket returns the bucket called name.
+func (d *Database) Bucket(name string) (*Bucket, error) {
+	b, ok := d.buckets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownBucket, name)
+	}
+	return b, nil
+}
+
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// Key returns the key in the underlying keyspace that is key in the
+// bucket.
+func (b *Bucket) Key(key string) string {
+	return bucketPrefix + b.name + "\x00" + key
+}
+
+// unqualify returns key, from the underlying keyspace, within the bucket,
+// if it is in it.
+func (b *Bucket) unqualify(key string) (string, bool) {
+	return strings.CutPrefix(key, b.Key(""))
+}
+
+// bounds returns the range the bucket's keys take up.
+func (b *Bucket) bounds() (string, string) {
+	prefix := b.Key("")
+	return prefix, prefixEnd(prefix)
+}
+
+// Begin begins a transaction at the bucket's isolation level.
+func (b *Bucket) Begin() (*Transaction, error) {
+	return b.db.beginAt(context.Background(), b.isolation)
+}
+
+func (b *Bucket) Get(tx *Transaction, key string) (string, error) {
+	return tx.Get(b.Key(key))
+}
+
+func (b *Bucket) Set(tx *Transaction, key, value string) error {
+	return tx.Set(b.Key(key), value)
+}
+
+func (b *Bucket) Delete(tx *Transaction, key string) error {
+	return tx.Delete(b.Key(key))
+}
+
+// Scan returns the visible keys k in the bucket with start <= k < end, in
+// order. An empty end means the end of the bucket.
+func (b *Bucket) Scan(tx *Transaction, start, end string) ([]KeyValue, error) {
+	opts := ScanOptions{Start: b.Key(start), End: b.Key(end)}
+	if end == "" {
+		_, opts.End = b.bounds()
+	}
+	pairs, err := tx.ScanWith(opts)
+	for i := range pairs {
+		pairs[i].Key, _ = b.unqualify(pairs[i].Key)
+	}
+	return pairs, err
+}
+
+// Size counts the bucket's keys visible to tx.
+func (b *Bucket) Size(tx *Transaction) (int, error) {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	if err := tx.checkInProgress(); err != nil {
+		return 0, err
+	}
+	return tx.size(b.bounds()), nil
+}
+
+// Stats reports on the bucket's keys and versions; the rest of Stats is
+// for the whole database.
+func (b *Bucket) Stats() (Stats, error) {
+	s, err := b.db.Stats()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	s.Keys, s.Versions, s.DeadVersions = 0, 0, 0
+	start, end := b.bounds()
+	b.db.countVersions(&s, start, end)
+	return s, nil
+}
+
+// Vacuum removes dead versions of the bucket's keys, returning how many
+// it removed.
+func (b *Bucket) Vacuum() int {
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	p := b.db.newVacuumPass()
+	p.next, p.end = b.bounds()
+	b.db.vacuumStep(p, 0)
+	return p.removed
+}
+
+// use [bucket]
+func (c *Connection) use(args []string) (Result, error) {
+	if len(args) == 0 {
+		c.bucket = nil
+		return Result{}, nil
+	}
+	b, err := c.db.Bucket(args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	c.bucket = b
+	return Result{Value: b.name}, nil
+}
+
+// isolation returns the level the connection's transactions begin at.
+func (c *Connection) isolation() IsolationLevel {
+	if c.bucket != nil {
+		return c.bucket.isolation
+	}
+	return c.db.defaultIsolation
+}
+
+// beginIsolation returns the level begin's args ask for, or the
+// connection's, and the args after it.
+func (c *Connection) beginIsolation(args []string) (IsolationLevel, []string, error) {
+	if len(args) < 2 || args[0] != "isolation" {
+		return c.isolation(), args, nil
+	}
+	isolation, err := parseIsolation(args[1])
+	if err != nil {
+		return 0, nil, fmt.Errorf("begin: %w", err)
+	}
+	return isolation, args[2:], nil
+}
+
+// Commands whose first argument is a key.
+var singleKeyCommands = map[string]bool{
+	"get": true, "set": true, "delete": true, "meta": true, "exists": true,
+	"type": true, "jget": true, "jset": true, "incr": true, "decr": true,
+	"setnx": true, "getdel": true, "gets": true, "cas": true, "expire": true,
+	"ttl": true, "lpush": true, "rpush": true, "lpop": true, "lrange": true,
+	"hset": true, "hget": true, "hdel": true, "hgetall": true, "sadd": true,
+	"srem": true, "smembers": true, "zadd": true, "zrange": true, "cincr": true,
+	"cdecr": true, "watch": true,
+}
+
+// qualify returns the arguments of command, run in the bucket, with the
+// keys in them in the underlying keyspace.
+func (b *Bucket) qualify(command string, args []string) ([]string, error) {
+	args = slices.Clone(args)
+	switch {
+	case singleKeyCommands[command]:
+		if len(args) > 0 {
+			args[0] = b.Key(args[0])
+		}
+	case command == "mget" || command == "mdel":
+		for i := range args {
+			args[i] = b.Key(args[i])
+		}
+	case command == "mset":
+		for i := 0; i < len(args); i += 2 {
+			args[i] = b.Key(args[i])
+		}
+	case command == "rename" || command == "copy":
+		for i := 0; i < min(len(args), 2); i++ {
+			args[i] = b.Key(args[i])
+		}
+	case command == "explain":
+		if len(args) == 2 && args[0] == "get" {
+			args[1] = b.Key(args[1])
+		}
+	case command == "keys" || command == "watch-prefix":
+		if len(args) > 0 {
+			args[0] = b.Key(args[0])
+		}
+	case command == "scan":
+		opts, err := parseScanArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		start, end := opts.bounds()
+		args = []string{fmt.Sprintf("-limit=%d", opts.Limit), fmt.Sprintf("-desc=%t", opts.Order == Descending)}
+		for _, f := range opts.Filters {
+			args = append(args, "-"+f.Op.String(), f.Operand)
+		}
+		if end == "" {
+			_, end = b.bounds()
+		} else {
+			end = b.Key(end)
+		}
+		args = append(args, b.Key(start), end)
+	}
+	return args, nil
+}
+
+// unqualifyResult returns res, from command run in the bucket, with the
+// keys in it within the bucket. Keys that aren't in it are left out.
+func (b *Bucket) unqualifyResult(command string, res Result) Result {
+	var keys []string
+	for _, key := range res.Keys {
+		if key, ok := b.unqualify(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	res.Keys = keys
+	// Pairs from hgetall are of fields, not keys, and left alone.
+	for i := range res.Pairs {
+		res.Pairs[i].Key, _ = b.unqualify(res.Pairs[i].Key)
+	}
+	for i := range res.Lookups {
+		res.Lookups[i].Key, _ = b.unqualify(res.Lookups[i].Key)
+	}
+
+	switch command {
+	case "keys", "lookup":
+		res.Value = strings.Join(keys, "\n")
+	case "scan", "mget", "watch", "watch-prefix":
+		// Each line starts with a key, but for the cursor watches start
+		// with, which is left as it is.
+		lines := strings.Split(res.Value, "\n")
+		for i := range lines {
+			lines[i], _ = b.unqualify(lines[i])
+		}
+		res.Value = strings.Join(lines, "\n")
+	}
+	return res
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	database := newDatabase()
+	database.apply(WithBucket("orders", SerializableIsolation), WithBucket("customers", ReadCommitedIsolation))
+	_, err := database.Bucket("invoices")
+	assert(errors.Is(err, ErrUnknownBucket), "undeclared")
+
+	orders, _ := database.Bucket("orders")
+	customers, _ := database.Bucket("customers")
+	tx, _ := orders.Begin()
+	assertEq(tx.isolation, SerializableIsolation, "bucket isolation")
+	orders.Set(tx, "1", "order one")
+	customers.Set(tx, "1", "customer one")
+	tx.Set("1", "plain one")
+	assertEq(tx.Commit(), nil, "commit")
+
+	tx, _ = database.Begin()
+	value, _ := orders.Get(tx, "1")
+	assertEq(value, "order one", "orders")
+	value, _ = tx.Get("1")
+	assertEq(value, "plain one", "default keyspace")
+	pairs, _ := tx.Scan("", "")
+	assertEq(len(pairs), 1, "scans of the default keyspace skip buckets")
+	pairs, _ = customers.Scan(tx, "", "")
+	assertEq(len(pairs), 1, "scans of a bucket stay in it")
+	assertEq(pairs[0], KeyValue{"1", "customer one"}, "customers scan")
+	tx.Abort()
+
+	tx, _ = orders.Begin()
+	orders.Delete(tx, "1")
+	tx.Commit()
+	s, _ := orders.Stats()
+	assertEq(s.Keys, 1, "one key in orders")
+	assertEq(s.DeadVersions, 1, "and a dead version")
+	assertEq(orders.Vacuum(), 1, "vacuum orders")
+	s, _ = customers.Stats()
+	assertEq(s.Versions, 1, "customers untouched")
+}
+
+func TestBucket_use(t *testing.T) {
+	database := newDatabase()
+	database.apply(WithBucket("orders", SnapshotIsolation))
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"a", "plain"})
+
+	_, err := c.execCommand("use", []string{"invoices"})
+	assert(errors.Is(err, ErrUnknownBucket), "undeclared")
+	assertEq(c.mustExecCommand("use", []string{"orders"}), "orders", "use")
+	c.mustExecCommand("begin", nil)
+	assertEq(c.tx.isolation, SnapshotIsolation, "bucket isolation")
+	c.mustExecCommand("mset", []string{"a", "1", "b", "2"})
+	c.mustExecCommand("rename", []string{"b", "c"})
+	c.mustExecCommand("commit", nil)
+
+	assertEq(c.mustExecCommand("get", []string{"a"}), "1", "get")
+	assertEq(c.mustExecCommand("scan", []string{""}), "a=1\nc=2", "scan")
+	assertEq(c.mustExecCommand("scan", []string{"-desc", "-limit", "1", "a", ""}), "c=2", "scan descending")
+	assertEq(c.mustExecCommand("scan", []string{"-eq", "1", ""}), "a=1", "scan filtered")
+	explained := c.mustExecCommand("explain", []string{"get", "a"})
+	assert(strings.HasSuffix(explained, `visible, returned "1"`), "explain get")
+	assertEq(c.mustExecCommand("keys", []string{"*"}), "a\nc", "keys")
+	assertEq(c.mustExecCommand("mget", []string{"a", "b"}), "a=1\nb", "mget")
+	assertEq(c.mustExecCommand("dbsize", nil), "2", "dbsize")
+	res, _ := c.execCommand("get", []string{"c"})
+	assertEq(strings.Join(res.Keys, " "), "c", "result keys")
+
+	c.mustExecCommand("use", nil)
+	assertEq(c.mustExecCommand("get", []string{"a"}), "plain", "back in the default keyspace")
+	assertEq(c.mustExecCommand("dbsize", nil), "1", "dbsize")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+/*
+Optimistic clients read a value along with its version, work out what to
+write, and write it only if nobody got there first. A version is the id
+of the transaction that wrote it, which is unique to every write of the
+key that anyone could have read. Like setnx, cas reads and writes in one
+statement, so under Snapshot Isolation two transactions racing with the
+same version can't both commit.
+*/
+
+// GetVersion is like Get but also returns the version of the value: the
+// id of the transaction that wrote it.
+func (t *Transaction) GetVersion(key string) (string, uint64, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return "", 0, err
+	}
+
+	value, err := t.get(key)
+	if err != nil {
+		return "", 0, err
+	}
+	s, err := t.db.read(value)
+	return s, value.txStartId, err
+}
+
+// CompareAndSet sets key to value if its visible value is still the
+// given version, reporting whether it did. It returns ErrKeyNotFound if
+// the key has no visible value at all.
+func (t *Transaction) CompareAndSet(key, value string, version uint64) (bool, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return false, err
+	}
+
+	current, err := t.get(key)
+	if err != nil {
+		return false, err
+	}
+	if current.txStartId != version {
+		return false, nil
+	}
+	t.set(key, value)
+	return true, nil
+}
+
+// gets key
+func (c *Connection) gets(args []string) (Result, error) {
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	value, version, err := c.tx.GetVersion(args[0])
+	res.Value, res.Version = value, version
+	res.NotFound = errors.Is(err, ErrKeyNotFound)
+	return res, err
+}
+
+// cas key value version
+//
+// Returns 1 if it set the key, 0 if the key had moved on to another
+// version.
+func (c *Connection) cas(args []string) (Result, error) {
+	version, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return Result{}, errors.New("cas: version must be a transaction id")
+	}
+
+	res := Result{Keys: args[:1], TxId: c.tx.id, Value: "0"}
+	ok, err := c.tx.CompareAndSet(args[0], args[1], version)
+	if err != nil {
+		res.NotFound = errors.Is(err, ErrKeyNotFound)
+		return res, err
+	}
+	if ok {
+		res.Value = "1"
+	}
+	return res, nil
+}
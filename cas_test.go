@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCompareAndSet(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "1"})
+
+	res, err := c.execCommand("gets", []string{"x"})
+	assertEq(err, nil, "gets")
+	assertEq(res.Value, "1", "value")
+	version := res.Version
+	assert(version != 0, "version is the writer's id")
+
+	c.mustExecCommand("set", []string{"x", "2"})
+	assertEq(c.mustExecCommand("cas", []string{"x", "3", "1"}), "0", "stale version")
+	res, _ = c.execCommand("gets", []string{"x"})
+	assert(res.Version != version, "new version")
+	assertEq(c.mustExecCommand("cas", []string{"x", "3", strconv.FormatUint(res.Version, 10)}), "1", "current version")
+	assertEq(c.mustExecCommand("get", []string{"x"}), "3", "set")
+
+	res, err = c.execCommand("cas", []string{"y", "1", "1"})
+	assert(res.NotFound, "missing key")
+	assertEq(err, ErrKeyNotFound, "missing key error")
+}
+
+func TestCompareAndSet_race(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "1"})
+
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	_, version, _ := t1.GetVersion("x")
+
+	ok, err := t1.CompareAndSet("x", "a", version)
+	assert(ok && err == nil, "t1 sets")
+	ok, err = t2.CompareAndSet("x", "b", version)
+	assert(ok && err == nil, "t2 sees the same version")
+
+	assertEq(t1.Commit(), nil, "first commit")
+	assert(t2.Commit() != nil, "second commit conflicts")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+The change feed lets a consumer tail everything the database commits, to
+keep a cache, a search index or some downstream system in step with it.
+A stream from Changes yields one event per key a transaction changed, in
+commit order, and a transaction's changes in key order. Index entries
+(see index.go) are left out, being derived from the rest.
+
+The database keeps the changes of its most recent commits, 1024 unless
+WithChangeRetention says otherwise, in memory. A consumer remembers the
+last transaction it has seen and resumes from it; one that falls further
+behind than that, or resumes after a restart, has lost its place, and
+gets ErrChangesTruncated. It has to start over from a backup (see
+backup.go) or a scan.
+
+Streams don't hold anything in the database, so there is nothing to
+close: a consumer just stops calling Next.
+*/
+
+var ErrChangesTruncated = errors.New("changes are no longer retained")
+
+const defaultChangeRetention = 1024
+
+// ChangeEvent is a committed change to a key.
+type ChangeEvent struct {
+	Change
+	// When the transaction committed.
+	Time time.Time
+}
+
+// committedChanges is what one transaction committed, as the feed keeps
+// it.
+type committedChanges struct {
+	txId    uint64
+	time    time.Time
+	changes []Change
+}
+
+// WithChangeRetention makes the database keep the changes of the last n
+// transactions to commit for streams to resume from. n must be positive.
+func WithChangeRetention(n int) Option {
+	return func(d *Database) {
+		d.changeRetention = n
+	}
+}
+
+// publishChanges adds the changes t, just committed, made to the feed,
+// and passes them to the watches they match.
+func (d *Database) publishChanges(t *Transaction) {
+	changes, err := t.changes()
+	if err != nil {
+		// It has committed, so all there is to do is say so.
+		d.logger.Error("changefeed: can't read a committed value", "tx", t.id, "err", err)
+		return
+	}
+	changes = slices.DeleteFunc(changes, func(c Change) bool {
+		return strings.HasPrefix(c.Key, indexPrefix) || (!c.OldExists && !c.NewExists)
+	})
+	if len(changes) == 0 {
+		return
+	}
+
+	retention := d.changeRetention
+	if retention <= 0 {
+		retention = defaultChangeRetention
+	}
+	at := d.clock()
+	if t.committedAt != 0 {
+		at = t.committedAt.Time()
+	}
+	d.notifyWatches(changes, d.feedNext)
+	d.feed = append(d.feed, committedChanges{t.id, at, changes})
+	d.feedNext++
+	if len(d.feed) > retention {
+		d.feed = slices.Delete(d.feed, 0, len(d.feed)-retention)
+	}
+	if d.feedUpdated != nil {
+		close(d.feedUpdated)
+		d.feedUpdated = nil
+	}
+}
+
+// ChangeStream yields committed changes in commit order.
+type ChangeStream struct {
+	db *Database
+	// Where the stream is in the feed: the commit, counting from the
+	// database's first, and the change within it.
+	next   uint64
+	offset int
+}
+
+// Changes returns a stream of the changes committed after the transaction
+// fromTxId, which must be one a stream has yielded changes from, or if
+// fromTxId is zero, of every change the database still keeps.
+func (d *Database) Changes(fromTxId uint64) (*ChangeStream, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := &ChangeStream{db: d, next: d.feedNext - uint64(len(d.feed))}
+	if fromTxId == 0 {
+		return s, nil
+	}
+	for i, c := range d.feed {
+		if c.txId == fromTxId {
+			s.next += uint64(i) + 1
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("changes after transaction %d: %w", fromTxId, ErrChangesTruncated)
+}
+
+// Next waits for the next change.
+func (s *ChangeStream) Next(ctx context.Context) (ChangeEvent, error) {
+	d := s.db
+	for {
+		d.mu.Lock()
+		first := d.feedNext - uint64(len(d.feed))
+		if s.next < first {
+			d.mu.Unlock()
+			return ChangeEvent{}, ErrChangesTruncated
+		}
+		if i := s.next - first; i < uint64(len(d.feed)) {
+			c := d.feed[i]
+			event := ChangeEvent{c.changes[s.offset], c.time}
+			if s.offset++; s.offset == len(c.changes) {
+				s.next, s.offset = s.next+1, 0
+			}
+			d.mu.Unlock()
+			return event, nil
+		}
+		if d.feedUpdated == nil {
+			d.feedUpdated = make(chan struct{})
+		}
+		updated := d.feedUpdated
+		d.mu.Unlock()
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ChangeEvent{}, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChanges(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	now := time.Unix(1000, 0)
+	database.now = func() time.Time { return now }
+
+	database.Set("x", "1")
+	// Commit order, not transaction ids, decides the order.
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	t1.Set("y", "first")
+	t2.Set("z", "second")
+	t2.Delete("x")
+	assertEq(t2.Commit(), nil, "t2")
+	assertEq(t1.Commit(), nil, "t1")
+	aborted, _ := database.Begin()
+	aborted.Set("x", "never")
+	aborted.Abort()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s, err := database.Changes(0)
+	assertEq(err, nil, "changes")
+	var events []ChangeEvent
+	for range 4 {
+		e, err := s.Next(ctx)
+		assertEq(err, nil, "next")
+		events = append(events, e)
+	}
+	assertEq(events[0].Key+"="+events[0].New, "x=1", "first commit")
+	assert(!events[0].OldExists, "x was new")
+	assertEq(events[0].Time, now, "commit time")
+	assertEq(events[1].Key, "x", "t2 in key order")
+	assertEq(events[1].TxId, t2.id, "t2 id")
+	assert(events[1].OldExists && !events[1].NewExists, "deleted")
+	assertEq(events[1].Old, "1", "deleted value")
+	assertEq(events[2].Key+"="+events[2].New, "z=second", "t2")
+	assertEq(events[3].TxId, t1.id, "then t1")
+
+	// A stream waits for the next commit.
+	go database.Set("w", "later")
+	e, err := s.Next(ctx)
+	assertEq(err, nil, "waited")
+	assertEq(e.Key+"="+e.New, "w=later", "later commit")
+
+	// Resuming after a transaction picks up with the next commit.
+	s, err = database.Changes(t2.id)
+	assertEq(err, nil, "resume")
+	e, _ = s.Next(ctx)
+	assertEq(e.TxId, t1.id, "resumed after t2")
+
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	s, _ = database.Changes(e.TxId)
+	s.Next(ctx)
+	_, err = s.Next(short)
+	assertEq(err, context.DeadlineExceeded, "nothing more")
+}
+
+func TestChanges_truncated(t *testing.T) {
+	database := newDatabase()
+	database.apply(WithChangeRetention(2))
+
+	database.Set("a", "1")
+	first, _ := database.Begin()
+	first.Set("b", "1")
+	first.Commit()
+	s, _ := database.Changes(0)
+	database.Set("c", "1")
+	database.Set("d", "1")
+
+	// The stream has fallen behind what is kept.
+	_, err := s.Next(context.Background())
+	assert(errors.Is(err, ErrChangesTruncated), "fell behind")
+	_, err = database.Changes(first.id)
+	assert(errors.Is(err, ErrChangesTruncated), "forgotten")
+	_, err = database.Changes(12345)
+	assert(errors.Is(err, ErrChangesTruncated), "unknown")
+}
@@ -0,0 +1,51 @@
+package main
+
+/*
+Several things want to know what a committed transaction actually did to
+each key it wrote, rather than just which keys it wrote: watchers deciding
+whether to fire, for a start. That is recoverable from the version chains
+alone. A transaction's writes are the versions it created and didn't
+itself supersede; what they replaced are the versions it marked as ended.
+*/
+
+// Change describes the effect of a committed transaction on one key.
+type Change struct {
+	Key  string
+	TxId uint64
+
+	Old       string
+	OldExists bool
+	New       string
+	// False when the transaction deleted the key.
+	NewExists bool
+}
+
+// changes lists the effect of t on every key in its writeset.
+func (t *Transaction) changes() ([]Change, error) {
+	var changes []Change
+	iter := t.writeset.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		key := iter.Key()
+		c := Change{Key: key, TxId: t.id}
+
+		versions := t.db.unsealed(t, key)
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			var err error
+			if !c.NewExists && t.owns(v.txStartId) && !t.owns(v.txEndId) {
+				c.New, err = t.db.read(&v)
+				c.NewExists = true
+			}
+			if !c.OldExists && !t.owns(v.txStartId) && t.owns(v.txEndId) {
+				c.Old, err = t.db.read(&v)
+				c.OldExists = true
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+/*
+Without checkpoints, recovery replays the whole log, so restarting takes
+longer the longer the database has been running. A checkpoint writes out
+the store as it stands, along with what recovery needs to know about
+transactions, and then truncates the log up to that point. Recovery loads
+the checkpoint and replays only the log after it.
+
+The checkpoint is taken under the lock, so it is consistent with the log
+position it records. It holds every version that is still live at the
+retention horizon, including those written by transactions in progress:
+their records before the checkpoint are truncated away, so their writes
+so far must come from the checkpoint, and their commit records, later in
+the log, decide whether they count. Transactions below the horizon are
+not listed; they committed, unless they are in the aborted set.
+
+On disk, a checkpoint is a header frame (see format.go) with the log
+position and the transaction lists, then one frame per key with its
+versions, followed by their types if any isn't a string. The header records how many keys follow, so a truncated file
+is caught as surely as a damaged one.
+
+It is written to a temporary file and renamed into place, so a crash
+leaves either the old checkpoint or the new one. If the crash comes
+between the rename and the truncation, recovery skips the log records the
+checkpoint already covers.
+*/
+
+const checkpointFile = "checkpoint"
+
+type checkpoint struct {
+	// The last log record reflected in the checkpoint.
+	LSN               uint64
+	NextTransactionId uint64
+	Horizon           uint64
+
+	// Transactions at or above the horizon.
+	Running  []uint64
+	Finished map[uint64]TransactionState
+	Aborted  []uint64
+	// The global ids of running transactions that are prepared.
+	Prepared map[uint64]string
+	// The latest commit timestamp issued by then (see hlc.go).
+	HLC HLC
+
+	Keys     []string
+	Versions [][]checkpointVersion
+}
+
+type checkpointVersion struct {
+	TxStartId uint64
+	TxEndId   uint64
+	Value     string
+	ExpiresAt uint32
+	Type      ValueType
+}
+
+// Checkpoint writes the database's state to its data directory and
+// truncates the write-ahead log.
+func (d *Database) Checkpoint() error {
+	d.mu.Lock()
+	if d.dir == "" || d.wal == nil {
+		d.mu.Unlock()
+		return errors.New("checkpoint: database has no data directory")
+	}
+	if d.holdsSavepoints() {
+		d.mu.Unlock()
+		return fmt.Errorf("checkpoint: %w", ErrSavepointsHeld)
+	}
+	if d.hasNested() {
+		d.mu.Unlock()
+		return fmt.Errorf("checkpoint: %w", ErrNestedInProgress)
+	}
+	cp, err := d.takeCheckpoint()
+	wal := d.wal
+	d.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+
+	if err := writeCheckpoint(filepath.Join(d.dir, checkpointFile), cp, d.faults); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if err := wal.Truncate(cp.LSN); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	d.logger.Debug("checkpointed", "lsn", cp.LSN, "horizon", cp.Horizon)
+	return nil
+}
+
+func (d *Database) takeCheckpoint() (*checkpoint, error) {
+	horizon := d.retentionHorizon()
+	cp := &checkpoint{
+		LSN:               d.wal.LastLSN(),
+		NextTransactionId: d.nextTransactionId,
+		Horizon:           horizon,
+		Running:           d.running.Keys(),
+		Finished:          map[uint64]TransactionState{},
+		Aborted:           d.aborted.Keys(),
+		Prepared:          map[uint64]string{},
+		HLC:               d.hlc,
+	}
+	for gid, t := range d.prepared {
+		cp.Prepared[t.id] = gid
+	}
+
+	txs := d.transactions.Iter()
+	for ok := txs.First(); ok; ok = txs.Next() {
+		if state := txs.Value().state; state != InProgressTransaction {
+			cp.Finished[txs.Key()] = state
+		}
+	}
+
+	iter := d.store.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		var versions []checkpointVersion
+		for _, v := range iter.Value() {
+			if d.dead(&v, horizon) {
+				continue
+			}
+			raw, err := d.readRaw(&v)
+			if err != nil {
+				return nil, err
+			}
+			versions = append(versions, checkpointVersion{v.txStartId, v.txEndId, raw, v.expiresAt, v.data.kind})
+		}
+		if len(versions) > 0 {
+			cp.Keys = append(cp.Keys, iter.Key())
+			cp.Versions = append(cp.Versions, versions)
+		}
+	}
+	return cp, nil
+}
+
+const checkpointMagic = "MVCCCKPT"
+
+// writeCheckpoint writes cp to a new file, which replaces the one at path
+// only once it is synced, with whatever faults fi injects.
+func writeCheckpoint(path string, cp *checkpoint, fi FaultInjector) error {
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	name := filepath.Base(path)
+	w := bufio.NewWriter(faultWriter{fi, name, tmp})
+	err = encodeCheckpoint(w, cp)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = syncFile(fi, name, tmp)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	return err
+}
+
+func encodeCheckpoint(w io.Writer, cp *checkpoint) error {
+	_, err := w.Write(appendFrame(nil, encodeCheckpointHeader(cp)))
+	for i := 0; err == nil && i < len(cp.Keys); i++ {
+		body := frameBody(nil).string(cp.Keys[i]).uvarint(uint64(len(cp.Versions[i])))
+		types := make([]byte, len(cp.Versions[i]))
+		typed := false
+		for j, v := range cp.Versions[i] {
+			body = body.uvarint(v.TxStartId).uvarint(v.TxEndId).string(v.Value).uint32(v.ExpiresAt)
+			types[j] = byte(v.Type)
+			typed = typed || v.Type != StringType
+		}
+		if typed {
+			body = body.string(string(types))
+		}
+		_, err = w.Write(appendFrame(nil, body))
+	}
+	return err
+}
+
+func encodeCheckpointHeader(cp *checkpoint) frameBody {
+	body := frameBody(nil).string(checkpointMagic).uvarint(cp.LSN).uvarint(cp.NextTransactionId).uvarint(cp.Horizon)
+	body = body.uvarint(uint64(len(cp.Running)))
+	for _, id := range cp.Running {
+		body = body.uvarint(id)
+	}
+	body = body.uvarint(uint64(len(cp.Aborted)))
+	for _, id := range cp.Aborted {
+		body = body.uvarint(id)
+	}
+	body = body.uvarint(uint64(len(cp.Finished)))
+	for id, state := range cp.Finished {
+		body = append(body.uvarint(id), byte(state))
+	}
+	body = body.uvarint(uint64(len(cp.Keys)))
+	// Checkpoints from before prepared transactions end here.
+	body = body.uvarint(uint64(len(cp.Prepared)))
+	for id, gid := range cp.Prepared {
+		body = body.uvarint(id).string(gid)
+	}
+	// Checkpoints from before commit timestamps end here.
+	return body.uvarint(uint64(cp.HLC))
+}
+
+// readCheckpoint reads the checkpoint at path, returning nil if there is
+// none.
+func readCheckpoint(path string) (*checkpoint, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cp, err := decodeCheckpoint(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+func decodeCheckpoint(r io.Reader) (*checkpoint, error) {
+	body, _, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	br := frameReader{b: body}
+	if br.string() != checkpointMagic {
+		return nil, errors.New("not a checkpoint")
+	}
+
+	cp := &checkpoint{
+		LSN:               br.uvarint(),
+		NextTransactionId: br.uvarint(),
+		Horizon:           br.uvarint(),
+		Finished:          map[uint64]TransactionState{},
+		Prepared:          map[uint64]string{},
+	}
+	for n := br.uvarint(); br.err == nil && n > 0; n-- {
+		cp.Running = append(cp.Running, br.uvarint())
+	}
+	for n := br.uvarint(); br.err == nil && n > 0; n-- {
+		cp.Aborted = append(cp.Aborted, br.uvarint())
+	}
+	for n := br.uvarint(); br.err == nil && n > 0; n-- {
+		cp.Finished[br.uvarint()] = TransactionState(br.byte())
+	}
+	keys := br.uvarint()
+	if len(br.b) > 0 {
+		for n := br.uvarint(); br.err == nil && n > 0; n-- {
+			cp.Prepared[br.uvarint()] = br.string()
+		}
+	}
+	if len(br.b) > 0 {
+		cp.HLC = HLC(br.uvarint())
+	}
+	if err := br.done(); err != nil {
+		return nil, err
+	}
+
+	for range keys {
+		body, _, err := readFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		br := frameReader{b: body}
+		key := br.string()
+		var versions []checkpointVersion
+		for n := br.uvarint(); br.err == nil && n > 0; n-- {
+			versions = append(versions, checkpointVersion{
+				TxStartId: br.uvarint(),
+				TxEndId:   br.uvarint(),
+				Value:     br.string(),
+				ExpiresAt: br.uint32(),
+			})
+		}
+		if len(br.b) > 0 {
+			types := br.string()
+			if len(types) != len(versions) {
+				return nil, errFrameBody
+			}
+			for j := range versions {
+				versions[j].Type = ValueType(types[j])
+			}
+		}
+		if err := br.done(); err != nil {
+			return nil, err
+		}
+		cp.Keys = append(cp.Keys, key)
+		cp.Versions = append(cp.Versions, versions)
+	}
+	return cp, nil
+}
+
+// loadCheckpoint restores the empty database to the state in cp. The
+// transactions that were running are returned, to be finished by the
+// log.
+func (d *Database) loadCheckpoint(cp *checkpoint) map[uint64]*Transaction {
+	d.nextTransactionId = cp.NextTransactionId
+	// When the transactions it lists committed isn't kept, only that it
+	// was by the time of the checkpoint.
+	d.observeHLC(cp.HLC)
+	d.hlcPruned = max(d.hlcPruned, cp.HLC)
+	for _, id := range cp.Aborted {
+		d.aborted.Insert(id)
+	}
+	for id, state := range cp.Finished {
+		d.transactions.Set(id, &Transaction{id: id, state: state, db: d})
+		d.clog.record(id, state)
+	}
+
+	running := map[uint64]*Transaction{}
+	for _, id := range cp.Running {
+		t := &Transaction{
+			isolation: ReadCommitedIsolation,
+			id:        id,
+			state:     InProgressTransaction,
+			db:        d,
+			logged:    true,
+		}
+		if gid, ok := cp.Prepared[id]; ok {
+			d.addPrepared(t, gid)
+		}
+		d.transactions.Set(id, t)
+		d.running.Insert(id)
+		running[id] = t
+	}
+
+	for i, key := range cp.Keys {
+		versions := make([]Value, len(cp.Versions[i]))
+		for j, v := range cp.Versions[i] {
+			versions[j] = Value{
+				txStartId: v.TxStartId,
+				txEndId:   v.TxEndId,
+				data:      d.payload(v.Value, v.Type),
+				checksum:  d.checksum(formatValue(v.Type, v.Value)),
+				expiresAt: v.ExpiresAt,
+			}
+		}
+		// Checkpoints taken before versions were kept in two parts (see
+		// sealEnds) may have the parts mixed.
+		slices.SortStableFunc(versions, func(a, b Value) int {
+			return cmp.Compare(d.chainPart(a), d.chainPart(b))
+		})
+		d.versionBytes += keySize(key)
+		for j := range versions {
+			d.versionBytes += versionSize(&versions[j])
+		}
+		d.store.Set(key, versions)
+		d.keyAdded(key)
+	}
+	return running
+}
+
+// StartCheckpointer checkpoints every interval until the returned
+// function is called.
+func (d *Database) StartCheckpointer(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := d.Checkpoint(); err != nil {
+					d.logger.Error("checkpoint failed", "err", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
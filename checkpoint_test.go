@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+
+	c1 := database.newConnection()
+	for _, value := range []string{"one", "two", "three"} {
+		c1.mustExecCommand("set", []string{"x", value})
+	}
+
+	// One transaction commits after the checkpoint, one never does.
+	commits := database.newConnection()
+	commits.mustExecCommand("begin", nil)
+	commits.mustExecCommand("set", []string{"y", "late"})
+	crashes := database.newConnection()
+	crashes.mustExecCommand("begin", nil)
+	crashes.mustExecCommand("set", []string{"z", "never"})
+
+	walPath := filepath.Join(dir, "wal")
+	before, _ := os.Stat(walPath)
+	assertEq(database.Checkpoint(), nil, "checkpoint")
+	after, _ := os.Stat(walPath)
+	assert(after.Size() < before.Size(), "log truncated")
+
+	commits.mustExecCommand("commit", nil)
+	c1.mustExecCommand("set", []string{"x", "four"})
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+
+	c := database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"x"}), "four", "x")
+	assertEq(c.mustExecCommand("get", []string{"y"}), "late", "committed after the checkpoint")
+	_, err = c.execCommand("get", []string{"z"})
+	assertEq(err, ErrKeyNotFound, "never committed")
+
+	// And again, with nothing new in the log.
+	assertEq(database.Checkpoint(), nil, "second checkpoint")
+	c.mustExecCommand("set", []string{"x", "five"})
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen again")
+	defer database.Close()
+	c = database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"x"}), "five", "x after the second checkpoint")
+	assertEq(len(database.versions("x")), 1, "only live versions kept")
+}
+
+func TestCheckpoint_corrupt(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"a", "1"})
+	c.mustExecCommand("set", []string{"b", "2"})
+	assertEq(database.Checkpoint(), nil, "checkpoint")
+	crash(database)
+
+	path := filepath.Join(dir, checkpointFile)
+	data, _ := os.ReadFile(path)
+
+	// Missing the last key.
+	os.WriteFile(path, data[:len(data)-frameHeaderSize-4], 0o644)
+	_, err = NewDatabase(dir)
+	assert(err != nil, "truncated checkpoint refused")
+
+	damaged := bytes.Clone(data)
+	damaged[len(damaged)-2] ^= 0xff
+	os.WriteFile(path, damaged, 0o644)
+	_, err = NewDatabase(dir)
+	assert(errors.Is(err, errFrameChecksum), "damaged checkpoint refused")
+
+	os.WriteFile(path, data, 0o644)
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "intact checkpoint")
+	defer database.Close()
+	assertEq(database.newConnection().mustExecCommand("get", []string{"b"}), "2", "b")
+}
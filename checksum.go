@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+/*
+Values can cross lossy layers on their way to a client (a flaky proxy, a
+hand-rolled protocol frontend). When checksums are enabled each version
+stores a CRC-32C of its value at write time, and readers get it back
+alongside the value so they can verify what they received end-to-end.
+Gets verify it too before returning a value, so whatever corrupted it on
+this side fails the get rather than reaching the client.
+*/
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksumMismatch is returned by a get of a version whose value no
+// longer matches the checksum stored with it.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// WithChecksums stores a checksum with every version written.
+func WithChecksums() Option {
+	return func(d *Database) {
+		d.checksums = true
+	}
+}
+
+func (d *Database) checksum(value string) uint32 {
+	if !d.checksums {
+		return 0
+	}
+	return crc32.Checksum([]byte(value), castagnoli)
+}
+
+// Checksum computes the checksum clients should compare against the one
+// returned by GetWithChecksum or Meta.
+func Checksum(value string) uint32 {
+	return crc32.Checksum([]byte(value), castagnoli)
+}
+
+// VersionMeta describes the version of a key visible to a transaction.
+type VersionMeta struct {
+	TxStartId uint64
+	Size      int
+	// Zero when the version was written without checksums enabled.
+	Checksum uint32
+	// When the version's transaction committed, if it has and that is
+	// still known. See hlc.go.
+	Committed HLC
+}
+
+func (m VersionMeta) String() string {
+	s := fmt.Sprintf("txstart=%d size=%d checksum=%08x", m.TxStartId, m.Size, m.Checksum)
+	if m.Committed != 0 {
+		s += " committed=" + m.Committed.String()
+	}
+	return s
+}
+
+// GetWithChecksum is like Get but also returns the checksum stored with
+// the visible version.
+func (t *Transaction) GetWithChecksum(key string) (string, uint32, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return "", 0, err
+	}
+
+	value, err := t.get(key)
+	if err != nil {
+		return "", 0, err
+	}
+	s, err := t.db.readVerified(key, value)
+	if err != nil {
+		return "", 0, err
+	}
+	return s, value.checksum, nil
+}
+
+// readVerified reads v, key's, failing if it has a checksum its value
+// doesn't match.
+func (d *Database) readVerified(key string, v *Value) (string, error) {
+	s, err := d.read(v)
+	if err != nil {
+		return "", err
+	}
+	if v.checksum != 0 && Checksum(s) != v.checksum {
+		return "", fmt.Errorf("%w: %q written by transaction %d", ErrChecksumMismatch, key, v.txStartId)
+	}
+	return s, nil
+}
+
+// Meta describes the version of key visible to the transaction without
+// returning the value itself.
+func (t *Transaction) Meta(key string) (VersionMeta, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return VersionMeta{}, err
+	}
+
+	value, err := t.get(key)
+	if err != nil {
+		return VersionMeta{}, err
+	}
+	return VersionMeta{
+		TxStartId: value.txStartId,
+		Size:      value.data.Len(),
+		Checksum:  value.checksum,
+		Committed: t.db.commitTimestamp(value.txStartId),
+	}, nil
+}
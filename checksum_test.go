@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	database := newDatabase()
+	database.apply(WithChecksums())
+
+	tx, _ := database.Begin()
+	tx.Set("x", "hey")
+
+	value, sum, err := tx.GetWithChecksum("x")
+	assertEq(err, nil, "get x")
+	assertEq(value, "hey", "get x")
+	assertEq(sum, Checksum("hey"), "checksum of x")
+
+	c := database.newConnection()
+	c.mustExecCommand("begin", nil)
+	tx.Commit()
+
+	res := c.mustExecCommand("meta", []string{"x"})
+	assertEq(res, fmt.Sprintf("txstart=%d size=3 checksum=%08x committed=%s", tx.id, Checksum("hey"), tx.CommitTimestamp()), "meta x")
+
+	// Versions written without checksums enabled report zero.
+	database.checksums = false
+	c.mustExecCommand("set", []string{"y", "yall"})
+	_, sum, _ = c.tx.GetWithChecksum("y")
+	assertEq(sum, uint32(0), "checksum of y")
+}
+
+func TestChecksums_get(t *testing.T) {
+	database := newDatabase()
+	database.apply(WithChecksums())
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "hey"})
+
+	res, err := c.execCommand("get", []string{"x"})
+	assertEq(err, nil, "get")
+	assertEq(res.Checksum, Checksum("hey"), "get returns the checksum")
+
+	// A value that no longer matches its checksum fails the get.
+	database.versions("x")[0].checksum ^= 1
+	_, err = c.execCommand("get", []string{"x"})
+	assert(errors.Is(err, ErrChecksumMismatch), "get verifies")
+	tx, _ := database.Begin()
+	_, err = tx.AppendGet(nil, "x")
+	assert(errors.Is(err, ErrChecksumMismatch), "AppendGet verifies")
+	tx.Abort()
+}
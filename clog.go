@@ -0,0 +1,64 @@
+package main
+
+/*
+Reads ask whether a version's writer committed for every version they
+look at, and the registry answers with a btree lookup, or two once the
+transaction has been pruned. The commit log answers with a bit of
+arithmetic, in the manner of PostgreSQL's clog: a bitmap with two bits
+per transaction id holding its outcome.
+
+It only holds outcomes, which don't change once reached. A transaction
+still in progress, or merged into a parent that hasn't finished, has
+none yet, and the zero bits read as in progress, sending the lookup on
+to the registry. Outcomes cost a quarter of a byte a transaction, and are
+kept until the registry prunes their transactions (see registry.go),
+whose outcomes it answers for itself from then on; the log drops them
+once half of it is below the pruning horizon, so it stays the size of
+the transactions above it. A database that restarts or follows a new
+leader starts afresh, and fills the log in again as transactions finish.
+*/
+
+type commitLog struct {
+	bits []byte
+	// The byte of the bitmap bits starts at: the outcomes of the
+	// transactions below base*4 have been dropped.
+	base uint64
+}
+
+// record notes that transaction id reached state, if that's an outcome.
+func (c *commitLog) record(id uint64, state TransactionState) {
+	if state != CommittedTransaction && state != AbortedTransaction {
+		return
+	}
+	if id/4 < c.base {
+		return
+	}
+	i, shift := id/4-c.base, id%4*2
+	for uint64(len(c.bits)) <= i {
+		c.bits = append(c.bits, 0)
+	}
+	c.bits[i] = c.bits[i]&^(3<<shift) | byte(state)<<shift
+}
+
+// state returns the outcome of transaction id, or InProgressTransaction
+// if it has none recorded.
+func (c *commitLog) state(id uint64) TransactionState {
+	if id/4 < c.base || id/4-c.base >= uint64(len(c.bits)) {
+		return InProgressTransaction
+	}
+	return TransactionState(c.bits[id/4-c.base] >> (id % 4 * 2) & 3)
+}
+
+// truncate drops the outcomes of the transactions below id, once there
+// are enough of them to be worth copying the rest for.
+func (c *commitLog) truncate(id uint64) {
+	if id/4 <= c.base {
+		return
+	}
+	drop := min(id/4-c.base, uint64(len(c.bits)))
+	if drop < uint64(len(c.bits))/2 {
+		return
+	}
+	c.bits = append([]byte(nil), c.bits[drop:]...)
+	c.base += drop
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCommitLog(t *testing.T) {
+	var c commitLog
+	assertEq(c.state(7), InProgressTransaction, "nothing recorded")
+	c.record(5, CommittedTransaction)
+	c.record(6, AbortedTransaction)
+	c.record(7, MergedTransaction)
+	assertEq(c.state(5), CommittedTransaction, "committed")
+	assertEq(c.state(6), AbortedTransaction, "aborted")
+	assertEq(c.state(7), InProgressTransaction, "merged isn't an outcome")
+	assertEq(c.state(4), InProgressTransaction, "neighbour untouched")
+	assertEq(len(c.bits), 2, "two bits a transaction")
+}
+
+func TestCommitLog_outcomes(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "one"})
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "two"})
+	aborted := c.tx.id
+	c.mustExecCommand("abort", nil)
+	c.mustExecCommand("begin", nil)
+	running := c.tx.id
+
+	for id := uint64(1); id < database.nextTransactionId; id++ {
+		want := database.registryState(id)
+		if id == running {
+			want = InProgressTransaction
+		}
+		assertEq(database.clog.state(id), want, fmt.Sprint("transaction ", id))
+	}
+	assertEq(database.transactionState(aborted), AbortedTransaction, "aborted")
+	assertEq(database.transactionState(running), InProgressTransaction, "in progress")
+}
+
+func TestCommitLog_truncate(t *testing.T) {
+	var c commitLog
+	for id := uint64(1); id < 40; id++ {
+		c.record(id, CommittedTransaction)
+	}
+	c.truncate(8)
+	assertEq(c.base, uint64(0), "too little to drop")
+	assertEq(c.state(1), CommittedTransaction, "kept")
+
+	c.truncate(22)
+	assertEq(c.base, uint64(5), "whole bytes below 22 dropped")
+	assertEq(c.state(19), InProgressTransaction, "dropped")
+	assertEq(c.state(20), CommittedTransaction, "kept")
+	assertEq(c.state(39), CommittedTransaction, "newest kept")
+	c.record(3, AbortedTransaction)
+	assertEq(c.state(3), InProgressTransaction, "below the base isn't recorded")
+	c.record(41, AbortedTransaction)
+	assertEq(c.state(41), AbortedTransaction, "recorded past the end")
+	assertEq(len(c.bits), 6, "bytes from 20 to 43")
+}
+
+func TestCommitLog_pruned(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	for i := range 1000 {
+		c.mustExecCommand("set", []string{"x", fmt.Sprint(i)})
+	}
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "aborted"})
+	aborted := c.tx.id
+	c.mustExecCommand("abort", nil)
+	c.mustExecCommand("set", []string{"x", "last"})
+
+	assert(len(database.clog.bits) < 10, "the log doesn't keep pruned transactions")
+	assertEq(database.transactionState(1), CommittedTransaction, "pruned and committed")
+	assertEq(database.transactionState(aborted), AbortedTransaction, "pruned and aborted")
+}
+
+// BenchmarkCommitLog scans a store whose registry a transaction left
+// running keeps whole, with the commit log and with it emptied, so every
+// lookup goes to the registry.
+func BenchmarkCommitLog(b *testing.B) {
+	for _, clog := range []bool{true, false} {
+		b.Run(fmt.Sprintf("clog=%t", clog), func(b *testing.B) {
+			database := newDatabase()
+			database.Begin()
+			// A transaction a key, as if each were written by a client of
+			// its own.
+			for i := range 10000 {
+				tx, _ := database.Begin()
+				tx.Set(benchKey(i), "x")
+				tx.Commit()
+			}
+			if !clog {
+				database.clog = commitLog{}
+			}
+
+			for b.Loop() {
+				tx, _ := database.Begin()
+				tx.scan("", "", Ascending, func(string, *Value) bool { return true })
+				tx.Abort()
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+One database has one lock, so however many cores a process has, only one
+of them is ever inside it. A cluster spreads the keyspace over several
+databases, its shards, by a hash of the key, so work on keys in different
+shards runs in parallel. Each shard is a whole database, with its own
+registry, log and checkpoints.
+
+A transaction on a cluster begins a transaction on each shard it touches,
+when it first touches it. One that only touches one shard is just an
+ordinary transaction of that shard's, and commits as one; one that
+touches more commits in two phases across them (see distributed.go).
+Keys used together are cheaper if they hash together, which a hash tag
+arranges: if a key has a part in braces, as in "user:{42}:name", only
+that part is hashed, so "user:{42}:name" and "cart:{42}" are in the same
+shard.
+
+A cluster's shards are kept in directories of their own under the
+cluster's, and the number of shards can't change once there is data:
+every key would hash somewhere else.
+*/
+
+const clusterShardsFile = "shards"
+
+// Cluster is a database partitioned across shards by key.
+type Cluster struct {
+	shards []*Database
+
+	// Where decisions to commit across shards are logged, if the cluster
+	// is on disk, and the last global id given out. See distributed.go.
+	coordinator *coordinatorLog
+	lastGid     atomic.Uint64
+}
+
+// NewCluster returns a cluster of n shards in memory, each opened with
+// opts.
+func NewCluster(n int, opts ...Option) *Cluster {
+	c := &Cluster{}
+	for range n {
+		d := new(Database)
+		*d = newDatabase()
+		d.apply(opts...)
+		c.shards = append(c.shards, d)
+	}
+	return c
+}
+
+// OpenCluster opens the cluster of n shards kept in dir, creating it if
+// need be. It fails if the cluster there has a different number of
+// shards.
+func OpenCluster(dir string, n int, opts ...Option) (*Cluster, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("cluster: %d shards", n)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, clusterShardsFile)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		err = os.WriteFile(path, []byte(strconv.Itoa(n)+"\n"), 0o644)
+	case err == nil:
+		if have := strings.TrimSpace(string(data)); have != strconv.Itoa(n) {
+			err = fmt.Errorf("cluster: %s has %s shards, not %d", dir, have, n)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{}
+	for i := range n {
+		d, err := NewDatabase(filepath.Join(dir, fmt.Sprintf("shard-%d", i)), opts...)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("cluster: shard %d: %w", i, err)
+		}
+		c.shards = append(c.shards, d)
+	}
+
+	coordinator, decided, err := openCoordinatorLog(filepath.Join(dir, coordinatorFile))
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("cluster: %w", err)
+	}
+	c.coordinator = coordinator
+	if err := c.recoverPrepared(decided); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes every shard, and the coordinator's log.
+func (c *Cluster) Close() error {
+	var errs []error
+	for _, d := range c.shards {
+		errs = append(errs, d.Close())
+	}
+	if c.coordinator != nil {
+		errs = append(errs, c.coordinator.close())
+	}
+	return errors.Join(errs...)
+}
+
+// Shards returns the cluster's shards, in order.
+func (c *Cluster) Shards() []*Database {
+	return c.shards
+}
+
+// ShardOf returns the index of the shard key belongs to.
+func (c *Cluster) ShardOf(key string) int {
+	h := fnv.New64a()
+	h.Write([]byte(hashTag(key)))
+	return int(h.Sum64() % uint64(len(c.shards)))
+}
+
+// hashTag returns the part of key that is hashed: what is between its
+// first braces, if there is anything, or else all of it.
+func hashTag(key string) string {
+	if _, rest, ok := strings.Cut(key, "{"); ok {
+		if tag, _, ok := strings.Cut(rest, "}"); ok && tag != "" {
+			return tag
+		}
+	}
+	return key
+}
+
+func (c *Cluster) shard(key string) *Database {
+	return c.shards[c.ShardOf(key)]
+}
+
+// Get returns key's value, read in a transaction of its own.
+func (c *Cluster) Get(key string) (string, error) {
+	tx, err := c.shard(key).Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Abort()
+	return tx.Get(key)
+}
+
+// Set writes key in its own single-statement transaction.
+func (c *Cluster) Set(key, value string) error {
+	return c.shard(key).Set(key, value)
+}
+
+// Delete removes key in its own single-statement transaction.
+func (c *Cluster) Delete(key string) error {
+	return c.shard(key).Delete(key)
+}
+
+// ClusterTransaction is a transaction on a cluster.
+type ClusterTransaction struct {
+	cluster *Cluster
+	// Its transaction on each shard, once it has touched a key there.
+	txs []*Transaction
+	// Set once it has committed or aborted.
+	done bool
+}
+
+// Begin starts a transaction on the cluster. It begins on each shard with
+// the first key it touches there.
+func (c *Cluster) Begin() *ClusterTransaction {
+	return &ClusterTransaction{cluster: c, txs: make([]*Transaction, len(c.shards))}
+}
+
+// Shards returns the indexes of the shards the transaction has touched.
+func (t *ClusterTransaction) Shards() []int {
+	var shards []int
+	for i, tx := range t.txs {
+		if tx != nil {
+			shards = append(shards, i)
+		}
+	}
+	return shards
+}
+
+// on returns the transaction on key's shard, beginning it if need be.
+func (t *ClusterTransaction) on(key string) (*Transaction, error) {
+	if t.done {
+		return nil, ErrNoTransaction
+	}
+	shard := t.cluster.ShardOf(key)
+	if t.txs[shard] == nil {
+		tx, err := t.cluster.shards[shard].Begin()
+		if err != nil {
+			return nil, err
+		}
+		t.txs[shard] = tx
+	}
+	return t.txs[shard], nil
+}
+
+func (t *ClusterTransaction) Get(key string) (string, error) {
+	tx, err := t.on(key)
+	if err != nil {
+		return "", err
+	}
+	return tx.Get(key)
+}
+
+func (t *ClusterTransaction) Set(key, value string) error {
+	tx, err := t.on(key)
+	if err != nil {
+		return err
+	}
+	return tx.Set(key, value)
+}
+
+func (t *ClusterTransaction) Delete(key string) error {
+	tx, err := t.on(key)
+	if err != nil {
+		return err
+	}
+	return tx.Delete(key)
+}
+
+// Commit commits the transaction: directly if it touched one shard, and
+// in two phases if it touched more (see distributed.go). If it fails
+// before the commit point, it has been aborted on every shard.
+func (t *ClusterTransaction) Commit() error {
+	if t.done {
+		return ErrNoTransaction
+	}
+	t.done = true
+	shards := t.Shards()
+	switch len(shards) {
+	case 0:
+		return nil
+	case 1:
+		return t.txs[shards[0]].Commit()
+	}
+
+	gid, err := t.prepare()
+	if err != nil {
+		return err
+	}
+	return t.cluster.commitPrepared(gid, shards)
+}
+
+// Abort aborts the transaction on every shard it touched.
+func (t *ClusterTransaction) Abort() error {
+	if t.done {
+		return ErrNoTransaction
+	}
+	t.done = true
+	var errs []error
+	for _, i := range t.Shards() {
+		errs = append(errs, t.txs[i].Abort())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// keyInShard returns a key, starting with prefix, that c puts in shard.
+func keyInShard(c *Cluster, prefix string, shard int) string {
+	for i := 0; ; i++ {
+		if key := fmt.Sprint(prefix, i); c.ShardOf(key) == shard {
+			return key
+		}
+	}
+}
+
+func TestCluster(t *testing.T) {
+	c := NewCluster(4)
+	a, b := keyInShard(c, "a", 0), keyInShard(c, "b", 1)
+
+	// Keys spread over the shards, and each lives in its own.
+	used := map[int]bool{}
+	for i := range 100 {
+		key := fmt.Sprint("k", i)
+		used[c.ShardOf(key)] = true
+		assertEq(c.Set(key, "v"), nil, "set")
+		other, _ := c.Shards()[(c.ShardOf(key)+1)%4].Begin()
+		_, err := other.Get(key)
+		assertEq(err, ErrKeyNotFound, "not in another shard")
+		other.Abort()
+	}
+	assertEq(len(used), 4, "every shard used")
+
+	// A transaction in one shard runs as it would in that database.
+	tx := c.Begin()
+	assertEq(len(tx.Shards()), 0, "no shard yet")
+	assertEq(tx.Set(a, "1"), nil, "set a")
+	a2 := keyInShard(c, a+"-", 0)
+	assertEq(tx.Set(a2, "2"), nil, "another key in the shard")
+	assertEq(fmt.Sprint(tx.Shards()), "[0]", "on a's shard")
+	_, err := c.Get(a)
+	assertEq(err, ErrKeyNotFound, "not committed yet")
+	assertEq(tx.Commit(), nil, "commit")
+	value, _ := c.Get(a2)
+	assertEq(value, "2", "committed")
+	assertEq(tx.Commit(), ErrNoTransaction, "already committed")
+	_, err = c.Get(b)
+	assertEq(err, ErrKeyNotFound, "b never written")
+
+	// Keys with the same hash tag share a shard.
+	assertEq(c.ShardOf("user:{42}:name"), c.ShardOf("cart:{42}"), "hash tag")
+	assertEq(hashTag("a{}b"), "a{}b", "empty tag")
+	assertEq(hashTag("a{b"), "a{b", "unclosed tag")
+
+	// Aborting discards the writes.
+	tx = c.Begin()
+	tx.Delete(a)
+	assertEq(tx.Abort(), nil, "abort")
+	value, _ = c.Get(a)
+	assertEq(value, "1", "aborted delete")
+}
+
+func TestOpenCluster(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenCluster(dir, 3)
+	assertEq(err, nil, "open")
+	for i := range 10 {
+		c.Set(fmt.Sprint("k", i), fmt.Sprint(i))
+	}
+	assertEq(c.Close(), nil, "close")
+
+	_, err = OpenCluster(dir, 4)
+	assert(err != nil, "different number of shards")
+
+	c, err = OpenCluster(dir, 3)
+	assertEq(err, nil, "reopen")
+	defer c.Close()
+	for i := range 10 {
+		value, err := c.Get(fmt.Sprint("k", i))
+		assertEq(err, nil, "recovered")
+		assertEq(value, fmt.Sprint(i), "recovered")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+Large text and JSON values compress well. With compression on, values
+longer than the threshold are compressed with zstd when a version is
+written, and decompressed whenever it is read, wherever the bytes end up
+living (in memory or in a segment file). A value that doesn't get any
+smaller is stored as it is.
+
+A compressed value's payload records its uncompressed length alongside,
+so its size can be reported without decompressing it.
+*/
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// Both are safe for concurrent use through EncodeAll and DecodeAll.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+type CompressionStats struct {
+	// Values compressed, and their size before and after.
+	Values          int
+	Bytes           int64
+	CompressedBytes int64
+}
+
+// Ratio returns the compressed size as a fraction of the original.
+func (s CompressionStats) Ratio() float64 {
+	if s.Bytes == 0 {
+		return 1
+	}
+	return float64(s.CompressedBytes) / float64(s.Bytes)
+}
+
+// UseCompression compresses values longer than threshold bytes from now
+// on. A threshold of zero turns compression off again.
+func (d *Database) UseCompression(threshold int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.compressAbove = threshold
+}
+
+// CompressionStats reports on the values compressed so far.
+func (d *Database) CompressionStats() CompressionStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.compression
+}
+
+// compress returns value compressed, or false if compressing it isn't
+// worthwhile.
+func (d *Database) compress(value string) (string, bool) {
+	if d.compressAbove <= 0 || len(value) <= d.compressAbove {
+		return "", false
+	}
+
+	encoder, _ := zstdCodec()
+	compressed := encoder.EncodeAll([]byte(value), nil)
+	if len(compressed) >= len(value) {
+		return "", false
+	}
+
+	d.compression.Values++
+	d.compression.Bytes += int64(len(value))
+	d.compression.CompressedBytes += int64(len(compressed))
+	return string(compressed), true
+}
+
+func decompress(compressed string) (string, error) {
+	_, decoder := zstdCodec()
+	value, err := decoder.DecodeAll([]byte(compressed), nil)
+	return string(value), err
+}
+
+// The uncompressed length of a compressed payload is kept in the inline
+// bytes: at the start for a blob, after the segment reference for a
+// segment.
+const (
+	compressedFlag    = 16
+	compressedLenBlob = 0
+	compressedLenRef  = 17
+)
+
+func compressedPayload(compressed string, length int) payload {
+	p := payload{blob: compressed, n: compressedBlobPayload}
+	binary.LittleEndian.PutUint32(p.inline[compressedLenBlob:], uint32(length))
+	return p
+}
+
+func (p payload) compressedLen(at int) int {
+	return int(binary.LittleEndian.Uint32(p.inline[at:]))
+}
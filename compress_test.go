@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	database := newDatabase()
+	database.UseCompression(64)
+
+	json := `{"name": "widget", "tags": [` + strings.Repeat(`"tag", `, 100) + `"last"]}`
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"short", strings.Repeat("x", 64)})
+	c.mustExecCommand("set", []string{"json", json})
+
+	assertEq(database.versions("short")[0].data.n, uint8(blobPayload), "short value stored as is")
+	v := database.versions("json")[0]
+	assertEq(v.data.n, uint8(compressedBlobPayload), "long value compressed")
+	assertEq(v.data.Len(), len(json), "uncompressed length")
+	assertEq(c.mustExecCommand("get", []string{"json"}), json, "read back")
+
+	stats := database.CompressionStats()
+	assertEq(stats.Values, 1, "values compressed")
+	assertEq(stats.Bytes, int64(len(json)), "bytes before")
+	assert(stats.Ratio() < 0.2, "compressed well")
+
+	// Incompressible values are left alone.
+	random := "7f3a9c01e4b2d8567a0c3e9f1b2d4a6c8e0f2a4b6c8d0e2f4a6b8c0d2e4f6a8b0c2d4e6f"
+	c.mustExecCommand("set", []string{"random", random})
+	assertEq(database.versions("random")[0].data.n, uint8(blobPayload), "incompressible value")
+}
+
+func TestCompression_segments(t *testing.T) {
+	database := newDatabase()
+	database.UseSegmentStore(t.TempDir(), 1024)
+	database.UseCompression(64)
+	defer database.Close()
+
+	value := strings.Repeat("compressible ", 100)
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", value})
+
+	v := database.versions("x")[0]
+	assertEq(v.data.n, uint8(segmentPayload), "compressed value in a segment")
+	assertEq(v.data.Len(), len(value), "uncompressed length")
+
+	// Compaction moves the compressed bytes along with their flag.
+	database.mu.Lock()
+	database.segments.rotate()
+	n, err := database.compactSegments(0)
+	database.mu.Unlock()
+	assertEq(err, nil, "compact")
+	assertEq(n, 1, "segments compacted")
+	assertEq(database.versions("x")[0].data.segmentRef().segment, uint32(2), "value moved")
+	assertEq(c.mustExecCommand("get", []string{"x"}), value, "read back after compaction")
+}
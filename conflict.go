@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+A commit refused because of a conflict fails with a ConflictError, which
+says which concurrent transaction got there first and which keys the two
+clashed over, so whoever is chasing contention knows where to look.
+
+Only the first transaction found to conflict is named. There may be
+others, but any one of them was enough to refuse the commit.
+*/
+
+// Keys named in a ConflictError's message, at most.
+const maxConflictKeys = 8
+
+// A ConflictError is why a commit was refused: a transaction that ran
+// concurrently committed first, having written keys this one read or
+// wrote, or read keys this one wrote.
+type ConflictError struct {
+	// "write-write" under Snapshot Isolation, "read-write" under
+	// Serializable.
+	Kind string
+	// The transaction refused, and the one that committed first, or else
+	// the one of higher priority it yielded to.
+	TxId   uint64
+	Winner uint64
+	// The keys the two clashed over, in order.
+	Keys []string
+	// Why the commit was refused, a line at a time, for explain conflict
+	// (see explain.go).
+	Explanation []string
+}
+
+func (e *ConflictError) Error() string {
+	keys := e.Keys
+	more := ""
+	if len(keys) > maxConflictKeys {
+		more = fmt.Sprintf(" and %d more", len(keys)-maxConflictKeys)
+		keys = keys[:maxConflictKeys]
+	}
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = fmt.Sprintf("%q", key)
+	}
+	return fmt.Sprintf("%s conflict with transaction %d on %s%s", e.Kind, e.Winner, strings.Join(quoted, ", "), more)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestConflictError(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	for i := range 10 {
+		key := fmt.Sprintf("k%d", i)
+		t1.Set(key, "t1")
+		t2.Set(key, "t2")
+	}
+	t2.Set("other", "t2")
+	assertEq(t1.Commit(), nil, "t1 commit")
+
+	err := t2.Commit()
+	var conflict *ConflictError
+	assert(errors.As(err, &conflict), "ConflictError")
+	assertEq(conflict.Kind, "write-write", "kind")
+	assertEq(conflict.TxId, t2.id, "refused")
+	assertEq(conflict.Winner, t1.id, "winner")
+	assertEq(len(conflict.Keys), 10, "keys")
+	assertEq(conflict.Keys[0], "k0", "keys in order")
+	assertEq(err.Error(), `write-write conflict with transaction 1 on "k0", "k1", "k2", "k3", "k4", "k5", "k6", "k7" and 2 more`, "message")
+}
+
+func TestConflictError_serializable(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SerializableIsolation
+
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	t1.Get("x")
+	t1.Set("x", "t1")
+	t1.Get("y")
+	t2.Get("x")
+	t2.Set("x", "t2")
+	t2.Set("y", "t2")
+	assertEq(t1.Commit(), nil, "t1 commit")
+
+	// x is read and written by both, but named once.
+	var conflict *ConflictError
+	assert(errors.As(t2.Commit(), &conflict), "ConflictError")
+	assertEq(conflict.Kind, "read-write", "kind")
+	assertEq(fmt.Sprint(conflict.Keys), "[x y]", "keys")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+/*
+incr conflicts like any other write: two transactions incrementing one
+key under Snapshot Isolation can't both commit, and at weaker levels one
+increment can be lost. Counters are for tallies that are incremented far
+more often than they are read exactly, like page views: they are a value
+type of their own (see types.go) whose increments commute, so they never
+conflict and are never lost, at any isolation level.
+
+Each version of a counter holds its value, as the transaction that wrote
+it sees it, and the sum of the increments that transaction has made to
+it. When the transaction commits, if another has committed an increment
+since the value it added to, it writes the sum of its increments to the
+newer value instead, and commits that. The increments aren't reads, so
+they don't add the counter to the readset either, and Serializable
+isolation doesn't see them as conflicts.
+
+Reading a counter reads its value as the transaction sees it, as with any
+other value. An increment by a prepared transaction (see prepare.go)
+isn't committed yet, so there is nothing to add to, and a transaction
+incrementing the same counter fails with a conflict.
+*/
+
+func encodeCounter(value, delta int64) string {
+	b := binary.BigEndian.AppendUint64(nil, uint64(value))
+	return string(binary.BigEndian.AppendUint64(b, uint64(delta)))
+}
+
+func decodeCounter(raw string) (value, delta int64) {
+	return decodeInt(raw[:8]), decodeInt(raw[8:])
+}
+
+// AddCounter adds delta to the counter held by key, returning its value as
+// the transaction sees it. A missing key counts as a counter at zero.
+func (t *Transaction) AddCounter(key string, delta int64) (int64, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, err
+	}
+
+	var n, sum int64
+	if value := t.visible(key); value != nil {
+		if value.data.kind != CounterType {
+			return 0, wrongType(key, value.data.kind, CounterType)
+		}
+		raw, err := t.db.readRaw(value)
+		if err != nil {
+			return 0, err
+		}
+		n, sum = decodeCounter(raw)
+		if !t.owns(value.txStartId) {
+			// The increments in it are someone else's.
+			sum = 0
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, fmt.Errorf("increment of %s would overflow", key)
+	}
+
+	t.write(key, encodeCounter(n+delta, sum+delta), CounterType, 0)
+	return n + delta, nil
+}
+
+// mergeCounters adds the increments t made to counters to whatever other
+// transactions committed since, returning the counters t wrote, which
+// mustn't be taken for conflicts.
+func (d *Database) mergeCounters(t *Transaction) (map[string]bool, error) {
+	// Most commits have no counters, and reading a nil map is fine.
+	var counters map[string]bool
+	iter := t.writeset.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		key := iter.Key()
+		ours, theirs, holder := d.counterVersions(t, key)
+		if ours == nil {
+			continue
+		}
+		if holder != nil {
+			return nil, t.conflict("write-write", holder, []string{key})
+		}
+		if counters == nil {
+			counters = map[string]bool{}
+		}
+		counters[key] = true
+
+		raw, err := d.readRaw(ours)
+		if err != nil {
+			return nil, err
+		}
+		value, sum := decodeCounter(raw)
+		var committed int64
+		if theirs != nil {
+			if theirs.data.kind != CounterType {
+				// Replaced by something that isn't a counter, which
+				// is a conflict like any other.
+				delete(counters, key)
+				continue
+			}
+			if raw, err = d.readRaw(theirs); err != nil {
+				return nil, err
+			}
+			committed, _ = decodeCounter(raw)
+		}
+		if value-sum != committed {
+			t.debug("merged counter increments", "key", key)
+			t.supersede(key, encodeCounter(committed+sum, sum), CounterType, 0)
+		}
+	}
+	return counters, nil
+}
+
+// counterVersions returns t's newest version of key, if it is a counter,
+// the newest version committed by anyone else that hasn't been replaced
+// by another committed one, and a prepared transaction that has written
+// key, if there is one.
+func (d *Database) counterVersions(t *Transaction, key string) (ours, theirs *Value, holder *Transaction) {
+	versions := d.versions(key)
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := &versions[i]
+		if t.owns(v.txStartId) {
+			if ours == nil && !t.owns(v.txEndId) {
+				ours = v
+				if ours.data.kind != CounterType {
+					// Nothing to merge, so the rest needn't be looked at.
+					return nil, nil, nil
+				}
+			}
+			continue
+		}
+		writer, _ := d.transactions.Get(v.txStartId)
+		if writer != nil && writer.prepared != "" {
+			holder = writer
+		}
+		if theirs == nil && d.transactionState(v.txStartId) == CommittedTransaction &&
+			(v.txEndId == 0 || t.owns(v.txEndId) || d.transactionState(v.txEndId) != CommittedTransaction) {
+			theirs = v
+		}
+	}
+	if ours == nil || ours.data.kind != CounterType {
+		return nil, nil, nil
+	}
+	return ours, theirs, holder
+}
+
+// cincr key [delta]
+// cdecr key [delta]
+func (c *Connection) cincr(command string, args []string) (Result, error) {
+	delta := int64(1)
+	if len(args) > 1 {
+		var err error
+		delta, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return Result{}, fmt.Errorf("%s: delta %w", command, ErrNotInteger)
+		}
+	}
+	if command == "cdecr" {
+		if delta == math.MinInt64 {
+			return Result{}, fmt.Errorf("decrement of %s would overflow", args[0])
+		}
+		delta = -delta
+	}
+
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	n, err := c.tx.AddCounter(args[0], delta)
+	if err != nil {
+		return res, err
+	}
+	res.Value = strconv.FormatInt(n, 10)
+	return res, nil
+}
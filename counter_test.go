@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	for _, isolation := range []IsolationLevel{ReadCommitedIsolation, RepeatableReadIsolation, SnapshotIsolation, SerializableIsolation} {
+		database := newDatabase()
+		database.defaultIsolation = isolation
+		c := database.newConnection()
+
+		assertEq(c.mustExecCommand("cincr", []string{"views"}), "1", "missing counts as zero")
+		assertEq(c.mustExecCommand("cincr", []string{"views", "10"}), "11", "cincr by")
+		assertEq(c.mustExecCommand("cdecr", []string{"views"}), "10", "cdecr")
+		assertEq(c.mustExecCommand("type", []string{"views"}), "counter", "type")
+
+		// Concurrent increments all count, and neither conflicts.
+		c1, c2 := database.newConnection(), database.newConnection()
+		c1.mustExecCommand("begin", nil)
+		c2.mustExecCommand("begin", nil)
+		assertEq(c1.mustExecCommand("cincr", []string{"views", "5"}), "15", "first sees its own")
+		c1.mustExecCommand("cincr", []string{"views"})
+		assertEq(c2.mustExecCommand("cincr", []string{"views", "100"}), "110", "second sees its own")
+		c1.mustExecCommand("commit", nil)
+		c2.mustExecCommand("commit", nil)
+		assertEq(c.mustExecCommand("get", []string{"views"}), "116", isolation.String()+": both count")
+
+		_, err := c.execCommand("cincr", []string{"views", "x"})
+		assert(errors.Is(err, ErrNotInteger), "bad delta")
+		c.mustExecCommand("set", []string{"s", "text"})
+		_, err = c.execCommand("cincr", []string{"s"})
+		assert(errors.Is(err, ErrWrongType), "not a counter")
+	}
+}
+
+func TestCounter_incrStillConflicts(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	t1.Incr("n", 1)
+	t2.Incr("n", 1)
+	assertEq(t1.Commit(), nil, "first")
+	var conflict *ConflictError
+	assert(errors.As(t2.Commit(), &conflict), "plain increments conflict")
+
+	// So does a set racing an increment.
+	t1, _ = database.Begin()
+	t2, _ = database.Begin()
+	t1.AddCounter("c", 1)
+	t2.Set("c", "replaced")
+	assertEq(t2.Commit(), nil, "set")
+	assert(errors.As(t1.Commit(), &conflict), "increment of a replaced counter")
+}
+
+func TestCounter_savepoint(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	tx, _ := database.Begin()
+	other, _ := database.Begin()
+	tx.AddCounter("c", 1)
+	tx.Savepoint("a")
+	tx.AddCounter("c", 10)
+	assertEq(tx.RollbackTo("a"), nil, "rollback")
+	n, _ := tx.AddCounter("c", 100)
+	assertEq(n, int64(101), "rolled back increment is gone")
+	other.AddCounter("c", 1000)
+	assertEq(other.Commit(), nil, "other")
+	assertEq(tx.Commit(), nil, "commit")
+
+	tx, _ = database.Begin()
+	n, _ = tx.AddCounter("c", 0)
+	assertEq(n, int64(1101), "merged")
+}
+
+func TestCounter_prepared(t *testing.T) {
+	database := newDatabase()
+
+	prepared, _ := database.Begin()
+	later, _ := database.Begin()
+	prepared.AddCounter("c", 1)
+	assertEq(prepared.Prepare("g"), nil, "prepare")
+	later.AddCounter("c", 1)
+	var conflict *ConflictError
+	assert(errors.As(later.Commit(), &conflict), "nothing committed to add to")
+	assertEq(database.CommitPrepared("g"), nil, "commit prepared")
+}
+
+func TestCounter_recovery(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+	database.defaultIsolation = SnapshotIsolation
+
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	t1.AddCounter("c", 2)
+	t2.AddCounter("c", 3)
+	assertEq(t1.Commit(), nil, "first")
+	assertEq(t2.Commit(), nil, "second")
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	c := database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"c"}), "5", "merged increments survive")
+	assertEq(c.mustExecCommand("cincr", []string{"c"}), "6", "still a counter")
+}
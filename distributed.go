@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+A cluster transaction that touched several shards commits through their
+two-phase commit (see prepare.go), with the cluster as coordinator. It
+prepares the transaction on each shard in turn, under one global id; if
+any refuses, it rolls back those already prepared and aborts the rest.
+Once all are prepared, it records its decision to commit in its own log,
+syncing it, and then commits the prepared transactions. The decision is
+the commit point: whatever happens after it, the transaction commits on
+every shard, and before it, on none.
+
+When a cluster is opened, every shard has already recovered its prepared
+transactions. The ones prepared by the cluster, which it tells by their
+global ids, are committed if the coordinator's log has the decision, and
+rolled back otherwise: a coordinator that died before deciding can't have
+told anyone the transaction committed. Then the log starts over, empty.
+While the cluster runs, the log also notes each transaction that has
+committed everywhere, and starts over whenever no decision is
+outstanding, so it stays short.
+
+The shards' transactions begin when the cluster transaction first
+touches each shard, so a transaction spanning shards doesn't see one
+snapshot of all of them: only of each shard on its own, as of when it
+first read or wrote there. What it commits is atomic all the same.
+
+A cluster in memory keeps no log: there is nothing to recover.
+*/
+
+// The prefix of the global ids the cluster prepares transactions under.
+const clusterGidPrefix = "cluster-"
+
+const coordinatorFile = "coordinator"
+
+// The kinds of coordinator log record.
+const (
+	// The decision to commit a global id.
+	coordinatorCommit = 'c'
+	// A global id committed on every shard.
+	coordinatorDone = 'd'
+)
+
+// coordinatorLog is where a cluster records its decisions.
+type coordinatorLog struct {
+	mu sync.Mutex
+	f  *os.File
+	// The decisions not yet carried out on every shard.
+	outstanding int
+}
+
+// openCoordinatorLog opens the log at path, returning the global ids it
+// has decided to commit and not seen done.
+func openCoordinatorLog(path string) (*coordinatorLog, map[string]bool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decided := map[string]bool{}
+	for {
+		body, _, err := readFrame(f)
+		// A crash can leave a partial record at the end, which is of a
+		// decision that was never made.
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		br := frameReader{b: body}
+		kind, gid := br.byte(), br.string()
+		if err == nil {
+			err = br.done()
+		}
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("coordinator log: %w", err)
+		}
+		if kind == coordinatorCommit {
+			decided[gid] = true
+		} else {
+			delete(decided, gid)
+		}
+	}
+	return &coordinatorLog{f: f}, decided, nil
+}
+
+func (l *coordinatorLog) append(kind byte, gid string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.f.Write(appendFrame(nil, frameBody{kind}.string(gid))); err != nil {
+		return err
+	}
+	if kind == coordinatorCommit {
+		l.outstanding++
+		return l.f.Sync()
+	}
+	if l.outstanding--; l.outstanding == 0 {
+		return l.reset()
+	}
+	return nil
+}
+
+// reset empties the log.
+func (l *coordinatorLog) reset() error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.f.Seek(0, io.SeekStart)
+	return err
+}
+
+func (l *coordinatorLog) close() error {
+	return l.f.Close()
+}
+
+// recoverPrepared settles the transactions the cluster prepared before
+// it was last closed: those in decided commit, and the rest roll back.
+func (c *Cluster) recoverPrepared(decided map[string]bool) error {
+	var errs []error
+	for i, d := range c.shards {
+		for _, gid := range d.Prepared() {
+			if !strings.HasPrefix(gid, clusterGidPrefix) {
+				continue
+			}
+			var err error
+			if decided[gid] {
+				err = d.CommitPrepared(gid)
+			} else {
+				err = d.RollbackPrepared(gid)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("cluster: shard %d: %w", i, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return c.coordinator.reset()
+}
+
+// prepare prepares the transaction on each shard it touched, returning
+// the global id it prepared them under. If a shard refuses, the
+// transaction is rolled back or aborted on every shard.
+func (t *ClusterTransaction) prepare() (string, error) {
+	gid := clusterGidPrefix + strconv.FormatUint(t.cluster.lastGid.Add(1), 10)
+	shards := t.Shards()
+	for n, i := range shards {
+		err := t.txs[i].Prepare(gid)
+		if err == nil {
+			continue
+		}
+		// The shard that refused has aborted its part already.
+		for _, j := range shards[:n] {
+			t.cluster.shards[j].RollbackPrepared(gid)
+		}
+		for _, j := range shards[n+1:] {
+			t.txs[j].Abort()
+		}
+		return "", fmt.Errorf("cluster: shard %d: %w", i, err)
+	}
+	return gid, nil
+}
+
+// commitPrepared decides to commit gid, prepared on shards, and commits
+// it on each of them.
+func (c *Cluster) commitPrepared(gid string, shards []int) error {
+	if c.coordinator != nil {
+		if err := c.coordinator.append(coordinatorCommit, gid); err != nil {
+			// Without the decision on record, the transaction can't
+			// commit.
+			for _, i := range shards {
+				c.shards[i].RollbackPrepared(gid)
+			}
+			return fmt.Errorf("coordinator log: %w", err)
+		}
+	}
+
+	var errs []error
+	for _, i := range shards {
+		if err := c.shards[i].CommitPrepared(gid); err != nil {
+			errs = append(errs, fmt.Errorf("cluster: shard %d: %w", i, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if c.coordinator != nil {
+		if err := c.coordinator.append(coordinatorDone, gid); err != nil {
+			return fmt.Errorf("coordinator log: %w", err)
+		}
+	}
+	return nil
+}
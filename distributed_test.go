@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCluster_crossShard(t *testing.T) {
+	c := NewCluster(3)
+	c.Shards()[0].defaultIsolation = SnapshotIsolation
+	a, b := keyInShard(c, "a", 0), keyInShard(c, "b", 1)
+
+	// A transaction across shards commits on all of them.
+	tx := c.Begin()
+	tx.Set(a, "1")
+	tx.Set(b, "1")
+	assertEq(fmt.Sprint(tx.Shards()), "[0 1]", "shards")
+	assertEq(tx.Commit(), nil, "commit")
+	for _, key := range []string{a, b} {
+		value, _ := c.Get(key)
+		assertEq(value, "1", "committed "+key)
+	}
+
+	// Or on none, if any shard refuses.
+	tx = c.Begin()
+	tx.Set(b, "2")
+	tx.Set(a, "2")
+	c.Set(a, "meanwhile")
+	err := tx.Commit()
+	var conflict *ConflictError
+	assert(errors.As(err, &conflict), "conflict in shard 0")
+	value, _ := c.Get(b)
+	assertEq(value, "1", "rolled back in shard 1")
+	for _, d := range c.Shards() {
+		assertEq(len(d.Prepared()), 0, "nothing left prepared")
+	}
+
+	tx = c.Begin()
+	tx.Set(a, "3")
+	tx.Delete(b)
+	assertEq(tx.Abort(), nil, "abort")
+	value, _ = c.Get(a)
+	assertEq(value, "meanwhile", "aborted")
+}
+
+// crashCluster drops the cluster as if the process had been killed.
+func crashCluster(c *Cluster) {
+	for _, d := range c.Shards() {
+		crash(d)
+	}
+	c.coordinator.close()
+}
+
+func TestCluster_coordinatorCrash(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenCluster(dir, 2)
+	assertEq(err, nil, "open")
+	a, b := keyInShard(c, "a", 0), keyInShard(c, "b", 1)
+
+	// Killed between preparing and deciding: the transaction never
+	// happened.
+	tx := c.Begin()
+	tx.Set(a, "undecided")
+	tx.Set(b, "undecided")
+	_, err = tx.prepare()
+	assertEq(err, nil, "prepare")
+	crashCluster(c)
+
+	c, err = OpenCluster(dir, 2)
+	assertEq(err, nil, "reopen")
+	for _, key := range []string{a, b} {
+		_, err = c.Get(key)
+		assertEq(err, ErrKeyNotFound, "rolled back "+key)
+	}
+
+	// Killed after deciding, before committing anywhere: it commits on
+	// every shard once the cluster is back.
+	tx = c.Begin()
+	tx.Set(a, "decided")
+	tx.Set(b, "decided")
+	gid, err := tx.prepare()
+	assertEq(err, nil, "prepare")
+	assertEq(c.coordinator.append(coordinatorCommit, gid), nil, "decide")
+	assertEq(c.Shards()[0].CommitPrepared(gid), nil, "commit in one shard")
+	crashCluster(c)
+
+	c, err = OpenCluster(dir, 2)
+	assertEq(err, nil, "reopen")
+	defer c.Close()
+	for _, key := range []string{a, b} {
+		value, err := c.Get(key)
+		assertEq(err, nil, "committed "+key)
+		assertEq(value, "decided", "committed "+key)
+	}
+	for _, d := range c.Shards() {
+		assertEq(len(d.Prepared()), 0, "nothing left prepared")
+	}
+
+	// Transactions of the cluster's own leave the log empty.
+	tx = c.Begin()
+	tx.Set(a, "again")
+	tx.Set(b, "again")
+	assertEq(tx.Commit(), nil, "commit")
+	info, _ := c.coordinator.f.Stat()
+	assertEq(info.Size(), int64(0), "log emptied")
+}
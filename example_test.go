@@ -0,0 +1,96 @@
+package main
+
+import "fmt"
+
+func ExampleDatabase_Begin() {
+	database := newDatabase()
+
+	tx, _ := database.Begin()
+	tx.Set("x", "hey")
+	tx.Commit()
+
+	// A transaction started afterwards sees the committed write.
+	tx, _ = database.Begin()
+	value, _ := tx.Get("x")
+	fmt.Println(value)
+	// Output: hey
+}
+
+func ExampleTransaction_Get() {
+	database := newDatabase()
+
+	writer, _ := database.Begin()
+	writer.Set("x", "hey")
+
+	// Under Read Committed, uncommitted writes are invisible to others.
+	reader, _ := database.Begin()
+	_, err := reader.Get("x")
+	fmt.Println(err)
+
+	writer.Commit()
+
+	value, _ := reader.Get("x")
+	fmt.Println(value)
+	// Output:
+	// cannot get key that does not exist
+	// hey
+}
+
+func ExampleTransaction_Delete() {
+	database := newDatabase()
+
+	tx, _ := database.Begin()
+	tx.Set("x", "hey")
+	tx.Delete("x")
+
+	_, err := tx.Get("x")
+	fmt.Println(err)
+
+	err = tx.Delete("x")
+	fmt.Println(err)
+	// Output:
+	// cannot get key that does not exist
+	// cannot delete key that does not exist
+}
+
+func ExampleTransaction_Abort() {
+	database := newDatabase()
+
+	tx, _ := database.Begin()
+	tx.Set("x", "hey")
+	tx.Abort()
+
+	tx, _ = database.Begin()
+	_, err := tx.Get("x")
+	fmt.Println(err)
+	// Output: cannot get key that does not exist
+}
+
+func ExampleTransaction_Commit() {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+
+	t1.Set("x", "from t1")
+	t2.Set("x", "from t2")
+
+	// The first committer wins; the second is aborted.
+	fmt.Println(t1.Commit())
+	fmt.Println(t2.Commit())
+	// Output:
+	// <nil>
+	// write-write conflict with transaction 1 on "x"
+}
+
+func Example_connection() {
+	database := newDatabase()
+
+	c := database.newConnection()
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "hey"})
+	fmt.Println(c.mustExecCommand("get", []string{"x"}))
+	c.mustExecCommand("commit", nil)
+	// Output: hey
+}
@@ -0,0 +1,73 @@
+package main
+
+import "strconv"
+
+/*
+exists is a get that doesn't return the value. dbsize counts the keys
+with a version visible to the transaction, and since that count depends
+on the snapshot there's no single number the database could keep up to
+date: every transaction could get a different answer, all correct. So
+dbsize walks the whole keyspace through the usual visibility rules,
+exactly as a full scan would (readset included), and costs as much.
+*/
+
+// Exists reports whether key has a version visible to the transaction.
+func (t *Transaction) Exists(key string) (bool, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return false, err
+	}
+
+	_, err := t.get(key)
+	return err == nil, nil
+}
+
+// Size counts the keys with a version visible to the transaction.
+func (t *Transaction) Size() (int, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, err
+	}
+
+	return t.size("", ""), nil
+}
+
+// size counts the visible keys in [start, end).
+func (t *Transaction) size(start, end string) int {
+	n := 0
+	t.scan(start, end, Ascending, func(string, *Value) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// exists key
+func (c *Connection) exists(args []string) (Result, error) {
+	ok, err := c.tx.Exists(args[0])
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Keys: args[:1], TxId: c.tx.id, Value: "0"}
+	if ok {
+		res.Value = "1"
+	}
+	return res, nil
+}
+
+// dbsize
+func (c *Connection) dbsize() (Result, error) {
+	n, err := c.tx.Size()
+	if c.bucket != nil {
+		n, err = c.bucket.Size(c.tx)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Value: strconv.Itoa(n), TxId: c.tx.id}, nil
+}
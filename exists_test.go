@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestExistsAndDBSize(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = RepeatableReadIsolation
+	database.Set("a", "1")
+	database.Set("b", "2")
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+
+	database.Set("c", "3")
+	database.Delete("a")
+
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+	c2.mustExecCommand("set", []string{"d", "4"})
+
+	// c1's snapshot predates the set of c and delete of a.
+	assertEq(c1.mustExecCommand("dbsize", nil), "2", "c1 dbsize")
+	assertEq(c1.mustExecCommand("exists", []string{"a"}), "1", "c1 exists a")
+	assertEq(c1.mustExecCommand("exists", []string{"c"}), "0", "c1 exists c")
+
+	// c2 sees both, and its own uncommitted d.
+	assertEq(c2.mustExecCommand("dbsize", nil), "3", "c2 dbsize")
+	assertEq(c2.mustExecCommand("exists", []string{"a"}), "0", "c2 exists a")
+	assertEq(c2.mustExecCommand("exists", []string{"d"}), "1", "c2 exists d")
+
+	// Autocommitted, outside of any snapshot the others hold.
+	c3 := database.newConnection()
+	assertEq(c3.mustExecCommand("dbsize", nil), "2", "c3 dbsize")
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+explain answers the two questions newcomers to MVCC ask most: why did my
+get return that, and why was my commit refused?
+
+explain get key walks key's version chain as a get in the connection's
+transaction would, newest first, and says of every version it examines
+who wrote it and who ended it, what became of them, whether the writer
+was still running when the transaction's snapshot was taken, and so
+whether the transaction sees the version, and why not if it doesn't. It
+marks the version the get returns, and says where the walk stopped, if it
+stopped before the oldest version. It doesn't record the read: explaining
+a get can't make a commit conflict.
+
+explain conflict goes over the last conflict a commit of the
+connection's was refused for: which transaction won and how the two
+overlapped, which of them read and which wrote each key they clashed
+over, and the rule of the isolation level that refused the commit. It is
+worked out when the commit is refused, since the winner may be forgotten
+by the time anyone asks.
+*/
+
+// A visibility is whether a transaction sees a version of a key, and if
+// not, why not. See Database.visibility.
+type visibility uint8
+
+const (
+	versionVisible visibility = iota
+	// Under Read Uncommitted.
+	versionEnded
+	// Under Repeatable Read and stricter.
+	writerStartedLater
+	writerRunningAtSnapshot
+	writerUncommitted
+	endedByOwn
+	endedBeforeSnapshot
+	// Under Read Committed.
+	endedCommitted
+	// Once the transaction has written the key (see ownOnly).
+	notOwnWrite
+)
+
+var visibilityReasons = []string{
+	versionVisible:          "visible",
+	versionEnded:            "ended, by a delete or a newer version",
+	writerStartedLater:      "written by a transaction that began after the snapshot was taken",
+	writerRunningAtSnapshot: "written by a transaction in progress when the snapshot was taken",
+	writerUncommitted:       "written by a transaction that hasn't committed",
+	endedByOwn:              "ended by this transaction",
+	endedBeforeSnapshot:     "ended by a transaction that committed before the snapshot was taken",
+	endedCommitted:          "ended by a committed transaction",
+	notOwnWrite:             "not this transaction's, which has written the key and sees only its own versions of it",
+}
+
+func (v visibility) String() string {
+	if int(v) < len(visibilityReasons) {
+		return visibilityReasons[v]
+	}
+	return fmt.Sprintf("visibility(%d)", uint8(v))
+}
+
+// A VersionDecision is what a get made of one version of a key.
+type VersionDecision struct {
+	TxStartId uint64
+	TxEndId   uint64
+	// The states of the transactions that wrote and ended the version,
+	// the latter only if it has been ended.
+	Writer TransactionState
+	Ender  TransactionState
+	// Whether the writer was in progress when the transaction's snapshot
+	// was taken. Read Committed and Read Uncommitted take none.
+	WriterRunning bool
+	Visible       bool
+	// Why the version isn't visible, if it isn't.
+	Reason string
+	// Whether the get returned the version, and its value if so.
+	Returned bool
+	Value    string
+	// Whether the version was visible but had expired, so the get found
+	// nothing.
+	Expired bool
+}
+
+// A GetExplanation is how a get of Key in transaction TxId went.
+type GetExplanation struct {
+	Key       string
+	TxId      uint64
+	Isolation IsolationLevel
+	// The snapshot the transaction reads, and the transactions in progress
+	// when it was taken, for Repeatable Read and stricter.
+	Snapshot uint64
+	Running  []uint64
+	// Whether the bloom filter (see bloom.go) ruled the key out, so no
+	// version was examined.
+	RuledOut bool
+	// The versions examined, newest first.
+	Versions []VersionDecision
+	// How many older versions weren't examined: the walk stops at the
+	// version it returns, or at one ended by a transaction whose commit
+	// the get sees, as every older version was ended by one too (see
+	// sealEnds).
+	Skipped int
+}
+
+// ExplainGet explains what a get of key in the transaction would return,
+// and why. Unlike a get, it doesn't record the read.
+func (t *Transaction) ExplainGet(key string) (GetExplanation, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return GetExplanation{}, err
+	}
+	return t.explainGet(key)
+}
+
+func (t *Transaction) explainGet(key string) (GetExplanation, error) {
+	d := t.db
+	e := GetExplanation{Key: key, TxId: t.id, Isolation: t.isolation}
+	snapshotted := t.isolation >= RepeatableReadIsolation
+	if snapshotted {
+		e.Snapshot = t.root().id
+		e.Running = slices.Clone(t.inprogress.running)
+	}
+
+	if d.bloom != nil && !d.bloom.mayContain(key) {
+		e.RuledOut = true
+		return e, nil
+	}
+
+	// As visibleIn does.
+	versions := d.versions(key)
+	ownOnly := t.ownOnly(key)
+	sealed := !d.tangled.Contains(key)
+	for i := len(versions) - 1; i >= 0; i-- {
+		value := &versions[i]
+		why := d.visibility(t, *value)
+		if why == versionVisible && ownOnly && !t.owns(value.txStartId) {
+			why = notOwnWrite
+		}
+		decision := VersionDecision{
+			TxStartId:     value.txStartId,
+			TxEndId:       value.txEndId,
+			Writer:        d.transactionState(value.txStartId),
+			WriterRunning: snapshotted && t.inprogress.contains(value.txStartId),
+			Visible:       why == versionVisible,
+		}
+		if value.txEndId != 0 {
+			decision.Ender = d.transactionState(value.txEndId)
+		}
+
+		if decision.Visible {
+			decision.Expired = t.expired(value)
+			if !decision.Expired {
+				decision.Returned = true
+				var err error
+				if decision.Value, err = d.read(value); err != nil {
+					return GetExplanation{}, err
+				}
+			}
+			e.Versions = append(e.Versions, decision)
+			e.Skipped = i
+			return e, nil
+		}
+		decision.Reason = why.String()
+		e.Versions = append(e.Versions, decision)
+		if sealed && t.sealedBy(value) {
+			e.Skipped = i
+			break
+		}
+	}
+	return e, nil
+}
+
+// Lines returns the explanation as text, a line at a time.
+func (e GetExplanation) Lines() []string {
+	var lines []string
+	if e.Isolation >= RepeatableReadIsolation {
+		running := "none"
+		if len(e.Running) > 0 {
+			ids := make([]string, len(e.Running))
+			for i, id := range e.Running {
+				ids[i] = fmt.Sprint(id)
+			}
+			running = strings.Join(ids, " ")
+		}
+		lines = append(lines, fmt.Sprintf("transaction %d (%s) reads the snapshot taken at %d, with in progress: %s",
+			e.TxId, e.Isolation, e.Snapshot, running))
+	} else {
+		lines = append(lines, fmt.Sprintf("transaction %d (%s) reads what is committed now", e.TxId, e.Isolation))
+	}
+
+	if e.RuledOut {
+		return append(lines, "the bloom filter rules the key out: not found")
+	}
+	if len(e.Versions) == 0 {
+		return append(lines, "no versions: not found")
+	}
+
+	found := false
+	for _, v := range e.Versions {
+		line := fmt.Sprintf("start=%d (%s", v.TxStartId, v.Writer)
+		if v.WriterRunning {
+			line += ", running at the snapshot"
+		}
+		line += ")"
+		if v.TxEndId != 0 {
+			line += fmt.Sprintf(" end=%d (%s)", v.TxEndId, v.Ender)
+		} else {
+			line += " end=0"
+		}
+		switch {
+		case v.Returned:
+			line += fmt.Sprintf(": visible, returned %q", v.Value)
+			found = true
+		case v.Expired:
+			line += ": visible, but expired"
+		default:
+			line += ": not visible, " + v.Reason
+		}
+		lines = append(lines, line)
+	}
+
+	if !e.Versions[len(e.Versions)-1].Visible && e.Skipped > 0 {
+		lines = append(lines, fmt.Sprintf("stopped: the %d older versions were all ended by commits the transaction sees, so none is visible", e.Skipped))
+	}
+	if !found {
+		lines = append(lines, "no visible version: not found")
+	}
+	return lines
+}
+
+// explainConflict says, a line at a time, why t's commit was refused for
+// a conflict of kind with winner over keys.
+func (t *Transaction) explainConflict(kind string, winner *Transaction, keys []string) []string {
+	lines := []string{fmt.Sprintf("transaction %d (%s) could not commit: %s conflict with transaction %d",
+		t.id, t.isolation, kind, winner.id)}
+
+	var overlap string
+	switch {
+	case t.inprogress.contains(winner.id):
+		overlap = fmt.Sprintf("was in progress when transaction %d began", t.id)
+	case winner.id > t.id:
+		overlap = fmt.Sprintf("began after transaction %d did", t.id)
+	default:
+		overlap = fmt.Sprintf("ran alongside transaction %d", t.id)
+	}
+	switch {
+	case winner.state == CommittedTransaction:
+		lines = append(lines, fmt.Sprintf("transaction %d %s, and committed first", winner.id, overlap))
+	case winner.prepared != "":
+		lines = append(lines, fmt.Sprintf("transaction %d %s, and is prepared, which counts as committed", winner.id, overlap))
+	default:
+		lines = append(lines, fmt.Sprintf("transaction %d %s, and is still running, but transaction %d yields to it as of higher priority",
+			winner.id, overlap, t.id))
+	}
+
+	shown := keys[:min(len(keys), maxConflictKeys)]
+	for _, key := range shown {
+		lines = append(lines, fmt.Sprintf("%q: %s by %d, %s by %d",
+			key, accessTo(t, key), t.id, accessTo(winner, key), winner.id))
+	}
+	if len(keys) > len(shown) {
+		lines = append(lines, fmt.Sprintf("and %d more keys", len(keys)-len(shown)))
+	}
+
+	switch {
+	case kind == "read-write":
+		lines = append(lines, "under serializable isolation, a transaction may not commit having read a key a concurrent one wrote, "+
+			"or written a key it read: neither order of the two would have had both read what they did")
+	case t.isolation == SnapshotIsolation:
+		lines = append(lines, "under snapshot isolation, of two concurrent transactions writing the same key only the first to commit may: "+
+			"the other would overwrite a version it never saw")
+	default:
+		lines = append(lines, "a counter's increments merge with those committed since, but not with a prepared transaction's write, "+
+			"which may yet be rolled back")
+	}
+	return lines
+}
+
+// accessTo says how t used key.
+func accessTo(t *Transaction, key string) string {
+	read, wrote := t.readset.Contains(key), t.wrote(key)
+	switch {
+	case read && wrote:
+		return "read and written"
+	case wrote:
+		return "written"
+	case read:
+		return "read"
+	}
+	return "untouched"
+}
+
+// explain get key
+// explain conflict
+func (c *Connection) explain(args []string) (Result, error) {
+	switch {
+	case len(args) == 2 && args[0] == "get":
+		return c.explainGet(args[1])
+	case len(args) == 1 && args[0] == "conflict":
+		if c.conflict == nil {
+			return Result{}, errors.New("explain: no conflict to explain")
+		}
+		return Result{Value: strings.Join(c.conflict.Explanation, "\n"), TxId: c.conflict.TxId}, nil
+	}
+	return Result{}, errors.New("explain: expected explain get key, or explain conflict")
+}
+
+func (c *Connection) explainGet(key string) (Result, error) {
+	tx := c.tx
+	if tx == nil {
+		if !c.autocommit {
+			return Result{}, ErrNoTransaction
+		}
+		var err error
+		if tx, err = c.db.beginAt(c.context(), c.isolation()); err != nil {
+			return Result{}, err
+		}
+		defer tx.Commit()
+	}
+
+	e, err := tx.ExplainGet(key)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Value: strings.Join(e.Lines(), "\n"), TxId: tx.id}, nil
+}
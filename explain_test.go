@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplainGet(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "one"})
+	c1.mustExecCommand("commit", nil)
+
+	// 2 is running when 3 begins, and 4 begins after it.
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+	c3 := database.newConnection()
+	c3.mustExecCommand("begin", nil)
+	c2.mustExecCommand("set", []string{"x", "two"})
+	c2.mustExecCommand("commit", nil)
+	c4 := database.newConnection()
+	c4.mustExecCommand("begin", nil)
+	c4.mustExecCommand("set", []string{"x", "four"})
+	c4.mustExecCommand("commit", nil)
+
+	res := c3.mustExecCommand("explain", []string{"get", "x"})
+	assertEq(res, `transaction 3 (snapshot) reads the snapshot taken at 3, with in progress: 2
+start=4 (committed) end=0: not visible, written by a transaction that began after the snapshot was taken
+start=2 (committed, running at the snapshot) end=4 (committed): not visible, written by a transaction in progress when the snapshot was taken
+start=1 (committed) end=2 (committed): visible, returned "one"`, "explain get")
+	assert(!c3.tx.readset.Contains("x"), "explaining doesn't record the read")
+	assertEq(c3.mustExecCommand("get", []string{"x"}), "one", "get agrees")
+
+	// Once it has written the key, it sees only its own versions.
+	c3.mustExecCommand("delete", []string{"x"})
+	res = c3.mustExecCommand("explain", []string{"get", "x"})
+	assert(strings.Contains(res, `start=1 (committed) end=2 (committed): not visible, not this transaction's, which has written the key and sees only its own versions of it`), res)
+	assert(strings.HasSuffix(res, "no visible version: not found"), res)
+
+	res = c3.mustExecCommand("explain", []string{"get", "y"})
+	assertEq(res, `transaction 3 (snapshot) reads the snapshot taken at 3, with in progress: 2
+no versions: not found`, "explain get of a missing key")
+
+	// Outside a transaction, in one of its own.
+	res = c1.mustExecCommand("explain", []string{"get", "x"})
+	assert(strings.Contains(res, `start=4 (committed) end=0: visible, returned "four"`), res)
+	assertEq(c1.tx, (*Transaction)(nil), "no transaction left behind")
+}
+
+func TestExplainGet_stops(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = ReadCommitedIsolation
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "one"})
+	c.mustExecCommand("set", []string{"x", "two"})
+	c.mustExecCommand("delete", []string{"x"})
+
+	c.mustExecCommand("begin", nil)
+	res := c.mustExecCommand("explain", []string{"get", "x"})
+	assertEq(res, `transaction 4 (read-committed) reads what is committed now
+start=2 (committed) end=3 (committed): not visible, ended by a committed transaction
+stopped: the 1 older versions were all ended by commits the transaction sees, so none is visible
+no visible version: not found`, "explain get")
+}
+
+func TestExplainGet_bloom(t *testing.T) {
+	database := newDatabase()
+	database.apply(WithBloomFilter(0.01))
+
+	c := database.newConnection()
+	res := c.mustExecCommand("explain", []string{"get", "x"})
+	assert(strings.HasSuffix(res, "the bloom filter rules the key out: not found"), res)
+}
+
+func TestExplainConflict(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	c1 := database.newConnection()
+	c2 := database.newConnection()
+	_, err := c2.execCommand("explain", []string{"conflict"})
+	assert(err != nil, "nothing to explain yet")
+
+	c1.mustExecCommand("begin", nil)
+	c2.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "1"})
+	c2.mustExecCommand("set", []string{"x", "2"})
+	c1.mustExecCommand("commit", nil)
+	_, err = c2.execCommand("commit", nil)
+	assert(err != nil, "conflict")
+
+	res := c2.mustExecCommand("explain", []string{"conflict"})
+	assertEq(res, `transaction 2 (snapshot) could not commit: write-write conflict with transaction 1
+transaction 1 was in progress when transaction 2 began, and committed first
+"x": written by 2, written by 1
+under snapshot isolation, of two concurrent transactions writing the same key only the first to commit may: the other would overwrite a version it never saw`, "explain conflict")
+}
+
+func TestExplainConflict_serializable(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SerializableIsolation
+
+	c1 := database.newConnection()
+	c2 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c2.mustExecCommand("begin", nil)
+	c1.execCommand("get", []string{"x"})
+	c1.mustExecCommand("set", []string{"y", "1"})
+	c2.mustExecCommand("set", []string{"x", "2"})
+	c2.mustExecCommand("commit", nil)
+	_, err := c1.execCommand("commit", nil)
+	assert(err != nil, "conflict")
+
+	res := c1.mustExecCommand("explain", []string{"conflict"})
+	assertEq(res, `transaction 1 (serializable) could not commit: read-write conflict with transaction 2
+transaction 2 began after transaction 1 did, and committed first
+"x": read by 1, written by 2
+under serializable isolation, a transaction may not commit having read a key a concurrent one wrote, or written a key it read: neither order of the two would have had both read what they did`, "explain conflict")
+}
+
+func TestExplainArgs(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	for _, args := range [][]string{{"get"}, {"set", "x"}, {"conflict", "x"}} {
+		_, err := c.execCommand("explain", args)
+		assert(err != nil, strings.Join(args, " "))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	_ "modernc.org/sqlite"
+)
+
+/*
+Downstream analysts almost always want "just give me a SQLite file" for
+ad-hoc querying. ExportSQLite writes the keys and values visible at a
+single snapshot into a fresh SQLite database:
+
+	kv(key, value)                                  -- visible values
+	versions(key, tx_start, tx_end, value, state)   -- every version, optional
+	snapshot(txid)                                  -- the snapshot exported
+
+The snapshot is taken by a read-only Repeatable Read transaction, so writes
+that commit while the file is being written don't show up in it.
+*/
+
+type exportedVersion struct {
+	key string
+	Value
+	value string
+	state TransactionState
+}
+
+// ExportSQLite writes the database contents visible to a new snapshot into
+// a new SQLite database file at path. When history is set, every version
+// of every key is written as well, along with the outcome of the
+// transaction that wrote it.
+func (d *Database) ExportSQLite(path string, history bool) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("export destination %s already exists", path)
+	}
+
+	d.mu.Lock()
+	snapshot := d.newTransaction(context.Background())
+	snapshot.isolation = RepeatableReadIsolation
+	f := d.freeze()
+	d.mu.Unlock()
+
+	// Scanning a frozen store lets writers carry on (see frozen.go).
+	var visible [][2]string
+	var versions []exportedVersion
+	var readErr error
+	d.scanFrozen(f, snapshot, func(key string, chain []Value, value *Value) {
+		if readErr != nil {
+			return
+		}
+		if value != nil {
+			var s string
+			if s, readErr = d.read(value); readErr != nil {
+				return
+			}
+			visible = append(visible, [2]string{key, s})
+		}
+		if history {
+			for _, value := range chain {
+				s, err := d.read(&value)
+				if err != nil {
+					readErr = err
+					return
+				}
+				versions = append(versions, exportedVersion{
+					key:   key,
+					Value: value,
+					value: s,
+					state: d.transactionState(value.txStartId),
+				})
+			}
+		}
+	}, nil)
+
+	d.mu.Lock()
+	d.completeTransaction(snapshot, AbortedTransaction)
+	d.mu.Unlock()
+	if readErr != nil {
+		return readErr
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		"CREATE TABLE kv (key TEXT PRIMARY KEY, value TEXT NOT NULL)",
+		"CREATE TABLE versions (key TEXT NOT NULL, tx_start INTEGER NOT NULL, tx_end INTEGER, value TEXT NOT NULL, state TEXT NOT NULL)",
+		"CREATE TABLE snapshot (txid INTEGER NOT NULL)",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("INSERT INTO snapshot VALUES (?)", snapshot.id); err != nil {
+		return err
+	}
+
+	for _, kv := range visible {
+		if _, err := tx.Exec("INSERT INTO kv VALUES (?, ?)", kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range versions {
+		// A zero txEndId means the version was never superseded.
+		var txEnd any
+		if v.txEndId != 0 {
+			txEnd = v.txEndId
+		}
+		if _, err := tx.Exec("INSERT INTO versions VALUES (?, ?, ?, ?, ?)",
+			v.key, v.txStartId, txEnd, v.value, v.state.String()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
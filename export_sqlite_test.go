@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportSQLite(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "hey"})
+	c1.mustExecCommand("set", []string{"y", "yall"})
+	c1.mustExecCommand("commit", nil)
+
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "uncommitted"})
+
+	path := filepath.Join(t.TempDir(), "export.db")
+	assertEq(database.ExportSQLite(path, true), nil, "export")
+
+	err := database.ExportSQLite(path, true)
+	assert(err != nil, "export refuses to overwrite")
+
+	db, err := sql.Open("sqlite", path)
+	assertEq(err, nil, "open export")
+	defer db.Close()
+
+	var value string
+	db.QueryRow("SELECT value FROM kv WHERE key = 'x'").Scan(&value)
+	assertEq(value, "hey", "exported x")
+
+	var count int
+	db.QueryRow("SELECT count(*) FROM kv").Scan(&count)
+	assertEq(count, 2, "exported keys")
+
+	db.QueryRow("SELECT count(*) FROM versions WHERE key = 'x'").Scan(&count)
+	assertEq(count, 2, "exported versions of x")
+
+	var state string
+	db.QueryRow("SELECT state FROM versions WHERE value = 'uncommitted'").Scan(&state)
+	assertEq(state, "in-progress", "uncommitted version state")
+}
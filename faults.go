@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+The paths that handle a failed fsync, a write cut off by a crash, or a
+follower falling behind are hard to test, because those things don't
+happen on demand. A FaultInjector makes them happen: the write-ahead log
+and checkpoints ask it before every write and sync of their files, and a
+follower before it applies each record it replicates. Faults is one that
+injects the faults it is told to, each once, so a test can say exactly
+which operation goes wrong.
+
+What a failure leaves behind is what it would on a real machine. A torn
+write leaves the part of the record that got written at the end of the
+log, as a crash would, for opening the log to drop. A failed sync may
+have lost anything written since the last one that succeeded, so the log
+drops it, and the commit that was syncing fails. Either way the log
+fails every append and sync after it, until the database is reopened:
+nothing may commit on top of records that may not be there.
+*/
+
+// ErrInjectedFault is the error Faults fails operations with.
+var ErrInjectedFault = errors.New("injected fault")
+
+// ErrWALFailed is returned by a log that has failed to write or sync.
+var ErrWALFailed = errors.New("write-ahead log failed earlier")
+
+// A FaultInjector decides what goes wrong with the database's files,
+// named as they are in its data directory, and when its follower applies
+// records.
+type FaultInjector interface {
+	// BeforeWrite is called before p is written to file. It returns how
+	// much of p to write, and, if that is not all of it, the error to
+	// fail the write with.
+	BeforeWrite(file string, p []byte) (int, error)
+	// BeforeSync is called before file is synced, and returns the error
+	// to fail the sync with instead, if any.
+	BeforeSync(file string) error
+	// BeforeApply is called before a follower applies the record with
+	// lsn, and holds it up until it returns.
+	BeforeApply(lsn uint64)
+}
+
+// WithFaults has the database inject the faults fi decides on.
+func WithFaults(fi FaultInjector) Option {
+	return func(d *Database) {
+		d.faults = fi
+	}
+}
+
+// writeFile writes p to f, which is file, with whatever fault fi
+// injects.
+func writeFile(fi FaultInjector, file string, f io.Writer, p []byte) (int, error) {
+	if fi == nil {
+		return f.Write(p)
+	}
+	keep, injected := fi.BeforeWrite(file, p)
+	if keep >= len(p) {
+		return f.Write(p)
+	}
+	n, err := f.Write(p[:keep])
+	if err == nil {
+		err = injected
+	}
+	return n, err
+}
+
+// syncFile syncs f, which is file, unless fi fails the sync.
+func syncFile(fi FaultInjector, file string, f *os.File) error {
+	if fi != nil {
+		if err := fi.BeforeSync(file); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+// faultWriter writes to a file through writeFile.
+type faultWriter struct {
+	fi   FaultInjector
+	file string
+	w    io.Writer
+}
+
+func (w faultWriter) Write(p []byte) (int, error) {
+	return writeFile(w.fi, w.file, w.w, p)
+}
+
+// Faults is a FaultInjector that injects the faults it is told to, each
+// once.
+type Faults struct {
+	mu    sync.Mutex
+	syncs map[string]int
+	tears map[string][]int
+	delay time.Duration
+}
+
+// FailNextSync fails the next sync of file.
+func (f *Faults) FailNextSync(file string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.syncs == nil {
+		f.syncs = map[string]int{}
+	}
+	f.syncs[file]++
+}
+
+// TearNextWrite cuts the next write to file off after keep bytes.
+func (f *Faults) TearNextWrite(file string, keep int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.tears == nil {
+		f.tears = map[string][]int{}
+	}
+	f.tears[file] = append(f.tears[file], keep)
+}
+
+// DelayApply holds up every record a follower applies by delay.
+func (f *Faults) DelayApply(delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delay = delay
+}
+
+func (f *Faults) BeforeWrite(file string, p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tears := f.tears[file]
+	if len(tears) == 0 {
+		return len(p), nil
+	}
+	f.tears[file] = tears[1:]
+	return min(tears[0], len(p)), fmt.Errorf("write %s: %w", file, ErrInjectedFault)
+}
+
+func (f *Faults) BeforeSync(file string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.syncs[file] == 0 {
+		return nil
+	}
+	f.syncs[file]--
+	return fmt.Errorf("sync %s: %w", file, ErrInjectedFault)
+}
+
+func (f *Faults) BeforeApply(lsn uint64) {
+	f.mu.Lock()
+	delay := f.delay
+	f.mu.Unlock()
+	time.Sleep(delay)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestFaults_tornWrite(t *testing.T) {
+	// The commit record cut off after every length up to its whole.
+	for keep := 0; ; keep++ {
+		dir := t.TempDir()
+		faults := &Faults{}
+		database, err := NewDatabase(dir, WithFaults(faults))
+		assertEq(err, nil, "open")
+		c := database.newConnection()
+		c.mustExecCommand("set", []string{"x", "one"})
+
+		tx, _ := database.Begin()
+		tx.Set("x", "two")
+		faults.TearNextWrite("wal", keep)
+		err = tx.Commit()
+		whole := err == nil
+		if !whole {
+			assert(errors.Is(err, ErrInjectedFault), "torn commit fails")
+			_, err = c.execCommand("set", []string{"y", "after"})
+			assert(errors.Is(err, ErrWALFailed), "nothing commits after it")
+		}
+		crash(database)
+
+		database, err = NewDatabase(dir)
+		assertEq(err, nil, "reopen")
+		c = database.newConnection()
+		want := "one"
+		if whole {
+			want = "two"
+		}
+		assertEq(c.mustExecCommand("get", []string{"x"}), want, "recovered")
+		_, err = c.execCommand("get", []string{"y"})
+		assertEq(err, ErrKeyNotFound, "not committed")
+
+		// The torn frame is gone, so what is logged after it survives.
+		c.mustExecCommand("set", []string{"z", "later"})
+		crash(database)
+		database, err = NewDatabase(dir)
+		assertEq(err, nil, "reopen again")
+		c = database.newConnection()
+		assertEq(c.mustExecCommand("get", []string{"z"}), "later", "logged after")
+		database.Close()
+		if whole {
+			assert(keep > 0, "torn at all")
+			return
+		}
+	}
+}
+
+func TestFaults_failedSync(t *testing.T) {
+	dir := t.TempDir()
+	faults := &Faults{}
+	database, err := NewDatabase(dir, WithFaults(faults))
+	assertEq(err, nil, "open")
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "one"})
+
+	faults.FailNextSync("wal")
+	_, err = c.execCommand("set", []string{"x", "two"})
+	assert(errors.Is(err, ErrInjectedFault), "commit fails")
+	assertEq(c.mustExecCommand("get", []string{"x"}), "one", "aborted")
+	// The sync would succeed now, but what it would sync may be gone.
+	_, err = c.execCommand("set", []string{"y", "after"})
+	assert(errors.Is(err, ErrWALFailed), "the log stays failed")
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	c = database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"x"}), "one", "synced commit survives")
+	_, err = c.execCommand("get", []string{"y"})
+	assertEq(err, ErrKeyNotFound, "failed log's commit")
+	c.mustExecCommand("set", []string{"y", "reopened"})
+	assertEq(c.mustExecCommand("get", []string{"y"}), "reopened", "usable again")
+}
+
+func TestFaults_checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	faults := &Faults{}
+	database, err := NewDatabase(dir, WithFaults(faults))
+	assertEq(err, nil, "open")
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "one"})
+	assertEq(database.Checkpoint(), nil, "checkpoint")
+	c.mustExecCommand("set", []string{"y", "two"})
+
+	faults.TearNextWrite("checkpoint", 10)
+	assert(errors.Is(database.Checkpoint(), ErrInjectedFault), "torn checkpoint fails")
+	faults.FailNextSync("checkpoint")
+	assert(errors.Is(database.Checkpoint(), ErrInjectedFault), "unsynced checkpoint fails")
+	// Neither replaced the checkpoint or truncated the log.
+	c.mustExecCommand("set", []string{"z", "three"})
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	c = database.newConnection()
+	for key, want := range map[string]string{"x": "one", "y": "two", "z": "three"} {
+		assertEq(c.mustExecCommand("get", []string{key}), want, "recovered "+key)
+	}
+}
+
+// countedSyncs counts the syncs of each file.
+type countedSyncs struct {
+	Faults
+	mu    sync.Mutex
+	syncs map[string]int
+}
+
+func (c *countedSyncs) BeforeSync(file string) error {
+	c.mu.Lock()
+	if c.syncs == nil {
+		c.syncs = map[string]int{}
+	}
+	c.syncs[file]++
+	c.mu.Unlock()
+	return c.Faults.BeforeSync(file)
+}
+
+func (c *countedSyncs) count(file string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.syncs[file]
+}
+
+// heldApplies holds up a follower's applies until it is released.
+type heldApplies struct {
+	Faults
+	release chan struct{}
+}
+
+func (h *heldApplies) BeforeApply(lsn uint64) {
+	<-h.release
+}
+
+func TestFaults_delayedApply(t *testing.T) {
+	leader, err := NewDatabase(t.TempDir())
+	assertEq(err, nil, "open")
+	defer leader.Close()
+	srv, addr := startReplicationServer(leader)
+	defer srv.Close()
+
+	held := &heldApplies{release: make(chan struct{})}
+	database := newDatabase()
+	database.apply(WithFaults(held))
+	f, err := database.Follow(addr)
+	assertEq(err, nil, "follow")
+	defer f.Close()
+
+	leader.newConnection().mustExecCommand("set", []string{"x", "one"})
+	follower := database.newConnection()
+	_, err = follower.execCommand("get", []string{"x"})
+	assertEq(err, ErrKeyNotFound, "not applied yet")
+	assertEq(database.ReplicationStatus().LSN, uint64(0), "nothing applied")
+
+	close(held.release)
+	caughtUp(leader, &database)
+	assertEq(follower.mustExecCommand("get", []string{"x"}), "one", "applied")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+A client after the few keys in a range whose values say something would
+otherwise have to fetch the whole range and look for itself. Filters let
+a scan do the looking: each is tried on the version of a key the scan
+sees, and only keys whose values pass every filter come back, and count
+towards the limit.
+
+  - eq, contains and match compare the value as a get would return it
+    with a string: equal to it, containing it, or matching it as a
+    regular expression (RE2, unanchored).
+  - lt, le, gt and ge compare numerically, and only ints, floats and
+    counters (see types.go and counter.go) can pass them; a string that
+    reads as a number is still a string.
+
+The keys filtered out were still read, so they are in the readset as if
+they had come back: what a filter lets through depends on their values.
+An iterator's step goes on past them, under the lock, to the next key
+that passes, and its continuation token carries the filters along.
+*/
+
+var ErrBadFilter = errors.New("bad filter")
+
+type FilterOp uint8
+
+const (
+	FilterEq FilterOp = iota
+	FilterContains
+	FilterMatch
+	FilterLt
+	FilterLe
+	FilterGt
+	FilterGe
+)
+
+var filterOps = []string{"eq", "contains", "match", "lt", "le", "gt", "ge"}
+
+func (op FilterOp) String() string {
+	if int(op) < len(filterOps) {
+		return filterOps[op]
+	}
+	return fmt.Sprintf("FilterOp(%d)", uint8(op))
+}
+
+func parseFilterOp(s string) (FilterOp, bool) {
+	for i, name := range filterOps {
+		if name == s {
+			return FilterOp(i), true
+		}
+	}
+	return 0, false
+}
+
+// A Filter is a test of a scanned key's value.
+type Filter struct {
+	Op      FilterOp
+	Operand string
+}
+
+// A compiled filter, with its operand parsed as its op needs.
+type valueFilter struct {
+	Filter
+	re *regexp.Regexp
+	// The operand as an int, if it is one, and as a float.
+	n     int64
+	isInt bool
+	f     float64
+}
+
+func compileFilters(filters []Filter) ([]valueFilter, error) {
+	compiled := make([]valueFilter, len(filters))
+	for i, f := range filters {
+		vf := valueFilter{Filter: f}
+		var err error
+		switch f.Op {
+		case FilterEq, FilterContains:
+		case FilterMatch:
+			vf.re, err = regexp.Compile(f.Operand)
+		case FilterLt, FilterLe, FilterGt, FilterGe:
+			vf.n, err = strconv.ParseInt(f.Operand, 10, 64)
+			vf.isInt = err == nil
+			vf.f, err = strconv.ParseFloat(f.Operand, 64)
+			if err == nil && math.IsNaN(vf.f) {
+				err = errors.New("not a number")
+			}
+		default:
+			err = fmt.Errorf("unknown op %s", f.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s %q: %w", ErrBadFilter, f.Op, f.Operand, err)
+		}
+		compiled[i] = vf
+	}
+	return compiled, nil
+}
+
+// passes reports whether v, which a scan sees, passes every filter.
+func (d *Database) passes(filters []valueFilter, v *Value) (bool, error) {
+	if len(filters) == 0 {
+		return true, nil
+	}
+	// Read at most once, and only if a filter needs it.
+	var value string
+	read := false
+	for i := range filters {
+		f := &filters[i]
+		switch f.Op {
+		case FilterEq, FilterContains, FilterMatch:
+			if !read {
+				var err error
+				if value, err = d.read(v); err != nil {
+					return false, err
+				}
+				read = true
+			}
+			if !f.matchString(value) {
+				return false, nil
+			}
+		default:
+			c, ok, err := f.compareNumber(d, v)
+			if err != nil {
+				return false, err
+			}
+			if !ok || !f.accepts(c) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func (f *valueFilter) matchString(value string) bool {
+	switch f.Op {
+	case FilterEq:
+		return value == f.Operand
+	case FilterContains:
+		return strings.Contains(value, f.Operand)
+	}
+	return f.re.MatchString(value)
+}
+
+// compareNumber compares v's value with the operand, if it is a number.
+func (f *valueFilter) compareNumber(d *Database, v *Value) (int, bool, error) {
+	if v.data.kind != IntType && v.data.kind != CounterType && v.data.kind != FloatType {
+		return 0, false, nil
+	}
+	raw, err := d.readRaw(v)
+	if err != nil {
+		return 0, false, err
+	}
+	var n int64
+	switch v.data.kind {
+	case IntType:
+		n = decodeInt(raw)
+	case CounterType:
+		n, _ = decodeCounter(raw)
+	case FloatType:
+		x := decodeFloat(raw)
+		if math.IsNaN(x) {
+			// NaN is neither less nor more than anything.
+			return 0, false, nil
+		}
+		return cmp.Compare(x, f.f), true, nil
+	}
+	if f.isInt {
+		return cmp.Compare(n, f.n), true, nil
+	}
+	return cmp.Compare(float64(n), f.f), true, nil
+}
+
+// accepts reports whether a value comparing c with the operand passes.
+func (f *valueFilter) accepts(c int) bool {
+	switch f.Op {
+	case FilterLt:
+		return c < 0
+	case FilterLe:
+		return c <= 0
+	case FilterGt:
+		return c > 0
+	}
+	return c >= 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestScanFilters(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("mset", []string{"a", "apple", "b", "banana", "c", "cherry", "n", "12"})
+	tx, _ := database.Begin()
+	tx.SetInt("i1", 5)
+	tx.SetInt("i2", 50)
+	tx.SetFloat("f", 7.5)
+	tx.AddCounter("k", 9)
+	tx.Commit()
+
+	scan := func(args ...string) string {
+		return c.mustExecCommand("scan", append(args, ""))
+	}
+	assertEq(scan("-eq", "banana"), "b=banana", "eq")
+	assertEq(scan("-contains", "an"), "b=banana", "contains")
+	assertEq(scan("-match", "^(a|c)"), "a=apple\nc=cherry", "match")
+	assertEq(scan("-match", "e", "-contains", "rr"), "c=cherry", "every filter")
+	assertEq(scan("-gt", "6"), "f=7.5\ni2=50\nk=9", "gt")
+	assertEq(scan("-ge", "5", "-lt", "8.5"), "f=7.5\ni1=5", "between")
+	assertEq(scan("-le", "7.5", "-desc"), "i1=5\nf=7.5", "le, descending")
+	assertEq(scan("-gt", "0", "-limit", "2"), "f=7.5\ni1=5", "the limit counts what passes")
+	assertEq(scan("-eq", "12"), "n=12", "strings compare as strings")
+	assertEq(scan("-lt", "100", "n"), "", "and never as numbers")
+
+	_, err := c.execCommand("scan", []string{"-match", "(", ""})
+	assert(errors.Is(err, ErrBadFilter), "bad regexp")
+	_, err = c.execCommand("scan", []string{"-gt", "ten", ""})
+	assert(errors.Is(err, ErrBadFilter), "bad number")
+}
+
+func TestScanFilters_iterator(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("mset", []string{"a", "x1", "b", "y", "c", "x2", "d", "x3"})
+
+	tx, _ := database.Begin()
+	defer tx.Abort()
+	it := tx.NewIterator(ScanOptions{Limit: 1, Filters: []Filter{{FilterContains, "x"}}})
+	assert(it.Next(), "first")
+	assertEq(it.Key(), "a", "first")
+	assert(!it.Next(), "limit")
+
+	// The token keeps the filter.
+	it, err := tx.ResumeIterator(it.Token(), 0)
+	assertEq(err, nil, "resume")
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assertEq(len(keys), 2, "resumed")
+	assertEq(keys[0]+keys[1], "cd", "resumed, filtered")
+	assert(tx.readset.Contains("b"), "filtered out keys are read")
+
+	it = tx.NewIterator(ScanOptions{Filters: []Filter{{FilterLt, "NaN"}}})
+	assert(!it.Next(), "bad filter")
+	assert(errors.Is(it.Err(), ErrBadFilter), "bad filter error")
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tidwall/btree"
+)
+
+/*
+A follower applies its leader's log (see replication.go) in memory. The
+transactions it replays get ids of its own, as they begin, rather than the
+leader's, so that they and the follower's own transactions are numbered
+in the order the follower saw them begin, and visibility works as it
+would for any transactions: a snapshot taken on the follower sees the
+leader's transactions that had committed there by then, and nothing of
+those that hadn't. Watches (see watch.go) fire as the commits arrive.
+
+A follower that has to start over from a snapshot replaces everything it
+had. Its own transactions in progress are aborted, since what they saw is
+gone.
+*/
+
+var ErrReadOnly = errors.New("follower is read-only")
+
+const (
+	followerDialTimeout = 5 * time.Second
+	// How long a follower waits to reconnect after losing its leader.
+	followerRetry = time.Second
+)
+
+// FollowConfig says how to follow a leader.
+type FollowConfig struct {
+	// The address of the leader's replication server.
+	Leader string
+	// The admin to authenticate as, if the leader requires it, and how
+	// to dial the leader over TLS, if it serves it.
+	User     string
+	Password string
+	TLS      *tls.Config
+}
+
+// Follower keeps a database a copy of a leader's.
+type Follower struct {
+	db     *Database
+	leader string
+	config FollowConfig
+
+	// Guarded by the database's lock. The leader's transactions in
+	// progress, by their ids in the leader's log; the last record
+	// applied; and what the follower knows of the leader.
+	running     map[uint64]*Transaction
+	applied     uint64
+	leaderLSN   uint64
+	connected   bool
+	lastContact time.Time
+
+	// The commit timestamp the follower has every commit up to, and the
+	// latest a heartbeat has promised once it has applied the log up to
+	// closedLSN. See followerread.go.
+	resolved  HLC
+	closedHLC HLC
+	closedLSN uint64
+
+	mu      sync.Mutex
+	conn    net.Conn
+	closed  bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// Follow makes d a follower of the leader whose replication server is at
+// addr, until the follower is closed. d must be empty, and not logged to,
+// and from now on it is read-only.
+func (d *Database) Follow(addr string) (*Follower, error) {
+	return d.FollowWith(FollowConfig{Leader: addr})
+}
+
+// FollowWith is Follow, configured by cfg.
+func (d *Database) FollowWith(cfg FollowConfig) (*Follower, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.follower != nil {
+		return nil, fmt.Errorf("follow: already following %s", d.follower.leader)
+	}
+	if d.wal != nil || d.store.Len() > 0 || d.nextTransactionId > 1 {
+		return nil, errors.New("follow: database is not empty")
+	}
+	f := &Follower{
+		db:      d,
+		leader:  cfg.Leader,
+		config:  cfg,
+		running: map[uint64]*Transaction{},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	d.follower = f
+	go f.run()
+	return f, nil
+}
+
+// Close stops following the leader. The database stays a read-only copy
+// of the leader's as of the last commit applied.
+func (f *Follower) Close() error {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.done)
+		if f.conn != nil {
+			f.conn.Close()
+		}
+	}
+	f.mu.Unlock()
+
+	<-f.stopped
+	return nil
+}
+
+func (f *Follower) run() {
+	defer close(f.stopped)
+	for {
+		err := f.follow()
+
+		f.db.mu.Lock()
+		f.connected = false
+		f.db.mu.Unlock()
+
+		select {
+		case <-f.done:
+			return
+		default:
+		}
+		f.db.logger.Warn("lost the leader", "leader", f.leader, "err", err)
+
+		select {
+		case <-f.done:
+			return
+		case <-time.After(followerRetry):
+		}
+	}
+}
+
+// follow connects to the leader and applies what it sends until the
+// connection fails.
+func (f *Follower) follow() error {
+	dialer := &net.Dialer{Timeout: followerDialTimeout}
+	var conn net.Conn
+	var err error
+	if f.config.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", f.leader, f.config.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", f.leader)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.conn = conn
+	f.mu.Unlock()
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	hello := frameBody(nil).string(replicationMagic).string(f.config.User).string(f.config.Password)
+	w.Write(appendFrame(nil, hello.uvarint(f.position())))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		body, _, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		if err := f.receive(body, r); err != nil {
+			return err
+		}
+
+		if r.Buffered() == 0 {
+			// Caught up with what has arrived, so say so.
+			w.Write(appendFrame(nil, frameBody(nil).uvarint(f.position())))
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (f *Follower) position() uint64 {
+	f.db.mu.Lock()
+	defer f.db.mu.Unlock()
+	return f.applied
+}
+
+// receive handles a message from the leader, reading whatever follows it
+// from r.
+func (f *Follower) receive(body []byte, r io.Reader) error {
+	if len(body) == 0 {
+		return errFrameBody
+	}
+
+	var cp *checkpoint
+	var rec WALRecord
+	var lsn uint64
+	var closed HLC
+	var err error
+	switch body[0] {
+	case replRecord:
+		rec, err = decodeWALRecord(body[1:])
+		lsn = rec.LSN
+	case replHeartbeat:
+		br := frameReader{b: body[1:]}
+		lsn = br.uvarint()
+		// Leaders from before commit timestamps send only the position.
+		if len(br.b) > 0 {
+			closed = HLC(br.uvarint())
+		}
+		err = br.done()
+	case replSnapshot:
+		cp, err = decodeCheckpoint(r)
+	default:
+		err = fmt.Errorf("unknown message %q", body[0])
+	}
+	if err != nil {
+		return fmt.Errorf("replication: %w", err)
+	}
+
+	d := f.db
+	if d.faults != nil && body[0] == replRecord {
+		d.faults.BeforeApply(rec.LSN)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f.connected = true
+	f.lastContact = d.clock()
+	f.leaderLSN = max(f.leaderLSN, lsn)
+	if closed > f.closedHLC {
+		f.closedHLC, f.closedLSN = closed, lsn
+	}
+
+	switch {
+	case cp != nil:
+		f.reset(cp)
+	case body[0] == replRecord && rec.LSN > f.applied:
+		err = f.apply(rec)
+	}
+	if f.applied >= f.closedLSN {
+		f.resolved = max(f.resolved, f.closedHLC)
+	}
+	return err
+}
+
+// apply replays rec, a record from the leader's log.
+func (f *Follower) apply(rec WALRecord) error {
+	d := f.db
+	t := f.running[rec.TxId]
+	if err := d.replay(rec, f.running, true); err != nil {
+		return err
+	}
+	f.applied = rec.LSN
+
+	switch rec.Type {
+	case WALCommit:
+		// The leader logs its commits in the order it stamps them.
+		f.resolved = max(f.resolved, t.committedAt)
+		d.publishChanges(t)
+		d.pruneTransactions()
+	case WALAbort:
+		d.pruneTransactions()
+	}
+	return nil
+}
+
+// reset replaces everything the follower has with the snapshot cp.
+func (f *Follower) reset(cp *checkpoint) {
+	d := f.db
+	next := d.nextTransactionId
+	d.discardAll()
+	f.running = d.loadCheckpoint(cp)
+	d.nextTransactionId = max(next, cp.NextTransactionId)
+	f.applied = cp.LSN
+	f.resolved = max(f.resolved, cp.HLC)
+	d.logger.Info("started over from a snapshot", "leader", f.leader, "lsn", cp.LSN)
+}
+
+// discardAll empties the database, to be replaced by a copy of another's,
+// aborting the transactions in progress. Transaction ids carry on from
+// where they were.
+func (d *Database) discardAll() {
+	iter := d.running.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		if t, ok := d.transactions.Get(iter.Key()); ok {
+			t.state = AbortedTransaction
+		}
+	}
+
+	d.store = btree.Map[string, []Value]{}
+	d.transactions = btree.Map[uint64, *Transaction]{}
+	d.running = btree.Set[uint64]{}
+	d.aborted = btree.Set[uint64]{}
+	d.clog = commitLog{}
+	d.versionBytes = 0
+	d.rebuildBloom()
+	d.prepared = nil
+	// Streams and watch cursors can't follow the change from what was
+	// there.
+	d.feed = nil
+	d.feedNext++
+	// Nor can reads as of a time before now.
+	d.hlcPruned = d.hlc
+}
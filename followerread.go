@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+A transaction can be begun at a commit timestamp (see hlc.go), to read
+what had committed by then and nothing since, like a read as of a time
+that lasts a whole transaction. It can't write: it would be writing into
+the past.
+
+On a follower, that is how a read gets a bound on how stale it is
+without asking the leader. The follower knows a timestamp up to which it
+has every commit: each commit the leader ships carries its stamp, the
+leader's commits are logged in stamp order, and an idle leader's
+heartbeats (see replication.go) carry a fresh stamp, one that anything it
+commits later will be stamped after, along with the log position that
+covers everything before it. Beginning at a timestamp past that fails
+straight away with ErrNotCaughtUp, rather than waiting, since the caller
+may well rather go to the leader. Beginning with a staleness bound reads
+at that timestamp, whatever it is, if it is recent enough.
+
+Anywhere but on a follower, the database has every commit there has
+been, and a transaction can begin at any timestamp up to now.
+
+Like other reads as of a time, one older than the registry keeps (see
+retention.go) can't be read.
+*/
+
+var (
+	ErrNotCaughtUp      = errors.New("has not replicated up to the timestamp")
+	ErrReadOnlySnapshot = errors.New("transaction reading at a timestamp is read-only")
+)
+
+// BeginAt begins a read-only transaction that sees what had committed at
+// ts. On a follower, it fails with ErrNotCaughtUp if the follower doesn't
+// have everything that had.
+func (d *Database) BeginAt(ts HLC) (*Transaction, error) {
+	return d.beginReadAt(context.Background(), atTimestamp(ts))
+}
+
+// BeginStale begins a read-only transaction at the latest timestamp the
+// database has everything up to, failing with ErrNotCaughtUp if that is
+// more than maxStaleness ago.
+func (d *Database) BeginStale(maxStaleness time.Duration) (*Transaction, error) {
+	return d.beginReadAt(context.Background(), d.withinStaleness(maxStaleness))
+}
+
+func atTimestamp(ts HLC) func(HLC) (HLC, error) {
+	return func(HLC) (HLC, error) { return ts, nil }
+}
+
+func (d *Database) withinStaleness(bound time.Duration) func(HLC) (HLC, error) {
+	return func(resolved HLC) (HLC, error) {
+		if oldest := newHLC(d.clock().Add(-bound), 0); resolved < oldest {
+			return 0, fmt.Errorf("%w: only up to %s, over %s ago", ErrNotCaughtUp, resolved, bound)
+		}
+		return resolved, nil
+	}
+}
+
+// beginReadAt begins a transaction at the timestamp at returns, given
+// the one the database has everything up to.
+func (d *Database) beginReadAt(ctx context.Context, at func(resolved HLC) (HLC, error)) (*Transaction, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.shutdown {
+		return nil, ErrDatabaseShutdown
+	}
+	resolved := d.resolvedHLC()
+	ts, err := at(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if ts > resolved {
+		return nil, fmt.Errorf("%w: %s, only up to %s", ErrNotCaughtUp, ts, resolved)
+	}
+	if ts < d.hlcPruned {
+		return nil, fmt.Errorf("read at %s: %w", ts, ErrTimeTooOld)
+	}
+
+	// As with a read as of a time, transactions that hadn't committed by
+	// ts count as in progress.
+	t := d.newTransactionAt(ctx, RepeatableReadIsolation)
+	t.readAt = ts
+	t.inprogress = d.snapshotAt(ts, t.id)
+	d.assertValidTransaction(t)
+	return t, nil
+}
+
+// resolvedHLC returns a timestamp the database has every commit up to:
+// for a follower, as far as it has replicated, and otherwise now.
+func (d *Database) resolvedHLC() HLC {
+	if f := d.follower; f != nil {
+		return f.resolved
+	}
+	return d.tickHLC()
+}
+
+// ReadTimestamp returns the timestamp the transaction reads at, or zero
+// if it wasn't begun at one.
+func (t *Transaction) ReadTimestamp() HLC {
+	return t.root().readAt
+}
+
+// begin asof <time> | begin stale <duration>
+func (c *Connection) beginReadAt(args []string) (Result, error) {
+	if c.tx != nil {
+		return Result{}, errors.New("begin: nested transactions read at their outermost transaction's snapshot")
+	}
+
+	var at func(HLC) (HLC, error)
+	if args[0] == "asof" {
+		ts, err := parseHLC(args[1])
+		if err != nil {
+			return Result{}, fmt.Errorf("begin: asof wants a time: %w", err)
+		}
+		at = atTimestamp(ts)
+	} else {
+		bound, err := time.ParseDuration(args[1])
+		if err != nil {
+			return Result{}, fmt.Errorf("begin: stale wants a duration: %w", err)
+		}
+		at = c.db.withinStaleness(bound)
+	}
+
+	tx, err := c.db.beginReadAt(c.context(), at)
+	if err != nil {
+		return Result{}, err
+	}
+	c.tx = tx
+	return Result{Value: fmt.Sprintf("%d", tx.id), TxId: tx.id}, nil
+}
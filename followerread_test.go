@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBeginAt(t *testing.T) {
+	database := newDatabase()
+	database.retention.Transactions = 100
+	commit := func(value string) HLC {
+		tx, _ := database.Begin()
+		tx.Set("x", value)
+		assertEq(tx.Commit(), nil, "commit")
+		return tx.CommitTimestamp()
+	}
+	first := commit("1")
+	second := commit("2")
+
+	tx, err := database.BeginAt(first)
+	assertEq(err, nil, "begin at the first commit")
+	assertEq(tx.ReadTimestamp(), first, "read timestamp")
+	value, _ := tx.Get("x")
+	assertEq(value, "1", "as of the first commit")
+	// Later commits stay out of it.
+	commit("3")
+	value, _ = tx.Get("x")
+	assertEq(value, "1", "still")
+
+	// It can't write.
+	tx.Set("y", "1")
+	assert(errors.Is(tx.Commit(), ErrReadOnlySnapshot), "read-only")
+
+	tx, _ = database.BeginAt(second)
+	value, _ = tx.Get("x")
+	assertEq(value, "2", "as of the second commit")
+	tx.Commit()
+
+	_, err = database.BeginAt(newHLC(time.Now().Add(time.Hour), 0))
+	assert(errors.Is(err, ErrNotCaughtUp), "the future")
+	database.retention.Transactions = 0
+	commit("4")
+	_, err = database.BeginAt(second)
+	assert(errors.Is(err, ErrTimeTooOld), "pruned")
+}
+
+func TestFollowerReads(t *testing.T) {
+	leader, err := NewDatabase(t.TempDir())
+	assertEq(err, nil, "open")
+	defer leader.Close()
+	srv, addr := startReplicationServer(leader)
+	defer srv.Close()
+
+	database := newDatabase()
+	database.retention.Transactions = 100
+	f, err := database.Follow(addr)
+	assertEq(err, nil, "follow")
+	defer f.Close()
+
+	tx, _ := leader.Begin()
+	tx.Set("x", "1")
+	tx.Commit()
+	first := tx.CommitTimestamp()
+	caughtUp(leader, &database)
+	tx, _ = leader.Begin()
+	tx.Set("x", "2")
+	tx.Commit()
+	second := tx.CommitTimestamp()
+	caughtUp(leader, &database)
+
+	// The follower reads as of either commit without the leader.
+	c := database.newConnection()
+	c.mustExecCommand("begin", []string{"asof", first.String()})
+	assertEq(c.mustExecCommand("get", []string{"x"}), "1", "at the first commit")
+	c.mustExecCommand("commit", nil)
+	ro, err := database.BeginAt(second)
+	assertEq(err, nil, "begin at the second commit")
+	value, _ := ro.Get("x")
+	assertEq(value, "2", "at the second commit")
+	ro.Commit()
+
+	// It can't read past what it has, until a heartbeat says there is
+	// nothing more.
+	_, err = database.BeginAt(second + 1)
+	assert(errors.Is(err, ErrNotCaughtUp), "not there yet")
+	waitFor(func() bool { return database.ReplicationStatus().Resolved > second }, "heartbeat")
+	_, err = c.execCommand("begin", []string{"asof", (second + 1).String()})
+	assertEq(err, nil, "after the heartbeat")
+	c.mustExecCommand("commit", nil)
+
+	// Reads with a staleness bound fail fast if the follower is too far
+	// behind.
+	c.mustExecCommand("begin", []string{"stale", "1m"})
+	assertEq(c.mustExecCommand("get", []string{"x"}), "2", "recent enough")
+	c.mustExecCommand("commit", nil)
+	database.now = func() time.Time { return time.Now().Add(time.Hour) }
+	_, err = database.BeginStale(time.Minute)
+	assert(errors.Is(err, ErrNotCaughtUp), "too stale")
+	_, err = c.execCommand("begin", []string{"stale", "soon"})
+	assert(err != nil, "bad duration")
+}
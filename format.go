@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+/*
+Everything the database writes to disk (log records, checkpoints and
+backups) is a sequence of frames:
+
+	version  byte    format version, currently 1
+	length   uint32  length of the body
+	crc      uint32  CRC-32C of the version, length and body
+	body     []byte
+
+so that a damaged or half-written record is caught when it is read back,
+rather than loaded as if it were good, and so that a future format can be
+told apart from this one.
+
+Within a body, integers are uvarints unless they have a fixed width, and
+strings are a uvarint length followed by the bytes.
+*/
+
+const (
+	formatVersion   = 1
+	frameHeaderSize = 9
+)
+
+var (
+	errFrameChecksum = errors.New("checksum mismatch")
+	errFrameBody     = errors.New("malformed record")
+)
+
+func appendFrame(buf []byte, body []byte) []byte {
+	start := len(buf)
+	buf = append(buf, formatVersion)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(body)))
+	sum := crc32.Update(crc32.Checksum(buf[start:], castagnoli), castagnoli, body)
+	buf = binary.LittleEndian.AppendUint32(buf, sum)
+	return append(buf, body...)
+}
+
+// readFrame reads one frame, returning its body and the frame's size. It
+// returns io.EOF at a clean end of input, and io.ErrUnexpectedEOF if the
+// frame is cut short.
+func readFrame(r io.Reader) ([]byte, int, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	if header[0] != formatVersion {
+		return nil, 0, fmt.Errorf("unsupported format version %d", header[0])
+	}
+	size := binary.LittleEndian.Uint32(header[1:5])
+	sum := binary.LittleEndian.Uint32(header[5:])
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.Update(crc32.Checksum(header[:5], castagnoli), castagnoli, body) != sum {
+		return nil, 0, errFrameChecksum
+	}
+	return body, len(header) + len(body), nil
+}
+
+type frameBody []byte
+
+func (b frameBody) uvarint(n uint64) frameBody {
+	return binary.AppendUvarint(b, n)
+}
+
+func (b frameBody) string(s string) frameBody {
+	return append(b.uvarint(uint64(len(s))), s...)
+}
+
+func (b frameBody) uint32(n uint32) frameBody {
+	return binary.LittleEndian.AppendUint32(b, n)
+}
+
+func (b frameBody) strings(ss []string) frameBody {
+	b = b.uvarint(uint64(len(ss)))
+	for _, s := range ss {
+		b = b.string(s)
+	}
+	return b
+}
+
+// frameReader decodes a frame body. The first problem sticks, and is
+// reported by done.
+type frameReader struct {
+	b   []byte
+	err error
+}
+
+func (r *frameReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(r.b)
+	if size <= 0 {
+		r.err = errFrameBody
+		return 0
+	}
+	r.b = r.b[size:]
+	return n
+}
+
+func (r *frameReader) string() string {
+	n := r.uvarint()
+	if r.err != nil || n > uint64(len(r.b)) {
+		r.err = errFrameBody
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+// count reads the length of a list, which can't be longer than what is
+// left of the body.
+func (r *frameReader) count() uint64 {
+	n := r.uvarint()
+	if r.err != nil || n > uint64(len(r.b)) {
+		r.err = errFrameBody
+		return 0
+	}
+	return n
+}
+
+func (r *frameReader) strings() []string {
+	var ss []string
+	for range r.count() {
+		ss = append(ss, r.string())
+	}
+	return ss
+}
+
+func (r *frameReader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.err = errFrameBody
+		return 0
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *frameReader) uint32() uint32 {
+	if r.err != nil || len(r.b) < 4 {
+		r.err = errFrameBody
+		return 0
+	}
+	n := binary.LittleEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return n
+}
+
+// done reports the first problem decoding the body, including anything
+// left over at the end.
+func (r *frameReader) done() error {
+	if r.err == nil && len(r.b) > 0 {
+		r.err = errFrameBody
+	}
+	return r.err
+}
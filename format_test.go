@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	var buf []byte
+	buf = appendFrame(buf, frameBody(nil).string("key").uvarint(300).uint32(7))
+	buf = appendFrame(buf, nil)
+	r := bytes.NewReader(buf)
+
+	body, n, err := readFrame(r)
+	assertEq(err, nil, "first frame")
+	assertEq(n, frameHeaderSize+len(body), "frame size")
+	br := frameReader{b: body}
+	assertEq(br.string(), "key", "string")
+	assertEq(br.uvarint(), uint64(300), "uvarint")
+	assertEq(br.uint32(), uint32(7), "uint32")
+	assertEq(br.done(), nil, "whole body read")
+
+	body, _, err = readFrame(r)
+	assertEq(err, nil, "empty frame")
+	assertEq(len(body), 0, "empty body")
+	_, _, err = readFrame(r)
+	assertEq(err, io.EOF, "clean end")
+
+	br = frameReader{b: frameBody(nil).string("key")}
+	br.uvarint()
+	assertEq(br.done(), errFrameBody, "leftover bytes")
+	br = frameReader{b: []byte{5, 'a'}}
+	br.string()
+	assertEq(br.done(), errFrameBody, "string past the end")
+}
+
+func TestFrame_corrupt(t *testing.T) {
+	frame := appendFrame(nil, frameBody(nil).string("value"))
+
+	for i := range frame {
+		damaged := bytes.Clone(frame)
+		damaged[i] ^= 0x01
+		_, _, err := readFrame(bytes.NewReader(damaged))
+		assert(err != nil, "damaged byte caught")
+	}
+
+	bad := bytes.Clone(frame)
+	bad[0] = formatVersion + 1
+	_, _, err := readFrame(bytes.NewReader(bad))
+	assertEq(err.Error(), "unsupported format version 2", "future version")
+
+	bad = bytes.Clone(frame)
+	bad[len(bad)-1] ^= 0xff
+	_, _, err = readFrame(bytes.NewReader(bad))
+	assertEq(err, errFrameChecksum, "checksum mismatch")
+
+	_, _, err = readFrame(bytes.NewReader(frame[:len(frame)-1]))
+	assert(errors.Is(err, io.ErrUnexpectedEOF), "truncated body")
+	_, _, err = readFrame(bytes.NewReader(frame[:3]))
+	assert(errors.Is(err, io.ErrUnexpectedEOF), "truncated header")
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+/*
+In a raft cluster (see raft.go), a connection on a node that isn't the
+leader forwards its commands to the leader's forwarding server, which
+runs them on a connection of its own, one per forwarding connection, so
+a transaction begun there carries on there. Commands are authenticated
+and authorized, and their keys put in the connection's bucket, before
+they are forwarded; the few that are about the node itself run where
+they are. A transaction stays with the leader it began on: if that node
+loses the leadership, its commit fails, and the next transaction goes to
+the new leader.
+
+Every message is a frame (see format.go). The first from the forwarding
+node has a magic string and the cluster's secret (see RaftConfig); then
+each has the connection's autocommit setting, the user it authenticated
+as, if any, the command, and its arguments. Each reply has the error, if
+any, whether a transaction is in progress, and the result. Errors come
+back as their text and the codes of the errors in forwardedErrors they
+are, so ErrKeyNotFound and the like can still be told apart.
+
+The forwarding server trusts a node that knows the secret to have
+authenticated its users, and runs their commands as them, but the secret
+is only as safe as the connection it goes over: with users, nodes talk
+TLS.
+*/
+
+const forwardMagic = "MVCCFWD"
+
+const forwardDialTimeout = 5 * time.Second
+
+// Commands that are about the node they run on, so are never forwarded.
+var localCommands = map[string]bool{
+	"auth":        true,
+	"use":         true,
+	"autocommit":  true,
+	"stats":       true,
+	"replication": true,
+	"raft":        true,
+	"backup":      true,
+}
+
+// Errors that forwarded commands' errors are checked for, by code: their
+// index. Only ever append to it, as nodes may be of different versions.
+var forwardedErrors = []error{
+	ErrKeyNotFound,
+	ErrTransactionAborted,
+	ErrTransactionKilled,
+	ErrNoTransaction,
+	ErrTransactionInProgress,
+	ErrUnknownCommand,
+	ErrConnectionClosed,
+	ErrDatabaseShutdown,
+	ErrAuthRequired,
+	ErrPermissionDenied,
+	ErrUnknownBucket,
+	ErrNotLeader,
+	ErrNoLeader,
+	ErrReadOnly,
+	ErrReadOnlySnapshot,
+	ErrNotCaughtUp,
+	ErrTimeTooOld,
+	ErrWrongType,
+	ErrNotInteger,
+	ErrNotJSON,
+	ErrNoPath,
+	ErrUniqueViolation,
+	ErrUnknownIndex,
+	ErrChecksumMismatch,
+	ErrTransactionPrepared,
+	ErrUnknownPrepared,
+	ErrNoSavepoint,
+	ErrSavepointsHeld,
+	ErrNestedInProgress,
+	ErrUnknownTransaction,
+	ErrOutOfMemoryBudget,
+	ErrWALFailed,
+	ErrInvalidToken,
+	ErrBadFilter,
+	ErrChangesTruncated,
+	ErrWatchClosed,
+	ErrInvalidCursor,
+}
+
+// NewForwardingServer returns a server running commands forwarded from the
+// other nodes of the database's raft cluster.
+func NewForwardingServer(db *Database) *Server {
+	return newServer(db, serveForwarded)
+}
+
+func serveForwarded(c *Connection, r *bufio.Reader, w *bufio.Writer) {
+	c.forwarded = true
+	body, _, err := readFrame(r)
+	br := frameReader{b: body}
+	n := c.db.raftNode.Load()
+	if err == nil && (br.string() != forwardMagic || n == nil) {
+		err = errors.New("not a raft node")
+	}
+	if err == nil && !passwordsMatch(n.secret, br.string()) {
+		err = errors.New("wrong cluster secret")
+	}
+	if err == nil {
+		err = br.done()
+	}
+	if err != nil {
+		c.db.logger.Warn("refused forwarding connection", "remote", c.remote, "err", err)
+		return
+	}
+
+	for {
+		body, _, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		br := frameReader{b: body}
+		autocommit := br.byte() == 1
+		user := br.string()
+		command := br.string()
+		args := br.strings()
+		if err := br.done(); err != nil {
+			c.db.logger.Warn("bad forwarded command", "remote", c.remote, "err", err)
+			return
+		}
+
+		c.autocommit = autocommit
+		// The forwarding node authenticated the user. One this node
+		// doesn't know, or none with users, can't run anything.
+		c.user = nil
+		if u, ok := c.users[user]; ok {
+			c.user = &u
+		}
+		res, err := c.execCommand(command, args)
+		w.Write(appendFrame(nil, encodeForwardedReply(res, err, c.tx != nil)))
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// forwarder is a connection's end of its forwarding connection.
+type forwarder struct {
+	addr string
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	// Whether the leader has a transaction in progress for us.
+	inTx bool
+	// Set once the connection has failed.
+	broken bool
+}
+
+func dialForwarder(n *RaftNode, addr string) (*forwarder, error) {
+	dialer := &net.Dialer{Timeout: forwardDialTimeout}
+	var conn net.Conn
+	var err error
+	if n.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, n.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := &forwarder{addr: addr, conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	f.w.Write(appendFrame(nil, frameBody(nil).string(forwardMagic).string(n.secret)))
+	return f, nil
+}
+
+func (f *forwarder) exec(autocommit bool, user *User, command string, args []string) (Result, error) {
+	flag := byte(0)
+	if autocommit {
+		flag = 1
+	}
+	name := ""
+	if user != nil {
+		name = user.Name
+	}
+	f.w.Write(appendFrame(nil, frameBody{flag}.string(name).string(command).strings(args)))
+	err := f.w.Flush()
+	var body []byte
+	if err == nil {
+		body, _, err = readFrame(f.r)
+	}
+	var res Result
+	var cmdErr error
+	if err == nil {
+		res, cmdErr, f.inTx, err = decodeForwardedReply(body)
+	}
+	if err != nil {
+		f.broken = true
+		f.conn.Close()
+		if f.inTx {
+			return Result{}, fmt.Errorf("forward: %w: %w", ErrTransactionAborted, err)
+		}
+		return Result{}, fmt.Errorf("forward: %w", err)
+	}
+	return res, cmdErr
+}
+
+func (f *forwarder) close() {
+	f.conn.Close()
+}
+
+// forwardTarget returns the address of the forwarding server command is
+// to be sent to, or "" to run it here.
+func (c *Connection) forwardTarget(command string) (string, error) {
+	n := c.db.raftNode.Load()
+	switch {
+	case n == nil || c.forwarded || localCommands[command]:
+		return "", nil
+	case c.forward != nil && c.forward.inTx:
+		return c.forward.addr, nil
+	case c.tx != nil || n.leading():
+		return "", nil
+	}
+	return n.leaderForwardAddr()
+}
+
+func (c *Connection) forwardCommand(addr, command string, args []string) (Result, error) {
+	if c.forward != nil && c.forward.addr != addr {
+		c.forward.close()
+		c.forward = nil
+	}
+	if c.forward == nil {
+		f, err := dialForwarder(c.db.raftNode.Load(), addr)
+		if err != nil {
+			return Result{}, fmt.Errorf("forward: %w", err)
+		}
+		c.forward = f
+	}
+	res, err := c.forward.exec(c.autocommit, c.user, command, args)
+	if c.forward.broken {
+		c.forward = nil
+	}
+	return res, err
+}
+
+func encodeForwardedReply(res Result, err error, inTx bool) frameBody {
+	var body frameBody
+	if err != nil {
+		var codes []uint64
+		for i, target := range forwardedErrors {
+			if errors.Is(err, target) {
+				codes = append(codes, uint64(i))
+			}
+		}
+		body = frameBody{1}.string(err.Error()).uvarint(uint64(len(codes)))
+		for _, code := range codes {
+			body = body.uvarint(code)
+		}
+	} else {
+		body = frameBody{0}
+	}
+	body = append(body, boolByte(inTx))
+	body = body.string(res.Value)
+	body = append(body, boolByte(res.NotFound))
+	body = body.strings(res.Keys)
+	body = body.uvarint(uint64(len(res.Pairs)))
+	for _, p := range res.Pairs {
+		body = body.string(p.Key).string(p.Value)
+	}
+	body = body.uvarint(uint64(len(res.Lookups)))
+	for _, l := range res.Lookups {
+		body = append(body.string(l.Key).string(l.Value), boolByte(l.Found))
+	}
+	body = body.strings(res.Values)
+	return body.uvarint(res.TxId).uvarint(res.Version)
+}
+
+func decodeForwardedReply(body []byte) (res Result, cmdErr error, inTx bool, err error) {
+	br := frameReader{b: body}
+	if br.byte() == 1 {
+		e := &forwardedError{text: br.string()}
+		for range br.count() {
+			// Codes from a newer node than this may mean nothing here.
+			if code := br.uvarint(); code < uint64(len(forwardedErrors)) {
+				e.is = append(e.is, forwardedErrors[code])
+			}
+		}
+		cmdErr = e
+	}
+	inTx = br.byte() == 1
+	res.Value = br.string()
+	res.NotFound = br.byte() == 1
+	res.Keys = br.strings()
+	for range br.count() {
+		res.Pairs = append(res.Pairs, KeyValue{br.string(), br.string()})
+	}
+	for range br.count() {
+		res.Lookups = append(res.Lookups, Lookup{br.string(), br.string(), br.byte() == 1})
+	}
+	res.Values = br.strings()
+	res.TxId = br.uvarint()
+	res.Version = br.uvarint()
+	return res, cmdErr, inTx, br.done()
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// forwardedError is an error the leader returned to a forwarded command,
+// and the errors in forwardedErrors it was.
+type forwardedError struct {
+	text string
+	is   []error
+}
+
+func (e *forwardedError) Error() string { return e.text }
+
+func (e *forwardedError) Is(target error) bool {
+	return slices.Contains(e.is, target)
+}
@@ -0,0 +1,62 @@
+package main
+
+import "github.com/tidwall/btree"
+
+/*
+A scan of the whole store, for a backup or an export, used to hold the
+lock from its first key to its last, and every writer waited for it.
+Instead it freezes the store and scans the frozen copy. The btree is
+copied on write, so freezing costs nothing until the live tree changes,
+and the copy keeps the keys and their chains of versions as they were, so
+the scan sees neither keys come and go as it goes nor a chain half
+vacuumed. It still takes the lock to look at versions and the
+transactions that wrote them, but only for a batch of keys at a time, and
+writers go on between batches.
+
+The two trees share their chains, so while a copy is frozen nothing may
+rearrange a chain in place: vacuum and sealEnds build new ones instead,
+vacuum doesn't forget the aborted transactions a frozen chain may still
+refer to, and segments aren't compacted (see segments.go). Writes append past the end of the frozen chain, where it
+doesn't look, and end marks set in place don't change what a snapshot
+sees (see isvisible).
+*/
+
+// How many keys a scan of a frozen store looks at per taking of the lock.
+const frozenBatch = 256
+
+type frozenStore struct {
+	store   btree.Map[string, []Value]
+	tangled btree.Set[string]
+}
+
+// freeze returns a copy of the store as it stands, which scanFrozen
+// releases once it has scanned it.
+func (d *Database) freeze() *frozenStore {
+	d.frozen++
+	return &frozenStore{store: *d.store.Copy(), tangled: *d.tangled.Copy()}
+}
+
+// scanFrozen calls fn, in key order, with every key of f, its versions as
+// they were when frozen, and the one t sees, if any, and then releases f.
+// It takes the lock for each batch of keys, and fn is called with it
+// held; batched, if not nil, is called after each batch, without it. The
+// caller must not hold the lock.
+func (d *Database) scanFrozen(f *frozenStore, t *Transaction, fn func(key string, versions []Value, visible *Value), batched func()) {
+	iter := f.store.Iter()
+	ok := iter.First()
+	for ok {
+		d.mu.Lock()
+		for n := 0; ok && n < frozenBatch; ok, n = iter.Next(), n+1 {
+			key, versions := iter.Key(), iter.Value()
+			fn(key, versions, t.visibleIn(key, versions, !f.tangled.Contains(key)))
+		}
+		d.mu.Unlock()
+		if batched != nil {
+			batched()
+		}
+	}
+
+	d.mu.Lock()
+	d.frozen--
+	d.mu.Unlock()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScanFrozen(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	const keys = 3 * frozenBatch
+	for i := range keys {
+		c.mustExecCommand("set", []string{benchKey(i), "older"})
+		c.mustExecCommand("set", []string{benchKey(i), "old"})
+	}
+
+	database.mu.Lock()
+	snapshot := database.newTransactionAt(context.Background(), RepeatableReadIsolation)
+	f := database.freeze()
+	database.mu.Unlock()
+
+	seen := map[string]string{}
+	batches := 0
+	database.scanFrozen(f, snapshot, func(key string, _ []Value, value *Value) {
+		if value != nil {
+			seen[key], _ = database.read(value)
+		}
+	}, func() {
+		batches++
+		if batches > 1 {
+			return
+		}
+		// Writers carry on between batches: overwriting some keys,
+		// deleting others, adding more, and vacuuming what nobody else
+		// can see, as if the snapshot were gone.
+		for i := range keys {
+			switch i % 3 {
+			case 0:
+				c.mustExecCommand("set", []string{benchKey(i), "new"})
+			case 1:
+				c.mustExecCommand("delete", []string{benchKey(i)})
+			}
+		}
+		c.mustExecCommand("set", []string{"later", "new"})
+		database.mu.Lock()
+		database.completeTransaction(snapshot, AbortedTransaction)
+		database.mu.Unlock()
+		assert(database.Vacuum() > 0, "vacuumed")
+	})
+
+	assertEq(batches, 3, "batches")
+	assertEq(len(seen), keys, "keys as frozen")
+	for key, value := range seen {
+		assertEq(value, "old", key)
+	}
+	assertEq(database.frozen, 0, "released")
+	assertEq(c.mustExecCommand("get", []string{benchKey(0)}), "new", "writes landed")
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+FuzzConnections decodes its input into commands on a few connections,
+runs them against a database, and checks every result against a model
+that runs the same commands one after another.
+
+The model doesn't keep versions. It keeps the values each key could have
+committed, which is one, unless transactions at a level that doesn't stop
+them wrote the key concurrently: which of their writes survives is up to
+the order they wrote in, and the model only knows the order they
+committed in. Each transaction it runs has the snapshot of those it began
+with, what it has read and what it has written.
+*/
+
+const (
+	fuzzConns = 3
+	fuzzKeys  = 3
+)
+
+// fuzzPossible is the set of values a key could have, "" for none.
+type fuzzPossible map[string]bool
+
+func (p fuzzPossible) String() string {
+	return fmt.Sprintf("%q", slices.Sorted(maps.Keys(p)))
+}
+
+// fuzzCommit is the keys a committed transaction read and wrote.
+type fuzzCommit struct {
+	reads, writes map[string]bool
+}
+
+type fuzzTx struct {
+	// How many transactions had committed when it began, and the values
+	// keys could have had then.
+	begin    int
+	snapshot map[string]fuzzPossible
+	// The first value it read of each key it hadn't written, the keys it
+	// read at all, and the last value it wrote of each key.
+	reads   map[string]string
+	readset map[string]bool
+	writes  map[string]string
+}
+
+type fuzzModel struct {
+	level     IsolationLevel
+	committed map[string]fuzzPossible
+	commits   []fuzzCommit
+}
+
+func (m *fuzzModel) possible(key string) fuzzPossible {
+	if p, ok := m.committed[key]; ok {
+		return p
+	}
+	return fuzzPossible{"": true}
+}
+
+func (m *fuzzModel) begin() *fuzzTx {
+	return &fuzzTx{
+		begin:    len(m.commits),
+		snapshot: maps.Clone(m.committed),
+		reads:    map[string]string{},
+		readset:  map[string]bool{},
+		writes:   map[string]string{},
+	}
+}
+
+// visible returns the values tx could read of key.
+func (m *fuzzModel) visible(tx *fuzzTx, key string) fuzzPossible {
+	if v, ok := tx.writes[key]; ok {
+		return fuzzPossible{v: true}
+	}
+	if m.level <= ReadCommitedIsolation {
+		return m.possible(key)
+	}
+	if v, ok := tx.reads[key]; ok {
+		return fuzzPossible{v: true}
+	}
+	if p, ok := tx.snapshot[key]; ok {
+		return p
+	}
+	return fuzzPossible{"": true}
+}
+
+// conflicts reports whether tx can't commit at the model's level.
+func (m *fuzzModel) conflicts(tx *fuzzTx) bool {
+	for _, c := range m.commits[tx.begin:] {
+		for key := range tx.writes {
+			if m.level == SnapshotIsolation && c.writes[key] ||
+				m.level == SerializableIsolation && c.reads[key] {
+				return true
+			}
+		}
+		for key := range tx.readset {
+			if m.level == SerializableIsolation && c.writes[key] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *fuzzModel) commit(tx *fuzzTx) {
+	c := fuzzCommit{reads: tx.readset, writes: map[string]bool{}}
+	for key, v := range tx.writes {
+		concurrent := slices.ContainsFunc(m.commits[tx.begin:], func(c fuzzCommit) bool { return c.writes[key] })
+		if concurrent {
+			p := maps.Clone(m.possible(key))
+			p[v] = true
+			m.committed[key] = p
+		} else {
+			m.committed[key] = fuzzPossible{v: true}
+		}
+		c.writes[key] = true
+	}
+	m.commits = append(m.commits, c)
+}
+
+// fuzzRun is a run of the fuzzer's commands.
+type fuzzRun struct {
+	t       *testing.T
+	model   fuzzModel
+	conns   [fuzzConns]*Connection
+	txs     [fuzzConns]*fuzzTx
+	written int
+	trace   []string
+}
+
+func (r *fuzzRun) fatalf(format string, args ...any) {
+	r.t.Helper()
+	r.t.Fatalf("%s: %s\n%s", r.model.level, fmt.Sprintf(format, args...), strings.Join(r.trace, "\n"))
+}
+
+func (r *fuzzRun) exec(i int, command string, args ...string) (Result, error) {
+	res, err := r.conns[i].execCommand(command, args)
+	line := fmt.Sprintf("c%d %s %s", i, command, strings.Join(args, " "))
+	if err != nil {
+		line += fmt.Sprintf(" (%v)", err)
+	} else if command == "get" {
+		line += " = " + res.Value
+	}
+	r.trace = append(r.trace, line)
+	return res, err
+}
+
+// step runs one command on connection i, a statement in a transaction
+// of its own if it has none in progress.
+func (r *fuzzRun) step(i int, command, key string) {
+	m := &r.model
+	tx := r.txs[i]
+	autocommit := tx == nil
+	if autocommit {
+		tx = m.begin()
+	}
+	var err error
+
+	switch command {
+	case "begin":
+		if !autocommit {
+			return
+		}
+		if _, err := r.exec(i, "begin"); err != nil {
+			r.fatalf("begin: %v", err)
+		}
+		r.txs[i] = tx
+		return
+
+	case "get":
+		var res Result
+		res, err = r.exec(i, "get", key)
+		if err != nil && !res.NotFound {
+			r.fatalf("get: %v", err)
+		}
+		if p := m.visible(tx, key); m.level > ReadUncommitedIsolation && !p[res.Value] {
+			r.fatalf("read %s = %q, not one of %s", key, res.Value, p)
+		}
+		tx.readset[key] = true
+		if _, ok := tx.writes[key]; !ok {
+			if _, ok := tx.reads[key]; !ok {
+				tx.reads[key] = res.Value
+			}
+		}
+
+	case "set":
+		r.written++
+		value := fmt.Sprintf("c%d.%d", i, r.written)
+		if _, err = r.exec(i, "set", key, value); err != nil {
+			r.fatalf("set: %v", err)
+		}
+		tx.writes[key] = value
+
+	case "delete":
+		_, err = r.exec(i, "delete", key)
+		p := m.visible(tx, key)
+		if m.level > ReadUncommitedIsolation && (err == nil && !p.exists() || err != nil && !p[""]) {
+			r.fatalf("delete %s: %v, with %s", key, err, p)
+		}
+		if err == nil {
+			tx.writes[key] = ""
+		}
+
+	case "commit", "abort":
+		if autocommit {
+			if _, err := r.exec(i, command); !errors.Is(err, ErrNoTransaction) {
+				r.fatalf("%s outside a transaction: %v", command, err)
+			}
+			return
+		}
+		_, err = r.exec(i, command)
+		r.txs[i] = nil
+		var conflict *ConflictError
+		switch {
+		case command == "abort" && err == nil:
+		case command == "abort":
+			r.fatalf("abort: %v", err)
+		case m.conflicts(tx) && !errors.As(err, &conflict):
+			r.fatalf("commit should conflict: %v", err)
+		case !m.conflicts(tx) && err != nil:
+			r.fatalf("commit: %v", err)
+		case err == nil:
+			m.commit(tx)
+		}
+		return
+
+	case "vacuum":
+		removed := r.conns[i].db.Vacuum()
+		r.trace = append(r.trace, fmt.Sprintf("vacuum removed %d", removed))
+		return
+	}
+
+	// A statement of its own commits unless it fails.
+	if autocommit && err == nil {
+		m.commit(tx)
+	}
+}
+
+func (p fuzzPossible) exists() bool {
+	for v := range p {
+		if v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+var fuzzCommands = []string{"begin", "get", "set", "delete", "commit", "abort", "vacuum"}
+
+// runFuzz decodes data: its first byte picks the isolation level, and
+// whether to keep a bloom filter, and every two after that a connection,
+// a command and a key.
+func runFuzz(t *testing.T, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	database := newDatabase()
+	database.defaultIsolation = IsolationLevel(int(data[0]) % int(SerializableIsolation+1))
+	if data[0]&0x80 != 0 {
+		database.apply(WithBloomFilter(0.01))
+	}
+	r := &fuzzRun{t: t, model: fuzzModel{level: database.defaultIsolation, committed: map[string]fuzzPossible{}}}
+	for i := range r.conns {
+		r.conns[i] = database.newConnection()
+	}
+
+	for data = data[1:]; len(data) >= 2; data = data[2:] {
+		i := int(data[0]) % fuzzConns
+		command := fuzzCommands[int(data[0])/fuzzConns%len(fuzzCommands)]
+		r.step(i, command, "k"+strconv.Itoa(int(data[1])%fuzzKeys))
+	}
+
+	// Whatever is left aborts, and what committed is there to read.
+	for i, tx := range r.txs {
+		if tx != nil {
+			r.step(i, "abort", "")
+		}
+	}
+	if counted := countedBytes(&database); database.versionBytes != counted {
+		t.Fatalf("versionBytes is %d, versions hold %d", database.versionBytes, counted)
+	}
+	if r.model.level == ReadUncommitedIsolation {
+		return
+	}
+	for k := range fuzzKeys {
+		r.step(0, "get", "k"+strconv.Itoa(k))
+	}
+}
+
+// fuzzOp encodes command on connection i, with key, as runFuzz decodes
+// it.
+func fuzzOp(i int, command string, key int) []byte {
+	return []byte{byte(slices.Index(fuzzCommands, command)*fuzzConns + i), byte(key)}
+}
+
+func FuzzConnections(f *testing.F) {
+	for level := ReadUncommitedIsolation; level <= SerializableIsolation; level++ {
+		// Two transactions each move a key on, concurrently.
+		f.Add(slices.Concat([]byte{byte(level)},
+			fuzzOp(0, "set", 0),
+			fuzzOp(1, "begin", 0), fuzzOp(2, "begin", 0),
+			fuzzOp(1, "get", 0), fuzzOp(2, "get", 0),
+			fuzzOp(1, "set", 0), fuzzOp(2, "set", 0),
+			fuzzOp(1, "commit", 0), fuzzOp(2, "commit", 0),
+			fuzzOp(0, "get", 0)))
+		// Write skew, and a delete racing a write.
+		f.Add(slices.Concat([]byte{byte(level)},
+			fuzzOp(0, "set", 0), fuzzOp(0, "set", 1),
+			fuzzOp(1, "begin", 0), fuzzOp(2, "begin", 0),
+			fuzzOp(1, "get", 0), fuzzOp(1, "get", 1), fuzzOp(2, "get", 0), fuzzOp(2, "get", 1),
+			fuzzOp(1, "delete", 0), fuzzOp(2, "delete", 1),
+			fuzzOp(0, "set", 1), fuzzOp(1, "get", 1),
+			fuzzOp(1, "commit", 0), fuzzOp(0, "vacuum", 0), fuzzOp(2, "commit", 0)))
+	}
+	f.Fuzz(runFuzz)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Rohianon/mvcc/mvccpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//go:generate protoc --proto_path=mvccpb --go_out=mvccpb --go_opt=paths=source_relative --go-grpc_out=mvccpb --go-grpc_opt=paths=source_relative mvcc.proto
+
+/*
+The gRPC service (see mvccpb/mvcc.proto) is a thin layer over Connection,
+like the TCP servers. gRPC calls don't belong to a connection, so they
+name their transaction by a handle (see sessions.go), and with RequireAuth
+each authenticates (see auth.go).
+
+Scan streams from an Iterator, so only one key is in hand at once and the
+database lock is released between keys.
+
+Keys and values are proto3 strings, which must be valid UTF-8, so binary
+ones have to go through another frontend.
+*/
+
+type GRPCServer struct {
+	mvccpb.UnimplementedMVCCServer
+
+	sessions *txSessions
+}
+
+func NewGRPCServer(db *Database) *GRPCServer {
+	return &GRPCServer{sessions: newTxSessions(db)}
+}
+
+// Register adds the service to gs.
+func (s *GRPCServer) Register(gs *grpc.Server) {
+	mvccpb.RegisterMVCCServer(gs, s)
+}
+
+// RequireAuth makes every call authenticate as one of users, and run as
+// that user. It must be called before the server starts serving.
+func (s *GRPCServer) RequireAuth(users []User) {
+	s.sessions.users = usersByName(users)
+}
+
+// Close aborts the transactions that were begun and never finished.
+func (s *GRPCServer) Close() {
+	s.sessions.close()
+}
+
+// authenticate checks the credentials in the call's authorization
+// metadata, if the server requires them.
+func (s *GRPCServer) authenticate(ctx context.Context) (context.Context, error) {
+	var name, password string
+	var given bool
+	if auth := metadata.ValueFromIncomingContext(ctx, "authorization"); len(auth) > 0 {
+		name, password, given = parseBasicAuth(auth[0])
+	}
+	ctx, err := s.sessions.authenticate(ctx, name, password, given)
+	return ctx, grpcError(err)
+}
+
+func (s *GRPCServer) Begin(ctx context.Context, req *mvccpb.BeginRequest) (*mvccpb.BeginResponse, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	txId, err := s.sessions.begin(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &mvccpb.BeginResponse{TxId: txId}, nil
+}
+
+func (s *GRPCServer) Exec(ctx context.Context, req *mvccpb.ExecRequest) (*mvccpb.ExecResponse, error) {
+	switch req.Command {
+	case "begin", "commit", "abort":
+		return nil, status.Errorf(codes.InvalidArgument, "use the %s call rather than exec", req.Command)
+	case "auth":
+		return nil, status.Error(codes.InvalidArgument, "authenticate with authorization metadata rather than exec")
+	}
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.sessions.run(ctx, req.TxId, req.Command, req.Args...)
+	if err != nil && !res.NotFound {
+		return nil, grpcError(err)
+	}
+
+	resp := &mvccpb.ExecResponse{Value: res.Value, NotFound: res.NotFound, Keys: res.Keys}
+	for _, kv := range res.Pairs {
+		resp.Pairs = append(resp.Pairs, &mvccpb.KeyValue{Key: kv.Key, Value: kv.Value})
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) Get(ctx context.Context, req *mvccpb.GetRequest) (*mvccpb.GetResponse, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.sessions.run(ctx, req.TxId, "get", req.Key)
+	if res.NotFound {
+		return &mvccpb.GetResponse{}, nil
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &mvccpb.GetResponse{Value: res.Value, Found: true}, nil
+}
+
+func (s *GRPCServer) Set(ctx context.Context, req *mvccpb.SetRequest) (*mvccpb.SetResponse, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.sessions.run(ctx, req.TxId, "set", req.Key, req.Value); err != nil {
+		return nil, grpcError(err)
+	}
+	return &mvccpb.SetResponse{}, nil
+}
+
+func (s *GRPCServer) Scan(req *mvccpb.ScanRequest, stream mvccpb.MVCC_ScanServer) error {
+	opts := ScanOptions{Start: req.Start, End: req.End, Prefix: req.Prefix, Limit: int(req.Limit)}
+	if req.Descending {
+		opts.Order = Descending
+	}
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	if err := s.sessions.authorizeScan(ctx, opts); err != nil {
+		return grpcError(err)
+	}
+
+	err = s.sessions.with(ctx, req.TxId, func(tx *Transaction) error {
+		it := tx.NewIterator(opts)
+		for it.Next() {
+			if err := stream.Send(&mvccpb.KeyValue{Key: it.Key(), Value: it.Value()}); err != nil {
+				return err
+			}
+		}
+		return it.Err()
+	})
+	return grpcError(err)
+}
+
+// Commit fails with Aborted if the transaction could not commit, for a
+// conflict or otherwise, since it is aborted then.
+func (s *GRPCServer) Commit(ctx context.Context, req *mvccpb.CommitRequest) (*mvccpb.CommitResponse, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sessions.finish(ctx, req.TxId, "commit"); err != nil {
+		return nil, grpcCommitError(err)
+	}
+	return &mvccpb.CommitResponse{}, nil
+}
+
+func (s *GRPCServer) Abort(ctx context.Context, req *mvccpb.AbortRequest) (*mvccpb.AbortResponse, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sessions.finish(ctx, req.TxId, "abort"); err != nil {
+		return nil, grpcError(err)
+	}
+	return &mvccpb.AbortResponse{}, nil
+}
+
+// grpcError gives err the status code that best describes it.
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	code := codes.Unknown
+	switch {
+	case errors.Is(err, ErrTransactionAborted):
+		code = codes.Aborted
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrUnknownTransaction):
+		code = codes.NotFound
+	case errors.Is(err, ErrNoTransaction), errors.Is(err, ErrTransactionInProgress), errors.Is(err, ErrNotInteger):
+		code = codes.FailedPrecondition
+	case errors.Is(err, ErrDatabaseShutdown), errors.Is(err, ErrConnectionClosed):
+		code = codes.Unavailable
+	case errors.Is(err, ErrAuthRequired), errors.Is(err, ErrBadCredentials):
+		code = codes.Unauthenticated
+	case errors.Is(err, ErrPermissionDenied):
+		code = codes.PermissionDenied
+	}
+	return status.Error(code, err.Error())
+}
+
+// grpcCommitError is grpcError for a failed commit. A transaction that
+// fails to commit for any reason of its own, such as a conflict, is
+// aborted.
+func grpcCommitError(err error) error {
+	if errors.Is(err, ErrUnknownTransaction) || errors.Is(err, ErrNoTransaction) {
+		return grpcError(err)
+	}
+	return status.Error(codes.Aborted, err.Error())
+}
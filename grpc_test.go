@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Rohianon/mvcc/mvccpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func startGRPCServer(t *testing.T, db *Database, users ...User) mvccpb.MVCCClient {
+	ln := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	srv := NewGRPCServer(db)
+	if users != nil {
+		srv.RequireAuth(users)
+	}
+	srv.Register(gs)
+	go gs.Serve(ln)
+	t.Cleanup(func() {
+		gs.Stop()
+		srv.Close()
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assertEq(err, nil, "dial")
+	t.Cleanup(func() { conn.Close() })
+	return mvccpb.NewMVCCClient(conn)
+}
+
+func TestGRPC(t *testing.T) {
+	database := newDatabase()
+	client := startGRPCServer(t, &database)
+	ctx := context.Background()
+
+	_, err := client.Set(ctx, &mvccpb.SetRequest{Key: "a", Value: "1"})
+	assertEq(err, nil, "autocommit set")
+
+	begin, err := client.Begin(ctx, &mvccpb.BeginRequest{})
+	assertEq(err, nil, "begin")
+	tx := begin.TxId
+	_, err = client.Set(ctx, &mvccpb.SetRequest{TxId: tx, Key: "b", Value: "2"})
+	assertEq(err, nil, "set in transaction")
+
+	got, err := client.Get(ctx, &mvccpb.GetRequest{Key: "b"})
+	assertEq(err, nil, "get outside the transaction")
+	assert(!got.Found, "uncommitted write invisible")
+	got, err = client.Get(ctx, &mvccpb.GetRequest{TxId: tx, Key: "b"})
+	assertEq(err, nil, "get in the transaction")
+	assertEq(got.Value, "2", "own write visible")
+
+	exec, err := client.Exec(ctx, &mvccpb.ExecRequest{TxId: tx, Command: "incr", Args: []string{"a"}})
+	assertEq(err, nil, "exec")
+	assertEq(exec.Value, "2", "incr")
+
+	_, err = client.Commit(ctx, &mvccpb.CommitRequest{TxId: tx})
+	assertEq(err, nil, "commit")
+	_, err = client.Commit(ctx, &mvccpb.CommitRequest{TxId: tx})
+	assertEq(status.Code(err), codes.NotFound, "transaction gone after commit")
+
+	got, err = client.Get(ctx, &mvccpb.GetRequest{Key: "b"})
+	assertEq(err, nil, "get after commit")
+	assertEq(got.Value, "2", "committed write visible")
+
+	_, err = client.Exec(ctx, &mvccpb.ExecRequest{Command: "begin"})
+	assertEq(status.Code(err), codes.InvalidArgument, "begin through exec")
+	_, err = client.Exec(ctx, &mvccpb.ExecRequest{Command: "incr", Args: []string{"b", "x"}})
+	assertEq(status.Code(err), codes.FailedPrecondition, "bad increment")
+}
+
+func TestGRPC_conflict(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	client := startGRPCServer(t, &database)
+	ctx := context.Background()
+
+	t1, _ := client.Begin(ctx, &mvccpb.BeginRequest{})
+	t2, _ := client.Begin(ctx, &mvccpb.BeginRequest{})
+	client.Set(ctx, &mvccpb.SetRequest{TxId: t1.TxId, Key: "x", Value: "1"})
+	client.Set(ctx, &mvccpb.SetRequest{TxId: t2.TxId, Key: "x", Value: "2"})
+	_, err := client.Commit(ctx, &mvccpb.CommitRequest{TxId: t1.TxId})
+	assertEq(err, nil, "first commit")
+	_, err = client.Commit(ctx, &mvccpb.CommitRequest{TxId: t2.TxId})
+	assertEq(status.Code(err), codes.Aborted, "conflicting commit")
+
+	t3, _ := client.Begin(ctx, &mvccpb.BeginRequest{})
+	_, err = client.Abort(ctx, &mvccpb.AbortRequest{TxId: t3.TxId})
+	assertEq(err, nil, "abort")
+}
+
+func TestGRPC_Scan(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	for i := range 100 {
+		c.mustExecCommand("set", []string{fmt.Sprintf("k%03d", i), fmt.Sprint(i)})
+	}
+	client := startGRPCServer(t, &database)
+	ctx := context.Background()
+
+	scan := func(req *mvccpb.ScanRequest) []string {
+		stream, err := client.Scan(ctx, req)
+		assertEq(err, nil, "scan")
+		var keys []string
+		for {
+			kv, err := stream.Recv()
+			if err == io.EOF {
+				return keys
+			}
+			assertEq(err, nil, "recv")
+			keys = append(keys, kv.Key)
+		}
+	}
+
+	assertEq(len(scan(&mvccpb.ScanRequest{})), 100, "whole range")
+	assertEq(fmt.Sprint(scan(&mvccpb.ScanRequest{Prefix: "k09", Descending: true})), "[k099 k098 k097 k096 k095 k094 k093 k092 k091 k090]", "prefix, descending")
+	assertEq(fmt.Sprint(scan(&mvccpb.ScanRequest{Start: "k010", End: "k020", Limit: 3})), "[k010 k011 k012]", "range with limit")
+
+	// In a transaction, the scan sees its writes.
+	tx, _ := client.Begin(ctx, &mvccpb.BeginRequest{})
+	client.Set(ctx, &mvccpb.SetRequest{TxId: tx.TxId, Key: "k100", Value: "new"})
+	assertEq(len(scan(&mvccpb.ScanRequest{TxId: tx.TxId})), 101, "own write scanned")
+	assertEq(len(scan(&mvccpb.ScanRequest{})), 100, "not yet visible to others")
+}
+
+func TestGRPC_auth(t *testing.T) {
+	database := newDatabase()
+	client := startGRPCServer(t, &database, User{"root", "secret", true}, User{"alice", "pw", false})
+	as := func(name, password string) context.Context {
+		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(name+":"+password))
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", auth)
+	}
+	root, alice := as("root", "secret"), as("alice", "pw")
+
+	_, err := client.Exec(context.Background(), &mvccpb.ExecRequest{Command: "abortall"})
+	assertEq(status.Code(err), codes.Unauthenticated, "anonymous")
+	_, err = client.Begin(as("alice", "wrong"), &mvccpb.BeginRequest{})
+	assertEq(status.Code(err), codes.Unauthenticated, "wrong password")
+
+	_, err = client.Exec(root, &mvccpb.ExecRequest{Command: "grant", Args: []string{"alice", "app/", "rw"}})
+	assertEq(err, nil, "admin grants")
+	_, err = client.Set(alice, &mvccpb.SetRequest{Key: "app/x", Value: "1"})
+	assertEq(err, nil, "alice writes her prefix")
+	_, err = client.Set(alice, &mvccpb.SetRequest{Key: "other", Value: "1"})
+	assertEq(status.Code(err), codes.PermissionDenied, "alice outside her prefix")
+	_, err = client.Exec(alice, &mvccpb.ExecRequest{Command: "abortall"})
+	assertEq(status.Code(err), codes.PermissionDenied, "admin command")
+	stream, err := client.Scan(alice, &mvccpb.ScanRequest{})
+	assertEq(err, nil, "scan")
+	_, err = stream.Recv()
+	assertEq(status.Code(err), codes.PermissionDenied, "alice scans everything")
+
+	// A transaction is only alice's to use.
+	begin, err := client.Begin(alice, &mvccpb.BeginRequest{})
+	assertEq(err, nil, "begin")
+	_, err = client.Get(root, &mvccpb.GetRequest{TxId: begin.TxId, Key: "app/x"})
+	assertEq(status.Code(err), codes.NotFound, "another user's transaction")
+	_, err = client.Commit(alice, &mvccpb.CommitRequest{TxId: begin.TxId})
+	assertEq(err, nil, "commit")
+}
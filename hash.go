@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+A hash maps fields to values under one key, for records an application
+would otherwise keep as a JSON document or spread over several keys.
+Like a list (see list.go), it is a value of its own type, and every
+change writes the whole hash as a new version, so a transaction sees the
+hash as it was in its snapshot, and two transactions setting fields of
+one hash conflict, even different fields.
+
+As in Redis, setting a field of a missing key makes a hash, and deleting
+its last field deletes the key. The fields are kept in order, each
+followed by its value, each preceded by its length.
+*/
+
+func encodeHash(fields []KeyValue) string {
+	var b frameBody
+	for _, f := range fields {
+		b = b.string(f.Key).string(f.Value)
+	}
+	return string(b)
+}
+
+func decodeHash(raw string) []KeyValue {
+	var fields []KeyValue
+	r := frameReader{b: []byte(raw)}
+	for len(r.b) > 0 && r.err == nil {
+		fields = append(fields, KeyValue{r.string(), r.string()})
+	}
+	return fields
+}
+
+func formatHash(fields []KeyValue) string {
+	m := make(map[string]string, len(fields))
+	for _, f := range fields {
+		m[f.Key] = f.Value
+	}
+	return encodeJSON(m)
+}
+
+func parseHash(s string) ([]KeyValue, error) {
+	var m map[string]string
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, err
+	}
+	var fields []KeyValue
+	for field, value := range m {
+		fields = append(fields, KeyValue{field, value})
+	}
+	slices.SortFunc(fields, compareFields)
+	return fields, nil
+}
+
+func compareFields(a, b KeyValue) int {
+	return strings.Compare(a.Key, b.Key)
+}
+
+// hash returns the fields of the hash held by key, in order, or none if
+// the key is missing. The caller must hold the lock.
+func (t *Transaction) hash(key string) ([]KeyValue, error) {
+	value, err := t.get(key)
+	if err != nil {
+		return nil, nil
+	}
+	if value.data.kind != HashType {
+		return nil, wrongType(key, value.data.kind, HashType)
+	}
+	raw, err := t.db.readRaw(value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHash(raw), nil
+}
+
+// HSet sets fields of the hash held by key, returning how many of them
+// it didn't have before.
+func (t *Transaction) HSet(key string, fields ...KeyValue) (int, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, err
+	}
+	hash, err := t.hash(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, f := range fields {
+		i, found := slices.BinarySearchFunc(hash, f, compareFields)
+		if found {
+			hash[i].Value = f.Value
+		} else {
+			hash = slices.Insert(hash, i, f)
+			added++
+		}
+	}
+	t.write(key, encodeHash(hash), HashType, 0)
+	return added, nil
+}
+
+// HGet returns the value of field in the hash held by key.
+func (t *Transaction) HGet(key, field string) (string, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return "", err
+	}
+	hash, err := t.hash(key)
+	if err != nil {
+		return "", err
+	}
+	i, found := slices.BinarySearchFunc(hash, KeyValue{Key: field}, compareFields)
+	if !found {
+		return "", ErrKeyNotFound
+	}
+	return hash[i].Value, nil
+}
+
+// HDel deletes fields from the hash held by key, returning how many of
+// them it had.
+func (t *Transaction) HDel(key string, fields ...string) (int, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, err
+	}
+	hash, err := t.hash(key)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, field := range fields {
+		if i, found := slices.BinarySearchFunc(hash, KeyValue{Key: field}, compareFields); found {
+			hash = slices.Delete(hash, i, i+1)
+			removed++
+		}
+	}
+	switch {
+	case removed == 0:
+	case len(hash) == 0:
+		t.delete(key)
+	default:
+		t.write(key, encodeHash(hash), HashType, 0)
+	}
+	return removed, nil
+}
+
+// HGetAll returns the fields of the hash held by key, in order.
+func (t *Transaction) HGetAll(key string) ([]KeyValue, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return nil, err
+	}
+	return t.hash(key)
+}
+
+// hset key field value...
+func (c *Connection) hset(args []string) (Result, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return Result{}, fmt.Errorf("hset: expected a key and field value pairs")
+	}
+	fields := make([]KeyValue, len(args)/2)
+	for i := range fields {
+		fields[i] = KeyValue{args[1+2*i], args[2+2*i]}
+	}
+
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	n, err := c.tx.HSet(args[0], fields...)
+	if err != nil {
+		return res, err
+	}
+	res.Value = strconv.Itoa(n)
+	return res, nil
+}
+
+// hget key field
+func (c *Connection) hget(args []string) (Result, error) {
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	value, err := c.tx.HGet(args[0], args[1])
+	res.Value = value
+	res.NotFound = errors.Is(err, ErrKeyNotFound)
+	return res, err
+}
+
+// hdel key field...
+func (c *Connection) hdel(args []string) (Result, error) {
+	if len(args) < 2 {
+		return Result{}, fmt.Errorf("hdel: expected a key and fields")
+	}
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	n, err := c.tx.HDel(args[0], args[1:]...)
+	if err != nil {
+		return res, err
+	}
+	res.Value = strconv.Itoa(n)
+	return res, nil
+}
+
+// hgetall key
+func (c *Connection) hgetall(args []string) (Result, error) {
+	fields, err := c.tx.HGetAll(args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	lines := make([]string, len(fields))
+	for i, f := range fields {
+		lines[i] = f.Key + "=" + f.Value
+	}
+	return Result{Keys: args[:1], TxId: c.tx.id, Pairs: fields, Value: strings.Join(lines, "\n")}, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	c := database.newConnection()
+
+	assertEq(c.mustExecCommand("hset", []string{"h", "name", "ann", "city", "oslo"}), "2", "hset")
+	assertEq(c.mustExecCommand("hset", []string{"h", "city", "rome", "age", "30"}), "1", "one new field")
+	assertEq(c.mustExecCommand("hget", []string{"h", "city"}), "rome", "hget")
+	_, err := c.execCommand("hget", []string{"h", "email"})
+	assertEq(err, ErrKeyNotFound, "missing field")
+	assertEq(c.mustExecCommand("hgetall", []string{"h"}), "age=30\ncity=rome\nname=ann", "hgetall")
+	assertEq(c.mustExecCommand("get", []string{"h"}), `{"age":"30","city":"rome","name":"ann"}`, "as a string")
+	assertEq(c.mustExecCommand("type", []string{"h"}), "hash", "type")
+	_, err = c.execCommand("hset", []string{"h", "odd"})
+	assert(err != nil, "fields need values")
+
+	// Each transaction sees the hash in its snapshot.
+	snapshot := database.newConnection()
+	snapshot.mustExecCommand("begin", nil)
+	assertEq(c.mustExecCommand("hdel", []string{"h", "age", "email"}), "1", "hdel")
+	assertEq(snapshot.mustExecCommand("hget", []string{"h", "age"}), "30", "snapshot")
+	snapshot.mustExecCommand("abort", nil)
+
+	// Setting different fields still conflicts.
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	t1.HSet("h", KeyValue{"name", "bob"})
+	t2.HSet("h", KeyValue{"city", "oslo"})
+	assertEq(t1.Commit(), nil, "first")
+	var conflict *ConflictError
+	assert(errors.As(t2.Commit(), &conflict), "second conflicts")
+
+	assertEq(c.mustExecCommand("hdel", []string{"h", "name", "city"}), "2", "hdel the rest")
+	_, err = c.execCommand("get", []string{"h"})
+	assertEq(err, ErrKeyNotFound, "deleting the last field deletes the key")
+
+	c.mustExecCommand("rpush", []string{"l", "x"})
+	_, err = c.execCommand("hget", []string{"l", "x"})
+	assert(errors.Is(err, ErrWrongType), "not a hash")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+The history command shows a key's whole version chain, oldest first,
+regardless of what any transaction can see: who created each version, who
+(if anyone) ended it, and how the creating transaction finished. Reading
+it next to a transaction's id and isolation level is usually enough to
+work out why a get returned what it did.
+*/
+
+// History returns every version of key, oldest first, along with the
+// final state of the transaction that wrote each one.
+func (d *Database) History(key string) []VersionInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.keyHistory(key)
+}
+
+func (v VersionInfo) String() string {
+	return fmt.Sprintf("start=%d end=%d state=%s value=%q", v.TxStartId, v.TxEndId, v.State, v.Value)
+}
+
+// history key
+func (c *Connection) history(args []string) (Result, error) {
+	if len(args) != 1 {
+		return Result{}, fmt.Errorf("history: expected a key")
+	}
+
+	versions := c.db.History(args[0])
+	lines := make([]string, len(versions))
+	for i, v := range versions {
+		lines[i] = v.String()
+	}
+	return Result{Value: strings.Join(lines, "\n"), Keys: args}, nil
+}
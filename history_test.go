@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestHistory(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "one"})
+	c1.mustExecCommand("commit", nil)
+
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "two"})
+	c1.mustExecCommand("abort", nil)
+
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("delete", []string{"x"})
+	c1.mustExecCommand("set", []string{"x", "three"})
+
+	res := c1.mustExecCommand("history", []string{"x"})
+	assertEq(res, `start=1 end=3 state=committed value="one"
+start=2 end=0 state=aborted value="two"
+start=3 end=0 state=in-progress value="three"`, "history")
+
+	c1.mustExecCommand("commit", nil)
+	res = c1.mustExecCommand("history", []string{"x"})
+	assertEq(res, `start=1 end=3 state=committed value="one"
+start=2 end=0 state=aborted value="two"
+start=3 end=0 state=committed value="three"`, "history after commit")
+
+	res = c1.mustExecCommand("history", []string{"y"})
+	assertEq(res, "", "history of a missing key")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Transaction ids say what began before what, but not when anything
+happened, and not in an order two replicated nodes agree on without a
+leader. So each commit is also stamped with a hybrid logical clock: the
+wall clock in milliseconds, with a counter in the low bits that moves the
+stamp on whenever the wall clock hasn't, so stamps are unique and never go
+backwards on a node even if its clock does. A node that replays commits
+from elsewhere, from its log or a leader, takes on their stamps, so its
+own later commits are stamped after them.
+
+The stamp goes in the commit record, so it survives recovery and reaches
+followers and raft peers along with the rest of the commit.
+
+"get key asof <time>" reads key as it was at a time rather than as of a
+transaction: as a snapshot that sees every transaction that committed by
+then. Only commits still in the registry are known apart (see
+registry.go), so a time older than the latest pruned commit can't be
+read, any more than a pruned transaction id can.
+*/
+
+var ErrTimeTooOld = errors.New("time is older than the history kept")
+
+// HLC is a hybrid logical clock timestamp: milliseconds since the Unix
+// epoch above the low 16 bits, and a logical counter in them.
+type HLC uint64
+
+const hlcLogicalBits = 16
+
+const maxHLCLogical = 1<<hlcLogicalBits - 1
+
+func newHLC(t time.Time, logical uint16) HLC {
+	return HLC(uint64(t.UnixMilli())<<hlcLogicalBits | uint64(logical))
+}
+
+// Time returns the wall clock part of the timestamp.
+func (h HLC) Time() time.Time {
+	return time.UnixMilli(int64(h >> hlcLogicalBits))
+}
+
+// Logical returns the counter part of the timestamp.
+func (h HLC) Logical() uint16 {
+	return uint16(h & maxHLCLogical)
+}
+
+// String formats the timestamp as its time and counter, as in
+// "2024-05-01T12:00:00.123Z/2", which parseHLC reads back.
+func (h HLC) String() string {
+	return h.Time().UTC().Format("2006-01-02T15:04:05.000Z07:00") + "/" + strconv.Itoa(int(h.Logical()))
+}
+
+// parseHLC parses a timestamp as String formats it, or a time in RFC 3339
+// format, which stands for the last timestamp in its millisecond.
+func parseHLC(s string) (HLC, error) {
+	s, counter, hasCounter := strings.Cut(s, "/")
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	if !hasCounter {
+		return newHLC(t, maxHLCLogical), nil
+	}
+	logical, err := strconv.ParseUint(counter, 10, hlcLogicalBits)
+	if err != nil {
+		return 0, fmt.Errorf("bad logical counter %q", counter)
+	}
+	return newHLC(t, uint16(logical)), nil
+}
+
+// tickHLC returns a new timestamp, later than any the database has
+// issued or observed.
+func (d *Database) tickHLC() HLC {
+	if now := newHLC(d.clock(), 0); now > d.hlc {
+		d.hlc = now
+	} else {
+		d.hlc++
+	}
+	return d.hlc
+}
+
+// observeHLC moves the clock on past h, a timestamp from elsewhere.
+func (d *Database) observeHLC(h HLC) {
+	d.hlc = max(d.hlc, h)
+}
+
+// CommitTimestamp returns when the transaction committed, or zero if it
+// hasn't, or it is not known.
+func (t *Transaction) CommitTimestamp() HLC {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	return t.committedAt
+}
+
+// commitTimestamp returns when the transaction id committed, or zero if
+// that isn't known.
+func (d *Database) commitTimestamp(id uint64) HLC {
+	if t, ok := d.transactions.Get(id); ok && t.state == CommittedTransaction {
+		return t.committedAt
+	}
+	return 0
+}
+
+// timeSnapshot returns a read-only transaction that sees what had
+// committed at ts.
+func (d *Database) timeSnapshot(ts HLC) (*Transaction, error) {
+	if ts < d.hlcPruned {
+		return nil, fmt.Errorf("read as of %s: %w", ts, ErrTimeTooOld)
+	}
+
+	// It comes after every transaction there is, and treats those that
+	// hadn't committed at ts as in progress.
+	t := &Transaction{
+		isolation:  RepeatableReadIsolation,
+		id:         d.nextTransactionId,
+		state:      InProgressTransaction,
+		started:    ts.Time(),
+		historical: true,
+		db:         d,
+	}
+	t.inprogress = d.snapshotAt(ts, t.id)
+	return t, nil
+}
+
+// snapshotAt returns a snapshot, taken before xmax was handed out, in
+// which the transactions that hadn't committed at ts are in progress.
+func (d *Database) snapshotAt(ts HLC, xmax uint64) snapshot {
+	var running []uint64
+	iter := d.transactions.Iter()
+	for ok := iter.First(); ok && iter.Key() < xmax; ok = iter.Next() {
+		if tx := iter.Value(); tx.state != CommittedTransaction || tx.committedAt > ts {
+			running = append(running, tx.id)
+		}
+	}
+	return newSnapshot(xmax, running)
+}
+
+// GetAsOfTime returns the value key had at time at.
+func (d *Database) GetAsOfTime(key string, at time.Time) (string, error) {
+	return d.getAsOfHLC(key, newHLC(at, maxHLCLogical))
+}
+
+func (d *Database) getAsOfHLC(key string, ts HLC) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, err := d.timeSnapshot(ts)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := t.get(key)
+	if err != nil {
+		return "", err
+	}
+	return d.read(value)
+}
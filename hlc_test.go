@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHLC(t *testing.T) {
+	database := newDatabase()
+	now := time.Unix(1000, 0)
+	database.now = func() time.Time { return now }
+
+	first := database.tickHLC()
+	assertEq(first, newHLC(now, 0), "wall clock")
+	assertEq(first.Time(), now, "time")
+	// The clock standing still, or going back, moves the counter on.
+	second := database.tickHLC()
+	assertEq(second.Logical(), uint16(1), "same millisecond")
+	now = now.Add(-time.Second)
+	assert(database.tickHLC() > second, "clock went back")
+	now = now.Add(time.Hour)
+	assertEq(database.tickHLC(), newHLC(now, 0), "clock caught up")
+
+	// Timestamps from elsewhere are observed.
+	later := newHLC(now.Add(time.Minute), 5)
+	database.observeHLC(later)
+	assertEq(database.tickHLC(), later+1, "after an observed stamp")
+
+	assertEq(later.String(), "1970-01-01T01:17:39.000Z/5", "string")
+	parsed, err := parseHLC(later.String())
+	assertEq(err, nil, "parse")
+	assertEq(parsed, later, "round trip")
+	parsed, _ = parseHLC("1970-01-01T01:17:39Z")
+	assertEq(parsed, newHLC(later.Time(), maxHLCLogical), "a time is the end of its millisecond")
+	_, err = parseHLC("yesterday")
+	assert(err != nil, "not a time")
+}
+
+func TestGetAsOfTime(t *testing.T) {
+	database := newDatabase()
+	database.retention.Transactions = 100
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	database.now = func() time.Time { return now }
+	c := database.newConnection()
+
+	c.mustExecCommand("set", []string{"x", "one"})
+	now = now.Add(time.Minute)
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "two"})
+	tx := c.tx
+	c.mustExecCommand("commit", nil)
+	// In the same millisecond, so told apart by the counter.
+	c.mustExecCommand("delete", []string{"x"})
+
+	asof := func(at string) (string, error) {
+		res, err := c.execCommand("get", []string{"x", "asof", at})
+		return res.Value, err
+	}
+	res, _ := asof("2024-05-01T12:00:30Z")
+	assertEq(res, "one", "before the second commit")
+	res, _ = asof(tx.CommitTimestamp().String())
+	assertEq(res, "two", "as of the commit")
+	_, err := asof("2024-05-01T12:01:00Z")
+	assertEq(err, ErrKeyNotFound, "the end of the millisecond")
+	_, err = asof("2024-05-01T11:00:00Z")
+	assertEq(err, ErrKeyNotFound, "before anything")
+	_, err = asof("noon")
+	assert(err != nil, "not a time")
+
+	value, err := database.GetAsOfTime("x", now.Add(-time.Second))
+	assertEq(err, nil, "GetAsOfTime")
+	assertEq(value, "one", "GetAsOfTime")
+
+	// Times before what the registry has pruned can't be read.
+	database.retention.Transactions = 0
+	c.mustExecCommand("set", []string{"y", "1"})
+	_, err = asof("2024-05-01T12:00:30Z")
+	assert(errors.Is(err, ErrTimeTooOld), "pruned")
+}
+
+func TestHLC_recovery(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+	tx, _ := database.Begin()
+	tx.Set("x", "1")
+	assertEq(tx.Commit(), nil, "commit")
+	before := tx.CommitTimestamp()
+	crash(database)
+
+	// The reopened database's clock is behind, but its stamps still
+	// come after those in the log.
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	database.now = func() time.Time { return before.Time().Add(-time.Hour) }
+	tx, _ = database.Begin()
+	tx.Set("x", "2")
+	assertEq(tx.Commit(), nil, "commit")
+	assertEq(tx.CommitTimestamp(), before+1, "after recovery")
+}
@@ -0,0 +1,61 @@
+package main
+
+/*
+Applications often need to do something only once a transaction's outcome
+is known: invalidate a cache entry, publish an event. Doing that before
+Commit returns risks acting on writes that end up aborted by a conflict,
+and doing it after means threading the outcome back through every caller.
+So transactions accept callbacks for either outcome.
+
+Hooks run after the database lock has been released, so they may freely
+start new transactions of their own. They run in registration order, on
+whichever goroutine completed the transaction (which, for a transaction
+aborted by Shutdown, is not the owner's).
+*/
+
+// OnCommit registers fn to run after the transaction commits. Registering
+// on an already completed transaction has no effect.
+func (t *Transaction) OnCommit(fn func()) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if t.state != InProgressTransaction {
+		return
+	}
+	t.onCommit = append(t.onCommit, fn)
+}
+
+// OnRollback registers fn to run after the transaction aborts, whether
+// explicitly, because of a conflict on commit, or because it was aborted
+// out from under its owner. Registering on an already completed
+// transaction has no effect.
+func (t *Transaction) OnRollback(fn func()) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if t.state != InProgressTransaction {
+		return
+	}
+	t.onRollback = append(t.onRollback, fn)
+}
+
+// takeHooks returns the hooks for the transaction's final state and
+// forgets about all of them, so each runs at most once.
+func (t *Transaction) takeHooks() []func() {
+	var hooks []func()
+	switch t.state {
+	case CommittedTransaction:
+		hooks = t.onCommit
+	case AbortedTransaction:
+		hooks = t.onRollback
+	}
+	t.onCommit = nil
+	t.onRollback = nil
+	return hooks
+}
+
+func runHooks(hooks []func()) {
+	for _, fn := range hooks {
+		fn()
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	var events []string
+	record := func(event string) func() {
+		return func() { events = append(events, event) }
+	}
+
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	t1.OnCommit(record("t1 commit"))
+	t1.OnRollback(record("t1 rollback"))
+	t2.OnCommit(record("t2 commit"))
+	t2.OnRollback(record("t2 rollback"))
+
+	t1.Set("x", "hey")
+	t2.Set("x", "yall")
+
+	// Hooks run outside the lock, so they can use the database.
+	t1.OnCommit(func() {
+		tx, _ := database.Begin()
+		value, _ := tx.Get("x")
+		events = append(events, "saw "+value)
+		tx.Abort()
+	})
+
+	assertEq(t1.Commit(), nil, "t1 commit")
+	assertEq(t2.Commit().Error(), `write-write conflict with transaction 1 on "x"`, "t2 commit")
+
+	t3, _ := database.Begin()
+	t3.OnRollback(record("t3 rollback"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	database.Shutdown(ctx)
+
+	assertEq(len(events), 4, "event count")
+	assertEq(events[0], "t1 commit", "event 0")
+	assertEq(events[1], "saw hey", "event 1")
+	assertEq(events[2], "t2 rollback", "event 2")
+	assertEq(events[3], "t3 rollback", "event 3")
+}
+
+func TestHooks_completed(t *testing.T) {
+	database := newDatabase()
+
+	tx, _ := database.Begin()
+	assertEq(tx.Commit(), nil, "commit")
+	tx.OnCommit(func() {})
+	tx.OnRollback(func() {})
+	assertEq(len(tx.onCommit)+len(tx.onRollback), 0, "not registered")
+}
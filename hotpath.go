@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Get and set are most of the traffic, so they shouldn't leave garbage
+behind them. Inside a transaction neither allocates once the key is in
+the read or write set: debug logging checks whether anything would log
+before boxing its arguments (see debugging), a log record only escapes to
+the heap when there is a log, and a span's attributes are only built when
+the span is recorded.
+
+What's left is a transaction's own bookkeeping. An auto-commit write
+begins and completes a transaction of its own, and most are forgotten by
+the registry as soon as they complete, so the batcher hands those back to
+transactionPool for the next write, keeping the capacity of their
+snapshot and undo buffers. And reading a value as a string copies any
+value stored inline (see payload.go), which AppendGet avoids by appending
+it to a buffer the caller owns and reuses.
+
+The allocation benchmarks in hotpath_test.go guard all this.
+*/
+
+var transactionPool = sync.Pool{
+	New: func() any { return new(Transaction) },
+}
+
+// recycle returns t, which has completed, to transactionPool, unless
+// something may still refer to it. It takes t's hooks first, so none
+// carries over to its next use, and returns them for the caller to run
+// once it has released the lock.
+func (d *Database) recycle(t *Transaction) []func() {
+	if t.state == InProgressTransaction {
+		return nil
+	}
+	hooks := t.takeHooks()
+	if t.parent != nil || t.merged.Len() > 0 {
+		return hooks
+	}
+	if _, ok := d.transactions.Get(t.id); ok {
+		return hooks
+	}
+
+	running := t.inprogress.running[:0]
+	clear(t.undo)
+	undo := t.undo[:0]
+	clear(t.ended)
+	ended := t.ended[:0]
+	*t = Transaction{}
+	t.inprogress.running = running
+	t.undo = undo
+	t.ended = ended
+	transactionPool.Put(t)
+	return hooks
+}
+
+// AppendGet appends the value of key visible to the transaction to dst,
+// as Get would return it, and returns the extended buffer.
+func (t *Transaction) AppendGet(dst []byte, key string) ([]byte, error) {
+	defer t.db.metrics.observe("get", time.Now())
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return dst, err
+	}
+
+	value, err := t.get(key)
+	if err != nil {
+		return dst, err
+	}
+	if value.checksum != 0 {
+		s, err := t.db.readVerified(key, value)
+		if err != nil {
+			return dst, err
+		}
+		return append(dst, s...), nil
+	}
+	if value.data.kind == StringType {
+		switch value.data.n {
+		case blobPayload:
+			return append(dst, value.data.blob...), nil
+		case segmentPayload, compressedBlobPayload:
+		default:
+			return append(dst, value.data.inline[:value.data.n]...), nil
+		}
+	}
+	s, err := t.db.read(value)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, s...), nil
+}
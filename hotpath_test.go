@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The most each operation may allocate, on average. Raising one needs a
+// reason; see hotpath.go.
+const (
+	maxGetAllocs        = 0
+	maxSetAllocs        = 0
+	maxAutoCommitAllocs = 26
+)
+
+func TestHotPathAllocs(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	database.Set("x", "hello, world")
+	tx, _ := database.Begin()
+	tx.Get("x")
+	tx.Set("y", "1")
+
+	var buf []byte
+	got := testing.AllocsPerRun(100, func() {
+		buf, _ = tx.AppendGet(buf[:0], "x")
+	})
+	assert(got <= maxGetAllocs, fmt.Sprintf("AppendGet allocates %v times", got))
+	assertEq(string(buf), "hello, world", "AppendGet")
+
+	got = testing.AllocsPerRun(100, func() { tx.Set("y", "2") })
+	assert(got <= maxSetAllocs, fmt.Sprintf("Set allocates %v times", got))
+	assertEq(tx.Commit(), nil, "commit")
+
+	got = testing.AllocsPerRun(100, func() { database.Set("x", "hello, world") })
+	assert(got <= maxAutoCommitAllocs, fmt.Sprintf("an auto-commit set allocates %v times", got))
+}
+
+func TestAppendGet(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"small", "abc"})
+	c.mustExecCommand("set", []string{"large", "a value too long to keep inline"})
+	c.mustExecCommand("set", []string{"n", "41"})
+	c.mustExecCommand("incr", []string{"n"})
+
+	tx, _ := database.Begin()
+	buf := []byte("prefix:")
+	for _, key := range []string{"small", "large", "n"} {
+		want, _ := tx.Get(key)
+		got, err := tx.AppendGet(buf, key)
+		assertEq(err, nil, key)
+		assertEq(string(got), "prefix:"+want, key)
+	}
+	got, err := tx.AppendGet(buf, "missing")
+	assertEq(err, ErrKeyNotFound, "missing")
+	assertEq(string(got), "prefix:", "missing")
+}
+
+func TestRecycle(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	reader, _ := database.Begin()
+
+	// The registry keeps what reader may yet ask about.
+	database.Set("x", "1")
+	tx, _ := database.transactions.Get(database.nextTransactionId - 1)
+	id := tx.id
+	database.recycle(tx)
+	assertEq(tx.state, CommittedTransaction, "kept")
+
+	assertEq(reader.Commit(), nil, "commit")
+	database.mu.Lock()
+	database.pruneTransactions()
+	database.recycle(tx)
+	database.mu.Unlock()
+	assertEq(tx.state, InProgressTransaction, "recycled")
+	assertEq(tx.writeset.Len(), 0, "recycled writes")
+
+	// Whatever comes out of the pool starts afresh.
+	for i := range 100 {
+		database.Set("x", fmt.Sprint(i))
+		next, _ := database.Begin()
+		value, _ := next.Get("x")
+		assertEq(value, fmt.Sprint(i), "value")
+		assertEq(next.readset.Len(), 1, "reads")
+		assertEq(next.inprogress.contains(id), false, "snapshot")
+		next.Commit()
+	}
+}
+
+func BenchmarkHotPath(b *testing.B) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	database.Set("x", "hello, world")
+	tx, _ := database.Begin()
+
+	b.Run("get", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			tx.Get("x")
+		}
+	})
+	b.Run("appendget", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for b.Loop() {
+			buf, _ = tx.AppendGet(buf[:0], "x")
+		}
+	})
+	b.Run("set", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			tx.Set("y", "1")
+		}
+	})
+	tx.Abort()
+	b.Run("autocommit", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			database.Set("x", "hello, world")
+		}
+	})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+/*
+An HTTP API, for poking at the database with curl and for clients that
+would rather not speak a protocol of their own:
+
+	POST   /tx                begin; replies {"tx": id}
+	POST   /tx/{id}/commit
+	POST   /tx/{id}/abort
+	GET    /keys/{key}        the value, as the body, and its checksum, if
+	                          it has one, in X-Checksum (see checksum.go)
+	PUT    /keys/{key}        set the value to the request body
+	DELETE /keys/{key}
+	GET    /scan              ?start=&end=, or ?prefix=; &desc=1, &limit=n,
+	                          and filters, as &gt=10 (see filter.go)
+
+Key requests and scans run in the transaction named by the X-Transaction
+header, the handle beginning replied with, or in one of their own without
+it (see sessions.go). With RequireAuth every request authenticates, with
+Basic credentials (see auth.go). A scan
+replies with a JSON array of {"key", "value"} objects, streamed from an
+Iterator as it goes. Keys and values in bodies are whatever bytes they
+are, but JSON strings are text, so in a scan a key or value that isn't
+valid UTF-8 is given in base64 instead, as "key_base64" or
+"value_base64".
+
+Errors reply with a status code that fits and a JSON body naming the
+engine error, {"error": message, "code": code}, so clients can tell an
+abort from a missing key without parsing messages.
+*/
+
+const transactionHeader = "X-Transaction"
+
+// The CRC-32C stored with the value a get returns, in hex, if it has one
+// (see checksum.go).
+const checksumHeader = "X-Checksum"
+
+type HTTPServer struct {
+	sessions *txSessions
+	mux      *http.ServeMux
+}
+
+func NewHTTPServer(db *Database) *HTTPServer {
+	s := &HTTPServer{sessions: newTxSessions(db), mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /tx", s.begin)
+	s.mux.HandleFunc("POST /tx/{id}/commit", s.finish("commit"))
+	s.mux.HandleFunc("POST /tx/{id}/abort", s.finish("abort"))
+	s.mux.HandleFunc("GET /keys/{key...}", s.get)
+	s.mux.HandleFunc("PUT /keys/{key...}", s.set)
+	s.mux.HandleFunc("DELETE /keys/{key...}", s.delete)
+	s.mux.HandleFunc("GET /scan", s.scan)
+	return s
+}
+
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, password, given := r.BasicAuth()
+	ctx, err := s.sessions.authenticate(r.Context(), name, password, given)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	s.mux.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// RequireAuth makes every request authenticate as one of users, and run
+// as that user. It must be called before the server starts serving.
+func (s *HTTPServer) RequireAuth(users []User) {
+	s.sessions.users = usersByName(users)
+}
+
+// Close aborts the transactions that were begun and never finished.
+func (s *HTTPServer) Close() {
+	s.sessions.close()
+}
+
+func (s *HTTPServer) begin(w http.ResponseWriter, r *http.Request) {
+	txId, err := s.sessions.begin(r.Context())
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.Header().Set(transactionHeader, strconv.FormatUint(txId, 10))
+	writeJSON(w, http.StatusCreated, map[string]uint64{"tx": txId})
+}
+
+func (s *HTTPServer) finish(command string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txId, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil || txId == 0 {
+			writeHTTPError(w, ErrUnknownTransaction)
+			return
+		}
+		if err := s.sessions.finish(r.Context(), txId, command); err != nil {
+			if command == "commit" && !errors.Is(err, ErrUnknownTransaction) {
+				// It was aborted instead.
+				err = fmt.Errorf("%w: %w", ErrTransactionAborted, err)
+			}
+			writeHTTPError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// txId returns the transaction named by the request's header, zero if
+// none.
+func txId(r *http.Request) (uint64, error) {
+	header := r.Header.Get(transactionHeader)
+	if header == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(header, 10, 64)
+	if err != nil {
+		return 0, ErrUnknownTransaction
+	}
+	return id, nil
+}
+
+func (s *HTTPServer) get(w http.ResponseWriter, r *http.Request) {
+	tx, err := txId(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	res, err := s.sessions.run(r.Context(), tx, "get", r.PathValue("key"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if res.Checksum != 0 {
+		w.Header().Set(checksumHeader, fmt.Sprintf("%08x", res.Checksum))
+	}
+	io.WriteString(w, res.Value)
+}
+
+func (s *HTTPServer) set(w http.ResponseWriter, r *http.Request) {
+	tx, err := txId(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if _, err := s.sessions.run(r.Context(), tx, "set", r.PathValue("key"), string(value)); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *HTTPServer) delete(w http.ResponseWriter, r *http.Request) {
+	tx, err := txId(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	// getdel, unlike delete, says when the key was missing.
+	if _, err := s.sessions.run(r.Context(), tx, "getdel", r.PathValue("key")); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *HTTPServer) scan(w http.ResponseWriter, r *http.Request) {
+	tx, err := txId(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	opts := ScanOptions{Start: query.Get("start"), End: query.Get("end"), Prefix: query.Get("prefix")}
+	if desc, _ := strconv.ParseBool(query.Get("desc")); desc {
+		opts.Order = Descending
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if opts.Limit, err = strconv.Atoi(limit); err != nil {
+			writeJSON(w, http.StatusBadRequest, httpErrorBody{"limit must be an integer", "bad_request"})
+			return
+		}
+	}
+	for i, name := range filterOps {
+		for _, operand := range query[name] {
+			opts.Filters = append(opts.Filters, Filter{FilterOp(i), operand})
+		}
+	}
+	if _, err := compileFilters(opts.Filters); err != nil {
+		writeJSON(w, http.StatusBadRequest, httpErrorBody{err.Error(), "bad_request"})
+		return
+	}
+	if err := s.sessions.authorizeScan(r.Context(), opts); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	// Once the first key is out, the status is sent, so an error after
+	// that can only cut the array short.
+	started := false
+	err = s.sessions.with(r.Context(), tx, func(tx *Transaction) error {
+		it := tx.NewIterator(opts)
+		enc := json.NewEncoder(w)
+		for it.Next() {
+			if !started {
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, "[")
+				started = true
+			} else {
+				io.WriteString(w, ",")
+			}
+			enc.Encode(scannedPair(it.Key(), it.Value()))
+		}
+		return it.Err()
+	})
+	switch {
+	case started:
+		io.WriteString(w, "]\n")
+	case err != nil:
+		writeHTTPError(w, err)
+	default:
+		writeJSON(w, http.StatusOK, []struct{}{})
+	}
+}
+
+type httpErrorBody struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status, code := http.StatusInternalServerError, "internal"
+	switch {
+	case errors.Is(err, ErrTransactionAborted):
+		status, code = http.StatusConflict, "transaction_aborted"
+	case errors.As(err, new(*ConflictError)):
+		status, code = http.StatusConflict, "conflict"
+	case errors.Is(err, ErrKeyNotFound):
+		status, code = http.StatusNotFound, "key_not_found"
+	case errors.Is(err, ErrUnknownTransaction):
+		status, code = http.StatusNotFound, "unknown_transaction"
+	case errors.Is(err, ErrNoTransaction):
+		status, code = http.StatusConflict, "no_transaction"
+	case errors.Is(err, ErrDatabaseShutdown):
+		status, code = http.StatusServiceUnavailable, "shutdown"
+	case errors.Is(err, ErrAuthRequired), errors.Is(err, ErrBadCredentials):
+		status, code = http.StatusUnauthorized, "unauthenticated"
+		w.Header().Set("WWW-Authenticate", `Basic realm="mvcc"`)
+	case errors.Is(err, ErrPermissionDenied):
+		status, code = http.StatusForbidden, "permission_denied"
+	}
+	writeJSON(w, status, httpErrorBody{err.Error(), code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// scannedPair is how a scan gives key and value, in base64 if they
+// aren't text.
+func scannedPair(key, value string) map[string]string {
+	pair := map[string]string{}
+	for name, s := range map[string]string{"key": key, "value": value} {
+		if utf8.ValidString(s) {
+			pair[name] = s
+		} else {
+			pair[name+"_base64"] = base64.StdEncoding.EncodeToString([]byte(s))
+		}
+	}
+	return pair
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type httpClient struct {
+	url string
+	// Basic credentials to send, if any.
+	user, password string
+}
+
+func (c httpClient) do(method, path, tx, body string) (int, string) {
+	req, err := http.NewRequest(method, c.url+path, strings.NewReader(body))
+	assertEq(err, nil, "request")
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	if tx != "" {
+		req.Header.Set(transactionHeader, tx)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assertEq(err, nil, method+" "+path)
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func (c httpClient) begin() string {
+	status, body := c.do("POST", "/tx", "", "")
+	assertEq(status, http.StatusCreated, "begin")
+	var res struct{ Tx uint64 }
+	assertEq(json.Unmarshal([]byte(body), &res), nil, "begin body")
+	return strconv.FormatUint(res.Tx, 10)
+}
+
+func errorCode(body string) string {
+	var res httpErrorBody
+	json.Unmarshal([]byte(body), &res)
+	return res.Code
+}
+
+func TestHTTP(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	api := NewHTTPServer(&database)
+	defer api.Close()
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := httpClient{url: srv.URL}
+
+	status, _ := c.do("PUT", "/keys/a/b", "", "hello")
+	assertEq(status, http.StatusNoContent, "autocommit put")
+	status, body := c.do("GET", "/keys/a/b", "", "")
+	assertEq(status, http.StatusOK, "get")
+	assertEq(body, "hello", "value")
+	status, body = c.do("GET", "/keys/missing", "", "")
+	assertEq(status, http.StatusNotFound, "missing key")
+	assertEq(errorCode(body), "key_not_found", "missing key code")
+
+	tx := c.begin()
+	c.do("PUT", "/keys/x", tx, "1")
+	status, _ = c.do("GET", "/keys/x", "", "")
+	assertEq(status, http.StatusNotFound, "uncommitted write invisible")
+	status, body = c.do("GET", "/keys/x", tx, "")
+	assertEq(body, "1", "own write visible")
+	status, _ = c.do("POST", "/tx/"+tx+"/commit", "", "")
+	assertEq(status, http.StatusNoContent, "commit")
+	status, body = c.do("POST", "/tx/"+tx+"/commit", "", "")
+	assertEq(errorCode(body), "unknown_transaction", "already committed")
+
+	status, _ = c.do("DELETE", "/keys/x", "", "")
+	assertEq(status, http.StatusNoContent, "delete")
+	status, body = c.do("DELETE", "/keys/x", "", "")
+	assertEq(errorCode(body), "key_not_found", "delete missing key")
+
+	// Conflicting writers: the second commit is refused.
+	t1, t2 := c.begin(), c.begin()
+	c.do("PUT", "/keys/y", t1, "1")
+	c.do("PUT", "/keys/y", t2, "2")
+	status, _ = c.do("POST", "/tx/"+t1+"/commit", "", "")
+	assertEq(status, http.StatusNoContent, "first commit")
+	status, body = c.do("POST", "/tx/"+t2+"/commit", "", "")
+	assertEq(status, http.StatusConflict, "conflicting commit")
+	assertEq(errorCode(body), "transaction_aborted", "conflict code")
+
+	t3 := c.begin()
+	status, _ = c.do("POST", "/tx/"+t3+"/abort", "", "")
+	assertEq(status, http.StatusNoContent, "abort")
+	status, body = c.do("GET", "/keys/y", t3, "")
+	assertEq(errorCode(body), "unknown_transaction", "aborted transaction gone")
+}
+
+func TestHTTP_scan(t *testing.T) {
+	database := newDatabase()
+	conn := database.newConnection()
+	for _, key := range []string{"a1", "a2", "a3", "b1"} {
+		conn.mustExecCommand("set", []string{key, "v" + key})
+	}
+	api := NewHTTPServer(&database)
+	defer api.Close()
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := httpClient{url: srv.URL}
+
+	scan := func(query string) []string {
+		status, body := c.do("GET", "/scan?"+query, "", "")
+		assertEq(status, http.StatusOK, "scan "+query)
+		var pairs []struct{ Key, Value string }
+		assertEq(json.Unmarshal([]byte(body), &pairs), nil, "scan body "+body)
+		var keys []string
+		for _, kv := range pairs {
+			keys = append(keys, kv.Key)
+		}
+		return keys
+	}
+
+	assertEq(strings.Join(scan(""), ","), "a1,a2,a3,b1", "everything")
+	assertEq(strings.Join(scan("prefix=a&desc=1&limit=2"), ","), "a3,a2", "prefix, descending, limit")
+	assertEq(strings.Join(scan("start=a2&end=b"), ","), "a2,a3", "range")
+	assertEq(len(scan("prefix=z")), 0, "nothing")
+
+	assertEq(strings.Join(scan("contains=2&contains=v"), ","), "a2", "filtered")
+
+	status, _ := c.do("GET", "/scan?limit=x", "", "")
+	assertEq(status, http.StatusBadRequest, "bad limit")
+	status, _ = c.do("GET", "/scan?match=(", "", "")
+	assertEq(status, http.StatusBadRequest, "bad filter")
+
+	conn.mustExecCommand("set", []string{"c", "\xff\x00"})
+	_, body := c.do("GET", "/scan?prefix=c", "", "")
+	assertEq(body, `[{"key":"c","value_base64":"/wA="}`+"\n]", "binary value")
+}
+
+func TestHTTP_checksum(t *testing.T) {
+	database := newDatabase()
+	database.apply(WithChecksums())
+	api := NewHTTPServer(&database)
+	defer api.Close()
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	database.Set("x", "hey")
+	resp, err := http.Get(srv.URL + "/keys/x")
+	assertEq(err, nil, "get")
+	resp.Body.Close()
+	assertEq(resp.Header.Get(checksumHeader), fmt.Sprintf("%08x", Checksum("hey")), "checksum header")
+}
+
+func TestHTTP_auth(t *testing.T) {
+	database := newDatabase()
+	api := NewHTTPServer(&database)
+	api.RequireAuth([]User{{"root", "secret", true}, {"alice", "pw", false}})
+	defer api.Close()
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	root := httpClient{srv.URL, "root", "secret"}
+	alice := httpClient{srv.URL, "alice", "pw"}
+
+	status, body := httpClient{url: srv.URL}.do("GET", "/keys/x", "", "")
+	assertEq(status, http.StatusUnauthorized, "anonymous")
+	assertEq(errorCode(body), "unauthenticated", "anonymous code")
+	status, _ = httpClient{srv.URL, "alice", "wrong"}.do("POST", "/tx", "", "")
+	assertEq(status, http.StatusUnauthorized, "wrong password")
+
+	status, _ = root.do("PUT", "/keys/secret", "", "shh")
+	assertEq(status, http.StatusNoContent, "admin writes")
+	assertEq(database.applyGrants([]Grant{{"alice", "app/", PermRead | PermWrite}}), nil, "grant")
+	status, _ = alice.do("PUT", "/keys/app/x", "", "1")
+	assertEq(status, http.StatusNoContent, "alice writes her prefix")
+	status, body = alice.do("GET", "/keys/secret", "", "")
+	assertEq(status, http.StatusForbidden, "alice outside her prefix")
+	assertEq(errorCode(body), "permission_denied", "forbidden code")
+	status, _ = alice.do("GET", "/scan", "", "")
+	assertEq(status, http.StatusForbidden, "alice scans everything")
+	status, body = alice.do("GET", "/scan?prefix=app/", "", "")
+	assertEq(status, http.StatusOK, "alice scans her prefix")
+	assertEq(body, `[{"key":"app/x","value":"1"}`+"\n]", "scan body")
+
+	// A transaction is only alice's to use.
+	tx := alice.begin()
+	status, body = root.do("GET", "/keys/app/x", tx, "")
+	assertEq(errorCode(body), "unknown_transaction", "another user's transaction")
+	status, _ = alice.do("POST", "/tx/"+tx+"/commit", "", "")
+	assertEq(status, http.StatusNoContent, "commit")
+}
@@ -0,0 +1,56 @@
+package main
+
+import "time"
+
+/*
+A client that begins a transaction and then goes quiet, because it hung
+or forgot, holds back the GC horizon for as long as it stays connected.
+The idle timeout aborts transactions nobody has used for a while, the way
+abortall would, so their owners get ErrTransactionAborted when they come
+back.
+
+Any use of a transaction counts as activity, including use of one nested
+in it. Prepared transactions are waiting on their coordinator rather than
+idle, and are left alone.
+*/
+
+// touch marks t, and whatever it is nested in, as used now.
+func (t *Transaction) touch() {
+	now := t.db.clock()
+	for ; t != nil; t = t.parent {
+		t.active = now
+	}
+}
+
+// AbortIdle force-aborts every transaction in progress that has gone
+// unused for at least timeout, returning their ids.
+func (d *Database) AbortIdle(timeout time.Duration) []uint64 {
+	now := d.clock()
+	ids := d.forceAbort(func(t *Transaction) bool {
+		return now.Sub(t.active) >= timeout
+	})
+	if len(ids) > 0 {
+		d.logger.Info("aborted idle transactions", "timeout", timeout, "txs", ids)
+	}
+	return ids
+}
+
+// StartIdleTimeout aborts transactions left idle for timeout, checking
+// every half of it, until the returned function is called, so a
+// transaction may stay idle up to half as long again before it is aborted.
+func (d *Database) StartIdleTimeout(timeout time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.AbortIdle(timeout)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
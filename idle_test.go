@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAbortIdle(t *testing.T) {
+	database := newDatabase()
+	now := time.Unix(1000, 0)
+	database.now = func() time.Time { return now }
+
+	idle := database.newConnection()
+	busy := database.newConnection()
+	nested := database.newConnection()
+	idle.mustExecCommand("begin", nil)
+	busy.mustExecCommand("begin", nil)
+	nested.mustExecCommand("begin", nil)
+	nested.mustExecCommand("begin", nil)
+	prepared, _ := database.Begin()
+	assertEq(prepared.Prepare("g"), nil, "prepare")
+
+	now = now.Add(time.Minute)
+	busy.mustExecCommand("set", []string{"x", "busy"})
+	nested.execCommand("get", []string{"x"})
+	now = now.Add(30 * time.Second)
+
+	ids := database.AbortIdle(time.Minute)
+	assertEq(len(ids), 1, "only the idle transaction")
+	assertEq(ids[0], idle.tx.id, "idle")
+	_, err := idle.execCommand("get", []string{"x"})
+	assertEq(err, ErrTransactionAborted, "owner finds out on next use")
+	assertEq(busy.mustExecCommand("get", []string{"x"}), "busy", "busy transaction kept")
+	assertEq(len(database.Prepared()), 1, "prepared transaction kept")
+
+	// Activity in a nested transaction keeps its parent alive too.
+	now = now.Add(time.Minute)
+	ids = database.AbortIdle(time.Minute)
+	assertEq(len(ids), 2, "both idle now")
+	_, err = nested.execCommand("get", []string{"x"})
+	assertEq(err, ErrTransactionAborted, "nested transaction aborted with its parent")
+}
+
+func TestStartIdleTimeout(t *testing.T) {
+	database := newDatabase()
+	database.Begin()
+	stop := database.StartIdleTimeout(10 * time.Millisecond)
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for len(database.Transactions()) > 0 {
+		assert(time.Now().Before(deadline), "idle transaction aborted")
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+/*
+incr reads the visible value of a key as an integer, adds to it and writes
+the result as a new version, all in one statement. A missing key counts
+as zero, and a string counts if it is a number in decimal. The result is
+an int (see types.go).
+
+"Atomic" here means atomic within the transaction. Two transactions
+incrementing the same key concurrently both read the same starting value;
+under Snapshot Isolation and Serializable the second to commit gets a
+conflict and has to retry, but at the weaker levels one increment is
+silently lost, exactly as it would be with a get and a set.
+*/
+
+var ErrNotInteger = errors.New("value is not an integer")
+
+// Incr adds delta to the integer value of key, returning the new value.
+func (t *Transaction) Incr(key string, delta int64) (int64, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if value, err := t.get(key); err == nil {
+		raw, err := t.db.readRaw(value)
+		if err != nil {
+			return 0, err
+		}
+		if value.data.kind == IntType {
+			n = decodeInt(raw)
+		} else if n, err = strconv.ParseInt(formatValue(value.data.kind, raw), 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, fmt.Errorf("increment of %s would overflow", key)
+	}
+	n += delta
+
+	t.write(key, encodeInt(n), IntType, 0)
+	return n, nil
+}
+
+// incr key [delta]
+// decr key [delta]
+func (c *Connection) incr(command string, args []string) (Result, error) {
+	delta := int64(1)
+	if len(args) > 1 {
+		var err error
+		delta, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return Result{}, fmt.Errorf("%s: delta %w", command, ErrNotInteger)
+		}
+	}
+	if command == "decr" {
+		if delta == math.MinInt64 {
+			return Result{}, fmt.Errorf("decrement of %s would overflow", args[0])
+		}
+		delta = -delta
+	}
+
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	n, err := c.tx.Incr(args[0], delta)
+	if err != nil {
+		return res, err
+	}
+	res.Value = strconv.FormatInt(n, 10)
+	return res, nil
+}
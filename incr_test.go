@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIncr(t *testing.T) {
+	database := newDatabase()
+
+	c := database.newConnection()
+	assertEq(c.mustExecCommand("incr", []string{"n"}), "1", "incr missing key")
+	assertEq(c.mustExecCommand("incr", []string{"n", "41"}), "42", "incr by 41")
+	assertEq(c.mustExecCommand("decr", []string{"n", "50"}), "-8", "decr by 50")
+	assertEq(c.mustExecCommand("get", []string{"n"}), "-8", "get n")
+
+	c.mustExecCommand("set", []string{"s", "hey"})
+	_, err := c.execCommand("incr", []string{"s"})
+	assert(errors.Is(err, ErrNotInteger), "incr non-integer")
+
+	c.mustExecCommand("set", []string{"max", "9223372036854775807"})
+	_, err = c.execCommand("incr", []string{"max"})
+	assertEq(err.Error(), "increment of max would overflow", "incr overflow")
+}
+
+func TestIncr_SnapshotIsolation_conflict(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	database.Set("n", "0")
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+
+	assertEq(c1.mustExecCommand("incr", []string{"n"}), "1", "c1 incr")
+	assertEq(c2.mustExecCommand("incr", []string{"n"}), "1", "c2 incr")
+
+	c1.mustExecCommand("commit", nil)
+	_, err := c2.execCommand("commit", nil)
+	assertEq(err.Error(), `write-write conflict with transaction 2 on "n"`, "c2 commit")
+
+	// The retry sees c1's increment, so neither update is lost.
+	c2.mustExecCommand("begin", nil)
+	assertEq(c2.mustExecCommand("incr", []string{"n"}), "2", "c2 retry incr")
+	c2.mustExecCommand("commit", nil)
+}
+
+func TestIncr_ReadCommitted_lostUpdate(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = ReadCommitedIsolation
+	database.Set("n", "0")
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+
+	c1.mustExecCommand("incr", []string{"n"})
+	c2.mustExecCommand("incr", []string{"n"})
+	c1.mustExecCommand("commit", nil)
+	c2.mustExecCommand("commit", nil)
+
+	// Read Committed doesn't detect the conflict: one increment is lost.
+	c3 := database.newConnection()
+	assertEq(c3.mustExecCommand("get", []string{"n"}), "1", "lost update")
+}
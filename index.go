@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/*
+A secondary index finds keys by something in their values, "users with
+email x", without a scan of the whole keyspace. An application creates
+one with an extractor, which returns what a key's value is indexed under,
+or "" for not at all. Whenever a transaction writes or deletes a key, it
+also writes or deletes the key's index entries, which are keys of their
+own under a reserved prefix, so entries are committed, aborted, rolled
+back to a savepoint, logged and recovered exactly along with the writes
+they index, and a lookup sees the entries its snapshot sees. Scans don't
+show the reserved keys.
+
+Maintaining an entry means knowing what the key held before, which the
+write reads without adding the key to the readset, as it would for a
+blind write. Each lookup rechecks the keys its entries name against their
+visible values, so an entry left stale, say by a conflict a resolver
+merged (see resolve.go), is never returned.
+
+Indexes are created in code after the database is opened, like scripts,
+and aren't persisted: their entries are, so creating an index again on
+reopening finds them there already, and only has to add what is missing.
+Keys written by transactions that were already in progress when the index
+was created may be missed.
+*/
+
+var ErrUnknownIndex = errors.New("no such index")
+
+// An Extractor returns what the value of key is indexed under, or "" if
+// it isn't.
+type Extractor func(key, value string) string
+
+type index struct {
+	extract Extractor
+	// Whether no two keys may be indexed under the same value (see
+	// unique.go).
+	unique bool
+}
+
+type indexes struct {
+	mu     sync.RWMutex
+	byName map[string]index
+}
+
+// indexPrefix starts every index entry's key.
+const indexPrefix = internalPrefix + "index\x00"
+
+func isIndexKey(key string) bool {
+	return strings.HasPrefix(key, indexPrefix)
+}
+
+// indexEntryPrefix starts the keys of the entries for value in index
+// name; each is followed by the key the entry is for.
+func indexEntryPrefix(name, value string) string {
+	return indexPrefix + name + "\x00" + value + "\x00"
+}
+
+// CreateIndex indexes every key under what extract returns for its
+// value, adding entries for the keys already there before returning.
+func (d *Database) CreateIndex(name string, extract Extractor) error {
+	return d.createIndex(name, index{extract: extract})
+}
+
+func (d *Database) createIndex(name string, idx index) error {
+	if name == "" || strings.Contains(name, "\x00") {
+		return fmt.Errorf("bad index name %q", name)
+	}
+
+	d.indexes.mu.Lock()
+	if _, ok := d.indexes.byName[name]; ok {
+		d.indexes.mu.Unlock()
+		return fmt.Errorf("index %q already exists", name)
+	}
+	// Writers may be using the old map, so it is replaced, not changed.
+	byName := maps.Clone(d.indexes.byName)
+	if byName == nil {
+		byName = map[string]index{}
+	}
+	byName[name] = idx
+	d.indexes.byName = byName
+	d.indexes.mu.Unlock()
+
+	if err := d.backfill(name, idx); err != nil {
+		d.indexes.mu.Lock()
+		byName := maps.Clone(d.indexes.byName)
+		delete(byName, name)
+		d.indexes.byName = byName
+		d.indexes.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// backfill adds the entries index name is missing.
+func (d *Database) backfill(name string, idx index) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	var missing []string
+	seen := map[string]string{}
+	tx.scan("", "", Ascending, func(key string, value *Value) bool {
+		var s string
+		if s, err = d.read(value); err != nil {
+			return false
+		}
+		v := idx.extract(key, s)
+		if v == "" {
+			return true
+		}
+		if other, ok := seen[v]; ok && idx.unique {
+			err = uniqueViolation(name, v, other, key)
+			return false
+		}
+		seen[v] = key
+		if entry := indexEntryPrefix(name, v) + key; tx.visible(entry) == nil {
+			missing = append(missing, entry)
+		}
+		return true
+	})
+	for _, entry := range missing {
+		tx.write(entry, "", StringType, 0)
+	}
+	d.mu.Unlock()
+	if err != nil {
+		tx.Abort()
+		return err
+	}
+	return tx.Commit()
+}
+
+// allIndexes returns the indexes by name, if there are any.
+func (d *Database) allIndexes() map[string]index {
+	d.indexes.mu.RLock()
+	defer d.indexes.mu.RUnlock()
+	return d.indexes.byName
+}
+
+// updateIndexes brings key's index entries in line with its value
+// becoming value, or being deleted if value is nil. The caller must hold
+// the lock.
+func (t *Transaction) updateIndexes(key string, value *string) {
+	indexes := t.db.allIndexes()
+	if len(indexes) == 0 || isIndexKey(key) {
+		return
+	}
+
+	var old *string
+	if v := t.visible(key); v != nil {
+		s, err := t.db.read(v)
+		if err != nil {
+			t.readFailed(err)
+			return
+		}
+		old = &s
+	}
+	for name, idx := range indexes {
+		var before, after string
+		if old != nil {
+			before = idx.extract(key, *old)
+		}
+		if value != nil {
+			after = idx.extract(key, *value)
+		}
+		if before == after {
+			continue
+		}
+		if before != "" {
+			// Which fails harmlessly if the entry is already gone.
+			t.delete(indexEntryPrefix(name, before) + key)
+		}
+		if after != "" {
+			t.write(indexEntryPrefix(name, after)+key, "", StringType, 0)
+		}
+	}
+}
+
+// Lookup returns the visible keys index name has under value, in order.
+func (t *Transaction) Lookup(name, value string) ([]string, error) {
+	idx, ok := t.db.allIndexes()[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownIndex, name)
+	}
+
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return nil, err
+	}
+
+	prefix := indexEntryPrefix(name, value)
+	var candidates []string
+	t.scan(prefix, prefixEnd(prefix), Ascending, func(entry string, _ *Value) bool {
+		candidates = append(candidates, strings.TrimPrefix(entry, prefix))
+		return true
+	})
+
+	var keys []string
+	for _, key := range candidates {
+		v, err := t.get(key)
+		if err != nil {
+			continue
+		}
+		s, err := t.db.read(v)
+		if err != nil {
+			return nil, err
+		}
+		if idx.extract(key, s) == value {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// lookup index value
+func (c *Connection) lookup(args []string) (Result, error) {
+	keys, err := c.tx.Lookup(args[0], args[1])
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Value: strings.Join(keys, "\n"), Keys: keys, TxId: c.tx.id}, nil
+}
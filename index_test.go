@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// byCity indexes values "name,city" by city.
+func byCity(key, value string) string {
+	_, city, _ := strings.Cut(value, ",")
+	return city
+}
+
+func TestIndex(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"u1", "ann,oslo"})
+	c.mustExecCommand("set", []string{"u2", "bob,rome"})
+
+	assertEq(database.CreateIndex("city", byCity), nil, "create")
+	assertEq(c.mustExecCommand("lookup", []string{"city", "oslo"}), "u1", "existing keys indexed")
+	assert(database.CreateIndex("city", byCity) != nil, "names are unique")
+	_, err := c.execCommand("lookup", []string{"town", "oslo"})
+	assert(errors.Is(err, ErrUnknownIndex), "unknown index")
+
+	snapshot := database.newConnection()
+	snapshot.mustExecCommand("begin", nil)
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"u3", "cat,oslo"})
+	c.mustExecCommand("set", []string{"u1", "ann,rome"})
+	c.mustExecCommand("delete", []string{"u2"})
+	assertEq(c.mustExecCommand("lookup", []string{"city", "oslo"}), "u3", "own writes")
+	assertEq(c.mustExecCommand("lookup", []string{"city", "rome"}), "u1", "own writes")
+	c.mustExecCommand("commit", nil)
+
+	assertEq(snapshot.mustExecCommand("lookup", []string{"city", "oslo"}), "u1", "snapshot")
+	assertEq(snapshot.mustExecCommand("lookup", []string{"city", "rome"}), "u2", "snapshot")
+	assertEq(c.mustExecCommand("lookup", []string{"city", "rome"}), "u1", "committed")
+
+	// Aborting takes the entries with it.
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"u4", "dan,oslo"})
+	c.mustExecCommand("abort", nil)
+	assertEq(c.mustExecCommand("lookup", []string{"city", "oslo"}), "u3", "aborted")
+
+	// And scans don't see them.
+	assertEq(c.mustExecCommand("scan", []string{"", ""}), "u1=ann,rome\nu3=cat,oslo", "scan")
+	assertEq(c.mustExecCommand("dbsize", nil), "2", "dbsize")
+}
+
+func TestIndex_recovery(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+	assertEq(database.CreateIndex("city", byCity), nil, "create")
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"u1", "ann,oslo"})
+	c.mustExecCommand("set", []string{"u2", "bob,oslo"})
+	c.mustExecCommand("set", []string{"u2", "bob,rome"})
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	before := database.store.Len()
+	assertEq(database.CreateIndex("city", byCity), nil, "create again")
+	assertEq(database.store.Len(), before, "entries were recovered")
+	c = database.newConnection()
+	assertEq(c.mustExecCommand("lookup", []string{"city", "oslo"}), "u1", "oslo")
+	assertEq(c.mustExecCommand("lookup", []string{"city", "rome"}), "u2", "rome")
+}
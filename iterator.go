@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+/*
+Scan materializes its whole result, which is no good for walking a huge
+keyspace. An Iterator instead fetches one key at a time, taking the
+database lock only for the duration of each step, so the rest of the
+database carries on between steps.
+
+After each key the iterator simply narrows its range to exclude
+everything already returned. That makes resuming trivial: the remaining
+range (and the direction) is all the state there is, and Token hands it
+out in an opaque form that any later transaction can pick up with
+ResumeIterator. Of course, a resumed walk sees that transaction's
+snapshot, not the original one's.
+*/
+
+var ErrInvalidToken = errors.New("invalid continuation token")
+
+type Iterator struct {
+	tx      *Transaction
+	opts    ScanOptions
+	filters []valueFilter
+	// Keys returned so far, for opts.Limit.
+	n int
+
+	key, value string
+	err        error
+	done       bool
+}
+
+// NewIterator returns an iterator over the keys described by opts,
+// positioned before the first one.
+func (t *Transaction) NewIterator(opts ScanOptions) *Iterator {
+	opts.Start, opts.End = opts.bounds()
+	opts.Prefix = ""
+	it := &Iterator{tx: t, opts: opts}
+	it.filters, it.err = compileFilters(opts.Filters)
+	it.done = it.err != nil
+	return it
+}
+
+// Next advances to the next visible key, reporting whether there was one.
+func (it *Iterator) Next() bool {
+	if it.done {
+		return false
+	}
+	if it.opts.Limit > 0 && it.n >= it.opts.Limit {
+		it.done = true
+		return false
+	}
+
+	t := it.tx
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		it.err = err
+		it.done = true
+		return false
+	}
+
+	found := false
+	var err error
+	t.scan(it.opts.Start, it.opts.End, it.opts.Order, func(key string, value *Value) bool {
+		var ok bool
+		if ok, err = t.db.passes(it.filters, value); err != nil || !ok {
+			return err == nil
+		}
+		if it.value, err = t.db.read(value); err != nil {
+			return false
+		}
+		it.key = key
+		found = true
+		return false
+	})
+	if err != nil {
+		it.err = err
+		it.done = true
+		return false
+	}
+	if !found {
+		it.done = true
+		return false
+	}
+
+	it.n++
+	if it.opts.Order == Descending {
+		it.opts.End = it.key
+	} else {
+		// The smallest key sorting after it.key.
+		it.opts.Start = it.key + "\x00"
+	}
+	return true
+}
+
+func (it *Iterator) Key() string   { return it.key }
+func (it *Iterator) Value() string { return it.value }
+
+// Err returns the error that stopped the iterator, if any.
+func (it *Iterator) Err() error { return it.err }
+
+type iteratorToken struct {
+	Version    int      `json:"v"`
+	Start, End string   `json:",omitempty"`
+	Order      Order    `json:",omitempty"`
+	Filters    []Filter `json:",omitempty"`
+}
+
+// Token returns an opaque continuation token for the keys the iterator
+// has not returned yet. The limit is not part of it.
+func (it *Iterator) Token() string {
+	b, _ := json.Marshal(iteratorToken{
+		Version: 1,
+		Start:   it.opts.Start,
+		End:     it.opts.End,
+		Order:   it.opts.Order,
+		Filters: it.opts.Filters,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ResumeIterator returns an iterator continuing where the one that issued
+// token left off, stopping after limit keys if limit is positive.
+func (t *Transaction) ResumeIterator(token string, limit int) (*Iterator, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var tok iteratorToken
+	if err := json.Unmarshal(b, &tok); err != nil || tok.Version != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	return t.NewIterator(ScanOptions{
+		Start:   tok.Start,
+		End:     tok.End,
+		Order:   tok.Order,
+		Limit:   limit,
+		Filters: tok.Filters,
+	}), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	database := newDatabase()
+	for i := range 10 {
+		database.Set(fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+	database.Set("other", "x")
+
+	// Walk k* three keys at a time, each page in a new transaction.
+	var seen []string
+	token := ""
+	for page := 0; ; page++ {
+		tx, _ := database.Begin()
+		var it *Iterator
+		if token == "" {
+			it = tx.NewIterator(ScanOptions{Prefix: "k", Limit: 3})
+		} else {
+			var err error
+			it, err = tx.ResumeIterator(token, 3)
+			assertEq(err, nil, "resume")
+		}
+
+		n := 0
+		for it.Next() {
+			seen = append(seen, it.Key()+"="+it.Value())
+			n++
+		}
+		assertEq(it.Err(), nil, "iterator error")
+		tx.Commit()
+
+		if n < 3 {
+			break
+		}
+		token = it.Token()
+
+		// Writes between pages show up if they are still ahead.
+		if page == 0 {
+			database.Set("k5a", "late")
+			database.Delete("k1")
+		}
+	}
+
+	assertEq(fmt.Sprint(seen), "[k0=v0 k1=v1 k2=v2 k3=v3 k4=v4 k5=v5 k5a=late k6=v6 k7=v7 k8=v8 k9=v9]", "pages")
+}
+
+func TestIteratorDescending(t *testing.T) {
+	database := newDatabase()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		database.Set(key, key)
+	}
+
+	tx, _ := database.Begin()
+	it := tx.NewIterator(ScanOptions{End: "d", Order: Descending, Limit: 2})
+	var seen []string
+	for it.Next() {
+		seen = append(seen, it.Key())
+	}
+
+	it, _ = tx.ResumeIterator(it.Token(), 0)
+	for it.Next() {
+		seen = append(seen, it.Key())
+	}
+	assertEq(fmt.Sprint(seen), "[c b a]", "descending pages")
+
+	_, err := tx.ResumeIterator("not a token", 0)
+	assertEq(err, ErrInvalidToken, "bad token")
+
+	// The iterator notices its transaction going away.
+	it = tx.NewIterator(ScanOptions{})
+	tx.Abort()
+	assertEq(it.Next(), false, "next after abort")
+	assertEq(it.Err(), ErrTransactionAborted, "iterator error")
+}
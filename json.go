@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+/*
+ExportJSON and ImportJSON move the logical contents of a database, as a
+single JSON object mapping keys to values, in key order:
+
+	{
+	  "a": "1",
+	  "b": "two"
+	}
+
+That is easy to diff, to edit by hand, and to keep as a test fixture.
+Unlike a backup it carries no expiry times, and an import merges into
+whatever the database already holds rather than requiring it to be empty.
+
+JSON strings are text, so a value that isn't valid UTF-8 is exported as
+an object holding it in base64, {"base64": "/w=="}, which imports as the
+bytes it was. Keys can't be objects, so a database with a key that isn't
+valid UTF-8 can't be exported, only backed up.
+*/
+
+// ExportJSON writes the keys and values visible to a new snapshot to w as
+// a JSON object.
+func (d *Database) ExportJSON(w io.Writer) error {
+	entries, err := d.snapshotEntries()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("{")
+	for i, e := range entries {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		if !utf8.ValidString(e.key) {
+			return fmt.Errorf("export: key %q is not UTF-8", e.key)
+		}
+		key, _ := json.Marshal(e.key)
+		value, _ := json.Marshal(jsonValue(formatValue(e.kind, e.value)))
+		fmt.Fprintf(bw, "\n  %s: %s", key, value)
+	}
+	if len(entries) > 0 {
+		bw.WriteString("\n")
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// jsonValue returns what value is exported as: itself if it is text, or
+// else its bytes in base64.
+func jsonValue(value string) any {
+	if utf8.ValidString(value) {
+		return value
+	}
+	return base64Value{[]byte(value)}
+}
+
+type base64Value struct {
+	// Encoded in base64 by encoding/json.
+	Bytes []byte `json:"base64"`
+}
+
+// importedValue is what an exported value imports as.
+type importedValue string
+
+func (v *importedValue) UnmarshalJSON(data []byte) error {
+	var b map[string][]byte
+	if err := json.Unmarshal(data, &b); err != nil {
+		return json.Unmarshal(data, (*string)(v))
+	}
+	bytes, ok := b["base64"]
+	if !ok || len(b) != 1 {
+		return fmt.Errorf("expected a string or {\"base64\": ...}")
+	}
+	*v = importedValue(bytes)
+	return nil
+}
+
+// ImportJSON sets every key in the JSON object read from r to its value,
+// in a single transaction.
+func (d *Database) ImportJSON(r io.Reader) error {
+	var pairs map[string]importedValue
+	if err := json.NewDecoder(r).Decode(&pairs); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	kvs := make([]KeyValue, 0, len(pairs))
+	for key, value := range pairs {
+		kvs = append(kvs, KeyValue{key, string(value)})
+	}
+	if err := tx.MSet(kvs...); err != nil {
+		tx.Abort()
+		return err
+	}
+	return tx.Commit()
+}
+
+// exportjson path
+func (c *Connection) exportJSON(args []string) (Result, error) {
+	if len(args) != 1 {
+		return Result{}, fmt.Errorf("exportjson: expected a path")
+	}
+
+	f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return Result{}, fmt.Errorf("exportjson: %w", err)
+	}
+	err = c.db.ExportJSON(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(args[0])
+		return Result{}, fmt.Errorf("exportjson: %w", err)
+	}
+	return Result{Value: args[0]}, nil
+}
+
+// importjson path
+func (c *Connection) importJSON(args []string) (Result, error) {
+	if len(args) != 1 {
+		return Result{}, fmt.Errorf("importjson: expected a path")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return Result{}, fmt.Errorf("importjson: %w", err)
+	}
+	defer f.Close()
+
+	if err := c.db.ImportJSON(f); err != nil {
+		return Result{}, err
+	}
+	return Result{Value: args[0]}, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImportJSON(t *testing.T) {
+	source := newDatabase()
+	c := source.newConnection()
+	c.mustExecCommand("set", []string{"b", "two \"quoted\""})
+	c.mustExecCommand("set", []string{"a", "1"})
+	c.mustExecCommand("set", []string{"gone", "x"})
+	c.mustExecCommand("delete", []string{"gone"})
+
+	path := filepath.Join(t.TempDir(), "data.json")
+	c.mustExecCommand("exportjson", []string{path})
+	data, _ := os.ReadFile(path)
+	assertEq(string(data), `{
+  "a": "1",
+  "b": "two \"quoted\""
+}
+`, "exported json")
+
+	// Imports merge into what's there.
+	target := newDatabase()
+	c2 := target.newConnection()
+	c2.mustExecCommand("set", []string{"a", "old"})
+	c2.mustExecCommand("set", []string{"c", "kept"})
+	c2.mustExecCommand("importjson", []string{path})
+	assertEq(c2.mustExecCommand("scan", []string{""}), "a=1\nb=two \"quoted\"\nc=kept", "imported")
+
+	var empty strings.Builder
+	nothing := newDatabase()
+	nothing.ExportJSON(&empty)
+	assertEq(empty.String(), "{}\n", "empty export")
+
+	err := target.ImportJSON(strings.NewReader(`["not", "an", "object"]`))
+	assert(err != nil, "bad import")
+	err = target.ImportJSON(strings.NewReader(`{"a": {"hex": "ff"}}`))
+	assert(err != nil, "bad value")
+}
+
+func TestExportImportJSON_binary(t *testing.T) {
+	source := newDatabase()
+	c := source.newConnection()
+	c.mustExecCommand("set", []string{"blob", "\xff\x00"})
+	var out strings.Builder
+	assertEq(source.ExportJSON(&out), nil, "export")
+	assertEq(out.String(), "{\n  \"blob\": {\"base64\":\"/wA=\"}\n}\n", "exported in base64")
+
+	target := newDatabase()
+	assertEq(target.ImportJSON(strings.NewReader(out.String())), nil, "import")
+	assertEq(target.newConnection().mustExecCommand("get", []string{"blob"}), "\xff\x00", "imported bytes")
+
+	c.mustExecCommand("set", []string{"\xff", "key"})
+	assert(source.ExportJSON(&out) != nil, "keys must be text")
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+A value can hold a JSON document, and jget and jset read and write inside
+it by path, so a client needn't fetch a whole document to change one field
+of it. The document is parsed from the visible version, and jset writes
+the whole document back as a new version, so documents get the same
+isolation and conflict detection as any other value: two transactions
+setting different fields of one document still conflict.
+
+Paths start at the root, $, and go into objects by .name and arrays by
+[index]. jset creates objects for names missing along the way, and an
+index one past the end of an array appends to it. Values are JSON, and
+numbers are kept as written.
+*/
+
+var (
+	ErrNotJSON = errors.New("value is not a JSON document")
+	ErrNoPath  = errors.New("no such path in document")
+)
+
+type pathStep struct {
+	name  string
+	index int
+	// Whether the step is into an array.
+	isIndex bool
+}
+
+func parsePath(path string) ([]pathStep, error) {
+	rest, ok := strings.CutPrefix(path, "$")
+	if !ok {
+		return nil, fmt.Errorf("bad path %q: must start with $", path)
+	}
+
+	var steps []pathStep
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return nil, fmt.Errorf("bad path %q: empty name", path)
+			}
+			steps = append(steps, pathStep{name: rest[1:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad path %q: unterminated index", path)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("bad path %q: bad index %q", path, rest[1:end])
+			}
+			steps = append(steps, pathStep{index: i, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("bad path %q: expected . or [ at %q", path, rest)
+		}
+	}
+	return steps, nil
+}
+
+func decodeJSON(s string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("trailing data")
+	}
+	return doc, nil
+}
+
+// encodeJSON encodes v without escaping HTML characters, which a
+// document store has no reason to.
+func encodeJSON(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func getPath(node any, steps []pathStep) (any, bool) {
+	for _, step := range steps {
+		switch n := node.(type) {
+		case map[string]any:
+			if step.isIndex {
+				return nil, false
+			}
+			var ok bool
+			if node, ok = n[step.name]; !ok {
+				return nil, false
+			}
+		case []any:
+			if !step.isIndex || step.index >= len(n) {
+				return nil, false
+			}
+			node = n[step.index]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// setPath returns node with the value at steps set to value.
+func setPath(node any, steps []pathStep, value any) (any, bool) {
+	if len(steps) == 0 {
+		return value, true
+	}
+	step := steps[0]
+
+	if node == nil && !step.isIndex {
+		node = map[string]any{}
+	}
+	switch n := node.(type) {
+	case map[string]any:
+		if step.isIndex {
+			return nil, false
+		}
+		child, ok := setPath(n[step.name], steps[1:], value)
+		if !ok {
+			return nil, false
+		}
+		n[step.name] = child
+		return n, true
+	case []any:
+		if !step.isIndex || step.index > len(n) {
+			return nil, false
+		}
+		if step.index == len(n) {
+			n = append(n, nil)
+		}
+		child, ok := setPath(n[step.index], steps[1:], value)
+		if !ok {
+			return nil, false
+		}
+		n[step.index] = child
+		return n, true
+	}
+	return nil, false
+}
+
+// JGet returns the JSON at path in the document held by key.
+func (t *Transaction) JGet(key, path string) (string, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return "", err
+	}
+	value, err := t.get(key)
+	if err != nil {
+		return "", err
+	}
+	s, err := t.db.read(value)
+	if err != nil {
+		return "", err
+	}
+	doc, err := decodeJSON(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrNotJSON, key)
+	}
+	node, ok := getPath(doc, steps)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNoPath, path)
+	}
+	return encodeJSON(node), nil
+}
+
+// JSet sets path in the document held by key to the JSON value, writing
+// the whole document as a new version. A missing key is an empty
+// document.
+func (t *Transaction) JSet(key, path, value string) error {
+	steps, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	v, err := decodeJSON(value)
+	if err != nil {
+		return fmt.Errorf("bad JSON value: %w", err)
+	}
+
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return err
+	}
+	var doc any
+	if current, err := t.get(key); err == nil {
+		s, err := t.db.read(current)
+		if err != nil {
+			return err
+		}
+		if doc, err = decodeJSON(s); err != nil {
+			return fmt.Errorf("%w: %s", ErrNotJSON, key)
+		}
+	}
+	doc, ok := setPath(doc, steps, v)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoPath, path)
+	}
+	t.set(key, encodeJSON(doc))
+	return nil
+}
+
+// jget key path
+func (c *Connection) jget(args []string) (Result, error) {
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	value, err := c.tx.JGet(args[0], args[1])
+	res.Value = value
+	res.NotFound = errors.Is(err, ErrKeyNotFound)
+	return res, err
+}
+
+// jset key path value
+func (c *Connection) jset(args []string) (Result, error) {
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	return res, c.tx.JSet(args[0], args[1], args[2])
+}
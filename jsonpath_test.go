@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"doc", `{"name": "ada", "langs": ["go", "c"], "n": 1.50}`})
+
+	assertEq(c.mustExecCommand("jget", []string{"doc", "$.name"}), `"ada"`, "field")
+	assertEq(c.mustExecCommand("jget", []string{"doc", "$.langs[1]"}), `"c"`, "index")
+	assertEq(c.mustExecCommand("jget", []string{"doc", "$.n"}), "1.50", "numbers kept as written")
+	assertEq(c.mustExecCommand("jget", []string{"doc", "$"}), `{"langs":["go","c"],"n":1.50,"name":"ada"}`, "root")
+
+	c.mustExecCommand("jset", []string{"doc", "$.langs[2]", `"<rust>"`})
+	c.mustExecCommand("jset", []string{"doc", "$.address.city", `"london"`})
+	c.mustExecCommand("jset", []string{"doc", "$.langs[0]", `{"name": "go"}`})
+	assertEq(c.mustExecCommand("get", []string{"doc"}),
+		`{"address":{"city":"london"},"langs":[{"name":"go"},"c","<rust>"],"n":1.50,"name":"ada"}`, "whole document")
+
+	_, err := c.execCommand("jset", []string{"new", "$.a[0]", "1"})
+	assert(errors.Is(err, ErrNoPath), "arrays aren't created")
+	c.mustExecCommand("jset", []string{"new", "$.a", "1"})
+	assertEq(c.mustExecCommand("get", []string{"new"}), `{"a":1}`, "missing key is an empty document")
+}
+
+func TestJSONPath_errors(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"doc", `{"a": [1]}`})
+	c.mustExecCommand("set", []string{"text", "hey"})
+
+	for _, tc := range []struct {
+		command string
+		args    []string
+		want    error
+	}{
+		{"jget", []string{"doc", "$.b"}, ErrNoPath},
+		{"jget", []string{"doc", "$.a[1]"}, ErrNoPath},
+		{"jget", []string{"doc", "$.a.b"}, ErrNoPath},
+		{"jget", []string{"text", "$"}, ErrNotJSON},
+		{"jget", []string{"missing", "$"}, ErrKeyNotFound},
+		{"jset", []string{"doc", "$.a[2]", "1"}, ErrNoPath},
+		{"jset", []string{"doc", "$.a.b", "1"}, ErrNoPath},
+		{"jset", []string{"text", "$.a", "1"}, ErrNotJSON},
+	} {
+		_, err := c.execCommand(tc.command, tc.args)
+		assert(errors.Is(err, tc.want), tc.command+" "+tc.args[1]+": "+tc.want.Error())
+	}
+
+	for _, path := range []string{"a", "$.", "$[x]", "$[1", "$a"} {
+		_, err := c.execCommand("jget", []string{"doc", path})
+		assert(err != nil, "bad path "+path)
+	}
+	_, err := c.execCommand("jset", []string{"doc", "$.a", "{"})
+	assert(err != nil, "bad value")
+	assertEq(c.mustExecCommand("get", []string{"doc"}), `{"a": [1]}`, "unchanged")
+}
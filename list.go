@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+A list is a value of its own type (see types.go), so every push or pop
+writes the whole list as a new version, and lists get the same isolation
+and conflict detection as any other value: a transaction sees the list
+as it was in its snapshot, however many elements others have pushed
+since, and two transactions pushing to one list conflict. That makes
+each push cost as much as the list is long, which suits the short lists
+of a queue or a recent-items feed rather than an ever-growing log.
+
+As in Redis, pushing to a missing key makes a list, and popping the last
+element deletes the key. The elements are kept one after another, each
+preceded by its length.
+*/
+
+func encodeList(elems []string) string {
+	var b frameBody
+	for _, elem := range elems {
+		b = b.string(elem)
+	}
+	return string(b)
+}
+
+func decodeList(raw string) []string {
+	var elems []string
+	r := frameReader{b: []byte(raw)}
+	for len(r.b) > 0 && r.err == nil {
+		elems = append(elems, r.string())
+	}
+	return elems
+}
+
+func formatList(elems []string) string {
+	if elems == nil {
+		elems = []string{}
+	}
+	return encodeJSON(elems)
+}
+
+func parseList(s string) ([]string, error) {
+	var elems []string
+	err := json.Unmarshal([]byte(s), &elems)
+	return elems, err
+}
+
+// list returns the elements of the list held by key, or none if the key
+// is missing. The caller must hold the lock.
+func (t *Transaction) list(key string) ([]string, error) {
+	value, err := t.get(key)
+	if err != nil {
+		return nil, nil
+	}
+	if value.data.kind != ListType {
+		return nil, wrongType(key, value.data.kind, ListType)
+	}
+	raw, err := t.db.readRaw(value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeList(raw), nil
+}
+
+// push adds values to the front of the list held by key, or to its back,
+// returning the list's new length.
+func (t *Transaction) push(key string, front bool, values ...string) (int, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, err
+	}
+	elems, err := t.list(key)
+	if err != nil {
+		return 0, err
+	}
+	if front {
+		// Each is pushed in turn, so the last ends up first.
+		for _, v := range values {
+			elems = append([]string{v}, elems...)
+		}
+	} else {
+		elems = append(elems, values...)
+	}
+	t.write(key, encodeList(elems), ListType, 0)
+	return len(elems), nil
+}
+
+// LPush adds values to the front of the list held by key, returning its
+// new length.
+func (t *Transaction) LPush(key string, values ...string) (int, error) {
+	return t.push(key, true, values...)
+}
+
+// RPush adds values to the back of the list held by key, returning its
+// new length.
+func (t *Transaction) RPush(key string, values ...string) (int, error) {
+	return t.push(key, false, values...)
+}
+
+// LPop removes and returns the first element of the list held by key.
+func (t *Transaction) LPop(key string) (string, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return "", err
+	}
+	elems, err := t.list(key)
+	if err != nil {
+		return "", err
+	}
+	if len(elems) == 0 {
+		return "", ErrKeyNotFound
+	}
+	if len(elems) == 1 {
+		t.delete(key)
+	} else {
+		t.write(key, encodeList(elems[1:]), ListType, 0)
+	}
+	return elems[0], nil
+}
+
+// LRange returns the elements of the list held by key from start to stop,
+// inclusive. Negative indexes count from the end, -1 being the last.
+func (t *Transaction) LRange(key string, start, stop int) ([]string, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return nil, err
+	}
+	elems, err := t.list(key)
+	if err != nil {
+		return nil, err
+	}
+
+	start, stop = rankRange(len(elems), start, stop)
+	return elems[start:stop], nil
+}
+
+// rankRange turns start and stop, inclusive indexes into n elements that
+// count from the end if negative, into the bounds of a slice of them.
+func rankRange(n, start, stop int) (int, int) {
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop+1, n)
+	if start >= stop {
+		return 0, 0
+	}
+	return start, stop
+}
+
+// lpush key value...
+// rpush key value...
+func (c *Connection) push(command string, args []string) (Result, error) {
+	if len(args) < 2 {
+		return Result{}, fmt.Errorf("%s: expected a key and values", command)
+	}
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	n, err := c.tx.push(args[0], command == "lpush", args[1:]...)
+	if err != nil {
+		return res, err
+	}
+	res.Value = strconv.Itoa(n)
+	return res, nil
+}
+
+// lpop key
+func (c *Connection) lpop(args []string) (Result, error) {
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	value, err := c.tx.LPop(args[0])
+	res.Value = value
+	res.NotFound = errors.Is(err, ErrKeyNotFound)
+	return res, err
+}
+
+// parseRank parses the start and stop of command's range.
+func parseRank(command, start, stop string) (int, int, error) {
+	i, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: bad start %q", command, start)
+	}
+	j, err := strconv.Atoi(stop)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: bad stop %q", command, stop)
+	}
+	return i, j, nil
+}
+
+// lrange key start stop
+func (c *Connection) lrange(args []string) (Result, error) {
+	start, stop, err := parseRank("lrange", args[1], args[2])
+	if err != nil {
+		return Result{}, err
+	}
+	elems, err := c.tx.LRange(args[0], start, stop)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Keys: args[:1], TxId: c.tx.id, Value: strings.Join(elems, "\n"), Values: elems}, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	c := database.newConnection()
+
+	assertEq(c.mustExecCommand("rpush", []string{"q", "b", "c"}), "2", "rpush")
+	assertEq(c.mustExecCommand("lpush", []string{"q", "a", "z"}), "4", "lpush")
+	assertEq(c.mustExecCommand("lrange", []string{"q", "0", "-1"}), "z\na\nb\nc", "everything")
+	assertEq(c.mustExecCommand("lrange", []string{"q", "1", "2"}), "a\nb", "middle")
+	assertEq(c.mustExecCommand("lrange", []string{"q", "-2", "10"}), "b\nc", "clamped")
+	assertEq(c.mustExecCommand("lrange", []string{"q", "3", "1"}), "", "empty")
+	assertEq(c.mustExecCommand("get", []string{"q"}), `["z","a","b","c"]`, "as a string")
+	assertEq(c.mustExecCommand("type", []string{"q"}), "list", "type")
+
+	// Each transaction sees the list in its snapshot.
+	snapshot := database.newConnection()
+	snapshot.mustExecCommand("begin", nil)
+	assertEq(c.mustExecCommand("lpop", []string{"q"}), "z", "lpop")
+	assertEq(snapshot.mustExecCommand("lrange", []string{"q", "0", "0"}), "z", "snapshot")
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("rpush", []string{"q", "d"})
+	c.mustExecCommand("abort", nil)
+	assertEq(c.mustExecCommand("lrange", []string{"q", "0", "-1"}), "a\nb\nc", "aborted push")
+
+	for range 3 {
+		c.mustExecCommand("lpop", []string{"q"})
+	}
+	_, err := c.execCommand("get", []string{"q"})
+	assertEq(err, ErrKeyNotFound, "popping the last element deletes the key")
+	_, err = c.execCommand("lpop", []string{"q"})
+	assertEq(err, ErrKeyNotFound, "nothing to pop")
+
+	c.mustExecCommand("set", []string{"s", "string"})
+	_, err = c.execCommand("rpush", []string{"s", "x"})
+	assert(errors.Is(err, ErrWrongType), "not a list")
+}
+
+func TestList_conflict(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	t1.RPush("q", "one")
+	t2.RPush("q", "two")
+	assertEq(t1.Commit(), nil, "first push")
+	var conflict *ConflictError
+	assert(errors.As(t2.Commit(), &conflict), "second push conflicts")
+}
+
+func TestList_recovery(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+	tx, _ := database.Begin()
+	tx.RPush("q", "a", "b")
+	assertEq(tx.Commit(), nil, "commit")
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	tx, _ = database.Begin()
+	elems, _ := tx.LRange("q", 0, -1)
+	assertEq(len(elems), 2, "recovered")
+	assertEq(elems[1], "b", "recovered")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+/*
+The database logs through a *slog.Logger, so an embedding application can
+send its logs wherever the rest of its own go. Nothing is logged unless a
+logger is given with WithLogger. Routine goings-on (transactions beginning
+and completing, vacuum and checkpoint passes) are at debug level; work
+dropped or retried because of an error is at warn level or above.
+
+Whatever is logged on behalf of a transaction carries its id and
+isolation level as the "tx" and "isolation" attributes.
+*/
+
+// An Option configures a database as it is created.
+type Option func(*Database)
+
+// WithLogger makes the database log to logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(d *Database) {
+		d.logger = logger
+	}
+}
+
+func (d *Database) apply(opts ...Option) {
+	for _, opt := range opts {
+		opt(d)
+	}
+}
+
+// debug logs msg at debug level, with the transaction's attributes.
+func (t *Transaction) debug(msg string, args ...any) {
+	if !t.debugging() {
+		return
+	}
+	t.db.logger.Debug(msg, append([]any{"tx", t.id, "isolation", t.isolation.String()}, args...)...)
+}
+
+// debugging reports whether debug would log. Calls on hot paths check it
+// first, since boxing debug's arguments allocates even when it doesn't.
+func (t *Transaction) debugging() bool {
+	return t.db.logger.Enabled(context.Background(), slog.LevelDebug)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	database, err := NewDatabase(t.TempDir(), WithLogger(logger))
+	assertEq(err, nil, "open")
+	defer database.Close()
+
+	c := database.newConnection()
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "1"})
+	c.mustExecCommand("commit", nil)
+
+	var sawBegin bool
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var record map[string]any
+		assertEq(json.Unmarshal(line, &record), nil, "json record")
+		if record["msg"] == "beginning transaction" {
+			sawBegin = true
+			assertEq(record["level"], "DEBUG", "level")
+			assertEq(record["tx"], any(float64(1)), "tx attribute")
+			assertEq(record["isolation"], any("read-committed"), "isolation attribute")
+		}
+	}
+	assert(sawBegin, "begin logged")
+
+	buf.Reset()
+	quiet := newDatabase()
+	quiet.apply(WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))))
+	quiet.newConnection().mustExecCommand("set", []string{"x", "1"})
+	assertEq(buf.Len(), 0, "nothing below warn")
+}
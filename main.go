@@ -1,11 +1,31 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/tidwall/btree"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func assert(b bool, msg string) {
@@ -20,21 +40,16 @@ func assertEq[C comparable](a C, b C, prefix string) {
 	}
 }
 
-var DEBUG = slices.Contains(os.Args, "--debug")
-
-func debug(a ...any) {
-	if !DEBUG {
-		return
-	}
-
-	args := append([]any{"[DEBUG]"}, a...)
-	fmt.Println(args...)
-}
-
 type Value struct {
 	txStartId uint64
 	txEndId   uint64
-	value     string
+	data      payload
+
+	// CRC-32C of value, only computed when the database has checksums
+	// enabled.
+	checksum uint32
+	// Unix time in seconds at which the version expires, zero if never.
+	expiresAt uint32
 }
 
 type TransactionState uint8
@@ -43,8 +58,25 @@ const (
 	InProgressTransaction TransactionState = iota
 	AbortedTransaction
 	CommittedTransaction
+	// Committed into its parent, and waiting for the parent to complete.
+	// See nested.go.
+	MergedTransaction
 )
 
+func (s TransactionState) String() string {
+	switch s {
+	case InProgressTransaction:
+		return "in-progress"
+	case AbortedTransaction:
+		return "aborted"
+	case CommittedTransaction:
+		return "committed"
+	case MergedTransaction:
+		return "merged"
+	}
+	return fmt.Sprintf("TransactionState(%d)", uint8(s))
+}
+
 // Loosest isolation at the top, strictiest isolation at the bottom
 type IsolationLevel uint8
 
@@ -69,13 +101,85 @@ type Transaction struct {
 	isolation IsolationLevel
 	id        uint64
 	state     TransactionState
+	// Whether an administrator aborted it. See admin.go.
+	killed bool
+	// What conflicts are resolved by. See priority.go.
+	priority Priority
 
 	// Used only by Repeatable Read and stricter
-	inprogress btree.Set[uint64]
+	inprogress snapshot
 
 	// Used only by Snapshot Isolation and stricter.
-	writerset btree.Set[string]
-	readset   btree.Set[string]
+	writeset btree.Set[string]
+	readset  btree.Set[string]
+
+	// When the transaction began, and when it was last used. See
+	// idle.go.
+	started time.Time
+	active  time.Time
+
+	// Set on the read-only snapshots used for time-travel reads.
+	historical bool
+
+	// When it committed, by the database's hybrid logical clock. See
+	// hlc.go.
+	committedAt HLC
+	// The timestamp it reads at, if it was begun at one. See
+	// followerread.go.
+	readAt HLC
+
+	// Whether the transaction has records in the write-ahead log, and
+	// the first error writing one.
+	logged bool
+	walErr error
+
+	// The first error reading back a value the transaction had to know
+	// to write (see payload.go), which keeps it from committing.
+	readErr error
+
+	// Callbacks registered with OnCommit and OnRollback.
+	onCommit   []func()
+	onRollback []func()
+
+	// The transaction's span, and a context carrying it, until it
+	// completes. See trace.go.
+	ctx  context.Context
+	span trace.Span
+	// How many versions visibility checks have walked.
+	versionsScanned int
+	// The bytes of the versions it has written. See memory.go.
+	added int64
+
+	// The statements it ran, for the slow log. See slowlog.go.
+	statements        []SlowStatement
+	omittedStatements int
+
+	// The global id it was prepared under, if it has been. See
+	// prepare.go.
+	prepared string
+
+	// The transaction this one is nested in, if any; the nested
+	// transaction in progress, if any; and those that committed into
+	// this one, all the way down. See nested.go.
+	parent *Transaction
+	child  *Transaction
+	merged btree.Set[uint64]
+
+	// Savepoints, oldest first, and what to undo to roll back to them.
+	// See savepoint.go.
+	savepoints []savepoint
+	undo       []undoEntry
+
+	// The versions it has ended that other transactions wrote, with the
+	// end marks they had before. See endVisible.
+	ended []undoEntry
+
+	// Who it runs on behalf of, and what the keys it has written held
+	// before, for the audit log. See audit.go.
+	identity string
+	audited  map[string]*string
+
+	db *Database
 }
 
 /*
@@ -89,63 +193,206 @@ ids to new transactions.
 */
 type Database struct {
 	defaultIsolation  IsolationLevel
-	store             map[string][]Value
-	transactions      btree.Map[uint64, Transaction]
+	store             btree.Map[string, []Value]
+	transactions      btree.Map[uint64, *Transaction]
 	nextTransactionId uint64
+
+	// The ids of the transactions in progress.
+	running btree.Set[uint64]
+	// Transactions that were pruned from the registry after aborting.
+	// Every other pruned transaction committed.
+	aborted btree.Set[uint64]
+	// What reads as of a pruned transaction need of it, and the latest
+	// end of a version vacuum removed, which older snapshots may have
+	// seen. See asof.go.
+	pastSnapshots btree.Map[uint64, pastSnapshot]
+	vacuumedEnd   uint64
+	// The outcomes of finished transactions. See clog.go.
+	clog commitLog
+	// Keys whose versions aren't kept in the two parts sealEnds keeps
+	// them in, so reads look at all of them.
+	tangled btree.Set[string]
+
+	// Store a checksum with every version written.
+	checksums bool
+
+	// Where admin commands record what they did, if anywhere.
+	adminLog io.Writer
+	// Where slow transactions are reported, if anywhere.
+	slowLog *SlowLog
+	// Where committed changes are audited, if anywhere.
+	audit func(AuditRecord)
+	// Where commands are recorded, if anywhere. See record.go.
+	recorder *recorder
+	// Prepared transactions, by global id.
+	prepared map[string]*Transaction
+	// What resolves write-write conflicts, by key prefix.
+	resolvers []resolver
+
+	watches map[*Watch]struct{}
+	scripts scripts
+	indexes indexes
+	buckets map[string]*Bucket
+	// Who may touch which keys, for connections that authenticated.
+	acl acl
+
+	// How much history vacuum and registry pruning leave behind.
+	retention Retention
+
+	// The change feed (see changefeed.go): the most recent commits'
+	// changes, oldest first, how many commits there have been, and a
+	// channel closed at the next, for streams waiting for one. The epoch
+	// tells this database's watch cursors from others' (see watch.go).
+	feed            []committedChanges
+	feedNext        uint64
+	feedUpdated     chan struct{}
+	feedEpoch       uint64
+	changeRetention int
+
+	// The hybrid logical clock commits are stamped with, and the latest
+	// stamp of a commit pruned from the registry. See hlc.go.
+	hlc       HLC
+	hlcPruned HLC
+
+	// Where writes are logged, if anywhere, and the data directory the
+	// log and checkpoints are kept in.
+	wal WAL
+	dir string
+
+	// The followers the log is shipped to, and the leader this database
+	// follows, if any. See replication.go and follower.go.
+	replicas map[*replica]struct{}
+	follower *Follower
+	// The database's part in a raft cluster, if any. See raft.go.
+	raftNode atomic.Pointer[RaftNode]
+
+	// Where large values are kept, if not in memory.
+	segments *segmentStore
+
+	// Values longer than this are compressed, if it is positive.
+	compressAbove int
+	compression   CompressionStats
+
+	metrics *metrics
+	// See log.go and trace.go.
+	logger *slog.Logger
+	tracer trace.Tracer
+
+	// Overrides time.Now, for tests.
+	now func() time.Time
+	// Where faults are injected, for tests. See faults.go.
+	faults FaultInjector
+
+	// How many copies of the store are frozen for scans. See frozen.go.
+	frozen int
+
+	// The bytes held by versions, and the most everything may hold, if
+	// positive. See memory.go.
+	versionBytes int64
+	maxMemory    int64
+	// The bloom filter of the store's keys, if any, and its false
+	// positive rate. See bloom.go.
+	bloom     *bloomFilter
+	bloomRate float64
+
+	mu      sync.Mutex
+	batcher writeBatcher
+	// The commits of the write batch being applied, if they are being
+	// synced together. See batch.go.
+	group *commitGroup
+	// Set once Shutdown has been called. drained is closed by whichever
+	// transaction completion leaves nothing in progress.
+	shutdown bool
+	drained  chan struct{}
 }
 
 func newDatabase() Database {
 	return Database{
 		defaultIsolation: ReadCommitedIsolation,
-		store:            map[string][]Value{},
 		// The `0` transaction id will be used to mean
 		// that the id was not set. So all valid transaction ids
 		// must start at 1.
 		nextTransactionId: 1,
+		metrics:           newMetrics(),
+		feedEpoch:         rand.Uint64(),
+		logger:            slog.New(slog.DiscardHandler),
+		tracer:            defaultTracer(),
 	}
 }
 
 /*
-To be thread-safe, store, transactions, and nextTransactionId should be guarded
-by a mutex. But to keep the code small, this post will not use goroutines and
-thus does not need mutexts
+To be thread-safe, store, transactions, and nextTransactionId are guarded
+by mu. The exported methods take the lock; everything unexported assumes
+the caller already holds it.
 */
 
+var (
+	ErrDatabaseShutdown      = errors.New("database is shut down")
+	ErrTransactionAborted    = errors.New("transaction was aborted")
+	ErrConnectionClosed      = errors.New("connection is closed")
+	ErrKeyNotFound           = errors.New("cannot get key that does not exist")
+	ErrNoTransaction         = errors.New("no transaction in progress")
+	ErrTransactionInProgress = errors.New("transaction already in progress")
+	ErrUnknownCommand        = errors.New("unknown command")
+)
+
 /*
 There's abit of book-keeping when creating a transaction, so we'll make a dedicted
 method for this. We must give the new transation id, store all in-progress
 transactions, and add it to the database transaction history.
 */
 
-func (d *Database) inprogress() btree.Set[uint64] {
-	var ids btree.Set[uint64]
-	iter := d.transactions.Iter()
+// inprogress returns a snapshot of the transactions running, with their
+// ids appended to buf.
+func (d *Database) inprogress(buf []uint64) snapshot {
+	d.running.Scan(func(id uint64) bool {
+		buf = append(buf, id)
+		return true
+	})
+	return newSnapshot(d.nextTransactionId, buf)
+}
+
+// hasInProgress reports whether any transaction is in progress, other
+// than those prepared and waiting for their coordinator.
+func (d *Database) hasInProgress() bool {
+	iter := d.running.Iter()
 	for ok := iter.First(); ok; ok = iter.Next() {
-		if iter.Value().state == InProgressTransaction {
-			ids.Insert(iter.Key())
+		if t, _ := d.transactions.Get(iter.Key()); t.root().prepared == "" {
+			return true
 		}
 	}
-	return ids
+	return false
+}
+
+func (d *Database) newTransaction(ctx context.Context) *Transaction {
+	return d.newTransactionAt(ctx, d.defaultIsolation)
 }
 
-func (d *Database) newTransaction() *Transaction {
-	t := Transaction{}
-	t.isolation = d.defaultIsolation
+func (d *Database) newTransactionAt(ctx context.Context, isolation IsolationLevel) *Transaction {
+	t := transactionPool.Get().(*Transaction)
+	t.isolation = isolation
 	t.state = InProgressTransaction
+	t.db = d
+	t.started = d.clock()
+	t.active = t.started
+	t.identity = identityFrom(ctx)
 
 	// Assign and increment transaction id.
 	t.id = d.nextTransactionId
 	d.nextTransactionId++
 
 	// Store all inprogress transaction ids
-	t.inprogress = d.inprogress()
+	t.inprogress = d.inprogress(t.inprogress.running)
 
 	// Add this transaction to history
 	d.transactions.Set(t.id, t)
+	d.running.Insert(t.id)
+	d.metrics.begun.WithLabelValues(t.isolation.String()).Inc()
+	t.startSpan(ctx)
 
-	debug("starting transaction", t.id)
+	t.debug("beginning transaction")
 
-	return &t
+	return t
 }
 
 /*
@@ -154,182 +401,1850 @@ fetching a transaction by id, and for validating a transaction
 */
 
 func (d *Database) completeTransaction(t *Transaction, state TransactionState) error {
-	debug("completing transactions ", t.id)
+	t.debug("completing transaction", "state", state.String())
+
+	// Only aborting can complete a transaction with a nested one in
+	// progress, which goes the same way.
+	if t.child != nil {
+		child := t.child
+		d.completeTransaction(child, AbortedTransaction)
+		t.onRollback = append(t.onRollback, child.takeHooks()...)
+	}
+
+	// A follower writes only what it replicates (see follower.go).
+	if state == CommittedTransaction && d.follower != nil && t.writeset.Len() > 0 {
+		d.completeTransaction(t, AbortedTransaction)
+		return ErrReadOnly
+	}
+	if state == CommittedTransaction && t.root().readAt != 0 && t.writeset.Len() > 0 {
+		d.completeTransaction(t, AbortedTransaction)
+		return ErrReadOnlySnapshot
+	}
+
+	if state == CommittedTransaction && t.readErr != nil {
+		d.completeTransaction(t, AbortedTransaction)
+		return t.readErr
+	}
+
+	// A prepared transaction was checked when it prepared.
+	if state == CommittedTransaction && t.parent == nil && t.prepared == "" {
+		if err := d.checkMemoryBudget(t); err != nil {
+			d.completeTransaction(t, AbortedTransaction)
+			return err
+		}
+	}
+
+	// A prepared transaction was validated when it was prepared.
+	if state == CommittedTransaction && t.prepared == "" {
+		if err := d.validate(t); err != nil {
+			d.completeTransaction(t, AbortedTransaction)
+			return err
+		}
+	}
+
+	// Committing stamps the transaction, and if that fails after all,
+	// aborting takes the stamp away again.
+	if state == CommittedTransaction {
+		t.committedAt = d.tickHLC()
+	} else {
+		t.committedAt = 0
+	}
+
+	// In a raft cluster, what commits is up to the log (see raft.go).
+	if n := d.raftNode.Load(); n != nil && state == CommittedTransaction && t.parent == nil && t.writeset.Len() > 0 {
+		if err := n.commit(t); err != nil {
+			d.completeTransaction(t, AbortedTransaction)
+			return err
+		}
+	}
+
+	if err := d.logCompletion(t, state); err != nil {
+		d.completeTransaction(t, AbortedTransaction)
+		return err
+	}
+	// A batched write finishes once its batch's log sync has succeeded.
+	if state == CommittedTransaction && d.group.holds(t) {
+		return nil
+	}
+
+	d.finishCompletion(t, state)
+	return nil
+}
+
+// finishCompletion completes t, whose commit or abort record is logged.
+func (d *Database) finishCompletion(t *Transaction, state TransactionState) {
+	if state == AbortedTransaction {
+		d.restoreEnds(t)
+	} else if t.parent == nil {
+		d.claimEnds(t)
+	}
 
 	//Update transactions
 	t.state = state
-	d.transactions.Set(t.id, *t)
+	d.clog.record(t.id, state)
+	d.running.Delete(t.id)
+	// Whatever was merged into t completes along with it.
+	completed := float64(1 + t.merged.Len())
+	if state == CommittedTransaction {
+		d.metrics.committed.WithLabelValues(t.isolation.String()).Add(completed)
+	} else {
+		d.metrics.aborted.WithLabelValues(t.isolation.String()).Add(completed)
+	}
+	if t.parent != nil {
+		t.parent.child = nil
+	}
+	d.settleMerged(t)
+	if state == CommittedTransaction && t.parent == nil {
+		d.sealEnds(t)
+	}
+	t.endSpan(state)
+	d.reportIfSlow(t)
+	d.pruneTransactions()
 
-	return nil
+	if state == CommittedTransaction {
+		d.auditCommit(t)
+		d.publishChanges(t)
+	}
+
+	if d.shutdown && !d.hasInProgress() {
+		close(d.drained)
+		d.drained = nil
+	}
 }
 
-func (d *Database) transactionState(txId uint64) Transaction {
-	t, ok := d.transactions.Get(txId)
-	assert(ok, "valid transaction")
-	return t
+// validate checks that t, which is about to commit, doesn't conflict
+// with anything that committed while it was in progress.
+func (d *Database) validate(t *Transaction) error {
+	// Snapshot Isolation imposes the additional constraint that no
+	// transaction A may commit after writing any of the same keys as
+	// transaction B has written and committed during transaction A's
+	// life.
+	//
+	// Unless the keys have resolvers, which merge the writes instead (see
+	// resolve.go). Counters merge their increments whatever the isolation
+	// level (see counter.go).
+	resolved, err := d.mergeCounters(t)
+	if err != nil {
+		return err
+	}
+	if t.isolation == SnapshotIsolation {
+		for {
+			winner, keys := d.findConflict(t, func(t1 *Transaction, t2 *Transaction) []string {
+				return slices.DeleteFunc(sharedItems(t1.writeset, t2.writeset), func(key string) bool {
+					return resolved[key]
+				})
+			})
+			if winner == nil {
+				break
+			}
+			if winner.state != CommittedTransaction || !d.resolve(t, keys) {
+				return t.conflict("write-write", winner, keys)
+			}
+			if resolved == nil {
+				resolved = map[string]bool{}
+			}
+			for _, key := range keys {
+				resolved[key] = true
+			}
+		}
+	}
+
+	// Serializable Isolation imposes the additional constraint that
+	// no transaction A may commit after reading any of the same keys
+	// as transaction B has written and committed during transaction
+	// A's life, or vice-versa.
+	if t.isolation == SerializableIsolation {
+		if winner, keys := d.findConflict(t, func(t1 *Transaction, t2 *Transaction) []string {
+			keys := append(sharedItems(t1.readset, t2.writeset), sharedItems(t1.writeset, t2.readset)...)
+			slices.Sort(keys)
+			return slices.Compact(keys)
+		}); winner != nil {
+			return t.conflict("read-write", winner, keys)
+		}
+	}
+
+	// And whatever the isolation level, t mustn't break a unique index
+	// (see unique.go).
+	return d.checkUnique(t)
+}
+
+func (d *Database) clock() time.Time {
+	if d.now != nil {
+		return d.now()
+	}
+	return time.Now()
+}
+
+// versions returns the version chain for key. Versions can be updated in
+// place, but appending to the chain must be followed by a store.Set.
+func (d *Database) versions(key string) []Value {
+	versions, _ := d.store.Get(key)
+	return versions
+}
+
+func (d *Database) transactionState(txId uint64) TransactionState {
+	if state := d.clog.state(txId); state != InProgressTransaction {
+		return state
+	}
+	return d.registryState(txId)
+}
+
+// registryState is transactionState without the commit log.
+func (d *Database) registryState(txId uint64) TransactionState {
+	if t, ok := d.transactions.Get(txId); ok {
+		return t.state
+	}
+
+	// Not in the registry, so it finished long enough ago to be pruned.
+	assert(txId > 0 && txId < d.nextTransactionId, "valid transaction")
+	if d.aborted.Contains(txId) {
+		return AbortedTransaction
+	}
+	return CommittedTransaction
+}
+
+/*
+Conflict detection only cares about transactions that committed while t1
+was running: the ones that were in progress when t1 started, and the ones
+that started after t1 did, and prepared ones count as committed (see
+prepare.go). So do those of higher priority still in progress (see
+priority.go). findConflict returns the first of those that conflictFn
+finds keys in common with, and the keys.
+*/
+func (d *Database) findConflict(t1 *Transaction, conflictFn func(*Transaction, *Transaction) []string) (*Transaction, []string) {
+	// First see if there is any transaction that was in progress when
+	// this one started that has since committed.
+	for _, id := range t1.inprogress.running {
+		t2, ok := d.transactions.Get(id)
+		if ok && (t2.state == CommittedTransaction || t2.prepared != "") {
+			if keys := conflictFn(t1, t2); len(keys) > 0 {
+				return t2, keys
+			}
+		}
+	}
+
+	// Then see if there is any transaction that started after this one
+	// that has committed.
+	for id := t1.id + 1; id < d.nextTransactionId; id++ {
+		t2, ok := d.transactions.Get(id)
+		if ok && (t2.state == CommittedTransaction || t2.prepared != "") {
+			if keys := conflictFn(t1, t2); len(keys) > 0 {
+				return t2, keys
+			}
+		}
+	}
+
+	return d.yieldTo(t1, conflictFn)
+}
+
+func sharedItems(s1 btree.Set[string], s2 btree.Set[string]) []string {
+	var items []string
+	iter := s1.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		if s2.Contains(iter.Key()) {
+			items = append(items, iter.Key())
+		}
+	}
+	return items
 }
 
 func (d *Database) isvisible(t *Transaction, value Value) bool {
+	return d.visibility(t, value) == versionVisible
+}
+
+// visibility reports whether t sees value, and if not, why not (see
+// explain.go).
+func (d *Database) visibility(t *Transaction, value Value) visibility {
 	// Read Uncommited means we simply read the last value written.
 	// Even if the transaction that wrote this value has not committed,
 	// and even if it has aborted.
 	if t.isolation == ReadUncommitedIsolation {
 		// We must merely make sure the value has not been deleted.
-		return value.txEndId == 0
+		if value.txEndId != 0 {
+			return versionEnded
+		}
+		return versionVisible
 	}
 
-	assert(false, "unsupported isolation level")
-	return false
+	// Read Committed means we are allowed to read any values that are
+	// committed at the point in time where we read.
+	if t.isolation == ReadCommitedIsolation {
+		return d.committedVisibility(t, value)
+	}
+
+	// Repeatable Read, Snapshot Isolation, and Serializable further
+	// restrict Read Committed so only versions from transactions that
+	// completed before this one started are visible.
+	assert(t.isolation == RepeatableReadIsolation ||
+		t.isolation == SnapshotIsolation ||
+		t.isolation == SerializableIsolation, "unsupported isolation level")
+
+	// A nested transaction sees the snapshot its outermost transaction
+	// began with.
+	snapshot := t.root().id
+
+	// Values from this transaction are visible, unless deleted since.
+	own := t.owns(value.txStartId)
+
+	// Ignore values from transactions started after this one.
+	if value.txStartId > snapshot && !own {
+		return writerStartedLater
+	}
+
+	// Ignore values created from transactions in progress when this
+	// one started.
+	if t.inprogress.contains(value.txStartId) {
+		return writerRunningAtSnapshot
+	}
+
+	// If the value was created by a transaction that is not committed,
+	// and not this current transaction, it's no good.
+	if d.transactionState(value.txStartId) != CommittedTransaction && !own {
+		return writerUncommitted
+	}
+
+	// If the value was deleted in this transaction, it's no good. (Unless
+	// this is a historical snapshot, which reads as of the moment its
+	// transaction began and so before any of its deletes.)
+	if t.owns(value.txEndId) && !t.historical {
+		return endedByOwn
+	}
+
+	// Or if the value was deleted in some other committed transaction
+	// that started before this one, it's no good.
+	if value.txEndId < snapshot &&
+		value.txEndId > 0 &&
+		d.transactionState(value.txEndId) == CommittedTransaction &&
+		!t.inprogress.contains(value.txEndId) {
+		return endedBeforeSnapshot
+	}
+
+	return versionVisible
+}
+
+// visibleCommitted reports whether t would see value under Read
+// Committed, whatever its isolation.
+func (d *Database) visibleCommitted(t *Transaction, value Value) bool {
+	return d.committedVisibility(t, value) == versionVisible
+}
+
+// committedVisibility is visibility under Read Committed.
+func (d *Database) committedVisibility(t *Transaction, value Value) visibility {
+	// If the value was created by a transaction that is not
+	// committed, and not this current transaction, it's no good.
+	if !t.owns(value.txStartId) &&
+		d.transactionState(value.txStartId) != CommittedTransaction {
+		return writerUncommitted
+	}
+
+	// If the value was deleted in this transaction, it's no good.
+	if t.owns(value.txEndId) {
+		return endedByOwn
+	}
+
+	// Or if the value was deleted in some other committed
+	// transaction, it's no good.
+	if value.txEndId > 0 &&
+		d.transactionState(value.txEndId) == CommittedTransaction {
+		return endedCommitted
+	}
+
+	// Otherwise the value is good.
+	return versionVisible
 }
 
 func (d *Database) assertValidTransaction(t *Transaction) {
 	assert(t.id > 0, "valid id")
-	assert(d.transactionState(t.id).state == InProgressTransaction, "in progress")
+	assert(d.transactionState(t.id) == InProgressTransaction, "in progress")
 }
 
 /*
-The final bit of scaffolding we'll set up is an abstraction for database connection. A
-A connection will have at most assocated one transaction. Users must ask the
-database for a new connection. Then within the connection thye can manage a
-transaction.
+The same operations the command layer exposes are also available directly
+on a Transaction, for callers embedding the database rather than talking
+to it through a Connection.
 */
 
-type Connection struct {
-	tx *Transaction
-	db *Database
+// Begin starts a new transaction at the database's default isolation level.
+func (d *Database) Begin() (*Transaction, error) {
+	return d.BeginContext(context.Background())
 }
 
-func (c *Connection) execCommand(command string, args []string) (string, error) {
-	debug(command, args)
+// Commit completes the transaction, making its writes visible to others.
+// Under Snapshot and Serializable isolation the commit may instead fail
+// with a conflict, in which case the transaction has been aborted.
+func (t *Transaction) Commit() error {
+	return t.complete(CommittedTransaction)
+}
 
-	/*
-		When a user asks to begin a transaction, we ask the db for a new
-		transaction and assign it to the current connection
-	*/
-	if command == "begin" {
-		assertEq(c.tx, nil, "no running transactions")
-		c.tx = c.db.newTransaction()
-		c.db.assertValidTransaction(c.tx)
-		return fmt.Sprintf("%d", c.tx.id), nil
+// Abort completes the transaction, discarding its writes.
+func (t *Transaction) Abort() error {
+	return t.complete(AbortedTransaction)
+}
+
+func (t *Transaction) complete(state TransactionState) error {
+	t.db.mu.Lock()
+	err := t.checkInProgress()
+	// Aborting takes whatever is nested in the transaction with it.
+	if errors.Is(err, ErrNestedInProgress) && state == AbortedTransaction {
+		err = nil
 	}
+	if err != nil {
+		t.db.mu.Unlock()
+		return err
+	}
+	if t.parent != nil && state == CommittedTransaction {
+		t.db.mergeChild(t)
+	} else {
+		err = t.db.completeTransaction(t, state)
+	}
+	hooks := t.takeHooks()
+	t.db.mu.Unlock()
 
-	/*
-		To abort a transaction, we call the completTransaction method
-		(which makes sure the database transaction history gets updated)
-		with the AbortedTransaction state
-	*/
-	if command == "abort" {
-		c.db.assertValidTransaction(c.tx)
-		err := c.db.completeTransaction(c.tx, AbortedTransaction)
-		c.tx = nil
+	runHooks(hooks)
+	return err
+}
+
+// A transaction can be aborted out from under its owner (by Shutdown, for
+// example). That is reported as ErrTransactionAborted rather than tripping
+// the assertion that guards against reusing a completed transaction.
+// Every use of a transaction is checked first, so this is also where it
+// is marked active.
+func (t *Transaction) checkInProgress() error {
+	if t.state == AbortedTransaction && t.killed {
+		return ErrTransactionKilled
+	}
+	if t.state == AbortedTransaction {
+		return ErrTransactionAborted
+	}
+	if t.prepared != "" {
+		return ErrTransactionPrepared
+	}
+	if t.child != nil {
+		return ErrNestedInProgress
+	}
+	t.db.assertValidTransaction(t)
+	t.touch()
+	return nil
+}
+
+/*
+As mentioned earlier, the key-value store maps each key to an array of
+values, with the more recent versions of a value at the end of the list
+of values for the key. It is ordered by key so that ranges of keys can be
+scanned.
+
+For get support, we'll iterate the list of value versions backwards for the key.
+And we'll call a special new isvisible method to determine if this transaction
+can see this value. The first value that passes the isvisible test is
+the correct value for the transaction. Once we reach a version whose delete
+the transaction sees as committed, none before it can pass (see sealEnds),
+so a key with a long history costs no more to read than one without.
+*/
+
+// Get returns the value of key visible to the transaction.
+func (t *Transaction) Get(key string) (string, error) {
+	defer t.db.metrics.observe("get", time.Now())
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
 		return "", err
 	}
 
-	/* commit a transaction */
-	if command == "commit" {
-		c.db.assertValidTransaction(c.tx)
-		err := c.db.completeTransaction(c.tx, CommittedTransaction)
-		c.tx = nil
+	value, err := t.get(key)
+	if err != nil {
 		return "", err
 	}
+	return t.db.readVerified(key, value)
+}
 
-	/*
-		As mentioned earlier, the key-value store is actually map[string][]Value.
-		With the more recent versions of a value at the end of the list of values
-		for the key.
-	*/
-	/*
-		For get support, we'll iterate the list of value versions backwards for the key.
-		And we'll call a special new isvisible method to determine if this transaction
-		can see this value. The first value that passes the isvisible test is
-		the correct value for the transaction.
-	*/
-	if command == "get" {
-		c.db.assertValidTransaction(c.tx)
+// get returns the version of key visible to the transaction, recording
+// the read.
+func (t *Transaction) get(key string) (*Value, error) {
+	t.readset.Insert(key)
 
-		key := args[0]
-		c.tx.readset.Insert(key)
+	if value := t.visible(key); value != nil {
+		return value, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// visible returns the version of key visible to the transaction, if any.
+func (t *Transaction) visible(key string) *Value {
+	return t.visibleIn(key, t.db.lookup(key), !t.db.tangled.Contains(key))
+}
 
-		for i := len(c.db.store[key]) - 1; i >= 0; i-- {
-			value := c.db.store[key][i]
-			debug(value, c.tx, c.db.isvisible(c.tx, value))
+// visibleIn returns the version among versions, key's, visible to the
+// transaction, if any. Unless sealed, the versions aren't in the two parts
+// sealEnds keeps them in.
+func (t *Transaction) visibleIn(key string, versions []Value, sealed bool) *Value {
+	ownOnly := t.ownOnly(key)
+	for i := len(versions) - 1; i >= 0; i-- {
+		value := &versions[i]
+		visible := t.db.isvisible(t, *value) && (!ownOnly || t.owns(value.txStartId))
+		t.versionsScanned++
+		if t.debugging() {
+			t.debug("checking version", "key", key, "start", value.txStartId, "end", value.txEndId, "visible", visible)
+		}
 
-			if c.db.isvisible(c.tx, value) {
-				return value.value, nil
+		if visible {
+			if t.expired(value) {
+				return nil
 			}
+			return value
+		}
+		if sealed && t.sealedBy(value) {
+			break
 		}
+	}
+	return nil
+}
+
+/*
+I snuck in tracking which keys are read, and we'll also soon sneak in
+tracking which keys are written. This is necessary in stricter isolation
+levels. More on this later.
+set and delet are similar to get. But this time, wehne we walk the list of value
+versions, we will set the texEndId for the value to the current transaction
+id if the value version is visible to this transaction.
+Then for set, we'll append to the value version list with the new version
+of the value that starts at this current transaction.
+*/
 
-		return "", fmt.Errorf("cannot get key that does not exist")
+// Set writes a new version of key.
+func (t *Transaction) Set(key, value string) error {
+	defer t.db.metrics.observe("set", time.Now())
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return err
 	}
 
-	/*
-		I snuck in tracking which keys are read, and we'll also soon sneak in
-		tracking which keys are written. This is necessary in stricter isolation
-		levels. More on this later.
-		set and delet are similar to get. But this time, wehne we walk the list of value
-		versions, we will set the texEndId for the value to the current transaction
-		id if the value version is visible to this transaction.
-		Then for set, we'll append to the value version list with the new version
-		of the value that starts at this current transaction.
-	*/
-	if command == "set" || command == "delete" {
-		c.db.assertValidTransaction(c.tx)
+	t.set(key, value)
+	return nil
+}
 
-		key := args[0]
+func (t *Transaction) set(key, value string) {
+	t.setWithExpiry(key, value, 0)
+}
 
-		// Mark all visible versions as now invalid.
-		found := false
-		for i := len(c.db.store[key]) - 1; i >= 0; i-- {
-			value := &c.db.store[key][i]
-			debug(value, c.tx, c.db.isvisible(c.tx, *value))
+func (t *Transaction) setWithExpiry(key, value string, expiresAt uint32) {
+	t.write(key, value, StringType, expiresAt)
+}
 
-			if c.db.isvisible(c.tx, *value) {
-				value.txEndId = c.tx.id
-				found = true
-			}
-		}
-		if command == "delete" && !found {
-			return "", fmt.Errorf("cannot delete key that does not exist")
-		}
+// write sets key to a value of type kind, stored as raw (see types.go).
+func (t *Transaction) write(key, raw string, kind ValueType, expiresAt uint32) {
+	value := formatValue(kind, raw)
+	t.updateIndexes(key, &value)
+	t.endVisible(key)
+	t.writeset.Insert(key)
+	t.log(WALRecord{Type: WALSet, Key: key, Value: raw, ValueType: kind, ExpiresAt: expiresAt})
 
-		c.tx.writeset.Insert(key)
-		// And add a new version if it's a set command.
-		if command == "set" {
-			value := args[1]
-			c.db.store[key] = append(c.db.store[key], Value{
-				txStartId: c.tx.id,
-				txEndId:   0,
-				value:     value,
-			})
+	// And add a new version.
+	t.appendVersion(key, t.db.versions(key), Value{
+		txStartId: t.id,
+		txEndId:   0,
+		data:      t.db.payload(raw, kind),
+		checksum:  t.db.checksum(value),
+		expiresAt: expiresAt,
+	})
+	t.remember(undoEntry{key: key, appended: true})
+}
 
-			return value, nil
-		}
+// Delete removes key, failing if no version of it is visible.
+func (t *Transaction) Delete(key string) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
 
-		// Delete ok.
-		return "", nil
+	if err := t.checkInProgress(); err != nil {
+		return err
 	}
-	/*
-		this time rather than modifying the readset we modify the writeset
-		for the transaction. And that is how commands get executed!
-	*/
 
-	//TODO:
-	return "", fmt.Errorf("unimplemented")
+	return t.delete(key)
 }
 
-func (c Connection) mustExecCommand(cmd string, args []string) string {
-	res, err := c.execCommand(cmd, args)
-	assertEq(err, nil, "unexpected error")
-	return res
+func (t *Transaction) delete(key string) error {
+	t.updateIndexes(key, nil)
+	if !t.endVisible(key) {
+		return fmt.Errorf("cannot delete key that does not exist")
+	}
+	t.writeset.Insert(key)
+	t.log(WALRecord{Type: WALDelete, Key: key})
+
+	// Delete ok.
+	return nil
 }
 
-func (d *Database) newConnection() *Connection {
-	return &Connection{
-		db: d,
-		tx: nil,
+/*
+A version has room for one end mark, and transactions that write a key
+concurrently, as they can at levels below Snapshot Isolation, or under
+Snapshot Isolation until one of them fails to commit, each want to set
+it. A mark set by a committed transaction stays: the version is ended for
+good. Otherwise the mark goes to whichever wrote last, and the others
+keep a note of the version. So a transaction that has written a key sees
+only its own versions of it, whatever the marks on the others say now;
+one that aborts puts back the marks it set, or the other's delete would
+be undone along with its own; and one that commits takes back the marks
+it lost to transactions that haven't, or its delete wouldn't count until
+they did.
+*/
+
+// ownOnly reports whether the transaction sees only its own versions of
+// key, having written it. Read Uncommitted reads the newest version
+// anyway.
+func (t *Transaction) ownOnly(key string) bool {
+	return t.isolation != ReadUncommitedIsolation && t.wrote(key)
+}
+
+// restoreEnds puts back the end marks the aborting transaction set, as
+// they were before, unless the transactions that set those have aborted
+// since.
+func (d *Database) restoreEnds(t *Transaction) {
+	for i := len(t.ended) - 1; i >= 0; i-- {
+		e := t.ended[i]
+		if e.end != 0 && d.transactionState(e.end) == AbortedTransaction {
+			e.end = 0
+		}
+		d.undo(e)
 	}
+	t.ended = nil
 }
 
-func main() {
-	panic("unimplemented")
+// claimEnds sets the committing transaction's end marks back on the
+// versions it ended where another transaction that hasn't committed set
+// its own since.
+func (d *Database) claimEnds(t *Transaction) {
+	for _, e := range t.ended {
+		versions := d.unsealed(t, e.key)
+		for i := range versions {
+			v := &versions[i]
+			if v.txStartId == e.start && v.txEndId != e.tx &&
+				(v.txEndId == 0 || d.transactionState(v.txEndId) != CommittedTransaction) {
+				v.txEndId = e.tx
+			}
+		}
+	}
+	t.ended = nil
+}
+
+/*
+A key's versions are kept in two parts. First come the versions whose
+deletes have committed, in the order those committed, and then the rest,
+in the order they were written. A version moves from the second part to
+the end of the first when the transaction that ended it commits, so the
+versions a transaction sees as deleted for good, having seen the delete
+commit, are all in the first part, below any it doesn't. Walking a key's
+versions from the newest, as reads and writes do, the first version whose
+delete a transaction sees as committed is the last it need look at: it
+sees every version below that one deleted too.
+
+Usually the second part holds just the version that is current, and the
+walk looks at one version, however many the key has kept, for as-of
+reads or transactions that began long ago.
+
+Moving a version down past another changes which of the two is newer, and
+so which a transaction that can see both reads. That's only possible if
+the other's writer has committed, and hadn't when the moving version's
+delete was written, or it would have been deleted too: a write that
+raced the delete at a level that allows it. Then the version stays where
+it is, and the key is tangled, so walks of it look at every version,
+until a vacuum finds its chain in two parts again.
+*/
+
+// sealedBy reports whether the transaction sees v's delete as committed,
+// and so every version older than v as deleted too.
+func (t *Transaction) sealedBy(v *Value) bool {
+	if v.txEndId == 0 || t.owns(v.txEndId) || t.db.transactionState(v.txEndId) != CommittedTransaction {
+		return false
+	}
+	if t.isolation <= ReadCommitedIsolation {
+		return true
+	}
+	return v.txEndId < t.root().id && !t.inprogress.contains(v.txEndId)
+}
+
+// chainPart returns the part of its key's chain v belongs in: 0 for the
+// first, 1 for the second.
+func (d *Database) chainPart(v Value) int {
+	if v.txEndId != 0 && d.transactionState(v.txEndId) == CommittedTransaction {
+		return 0
+	}
+	return 1
+}
+
+// sealEnds moves the versions the committed transaction t, or one merged
+// into it, has ended to the end of the first part of their keys' version
+// chains.
+func (d *Database) sealEnds(t *Transaction) {
+	d.sealWritten(t, t)
+	iter := t.merged.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		if merged, ok := d.transactions.Get(iter.Key()); ok {
+			d.sealWritten(t, merged)
+		}
+	}
+}
+
+// sealWritten seals t's ends among the keys w wrote.
+func (d *Database) sealWritten(t, w *Transaction) {
+	keys := w.writeset.Iter()
+	for ok := keys.First(); ok; ok = keys.Next() {
+		if key := keys.Key(); !d.tangled.Contains(key) && !d.seal(t, key) {
+			d.tangled.Insert(key)
+		}
+	}
+}
+
+// seal moves the versions t ended to the end of the first part of key's
+// versions, reporting whether it could.
+func (d *Database) seal(t *Transaction, key string) bool {
+	versions := d.versions(key)
+	moved := d.secondPart(t, versions)
+	cloned := false
+	for i := moved; i < len(versions); i++ {
+		v := versions[i]
+		if v.txEndId == 0 || !t.owns(v.txEndId) {
+			continue
+		}
+		for _, w := range versions[moved:i] {
+			if !t.owns(w.txStartId) && d.transactionState(w.txStartId) == CommittedTransaction {
+				return false
+			}
+		}
+		if moved < i && d.frozen > 0 && !cloned {
+			// A frozen store may share the chain (see frozen.go).
+			versions = slices.Clone(versions)
+			d.store.Set(key, versions)
+			cloned = true
+		}
+		copy(versions[moved+1:i+1], versions[moved:i])
+		versions[moved] = v
+		moved++
+	}
+	return true
+}
+
+// secondPart returns where the second part of versions starts, for t:
+// above the newest version ended by another transaction that has
+// committed.
+func (d *Database) secondPart(t *Transaction, versions []Value) int {
+	start := len(versions)
+	for start > 0 {
+		end := versions[start-1].txEndId
+		if end != 0 && !t.owns(end) && d.transactionState(end) == CommittedTransaction {
+			break
+		}
+		start--
+	}
+	return start
+}
+
+// unsealed returns the versions of key that t may have written or ended:
+// the second part of its chain, or all of it if the key is tangled.
+func (d *Database) unsealed(t *Transaction, key string) []Value {
+	versions := d.versions(key)
+	if d.tangled.Contains(key) {
+		return versions
+	}
+	return versions[d.secondPart(t, versions):]
+}
+
+// untangle reports whether the chain of a tangled key is in two parts
+// again, now that every transaction, current or future, sees the deletes
+// of its first part as committed.
+func (d *Database) untangle(versions []Value, horizon uint64) bool {
+	second := false
+	for _, v := range versions {
+		if d.chainPart(v) == 1 {
+			second = true
+		} else if second || v.txEndId >= horizon {
+			return false
+		}
+	}
+	return true
+}
+
+// endVisible marks all visible versions of key as now invalid, reporting
+// whether there were any that hadn't expired.
+func (t *Transaction) endVisible(key string) bool {
+	found := false
+	newest := -1
+	ownOnly := t.ownOnly(key)
+	sealed := !t.db.tangled.Contains(key)
+	versions := t.db.versions(key)
+	for i := len(versions) - 1; i >= 0; i-- {
+		value := &versions[i]
+		visible := t.db.isvisible(t, *value) && (!ownOnly || t.owns(value.txStartId))
+		t.versionsScanned++
+		if t.debugging() {
+			t.debug("checking version", "key", key, "start", value.txStartId, "end", value.txEndId, "visible", visible)
+		}
+
+		if visible {
+			newest = max(newest, i)
+			found = found || !t.expired(value)
+			if value.txEndId != 0 && !t.owns(value.txEndId) &&
+				t.db.transactionState(value.txEndId) == CommittedTransaction {
+				continue
+			}
+			t.remember(undoEntry{key: key, start: value.txStartId, end: value.txEndId})
+			if !t.owns(value.txStartId) {
+				t.ended = append(t.ended, undoEntry{tx: t.id, key: key, start: value.txStartId, end: value.txEndId})
+			}
+			value.txEndId = t.id
+		} else if sealed && t.sealedBy(value) {
+			break
+		}
+	}
+	t.auditOld(key, versions, newest)
+	return found
+}
+
+/*
+this time rather than modifying the readset we modify the writeset
+for the transaction.
+
+The final bit of scaffolding we'll set up is an abstraction for database connection. A
+A connection will have at most assocated one transaction. Users must ask the
+database for a new connection. Then within the connection thye can manage a
+transaction.
+*/
+
+type Connection struct {
+	tx     *Transaction
+	db     *Database
+	closed bool
+
+	// Run statements issued outside a transaction in their own
+	// single-statement transaction instead of rejecting them.
+	autocommit bool
+
+	// The users the connection may authenticate as, nil if it needn't,
+	// and the one it has.
+	users map[string]User
+	user  *User
+
+	// The context of the request being served, for frontends that have
+	// one. See trace.go.
+	ctx context.Context
+	// The address of the client, for network frontends.
+	remote string
+
+	// The bucket the connection's commands' keys are in, if any.
+	bucket *Bucket
+
+	// In a raft cluster, the leader the connection's commands are
+	// forwarded to, and whether the connection is itself running another
+	// node's forwarded commands. See forward.go.
+	forward   *forwarder
+	forwarded bool
+
+	// The last conflict a commit of the connection's was refused for, for
+	// explain conflict.
+	conflict *ConflictError
+}
+
+/*
+Commands return a Result rather than a bare string, so protocol frontends
+can encode responses without re-parsing them, and so a key holding the
+empty string can be told apart from a key with no visible value.
+*/
+
+type Result struct {
+	Value string
+	// NotFound is set when the command looked for a key that has no
+	// visible version. The command also returns ErrKeyNotFound.
+	NotFound bool
+	// Keys the command read or wrote.
+	Keys []string
+	// Key/value pairs, for commands returning more than one value.
+	Pairs []KeyValue
+	// Per-key outcomes, for commands reading several keys that may or
+	// may not exist.
+	Lookups []Lookup
+	// Values, for commands returning several that aren't keyed, like
+	// lrange (see list.go).
+	Values []string
+	// The transaction the command ran in, zero if none.
+	TxId uint64
+	// The version of the value read, for commands that report it (see
+	// cas.go).
+	Version uint64
+	// The checksum stored with the version a get read, if it has one
+	// (see checksum.go).
+	Checksum uint32
+}
+
+func (c *Connection) execCommand(command string, args []string) (res Result, err error) {
+	if c.tx != nil {
+		c.tx.debug("running command", "command", command, "args", args)
+	} else {
+		c.db.logger.Debug("running command", "command", command, "args", args)
+	}
+	if r := c.db.recorder; r != nil {
+		defer func(args []string) { r.record(c, command, args, res, err) }(args)
+	}
+
+	if c.closed {
+		return Result{}, ErrConnectionClosed
+	}
+
+	// A transaction's span covers its begin.
+	ctx := c.context()
+	if command != "begin" {
+		var span trace.Span
+		ctx, span = c.startStatement(command)
+		tx, versions := c.tx, 0
+		if tx != nil {
+			versions = tx.versionsScanned
+		}
+		defer func() { endStatement(span, tx, versions, res, err) }()
+	}
+
+	if c.users != nil && c.user == nil && command != "auth" {
+		return Result{}, ErrAuthRequired
+	}
+	if err := c.checkCommand(command, args); err != nil {
+		return Result{}, err
+	}
+	if c.bucket != nil {
+		if args, err = c.bucket.qualify(command, args); err != nil {
+			return Result{}, err
+		}
+	}
+	if err := c.authorize(command, args); err != nil {
+		return Result{}, err
+	}
+
+	addr, err := c.forwardTarget(command)
+	if err != nil {
+		return Result{}, err
+	}
+	if addr != "" {
+		res, err = c.forwardCommand(addr, command, args)
+	} else if c.tx == nil && statementCommands[command] {
+		res, err = c.autocommitStatement(ctx, command, args)
+	} else if statementCommands[command] {
+		res, err = c.runStatement(command, args)
+	} else {
+		res, err = c.dispatch(command, args)
+	}
+	if c.bucket != nil && (statementCommands[command] || command == "watch" || command == "watch-prefix") {
+		res = c.bucket.unqualifyResult(command, res)
+	}
+
+	// If the transaction was aborted behind our back, forget about it so
+	// the connection can begin a new one.
+	if errors.Is(err, ErrTransactionAborted) {
+		c.tx = nil
+	}
+	if conflict := (*ConflictError)(nil); errors.As(err, &conflict) {
+		c.conflict = conflict
+	}
+
+	return res, err
+}
+
+// How many arguments commands take, for those whose handlers don't check
+// themselves.
+var commandArity = map[string][2]int{
+	"auth":              {2, 2},
+	"grant":             {3, 3},
+	"revoke":            {2, 2},
+	"grants":            {0, 1},
+	"stats":             {0, 0},
+	"replication":       {0, 0},
+	"raft":              {0, 0},
+	"raft-join":         {2, 2},
+	"watch":             {1, 2},
+	"watch-prefix":      {1, 2},
+	"begin":             {0, 4},
+	"txlist":            {0, 0},
+	"kill":              {2, 2},
+	"use":               {0, 1},
+	"type":              {1, 1},
+	"lookup":            {2, 2},
+	"jget":              {2, 2},
+	"jset":              {3, 3},
+	"lpop":              {1, 1},
+	"lrange":            {3, 3},
+	"hget":              {2, 2},
+	"hgetall":           {1, 1},
+	"smembers":          {1, 1},
+	"zrange":            {3, 4},
+	"savepoint":         {1, 1},
+	"prepare":           {1, 1},
+	"commit-prepared":   {1, 1},
+	"rollback-prepared": {1, 1},
+	"rollback":          {2, 2},
+	"get":               {1, 3},
+	"set":               {2, 2},
+	"delete":            {1, 1},
+	"meta":              {1, 1},
+	"keys":              {1, 1},
+	"exists":            {1, 1},
+	"incr":              {1, 2},
+	"decr":              {1, 2},
+	"cincr":             {1, 2},
+	"cdecr":             {1, 2},
+	"setnx":             {2, 2},
+	"getdel":            {1, 1},
+	"gets":              {1, 1},
+	"cas":               {3, 3},
+	"rename":            {2, 2},
+	"copy":              {2, 3},
+	"expire":            {2, 2},
+	"ttl":               {1, 1},
+	"explain":           {1, 2},
+}
+
+// checkCommand rejects commands that can't run as given, before they
+// reach a handler that would trip over them.
+func (c *Connection) checkCommand(command string, args []string) error {
+	switch command {
+	case "commit", "abort", "savepoint", "rollback", "prepare":
+		// Or the leader has, for a connection forwarding to it.
+		if c.tx == nil && (c.forward == nil || !c.forward.inTx) {
+			return ErrNoTransaction
+		}
+	}
+
+	if arity, ok := commandArity[command]; ok && (len(args) < arity[0] || len(args) > arity[1]) {
+		return fmt.Errorf("%s: wrong number of arguments", command)
+	}
+	return nil
+}
+
+func (c *Connection) dispatch(command string, args []string) (Result, error) {
+
+	/*
+		When a user asks to begin a transaction, we ask the db for a new
+		transaction and assign it to the current connection
+	*/
+	if command == "begin" && len(args) == 2 && (args[0] == "asof" || args[0] == "stale") {
+		return c.beginReadAt(args)
+	}
+
+	if command == "begin" {
+		isolation, rest, err := c.beginIsolation(args)
+		if err != nil {
+			return Result{}, err
+		}
+		priority, err := beginPriority(rest)
+		if err != nil {
+			return Result{}, err
+		}
+		if c.tx != nil && len(args) > 0 {
+			return Result{}, errors.New("begin: nested transactions have their outermost transaction's isolation level and priority")
+		}
+		if c.tx != nil {
+			return c.beginNested()
+		}
+		tx, err := c.db.beginAt(c.context(), isolation)
+		if err != nil {
+			return Result{}, err
+		}
+		if err := tx.SetPriority(priority); err != nil {
+			tx.Abort()
+			return Result{}, err
+		}
+		c.tx = tx
+		return Result{Value: fmt.Sprintf("%d", c.tx.id), TxId: c.tx.id}, nil
+	}
+
+	/*
+		To abort a transaction, we call the completTransaction method
+		(which makes sure the database transaction history gets updated)
+		with the AbortedTransaction state
+	*/
+	if command == "abort" {
+		res := Result{TxId: c.tx.id}
+		err := c.tx.Abort()
+		c.tx = c.tx.parent
+		return res, err
+	}
+
+	/* commit a transaction */
+	if command == "commit" {
+		res := Result{TxId: c.tx.id}
+		err := c.tx.Commit()
+		c.tx = c.tx.parent
+		return res, err
+	}
+
+	if command == "get" && len(args) == 3 && args[1] == "asof" {
+		return c.getAsOf(args)
+	}
+
+	if command == "get" {
+		res := Result{Keys: args[:1], TxId: c.tx.id}
+		value, sum, err := c.tx.GetWithChecksum(args[0])
+		res.Value, res.Checksum = value, sum
+		res.NotFound = errors.Is(err, ErrKeyNotFound)
+		return res, err
+	}
+
+	if command == "set" {
+		res := Result{Keys: args[:1], TxId: c.tx.id}
+		err := c.tx.Set(args[0], args[1])
+		if err != nil {
+			return res, err
+		}
+		res.Value = args[1]
+		return res, nil
+	}
+
+	if command == "delete" {
+		res := Result{Keys: args[:1], TxId: c.tx.id}
+		return res, c.tx.Delete(args[0])
+	}
+
+	if command == "auth" {
+		return c.auth(args)
+	}
+
+	if command == "grant" {
+		return c.grant(args)
+	}
+
+	if command == "revoke" {
+		return c.revoke(args)
+	}
+
+	if command == "grants" {
+		return c.grants(args)
+	}
+
+	if command == "autocommit" {
+		return c.setAutocommit(args)
+	}
+
+	if command == "abortall" {
+		return c.abortAll(args)
+	}
+
+	if command == "use" {
+		return c.use(args)
+	}
+
+	if command == "kill" {
+		return c.kill(args)
+	}
+
+	if command == "type" {
+		return c.typeOf(args)
+	}
+
+	if command == "lookup" {
+		return c.lookup(args)
+	}
+
+	if command == "jget" {
+		return c.jget(args)
+	}
+
+	if command == "jset" {
+		return c.jset(args)
+	}
+
+	if command == "lpush" || command == "rpush" {
+		return c.push(command, args)
+	}
+
+	if command == "lpop" {
+		return c.lpop(args)
+	}
+
+	if command == "lrange" {
+		return c.lrange(args)
+	}
+
+	if command == "hset" {
+		return c.hset(args)
+	}
+
+	if command == "hget" {
+		return c.hget(args)
+	}
+
+	if command == "hdel" {
+		return c.hdel(args)
+	}
+
+	if command == "hgetall" {
+		return c.hgetall(args)
+	}
+
+	if command == "sadd" || command == "srem" {
+		return c.sadd(command, args)
+	}
+
+	if command == "smembers" {
+		return c.smembers(args)
+	}
+
+	if command == "zadd" {
+		return c.zadd(args)
+	}
+
+	if command == "zrange" {
+		return c.zrange(args)
+	}
+
+	if command == "history" {
+		return c.history(args)
+	}
+
+	if command == "explain" {
+		return c.explain(args)
+	}
+
+	if command == "vacuum" {
+		return c.vacuum(args)
+	}
+
+	if command == "backup" {
+		return c.backup(args)
+	}
+
+	if command == "restore" {
+		return c.restore(args)
+	}
+
+	if command == "exportjson" {
+		return c.exportJSON(args)
+	}
+
+	if command == "importjson" {
+		return c.importJSON(args)
+	}
+
+	if command == "scan" {
+		return c.scan(args)
+	}
+
+	if command == "keys" {
+		return c.keys(args)
+	}
+
+	if command == "mget" {
+		return c.mget(args)
+	}
+
+	if command == "mset" {
+		return c.mset(args)
+	}
+
+	if command == "mdel" {
+		return c.mdel(args)
+	}
+
+	if command == "exists" {
+		return c.exists(args)
+	}
+
+	if command == "prepare" {
+		return c.prepare(args)
+	}
+
+	if command == "commit-prepared" || command == "rollback-prepared" {
+		return c.finishPrepared(command, args)
+	}
+
+	if command == "savepoint" {
+		return c.savepoint(args)
+	}
+
+	if command == "rollback" {
+		return c.rollbackTo(args)
+	}
+
+	if command == "txlist" {
+		return c.txlist()
+	}
+
+	if command == "stats" {
+		return c.stats()
+	}
+
+	if command == "replication" {
+		return c.replication()
+	}
+
+	if command == "watch" || command == "watch-prefix" {
+		return c.watch(command, args)
+	}
+
+	if command == "raft" {
+		return c.raftStatus()
+	}
+
+	if command == "raft-join" {
+		return c.raftJoin(args)
+	}
+
+	if command == "dbsize" {
+		return c.dbsize()
+	}
+
+	if command == "incr" || command == "decr" {
+		return c.incr(command, args)
+	}
+
+	if command == "cincr" || command == "cdecr" {
+		return c.cincr(command, args)
+	}
+
+	if command == "setnx" {
+		return c.setnx(args)
+	}
+
+	if command == "getdel" {
+		return c.getdel(args)
+	}
+
+	if command == "gets" {
+		return c.gets(args)
+	}
+
+	if command == "cas" {
+		return c.cas(args)
+	}
+
+	if command == "rename" {
+		return c.rename(args)
+	}
+
+	if command == "copy" {
+		return c.copy(args)
+	}
+
+	if command == "expire" {
+		return c.expire(args)
+	}
+
+	if command == "ttl" {
+		return c.ttl(args)
+	}
+
+	if command == "call" {
+		return c.call(args)
+	}
+
+	if command == "meta" {
+		res := Result{Keys: args[:1], TxId: c.tx.id}
+		meta, err := c.tx.Meta(args[0])
+		if err != nil {
+			res.NotFound = errors.Is(err, ErrKeyNotFound)
+			return res, err
+		}
+		res.Value = meta.String()
+		return res, nil
+	}
+
+	return Result{}, fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+}
+
+func (c *Connection) mustExecCommand(cmd string, args []string) string {
+	res, err := c.execCommand(cmd, args)
+	assertEq(err, nil, "unexpected error")
+	return res.Value
+}
+
+func (d *Database) newConnection() *Connection {
+	return &Connection{
+		db:         d,
+		tx:         nil,
+		autocommit: true,
+	}
+}
+
+// Close aborts the connection's open transaction, if any. Further commands
+// on the connection fail with ErrConnectionClosed.
+func (c *Connection) Close() (err error) {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if r := c.db.recorder; r != nil {
+		defer func() { r.record(c, "close", nil, Result{}, err) }()
+	}
+	if c.forward != nil {
+		c.forward.close()
+		c.forward = nil
+	}
+
+	if c.tx == nil {
+		return nil
+	}
+
+	err = c.tx.root().Abort()
+	c.tx = nil
+	if errors.Is(err, ErrTransactionAborted) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops the database from starting new transactions and waits for
+// the in-progress ones to finish. If ctx is done first, whatever is still
+// in progress is aborted and ctx's error is returned. Either way, what has
+// been written to disk is then made durable.
+func (d *Database) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.shutdown {
+		d.shutdown = true
+		if d.hasInProgress() {
+			d.drained = make(chan struct{})
+		}
+	}
+	drained := d.drained
+	d.mu.Unlock()
+
+	var err error
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			d.forceAbort(func(*Transaction) bool { return true })
+			err = ctx.Err()
+		}
+	}
+
+	if flushErr := d.flush(); flushErr != nil {
+		return errors.Join(err, flushErr)
+	}
+	return err
+}
+
+func main() {
+	addr := flag.String("listen", "", "address to serve the line protocol on, if any")
+	socket := flag.String("socket", "", "unix socket to serve the line protocol on, if any")
+	socketMode := flag.Uint("socket-mode", 0o600, "permissions for the unix socket")
+	respAddr := flag.String("resp", "", "address to serve the Redis protocol on, if any")
+	memcacheAddr := flag.String("memcache", "", "address to serve the memcached text protocol on, if any; clients don't authenticate, so not with -users")
+	grpcAddr := flag.String("grpc", "", "address to serve gRPC on, if any")
+	httpAddr := flag.String("http", "", "address to serve the HTTP API on, if any")
+	replicationAddr := flag.String("replication", "", "address to ship the write-ahead log to followers on, if any; needs -dir")
+	leader := flag.String("follow", "", "replication address of a leader to keep a read-only copy of, in memory, if any")
+	followAuthFile := flag.String("follow-auth", "", `file holding the "name:password" of an admin to authenticate to the -follow leader as, if it has -users`)
+	raftID := flag.String("raft-id", "", "this node's id in a raft cluster; with -raft and -raft-forward, makes it a node of one, in memory")
+	raftAddr := flag.String("raft", "", "address to talk to the other nodes of the raft cluster on")
+	forwardAddr := flag.String("raft-forward", "", "address to serve commands forwarded from the other nodes of the raft cluster on")
+	raftDir := flag.String("raft-dir", "", "directory to keep the raft log and snapshots in; in memory if empty")
+	raftBootstrap := flag.Bool("raft-bootstrap", false, "start a new raft cluster with just this node, which others can then join")
+	raftSecretFile := flag.String("raft-secret", "", "file holding the secret the raft cluster's nodes forward commands to each other with; needed with -users")
+	metricsAddr := flag.String("metrics", "", "address to serve Prometheus metrics on, at /metrics, if any")
+	adminAddr := flag.String("admin", "", "address to serve the read-only admin UI on, if any; it shows keys and values, so keep it internal; with -users, only admins may see it")
+	tlsCert := flag.String("tls-cert", "", "certificate to serve TLS with, if any")
+	tlsKey := flag.String("tls-key", "", "key for -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "certificate authority to check other nodes' -tls-cert against, if not the system's; with it or -tls-cert, other nodes are dialed over TLS")
+	usersFile := flag.String("users", "", `file of "name:password" lines; if given, clients must authenticate, over HTTP and gRPC with Basic credentials`)
+	grantsFile := flag.String("grants", "", `file of "user prefix r|w|rw" lines, granting access to keys`)
+	dir := flag.String("dir", "", "data directory; if empty, nothing is kept after exiting")
+	otlpURL := flag.String("otlp", "", "OTLP/gRPC endpoint URL to export traces to, if any")
+	adminLogFile := flag.String("admin-log", "", "file to record what admin commands (kill, abortall) did to, if any")
+	auditLogFile := flag.String("audit-log", "", "file to record every committed change to, as lines of JSON, if any")
+	slowLogFile := flag.String("slow-log", "", "file to log slow transactions to, if any")
+	slowDuration := flag.Duration("slow-duration", time.Second, "transactions running at least this long are slow")
+	slowKeys := flag.Int("slow-keys", 0, "transactions touching more keys than this are slow, if positive")
+	recordFile := flag.String("record", "", "file to record every command to, for the replay subcommand, if any")
+	maxMemory := flag.Int64("max-memory", 0, "bytes the database may hold before commits that write are refused, if positive")
+	checksums := flag.Bool("checksums", false, "store a checksum with every version written, returned and verified by gets")
+	bloomRate := flag.Float64("bloom-fp-rate", 0, "keep a bloom filter of keys with this false positive rate, if between 0 and 1")
+	syncMode := flag.String("sync", "every-commit", "when to sync the log of a -dir database: every-commit, periodically or never")
+	syncInterval := flag.Duration("sync-interval", time.Second, "how often -sync periodically syncs the log")
+	checkpointInterval := flag.Duration("checkpoint-interval", 0, "checkpoint a -dir database this often, if positive")
+	vacuumInterval := flag.Duration("autovacuum-interval", 0, "vacuum in the background this often, if positive")
+	vacuumBatch := flag.Int("autovacuum-batch", 0, "keys to vacuum every -autovacuum-interval; 0 means the whole store")
+	expiryInterval := flag.Duration("expiry-sweep-interval", 0, "delete expired keys this often, if positive")
+	segmentsDir := flag.String("segments", "", "directory to keep large values in, as segment files, if any; cleared on start")
+	segmentSize := flag.Int64("segment-size", 64<<20, "bytes a -segments file may grow to")
+	compactionInterval := flag.Duration("segment-compaction-interval", 0, "compact -segments files this often, if positive")
+	compactionGarbage := flag.Float64("segment-min-garbage", 0.5, "fraction of a segment file that must be garbage for compaction to rewrite it")
+	idleTimeout := flag.Duration("idle-timeout", 0, "abort transactions left idle this long, if positive")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to let transactions in progress finish on shutdown before aborting them")
+	debugFlag := flag.Bool("debug", false, "log debugging output")
+	simulateSeed := flag.Uint64("simulate", 0, "run a simulated workload from this seed, print its trace, and exit; see simulation.go")
+	simulateSteps := flag.Int("simulate-steps", 1000, "how many steps the simulation takes")
+	simulateIsolation := flag.String("simulate-isolation", "snapshot", "the isolation level the simulation runs at")
+	flag.Parse()
+
+	if flag.Arg(0) == "bench" {
+		if err := runBench(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "replay" {
+		if err := runReplay(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *simulateSeed != 0 {
+		level, err := parseIsolation(*simulateIsolation)
+		if err != nil {
+			log.Fatal(err)
+		}
+		trace, err := Simulate(SimulationConfig{Seed: *simulateSeed, Steps: *simulateSteps, Isolation: level})
+		for _, line := range trace {
+			fmt.Println(line)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	level := slog.LevelInfo
+	if *debugFlag {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	opts := []Option{WithLogger(logger)}
+
+	if *otlpURL != "" {
+		exporter, err := otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpointURL(*otlpURL))
+		if err != nil {
+			log.Fatal(err)
+		}
+		tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+		defer tp.Shutdown(context.Background())
+		opts = append(opts, WithTracerProvider(tp))
+	}
+
+	if *adminLogFile != "" {
+		f, err := os.OpenFile(*adminLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		opts = append(opts, WithAdminLog(f))
+	}
+
+	if *auditLogFile != "" {
+		f, err := os.OpenFile(*auditLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		opts = append(opts, WithAuditLog(WriteAuditLog(f)))
+	}
+
+	if *slowLogFile != "" {
+		f, err := os.OpenFile(*slowLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		opts = append(opts, WithSlowLog(SlowLog{Duration: *slowDuration, Keys: *slowKeys, Report: WriteSlowLog(f)}))
+	}
+
+	if *recordFile != "" {
+		f, err := os.Create(*recordFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		opts = append(opts, WithRecording(f))
+	}
+	if *maxMemory > 0 {
+		opts = append(opts, WithMaxMemory(*maxMemory))
+	}
+	if *bloomRate > 0 {
+		opts = append(opts, WithBloomFilter(*bloomRate))
+	}
+	if *checksums {
+		opts = append(opts, WithChecksums())
+	}
+	if *segmentsDir != "" {
+		segments, err := openSegmentStore(*segmentsDir, *segmentSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, withSegmentStore(segments))
+	}
+
+	// Options are applied once, to the database that is served.
+	db := new(Database)
+	if *dir != "" {
+		var err error
+		if db, err = NewDatabase(*dir, opts...); err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+	} else {
+		*db = newDatabase()
+		db.apply(opts...)
+	}
+	if *dir != "" {
+		mode, err := parseSyncMode(*syncMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := db.SetSyncMode(mode, *syncInterval); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *checkpointInterval > 0 {
+		if *dir == "" {
+			log.Fatal("-checkpoint-interval needs -dir")
+		}
+		defer db.StartCheckpointer(*checkpointInterval)()
+	}
+	if *vacuumInterval > 0 {
+		defer db.StartAutoVacuum(AutoVacuumOptions{Interval: *vacuumInterval, BatchSize: *vacuumBatch})()
+	}
+	if *expiryInterval > 0 {
+		defer db.StartExpirySweeper(*expiryInterval)()
+	}
+	if *compactionInterval > 0 {
+		if *segmentsDir == "" {
+			log.Fatal("-segment-compaction-interval needs -segments")
+		}
+		defer db.StartSegmentCompaction(*compactionInterval, *compactionGarbage)()
+	}
+	if *idleTimeout > 0 {
+		defer db.StartIdleTimeout(*idleTimeout)()
+	}
+	// Nodes talk to each other over TLS if they serve it.
+	var tlsConfig, peerTLS *tls.Config
+	if *tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	if *tlsCert != "" || *tlsCA != "" {
+		var err error
+		if peerTLS, err = loadPeerTLS(*tlsCA); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *leader != "" {
+		cfg := FollowConfig{Leader: *leader, TLS: peerTLS}
+		if *followAuthFile != "" {
+			var err error
+			if cfg.User, cfg.Password, err = loadCredentials(*followAuthFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		f, err := db.FollowWith(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+	}
+	if *raftID != "" {
+		if *dir != "" || *leader != "" || *grantsFile != "" {
+			log.Fatal("-raft-id can't be used with -dir, -follow or -grants")
+		}
+		// Forwarded commands run as the users who sent them, so only
+		// nodes may send them.
+		if *usersFile != "" && (*tlsCert == "" || *raftSecretFile == "") {
+			log.Fatal("-raft-id with -users needs -tls-cert and -raft-secret")
+		}
+		var secret string
+		if *raftSecretFile != "" {
+			var err error
+			if secret, err = loadSecret(*raftSecretFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		node, err := db.StartRaft(RaftConfig{ID: *raftID, Addr: *raftAddr, ForwardAddr: *forwardAddr, Dir: *raftDir, Bootstrap: *raftBootstrap, Secret: secret, TLS: peerTLS})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer node.Close()
+	}
+
+	if *addr == "" && *socket == "" && *respAddr == "" && *memcacheAddr == "" && *grpcAddr == "" && *httpAddr == "" && *metricsAddr == "" && *adminAddr == "" && *replicationAddr == "" && *raftID == "" {
+		runREPL(db, os.Stdin, os.Stdout)
+		return
+	}
+
+	srv := NewServer(db)
+	respSrv := NewRESPServer(db)
+	memcacheSrv := NewMemcacheServer(db)
+	replicationSrv := NewReplicationServer(db)
+	forwardingSrv := NewForwardingServer(db)
+	var grpcOpts []grpc.ServerOption
+	mvccSrv := NewGRPCServer(db)
+	api := NewHTTPServer(db)
+	httpSrv := &http.Server{Addr: *httpAddr, Handler: api}
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("GET /metrics", db.MetricsHandler())
+	metricsSrv := &http.Server{Addr: *metricsAddr, Handler: metricsMux}
+	adminSrv := &http.Server{Addr: *adminAddr, Handler: db.AdminHandler()}
+
+	if tlsConfig != nil {
+		srv.UseTLS(tlsConfig)
+		respSrv.UseTLS(tlsConfig)
+		memcacheSrv.UseTLS(tlsConfig)
+		replicationSrv.UseTLS(tlsConfig)
+		forwardingSrv.UseTLS(tlsConfig)
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		httpSrv.TLSConfig = tlsConfig
+		adminSrv.TLSConfig = tlsConfig
+	}
+	if *usersFile != "" {
+		// The memcached protocol has no way to authenticate, short of
+		// SASL over the binary protocol, which isn't served.
+		if *memcacheAddr != "" {
+			log.Fatal("-memcache can't be used with -users: memcache clients can't authenticate")
+		}
+		users, err := loadUsers(*usersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.RequireAuth(users)
+		respSrv.RequireAuth(users)
+		mvccSrv.RequireAuth(users)
+		api.RequireAuth(users)
+		replicationSrv.RequireAuth(users)
+		// Which the forwarding nodes authenticated already.
+		forwardingSrv.RequireAuth(users)
+		adminSrv.Handler = RequireAdmin(users, adminSrv.Handler)
+	}
+	if *grantsFile != "" {
+		grants, err := loadGrants(*grantsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := db.applyGrants(grants); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	grpcSrv := grpc.NewServer(grpcOpts...)
+	mvccSrv.Register(grpcSrv)
+
+	if *addr != "" {
+		go func() {
+			log.Printf("serving the line protocol on %s", *addr)
+			if err := srv.ListenAndServe(*addr); !errors.Is(err, ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *socket != "" {
+		go func() {
+			log.Printf("serving the line protocol on %s", *socket)
+			if err := srv.ListenAndServeUnix(*socket, os.FileMode(*socketMode)); !errors.Is(err, ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *respAddr != "" {
+		go func() {
+			log.Printf("serving the Redis protocol on %s", *respAddr)
+			if err := respSrv.ListenAndServe(*respAddr); !errors.Is(err, ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *memcacheAddr != "" {
+		go func() {
+			log.Printf("serving the memcached protocol on %s", *memcacheAddr)
+			if err := memcacheSrv.ListenAndServe(*memcacheAddr); !errors.Is(err, ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *replicationAddr != "" {
+		go func() {
+			log.Printf("shipping the write-ahead log on %s", *replicationAddr)
+			if err := replicationSrv.ListenAndServe(*replicationAddr); !errors.Is(err, ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *raftID != "" {
+		go func() {
+			log.Printf("serving forwarded commands on %s", *forwardAddr)
+			if err := forwardingSrv.ListenAndServe(*forwardAddr); !errors.Is(err, ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *grpcAddr != "" {
+		go func() {
+			ln, err := net.Listen("tcp", *grpcAddr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("serving gRPC on %s", *grpcAddr)
+			if err := grpcSrv.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *httpAddr != "" {
+		go func() {
+			log.Printf("serving HTTP on %s", *httpAddr)
+			var err error
+			if httpSrv.TLSConfig != nil {
+				err = httpSrv.ListenAndServeTLS("", "")
+			} else {
+				err = httpSrv.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if *metricsAddr != "" {
+		go func() {
+			log.Printf("serving metrics on %s", *metricsAddr)
+			if err := metricsSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if *adminAddr != "" {
+		go func() {
+			log.Printf("serving the admin UI on %s", *adminAddr)
+			var err error
+			if adminSrv.TLSConfig != nil {
+				err = adminSrv.ListenAndServeTLS("", "")
+			} else {
+				err = adminSrv.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	// A second signal kills the process outright.
+	stop()
+
+	// Let the transactions in progress finish, over the connections they
+	// are running on, before closing those.
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := db.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+
+	srv.Close()
+	respSrv.Close()
+	memcacheSrv.Close()
+	replicationSrv.Close()
+	forwardingSrv.Close()
+	grpcSrv.Stop()
+	mvccSrv.Close()
+	httpSrv.Close()
+	api.Close()
+	metricsSrv.Close()
+	adminSrv.Close()
 }
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestReadUncommited(t *testing.T) {
@@ -28,11 +33,409 @@ func TestReadUncommited(t *testing.T) {
 	res = c1.mustExecCommand("delete", []string{"x"})
 	assertEq(res, "", "c1 delete x")
 
-	res, err := c1.execCommand("get", []string{"x"})
-	assertEq(res, "", "c1 sees no x")
+	result, err := c1.execCommand("get", []string{"x"})
+	assertEq(result.Value, "", "c1 sees no x")
 	assertEq(err.Error(), "cannot get key that does not exist", "c1 sees no x")
 
-	res, err := c2.execCommand("get", []string{"x"})
-	assertEq(res, "", "c2 sees no x")
+	result, err = c2.execCommand("get", []string{"x"})
+	assertEq(result.Value, "", "c2 sees no x")
 	assertEq(err.Error(), "cannot get key that does not exist", "c2 sees no x")
 }
+
+func TestReadCommitted(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = ReadCommitedIsolation
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+
+	// Local change is visible locally.
+	c1.mustExecCommand("set", []string{"x", "hey"})
+
+	res := c1.mustExecCommand("get", []string{"x"})
+	assertEq(res, "hey", "c1 get x")
+
+	// Update not available to this transaction since this is not
+	// committed.
+	result, err := c2.execCommand("get", []string{"x"})
+	assertEq(result.Value, "", "c2 get x")
+	assertEq(err.Error(), "cannot get key that does not exist", "c2 get x")
+
+	c1.mustExecCommand("commit", nil)
+
+	// Now that it's been committed, it's visible in c2.
+	res = c2.mustExecCommand("get", []string{"x"})
+	assertEq(res, "hey", "c2 get x")
+
+	c3 := database.newConnection()
+	c3.mustExecCommand("begin", nil)
+
+	// Local change is visible locally.
+	c3.mustExecCommand("set", []string{"x", "yall"})
+
+	res = c3.mustExecCommand("get", []string{"x"})
+	assertEq(res, "yall", "c3 get x")
+
+	// But not on the other commit, again.
+	res = c2.mustExecCommand("get", []string{"x"})
+	assertEq(res, "hey", "c2 get x")
+
+	c3.mustExecCommand("abort", nil)
+
+	// And still not, if the other transaction aborted.
+	res = c2.mustExecCommand("get", []string{"x"})
+	assertEq(res, "hey", "c2 get x")
+
+	// And if we delete it, it should show up deleted locally.
+	c2.mustExecCommand("delete", []string{"x"})
+
+	result, err = c2.execCommand("get", []string{"x"})
+	assertEq(result.Value, "", "c2 get x")
+	assertEq(err.Error(), "cannot get key that does not exist", "c2 get x")
+
+	c2.mustExecCommand("commit", nil)
+
+	// It should also show up as deleted in new transactions now
+	// that it has been committed.
+	c4 := database.newConnection()
+	c4.mustExecCommand("begin", nil)
+
+	result, err = c4.execCommand("get", []string{"x"})
+	assertEq(result.Value, "", "c4 get x")
+	assertEq(err.Error(), "cannot get key that does not exist", "c4 get x")
+}
+
+func TestRepeatableRead(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = RepeatableReadIsolation
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+
+	// Local change is visible locally.
+	c1.mustExecCommand("set", []string{"x", "hey"})
+	res := c1.mustExecCommand("get", []string{"x"})
+	assertEq(res, "hey", "c1 get x")
+
+	// Update not available to this transaction since this is not
+	// committed.
+	result, err := c2.execCommand("get", []string{"x"})
+	assertEq(result.Value, "", "c2 get x")
+	assertEq(err.Error(), "cannot get key that does not exist", "c2 get x")
+
+	c1.mustExecCommand("commit", nil)
+
+	// Even after committing, it's not visible in an existing
+	// transaction.
+	result, err = c2.execCommand("get", []string{"x"})
+	assertEq(result.Value, "", "c2 get x")
+	assertEq(err.Error(), "cannot get key that does not exist", "c2 get x")
+
+	// But is available in a new transaction.
+	c3 := database.newConnection()
+	c3.mustExecCommand("begin", nil)
+
+	res = c3.mustExecCommand("get", []string{"x"})
+	assertEq(res, "hey", "c3 get x")
+
+	// Local change is visible locally.
+	c3.mustExecCommand("set", []string{"x", "yall"})
+	res = c3.mustExecCommand("get", []string{"x"})
+	assertEq(res, "yall", "c3 get x")
+
+	// But not on the other commit, again.
+	result, err = c2.execCommand("get", []string{"x"})
+	assertEq(result.Value, "", "c2 get x")
+	assertEq(err.Error(), "cannot get key that does not exist", "c2 get x")
+
+	c3.mustExecCommand("abort", nil)
+
+	// And still not, regardless of abort, because it's an older
+	// transaction.
+	result, err = c2.execCommand("get", []string{"x"})
+	assertEq(result.Value, "", "c2 get x")
+	assertEq(err.Error(), "cannot get key that does not exist", "c2 get x")
+
+	// And again still the aborted set is still not on a new
+	// transaction.
+	c4 := database.newConnection()
+	res = c4.mustExecCommand("begin", nil)
+
+	res = c4.mustExecCommand("get", []string{"x"})
+	assertEq(res, "hey", "c4 get x")
+
+	c4.mustExecCommand("delete", []string{"x"})
+	c4.mustExecCommand("commit", nil)
+
+	// But the delete is visible to new transactions now that this
+	// has been committed.
+	c5 := database.newConnection()
+	res = c5.mustExecCommand("begin", nil)
+
+	result, err = c5.execCommand("get", []string{"x"})
+	assertEq(result.Value, "", "c5 get x")
+	assertEq(err.Error(), "cannot get key that does not exist", "c5 get x")
+}
+
+func TestSnapshotIsolation_writewrite_conflict(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+
+	c3 := database.newConnection()
+	c3.mustExecCommand("begin", nil)
+
+	c1.mustExecCommand("set", []string{"x", "hey"})
+	c1.mustExecCommand("commit", nil)
+
+	c2.mustExecCommand("set", []string{"x", "hey"})
+
+	result, err := c2.execCommand("commit", nil)
+	assertEq(result.Value, "", "c2 commit")
+	assertEq(err.Error(), `write-write conflict with transaction 1 on "x"`, "c2 commit")
+
+	// But unrelated keys cause no conflict.
+	c3.mustExecCommand("set", []string{"y", "no conflict"})
+	c3.mustExecCommand("commit", nil)
+}
+
+func TestSerializableIsolation_readwrite_conflict(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SerializableIsolation
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+
+	c3 := database.newConnection()
+	c3.mustExecCommand("begin", nil)
+
+	c1.mustExecCommand("set", []string{"x", "hey"})
+	c1.mustExecCommand("commit", nil)
+
+	_, err := c2.execCommand("get", []string{"x"})
+	assertEq(err.Error(), "cannot get key that does not exist", "c2 get x")
+
+	result, err := c2.execCommand("commit", nil)
+	assertEq(result.Value, "", "c2 commit")
+	assertEq(err.Error(), `read-write conflict with transaction 1 on "x"`, "c2 commit")
+
+	// But unrelated keys cause no conflict.
+	c3.mustExecCommand("set", []string{"y", "no conflict"})
+	c3.mustExecCommand("commit", nil)
+}
+
+func TestConnectionClose(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "hey"})
+	assertEq(c1.Close(), nil, "c1 close")
+
+	// The open transaction was aborted, so its write is gone.
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+	_, err := c2.execCommand("get", []string{"x"})
+	assertEq(err.Error(), "cannot get key that does not exist", "c2 get x")
+
+	_, err = c1.execCommand("begin", nil)
+	assertEq(err, ErrConnectionClosed, "c1 begin after close")
+}
+
+func TestShutdown(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+
+	done := make(chan error)
+	go func() {
+		done <- database.Shutdown(context.Background())
+	}()
+
+	// Shutdown waits for c1, but new transactions are refused meanwhile.
+	c2 := database.newConnection()
+	for {
+		_, err := c2.execCommand("begin", nil)
+		if err != nil {
+			assertEq(err, ErrDatabaseShutdown, "c2 begin")
+			break
+		}
+		c2.mustExecCommand("abort", nil)
+	}
+
+	c1.mustExecCommand("set", []string{"x", "hey"})
+	c1.mustExecCommand("commit", nil)
+	assertEq(<-done, nil, "shutdown")
+}
+
+func TestShutdown_deadline(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "hey"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := database.Shutdown(ctx)
+	assertEq(err, context.DeadlineExceeded, "shutdown")
+
+	// c1 was force-aborted and finds out on its next statement.
+	_, err = c1.execCommand("get", []string{"x"})
+	assert(errors.Is(err, ErrTransactionAborted), "c1 aborted")
+	assertEq(c1.tx, nil, "c1 forgot transaction")
+}
+
+func TestShutdown_flushes(t *testing.T) {
+	syncs := &countedSyncs{}
+	database, err := NewDatabase(t.TempDir(), WithFaults(syncs))
+	assertEq(err, nil, "open")
+	defer database.Close()
+	assertEq(database.SetSyncMode(SyncNever, 0), nil, "sync mode")
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "hey"})
+	assertEq(syncs.count("wal"), 0, "commits don't sync")
+	assertEq(database.Shutdown(context.Background()), nil, "shutdown")
+	assertEq(syncs.count("wal"), 1, "shutdown syncs")
+}
+
+func TestResult(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	res, err := c1.execCommand("begin", nil)
+	assertEq(err, nil, "begin")
+	txId := res.TxId
+	assertEq(res.Value, fmt.Sprintf("%d", txId), "begin value")
+
+	res, err = c1.execCommand("set", []string{"x", ""})
+	assertEq(err, nil, "set x")
+	assertEq(res.Keys[0], "x", "set x keys")
+	assertEq(res.TxId, txId, "set x tx")
+
+	// An empty value is not the same as no value.
+	res, err = c1.execCommand("get", []string{"x"})
+	assertEq(err, nil, "get x")
+	assertEq(res.Value, "", "get x")
+	assertEq(res.NotFound, false, "get x found")
+
+	res, err = c1.execCommand("get", []string{"y"})
+	assert(errors.Is(err, ErrKeyNotFound), "get y error")
+	assertEq(res.NotFound, true, "get y not found")
+
+	res, err = c1.execCommand("commit", nil)
+	assertEq(err, nil, "commit")
+	assertEq(res.TxId, txId, "commit tx")
+}
+
+// longHistory writes key n times, one transaction after another.
+func longHistory(d *Database, key string, n int) {
+	for i := range n {
+		tx, _ := d.Begin()
+		tx.Set(key, strconv.Itoa(i))
+		tx.Commit()
+	}
+}
+
+func TestLongHistory(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	// A transaction that began early keeps the whole history needed.
+	early := database.newConnection()
+	early.mustExecCommand("begin", nil)
+	longHistory(&database, "x", 1000)
+	assertEq(len(database.versions("x")), 1000, "versions kept")
+
+	for _, level := range []IsolationLevel{ReadCommitedIsolation, SnapshotIsolation} {
+		tx, _ := database.beginAt(context.Background(), level)
+		v, _ := tx.Get("x")
+		assertEq(v, "999", "newest")
+		assertEq(tx.versionsScanned, 1, level.String()+" reads the newest only")
+		tx.Delete("x")
+		assertEq(tx.versionsScanned, 3, level.String()+" deletes the newest only")
+		tx.Abort()
+	}
+	_, err := early.execCommand("get", []string{"x"})
+	assertEq(err, ErrKeyNotFound, "early transaction reads past the history")
+
+	c := database.newConnection()
+	c.mustExecCommand("delete", []string{"x"})
+	c.mustExecCommand("begin", nil)
+	_, err = c.execCommand("get", []string{"x"})
+	assertEq(err, ErrKeyNotFound, "deleted")
+	assertEq(c.tx.versionsScanned, 1, "a deleted key's history isn't read")
+}
+
+func TestLongHistory_tangled(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = RepeatableReadIsolation
+	c1, c2, c3, reader := database.newConnection(), database.newConnection(), database.newConnection(), database.newConnection()
+	c1.mustExecCommand("set", []string{"x", "0"})
+
+	// c2's write races c3's, and c1 begins between their commits.
+	c2.mustExecCommand("begin", nil)
+	c3.mustExecCommand("begin", nil)
+	c2.mustExecCommand("set", []string{"x", "2"})
+	c3.mustExecCommand("set", []string{"x", "3"})
+	c3.mustExecCommand("commit", nil)
+	c1.mustExecCommand("begin", nil)
+	c2.mustExecCommand("commit", nil)
+
+	// A transaction that sees both reads the one written last, and keeps
+	// reading it once c1, which sees only that one, overwrites it.
+	reader.mustExecCommand("begin", nil)
+	assertEq(reader.mustExecCommand("get", []string{"x"}), "3", "newest written")
+	assertEq(c1.mustExecCommand("get", []string{"x"}), "3", "c2's not committed")
+	c1.mustExecCommand("set", []string{"x", "1"})
+	c1.mustExecCommand("commit", nil)
+	assert(database.tangled.Contains("x"), "tangled")
+	assertEq(reader.mustExecCommand("get", []string{"x"}), "3", "repeatable")
+	reader.mustExecCommand("commit", nil)
+
+	// Neither version is visible to what begins now, and a vacuum
+	// finds the chain in order again.
+	assertEq(c1.mustExecCommand("get", []string{"x"}), "1", "committed last")
+	c1.mustExecCommand("delete", []string{"x"})
+	database.Vacuum()
+	assert(!database.tangled.Contains("x"), "untangled")
+}
+
+func BenchmarkLongHistory(b *testing.B) {
+	for _, level := range []IsolationLevel{ReadCommitedIsolation, SnapshotIsolation} {
+		database := newDatabase()
+		database.defaultIsolation = level
+		longHistory(&database, "hot", 10000)
+
+		b.Run(level.String()+"/get", func(b *testing.B) {
+			for b.Loop() {
+				tx, _ := database.Begin()
+				tx.Get("hot")
+				tx.Commit()
+			}
+		})
+		b.Run(level.String()+"/set", func(b *testing.B) {
+			for b.Loop() {
+				tx, _ := database.Begin()
+				tx.Set("hot", "x")
+				tx.Commit()
+			}
+		})
+	}
+}
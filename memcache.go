@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+A memcached text protocol frontend, so the database can sit behind
+existing memcached clients as a cache that remembers: every store is a new
+version, and "history key" on the line protocol still shows the old ones.
+
+It covers get, gets, set, add, replace, append, prepend, cas, delete,
+incr, decr, touch, version and quit, each run in a transaction of its
+own. An item's cas unique is its version (see cas.go).
+
+Items carry no flags. A store with non-zero flags is refused rather than
+have the flags silently dropped, and items come back with flags 0. Expiry
+times follow memcached: zero never expires, up to 30 days is relative,
+anything longer is a unix time, and anything negative expires at once.
+
+Clients don't authenticate: the text protocol has no way to, and SASL
+needs the binary protocol. So anyone who can reach the listener can read
+and write every key, and the server refuses to serve memcache at all when
+-users asks for authentication.
+*/
+
+// NewMemcacheServer returns a server speaking the memcached text protocol.
+func NewMemcacheServer(db *Database) *Server {
+	return newServer(db, serveMemcache)
+}
+
+const (
+	memcacheMaxKey   = 250
+	memcacheMaxValue = 1 << 20
+	// Expiry times beyond this many seconds are unix times.
+	memcacheMaxRelative = 30 * 24 * 60 * 60
+)
+
+// A memcacheReply ends a command early with the reply it gives, aborting
+// whatever the command had done.
+type memcacheReply string
+
+func (r memcacheReply) Error() string { return string(r) }
+
+const (
+	memcacheBadFormat  memcacheReply = "CLIENT_ERROR bad command line format"
+	memcacheNotFound   memcacheReply = "NOT_FOUND"
+	memcacheNotStored  memcacheReply = "NOT_STORED"
+	memcacheNonNumeric memcacheReply = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+)
+
+type memcacheHandler func(c *Connection, r *bufio.Reader, args []string) string
+
+var memcacheCommands = map[string]memcacheHandler{
+	"get":     memcacheGet(false),
+	"gets":    memcacheGet(true),
+	"set":     memcacheStore("set"),
+	"add":     memcacheStore("add"),
+	"replace": memcacheStore("replace"),
+	"append":  memcacheStore("append"),
+	"prepend": memcacheStore("prepend"),
+	"cas":     memcacheStore("cas"),
+	"delete":  memcacheDelete,
+	"incr":    memcacheIncr,
+	"decr":    memcacheIncr,
+	"touch":   memcacheTouch,
+	"version": func(*Connection, *bufio.Reader, []string) string { return "VERSION mvcc" },
+}
+
+func serveMemcache(c *Connection, r *bufio.Reader, w *bufio.Writer) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "quit" {
+			return
+		}
+
+		reply := "ERROR"
+		if len(fields) > 0 {
+			if handler, ok := memcacheCommands[fields[0]]; ok {
+				args := fields[1:]
+				noreply := len(args) > 0 && args[len(args)-1] == "noreply"
+				if noreply {
+					args = args[:len(args)-1]
+				}
+				reply = handler(c, r, append([]string{fields[0]}, args...))
+				if noreply {
+					reply = ""
+				}
+			}
+		}
+		if reply != "" {
+			w.WriteString(reply)
+			w.WriteString("\r\n")
+		}
+
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// memcacheOutcome turns what a command's transaction returned into its
+// reply.
+func memcacheOutcome(reply string, err error) string {
+	var early memcacheReply
+	switch {
+	case errors.As(err, &early):
+		return string(early)
+	case errors.Is(err, ErrKeyNotFound):
+		return string(memcacheNotFound)
+	case errors.Is(err, ErrAuthRequired), errors.Is(err, ErrPermissionDenied):
+		return "CLIENT_ERROR " + err.Error()
+	case err != nil:
+		return "SERVER_ERROR " + strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+	}
+	return reply
+}
+
+func validMemcacheKey(key string) bool {
+	if len(key) > memcacheMaxKey {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// memcacheSeconds converts a memcached expiry time into the seconds
+// argument of expire, whose non-positive values expire at once.
+func (c *Connection) memcacheSeconds(exptime int64) string {
+	if exptime > memcacheMaxRelative {
+		exptime -= c.db.clock().Unix()
+		if exptime == 0 {
+			exptime = -1
+		}
+	}
+	return strconv.FormatInt(exptime, 10)
+}
+
+// get key...
+// gets key...
+func memcacheGet(withCas bool) memcacheHandler {
+	return func(c *Connection, r *bufio.Reader, args []string) string {
+		keys := args[1:]
+		if len(keys) == 0 {
+			return "ERROR"
+		}
+		for _, key := range keys {
+			if !validMemcacheKey(key) {
+				return string(memcacheBadFormat)
+			}
+		}
+
+		var reply strings.Builder
+		err := c.atomically(func() error {
+			for _, key := range keys {
+				res, err := c.execCommand("gets", []string{key})
+				if res.NotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(&reply, "VALUE %s 0 %d", key, len(res.Value))
+				if withCas {
+					fmt.Fprintf(&reply, " %d", res.Version)
+				}
+				fmt.Fprintf(&reply, "\r\n%s\r\n", res.Value)
+			}
+			return nil
+		})
+		if err != nil {
+			return memcacheOutcome("", err)
+		}
+		reply.WriteString("END")
+		return reply.String()
+	}
+}
+
+// set key flags exptime bytes
+// cas key flags exptime bytes unique
+//
+// and likewise add, replace, append and prepend, each followed by a line
+// of data.
+func memcacheStore(command string) memcacheHandler {
+	return func(c *Connection, r *bufio.Reader, args []string) string {
+		want := 5
+		if command == "cas" {
+			want = 6
+		}
+		if len(args) != want {
+			return "ERROR"
+		}
+		size, err := strconv.Atoi(args[4])
+		if err != nil || size < 0 {
+			return string(memcacheBadFormat)
+		}
+		if size > memcacheMaxValue {
+			io.CopyN(io.Discard, r, int64(size)+2)
+			return "SERVER_ERROR object too large for cache"
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil || string(data[size:]) != "\r\n" {
+			// Skip the rest of the line, so as not to take it for a
+			// command.
+			if err == nil && data[size+1] != '\n' {
+				r.ReadString('\n')
+			}
+			return "CLIENT_ERROR bad data chunk"
+		}
+		value := string(data[:size])
+
+		key := args[1]
+		flags, err := strconv.ParseUint(args[2], 10, 32)
+		if err != nil || !validMemcacheKey(key) {
+			return string(memcacheBadFormat)
+		}
+		if flags != 0 {
+			return "CLIENT_ERROR flags are not supported"
+		}
+		exptime, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return string(memcacheBadFormat)
+		}
+
+		err = c.atomically(func() error {
+			switch command {
+			case "add":
+				res, err := c.execCommand("setnx", []string{key, value})
+				if err != nil {
+					return err
+				}
+				if res.Value == "0" {
+					return memcacheNotStored
+				}
+			case "cas":
+				res, err := c.execCommand("cas", []string{key, value, args[5]})
+				if err != nil {
+					return err
+				}
+				if res.Value == "0" {
+					return memcacheReply("EXISTS")
+				}
+			case "set", "replace", "append", "prepend":
+				if command != "set" {
+					res, err := c.execCommand("get", []string{key})
+					if res.NotFound {
+						return memcacheNotStored
+					}
+					if err != nil {
+						return err
+					}
+					if command == "append" {
+						value = res.Value + value
+					} else if command == "prepend" {
+						value = value + res.Value
+					}
+				}
+				if _, err := c.execCommand("set", []string{key, value}); err != nil {
+					return err
+				}
+			}
+
+			// Appending and prepending leave the expiry time alone, in
+			// memcached; here the write cleared it, as any write does.
+			if exptime != 0 && command != "append" && command != "prepend" {
+				_, err := c.execCommand("expire", []string{key, c.memcacheSeconds(exptime)})
+				return err
+			}
+			return nil
+		})
+		return memcacheOutcome("STORED", err)
+	}
+}
+
+// delete key
+func memcacheDelete(c *Connection, r *bufio.Reader, args []string) string {
+	if len(args) != 2 {
+		return "ERROR"
+	}
+	if !validMemcacheKey(args[1]) {
+		return string(memcacheBadFormat)
+	}
+	_, err := c.execCommand("getdel", args[1:])
+	return memcacheOutcome("DELETED", err)
+}
+
+// incr key delta
+// decr key delta
+//
+// Values are unsigned 64-bit integers. Incrementing wraps around, and
+// decrementing stops at zero.
+func memcacheIncr(c *Connection, r *bufio.Reader, args []string) string {
+	if len(args) != 3 {
+		return "ERROR"
+	}
+	if !validMemcacheKey(args[1]) {
+		return string(memcacheBadFormat)
+	}
+	delta, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR invalid numeric delta argument"
+	}
+
+	var n uint64
+	err = c.atomically(func() error {
+		res, err := c.execCommand("get", args[1:2])
+		if err != nil {
+			return err
+		}
+		if n, err = strconv.ParseUint(res.Value, 10, 64); err != nil {
+			return memcacheNonNumeric
+		}
+		switch {
+		case args[0] == "incr":
+			n += delta
+		case delta > n:
+			n = 0
+		default:
+			n -= delta
+		}
+		_, err = c.execCommand("set", []string{args[1], strconv.FormatUint(n, 10)})
+		return err
+	})
+	return memcacheOutcome(strconv.FormatUint(n, 10), err)
+}
+
+// touch key exptime
+func memcacheTouch(c *Connection, r *bufio.Reader, args []string) string {
+	if len(args) != 3 {
+		return "ERROR"
+	}
+	exptime, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || !validMemcacheKey(args[1]) {
+		return string(memcacheBadFormat)
+	}
+
+	err = c.atomically(func() error {
+		if exptime == 0 {
+			// Rewriting the value is the only way to stop it expiring.
+			res, err := c.execCommand("get", args[1:2])
+			if err != nil {
+				return err
+			}
+			_, err = c.execCommand("set", []string{args[1], res.Value})
+			return err
+		}
+		res, err := c.execCommand("expire", []string{args[1], c.memcacheSeconds(exptime)})
+		if err == nil && res.Value == "0" {
+			return memcacheNotFound
+		}
+		return err
+	})
+	return memcacheOutcome("TOUCHED", err)
+}
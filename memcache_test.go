@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memcacheCall sends line and returns the reply, up to and including END
+// for retrievals, with CRLFs turned into spaces.
+func memcacheCall(c *testClient, line string) string {
+	fmt.Fprintf(c.conn, "%s\r\n", line)
+	var reply []string
+	for {
+		l := strings.TrimSuffix(c.response(), "\r")
+		reply = append(reply, l)
+		if !strings.HasPrefix(l, "VALUE ") {
+			return strings.Join(reply, " ")
+		}
+		reply = append(reply, strings.TrimSuffix(c.response(), "\r"))
+	}
+}
+
+func TestMemcache(t *testing.T) {
+	database := newDatabase()
+	now := time.Unix(time.Now().Unix(), 0)
+	database.now = func() time.Time { return now }
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assertEq(err, nil, "listen")
+	srv := NewMemcacheServer(&database)
+	go srv.Serve(ln)
+	defer srv.Close()
+	c := dial(ln.Addr().String())
+	local := database.newConnection()
+
+	assertEq(memcacheCall(c, "set a 0 0 5\r\nhello"), "STORED", "set")
+	assertEq(memcacheCall(c, "get a b"), "VALUE a 0 5 hello END", "get")
+	assertEq(memcacheCall(c, "add a 0 0 1\r\nx"), "NOT_STORED", "add existing")
+	assertEq(memcacheCall(c, "replace b 0 0 1\r\nx"), "NOT_STORED", "replace missing")
+	assertEq(memcacheCall(c, "append a 0 0 1\r\n!"), "STORED", "append")
+	assertEq(memcacheCall(c, "prepend a 0 0 1\r\n>"), "STORED", "prepend")
+	assertEq(memcacheCall(c, "get a"), "VALUE a 0 7 >hello! END", "appended")
+
+	gets := strings.Fields(memcacheCall(c, "gets a"))
+	unique := gets[4]
+	assertEq(memcacheCall(c, "cas a 0 0 1 "+unique+"\r\nz"), "STORED", "cas")
+	assertEq(memcacheCall(c, "cas a 0 0 1 "+unique+"\r\ny"), "EXISTS", "stale cas")
+	assertEq(memcacheCall(c, "cas b 0 0 1 1\r\ny"), "NOT_FOUND", "cas missing")
+
+	assertEq(memcacheCall(c, "set n 0 0 1\r\n9"), "STORED", "set counter")
+	assertEq(memcacheCall(c, "incr n 3"), "12", "incr")
+	assertEq(memcacheCall(c, "decr n 20"), "0", "decr stops at zero")
+	assertEq(memcacheCall(c, "incr a 1"), "CLIENT_ERROR cannot increment or decrement non-numeric value", "incr text")
+	assertEq(memcacheCall(c, "incr b 1"), "NOT_FOUND", "incr missing")
+
+	assertEq(memcacheCall(c, "set e 0 100 1\r\nx"), "STORED", "set with expiry")
+	assertEq(local.mustExecCommand("ttl", []string{"e"}), "100", "relative expiry")
+	assertEq(memcacheCall(c, fmt.Sprintf("touch e %d", now.Unix()+50)), "TOUCHED", "touch with a unix time")
+	assertEq(local.mustExecCommand("ttl", []string{"e"}), "50", "absolute expiry")
+	assertEq(memcacheCall(c, "touch e 0"), "TOUCHED", "touch forever")
+	assertEq(local.mustExecCommand("ttl", []string{"e"}), "-1", "no expiry")
+	assertEq(memcacheCall(c, "set e 0 -1 1\r\nx"), "STORED", "set already expired")
+	assertEq(memcacheCall(c, "get e"), "END", "expired")
+
+	assertEq(memcacheCall(c, "delete a"), "DELETED", "delete")
+	assertEq(memcacheCall(c, "delete a"), "NOT_FOUND", "delete missing")
+
+	assertEq(memcacheCall(c, "set f 1 0 1\r\nx"), "CLIENT_ERROR flags are not supported", "flags")
+	assertEq(memcacheCall(c, "set g 0 0 1\r\nxy"), "CLIENT_ERROR bad data chunk", "long data")
+	assertEq(memcacheCall(c, "flush_all"), "ERROR", "unknown command")
+
+	// noreply suppresses the reply; the next one is the version's.
+	fmt.Fprintf(c.conn, "set q 0 0 1 noreply\r\nx\r\n")
+	assertEq(memcacheCall(c, "version"), "VERSION mvcc", "noreply")
+	assertEq(memcacheCall(c, "get q"), "VALUE q 0 1 x END", "stored without reply")
+}
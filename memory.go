@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+/*
+Everything the database holds is in memory, and nothing stops a hot key's
+history or a long-running transaction's snapshot from growing until the
+host kills the process. So the database keeps a running count of the
+bytes its versions hold, adding them as versions are written and taking
+them away as vacuum or a rollback removes them, and works out what its
+transactions hold from the registry when asked. The numbers are
+estimates, of the structures' sizes plus the strings they own, not of
+what the allocator has handed out.
+
+With a budget set, a transaction that wrote new versions can't commit
+while the database holds more than the budget. Before refusing it, the
+database vacuums aggressively, ignoring the retention policy, so history
+kept for time-travel reads goes before writes do. If that frees too
+little, the commit fails with ErrOutOfMemoryBudget. Transactions that only
+delete still commit, since that is how room is made. A prepared
+transaction is checked when it prepares, as it was promised its commit.
+*/
+
+var ErrOutOfMemoryBudget = errors.New("out of memory budget")
+
+// WithMaxMemory sets the database's memory budget, in bytes.
+func WithMaxMemory(bytes int64) Option {
+	return func(d *Database) {
+		d.maxMemory = bytes
+	}
+}
+
+const (
+	versionOverhead     = int64(unsafe.Sizeof(Value{}))
+	transactionOverhead = int64(unsafe.Sizeof(Transaction{}))
+	// A key's node in the store, roughly, and its versions' slice header.
+	keyOverhead = 64
+	// A key in a read or write set, whose bytes the store already owns.
+	setEntryOverhead = int64(unsafe.Sizeof(""))
+)
+
+func versionSize(v *Value) int64 {
+	return versionOverhead + int64(len(v.data.blob))
+}
+
+func keySize(key string) int64 {
+	return keyOverhead + int64(len(key))
+}
+
+// appendVersion adds v to the versions of key, counting its bytes
+// against t.
+func (t *Transaction) appendVersion(key string, versions []Value, v Value) {
+	t.db.store.Set(key, append(versions, v))
+	if len(versions) == 0 {
+		t.db.versionBytes += keySize(key)
+		t.db.keyAdded(key)
+	}
+	size := versionSize(&v)
+	t.db.versionBytes += size
+	t.added += size
+}
+
+// transactionBytes estimates what the registry and the read and write
+// sets of transactions in progress hold.
+func (d *Database) transactionBytes() int64 {
+	n := int64(d.transactions.Len()) * transactionOverhead
+	iter := d.running.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		t, _ := d.transactions.Get(iter.Key())
+		n += int64(t.readset.Len()+t.writeset.Len()) * setEntryOverhead
+	}
+	return n
+}
+
+func (d *Database) memoryUsage() int64 {
+	return d.versionBytes + d.transactionBytes()
+}
+
+// checkMemoryBudget returns ErrOutOfMemoryBudget if t added versions and
+// the database is over its budget even after an aggressive vacuum.
+func (d *Database) checkMemoryBudget(t *Transaction) error {
+	if d.maxMemory <= 0 || t.added == 0 || d.memoryUsage() <= d.maxMemory {
+		return nil
+	}
+
+	p := &vacuumPass{horizon: d.horizon(), aggressive: true}
+	d.vacuumStep(p, 0)
+	d.finishVacuum(p)
+	used := d.memoryUsage()
+	d.logger.Warn("over memory budget, vacuumed aggressively", "versions", p.removed, "bytes", used, "budget", d.maxMemory)
+	if used > d.maxMemory {
+		return fmt.Errorf("%w: %d bytes in use, of %d", ErrOutOfMemoryBudget, used, d.maxMemory)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// countedBytes works out what versionBytes should be from the store.
+func countedBytes(d *Database) int64 {
+	var n int64
+	iter := d.store.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		n += keySize(iter.Key())
+		for i := range iter.Value() {
+			n += versionSize(&iter.Value()[i])
+		}
+	}
+	return n
+}
+
+func TestMemoryAccounting(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	big := strings.Repeat("x", 1000)
+	c.mustExecCommand("set", []string{"a", "1"})
+	c.mustExecCommand("set", []string{"b", big})
+	c.mustExecCommand("set", []string{"b", big + big})
+	assertEq(database.versionBytes, countedBytes(&database), "written")
+	assert(database.versionBytes > 3000, "counts the values")
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"c", big})
+	c.mustExecCommand("savepoint", []string{"s"})
+	c.mustExecCommand("set", []string{"c", "2"})
+	c.mustExecCommand("rollback", []string{"to", "s"})
+	assertEq(database.versionBytes, countedBytes(&database), "rolled back to a savepoint")
+	c.mustExecCommand("abort", nil)
+
+	c.mustExecCommand("delete", []string{"a"})
+	database.Vacuum()
+	assertEq(database.versionBytes, countedBytes(&database), "vacuumed")
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("get", []string{"b"})
+	s, err := database.Stats()
+	assertEq(err, nil, "stats")
+	assertEq(s.VersionBytes, database.versionBytes, "version bytes")
+	assertEq(s.TransactionBytes, transactionOverhead+setEntryOverhead, "a transaction that read a key")
+}
+
+func TestMemoryAccounting_recovery(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"a", strings.Repeat("x", 100)})
+	assertEq(database.Checkpoint(), nil, "checkpoint")
+	c.mustExecCommand("set", []string{"b", "after"})
+	want := database.versionBytes
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	assertEq(database.versionBytes, want, "recovered")
+	assertEq(database.versionBytes, countedBytes(database), "counted")
+}
+
+func TestMaxMemory(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	c := database.newConnection()
+	big := strings.Repeat("x", 1000)
+	c.mustExecCommand("set", []string{"a", big})
+	c.mustExecCommand("set", []string{"small", "1"})
+	reader := database.newConnection()
+	reader.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"a", big})
+	// Room for one more version of a, not two.
+	database.apply(WithMaxMemory(database.memoryUsage() + 2000))
+	c.mustExecCommand("set", []string{"a", big})
+
+	// The reader's snapshot keeps the oldest version, and the newest is
+	// only replaced once the write commits.
+	_, err := c.execCommand("set", []string{"a", big})
+	assert(errors.Is(err, ErrOutOfMemoryBudget), "over budget")
+	assertEq(reader.mustExecCommand("get", []string{"a"}), big, "the snapshot's version stays")
+	c.mustExecCommand("delete", []string{"small"})
+
+	// Once the snapshot is gone, vacuum makes room.
+	reader.mustExecCommand("abort", nil)
+	c.mustExecCommand("set", []string{"a", big})
+	assertEq(len(database.versions("a")), 2, "vacuumed")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/*
+The engine keeps Prometheus metrics on itself from the start: transactions
+begun, committed and aborted at each isolation level, commits refused
+because of a conflict, versions reclaimed by vacuum, and how long gets and
+sets take, and how gets fared against the bloom filter, if there is one
+(see bloom.go). Counting is a handful of atomic adds, so it is always on.
+
+What can be read off the database's state is computed when scraped
+instead: how many transactions are in progress, and a histogram of how
+many versions each key has, which walks the whole store under the lock.
+
+MetricsHandler serves them all. Like the admin UI, it is just an
+http.Handler, for whatever listener should expose /metrics.
+*/
+
+type metrics struct {
+	begun     *prometheus.CounterVec
+	committed *prometheus.CounterVec
+	aborted   *prometheus.CounterVec
+	conflicts *prometheus.CounterVec
+	vacuumed  prometheus.Counter
+	latency   *prometheus.HistogramVec
+
+	bloom                                         *prometheus.CounterVec
+	bloomAbsent, bloomPresent, bloomFalsePositive prometheus.Counter
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		begun: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mvcc_transactions_begun_total",
+			Help: "Transactions begun, by isolation level.",
+		}, []string{"isolation"}),
+		committed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mvcc_transactions_committed_total",
+			Help: "Transactions committed, by isolation level.",
+		}, []string{"isolation"}),
+		aborted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mvcc_transactions_aborted_total",
+			Help: "Transactions aborted for whatever reason, by isolation level.",
+		}, []string{"isolation"}),
+		conflicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mvcc_conflicts_total",
+			Help: "Commits refused because of a conflict, by kind.",
+		}, []string{"kind"}),
+		vacuumed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mvcc_vacuum_reclaimed_versions_total",
+			Help: "Dead versions removed by vacuum.",
+		}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "mvcc_operation_duration_seconds",
+			Help:    "How long gets and sets take, waiting for the lock included.",
+			Buckets: prometheus.ExponentialBuckets(1e-6, 4, 10),
+		}, []string{"op"}),
+		bloom: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mvcc_bloom_lookups_total",
+			Help: "Gets checked against the bloom filter, by whether the key was absent, present, or a false positive.",
+		}, []string{"result"}),
+	}
+	m.bloomAbsent = m.bloom.WithLabelValues("absent")
+	m.bloomPresent = m.bloom.WithLabelValues("present")
+	m.bloomFalsePositive = m.bloom.WithLabelValues("false_positive")
+	return m
+}
+
+// observe records how long an operation that started at start took.
+func (m *metrics) observe(op string, start time.Time) {
+	m.latency.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+var versionsPerKeyDesc = prometheus.NewDesc(
+	"mvcc_key_versions",
+	"Versions kept per key, vacuumable ones included.",
+	nil, nil,
+)
+
+// versionsCollector computes the versions-per-key histogram when scraped.
+type versionsCollector struct{ db *Database }
+
+func (c versionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- versionsPerKeyDesc
+}
+
+func (c versionsCollector) Collect(ch chan<- prometheus.Metric) {
+	bounds := []float64{1, 2, 4, 8, 16, 32, 64, 128}
+	buckets := make(map[float64]uint64, len(bounds))
+	var count uint64
+	var sum float64
+
+	c.db.mu.Lock()
+	iter := c.db.store.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		n := float64(len(iter.Value()))
+		count++
+		sum += n
+		for _, bound := range bounds {
+			if n <= bound {
+				buckets[bound]++
+			}
+		}
+	}
+	c.db.mu.Unlock()
+
+	ch <- prometheus.MustNewConstHistogram(versionsPerKeyDesc, count, sum, buckets)
+}
+
+// MetricsHandler serves the database's metrics in the Prometheus text
+// format.
+func (d *Database) MetricsHandler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		d.metrics.begun,
+		d.metrics.committed,
+		d.metrics.aborted,
+		d.metrics.conflicts,
+		d.metrics.vacuumed,
+		d.metrics.latency,
+		d.metrics.bloom,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "mvcc_active_transactions",
+			Help: "Transactions in progress.",
+		}, func() float64 {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			return float64(d.running.Len())
+		}),
+		versionsCollector{d},
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	database.Set("a", "1")
+	database.Set("a", "2")
+	database.Set("b", "1")
+
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	t1.Set("a", "3")
+	t2.Set("a", "4")
+	assertEq(t1.Commit(), nil, "first commit")
+	assert(t2.Commit() != nil, "conflict")
+
+	t3, _ := database.Begin()
+	t3.Get("a")
+	// t2 aborting hands the end mark it set on a=2 back to t1, so both
+	// old versions of a go, along with t2's.
+	assertEq(database.Vacuum(), 3, "vacuumed")
+
+	rec := httptest.NewRecorder()
+	database.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assertEq(rec.Code, 200, "status")
+	body := rec.Body.String()
+	for _, line := range []string{
+		`mvcc_transactions_begun_total{isolation="snapshot"} 6`,
+		`mvcc_transactions_committed_total{isolation="snapshot"} 4`,
+		`mvcc_transactions_aborted_total{isolation="snapshot"} 1`,
+		`mvcc_conflicts_total{kind="write-write"} 1`,
+		`mvcc_active_transactions 1`,
+		`mvcc_vacuum_reclaimed_versions_total 3`,
+		`mvcc_key_versions_bucket{le="1"} 2`,
+		`mvcc_key_versions_bucket{le="2"} 2`,
+		`mvcc_key_versions_count 2`,
+		`mvcc_operation_duration_seconds_count{op="get"} 1`,
+		`mvcc_operation_duration_seconds_count{op="set"} 5`,
+	} {
+		assert(strings.Contains(body, line+"\n"), "metrics include "+line)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+mget reads many keys in one call. All of them are read under a single
+acquisition of the database lock, so even at Read Committed (where
+separate gets could straddle another transaction's commit) the values
+returned all come from the same moment.
+*/
+
+// Lookup is the outcome of reading one key of an MGet.
+type Lookup struct {
+	Key   string
+	Value string
+	Found bool
+}
+
+// MGet reads every key in keys, reporting for each whether it had a
+// visible value.
+func (t *Transaction) MGet(keys ...string) ([]Lookup, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return nil, err
+	}
+
+	lookups := make([]Lookup, len(keys))
+	for i, key := range keys {
+		lookups[i].Key = key
+		value, err := t.get(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if lookups[i].Value, err = t.db.read(value); err != nil {
+			return nil, err
+		}
+		lookups[i].Found = true
+	}
+	return lookups, nil
+}
+
+// mget key...
+//
+// Found keys render as "key=value" lines and missing ones as a bare
+// "key" line.
+func (c *Connection) mget(args []string) (Result, error) {
+	lookups, err := c.tx.MGet(args...)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Keys: args, TxId: c.tx.id, Lookups: lookups}
+	lines := make([]string, len(lookups))
+	for i, l := range lookups {
+		if l.Found {
+			lines[i] = l.Key + "=" + l.Value
+		} else {
+			lines[i] = l.Key
+		}
+	}
+	res.Value = strings.Join(lines, "\n")
+	return res, nil
+}
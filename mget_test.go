@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestMGet(t *testing.T) {
+	database := newDatabase()
+	database.Set("a", "1")
+	database.Set("b", "")
+
+	c := database.newConnection()
+	c.mustExecCommand("begin", nil)
+	res, err := c.execCommand("mget", []string{"a", "missing", "b"})
+	assertEq(err, nil, "mget")
+	assertEq(res.Value, "a=1\nmissing\nb=", "mget")
+	assertEq(res.Lookups[1], Lookup{Key: "missing"}, "missing key")
+	assertEq(res.Lookups[2], Lookup{Key: "b", Found: true}, "empty value")
+
+	// Every key read lands in the readset.
+	assertEq(c.tx.readset.Len(), 3, "readset")
+}
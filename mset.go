@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+/*
+mset and mdel write many keys in one statement. Each is all-or-nothing:
+everything is checked before anything is written, under one acquisition
+of the database lock, so a failing mdel leaves the transaction exactly as
+it was.
+*/
+
+// MSet writes every pair.
+func (t *Transaction) MSet(pairs ...KeyValue) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return err
+	}
+
+	for _, kv := range pairs {
+		t.set(kv.Key, kv.Value)
+	}
+	return nil
+}
+
+// MDelete deletes every key, or none of them if any has no visible value.
+func (t *Transaction) MDelete(keys ...string) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if t.visible(key) == nil {
+			return fmt.Errorf("cannot delete key that does not exist: %s", key)
+		}
+	}
+
+	// Deleting the same key twice in one statement means deleting it once.
+	for _, key := range slices.Compact(slices.Sorted(slices.Values(keys))) {
+		t.delete(key)
+	}
+	return nil
+}
+
+// mset key value [key value...]
+func (c *Connection) mset(args []string) (Result, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Result{}, fmt.Errorf("mset: expected key value pairs")
+	}
+
+	pairs := make([]KeyValue, len(args)/2)
+	keys := make([]string, len(args)/2)
+	for i := range pairs {
+		pairs[i] = KeyValue{args[2*i], args[2*i+1]}
+		keys[i] = args[2*i]
+	}
+
+	res := Result{Keys: keys, TxId: c.tx.id}
+	return res, c.tx.MSet(pairs...)
+}
+
+// mdel key...
+func (c *Connection) mdel(args []string) (Result, error) {
+	res := Result{Keys: args, TxId: c.tx.id}
+	return res, c.tx.MDelete(args...)
+}
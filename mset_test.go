@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMSetMDel(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("mset", []string{"a", "1", "b", "2", "c", "3"})
+	assertEq(c1.tx.writeset.Len(), 3, "writeset after mset")
+
+	_, err := c1.execCommand("mset", []string{"a", "1", "b"})
+	assertEq(err.Error(), "mset: expected key value pairs", "odd mset")
+
+	// One missing key and nothing is deleted.
+	_, err = c1.execCommand("mdel", []string{"a", "missing", "b"})
+	assertEq(err.Error(), "cannot delete key that does not exist: missing", "mdel missing")
+	res := c1.mustExecCommand("mget", []string{"a", "b", "c"})
+	assertEq(res, "a=1\nb=2\nc=3", "mget after failed mdel")
+
+	c1.mustExecCommand("mdel", []string{"a", "b", "a"})
+	res = c1.mustExecCommand("mget", []string{"a", "b", "c"})
+	assertEq(res, "a\nb\nc=3", "mget after mdel")
+	c1.mustExecCommand("commit", nil)
+
+	// Autocommitted, both writes land in the same transaction.
+	c2 := database.newConnection()
+	result, err := c2.execCommand("mset", []string{"x", "1", "y", "2"})
+	assertEq(err, nil, "autocommit mset")
+	tx, _ := database.Begin()
+	changes := 0
+	for _, key := range []string{"x", "y"} {
+		tx.Get(key)
+		if tx.visible(key).txStartId == result.TxId {
+			changes++
+		}
+	}
+	assertEq(changes, 2, "same transaction")
+
+	_, err = tx.Get("a")
+	assert(errors.Is(err, ErrKeyNotFound), "a deleted")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tidwall/btree"
+)
+
+/*
+A transaction can begin another inside itself. The nested transaction
+gets an id of its own, from the same sequence as every other, and writes
+versions under it. Nobody sees them but the nested transaction itself
+until it commits; then its parent sees them too, as though it had written
+them, and everyone else only once the outermost transaction commits. If
+the nested transaction aborts, its writes are gone, and its parent goes
+on as if it had never begun.
+
+So committing a nested transaction doesn't commit it. It is merged into
+its parent instead: its keys join the parent's readset and writeset, its
+hooks the parent's hooks, and its id the set of ids whose versions the
+parent treats as its own (along with those merged into it, all the way
+down). It stays in progress as far as anyone else is concerned, and
+completes only when its parent does, the same way. A nested transaction
+reads from the snapshot its outermost transaction began with, and sees
+the writes of everything it is nested in.
+
+Conflicts are checked once, when the outermost transaction commits, over
+everything merged into it. While a nested transaction is in progress its
+parent can't be used, only aborted, which aborts the nested one too.
+
+Recovery nests transactions by the parent id on their begin records, and
+merges them on their merge records. The database isn't checkpointed while
+a nested transaction is in progress or merged into one that is, since
+checkpoints don't record what is nested in what.
+*/
+
+var ErrNestedInProgress = errors.New("a nested transaction is in progress")
+
+// Begin starts a transaction nested in t. While it is in progress t can
+// only be aborted.
+func (t *Transaction) Begin() (*Transaction, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return nil, err
+	}
+	return t.db.newNested(t), nil
+}
+
+func (d *Database) newNested(parent *Transaction) *Transaction {
+	t := d.newTransaction(parent.ctx)
+	t.parent = parent
+	t.isolation = parent.isolation
+	t.inprogress = parent.root().inprogress
+	parent.child = t
+	t.debug("nested transaction", "parent", parent.id)
+	return t
+}
+
+// root returns the outermost transaction t is nested in, or t.
+func (t *Transaction) root() *Transaction {
+	for t.parent != nil {
+		t = t.parent
+	}
+	return t
+}
+
+// owns reports whether t treats versions written by the transaction id
+// as its own: it is t, or merged into t, or something t is nested in.
+func (t *Transaction) owns(id uint64) bool {
+	for ; t != nil; t = t.parent {
+		if id == t.id || t.merged.Contains(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrote reports whether the transaction, or one it is nested in, has
+// written key.
+func (t *Transaction) wrote(key string) bool {
+	for ; t != nil; t = t.parent {
+		if t.writeset.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeChild commits the nested transaction t into its parent.
+func (d *Database) mergeChild(t *Transaction) {
+	t.debug("merging into parent", "parent", t.parent.id)
+	p := t.parent
+	if t.logged {
+		t.log(WALRecord{Type: WALMerge})
+	}
+	if t.walErr != nil && p.walErr == nil {
+		p.walErr = t.walErr
+	}
+	d.merge(t)
+
+	addAll(&p.readset, &t.readset)
+	addAll(&p.writeset, &t.writeset)
+	p.onCommit = append(p.onCommit, t.onCommit...)
+	p.onRollback = append(p.onRollback, t.onRollback...)
+	t.onCommit, t.onRollback = nil, nil
+	if t.audited != nil && p.audited == nil {
+		p.audited = map[string]*string{}
+	}
+	for key, old := range t.audited {
+		if _, ok := p.audited[key]; !ok {
+			p.audited[key] = old
+		}
+	}
+	// Rolling the parent back to a savepoint undoes what t did too.
+	if p.keepsUndo() {
+		p.undo = append(p.undo, t.undo...)
+	}
+	p.ended = append(p.ended, t.ended...)
+	p.versionsScanned += t.versionsScanned
+	p.added += t.added
+
+	t.endSpan(MergedTransaction)
+}
+
+// merge marks t as merged into its parent.
+func (d *Database) merge(t *Transaction) {
+	t.state = MergedTransaction
+	t.parent.child = nil
+	t.parent.merged.Insert(t.id)
+	iter := t.merged.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		t.parent.merged.Insert(iter.Key())
+	}
+}
+
+// settleMerged completes whatever was merged into t, which has just
+// completed, the same way.
+func (d *Database) settleMerged(t *Transaction) {
+	iter := t.merged.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		merged, ok := d.transactions.Get(iter.Key())
+		if !ok {
+			continue
+		}
+		merged.state = t.state
+		d.clog.record(merged.id, merged.state)
+		merged.committedAt = t.committedAt
+		d.running.Delete(merged.id)
+	}
+}
+
+func addAll(dst, src *btree.Set[string]) {
+	iter := src.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		dst.Insert(iter.Key())
+	}
+}
+
+// hasNested reports whether any transaction in progress is nested, or
+// merged into one that is still in progress.
+func (d *Database) hasNested() bool {
+	iter := d.running.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		if t, _ := d.transactions.Get(iter.Key()); t.parent != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// begin inside a transaction
+func (c *Connection) beginNested() (Result, error) {
+	tx, err := c.tx.Begin()
+	if err != nil {
+		return Result{}, fmt.Errorf("begin: %w", err)
+	}
+	c.tx = tx
+	return Result{Value: fmt.Sprintf("%d", tx.id), TxId: tx.id}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNested(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	c := database.newConnection()
+	other := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "before"})
+
+	c.mustExecCommand("begin", nil)
+	parent := c.tx
+	c.mustExecCommand("set", []string{"y", "parent"})
+
+	c.mustExecCommand("begin", nil)
+	assert(c.tx.parent == parent, "nested")
+	assertEq(c.mustExecCommand("get", []string{"y"}), "parent", "nested sees its parent's writes")
+	c.mustExecCommand("set", []string{"x", "aborted"})
+	c.mustExecCommand("abort", nil)
+	assert(c.tx == parent, "back in the parent")
+	assertEq(c.mustExecCommand("get", []string{"x"}), "before", "aborted writes discarded")
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "merged"})
+	c.mustExecCommand("delete", []string{"y"})
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"z", "grandchild"})
+	c.mustExecCommand("commit", nil)
+	c.mustExecCommand("commit", nil)
+	assert(c.tx == parent, "back in the parent")
+
+	assertEq(c.mustExecCommand("get", []string{"x"}), "merged", "merged write")
+	assertEq(c.mustExecCommand("get", []string{"z"}), "grandchild", "merged all the way up")
+	_, err := c.execCommand("get", []string{"y"})
+	assertEq(err, ErrKeyNotFound, "merged delete")
+	assertEq(other.mustExecCommand("get", []string{"x"}), "before", "not visible to others yet")
+
+	c.mustExecCommand("commit", nil)
+	assert(c.tx == nil, "done")
+	assertEq(other.mustExecCommand("get", []string{"x"}), "merged", "visible once the parent commits")
+	assertEq(other.mustExecCommand("get", []string{"z"}), "grandchild", "z")
+	assertEq(database.running.Len(), 0, "nothing left running")
+}
+
+func TestNested_conflict(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	parent, _ := database.Begin()
+	child, _ := parent.Begin()
+	assertEq(parent.Set("x", "parent"), ErrNestedInProgress, "parent waits for the nested transaction")
+
+	// The nested transaction reads from its parent's snapshot, even
+	// though this commits before it begins.
+	before, _ := database.Begin()
+	before.Set("x", "before")
+	assertEq(before.Commit(), nil, "before")
+	grandchild, _ := child.Begin()
+	_, err := grandchild.Get("x")
+	assertEq(err, ErrKeyNotFound, "snapshot of the outermost transaction")
+
+	grandchild.Set("x", "grandchild")
+	assertEq(grandchild.Commit(), nil, "merge")
+	assertEq(child.Commit(), nil, "merge")
+
+	var conflict *ConflictError
+	assert(errors.As(parent.Commit(), &conflict), "conflict over what was merged")
+	assertEq(conflict.Winner, before.id, "winner")
+	assertEq(database.transactionState(grandchild.id), AbortedTransaction, "merged transactions aborted with their parent")
+}
+
+func TestNested_abortParent(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("begin", nil)
+	child := c.tx
+	var rolledBack bool
+	child.OnRollback(func() { rolledBack = true })
+	c.mustExecCommand("set", []string{"x", "child"})
+
+	assertEq(c.Close(), nil, "close")
+	assertEq(database.transactionState(child.id), AbortedTransaction, "nested transaction aborted")
+	assert(rolledBack, "its hooks ran")
+	assertEq(database.running.Len(), 0, "nothing left running")
+}
+
+func TestNested_savepoint(t *testing.T) {
+	database := newDatabase()
+	tx, _ := database.Begin()
+	tx.Set("x", "kept")
+	tx.Savepoint("a")
+	child, _ := tx.Begin()
+	child.Set("x", "undone")
+	child.Set("y", "undone")
+	assertEq(child.Commit(), nil, "merge")
+	assertEq(tx.RollbackTo("a"), nil, "rollback")
+
+	value, _ := tx.Get("x")
+	assertEq(value, "kept", "merged write undone")
+	_, err := tx.Get("y")
+	assertEq(err, ErrKeyNotFound, "merged write undone")
+}
+
+func TestNested_recovery(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "before"})
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "merged"})
+	c.mustExecCommand("commit", nil)
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"y", "aborted"})
+	c.mustExecCommand("abort", nil)
+	assert(errors.Is(database.Checkpoint(), ErrNestedInProgress), "no checkpoint while nested")
+	c.mustExecCommand("commit", nil)
+
+	// Killed with a nested transaction merged into one in progress.
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"z", "unfinished"})
+	c.mustExecCommand("commit", nil)
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	c = database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"x"}), "merged", "x")
+	for _, key := range []string{"y", "z"} {
+		_, err := c.execCommand("get", []string{key})
+		assertEq(err, ErrKeyNotFound, "not recovered: "+key)
+	}
+	assertEq(database.running.Len(), 0, "nothing left running")
+}
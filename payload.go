@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+Most values are small: counters, flags, short names and ids. Storing each
+of those as a Go string means one heap allocation per version, and one
+more object for the garbage collector to find and trace, on top of the
+version slice itself.
+
+So values of up to inlineValueSize bytes are copied straight into the
+version struct, and only larger ones keep a reference to a separately
+allocated string. That sizes Value at exactly one 64-byte cache line.
+
+The tradeoff is on the read side: handing out an inline value as a string
+copies it. That's one small, short-lived allocation per read against one
+long-lived allocation per stored version, which is the right way round for
+a store that keeps every version ever written.
+*/
+
+const inlineValueSize = 22
+
+type payload struct {
+	// Set for values too large to inline.
+	blob string
+
+	inline [inlineValueSize]byte
+	// What the value is (see types.go).
+	kind ValueType
+	// Length of the inline value, or 0xff if the value is in blob, or
+	// 0xfe if it is in a segment file (see segments.go), or 0xfd if it is
+	// compressed in blob (see compress.go).
+	n uint8
+}
+
+const (
+	blobPayload           = 0xff
+	segmentPayload        = 0xfe
+	compressedBlobPayload = 0xfd
+)
+
+func makePayload(value string) payload {
+	var p payload
+	if len(value) > inlineValueSize {
+		p.blob = value
+		p.n = blobPayload
+		return p
+	}
+	p.n = uint8(copy(p.inline[:], value))
+	return p
+}
+
+func (p payload) String() string {
+	switch p.n {
+	case blobPayload:
+		return p.blob
+	case segmentPayload, compressedBlobPayload:
+		panic("segment and compressed payloads must be read through the database")
+	}
+	return string(p.inline[:p.n])
+}
+
+func (p payload) Len() int {
+	switch p.n {
+	case blobPayload:
+		return len(p.blob)
+	case segmentPayload:
+		if p.inline[compressedFlag] != 0 {
+			return p.compressedLen(compressedLenRef)
+		}
+		return int(p.segmentRef().length)
+	case compressedBlobPayload:
+		return p.compressedLen(compressedLenBlob)
+	}
+	return int(p.n)
+}
+
+// payload returns what a new version with value, of type kind, should
+// hold.
+func (d *Database) payload(value string, kind ValueType) payload {
+	p := d.untypedPayload(value)
+	p.kind = kind
+	return p
+}
+
+func (d *Database) untypedPayload(value string) payload {
+	stored, compressed := d.compress(value)
+	if !compressed {
+		stored = value
+	}
+
+	if d.segments != nil && len(stored) > inlineValueSize {
+		ref, err := d.segments.write(stored)
+		if err == nil {
+			p := ref.payload()
+			if compressed {
+				p.inline[compressedFlag] = 1
+				binary.LittleEndian.PutUint32(p.inline[compressedLenRef:], uint32(len(value)))
+			}
+			return p
+		}
+		// The value is still safe in memory, and in the log.
+		d.logger.Warn("segment write failed, keeping value in memory", "err", err)
+	}
+
+	if compressed {
+		return compressedPayload(stored, len(value))
+	}
+	return makePayload(value)
+}
+
+// read returns the version's value, as a string. It fails only if the
+// value is in a segment (see segments.go) that can't be read back.
+func (d *Database) read(v *Value) (string, error) {
+	raw, err := d.readRaw(v)
+	if err != nil || v.data.kind == StringType {
+		return raw, err
+	}
+	return formatValue(v.data.kind, raw), nil
+}
+
+// readRaw returns the version's value as stored.
+func (d *Database) readRaw(v *Value) (string, error) {
+	var stored string
+	var err error
+	switch v.data.n {
+	case segmentPayload:
+		stored, err = d.segments.read(v.data.segmentRef())
+		if err == nil && v.data.inline[compressedFlag] == 0 {
+			return stored, nil
+		}
+	case compressedBlobPayload:
+		stored = v.data.blob
+	default:
+		return v.data.String(), nil
+	}
+
+	var value string
+	if err == nil {
+		value, err = decompress(stored)
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading value: %w", err)
+	}
+	return value, nil
+}
+
+// readFailed remembers err, from reading back a value t needed to write,
+// so that t can't commit.
+func (t *Transaction) readFailed(err error) {
+	if t.readErr == nil {
+		t.readErr = err
+	}
+}
+
+// readAll reads each of vs, leaving "" for any that is nil.
+func (d *Database) readAll(vs ...*Value) ([]string, error) {
+	values := make([]string, len(vs))
+	for i, v := range vs {
+		if v == nil {
+			continue
+		}
+		var err error
+		if values[i], err = d.read(v); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPayload(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"hey",
+		strings.Repeat("x", inlineValueSize),
+		strings.Repeat("x", inlineValueSize+1),
+		strings.Repeat("x", 4096),
+	} {
+		p := makePayload(value)
+		assertEq(p.String(), value, "payload round trip")
+		assertEq(p.Len(), len(value), "payload length")
+		assertEq(p.blob != "", len(value) > inlineValueSize, "payload inlined")
+	}
+}
+
+// A small value's string is garbage as soon as it has been copied into
+// its version...
+func BenchmarkSetValue(b *testing.B) {
+	for _, size := range []int{8, inlineValueSize + 1} {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			database := newDatabase()
+			tx, _ := database.Begin()
+			value := []byte(strings.Repeat("x", size))
+			b.ReportAllocs()
+			for i := 0; b.Loop(); i++ {
+				// Build a fresh string each time, like a value parsed off
+				// the wire would be.
+				value[0] = byte(i)
+				tx.Set(fmt.Sprint(i%1024), string(value))
+			}
+		})
+	}
+}
+
+// ...so a store full of them leaves the garbage collector less to trace.
+func BenchmarkGCWithVersions(b *testing.B) {
+	for _, size := range []int{8, inlineValueSize + 1} {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			database := newDatabase()
+			tx, _ := database.Begin()
+			value := []byte(strings.Repeat("x", size))
+			for i := range 200_000 {
+				value[0] = byte(i)
+				tx.Set(fmt.Sprint(i%1024), string(value))
+			}
+			runtime.GC()
+
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+
+			for b.Loop() {
+				runtime.GC()
+			}
+			b.ReportMetric(float64(stats.HeapObjects), "heap-objects")
+			runtime.KeepAlive(&database)
+		})
+	}
+}
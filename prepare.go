@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+/*
+To take part in a distributed transaction, the database commits in two
+phases. Preparing a transaction does everything committing it would,
+short of making it visible: it checks for conflicts, aborting the
+transaction if there are any, and logs the transaction as prepared, under
+a global id the coordinator chose, syncing the log so that it survives a
+crash. From then on the transaction can't fail to commit, and can't do
+anything else, until the coordinator commits or rolls back the prepared
+transaction, from whatever connection it likes.
+
+A prepared transaction stays in progress, so nobody sees its writes and
+vacuum keeps what it might need. It can't have conflicts found with
+transactions that commit after it was prepared, so those find conflicts
+with it instead, as if it had already committed: a prepared transaction
+that is rolled back in the end may have aborted others for nothing, but
+one that is committed never breaks their isolation.
+
+Recovery brings prepared transactions back as they were, still waiting
+for their coordinator, and so do checkpoints. Shutdown doesn't wait for
+them, or abort them; neither does abortall.
+*/
+
+var (
+	ErrTransactionPrepared = errors.New("transaction is prepared")
+	ErrUnknownPrepared     = errors.New("no such prepared transaction")
+)
+
+// Prepare validates the transaction and durably records that it is
+// prepared to commit, under the global id gid. If it would conflict, it
+// is aborted instead. Once prepared it can only be finished with
+// CommitPrepared or RollbackPrepared.
+func (t *Transaction) Prepare(gid string) error {
+	t.db.mu.Lock()
+	err := t.db.prepare(t, gid)
+	var hooks []func()
+	if t.state == AbortedTransaction {
+		hooks = t.takeHooks()
+	}
+	t.db.mu.Unlock()
+
+	runHooks(hooks)
+	return err
+}
+
+func (d *Database) prepare(t *Transaction, gid string) error {
+	if err := t.checkInProgress(); err != nil {
+		return err
+	}
+	if t.parent != nil {
+		return errors.New("prepare: nested transactions can't be prepared")
+	}
+	if gid == "" {
+		return errors.New("prepare: empty global id")
+	}
+	if _, ok := d.prepared[gid]; ok {
+		return fmt.Errorf("prepare: %q is already prepared", gid)
+	}
+
+	if err := d.validate(t); err != nil {
+		d.completeTransaction(t, AbortedTransaction)
+		return err
+	}
+	if err := d.checkMemoryBudget(t); err != nil {
+		d.completeTransaction(t, AbortedTransaction)
+		return err
+	}
+	if err := d.logPrepare(t, gid); err != nil {
+		d.completeTransaction(t, AbortedTransaction)
+		return err
+	}
+
+	t.debug("prepared transaction", "gid", gid)
+	d.addPrepared(t, gid)
+	return nil
+}
+
+// logPrepare appends t's prepare record and syncs the log. Unlike other
+// transactions, t is logged even if it wrote nothing, so that the
+// coordinator can still find it after a crash.
+func (d *Database) logPrepare(t *Transaction, gid string) error {
+	if d.wal == nil {
+		return nil
+	}
+	if t.log(WALRecord{Type: WALPrepare, Key: gid}); t.walErr != nil {
+		return fmt.Errorf("write-ahead log: %w", t.walErr)
+	}
+	if err := d.wal.Sync(); err != nil {
+		return fmt.Errorf("write-ahead log: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) addPrepared(t *Transaction, gid string) {
+	if d.prepared == nil {
+		d.prepared = map[string]*Transaction{}
+	}
+	t.prepared = gid
+	d.prepared[gid] = t
+	// There is no rolling back to them now.
+	t.savepoints, t.undo = nil, nil
+}
+
+// CommitPrepared commits the transaction prepared under gid.
+func (d *Database) CommitPrepared(gid string) error {
+	return d.finishPrepared(gid, CommittedTransaction)
+}
+
+// RollbackPrepared aborts the transaction prepared under gid.
+func (d *Database) RollbackPrepared(gid string) error {
+	return d.finishPrepared(gid, AbortedTransaction)
+}
+
+func (d *Database) finishPrepared(gid string, state TransactionState) error {
+	d.mu.Lock()
+	t, ok := d.prepared[gid]
+	if !ok {
+		d.mu.Unlock()
+		return fmt.Errorf("%w: %q", ErrUnknownPrepared, gid)
+	}
+	delete(d.prepared, gid)
+	err := d.completeTransaction(t, state)
+	hooks := t.takeHooks()
+	d.mu.Unlock()
+
+	runHooks(hooks)
+	return err
+}
+
+// Prepared returns the global ids of the prepared transactions.
+func (d *Database) Prepared() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	gids := make([]string, 0, len(d.prepared))
+	for gid := range d.prepared {
+		gids = append(gids, gid)
+	}
+	slices.Sort(gids)
+	return gids
+}
+
+// prepare gid
+//
+// Once prepared, or aborted for a conflict, the transaction is no longer
+// the connection's.
+func (c *Connection) prepare(args []string) (Result, error) {
+	res := Result{TxId: c.tx.id}
+	if err := c.tx.Prepare(args[0]); err != nil {
+		if c.tx.state == AbortedTransaction {
+			c.tx = nil
+		}
+		return res, err
+	}
+	c.tx = nil
+	return res, nil
+}
+
+// commit-prepared gid
+// rollback-prepared gid
+func (c *Connection) finishPrepared(command string, args []string) (Result, error) {
+	if command == "commit-prepared" {
+		return Result{}, c.db.CommitPrepared(args[0])
+	}
+	return Result{}, c.db.RollbackPrepared(args[0])
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPrepare(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	other := database.newConnection()
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "committed"})
+	c.mustExecCommand("prepare", []string{"g1"})
+	assert(c.tx == nil, "prepared transaction is no longer the connection's")
+	_, err := other.execCommand("get", []string{"x"})
+	assertEq(err, ErrKeyNotFound, "not visible while prepared")
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"y", "rolled back"})
+	c.mustExecCommand("prepare", []string{"g2"})
+	_, err = c.execCommand("begin", nil)
+	assertEq(err, nil, "connection can begin again")
+	_, err = c.execCommand("prepare", []string{"g1"})
+	assert(err != nil && err.Error() == `prepare: "g1" is already prepared`, "global ids are unique")
+	c.mustExecCommand("abort", nil)
+	assertEq(len(database.Prepared()), 2, "both prepared")
+
+	other.mustExecCommand("commit-prepared", []string{"g1"})
+	other.mustExecCommand("rollback-prepared", []string{"g2"})
+	assertEq(other.mustExecCommand("get", []string{"x"}), "committed", "committed")
+	_, err = other.execCommand("get", []string{"y"})
+	assertEq(err, ErrKeyNotFound, "rolled back")
+	_, err = other.execCommand("commit-prepared", []string{"g1"})
+	assert(errors.Is(err, ErrUnknownPrepared), "already finished")
+	assertEq(database.running.Len(), 0, "nothing left running")
+}
+
+func TestPrepare_conflict(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	prepared, _ := database.Begin()
+	later, _ := database.Begin()
+	prepared.Set("x", "prepared")
+	assertEq(prepared.Prepare("g"), nil, "prepare")
+	assertEq(prepared.Set("y", "more"), ErrTransactionPrepared, "prepared transactions can't be used")
+	assertEq(prepared.Commit(), ErrTransactionPrepared, "or committed directly")
+
+	// Transactions committing after the prepare conflict with it, as if
+	// it had committed already.
+	later.Set("x", "later")
+	var conflict *ConflictError
+	assert(errors.As(later.Commit(), &conflict), "conflict with the prepared transaction")
+	assertEq(conflict.Winner, prepared.id, "winner")
+	assertEq(database.CommitPrepared("g"), nil, "commit prepared")
+
+	// And a transaction that would conflict can't prepare.
+	first, _ := database.Begin()
+	second, _ := database.Begin()
+	first.Set("z", "first")
+	second.Set("z", "second")
+	assertEq(first.Commit(), nil, "first")
+	assert(errors.As(second.Prepare("h"), &conflict), "conflict on prepare")
+	assertEq(database.transactionState(second.id), AbortedTransaction, "aborted")
+	assertEq(len(database.Prepared()), 0, "not prepared")
+}
+
+func TestPrepare_recovery(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+
+	c := database.newConnection()
+	for _, gid := range []string{"before", "after"} {
+		c.mustExecCommand("begin", nil)
+		c.mustExecCommand("set", []string{gid, "prepared"})
+		c.mustExecCommand("prepare", []string{gid})
+		if gid == "before" {
+			assertEq(database.Checkpoint(), nil, "checkpoint with a prepared transaction")
+		}
+	}
+	// Read-only transactions are prepared too.
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("prepare", []string{"read-only"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assertEq(database.Shutdown(ctx), nil, "shutdown doesn't wait for prepared transactions")
+	assertEq(len(database.Prepared()), 3, "nor abort them")
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	assertEq(strings.Join(database.Prepared(), " "), "after before read-only", "recovered prepared")
+	assertEq(database.CommitPrepared("before"), nil, "commit")
+	assertEq(database.RollbackPrepared("after"), nil, "rollback")
+	crash(database)
+
+	// Finishing a recovered transaction is logged too.
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen again")
+	defer database.Close()
+	assertEq(strings.Join(database.Prepared(), " "), "read-only", "only the unfinished one")
+	c = database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"before"}), "prepared", "committed")
+	_, err = c.execCommand("get", []string{"after"})
+	assertEq(err, ErrKeyNotFound, "rolled back")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Under Snapshot and Serializable isolation, whichever of two conflicting
+transactions commits first wins. That is no good when the loser is an
+interactive request and the winner a bulk job that happened to get there
+first, so transactions have priorities. A transaction about to commit
+yields to any of higher priority still in progress that it would conflict
+with, were that one to commit first: it is aborted with a ConflictError
+naming the other, rather than committing and dooming it.
+
+A higher-priority transaction that aborts anyway will have made the ones
+that yielded to it abort for nothing. Between transactions of the same
+priority the first to commit still wins. Nested transactions have the
+priority of their outermost transaction.
+*/
+
+type Priority int8
+
+const (
+	LowPriority Priority = iota - 1
+	NormalPriority
+	HighPriority
+)
+
+func (p Priority) String() string {
+	switch p {
+	case LowPriority:
+		return "low"
+	case NormalPriority:
+		return "normal"
+	case HighPriority:
+		return "high"
+	}
+	return fmt.Sprintf("Priority(%d)", int8(p))
+}
+
+func parsePriority(s string) (Priority, error) {
+	for _, p := range []Priority{LowPriority, NormalPriority, HighPriority} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q", s)
+}
+
+// SetPriority sets the priority conflicts are resolved by. Transactions
+// begin with NormalPriority.
+func (t *Transaction) SetPriority(p Priority) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return err
+	}
+	if t.parent != nil {
+		return errors.New("priority: nested transactions have their outermost transaction's priority")
+	}
+	t.priority = p
+	return nil
+}
+
+// yieldTo returns a transaction still in progress, of higher priority than
+// t1, that conflictFn finds t1 conflicts with, and the keys they clash
+// over.
+func (d *Database) yieldTo(t1 *Transaction, conflictFn func(*Transaction, *Transaction) []string) (*Transaction, []string) {
+	iter := d.running.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		t2, _ := d.transactions.Get(iter.Key())
+		if t2.state != InProgressTransaction || t2.root() == t1.root() || t2.root().priority <= t1.root().priority {
+			continue
+		}
+		if keys := conflictFn(t1, t2); len(keys) > 0 {
+			return t2, keys
+		}
+	}
+	return nil, nil
+}
+
+// begin [isolation level] [priority low|normal|high]
+func beginPriority(args []string) (Priority, error) {
+	if len(args) == 0 {
+		return NormalPriority, nil
+	}
+	if len(args) != 2 || args[0] != "priority" {
+		return 0, errors.New("begin: expected begin [isolation level] [priority low|normal|high]")
+	}
+	p, err := parsePriority(args[1])
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	return p, nil
+}
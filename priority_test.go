@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPriority(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	bulk := database.newConnection()
+	interactive := database.newConnection()
+	bulk.mustExecCommand("begin", []string{"priority", "low"})
+	interactive.mustExecCommand("begin", []string{"priority", "high"})
+	bulk.mustExecCommand("set", []string{"x", "bulk"})
+	bulk.mustExecCommand("set", []string{"y", "bulk"})
+	interactive.mustExecCommand("set", []string{"x", "interactive"})
+
+	// The bulk job gets there first, but yields.
+	_, err := bulk.execCommand("commit", nil)
+	var conflict *ConflictError
+	assert(errors.As(err, &conflict), "low priority yields")
+	assertEq(conflict.Winner, interactive.tx.id, "to the high priority transaction")
+	assertEq(conflict.Keys[0], "x", "over x")
+	interactive.mustExecCommand("commit", nil)
+	assertEq(bulk.mustExecCommand("get", []string{"x"}), "interactive", "interactive committed")
+
+	// Without a conflict there is nothing to yield.
+	bulk.mustExecCommand("begin", []string{"priority", "low"})
+	interactive.mustExecCommand("begin", []string{"priority", "high"})
+	bulk.mustExecCommand("set", []string{"y", "bulk"})
+	interactive.mustExecCommand("set", []string{"x", "again"})
+	bulk.mustExecCommand("commit", nil)
+	interactive.mustExecCommand("commit", nil)
+
+	// Between equals the first to commit wins, as ever.
+	first := database.newConnection()
+	first.mustExecCommand("begin", nil)
+	bulk.mustExecCommand("begin", nil)
+	bulk.mustExecCommand("set", []string{"x", "second"})
+	first.mustExecCommand("set", []string{"x", "first"})
+	first.mustExecCommand("commit", nil)
+	_, err = bulk.execCommand("commit", nil)
+	assert(errors.As(err, &conflict), "second loses")
+}
+
+func TestPriority_commands(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	_, err := c.execCommand("begin", []string{"priority", "urgent"})
+	assertEq(err.Error(), `begin: unknown priority "urgent"`, "unknown priority")
+	_, err = c.execCommand("begin", []string{"urgently"})
+	assert(err != nil, "expected priority")
+
+	c.mustExecCommand("begin", []string{"priority", "high"})
+	_, err = c.execCommand("begin", []string{"priority", "low"})
+	assert(err != nil, "nested transactions take their parent's priority")
+	assertEq(c.tx.parent, nil, "not nested")
+	assertEq(database.Transactions()[0].Priority, HighPriority, "listed")
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/tidwall/btree"
+)
+
+/*
+In a raft cluster, every node keeps a copy of the database, and commits
+are sequenced through a replicated log (see github.com/hashicorp/raft)
+rather than the write-ahead log. Only the leader commits transactions
+that write. It validates one as usual, then appends its effect, the
+value each key it wrote ended up with, to the raft log, and the commit
+succeeds once a majority of the nodes have the entry. Other nodes apply
+entries as a follower applies the leader's log (see follower.go): each
+is replayed as a transaction of the node's own, which commits all at
+once. Writes are linearizable: each commits on the leader, in log order,
+after validating against every entry before it.
+
+A node that becomes leader first waits until it has applied every entry
+its predecessors appended, then announces the address of its forwarding
+server (see forward.go) in an entry of its own. Connections on the other
+nodes forward their commands there, so clients can talk to any node.
+If the leader fails, the others elect a new one, and connections follow
+it, although a transaction in progress at the old leader is lost.
+
+The database lock is held while an entry is replicated, so commits go
+through the log one at a time. The entries the leader appends are
+already in its database, so applying them there doesn't need the lock.
+Raft takes snapshots, to truncate its log, from a copy of the committed
+state kept alongside the database for the purpose, in the form of a
+backup (see backup.go); a node restoring one starts over from it, as a
+follower does from a checkpoint.
+*/
+
+var (
+	ErrNotLeader = errors.New("not the raft leader")
+	ErrNoLeader  = errors.New("no raft leader")
+)
+
+// The kinds of raft log entry.
+const (
+	// A transaction's effect: the node and commit it came from, its
+	// commit timestamp, then its records.
+	raftCommit = 'c'
+	// A new leader's id and forwarding server address.
+	raftAnnounce = 'a'
+)
+
+// Snapshots keep the leaders' forwarding addresses as keys under this
+// prefix.
+const raftForwardPrefix = internalPrefix + "raft\x00forward\x00"
+
+// RaftConfig describes a node of a raft cluster.
+type RaftConfig struct {
+	// The node's id in the cluster, and the address it listens on for
+	// the other nodes.
+	ID   string
+	Addr string
+	// The address of the node's forwarding server.
+	ForwardAddr string
+	// Where to keep the raft log and snapshots; in memory if empty.
+	Dir string
+	// Start a new cluster with just this node, unless Dir has one
+	// already.
+	Bootstrap bool
+	// How long a follower waits to hear from the leader before calling
+	// an election; the library's default if zero.
+	HeartbeatTimeout time.Duration
+	// The secret the nodes forward commands to each other with (see
+	// forward.go), and how to dial their forwarding servers over TLS, if
+	// they serve it.
+	Secret string
+	TLS    *tls.Config
+}
+
+// RaftNode is a database's part in a raft cluster.
+type RaftNode struct {
+	db          *Database
+	id          string
+	forwardAddr string
+	secret      string
+	tlsConfig   *tls.Config
+	raft        *raft.Raft
+	transport   *raft.NetworkTransport
+	stores      []io.Closer
+	// Tells this process's entries from others'.
+	origin uint64
+	// Set while the node is leader and has applied everything before its
+	// term.
+	ready   atomic.Bool
+	done    chan struct{}
+	stopped chan struct{}
+
+	mu sync.Mutex
+	// The committed state, as the log has it, and each node that has led
+	// by its forwarding address.
+	state    btree.Map[string, backupEntry]
+	forwards map[string]string
+	// The last commit the node sent the log, whether its entry is still
+	// awaited, and whether it has been applied.
+	seq     uint64
+	pending bool
+	applied bool
+}
+
+// StartRaft makes d a node of a raft cluster, until the node is closed. d
+// must be empty, and not logged to; its contents come from the cluster.
+func (d *Database) StartRaft(cfg RaftConfig) (*RaftNode, error) {
+	d.mu.Lock()
+	if d.raftNode.Load() != nil {
+		d.mu.Unlock()
+		return nil, errors.New("raft: already started")
+	}
+	if d.wal != nil || d.follower != nil || d.store.Len() > 0 || d.nextTransactionId > 1 {
+		d.mu.Unlock()
+		return nil, errors.New("raft: database is not empty")
+	}
+	d.mu.Unlock()
+
+	n := &RaftNode{
+		db:          d,
+		id:          cfg.ID,
+		forwardAddr: cfg.ForwardAddr,
+		secret:      cfg.Secret,
+		tlsConfig:   cfg.TLS,
+		origin:      rand.Uint64(),
+		forwards:    map[string]string{},
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	logger := hclog.FromStandardLogger(slog.NewLogLogger(d.logger.Handler(), slog.LevelWarn), &hclog.LoggerOptions{Name: "raft", Level: hclog.Warn})
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(cfg.ID)
+	config.Logger = logger
+	if cfg.HeartbeatTimeout > 0 {
+		config.HeartbeatTimeout = cfg.HeartbeatTimeout
+		config.ElectionTimeout = cfg.HeartbeatTimeout
+		config.LeaderLeaseTimeout = cfg.HeartbeatTimeout / 2
+	}
+
+	var logs raft.LogStore
+	var stable raft.StableStore
+	var snapshots raft.SnapshotStore
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return nil, fmt.Errorf("raft: %w", err)
+		}
+		store, err := raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, "raft.db"))
+		if err != nil {
+			return nil, fmt.Errorf("raft: %w", err)
+		}
+		n.stores = append(n.stores, store)
+		logs, stable = store, store
+		if snapshots, err = raft.NewFileSnapshotStoreWithLogger(cfg.Dir, 2, logger); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("raft: %w", err)
+		}
+	} else {
+		store := raft.NewInmemStore()
+		logs, stable = store, store
+		snapshots = raft.NewInmemSnapshotStore()
+	}
+
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		n.closeStores()
+		return nil, fmt.Errorf("raft: %w", err)
+	}
+	n.transport = raft.NewNetworkTransportWithConfig(&raft.NetworkTransportConfig{
+		Stream:  raftStream{ln},
+		MaxPool: 3,
+		Timeout: 10 * time.Second,
+		Logger:  logger,
+	})
+
+	if cfg.Bootstrap {
+		existing, err := raft.HasExistingState(logs, stable, snapshots)
+		if err == nil && !existing {
+			err = raft.BootstrapCluster(config, logs, stable, snapshots, n.transport, raft.Configuration{
+				Servers: []raft.Server{{ID: config.LocalID, Address: n.transport.LocalAddr()}},
+			})
+		}
+		if err != nil {
+			n.transport.Close()
+			n.closeStores()
+			return nil, fmt.Errorf("raft: %w", err)
+		}
+	}
+
+	d.raftNode.Store(n)
+	n.raft, err = raft.NewRaft(config, raftFSM{n}, logs, stable, snapshots, n.transport)
+	if err != nil {
+		d.raftNode.Store(nil)
+		n.transport.Close()
+		n.closeStores()
+		return nil, fmt.Errorf("raft: %w", err)
+	}
+	go n.watchLeadership()
+	return n, nil
+}
+
+// Addr returns the address the node listens on for the other nodes.
+func (n *RaftNode) Addr() string {
+	return string(n.transport.LocalAddr())
+}
+
+// AddVoter adds the node id, listening at addr, to the cluster. Only the
+// leader can.
+func (n *RaftNode) AddVoter(id, addr string) error {
+	if err := n.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0).Error(); err != nil {
+		return fmt.Errorf("raft: %w", err)
+	}
+	return nil
+}
+
+// Close leaves the cluster. The database stays as it was, but nothing more
+// commits on it.
+func (n *RaftNode) Close() error {
+	close(n.done)
+	err := n.raft.Shutdown().Error()
+	<-n.stopped
+	n.ready.Store(false)
+	n.transport.Close()
+	n.closeStores()
+	return err
+}
+
+func (n *RaftNode) closeStores() {
+	for _, s := range n.stores {
+		s.Close()
+	}
+}
+
+func (n *RaftNode) watchLeadership() {
+	defer close(n.stopped)
+	leaderCh := n.raft.LeaderCh()
+	for {
+		select {
+		case <-n.done:
+			return
+		case leader := <-leaderCh:
+			n.ready.Store(false)
+			if leader {
+				n.lead()
+			}
+		}
+	}
+}
+
+// lead readies a node that has just become leader to commit.
+func (n *RaftNode) lead() {
+	// Until it has applied everything its predecessors appended, commits
+	// would validate against a stale database.
+	if err := n.raft.Barrier(0).Error(); err != nil {
+		n.db.logger.Warn("raft: can't catch up as leader", "err", err)
+		return
+	}
+	entry := frameBody{raftAnnounce}.string(n.id).string(n.forwardAddr)
+	if err := n.raft.Apply(entry, 0).Error(); err != nil {
+		n.db.logger.Warn("raft: can't announce leadership", "err", err)
+		return
+	}
+	n.ready.Store(true)
+	n.db.logger.Info("raft: leading", "id", n.id)
+}
+
+// leading reports whether the node is the leader, as far as it knows.
+func (n *RaftNode) leading() bool {
+	_, id := n.raft.LeaderWithID()
+	return string(id) == n.id
+}
+
+// leaderForwardAddr returns the address of the leader's forwarding server.
+func (n *RaftNode) leaderForwardAddr() (string, error) {
+	_, id := n.raft.LeaderWithID()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	addr, ok := n.forwards[string(id)]
+	if id == "" || !ok {
+		return "", ErrNoLeader
+	}
+	return addr, nil
+}
+
+// commit appends the effect of t, which has validated, to the raft log,
+// and waits for a majority of the nodes to have it. If that fails, t
+// must abort, although the entry may yet commit, and is then replayed
+// like another node's.
+func (n *RaftNode) commit(t *Transaction) error {
+	if !n.ready.Load() {
+		return ErrNotLeader
+	}
+	recs, err := t.effects()
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.seq++
+	n.pending, n.applied = true, false
+	entry := frameBody{raftCommit}.uvarint(n.origin).uvarint(n.seq).uvarint(uint64(t.committedAt)).uvarint(uint64(len(recs)))
+	n.mu.Unlock()
+	for _, rec := range recs {
+		entry = entry.string(string(encodeWALRecord(rec)))
+	}
+
+	err = n.raft.Apply(entry, 0).Error()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending = false
+	if n.applied {
+		return nil
+	}
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+		return fmt.Errorf("%w: %w", ErrNotLeader, err)
+	}
+	return fmt.Errorf("raft: %w", err)
+}
+
+// effects returns records that redo what t, about to commit, wrote.
+func (t *Transaction) effects() ([]WALRecord, error) {
+	var recs []WALRecord
+	iter := t.writeset.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		key := iter.Key()
+		rec := WALRecord{Type: WALDelete, Key: key}
+		versions := t.db.versions(key)
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := &versions[i]
+			if t.owns(v.txStartId) && !t.owns(v.txEndId) {
+				raw, err := t.db.readRaw(v)
+				if err != nil {
+					return nil, err
+				}
+				rec = WALRecord{Type: WALSet, Key: key, Value: raw, ValueType: v.data.kind, ExpiresAt: v.expiresAt}
+				break
+			}
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// record applies recs to the state kept for snapshots.
+func (n *RaftNode) record(recs []WALRecord) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, rec := range recs {
+		if rec.Type == WALSet {
+			n.state.Set(rec.Key, backupEntry{rec.Key, rec.Value, rec.ExpiresAt, rec.ValueType})
+		} else {
+			n.state.Delete(rec.Key)
+		}
+	}
+}
+
+// replayCommit commits recs to the database as a transaction of its own,
+// stamped ts if that is known.
+func (d *Database) replayCommit(lsn uint64, ts HLC, recs []WALRecord) error {
+	running := map[uint64]*Transaction{}
+	if err := d.replay(WALRecord{LSN: lsn, Type: WALBegin, TxId: 1}, running, true); err != nil {
+		return err
+	}
+	t := running[1]
+	for _, rec := range recs {
+		rec.LSN, rec.TxId = lsn, 1
+		if err := d.replay(rec, running, true); err != nil {
+			return err
+		}
+	}
+	commit := WALRecord{LSN: lsn, Type: WALCommit, TxId: 1}
+	if ts != 0 {
+		commit.Value = strconv.FormatUint(uint64(ts), 10)
+	}
+	if err := d.replay(commit, running, true); err != nil {
+		return err
+	}
+	d.publishChanges(t)
+	d.pruneTransactions()
+	return nil
+}
+
+// raftFSM applies the raft log to a node's database.
+type raftFSM struct{ *RaftNode }
+
+func (f raftFSM) Apply(l *raft.Log) any {
+	n := f.RaftNode
+	br := frameReader{b: l.Data}
+	switch br.byte() {
+	case raftAnnounce:
+		id, addr := br.string(), br.string()
+		if err := br.done(); err != nil {
+			return fmt.Errorf("raft: entry %d: %w", l.Index, err)
+		}
+		n.mu.Lock()
+		n.forwards[id] = addr
+		n.mu.Unlock()
+		return nil
+
+	case raftCommit:
+		origin, seq, ts := br.uvarint(), br.uvarint(), HLC(br.uvarint())
+		count := br.count()
+		recs := make([]WALRecord, 0, count)
+		for range count {
+			rec, err := decodeWALRecord([]byte(br.string()))
+			if br.err == nil {
+				br.err = err
+			}
+			recs = append(recs, rec)
+		}
+		if err := br.done(); err != nil {
+			return fmt.Errorf("raft: entry %d: %w", l.Index, err)
+		}
+
+		// The commit waiting for this entry already wrote it.
+		n.mu.Lock()
+		if origin == n.origin && seq == n.seq && n.pending {
+			n.applied = true
+			n.mu.Unlock()
+			n.record(recs)
+			return nil
+		}
+		n.mu.Unlock()
+
+		d := n.db
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if err := d.replayCommit(l.Index, ts, recs); err != nil {
+			d.logger.Error("raft: can't apply entry", "index", l.Index, "err", err)
+			return err
+		}
+		n.record(recs)
+		return nil
+	}
+	return fmt.Errorf("raft: entry %d: unknown kind", l.Index)
+}
+
+func (f raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &raftSnapshot{f.state.Copy(), maps.Clone(f.forwards)}, nil
+}
+
+func (f raftFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	entries, err := decodeBackup(bufio.NewReader(rc))
+	if err != nil {
+		return fmt.Errorf("raft: %w", err)
+	}
+
+	var state btree.Map[string, backupEntry]
+	forwards := map[string]string{}
+	var recs []WALRecord
+	for _, e := range entries {
+		if id, ok := strings.CutPrefix(e.key, raftForwardPrefix); ok {
+			forwards[id] = e.value
+			continue
+		}
+		state.Set(e.key, e)
+		recs = append(recs, WALRecord{Type: WALSet, Key: e.key, Value: e.value, ValueType: e.kind, ExpiresAt: e.expiresAt})
+	}
+
+	d := f.db
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.discardAll()
+	if err := d.replayCommit(0, 0, recs); err != nil {
+		return fmt.Errorf("raft: %w", err)
+	}
+	f.mu.Lock()
+	f.state, f.forwards = state, forwards
+	f.mu.Unlock()
+	d.logger.Info("raft: started over from a snapshot", "keys", len(recs))
+	return nil
+}
+
+type raftSnapshot struct {
+	state    *btree.Map[string, backupEntry]
+	forwards map[string]string
+}
+
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	entries := make([]backupEntry, 0, s.state.Len()+len(s.forwards))
+	for id, addr := range s.forwards {
+		entries = append(entries, backupEntry{key: raftForwardPrefix + id, value: addr})
+	}
+	s.state.Scan(func(_ string, e backupEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	if err := encodeBackup(sink, entries); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *raftSnapshot) Release() {}
+
+// raftStream carries raft's traffic over TCP.
+type raftStream struct{ net.Listener }
+
+func (s raftStream) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", string(addr), timeout)
+}
+
+// raft
+func (c *Connection) raftStatus() (Result, error) {
+	n := c.db.raftNode.Load()
+	if n == nil {
+		return Result{}, errors.New("raft: not a raft node")
+	}
+	leader := "none"
+	if _, id := n.raft.LeaderWithID(); id != "" {
+		leader = string(id)
+	}
+	lines := []string{
+		fmt.Sprintf("id %s", n.id),
+		fmt.Sprintf("state %s", strings.ToLower(n.raft.State().String())),
+		fmt.Sprintf("leader %s", leader),
+		fmt.Sprintf("ready %t", n.ready.Load()),
+		fmt.Sprintf("applied %d", n.raft.AppliedIndex()),
+	}
+	return Result{Value: strings.Join(lines, "\n")}, nil
+}
+
+// raft-join id addr
+func (c *Connection) raftJoin(args []string) (Result, error) {
+	n := c.db.raftNode.Load()
+	if n == nil {
+		return Result{}, errors.New("raft: not a raft node")
+	}
+	if err := n.AddVoter(args[0], args[1]); err != nil {
+		return Result{}, err
+	}
+	return Result{Value: args[0]}, nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type raftTestNode struct {
+	db   *Database
+	node *RaftNode
+	srv  *Server
+}
+
+func (n *raftTestNode) close() {
+	n.node.Close()
+	n.srv.Close()
+}
+
+// startRaftCluster starts a cluster of size nodes, the first of them its
+// leader, each set up by setup, if given.
+func startRaftCluster(size int, setup ...func(*Server, *RaftConfig)) []*raftTestNode {
+	var nodes []*raftTestNode
+	for i := range size {
+		database := newDatabase()
+		database.defaultIsolation = SnapshotIsolation
+		db := &database
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assertEq(err, nil, "listen")
+		srv := NewForwardingServer(db)
+		cfg := RaftConfig{
+			ID:               string(rune('a' + i)),
+			Addr:             "127.0.0.1:0",
+			ForwardAddr:      ln.Addr().String(),
+			Bootstrap:        i == 0,
+			HeartbeatTimeout: 50 * time.Millisecond,
+		}
+		for _, f := range setup {
+			f(srv, &cfg)
+		}
+		go srv.Serve(ln)
+		node, err := db.StartRaft(cfg)
+		assertEq(err, nil, "start raft")
+		nodes = append(nodes, &raftTestNode{db, node, srv})
+
+		if i == 0 {
+			waitFor(func() bool { return node.ready.Load() }, "first node leads")
+		} else {
+			assertEq(nodes[0].node.AddVoter(node.id, node.Addr()), nil, "add voter")
+		}
+	}
+	// Followers forward to the leader once they have heard from it.
+	for _, n := range nodes[1:] {
+		waitFor(func() bool {
+			_, err := n.node.leaderForwardAddr()
+			return err == nil
+		}, "knows the leader")
+	}
+	return nodes
+}
+
+func waitFor(cond func() bool, msg string) {
+	for deadline := time.Now().Add(10 * time.Second); !cond(); {
+		assert(time.Now().Before(deadline), msg)
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// hasValue reports whether key has value in db.
+func hasValue(db *Database, key, value string) func() bool {
+	return func() bool {
+		tx, _ := db.Begin()
+		defer tx.Abort()
+		v, err := tx.Get(key)
+		return err == nil && v == value
+	}
+}
+
+func TestRaft(t *testing.T) {
+	nodes := startRaftCluster(3)
+	defer func() {
+		for _, n := range nodes {
+			n.close()
+		}
+	}()
+	leader := nodes[0].db.newConnection()
+	follower := nodes[1].db.newConnection()
+	other := nodes[2].db.newConnection()
+
+	// A write on the leader reaches every node.
+	leader.mustExecCommand("set", []string{"x", "1"})
+	waitFor(hasValue(nodes[1].db, "x", "1"), "replicated")
+	waitFor(hasValue(nodes[2].db, "x", "1"), "replicated")
+
+	// Other nodes forward to the leader, and read what it has.
+	follower.mustExecCommand("set", []string{"y", "2"})
+	assertEq(leader.mustExecCommand("get", []string{"y"}), "2", "forwarded write")
+	assertEq(other.mustExecCommand("get", []string{"y"}), "2", "read from the leader")
+	_, err := other.execCommand("get", []string{"missing"})
+	assert(errors.Is(err, ErrKeyNotFound), "errors survive forwarding")
+
+	// A transaction stays on the leader, and conflicts as it would there.
+	follower.mustExecCommand("begin", nil)
+	other.mustExecCommand("begin", nil)
+	follower.mustExecCommand("set", []string{"z", "follower"})
+	other.mustExecCommand("set", []string{"z", "other"})
+	assertEq(follower.mustExecCommand("get", []string{"z"}), "follower", "own write")
+	follower.mustExecCommand("commit", nil)
+	_, err = other.execCommand("commit", nil)
+	assert(err != nil, "write-write conflict")
+	waitFor(hasValue(nodes[2].db, "z", "follower"), "the first commit won")
+
+	// Counters merge as they would on one node.
+	for _, c := range []*Connection{leader, follower, other} {
+		c.mustExecCommand("cincr", []string{"n"})
+	}
+	assertEq(leader.mustExecCommand("get", []string{"n"}), "3", "counter")
+
+	// Status and admin commands.
+	assertEq(leader.mustExecCommand("raft", nil), "id a\nstate leader\nleader a\nready true\napplied "+strconv.FormatUint(nodes[0].node.raft.AppliedIndex(), 10), "status")
+	_, err = nodes[1].db.StartRaft(RaftConfig{ID: "b"})
+	assert(err != nil, "already started")
+}
+
+func TestRaft_failover(t *testing.T) {
+	nodes := startRaftCluster(3)
+	defer func() {
+		for _, n := range nodes[1:] {
+			n.close()
+		}
+	}()
+	c := nodes[2].db.newConnection()
+	c.mustExecCommand("set", []string{"x", "before"})
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"y", "lost"})
+
+	// The leader fails, and the others carry on without it.
+	nodes[0].close()
+	_, err := c.execCommand("commit", nil)
+	assert(errors.Is(err, ErrTransactionAborted), "transaction at the old leader is lost")
+
+	waitFor(func() bool {
+		_, err = c.execCommand("set", []string{"x", "after"})
+		return err == nil
+	}, "new leader")
+	_, err = c.execCommand("get", []string{"y"})
+	assertEq(errors.Is(err, ErrKeyNotFound), true, "y never committed")
+	assertEq(c.mustExecCommand("get", []string{"x"}), "after", "x")
+	waitFor(hasValue(nodes[1].db, "x", "after"), "replicated")
+
+	// The old leader's database has stopped taking writes.
+	_, err = nodes[0].db.newConnection().execCommand("set", []string{"x", "stale"})
+	assert(err != nil, "closed node")
+}
+
+func TestRaft_forwardingAuth(t *testing.T) {
+	cert, pool := selfSignedCert()
+	users := []User{{Name: "alice", Password: "pw", Admin: true}, {Name: "bob", Password: "pw"}}
+	nodes := startRaftCluster(2, func(srv *Server, cfg *RaftConfig) {
+		srv.UseTLS(&tls.Config{Certificates: []tls.Certificate{cert}})
+		srv.RequireAuth(users)
+		cfg.Secret = "s3cret"
+		cfg.TLS = &tls.Config{RootCAs: pool}
+	})
+	defer func() {
+		for _, n := range nodes {
+			n.close()
+		}
+	}()
+
+	c := nodes[1].db.newConnection()
+	c.users = usersByName(users)
+	c.mustExecCommand("auth", []string{"alice", "pw"})
+	c.mustExecCommand("set", []string{"x", "1"})
+	assertEq(nodes[0].db.newConnection().mustExecCommand("get", []string{"x"}), "1", "forwarded over TLS")
+
+	// Only nodes, which know the secret, can forward, and what they
+	// forward runs as the user who sent it.
+	addr, err := nodes[1].node.leaderForwardAddr()
+	assertEq(err, nil, "leader")
+	forward := func(secret string, cfg *tls.Config, user *User, command string, args ...string) (Result, error) {
+		f, err := dialForwarder(&RaftNode{secret: secret, tlsConfig: cfg}, addr)
+		if err != nil {
+			return Result{}, err
+		}
+		defer f.close()
+		return f.exec(true, user, command, args)
+	}
+	alice, bob := &users[0], &users[1]
+	_, err = forward("wrong", &tls.Config{RootCAs: pool}, alice, "get", "x")
+	assert(err != nil, "wrong secret")
+	_, err = forward("s3cret", nil, alice, "get", "x")
+	assert(err != nil, "plaintext")
+	_, err = forward("s3cret", &tls.Config{RootCAs: pool}, nil, "get", "x")
+	assert(errors.Is(err, ErrAuthRequired), "no user")
+	_, err = forward("s3cret", &tls.Config{RootCAs: pool}, bob, "get", "x")
+	assert(errors.Is(err, ErrPermissionDenied), "user without access")
+	res, err := forward("s3cret", &tls.Config{RootCAs: pool}, alice, "get", "x")
+	assertEq(err, nil, "admin")
+	assertEq(res.Value, "1", "admin")
+
+	// Errors are told apart by code, not by what they say.
+	_, err = forward("s3cret", &tls.Config{RootCAs: pool}, alice, "lookup", "permission denied", "x")
+	assert(errors.Is(err, ErrUnknownIndex), "unknown index")
+	assert(!errors.Is(err, ErrPermissionDenied), "an index named like an error")
+}
+
+// bufferSink is a raft snapshot sink in memory.
+type bufferSink struct{ bytes.Buffer }
+
+func (s *bufferSink) ID() string    { return "test" }
+func (s *bufferSink) Cancel() error { return nil }
+func (s *bufferSink) Close() error  { return nil }
+
+func TestRaft_snapshot(t *testing.T) {
+	nodes := startRaftCluster(1)
+	defer nodes[0].close()
+	c := nodes[0].db.newConnection()
+	c.mustExecCommand("set", []string{"x", "1"})
+	c.mustExecCommand("rpush", []string{"l", "a", "b"})
+	c.mustExecCommand("set", []string{"gone", "1"})
+	c.mustExecCommand("delete", []string{"gone"})
+
+	snapshot, err := raftFSM{nodes[0].node}.Snapshot()
+	assertEq(err, nil, "snapshot")
+	var sink bufferSink
+	assertEq(snapshot.Persist(&sink), nil, "persist")
+
+	// Restoring replaces whatever the node had, aborting what was in
+	// progress.
+	database := newDatabase()
+	restored := &RaftNode{db: &database, forwards: map[string]string{}}
+	database.Set("stale", "1")
+	tx, _ := database.Begin()
+	assertEq(raftFSM{restored}.Restore(io.NopCloser(&sink)), nil, "restore")
+	r := database.newConnection()
+	assertEq(r.mustExecCommand("get", []string{"x"}), "1", "x")
+	assertEq(r.mustExecCommand("lrange", []string{"l", "0", "-1"}), "a\nb", "with types")
+	_, err = r.execCommand("get", []string{"gone"})
+	assertEq(err, ErrKeyNotFound, "deleted")
+	_, err = r.execCommand("get", []string{"stale"})
+	assertEq(err, ErrKeyNotFound, "replaced")
+	_, err = tx.Get("x")
+	assertEq(err, ErrTransactionAborted, "aborted by starting over")
+	assertEq(restored.forwards["a"], nodes[0].node.forwardAddr, "forwarding addresses")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+/*
+A recording is every command the database ran, with the connection it ran
+on and what it returned, so a bug someone hit can be sent as the commands
+that hit it, and a test can check a fix keeps giving the same answers.
+Replay runs a recording's commands again, on a database of the caller's,
+one after another, and stops at the first whose response differs.
+
+A recording has a line per command:
+
+	<connection> <command line>	<response>
+
+where connections are numbered from 1 in the order they first ran a
+command, the command line is as the line protocol takes it (see
+server.go), and after a tab comes the response as the line protocol
+sends it. Closing a connection is recorded as a close command.
+
+Commands are recorded in the order they finished. Replay is faithful if
+they didn't overlap, as in tests and reproductions; commands that ran
+concurrently, that blocked on others, or whose answers depend on the time
+or on random choices may answer differently.
+*/
+
+// WithRecording makes the database record every command it runs to w.
+func WithRecording(w io.Writer) Option {
+	return func(d *Database) {
+		d.recorder = &recorder{w: w, conns: map[*Connection]int{}}
+	}
+}
+
+// recorder writes a recording.
+type recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	conns map[*Connection]int
+	last  int
+}
+
+func (r *recorder) record(c *Connection, command string, args []string, res Result, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, ok := r.conns[c]
+	if !ok {
+		r.last++
+		id = r.last
+		r.conns[c] = id
+	}
+	fmt.Fprintf(r.w, "%d %s\t%s\n", id, formatCommandLine(command, args), responseLine(res, err))
+}
+
+// formatCommandLine formats a command line as parseCommandLine parses it.
+func formatCommandLine(command string, args []string) string {
+	words := make([]string, 0, 1+len(args))
+	for _, w := range append([]string{command}, args...) {
+		plain := w != "" && w[0] != '"' && strings.IndexFunc(w, func(r rune) bool {
+			return unicode.IsSpace(r) || !unicode.IsPrint(r)
+		}) < 0
+		if !plain {
+			w = strconv.Quote(w)
+		}
+		words = append(words, w)
+	}
+	return strings.Join(words, " ")
+}
+
+// ReplayMismatch is a command that responded differently on replay.
+type ReplayMismatch struct {
+	// The line of the recording it is on.
+	Line     int
+	Command  string
+	Recorded string
+	Replayed string
+}
+
+func (m *ReplayMismatch) Error() string {
+	return fmt.Sprintf("replay: line %d: %s: recorded %s, replayed %s", m.Line, m.Command, m.Recorded, m.Replayed)
+}
+
+// Replay runs the commands recorded in r on d, on connections of its own,
+// returning a *ReplayMismatch for the first that responds differently.
+// The connections are closed when it returns.
+func Replay(r io.Reader, d *Database) error {
+	conns := map[string]*Connection{}
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<30)
+	for n := 1; scanner.Scan(); n++ {
+		line, want, ok := strings.Cut(scanner.Text(), "\t")
+		id, commandLine, ok2 := strings.Cut(line, " ")
+		if !ok || !ok2 {
+			return fmt.Errorf("replay: line %d: expected a connection, a command and a response", n)
+		}
+		words, err := parseCommandLine(commandLine)
+		if err != nil || len(words) == 0 {
+			return fmt.Errorf("replay: line %d: bad command line %q", n, commandLine)
+		}
+
+		c := conns[id]
+		if c == nil {
+			c = d.newConnection()
+			conns[id] = c
+		}
+		var got string
+		if words[0] == "close" {
+			got = responseLine(Result{}, c.Close())
+		} else {
+			res, err := c.execCommand(words[0], words[1:])
+			got = responseLine(res, err)
+		}
+		if got != want {
+			return &ReplayMismatch{Line: n, Command: commandLine, Recorded: want, Replayed: got}
+		}
+	}
+	return scanner.Err()
+}
+
+// runReplay runs the replay subcommand, which replays the recordings
+// named by args, each on a fresh database in memory.
+func runReplay(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("replay: expected recordings to replay")
+	}
+	for _, path := range args {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		database := newDatabase()
+		err = Replay(f, &database)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Fprintf(out, "%s: ok\n", path)
+	}
+	return nil
+}
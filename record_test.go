@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecording(t *testing.T) {
+	var recording bytes.Buffer
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	database.apply(WithRecording(&recording))
+
+	c1, c2 := database.newConnection(), database.newConnection()
+	c1.mustExecCommand("set", []string{"x", "hello world"})
+	c1.mustExecCommand("begin", nil)
+	c2.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", ""})
+	c2.mustExecCommand("set", []string{"x", "\"quoted\"\n"})
+	c1.mustExecCommand("commit", nil)
+	c2.execCommand("commit", nil)
+	c2.execCommand("get", []string{"y"})
+	c2.Close()
+	c2.execCommand("get", []string{"x"})
+
+	lines := strings.Split(strings.TrimSuffix(recording.String(), "\n"), "\n")
+	assertEq(len(lines), 10, "a line per command")
+	assertEq(lines[0], "1 set x \"hello world\"\tOK \"hello world\"", "quoted")
+	assertEq(lines[4], "2 set x \"\\\"quoted\\\"\\n\"\tOK \"\\\"quoted\\\"\\n\"", "escaped")
+	assert(strings.HasPrefix(lines[6], "2 commit\tERR "), "conflict")
+	assertEq(lines[7], "2 get y\tNIL", "not found")
+	assertEq(lines[8], "2 close\tOK \"\"", "closed")
+	assertEq(lines[9], "2 get x\tERR connection is closed", "after closing")
+
+	// A fresh database answers the same.
+	replayed := newDatabase()
+	replayed.defaultIsolation = SnapshotIsolation
+	assertEq(Replay(strings.NewReader(recording.String()), &replayed), nil, "replay")
+
+	// At a level that allows the concurrent write, it doesn't.
+	replayed = newDatabase()
+	var mismatch *ReplayMismatch
+	err := Replay(strings.NewReader(recording.String()), &replayed)
+	assert(errors.As(err, &mismatch), "mismatch")
+	assertEq(mismatch.Line, 7, "line")
+	assertEq(mismatch.Replayed, "OK \"\"", "replayed")
+}
+
+func TestRunReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording")
+	os.WriteFile(path, []byte("1 set x one\tOK \"one\"\n1 get x\tOK \"one\"\n"), 0o644)
+	var out bytes.Buffer
+	assertEq(runReplay([]string{path}, &out), nil, "replay")
+	assertEq(out.String(), path+": ok\n", "output")
+
+	os.WriteFile(path, []byte("1 get x\tOK \"one\"\n"), 0o644)
+	err := runReplay([]string{path}, &out)
+	assert(err != nil && strings.Contains(err.Error(), "line 1: get x: recorded OK \"one\", replayed NIL"), "mismatch")
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+/*
+A database opened on a data directory logs to a FileWAL there, and on
+opening loads the latest checkpoint (see checkpoint.go) and replays
+whatever the log holds after it to rebuild the store.
+
+Replay runs the logged writes again, in log order, under the ids of the
+transactions that made them. A transaction counts as committed from the
+point in the log where its commit record is, so each write sees what Read
+Committed would have let it see when it first ran, and ends the same
+versions it ended then. Transactions with no commit record, whether they
+aborted or were cut off by the crash, end up aborted, and their versions
+are vacuumed away before the database is handed over.
+
+(At Repeatable Read and stricter a transaction's snapshot may have been
+older than that. Replay can differ then only for concurrent writes to the
+same key, which Snapshot isolation and stricter would have refused to
+commit.)
+*/
+
+// NewDatabase opens the database kept in dir, creating the directory if
+// need be and recovering whatever was committed there before.
+func NewDatabase(dir string, opts ...Option) (*Database, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	cp, err := readCheckpoint(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		return nil, err
+	}
+
+	wal, err := OpenFileWAL(filepath.Join(dir, "wal"))
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil {
+		// The log may have been truncated right up to the checkpoint.
+		wal.nextLSN = max(wal.nextLSN, cp.LSN+1)
+	}
+
+	d := new(Database)
+	*d = newDatabase()
+	d.apply(opts...)
+	wal.logger = d.logger
+	wal.faults = d.faults
+	if err := d.recover(cp, wal); err != nil {
+		wal.Close()
+		return nil, err
+	}
+	d.dir = dir
+	d.wal = wal
+	return d, nil
+}
+
+// Close closes the database's write-ahead log and segment files, if it
+// has them.
+func (d *Database) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var errs []error
+	if d.wal != nil {
+		errs = append(errs, d.wal.Close())
+		d.wal = nil
+	}
+	if d.segments != nil {
+		errs = append(errs, d.segments.close())
+		d.segments = nil
+	}
+	return errors.Join(errs...)
+}
+
+// flush makes the log, and the segments values are kept in, durable.
+func (d *Database) flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var errs []error
+	if wal, ok := d.wal.(*FileWAL); ok {
+		errs = append(errs, wal.Flush())
+	} else if d.wal != nil {
+		errs = append(errs, d.wal.Sync())
+	}
+	if d.segments != nil {
+		errs = append(errs, d.segments.sync())
+	}
+	return errors.Join(errs...)
+}
+
+// recover loads the checkpoint, if there is one, into the empty database
+// and replays the log after it. It is not logged to again while it does.
+func (d *Database) recover(cp *checkpoint, wal WAL) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	running := map[uint64]*Transaction{}
+	var after uint64
+	if cp != nil {
+		running = d.loadCheckpoint(cp)
+		after = cp.LSN
+	}
+
+	err := wal.Replay(func(rec WALRecord) error {
+		if rec.LSN <= after {
+			return nil
+		}
+		return d.replay(rec, running, false)
+	})
+	if err != nil {
+		return err
+	}
+
+	for id, t := range running {
+		if t.root().prepared != "" {
+			d.logger.Info("recovered prepared transaction", "tx", id, "gid", t.root().prepared)
+			continue
+		}
+		d.logger.Debug("discarding unfinished transaction", "tx", id)
+		t.state = AbortedTransaction
+		d.clog.record(id, t.state)
+		d.running.Delete(id)
+	}
+
+	d.pruneTransactions()
+	p := d.newVacuumPass()
+	d.vacuumStep(p, 0)
+	d.finishVacuum(p)
+	return nil
+}
+
+// replay applies rec, the next record in a log, to the database. running
+// holds the transactions the log has begun and not yet finished, by their
+// ids in the log. In recovery those are their ids in the database too; a
+// follower renumbers them instead, since it begins transactions of its
+// own (see replication.go).
+func (d *Database) replay(rec WALRecord, running map[uint64]*Transaction, renumber bool) error {
+	if rec.Type == WALBegin {
+		if _, ok := running[rec.TxId]; ok {
+			// Found running by a checkpoint before it wrote anything.
+			return nil
+		}
+		t := &Transaction{
+			isolation: ReadCommitedIsolation,
+			id:        rec.TxId,
+			state:     InProgressTransaction,
+			db:        d,
+			logged:    true,
+		}
+		if renumber {
+			t.id = d.nextTransactionId
+		}
+		if rec.Value != "" {
+			parentId, err := strconv.ParseUint(rec.Value, 10, 64)
+			if t.parent = running[parentId]; err != nil || t.parent == nil {
+				return fmt.Errorf("%w: record %d nests transaction %d in %q, which is not running", ErrCorruptWAL, rec.LSN, rec.TxId, rec.Value)
+			}
+			t.parent.child = t
+		}
+		d.transactions.Set(t.id, t)
+		d.running.Insert(t.id)
+		d.nextTransactionId = max(d.nextTransactionId, t.id+1)
+		running[rec.TxId] = t
+		return nil
+	}
+
+	t, ok := running[rec.TxId]
+	if !ok {
+		return fmt.Errorf("%w: record %d for transaction %d, which is not running", ErrCorruptWAL, rec.LSN, rec.TxId)
+	}
+
+	switch rec.Type {
+	case WALSet:
+		t.write(rec.Key, rec.Value, rec.ValueType, rec.ExpiresAt)
+	case WALDelete:
+		// If it failed now, it failed then, and was never logged.
+		t.delete(rec.Key)
+	case WALSavepoint:
+		t.savepoint(rec.Key)
+	case WALRollbackTo:
+		if err := t.rollbackTo(rec.Key); err != nil {
+			return fmt.Errorf("%w: record %d: %w", ErrCorruptWAL, rec.LSN, err)
+		}
+	case WALRelease:
+		if err := t.release(rec.Key); err != nil {
+			return fmt.Errorf("%w: record %d: %w", ErrCorruptWAL, rec.LSN, err)
+		}
+	case WALMerge:
+		if t.parent == nil {
+			return fmt.Errorf("%w: record %d merges transaction %d, which is not nested", ErrCorruptWAL, rec.LSN, rec.TxId)
+		}
+		d.merge(t)
+		return nil
+	case WALPrepare:
+		d.addPrepared(t, rec.Key)
+		return nil
+	case WALCommit:
+		delete(d.prepared, t.prepared)
+		t.state = CommittedTransaction
+		d.clog.record(t.id, t.state)
+		// Commit records from before commit timestamps have none.
+		if rec.Value != "" {
+			ts, err := strconv.ParseUint(rec.Value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: record %d has a bad commit timestamp", ErrCorruptWAL, rec.LSN)
+			}
+			t.committedAt = HLC(ts)
+			d.observeHLC(t.committedAt)
+		}
+	case WALAbort:
+		delete(d.prepared, t.prepared)
+		t.state = AbortedTransaction
+		d.clog.record(t.id, t.state)
+	default:
+		return fmt.Errorf("%w: record %d has unknown type %s", ErrCorruptWAL, rec.LSN, rec.Type)
+	}
+
+	if t.state != InProgressTransaction {
+		if t.parent != nil {
+			t.parent.child = nil
+		}
+		d.running.Delete(t.id)
+		delete(running, rec.TxId)
+		d.settleMerged(t)
+		if t.state == CommittedTransaction {
+			d.sealEnds(t)
+		}
+		if t.merged.Len() > 0 {
+			for id, m := range running {
+				if t.merged.Contains(m.id) {
+					delete(running, id)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// crash drops the database without completing anything, as if the
+// process had been killed.
+func crash(d *Database) {
+	d.mu.Lock()
+	d.wal.Close()
+	d.wal = nil
+	d.mu.Unlock()
+}
+
+func TestRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("set", []string{"x", "one"})
+	c1.mustExecCommand("set", []string{"y", "gone"})
+	c1.mustExecCommand("delete", []string{"y"})
+
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"z", "rolled back"})
+	c1.mustExecCommand("abort", nil)
+
+	// Killed mid-transaction: none of this may survive.
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+	c2.mustExecCommand("set", []string{"x", "half done"})
+	c2.mustExecCommand("set", []string{"w", "half done"})
+
+	c1.mustExecCommand("set", []string{"v", "after"})
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+
+	c3 := database.newConnection()
+	assertEq(c3.mustExecCommand("get", []string{"x"}), "one", "committed set")
+	assertEq(c3.mustExecCommand("get", []string{"v"}), "after", "committed after the crashed transaction began")
+	for _, key := range []string{"y", "z", "w"} {
+		_, err := c3.execCommand("get", []string{key})
+		assertEq(err, ErrKeyNotFound, "not recovered: "+key)
+	}
+
+	// The unfinished transaction's versions are gone, and new ids don't
+	// reuse old ones.
+	assertEq(len(database.versions("x")), 1, "versions of x")
+	c3.mustExecCommand("begin", nil)
+	assert(c3.tx.id > c2.tx.id, "fresh transaction id")
+}
+
+func TestRecovery_tornCommit(t *testing.T) {
+	dir := t.TempDir()
+
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "one"})
+	c.mustExecCommand("set", []string{"x", "two"})
+	database.Close()
+
+	// Cut the last commit record short.
+	path := filepath.Join(dir, "wal")
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-3)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	c = database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"x"}), "one", "torn commit discarded")
+}
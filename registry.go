@@ -0,0 +1,39 @@
+package main
+
+/*
+Every transaction goes into the registry when it begins, so that others
+can look up whether it committed. Nobody needs the entry itself once the
+transaction is below the horizon (see vacuum.go): no snapshot treats it as
+in progress any more, and conflict detection only looks at transactions
+that finished during some in-progress transaction's life.
+
+All that is left to remember about a pruned transaction is its outcome,
+and nearly all transactions commit. So pruned transactions count as
+committed unless their id is in the aborted set, which vacuum empties
+once no version refers to them. Reads as of a pruned transaction also
+need its snapshot, which is kept apart until vacuum removes a version it
+could see (see asof.go).
+*/
+
+// pruneTransactions drops the registry entries below the horizon, or the
+// retention window if that reaches further back, and the commit log's
+// outcomes for them, keeping their snapshots.
+func (d *Database) pruneTransactions() {
+	horizon := d.retentionHorizon()
+
+	var ids []uint64
+	iter := d.transactions.Iter()
+	for ok := iter.First(); ok && iter.Key() < horizon; ok = iter.Next() {
+		ids = append(ids, iter.Key())
+		d.pastSnapshots.Set(iter.Key(), pastSnapshot{iter.Value().started, iter.Value().inprogress})
+		if iter.Value().state == AbortedTransaction {
+			d.aborted.Insert(iter.Key())
+		} else {
+			d.hlcPruned = max(d.hlcPruned, iter.Value().committedAt)
+		}
+	}
+	for _, id := range ids {
+		d.transactions.Delete(id)
+	}
+	d.clog.truncate(horizon)
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestPruneTransactions(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = RepeatableReadIsolation
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("set", []string{"x", "one"})
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"y", "rolled back"})
+	c1.mustExecCommand("abort", nil)
+	assertEq(database.transactions.Len(), 0, "nothing running, nothing kept")
+	assert(database.aborted.Contains(2), "outcome of the abort kept")
+
+	// A long-running transaction pins everything from its id on.
+	long := database.newConnection()
+	long.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "two"})
+	assertEq(database.transactions.Len(), 2, "pinned by the long transaction")
+	assertEq(long.mustExecCommand("get", []string{"x"}), "one", "snapshot intact")
+
+	long.mustExecCommand("commit", nil)
+	assertEq(database.transactions.Len(), 0, "unpinned")
+
+	// Pruned transactions keep their outcome.
+	assertEq(c1.mustExecCommand("get", []string{"x"}), "two", "committed value")
+	_, err := c1.execCommand("get", []string{"y"})
+	assertEq(err, ErrKeyNotFound, "aborted value")
+}
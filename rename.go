@@ -0,0 +1,87 @@
+package main
+
+import "errors"
+
+/*
+rename and copy are reads and writes like any others, just bundled into a
+single statement: the source lands in the readset, the destination (and,
+for rename, the source) in the writeset. So they conflict, and are
+isolated, exactly as the equivalent get/set/delete sequence would be.
+*/
+
+// Rename moves the value of oldKey, and when it expires, to newKey,
+// replacing whatever newKey held.
+func (t *Transaction) Rename(oldKey, newKey string) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return err
+	}
+
+	value, err := t.get(oldKey)
+	if err != nil {
+		return err
+	}
+	if oldKey == newKey {
+		return nil
+	}
+
+	raw, err := t.db.readRaw(value)
+	if err != nil {
+		return err
+	}
+	kind, expiresAt := value.data.kind, value.expiresAt
+	t.delete(oldKey)
+	t.write(newKey, raw, kind, expiresAt)
+	return nil
+}
+
+// Copy copies the value of src, and when it expires, to dst. Unless
+// replace is set, it does nothing if dst already has a value, and reports
+// whether it copied.
+func (t *Transaction) Copy(src, dst string, replace bool) (bool, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return false, err
+	}
+
+	value, err := t.get(src)
+	if err != nil {
+		return false, err
+	}
+	if !replace {
+		if _, err := t.get(dst); err == nil {
+			return false, nil
+		}
+	}
+
+	raw, err := t.db.readRaw(value)
+	if err != nil {
+		return false, err
+	}
+	t.write(dst, raw, value.data.kind, value.expiresAt)
+	return true, nil
+}
+
+// rename old new
+func (c *Connection) rename(args []string) (Result, error) {
+	res := Result{Keys: args[:2], TxId: c.tx.id}
+	err := c.tx.Rename(args[0], args[1])
+	res.NotFound = errors.Is(err, ErrKeyNotFound)
+	return res, err
+}
+
+// copy src dst [replace]
+func (c *Connection) copy(args []string) (Result, error) {
+	replace := len(args) > 2 && args[2] == "replace"
+	res := Result{Keys: args[:2], TxId: c.tx.id, Value: "0"}
+	ok, err := c.tx.Copy(args[0], args[1], replace)
+	if ok {
+		res.Value = "1"
+	}
+	res.NotFound = errors.Is(err, ErrKeyNotFound)
+	return res, err
+}
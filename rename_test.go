@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRenameAndCopy(t *testing.T) {
+	database := newDatabase()
+	database.Set("a", "1")
+	database.Set("b", "2")
+
+	c := database.newConnection()
+	c.mustExecCommand("rename", []string{"a", "b"})
+	assertEq(c.mustExecCommand("mget", []string{"a", "b"}), "a\nb=1", "after rename")
+
+	res, err := c.execCommand("rename", []string{"a", "c"})
+	assert(errors.Is(err, ErrKeyNotFound), "rename missing")
+	assertEq(res.NotFound, true, "rename missing")
+
+	assertEq(c.mustExecCommand("copy", []string{"b", "c"}), "1", "copy to new key")
+	database.Set("b", "3")
+	assertEq(c.mustExecCommand("copy", []string{"b", "c"}), "0", "copy onto existing key")
+	assertEq(c.mustExecCommand("copy", []string{"b", "c", "replace"}), "1", "copy replace")
+	assertEq(c.mustExecCommand("mget", []string{"b", "c"}), "b=3\nc=3", "after copy")
+}
+
+func TestRenameAndCopy_ttl(t *testing.T) {
+	database := newDatabase()
+	now := time.Unix(1_000_000, 0)
+	database.now = func() time.Time { return now }
+	database.Set("a", "1")
+
+	c := database.newConnection()
+	c.mustExecCommand("expire", []string{"a", "10"})
+	c.mustExecCommand("rename", []string{"a", "b"})
+	assertEq(c.mustExecCommand("ttl", []string{"b"}), "10", "ttl after rename")
+	c.mustExecCommand("copy", []string{"b", "c"})
+	assertEq(c.mustExecCommand("ttl", []string{"c"}), "10", "ttl after copy")
+
+	now = now.Add(10 * time.Second)
+	_, err := c.execCommand("get", []string{"c"})
+	assert(errors.Is(err, ErrKeyNotFound), "copy expires")
+}
+
+func TestRename_SnapshotIsolation_conflict(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	database.Set("a", "1")
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+
+	c1.mustExecCommand("rename", []string{"a", "b"})
+	c2.mustExecCommand("set", []string{"a", "2"})
+
+	c2.mustExecCommand("commit", nil)
+	_, err := c1.execCommand("commit", nil)
+	assertEq(err.Error(), `write-write conflict with transaction 3 on "a"`, "c1 commit")
+}
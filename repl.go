@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+Run without any addresses to listen on, the binary is a REPL over an
+in-memory (or -dir) database. Commands are written as in the line protocol
+(see server.go), SQL included, and run on the current connection. Several
+connections can be open at once, to watch transactions interleave:
+
+	\c n    switch to connection n, opening it if need be
+	\l      list the open connections
+	\q      quit, aborting whatever is still in progress
+
+The prompt shows the connection, and its transaction if it has one.
+*/
+
+type repl struct {
+	db      *Database
+	out     io.Writer
+	conns   map[int]*Connection
+	current int
+}
+
+// exec runs line, a command or SQL (see sql.go), on the current
+// connection.
+func (r *repl) exec(line string) (Result, error) {
+	c := r.conns[r.current]
+	if isSQL(line) {
+		return c.execSQL(line)
+	}
+	words, err := parseCommandLine(line)
+	if err != nil {
+		return Result{}, err
+	}
+	return c.execCommand(words[0], words[1:])
+}
+
+// runREPL reads commands from in until it ends or \q.
+func runREPL(db *Database, in io.Reader, out io.Writer) {
+	r := &repl{db: db, out: out, conns: map[int]*Connection{}}
+	r.switchTo(1)
+	defer func() {
+		for _, c := range r.conns {
+			c.Close()
+		}
+	}()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 16<<20)
+	for {
+		r.prompt()
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, `\`) {
+			if !r.meta(line) {
+				return
+			}
+			continue
+		}
+
+		res, err := r.exec(line)
+		switch {
+		case res.NotFound:
+			fmt.Fprintln(out, "(nil)")
+		case err != nil:
+			fmt.Fprintf(out, "error: %s\n", err)
+		case res.Value == "":
+			fmt.Fprintln(out, "ok")
+		default:
+			fmt.Fprintln(out, res.Value)
+		}
+	}
+}
+
+func (r *repl) prompt() {
+	if tx := r.conns[r.current].tx; tx != nil {
+		fmt.Fprintf(r.out, "%d (tx %d)> ", r.current, tx.id)
+		return
+	}
+	fmt.Fprintf(r.out, "%d> ", r.current)
+}
+
+func (r *repl) switchTo(n int) {
+	if r.conns[n] == nil {
+		r.conns[n] = r.db.newConnection()
+	}
+	r.current = n
+}
+
+// meta runs a backslash command, reporting whether to carry on.
+func (r *repl) meta(line string) bool {
+	cmd, arg, _ := strings.Cut(line, " ")
+	switch cmd {
+	case `\q`:
+		return false
+	case `\c`:
+		n, err := strconv.Atoi(strings.TrimSpace(arg))
+		if err != nil || n < 1 {
+			fmt.Fprintln(r.out, `error: \c takes a connection number`)
+			return true
+		}
+		r.switchTo(n)
+	case `\l`:
+		for _, n := range slices.Sorted(maps.Keys(r.conns)) {
+			state := "idle"
+			if tx := r.conns[n].tx; tx != nil {
+				state = fmt.Sprintf("in transaction %d", tx.id)
+			}
+			fmt.Fprintf(r.out, "%d: %s\n", n, state)
+		}
+	default:
+		fmt.Fprintf(r.out, "error: unknown command %s; try \\c n, \\l or \\q\n", cmd)
+	}
+	return true
+}
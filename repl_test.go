@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	database := newDatabase()
+	var out strings.Builder
+	runREPL(&database, strings.NewReader(`set x "hello world"
+begin
+set x changed
+\c 2
+get x
+get missing
+\l
+\c 1
+commit
+\c 2
+get x
+bogus
+\x
+\q
+get x
+`), &out)
+
+	assertEq(out.String(), `1> hello world
+1> 2
+1 (tx 2)> changed
+1 (tx 2)> 2> hello world
+2> (nil)
+2> 1: in transaction 2
+2: idle
+2> 1 (tx 2)> ok
+1> 2> changed
+2> error: unknown command: bogus
+2> error: unknown command \x; try \c n, \l or \q
+2> `, "transcript")
+	assert(!database.hasInProgress(), "nothing left in progress")
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Replication keeps copies of a database on other machines, for reads that
+the leader needn't serve and for a spare that is nearly up to date. The
+leader ships its write-ahead log to followers as it is appended; each
+follower applies the records as recovery would (see recovery.go), so a
+transaction the leader committed becomes visible there all at once, when
+its commit record arrives. Followers serve transactions of their own, but
+only reads: one that writes anything fails to commit.
+
+A follower connects to the leader's replication server and says how far
+into the log it has applied. The leader sends it the rest of the log, or,
+if a checkpoint has truncated away records it needs, a snapshot of the
+database in the form of a checkpoint (see checkpoint.go) to start over
+from. Then it sends records as they are appended, commit records only
+once they are durable, and heartbeats with the last record's position
+while it is idle. The follower acknowledges how far it has applied each
+time it has caught up with what arrived, so both ends know how far
+behind it is.
+
+Every message is a frame (see format.go). From the follower, the first
+has a magic string, the name and password of the user it authenticates
+as, and the position it has applied; the rest just the position. From the leader, each begins with its kind: a record, with the
+record as the log has it; a heartbeat, with the position and a commit
+timestamp (see followerread.go); or a snapshot, followed by the
+checkpoint's frames.
+
+A follower that can't keep up is cut off, and catches up again when it
+reconnects, as one would after losing its connection. A server that
+requires authentication (see auth.go) only takes followers that
+authenticate as an admin, as they get every key.
+*/
+
+const replicationMagic = "MVCCREPL"
+
+// The kinds of message the leader sends.
+const (
+	replRecord    = 'r'
+	replHeartbeat = 'h'
+	replSnapshot  = 's'
+)
+
+const (
+	// How often an idle leader tells followers where the log is.
+	replicationHeartbeat = time.Second
+	// How many records a follower may have outstanding before it is cut
+	// off.
+	replicaBuffer = 4096
+)
+
+// replica is the leader's end of a follower's connection.
+type replica struct {
+	addr string
+	// Records shipped to the follower and not yet sent, until ship closes
+	// it to cut the follower off.
+	records chan WALRecord
+	// The last record the follower has said it applied.
+	acked atomic.Uint64
+}
+
+// ReplicaStatus describes a follower as its leader sees it.
+type ReplicaStatus struct {
+	Addr     string
+	AckedLSN uint64
+	// How many records behind the leader's log it is.
+	Lag uint64
+}
+
+// ReplicationStatus describes a database's part in replication.
+type ReplicationStatus struct {
+	// The last record in the log, or for a follower, the last applied.
+	LSN uint64
+	// The followers connected to a leader, by address.
+	Replicas []ReplicaStatus
+
+	// For a follower: its leader, whether it is connected, the last
+	// record the leader has, as far as the follower knows, and when it
+	// last heard from the leader.
+	Leader      string
+	Connected   bool
+	LeaderLSN   uint64
+	LastContact time.Time
+	// And the commit timestamp it has replicated everything up to.
+	Resolved HLC
+}
+
+// Lag returns how many records behind its leader a follower is.
+func (s ReplicationStatus) Lag() uint64 {
+	return s.LeaderLSN - min(s.LSN, s.LeaderLSN)
+}
+
+// NewReplicationServer returns a server shipping the database's log to
+// followers. The database must have a data directory, for the log.
+func NewReplicationServer(db *Database) *Server {
+	return newServer(db, serveReplication)
+}
+
+func serveReplication(c *Connection, r *bufio.Reader, w *bufio.Writer) {
+	d := c.db
+	body, _, err := readFrame(r)
+	br := frameReader{b: body}
+	if err == nil && br.string() != replicationMagic {
+		err = errors.New("not a follower")
+	}
+	name, password := br.string(), br.string()
+	from := br.uvarint()
+	if err == nil {
+		err = br.done()
+	}
+	if err == nil && c.users != nil {
+		u, found := c.users[name]
+		if !passwordsMatch(u.Password, password) || !found {
+			err = ErrBadCredentials
+		} else if !u.Admin {
+			err = fmt.Errorf("%w: following is for admins only", ErrPermissionDenied)
+		}
+	}
+	if err != nil {
+		d.logger.Warn("refused follower", "follower", c.remote, "err", err)
+		return
+	}
+
+	rep := &replica{addr: c.remote, records: make(chan WALRecord, replicaBuffer)}
+	rep.acked.Store(from)
+	cp, records, heartbeat, err := d.catchUp(rep, from)
+	if err != nil {
+		d.logger.Warn("can't catch follower up", "follower", c.remote, "err", err)
+		return
+	}
+	defer d.dropReplica(rep)
+	d.logger.Info("follower connected", "follower", c.remote, "from", from, "snapshot", cp != nil)
+
+	// Reading the acknowledgements is also how the follower going away is
+	// noticed.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			body, _, err := readFrame(r)
+			if err != nil {
+				return
+			}
+			br := frameReader{b: body}
+			lsn := br.uvarint()
+			if br.done() != nil {
+				return
+			}
+			rep.acked.Store(lsn)
+		}
+	}()
+
+	w.Write(heartbeat)
+	if cp != nil {
+		w.Write(appendFrame(nil, frameBody{replSnapshot}))
+		if err := encodeCheckpoint(w, cp); err != nil {
+			return
+		}
+	}
+	for _, rec := range records {
+		w.Write(recordFrame(rec))
+	}
+	if w.Flush() != nil {
+		return
+	}
+
+	ticker := time.NewTicker(replicationHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case rec, ok := <-rep.records:
+			if !ok {
+				return
+			}
+			w.Write(recordFrame(rec))
+			if len(rep.records) > 0 {
+				continue
+			}
+		case <-ticker.C:
+			w.Write(d.heartbeat())
+		case <-gone:
+			return
+		}
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func recordFrame(rec WALRecord) []byte {
+	return appendFrame(nil, append(frameBody{replRecord}, encodeWALRecord(rec)...))
+}
+
+// heartbeat returns a heartbeat with the position of the log's last
+// record, and a commit timestamp that whatever commits from now on will
+// be stamped after (see followerread.go).
+func (d *Database) heartbeat() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.heartbeatFrame()
+}
+
+func (d *Database) heartbeatFrame() []byte {
+	var lsn uint64
+	if d.wal != nil {
+		lsn = d.wal.LastLSN()
+	}
+	return appendFrame(nil, frameBody{replHeartbeat}.uvarint(lsn).uvarint(uint64(d.tickHLC())))
+}
+
+// catchUp ships rep whatever is appended to the log from now on, and
+// returns what a follower that has applied the log up to from needs
+// first: the rest of the log, or if the log no longer has all of it, a
+// snapshot as of its last record. It also returns a heartbeat as of that
+// record.
+func (d *Database) catchUp(rep *replica, from uint64) (*checkpoint, []WALRecord, []byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.wal == nil {
+		return nil, nil, nil, errors.New("database has no write-ahead log")
+	}
+	last := d.wal.LastLSN()
+
+	var records []WALRecord
+	if from < last {
+		err := d.wal.Replay(func(rec WALRecord) error {
+			if rec.LSN > from {
+				records = append(records, rec)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var cp *checkpoint
+	// A follower ahead of the log applied records lost in a crash, before
+	// they were synced, so it has to start over too.
+	if from > last || (from < last && (len(records) == 0 || records[0].LSN > from+1)) {
+		if d.holdsSavepoints() {
+			return nil, nil, nil, fmt.Errorf("snapshot: %w", ErrSavepointsHeld)
+		}
+		if d.hasNested() {
+			return nil, nil, nil, fmt.Errorf("snapshot: %w", ErrNestedInProgress)
+		}
+		var err error
+		if cp, err = d.takeCheckpoint(); err != nil {
+			return nil, nil, nil, fmt.Errorf("snapshot: %w", err)
+		}
+		// Transactions that haven't written anything won't log how they
+		// end either, so the follower mustn't wait for them. If they do
+		// write, their begin record introduces them.
+		cp.Running = slices.DeleteFunc(cp.Running, func(id uint64) bool {
+			t, _ := d.transactions.Get(id)
+			return !t.logged
+		})
+		records = nil
+	}
+
+	if d.replicas == nil {
+		d.replicas = map[*replica]struct{}{}
+	}
+	d.replicas[rep] = struct{}{}
+	return cp, records, d.heartbeatFrame(), nil
+}
+
+func (d *Database) dropReplica(rep *replica) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.replicas, rep)
+}
+
+// ship sends rec, just appended to the log, to the followers. One too
+// far behind to take it is cut off.
+func (d *Database) ship(rec WALRecord) {
+	for rep := range d.replicas {
+		select {
+		case rep.records <- rec:
+		default:
+			d.logger.Warn("follower fell behind", "follower", rep.addr)
+			delete(d.replicas, rep)
+			close(rep.records)
+		}
+	}
+}
+
+// ReplicationStatus reports on the database's followers, or if it is a
+// follower, on how far behind its leader it is.
+func (d *Database) ReplicationStatus() ReplicationStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var s ReplicationStatus
+	if d.wal != nil {
+		s.LSN = d.wal.LastLSN()
+	}
+	for rep := range d.replicas {
+		acked := rep.acked.Load()
+		s.Replicas = append(s.Replicas, ReplicaStatus{rep.addr, acked, s.LSN - min(acked, s.LSN)})
+	}
+	slices.SortFunc(s.Replicas, func(a, b ReplicaStatus) int { return cmp.Compare(a.Addr, b.Addr) })
+
+	if f := d.follower; f != nil {
+		s.LSN = f.applied
+		s.Leader = f.leader
+		s.Connected = f.connected
+		s.LeaderLSN = f.leaderLSN
+		s.LastContact = f.lastContact
+		s.Resolved = f.resolved
+	}
+	return s
+}
+
+// replication
+func (c *Connection) replication() (Result, error) {
+	s := c.db.ReplicationStatus()
+	lines := []string{fmt.Sprintf("lsn %d", s.LSN)}
+	if s.Leader != "" {
+		lines = append(lines,
+			fmt.Sprintf("leader %s", s.Leader),
+			fmt.Sprintf("connected %t", s.Connected),
+			fmt.Sprintf("leader_lsn %d", s.LeaderLSN),
+			fmt.Sprintf("lag %d", s.Lag()),
+		)
+		if !s.LastContact.IsZero() {
+			lines = append(lines, fmt.Sprintf("last_contact %s", s.LastContact.Format(time.RFC3339)))
+		}
+		if s.Resolved != 0 {
+			lines = append(lines, fmt.Sprintf("resolved %s", s.Resolved))
+		}
+	}
+	for _, rep := range s.Replicas {
+		lines = append(lines, fmt.Sprintf("follower %s acked %d lag %d", rep.Addr, rep.AckedLSN, rep.Lag))
+	}
+	return Result{Value: strings.Join(lines, "\n")}, nil
+}
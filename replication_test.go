@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startReplicationServer(db *Database) (*Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assertEq(err, nil, "listen")
+	srv := NewReplicationServer(db)
+	go srv.Serve(ln)
+	return srv, ln.Addr().String()
+}
+
+// caughtUp waits until follower has applied everything in leader's log.
+func caughtUp(leader, follower *Database) {
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		s := follower.ReplicationStatus()
+		if s.LSN == leader.ReplicationStatus().LSN && s.Lag() == 0 {
+			return
+		}
+		assert(time.Now().Before(deadline), "follower caught up")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	leader, err := NewDatabase(t.TempDir())
+	assertEq(err, nil, "open")
+	defer leader.Close()
+	srv, addr := startReplicationServer(leader)
+	defer srv.Close()
+
+	c := leader.newConnection()
+	c.mustExecCommand("set", []string{"x", "before"})
+	c.mustExecCommand("rpush", []string{"l", "a", "b"})
+
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	f, err := database.Follow(addr)
+	assertEq(err, nil, "follow")
+	defer f.Close()
+	caughtUp(leader, &database)
+	follower := database.newConnection()
+	assertEq(follower.mustExecCommand("get", []string{"x"}), "before", "caught up from the log")
+	assertEq(follower.mustExecCommand("lrange", []string{"l", "0", "-1"}), "a\nb", "with types")
+
+	// A snapshot on the follower sees only what had committed when it
+	// began.
+	snapshot := database.newConnection()
+	snapshot.mustExecCommand("begin", nil)
+	tx, _ := leader.Begin()
+	tx.Set("x", "after")
+	tx.Set("y", "after")
+	caughtUp(leader, &database)
+	_, err = follower.execCommand("get", []string{"y"})
+	assertEq(err, ErrKeyNotFound, "not committed yet")
+	assertEq(tx.Commit(), nil, "commit")
+	caughtUp(leader, &database)
+	assertEq(follower.mustExecCommand("get", []string{"y"}), "after", "committed")
+	assertEq(snapshot.mustExecCommand("get", []string{"x"}), "before", "snapshot")
+	_, err = snapshot.execCommand("get", []string{"y"})
+	assertEq(err, ErrKeyNotFound, "snapshot")
+	snapshot.mustExecCommand("commit", nil)
+
+	_, err = follower.execCommand("set", []string{"x", "local"})
+	assert(errors.Is(err, ErrReadOnly), "followers are read-only")
+	assertEq(follower.mustExecCommand("get", []string{"x"}), "after", "not written")
+
+	// Both ends know how far behind the follower is.
+	s := database.ReplicationStatus()
+	assertEq(s.Leader, addr, "leader")
+	assert(s.Connected, "connected")
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		s = leader.ReplicationStatus()
+		if len(s.Replicas) == 1 && s.Replicas[0].Lag == 0 {
+			break
+		}
+		assert(time.Now().Before(deadline), "acknowledged")
+		time.Sleep(time.Millisecond)
+	}
+	assertEq(s.Replicas[0].AckedLSN, s.LSN, "acked")
+	lines := strings.Split(follower.mustExecCommand("replication", nil), "\n")
+	lsn := strconv.FormatUint(s.LSN, 10)
+	assertEq(strings.Join(lines[:5], ","), "lsn "+lsn+",leader "+addr+",connected true,leader_lsn "+lsn+",lag 0", "replication")
+}
+
+func TestReplication_snapshot(t *testing.T) {
+	leader, err := NewDatabase(t.TempDir())
+	assertEq(err, nil, "open")
+	defer leader.Close()
+	srv, addr := startReplicationServer(leader)
+	defer srv.Close()
+
+	leader.Set("x", "checkpointed")
+	spanning, _ := leader.Begin()
+	spanning.Set("y", "spanning")
+	idle, _ := leader.Begin()
+	assertEq(leader.Checkpoint(), nil, "checkpoint")
+	leader.Set("z", "after")
+
+	// The log no longer starts at the beginning, so the follower starts
+	// from a snapshot.
+	database := newDatabase()
+	f, err := database.Follow(addr)
+	assertEq(err, nil, "follow")
+	defer f.Close()
+	caughtUp(leader, &database)
+	c := database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"x"}), "checkpointed", "from the snapshot")
+	assertEq(c.mustExecCommand("get", []string{"z"}), "after", "from the log")
+	_, err = c.execCommand("get", []string{"y"})
+	assertEq(err, ErrKeyNotFound, "not committed yet")
+
+	assertEq(spanning.Commit(), nil, "commit")
+	idle.Set("w", "idle")
+	assertEq(idle.Commit(), nil, "commit idle")
+	caughtUp(leader, &database)
+	assertEq(c.mustExecCommand("get", []string{"y"}), "spanning", "committed after the snapshot")
+	assertEq(c.mustExecCommand("get", []string{"w"}), "idle", "begun before the snapshot, written after")
+	database.mu.Lock()
+	running := database.running.Len()
+	database.mu.Unlock()
+	assertEq(running, 0, "nothing left running")
+}
+
+func TestReplication_reconnect(t *testing.T) {
+	leader, err := NewDatabase(t.TempDir())
+	assertEq(err, nil, "open")
+	defer leader.Close()
+	srv, addr := startReplicationServer(leader)
+
+	leader.Set("x", "one")
+	database := newDatabase()
+	f, err := database.Follow(addr)
+	assertEq(err, nil, "follow")
+	defer f.Close()
+	caughtUp(leader, &database)
+	reader, _ := database.Begin()
+
+	// The leader checkpoints while the follower is away, so it has to
+	// start over, and what its transactions saw is gone.
+	srv.Close()
+	leader.Set("x", "two")
+	assertEq(leader.Checkpoint(), nil, "checkpoint")
+	leader.Set("y", "three")
+	ln, err := net.Listen("tcp", addr)
+	assertEq(err, nil, "listen again")
+	srv = NewReplicationServer(leader)
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	caughtUp(leader, &database)
+	c := database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"x"}), "two", "x")
+	assertEq(c.mustExecCommand("get", []string{"y"}), "three", "y")
+	_, err = reader.Get("x")
+	assertEq(err, ErrTransactionAborted, "aborted by starting over")
+}
+
+func TestReplication_auth(t *testing.T) {
+	leader, err := NewDatabase(t.TempDir())
+	assertEq(err, nil, "open")
+	defer leader.Close()
+	leader.Set("x", "1")
+
+	cert, pool := selfSignedCert()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assertEq(err, nil, "listen")
+	srv := NewReplicationServer(leader)
+	srv.UseTLS(&tls.Config{Certificates: []tls.Certificate{cert}})
+	srv.RequireAuth([]User{{Name: "alice", Password: "pw", Admin: true}, {Name: "bob", Password: "pw"}})
+	go srv.Serve(ln)
+	defer srv.Close()
+	addr := ln.Addr().String()
+
+	// Only an admin can follow.
+	refused := func(name, password string) bool {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+		assertEq(err, nil, "dial")
+		defer conn.Close()
+		conn.Write(appendFrame(nil, frameBody(nil).string(replicationMagic).string(name).string(password).uvarint(0)))
+		_, _, err = readFrame(bufio.NewReader(conn))
+		return err != nil
+	}
+	assert(refused("", ""), "no credentials")
+	assert(refused("alice", "wrong"), "wrong password")
+	assert(refused("bob", "pw"), "not an admin")
+	assert(!refused("alice", "pw"), "admin")
+
+	database := newDatabase()
+	f, err := database.FollowWith(FollowConfig{Leader: addr, User: "alice", Password: "pw", TLS: &tls.Config{RootCAs: pool}})
+	assertEq(err, nil, "follow")
+	defer f.Close()
+	caughtUp(leader, &database)
+	assertEq(database.newConnection().mustExecCommand("get", []string{"x"}), "1", "followed over TLS")
+}
+
+func TestFollow_notEmpty(t *testing.T) {
+	database := newDatabase()
+	database.Set("x", "local")
+	_, err := database.Follow("127.0.0.1:1")
+	assert(err != nil, "only an empty database can follow")
+}
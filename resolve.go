@@ -0,0 +1,143 @@
+package main
+
+import "strings"
+
+/*
+Under Snapshot Isolation two transactions writing the same key conflict,
+and the second to commit is aborted, to be retried. For updates that
+commute, like adding to a counter or to a set, retrying is wasted work:
+the application could just as well combine the two writes. So it can
+register a resolver for keys with a given prefix, and a commit that would
+conflict on those keys calls it instead, with the value the key had in
+the committing transaction's snapshot (base), the value committed since
+(theirs), and the value the committing transaction wrote (ours). What it
+returns is written in place of ours, superseding theirs, and the commit
+goes on. Typed values (see types.go) are passed as strings, and the result
+has the type ours had, if it parses as one.
+
+Base is empty if the key didn't exist in the snapshot. If either side
+deleted the key, if the resolver fails, or if any conflicting key has no
+resolver, the commit fails with the conflict as before. Resolvers run
+while the database lock is held, so they must not use the database.
+Conflicts under Serializable isolation, and with transactions that
+haven't committed (see prepare.go and priority.go), are never resolved.
+*/
+
+// A Resolver combines two conflicting writes to key into one.
+type Resolver func(key, base, theirs, ours string) (string, error)
+
+type resolver struct {
+	prefix string
+	fn     Resolver
+}
+
+// WithResolver resolves write-write conflicts on keys starting with
+// prefix with fn. Where prefixes overlap the longest applies.
+func WithResolver(prefix string, fn Resolver) Option {
+	return func(d *Database) {
+		d.resolvers = append(d.resolvers, resolver{prefix, fn})
+	}
+}
+
+// resolverFor returns the resolver for key, if there is one.
+func (d *Database) resolverFor(key string) Resolver {
+	var found *resolver
+	for i, r := range d.resolvers {
+		if strings.HasPrefix(key, r.prefix) && (found == nil || len(r.prefix) > len(found.prefix)) {
+			found = &d.resolvers[i]
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	return found.fn
+}
+
+// resolve merges t's writes to keys with those committed since its
+// snapshot, reporting whether it could merge them all.
+func (d *Database) resolve(t *Transaction, keys []string) bool {
+	for _, key := range keys {
+		fn := d.resolverFor(key)
+		if fn == nil {
+			return false
+		}
+
+		base, theirs, ours := d.conflictingVersions(t, key)
+		if theirs == nil || ours == nil {
+			return false
+		}
+		values, err := d.readAll(base, theirs, ours)
+		if err != nil {
+			t.debug("can't read conflicting versions", "key", key, "err", err)
+			return false
+		}
+		merged, err := fn(key, values[0], values[1], values[2])
+		if err != nil {
+			t.debug("resolver failed", "key", key, "err", err)
+			return false
+		}
+
+		t.debug("resolved write-write conflict", "key", key)
+		if d.audit != nil {
+			// What the key held before is theirs, now.
+			old := values[1]
+			if t.audited == nil {
+				t.audited = map[string]*string{}
+			}
+			t.audited[key] = &old
+		}
+		kind := ours.data.kind
+		raw, ok := parseValue(kind, merged)
+		if !ok {
+			raw, kind = merged, StringType
+		}
+		t.supersede(key, raw, kind, ours.expiresAt)
+	}
+	return true
+}
+
+// conflictingVersions returns the version of key that t's snapshot saw,
+// the newest committed since, and the newest t wrote. Any may be nil.
+func (d *Database) conflictingVersions(t *Transaction, key string) (base, theirs, ours *Value) {
+	versions := d.versions(key)
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := &versions[i]
+		if t.owns(v.txStartId) {
+			if ours == nil && !t.owns(v.txEndId) {
+				ours = v
+			}
+			continue
+		}
+		if theirs == nil && d.visibleCommitted(t, *v) {
+			theirs = v
+		}
+		// t ending the version doesn't stop it having seen it.
+		seen := *v
+		if t.owns(seen.txEndId) {
+			seen.txEndId = 0
+		}
+		if base == nil && d.isvisible(t, seen) {
+			base = v
+		}
+	}
+	return base, theirs, ours
+}
+
+// supersede writes raw, of type kind, to key in place of t's own write and of
+// whatever has been committed since, the way a write under Read Committed
+// would, and the way recovery will replay it.
+func (t *Transaction) supersede(key, raw string, kind ValueType, expiresAt uint32) {
+	versions := t.db.versions(key)
+	for i := range versions {
+		if t.db.visibleCommitted(t, versions[i]) {
+			versions[i].txEndId = t.id
+		}
+	}
+	t.log(WALRecord{Type: WALSet, Key: key, Value: raw, ValueType: kind, ExpiresAt: expiresAt})
+	t.appendVersion(key, versions, Value{
+		txStartId: t.id,
+		data:      t.db.payload(raw, kind),
+		checksum:  t.db.checksum(formatValue(kind, raw)),
+		expiresAt: expiresAt,
+	})
+}
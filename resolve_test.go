@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// addCounters adds what each side added to base.
+func addCounters(key, base, theirs, ours string) (string, error) {
+	b, _ := strconv.Atoi(base)
+	x, err := strconv.Atoi(theirs)
+	if err != nil {
+		return "", err
+	}
+	y, err := strconv.Atoi(ours)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(x + y - b), nil
+}
+
+func TestResolver(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir, WithResolver("counter:", addCounters))
+	assertEq(err, nil, "open")
+	database.defaultIsolation = SnapshotIsolation
+	database.Set("counter:a", "10")
+
+	c1 := database.newConnection()
+	c2 := database.newConnection()
+	c3 := database.newConnection()
+	for _, c := range []*Connection{c1, c2, c3} {
+		c.mustExecCommand("begin", nil)
+	}
+	c1.mustExecCommand("incr", []string{"counter:a"})
+	c2.mustExecCommand("set", []string{"counter:a", "15"})
+	c3.mustExecCommand("set", []string{"counter:b", "1"})
+	c1.mustExecCommand("commit", nil)
+	c2.mustExecCommand("commit", nil)
+	assertEq(c3.mustExecCommand("get", []string{"counter:a"}), "10", "snapshot unchanged")
+	c3.mustExecCommand("incr", []string{"counter:a"})
+	c3.mustExecCommand("commit", nil)
+
+	c1.mustExecCommand("begin", nil)
+	assertEq(c1.mustExecCommand("get", []string{"counter:a"}), "17", "all three merged")
+	assertEq(c1.mustExecCommand("get", []string{"counter:b"}), "1", "b")
+	c1.mustExecCommand("commit", nil)
+	crash(database)
+
+	database, err = NewDatabase(dir, WithResolver("counter:", addCounters))
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	c := database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"counter:a"}), "17", "recovered merged")
+}
+
+func TestResolver_unresolved(t *testing.T) {
+	database := newDatabase()
+	database.apply(
+		WithResolver("", func(key, base, theirs, ours string) (string, error) {
+			return "", errors.New("no")
+		}),
+		WithResolver("counter:", addCounters),
+	)
+	database.defaultIsolation = SnapshotIsolation
+	database.Set("counter:a", "1")
+	database.Set("x", "1")
+
+	conflict := func(key, first, second string, deleteFirst bool) error {
+		t1, _ := database.Begin()
+		t2, _ := database.Begin()
+		if deleteFirst {
+			t1.Delete(key)
+		} else {
+			t1.Set(key, first)
+		}
+		t2.Set(key, second)
+		assertEq(t1.Commit(), nil, "first commit")
+		return t2.Commit()
+	}
+
+	assertEq(conflict("counter:a", "4", "5", false), nil, "resolved")
+	tx, _ := database.Begin()
+	value, _ := tx.Get("counter:a")
+	assertEq(value, "8", "merged")
+	tx.Commit()
+
+	var conflictErr *ConflictError
+	assert(errors.As(conflict("x", "2", "3", false), &conflictErr), "resolver failed")
+	assert(errors.As(conflict("counter:a", "", "3", true), &conflictErr), "deleted")
+	database.Set("counter:a", "1")
+	assert(errors.As(conflict("counter:a", "x", "3", false), &conflictErr), "not a counter")
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+A RESP2 frontend, so redis-cli, redis-benchmark and Redis client libraries
+can talk to the database. It covers the string commands that map directly
+onto ours (GET, SET, DEL, MGET, INCR and so on), those for lists,
+hashes, sets and sorted sets (LPUSH, HSET, SADD, ZRANGE and so on), plus
+MULTI, EXEC and DISCARD.
+
+Redis queues the commands between MULTI and EXEC and runs them all at
+EXEC. Here MULTI begins a transaction and each command runs in it straight
+away, replying QUEUED; EXEC commits and replies with what the commands
+returned. That gives the same isolation, with one difference: if the
+commit fails because of a conflict, EXEC replies with a null array, the
+way Redis does when a WATCHed key changed, and none of it happened.
+
+Outside MULTI, each command is its own transaction, so a DEL of several
+keys is atomic too.
+*/
+
+// NewRESPServer returns a server speaking the Redis protocol.
+func NewRESPServer(db *Database) *Server {
+	return newServer(db, serveRESP)
+}
+
+type respReply struct {
+	// One of '+', '-', ':', '$' and '*'.
+	kind  byte
+	str   string
+	n     int64
+	null  bool
+	elems []respReply
+}
+
+var (
+	respOK        = respReply{kind: '+', str: "OK"}
+	respNull      = respReply{kind: '$', null: true}
+	respNullArray = respReply{kind: '*', null: true}
+)
+
+func respStatus(s string) respReply { return respReply{kind: '+', str: s} }
+func respBulk(s string) respReply   { return respReply{kind: '$', str: s} }
+func respInt(n int64) respReply     { return respReply{kind: ':', n: n} }
+
+func respArray(elems []respReply) respReply {
+	return respReply{kind: '*', elems: elems}
+}
+
+func respError(err error) respReply {
+	msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+	return respReply{kind: '-', str: "ERR " + msg}
+}
+
+func (r respReply) write(w *bufio.Writer) {
+	switch {
+	case r.null:
+		fmt.Fprintf(w, "%c-1\r\n", r.kind)
+	case r.kind == '+' || r.kind == '-':
+		fmt.Fprintf(w, "%c%s\r\n", r.kind, r.str)
+	case r.kind == ':':
+		fmt.Fprintf(w, ":%d\r\n", r.n)
+	case r.kind == '$':
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(r.str), r.str)
+	case r.kind == '*':
+		fmt.Fprintf(w, "*%d\r\n", len(r.elems))
+		for _, e := range r.elems {
+			e.write(w)
+		}
+	}
+}
+
+type respProtocolError string
+
+func (e respProtocolError) Error() string { return "Protocol error: " + string(e) }
+
+const (
+	respMaxArgs = 1024 * 1024
+	respMaxBulk = 512 * 1024 * 1024
+)
+
+// readRESPCommand reads an array of bulk strings or, as redis-cli sends
+// when typed at over telnet, an inline command.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > respMaxArgs {
+		return nil, respProtocolError("invalid multibulk length")
+	}
+	var args []string
+	for range n {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, respProtocolError(fmt.Sprintf("expected '$', got %q", line))
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > respMaxBulk {
+			return nil, respProtocolError("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if string(buf[size:]) != "\r\n" {
+			return nil, respProtocolError("bulk string not terminated by CRLF")
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+type respSession struct {
+	c *Connection
+
+	// Between MULTI and EXEC.
+	multi bool
+	// Whether the transaction was aborted by one of the commands in it.
+	failed bool
+	// The replies EXEC will return.
+	queued []respReply
+}
+
+func serveRESP(c *Connection, r *bufio.Reader, w *bufio.Writer) {
+	s := &respSession{c: c}
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			var protocolErr respProtocolError
+			if errors.As(err, &protocolErr) {
+				respError(err).write(w)
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		name := strings.ToUpper(args[0])
+		if name == "QUIT" {
+			respOK.write(w)
+			w.Flush()
+			return
+		}
+		s.do(name, args[1:]).write(w)
+
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *respSession) do(name string, args []string) respReply {
+	switch name {
+	case "MULTI":
+		if s.multi {
+			return respError(errors.New("MULTI calls can not be nested"))
+		}
+		if _, err := s.c.execCommand("begin", nil); err != nil {
+			return respError(err)
+		}
+		s.multi, s.failed, s.queued = true, false, nil
+		return respOK
+
+	case "EXEC":
+		if !s.multi {
+			return respError(errors.New("EXEC without MULTI"))
+		}
+		queued := s.queued
+		s.multi, s.queued = false, nil
+		if s.failed {
+			return respReply{kind: '-', str: "EXECABORT Transaction discarded because of previous errors."}
+		}
+		if _, err := s.c.execCommand("commit", nil); err != nil {
+			return respNullArray
+		}
+		return respArray(queued)
+
+	case "DISCARD":
+		if !s.multi {
+			return respError(errors.New("DISCARD without MULTI"))
+		}
+		s.multi, s.queued = false, nil
+		if s.c.tx != nil {
+			s.c.execCommand("abort", nil)
+		}
+		return respOK
+	}
+
+	cmd, ok := respCommands[name]
+	if !ok {
+		return respError(fmt.Errorf("unknown command '%s'", name))
+	}
+	if !s.multi {
+		return cmd(s.c, args)
+	}
+
+	// Once the transaction is gone, don't let the rest of the block
+	// autocommit.
+	if s.failed {
+		return respError(ErrTransactionAborted)
+	}
+	reply := cmd(s.c, args)
+	if s.c.tx == nil {
+		s.failed = true
+	}
+	s.queued = append(s.queued, reply)
+	return respStatus("QUEUED")
+}
+
+type respHandler func(c *Connection, args []string) respReply
+
+var respCommands = map[string]respHandler{
+	"AUTH":     respAuth,
+	"PING":     respPing,
+	"ECHO":     respEcho,
+	"COMMAND":  func(*Connection, []string) respReply { return respArray(nil) },
+	"GET":      respValue("get"),
+	"GETDEL":   respValue("getdel"),
+	"SET":      respSet,
+	"SETNX":    respInteger("setnx"),
+	"MGET":     respMGet,
+	"MSET":     respDone("mset"),
+	"DEL":      respCount("getdel", func(Result) bool { return true }),
+	"EXISTS":   respCount("exists", func(res Result) bool { return res.Value == "1" }),
+	"INCR":     respInteger("incr"),
+	"DECR":     respInteger("decr"),
+	"INCRBY":   respInteger("incr"),
+	"DECRBY":   respInteger("decr"),
+	"KEYS":     respKeys,
+	"DBSIZE":   respInteger("dbsize"),
+	"EXPIRE":   respInteger("expire"),
+	"TTL":      respTTL,
+	"LPUSH":    respInteger("lpush"),
+	"RPUSH":    respInteger("rpush"),
+	"LPOP":     respValue("lpop"),
+	"LRANGE":   respValues("lrange"),
+	"HSET":     respInteger("hset"),
+	"HGET":     respValue("hget"),
+	"HDEL":     respInteger("hdel"),
+	"HGETALL":  respHGetAll,
+	"SADD":     respInteger("sadd"),
+	"SREM":     respInteger("srem"),
+	"SMEMBERS": respValues("smembers"),
+	"ZADD":     respInteger("zadd"),
+	"ZRANGE":   respZRange,
+}
+
+var errRESPArguments = errors.New("wrong number of arguments")
+
+// AUTH [user] password, with the user "default" if none is given.
+func respAuth(c *Connection, args []string) respReply {
+	if len(args) == 1 {
+		args = []string{"default", args[0]}
+	}
+	return respDone("auth")(c, args)
+}
+
+func respPing(c *Connection, args []string) respReply {
+	switch len(args) {
+	case 0:
+		return respStatus("PONG")
+	case 1:
+		return respBulk(args[0])
+	}
+	return respError(errRESPArguments)
+}
+
+func respEcho(c *Connection, args []string) respReply {
+	if len(args) != 1 {
+		return respError(errRESPArguments)
+	}
+	return respBulk(args[0])
+}
+
+// respValue runs a command that replies with a value, or nil if there is
+// none.
+func respValue(command string) respHandler {
+	return func(c *Connection, args []string) respReply {
+		res, err := c.execCommand(command, args)
+		if res.NotFound {
+			return respNull
+		}
+		if err != nil {
+			return respError(err)
+		}
+		return respBulk(res.Value)
+	}
+}
+
+// respInteger runs a command whose value is an integer.
+func respInteger(command string) respHandler {
+	return func(c *Connection, args []string) respReply {
+		res, err := c.execCommand(command, args)
+		if err != nil {
+			return respError(err)
+		}
+		n, err := strconv.ParseInt(res.Value, 10, 64)
+		if err != nil {
+			return respError(err)
+		}
+		return respInt(n)
+	}
+}
+
+// respDone runs a command that replies OK.
+func respDone(command string) respHandler {
+	return func(c *Connection, args []string) respReply {
+		if _, err := c.execCommand(command, args); err != nil {
+			return respError(err)
+		}
+		return respOK
+	}
+}
+
+// respCount runs a single-key command on each key, in one transaction,
+// and replies with how many of the keys it found.
+func respCount(command string, found func(Result) bool) respHandler {
+	return func(c *Connection, args []string) respReply {
+		if len(args) == 0 {
+			return respError(errRESPArguments)
+		}
+
+		var n int64
+		err := c.atomically(func() error {
+			for _, key := range args {
+				res, err := c.execCommand(command, []string{key})
+				if res.NotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if found(res) {
+					n++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return respError(err)
+		}
+		return respInt(n)
+	}
+}
+
+// SET key value [EX seconds]
+func respSet(c *Connection, args []string) respReply {
+	switch {
+	case len(args) == 2:
+		return respDone("set")(c, args)
+	case len(args) == 4 && strings.EqualFold(args[2], "EX"):
+		err := c.atomically(func() error {
+			if _, err := c.execCommand("set", args[:2]); err != nil {
+				return err
+			}
+			_, err := c.execCommand("expire", []string{args[0], args[3]})
+			return err
+		})
+		if err != nil {
+			return respError(err)
+		}
+		return respOK
+	}
+	return respError(errors.New("syntax error"))
+}
+
+func respMGet(c *Connection, args []string) respReply {
+	if len(args) == 0 {
+		return respError(errRESPArguments)
+	}
+	res, err := c.execCommand("mget", args)
+	if err != nil {
+		return respError(err)
+	}
+	elems := make([]respReply, len(res.Lookups))
+	for i, l := range res.Lookups {
+		elems[i] = respNull
+		if l.Found {
+			elems[i] = respBulk(l.Value)
+		}
+	}
+	return respArray(elems)
+}
+
+func respKeys(c *Connection, args []string) respReply {
+	res, err := c.execCommand("keys", args)
+	if err != nil {
+		return respError(err)
+	}
+	elems := make([]respReply, len(res.Keys))
+	for i, key := range res.Keys {
+		elems[i] = respBulk(key)
+	}
+	return respArray(elems)
+}
+
+// respValues runs a command replying with several values.
+func respValues(command string) respHandler {
+	return func(c *Connection, args []string) respReply {
+		res, err := c.execCommand(command, args)
+		if err != nil {
+			return respError(err)
+		}
+		elems := make([]respReply, len(res.Values))
+		for i, v := range res.Values {
+			elems[i] = respBulk(v)
+		}
+		return respArray(elems)
+	}
+}
+
+// HGETALL replies with fields and values alternating, as Redis does.
+func respHGetAll(c *Connection, args []string) respReply {
+	res, err := c.execCommand("hgetall", args)
+	if err != nil {
+		return respError(err)
+	}
+	var elems []respReply
+	for _, f := range res.Pairs {
+		elems = append(elems, respBulk(f.Key), respBulk(f.Value))
+	}
+	return respArray(elems)
+}
+
+// ZRANGE WITHSCORES replies with members and scores alternating, as
+// Redis does.
+func respZRange(c *Connection, args []string) respReply {
+	if len(args) < 4 {
+		return respValues("zrange")(c, args)
+	}
+	res, err := c.execCommand("zrange", args)
+	if err != nil {
+		return respError(err)
+	}
+	var elems []respReply
+	for _, m := range res.Pairs {
+		elems = append(elems, respBulk(m.Key), respBulk(m.Value))
+	}
+	return respArray(elems)
+}
+
+// TTL replies -2 for a missing key, as Redis does.
+func respTTL(c *Connection, args []string) respReply {
+	res, err := c.execCommand("ttl", args)
+	if res.NotFound {
+		return respInt(-2)
+	}
+	if err != nil {
+		return respError(err)
+	}
+	n, _ := strconv.ParseInt(res.Value, 10, 64)
+	return respInt(n)
+}
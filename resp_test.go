@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startRESPServer(db *Database) (*Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assertEq(err, nil, "listen")
+	srv := NewRESPServer(db)
+	go srv.Serve(ln)
+	return srv, ln.Addr().String()
+}
+
+// respCall sends args as a RESP array and returns the reply, with CRLFs
+// turned into spaces.
+func respCall(c *testClient, args ...string) string {
+	fmt.Fprintf(c.conn, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return respReadReply(c)
+}
+
+func respReadReply(c *testClient) string {
+	line := c.response()
+	line = strings.TrimSuffix(line, "\r")
+	var n int
+	switch {
+	case strings.HasPrefix(line, "$") && line != "$-1":
+		return line + " " + strings.TrimSuffix(c.response(), "\r")
+	case strings.HasPrefix(line, "*") && line != "*-1":
+		fmt.Sscan(line[1:], &n)
+		for range n {
+			line += " " + respReadReply(c)
+		}
+	}
+	return line
+}
+
+func TestRESP(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	database := newDatabase()
+	database.now = func() time.Time { return now }
+	srv, addr := startRESPServer(&database)
+	defer srv.Close()
+
+	c := dial(addr)
+	assertEq(respCall(c, "PING"), "+PONG", "ping")
+	assertEq(respCall(c, "SET", "k", "hello world"), "+OK", "set")
+	assertEq(respCall(c, "get", "k"), "$11 hello world", "get")
+	assertEq(respCall(c, "GET", "missing"), "$-1", "missing key")
+	assertEq(respCall(c, "SET", "n", "0"), "+OK", "set zero")
+	assertEq(respCall(c, "INCRBY", "n", "5"), ":5", "incrby")
+	assertEq(respCall(c, "MGET", "k", "missing", "n"), "*3 $11 hello world $-1 $1 5", "mget")
+	assertEq(respCall(c, "EXISTS", "k", "missing", "n"), ":2", "exists")
+	assertEq(respCall(c, "DEL", "k", "missing", "n"), ":2", "del")
+	assertEq(respCall(c, "DBSIZE"), ":0", "dbsize")
+	assertEq(respCall(c, "TTL", "k"), ":-2", "ttl of a missing key")
+	assertEq(respCall(c, "SET", "e", "v", "EX", "100"), "+OK", "set with expiry")
+	assertEq(respCall(c, "TTL", "e"), ":100", "ttl")
+	assertEq(respCall(c, "RPUSH", "q", "a", "b"), ":2", "rpush")
+	assertEq(respCall(c, "LPOP", "q"), "$1 a", "lpop")
+	assertEq(respCall(c, "LRANGE", "q", "0", "-1"), "*1 $1 b", "lrange")
+	assertEq(respCall(c, "HSET", "h", "f", "1", "g", "2"), ":2", "hset")
+	assertEq(respCall(c, "HGETALL", "h"), "*4 $1 f $1 1 $1 g $1 2", "hgetall")
+	assertEq(respCall(c, "ZADD", "z", "2", "b", "1", "a"), ":2", "zadd")
+	assertEq(respCall(c, "ZRANGE", "z", "0", "-1", "WITHSCORES"), "*4 $1 a $1 1 $1 b $1 2", "zrange")
+	assertEq(respCall(c, "FLUSHALL"), "-ERR unknown command 'FLUSHALL'", "unknown command")
+	assertEq(respCall(c, "GET"), "-ERR get: wrong number of arguments", "arity")
+
+	// Inline commands, as typed over telnet.
+	fmt.Fprintf(c.conn, "ECHO hi\r\n")
+	assertEq(respReadReply(c), "$2 hi", "inline command")
+
+	assertEq(respCall(c, "QUIT"), "+OK", "quit")
+}
+
+func TestRESP_multi(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	srv, addr := startRESPServer(&database)
+	defer srv.Close()
+
+	c1 := dial(addr)
+	c2 := dial(addr)
+
+	assertEq(respCall(c1, "MULTI"), "+OK", "multi")
+	assertEq(respCall(c1, "SET", "a", "1"), "+QUEUED", "queued set")
+	assertEq(respCall(c1, "GET", "a"), "+QUEUED", "queued get")
+	assertEq(respCall(c2, "GET", "a"), "$-1", "not visible before exec")
+	assertEq(respCall(c1, "EXEC"), "*2 +OK $1 1", "exec")
+	assertEq(respCall(c2, "GET", "a"), "$1 1", "visible after exec")
+
+	assertEq(respCall(c1, "MULTI"), "+OK", "multi")
+	respCall(c1, "SET", "a", "2")
+	assertEq(respCall(c1, "DISCARD"), "+OK", "discard")
+	assertEq(respCall(c2, "GET", "a"), "$1 1", "discarded")
+	assertEq(respCall(c1, "EXEC"), "-ERR EXEC without MULTI", "exec without multi")
+
+	// A write-write conflict fails the EXEC, as a WATCH would.
+	assertEq(respCall(c1, "MULTI"), "+OK", "multi")
+	assertEq(respCall(c2, "MULTI"), "+OK", "concurrent multi")
+	respCall(c1, "SET", "a", "3")
+	respCall(c2, "SET", "a", "4")
+	assertEq(respCall(c1, "EXEC"), "*1 +OK", "first exec")
+	assertEq(respCall(c2, "EXEC"), "*-1", "conflicting exec")
+	assertEq(respCall(c2, "GET", "a"), "$1 3", "first write won")
+}
+
+func TestReadRESPCommand(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$1\r\nkk\r\n"))
+	_, err := readRESPCommand(r)
+	assertEq(err, error(respProtocolError("bulk string not terminated by CRLF")), "bad bulk length")
+
+	r = bufio.NewReader(strings.NewReader("*1\r\n+GET\r\n"))
+	_, err = readRESPCommand(r)
+	assert(err != nil, "not a bulk string")
+}
@@ -0,0 +1,80 @@
+package main
+
+import "time"
+
+/*
+By default vacuum keeps only what some in-progress transaction can still
+see, and the registry forgets transactions as soon as none can. That keeps
+memory down, but it also throws away the history that the history command
+and time-travel reads work from. A retention policy keeps more of it:
+
+  - Versions keeps the newest n versions of every key, dead or not, for
+    the history command.
+  - Transactions and Age keep the last n transactions, or those begun in
+    the last d, readable with get asof: their registry entries stay, and
+    so does every version their snapshots could see.
+
+Versions written by aborted transactions are never retained.
+*/
+
+type Retention struct {
+	Versions     int
+	Transactions uint64
+	Age          time.Duration
+}
+
+// retainedFrom returns the oldest transaction id the retention policy
+// keeps readable, or nextTransactionId if it keeps none.
+func (d *Database) retainedFrom() uint64 {
+	from := d.nextTransactionId
+	if n := d.retention.Transactions; n > 0 {
+		from = d.nextTransactionId - min(n, d.nextTransactionId-1)
+	}
+
+	if d.retention.Age > 0 {
+		// Ids and start times increase together, so the first
+		// transaction recent enough is the oldest one.
+		cutoff := d.clock().Add(-d.retention.Age)
+		iter := d.transactions.Iter()
+		for ok := iter.First(); ok; ok = iter.Next() {
+			if !iter.Value().started.Before(cutoff) {
+				from = min(from, iter.Key())
+				break
+			}
+		}
+	}
+	return from
+}
+
+// retentionHorizon is horizon, pushed back to keep whatever the oldest
+// retained transaction could see.
+func (d *Database) retentionHorizon() uint64 {
+	horizon := d.horizon()
+	from := d.retainedFrom()
+	if t, ok := d.transactions.Get(from); ok {
+		if oldest, ok := t.inprogress.oldest(); ok {
+			from = min(from, oldest)
+		}
+	}
+	return min(horizon, from)
+}
+
+// retainedVersions returns the index of the oldest version the Versions
+// policy keeps.
+func (d *Database) retainedVersions(versions []Value) int {
+	if d.retention.Versions <= 0 {
+		return len(versions)
+	}
+
+	kept := 0
+	for i := len(versions) - 1; i >= 0; i-- {
+		if d.transactionState(versions[i].txStartId) == AbortedTransaction {
+			continue
+		}
+		kept++
+		if kept == d.retention.Versions {
+			return i
+		}
+	}
+	return 0
+}
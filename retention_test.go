@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionVersions(t *testing.T) {
+	database := newDatabase()
+	database.retention.Versions = 2
+
+	c := database.newConnection()
+	for _, value := range []string{"one", "two", "three"} {
+		c.mustExecCommand("set", []string{"x", value})
+	}
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "rolled back"})
+	c.mustExecCommand("abort", nil)
+
+	assertEq(c.mustExecCommand("vacuum", nil), "2", "vacuum")
+	res := c.mustExecCommand("history", []string{"x"})
+	assertEq(res, `start=2 end=3 state=committed value="two"
+start=3 end=0 state=committed value="three"`, "history kept")
+}
+
+func TestRetentionTransactions(t *testing.T) {
+	database := newDatabase()
+	database.retention.Transactions = 2
+
+	c := database.newConnection()
+	for _, value := range []string{"one", "two", "three", "four"} {
+		c.mustExecCommand("set", []string{"x", value})
+	}
+
+	// Transactions 3 and 4 are retained, and with them "two", which is
+	// what transaction 3 saw.
+	assertEq(database.transactions.Len(), 2, "registry")
+	assertEq(c.mustExecCommand("vacuum", nil), "1", "vacuum")
+	assertEq(c.mustExecCommand("get", []string{"x", "asof", "3"}), "two", "as of 3")
+	assertEq(c.mustExecCommand("get", []string{"x", "asof", "4"}), "three", "as of 4")
+}
+
+func TestRetentionAge(t *testing.T) {
+	database := newDatabase()
+	database.retention.Age = time.Minute
+	now := time.Now()
+	database.now = func() time.Time { return now }
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "old"})
+	now = now.Add(time.Hour)
+	c.mustExecCommand("set", []string{"x", "new"})
+	c.mustExecCommand("set", []string{"x", "newer"})
+
+	// Transaction 1 is out of the window; 2 and 3 are in it.
+	assertEq(database.transactions.Len(), 2, "registry")
+	assertEq(c.mustExecCommand("vacuum", nil), "0", "vacuum")
+	assertEq(c.mustExecCommand("get", []string{"x", "asof", "3"}), "new", "as of 3")
+
+	now = now.Add(time.Hour)
+	assertEq(c.mustExecCommand("vacuum", nil), "2", "vacuum once out of the window")
+	_, err := c.execCommand("get", []string{"x", "asof", "3"})
+	assertEq(err.Error(), "no transaction 3 to read as of", "as of 3 once vacuumed")
+}
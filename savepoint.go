@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+A savepoint marks a point in a transaction that it can later roll back
+to, undoing whatever it wrote since without aborting the rest. Writing
+is appending versions and marking the versions they replace as ended, so
+while a transaction holds savepoints it keeps an undo log of both: the
+versions it appended, and the end marks it set along with what they were
+before. Rolling back replays the log backwards from the end to the
+savepoint, and puts back the writeset as it was.
+
+Reads are not undone. The transaction has already seen what it read, and
+may have acted on it, so under Serializable isolation those reads still
+have to be checked for conflicts at commit.
+
+As in SQL, a savepoint with the same name as an earlier one hides it, and
+rolling back to a savepoint keeps it but forgets any taken after it.
+OnCommit hooks registered after the savepoint are forgotten too, since
+the work they were registered for has been undone.
+
+A statement that can fail partway through, like a script, runs under a
+savepoint of its own, with no name, which it rolls back to if it fails
+and releases either way. So a failing statement leaves the transaction as
+it was before the statement, and still in progress.
+
+Savepoints, rollbacks and releases go in the write-ahead log, so recovery
+replays them. Rolling back needs the log records written since the savepoint, so
+the database isn't checkpointed while any transaction holds one.
+*/
+
+var (
+	ErrNoSavepoint    = errors.New("no such savepoint")
+	ErrSavepointsHeld = errors.New("transactions hold savepoints")
+)
+
+type savepoint struct {
+	name string
+	// How long the undo log and the OnCommit hooks were, and what the
+	// writeset held, when the savepoint was taken.
+	undo     int
+	onCommit int
+	writeset []string
+}
+
+// An undoEntry undoes one change the transaction tx made to key: the
+// version it appended, or else the end mark it set on the version that
+// start wrote, which was end before. tx is the transaction holding the
+// savepoint, or one nested in it.
+type undoEntry struct {
+	tx       uint64
+	key      string
+	appended bool
+	start    uint64
+	end      uint64
+}
+
+// Savepoint marks the transaction's current state as name, to roll back
+// to with RollbackTo.
+func (t *Transaction) Savepoint(name string) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("savepoint: empty name")
+	}
+	t.savepoint(name)
+	return nil
+}
+
+// RollbackTo undoes everything the transaction wrote since the savepoint
+// name, which it keeps, forgetting any savepoints taken since.
+func (t *Transaction) RollbackTo(name string) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return err
+	}
+	return t.rollbackTo(name)
+}
+
+func (t *Transaction) savepoint(name string) {
+	t.log(WALRecord{Type: WALSavepoint, Key: name})
+	t.savepoints = append(t.savepoints, savepoint{
+		name:     name,
+		undo:     len(t.undo),
+		onCommit: len(t.onCommit),
+		writeset: t.writeset.Keys(),
+	})
+}
+
+func (t *Transaction) rollbackTo(name string) error {
+	i, err := t.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	sp := t.savepoints[i]
+	t.savepoints = t.savepoints[:i+1]
+	t.log(WALRecord{Type: WALRollbackTo, Key: name})
+
+	for j := len(t.undo) - 1; j >= sp.undo; j-- {
+		t.db.undo(t.undo[j])
+	}
+	t.undo = t.undo[:sp.undo]
+	t.onCommit = t.onCommit[:sp.onCommit]
+
+	t.writeset.Clear()
+	for _, key := range sp.writeset {
+		t.writeset.Insert(key)
+	}
+
+	t.debug("rolled back to savepoint", "savepoint", name)
+	return nil
+}
+
+// release forgets the savepoint name, and any taken since, keeping what
+// was written since.
+func (t *Transaction) release(name string) error {
+	i, err := t.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	t.log(WALRecord{Type: WALRelease, Key: name})
+	t.savepoints = t.savepoints[:i]
+	if !t.keepsUndo() {
+		t.undo = nil
+	}
+	return nil
+}
+
+// findSavepoint returns the index of the latest savepoint named name.
+func (t *Transaction) findSavepoint(name string) (int, error) {
+	for i := len(t.savepoints) - 1; i >= 0; i-- {
+		if t.savepoints[i].name == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrNoSavepoint, name)
+}
+
+// atomically runs fn, a statement that may fail partway through, undoing
+// what it wrote if it fails.
+func (t *Transaction) atomically(fn func() error) error {
+	t.db.mu.Lock()
+	err := t.checkInProgress()
+	if err == nil {
+		t.savepoint("")
+	}
+	t.db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	// If the transaction was aborted or prepared meanwhile, there is
+	// nothing left to undo or release.
+	if t.checkInProgress() != nil {
+		return err
+	}
+	if err != nil {
+		t.rollbackTo("")
+	}
+	t.release("")
+	return err
+}
+
+// remember adds an entry to the undo log, if there are savepoints to
+// roll back to.
+func (t *Transaction) remember(e undoEntry) {
+	if t.keepsUndo() {
+		e.tx = t.id
+		t.undo = append(t.undo, e)
+	}
+}
+
+// keepsUndo reports whether the transaction, or one it is nested in,
+// holds any savepoints.
+func (t *Transaction) keepsUndo() bool {
+	for ; t != nil; t = t.parent {
+		if len(t.savepoints) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// undo reverses a change. Entries are undone newest first, so the version
+// e.tx appended last to a key, or ended last among those start wrote, is
+// the one the entry is about.
+func (d *Database) undo(e undoEntry) {
+	versions := d.versions(e.key)
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := &versions[i]
+		if e.appended && v.txStartId == e.tx && v.txEndId == 0 {
+			d.versionBytes -= versionSize(v)
+			if len(versions) == 1 {
+				d.versionBytes -= keySize(e.key)
+				d.store.Delete(e.key)
+			} else {
+				d.store.Set(e.key, append(versions[:i:i], versions[i+1:]...))
+			}
+			return
+		}
+		if !e.appended && v.txStartId == e.start && v.txEndId == e.tx {
+			v.txEndId = e.end
+			return
+		}
+	}
+}
+
+// holdsSavepoints reports whether any transaction in progress holds a
+// savepoint.
+func (d *Database) holdsSavepoints() bool {
+	iter := d.running.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		if t, _ := d.transactions.Get(iter.Key()); len(t.savepoints) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// savepoint name
+func (c *Connection) savepoint(args []string) (Result, error) {
+	return Result{TxId: c.tx.id}, c.tx.Savepoint(args[0])
+}
+
+// rollback to name
+func (c *Connection) rollbackTo(args []string) (Result, error) {
+	if args[0] != "to" {
+		return Result{}, errors.New("rollback: expected rollback to <savepoint>")
+	}
+	return Result{TxId: c.tx.id}, c.tx.RollbackTo(args[1])
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSavepoint(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "before"})
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"y", "kept"})
+	c.mustExecCommand("savepoint", []string{"a"})
+	c.mustExecCommand("set", []string{"x", "undone"})
+	c.mustExecCommand("set", []string{"x", "at b"})
+	c.mustExecCommand("savepoint", []string{"b"})
+	c.mustExecCommand("delete", []string{"y"})
+	c.mustExecCommand("set", []string{"z", "undone"})
+
+	c.mustExecCommand("rollback", []string{"to", "b"})
+	assertEq(c.mustExecCommand("get", []string{"x"}), "at b", "x as at b")
+	assertEq(c.mustExecCommand("get", []string{"y"}), "kept", "delete undone")
+	_, err := c.execCommand("get", []string{"z"})
+	assertEq(err, ErrKeyNotFound, "z never written")
+
+	// Rolling back to a keeps a, but forgets b.
+	c.mustExecCommand("rollback", []string{"to", "a"})
+	c.mustExecCommand("rollback", []string{"to", "a"})
+	assertEq(c.mustExecCommand("get", []string{"x"}), "before", "x as at a")
+	_, err = c.execCommand("rollback", []string{"to", "b"})
+	assert(errors.Is(err, ErrNoSavepoint), "b forgotten")
+	_, err = c.execCommand("rollback", []string{"back", "a"})
+	assert(err != nil, "rollback to")
+
+	// Only y is left in the writeset, so another transaction writing x
+	// meanwhile doesn't conflict.
+	other := database.newConnection()
+	other.mustExecCommand("set", []string{"x", "other"})
+	c.mustExecCommand("commit", nil)
+
+	assertEq(c.mustExecCommand("get", []string{"x"}), "other", "x")
+	assertEq(c.mustExecCommand("get", []string{"y"}), "kept", "y")
+	assertEq(len(database.versions("x")), 2, "undone versions removed")
+	_, ok := database.store.Get("z")
+	assert(!ok, "z removed")
+
+	_, err = c.execCommand("savepoint", []string{"a"})
+	assertEq(err, ErrNoTransaction, "savepoint outside a transaction")
+}
+
+func TestSavepoint_hooks(t *testing.T) {
+	database := newDatabase()
+	tx, _ := database.Begin()
+	var ran []string
+	tx.OnCommit(func() { ran = append(ran, "kept") })
+	tx.Savepoint("a")
+	tx.OnCommit(func() { ran = append(ran, "undone") })
+	assertEq(tx.RollbackTo("a"), nil, "rollback")
+	assertEq(tx.Commit(), nil, "commit")
+	assertEq(len(ran), 1, "hooks run")
+	assertEq(ran[0], "kept", "hook registered before the savepoint")
+}
+
+func TestSavepoint_recovery(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "before"})
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("savepoint", []string{"a"})
+	c.mustExecCommand("set", []string{"x", "undone"})
+	c.mustExecCommand("set", []string{"y", "undone"})
+	assert(errors.Is(database.Checkpoint(), ErrSavepointsHeld), "no checkpoint while savepoints are held")
+	c.mustExecCommand("rollback", []string{"to", "a"})
+	c.mustExecCommand("set", []string{"z", "kept"})
+	c.mustExecCommand("commit", nil)
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	c = database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"x"}), "before", "x")
+	assertEq(c.mustExecCommand("get", []string{"z"}), "kept", "z")
+	_, err = c.execCommand("get", []string{"y"})
+	assertEq(err, ErrKeyNotFound, "y")
+	assertEq(database.Checkpoint(), nil, "checkpoint")
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+Because the store is ordered by key, a transaction can walk a range of
+keys. Each key still goes through the normal visibility rules: a key shows
+up in a scan exactly when a get of it in the same transaction would have
+succeeded, with the same value.
+
+Every key examined is added to the readset, just as a get would add it.
+Keys in the range that have no versions at all yet are not, so
+Serializable isolation does not protect a scan against phantoms.
+
+Scans can run in either direction. A descending scan starts from the last
+key before the end of the range (a seek-for-prev), which lets a paginated
+UI show the newest entries first, a page at a time, without touching the
+rest of the range.
+*/
+
+// Keys starting with internalPrefix are the database's own: index entries
+// (see index.go) and the keys in buckets (see bucket.go).
+const internalPrefix = "\x00"
+
+func isInternalKey(key string) bool {
+	return strings.HasPrefix(key, internalPrefix)
+}
+
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+type Order uint8
+
+const (
+	Ascending Order = iota
+	Descending
+)
+
+type ScanOptions struct {
+	// The range to scan is [Start, End), with an empty End meaning no
+	// upper bound. If Prefix is set, it determines the range instead.
+	Start, End string
+	Prefix     string
+
+	Order Order
+	// Stop after this many keys, if positive.
+	Limit int
+	// Return only keys whose values pass them all. See filter.go.
+	Filters []Filter
+}
+
+func (o ScanOptions) bounds() (string, string) {
+	if o.Prefix != "" {
+		return o.Prefix, prefixEnd(o.Prefix)
+	}
+	return o.Start, o.End
+}
+
+// Scan returns the visible keys k with start <= k < end, in order. An
+// empty end means no upper bound.
+func (t *Transaction) Scan(start, end string) ([]KeyValue, error) {
+	return t.ScanWith(ScanOptions{Start: start, End: end})
+}
+
+// ScanWith returns the visible keys in the range described by opts, in
+// the requested order.
+func (t *Transaction) ScanWith(opts ScanOptions) ([]KeyValue, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return nil, err
+	}
+
+	filters, err := compileFilters(opts.Filters)
+	if err != nil {
+		return nil, err
+	}
+	start, end := opts.bounds()
+
+	var pairs []KeyValue
+	t.scan(start, end, opts.Order, func(key string, value *Value) bool {
+		var ok bool
+		if ok, err = t.db.passes(filters, value); err != nil || !ok {
+			return err == nil
+		}
+		var s string
+		if s, err = t.db.read(value); err != nil {
+			return false
+		}
+		pairs = append(pairs, KeyValue{key, s})
+		return opts.Limit <= 0 || len(pairs) < opts.Limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// scan calls fn with the visible version of each key in [start, end), in
+// the given order, until fn returns false.
+func (t *Transaction) scan(start, end string, order Order, fn func(key string, value *Value) bool) {
+	iter := t.db.store.Iter()
+
+	var ok bool
+	var next func() bool
+	var done func(key string) bool
+	if order == Descending {
+		// Seek lands on the first key >= end, so the last key in range
+		// is the one before it; or the very last key, if nothing is.
+		if end != "" && iter.Seek(end) {
+			ok = iter.Prev()
+		} else {
+			ok = iter.Last()
+		}
+		next = iter.Prev
+		done = func(key string) bool { return key < start }
+	} else {
+		ok = iter.Seek(start)
+		next = iter.Next
+		done = func(key string) bool { return end != "" && key >= end }
+	}
+
+	for ; ok; ok = next() {
+		key := iter.Key()
+		if done(key) {
+			return
+		}
+		// The database's own keys are only seen by scans of their own
+		// ranges.
+		if isInternalKey(key) && !isInternalKey(start) {
+			continue
+		}
+
+		value, err := t.get(key)
+		if err != nil {
+			continue
+		}
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+// ScanPrefix returns the visible keys starting with prefix, in order.
+func (t *Transaction) ScanPrefix(prefix string) ([]KeyValue, error) {
+	return t.ScanWith(ScanOptions{Start: prefix, End: prefixEnd(prefix)})
+}
+
+// Keys returns the visible keys matching the glob pattern, in order.
+// Patterns support * (any run of characters), ? (any one character),
+// [abc] and [a-z] classes, and \ to escape the next character.
+func (t *Transaction) Keys(pattern string) ([]string, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return nil, err
+	}
+
+	// Only keys sharing the pattern's literal prefix can match, so there
+	// is no need to look at the rest of the keyspace.
+	prefix := globPrefix(pattern)
+
+	var keys []string
+	t.scan(prefix, prefixEnd(prefix), Ascending, func(key string, _ *Value) bool {
+		if globMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys, nil
+}
+
+// prefixEnd returns the smallest key greater than every key starting
+// with prefix, or "" if there is none.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// scan [--desc] [--limit n] [--op operand]... start end
+// scan [--desc] [--limit n] [--op operand]... prefix
+//
+// where op is a filter's (see filter.go).
+func (c *Connection) scan(args []string) (Result, error) {
+	opts, err := parseScanArgs(args)
+	if err != nil {
+		return Result{}, err
+	}
+
+	pairs, err := c.tx.ScanWith(opts)
+	if err != nil {
+		return Result{}, err
+	}
+	return pairsResult(c.tx.id, pairs), nil
+}
+
+func parseScanArgs(args []string) (ScanOptions, error) {
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	desc := flags.Bool("desc", false, "")
+	limit := flags.Int("limit", 0, "")
+	var filters []Filter
+	for i, name := range filterOps {
+		flags.Func(name, "", func(operand string) error {
+			filters = append(filters, Filter{FilterOp(i), operand})
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return ScanOptions{}, fmt.Errorf("scan: %w", err)
+	}
+
+	opts := ScanOptions{Limit: *limit, Filters: filters}
+	if *desc {
+		opts.Order = Descending
+	}
+
+	switch flags.NArg() {
+	case 1:
+		opts.Prefix = flags.Arg(0)
+	case 2:
+		opts.Start, opts.End = flags.Arg(0), flags.Arg(1)
+	default:
+		return ScanOptions{}, fmt.Errorf("scan: expected a prefix or a start and end key")
+	}
+	return opts, nil
+}
+
+// keys pattern
+func (c *Connection) keys(args []string) (Result, error) {
+	keys, err := c.tx.Keys(args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Value: strings.Join(keys, "\n"), Keys: keys, TxId: c.tx.id}, nil
+}
+
+// pairsResult renders pairs one "key=value" per line, alongside the
+// structured form.
+func pairsResult(txId uint64, pairs []KeyValue) Result {
+	res := Result{TxId: txId, Pairs: pairs}
+	lines := make([]string, len(pairs))
+	for i, kv := range pairs {
+		lines[i] = kv.Key + "=" + kv.Value
+		res.Keys = append(res.Keys, kv.Key)
+	}
+	res.Value = strings.Join(lines, "\n")
+	return res
+}
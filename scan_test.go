@@ -0,0 +1,121 @@
+package main
+
+import "testing"
+
+func TestScan(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	for _, key := range []string{"d", "a", "c", "b", "e"} {
+		c1.mustExecCommand("set", []string{key, key + key})
+	}
+	c1.mustExecCommand("delete", []string{"c"})
+	c1.mustExecCommand("commit", nil)
+
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+	c2.mustExecCommand("set", []string{"bb", "uncommitted"})
+
+	c3 := database.newConnection()
+	c3.mustExecCommand("begin", nil)
+
+	res := c3.mustExecCommand("scan", []string{"b", "e"})
+	assertEq(res, "b=bb\nd=dd", "c3 scan b e")
+
+	// The writer sees its own uncommitted write.
+	result, err := c2.execCommand("scan", []string{"a", "c"})
+	assertEq(err, nil, "c2 scan a c")
+	assertEq(len(result.Pairs), 3, "c2 scan a c")
+	assertEq(result.Pairs[2], KeyValue{"bb", "uncommitted"}, "c2 scan a c")
+
+	tx, _ := database.Begin()
+	pairs, _ := tx.Scan("c", "")
+	assertEq(len(pairs), 2, "scan to the end")
+	assertEq(pairs[1].Key, "e", "scan to the end")
+}
+
+func TestScanPrefixAndKeys(t *testing.T) {
+	database := newDatabase()
+	for _, key := range []string{"user:1", "user:2", "user:10", "order:1", "users", "user:2:name"} {
+		database.Set(key, "v")
+	}
+	database.Delete("user:10")
+
+	c := database.newConnection()
+	res := c.mustExecCommand("scan", []string{"user:"})
+	assertEq(res, "user:1=v\nuser:2=v\nuser:2:name=v", "scan user:")
+
+	res = c.mustExecCommand("keys", []string{"user:*"})
+	assertEq(res, "user:1\nuser:2\nuser:2:name", "keys user:*")
+
+	res = c.mustExecCommand("keys", []string{"user:?"})
+	assertEq(res, "user:1\nuser:2", "keys user:?")
+
+	res = c.mustExecCommand("keys", []string{"*:1"})
+	assertEq(res, "order:1\nuser:1", "keys *:1")
+
+	res = c.mustExecCommand("keys", []string{"user[s:]*"})
+	assertEq(res, "user:1\nuser:2\nuser:2:name\nusers", "keys user[s:]*")
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"*", "", true},
+		{"a*b", "ab", true},
+		{"a*b", "a/x/b", true},
+		{"a*b", "abc", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"[a-c]x", "bx", true},
+		{"[^a-c]x", "bx", false},
+		{"[^a-c]x", "dx", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"a[", "a[", true},
+	} {
+		assertEq(globMatch(tc.pattern, tc.key), tc.match, tc.pattern+" "+tc.key)
+	}
+
+	assertEq(globPrefix(`user\*:*`), "user*:", "glob prefix")
+	assertEq(prefixEnd("ab"), "ac", "prefix end")
+	assertEq(prefixEnd("a\xff"), "b", "prefix end")
+	assertEq(prefixEnd("\xff"), "", "prefix end")
+}
+
+func TestScanDescending(t *testing.T) {
+	database := newDatabase()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		database.Set(key, key)
+	}
+	database.Set("post:1", "first")
+	database.Set("post:2", "second")
+	database.Set("post:3", "third")
+
+	c := database.newConnection()
+	res := c.mustExecCommand("scan", []string{"--desc", "b", "e"})
+	assertEq(res, "d=d\nc=c\nb=b", "scan --desc b e")
+
+	// The end of the range may not exist; seek-for-prev still finds the
+	// last key before it.
+	res = c.mustExecCommand("scan", []string{"--desc", "--limit", "2", "a", "cc"})
+	assertEq(res, "c=c\nb=b", "scan --desc --limit 2 a cc")
+
+	res = c.mustExecCommand("scan", []string{"--desc", "--limit", "2", "post:"})
+	assertEq(res, "post:3=third\npost:2=second", "newest posts first")
+
+	// Past the last key in the store.
+	tx, _ := database.Begin()
+	pairs, _ := tx.ScanWith(ScanOptions{Start: "d", End: "zzz", Order: Descending})
+	assertEq(len(pairs), 5, "descending to the end")
+	assertEq(pairs[0].Key, "post:3", "descending to the end")
+
+	pairs, _ = tx.ScanWith(ScanOptions{Order: Descending})
+	assertEq(len(pairs), 8, "descending everything")
+
+	_, err := c.execCommand("scan", []string{"--desc"})
+	assert(err != nil, "scan without a range")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*
+Conditional logic like "move the item to the cart if stock > 0" costs a
+client several round trips, with a transaction held open across all of
+them. Scripts let an application register that logic with the database
+once, in Go, and have clients invoke it by name: "call reserve sku-1 cart-7".
+
+A script runs inside the caller's transaction, or its own one in
+autocommit mode, so its reads and writes are isolated and committed (or
+not) together with everything else in it. A script that fails has what it
+wrote undone (see savepoint.go), but the transaction goes on.
+*/
+
+// A Script reads and writes through tx and returns the command's value.
+type Script func(tx *Transaction, args []string) (string, error)
+
+type scripts struct {
+	mu     sync.RWMutex
+	byName map[string]Script
+}
+
+// RegisterScript makes fn callable as "call name args...". Registering a
+// name again replaces the earlier script.
+func (d *Database) RegisterScript(name string, fn Script) {
+	d.scripts.mu.Lock()
+	defer d.scripts.mu.Unlock()
+
+	if d.scripts.byName == nil {
+		d.scripts.byName = map[string]Script{}
+	}
+	d.scripts.byName[name] = fn
+}
+
+// call name args...
+func (c *Connection) call(args []string) (Result, error) {
+	if len(args) == 0 {
+		return Result{}, fmt.Errorf("call: missing script name")
+	}
+
+	c.db.scripts.mu.RLock()
+	fn, ok := c.db.scripts.byName[args[0]]
+	c.db.scripts.mu.RUnlock()
+	if !ok {
+		return Result{}, fmt.Errorf("call: no script named %q", args[0])
+	}
+
+	tx := c.tx
+	var value string
+	err := tx.atomically(func() (err error) {
+		value, err = fn(tx, args[1:])
+		return err
+	})
+	if err != nil {
+		return Result{TxId: tx.id}, fmt.Errorf("call %s: %w", args[0], err)
+	}
+	return Result{Value: value, TxId: tx.id}, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// reserve moves one unit of stock into a cart, if there is any left.
+func reserve(tx *Transaction, args []string) (string, error) {
+	sku, cart := args[0], args[1]
+
+	stock, err := tx.Get("stock:" + sku)
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(stock)
+	if err != nil {
+		return "", err
+	}
+	if n <= 0 {
+		return "", fmt.Errorf("%s is out of stock", sku)
+	}
+
+	if err := tx.Set("stock:"+sku, strconv.Itoa(n-1)); err != nil {
+		return "", err
+	}
+	if err := tx.Set("cart:"+cart+":"+sku, "1"); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(n - 1), nil
+}
+
+func TestScripts(t *testing.T) {
+	database := newDatabase()
+	database.RegisterScript("reserve", reserve)
+	database.Set("stock:sku-1", "1")
+
+	c1 := database.newConnection()
+	res := c1.mustExecCommand("call", []string{"reserve", "sku-1", "cart-7"})
+	assertEq(res, "0", "reserve last unit")
+	res = c1.mustExecCommand("get", []string{"cart:cart-7:sku-1"})
+	assertEq(res, "1", "in cart")
+
+	// Nothing left: the script fails and none of its writes happen.
+	_, err := c1.execCommand("call", []string{"reserve", "sku-1", "cart-8"})
+	assertEq(err.Error(), "call reserve: sku-1 is out of stock", "reserve out of stock")
+	_, err = c1.execCommand("get", []string{"cart:cart-8:sku-1"})
+	assert(errors.Is(err, ErrKeyNotFound), "not in cart")
+
+	// Inside an explicit transaction, a failing script undoes only what
+	// it wrote itself.
+	database.Set("stock:sku-2", "0")
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"y", "hey"})
+	_, err = c1.execCommand("call", []string{"reserve", "sku-2", "cart-7"})
+	assert(err != nil, "reserve sku-2")
+	assert(c1.tx != nil, "transaction still in progress")
+	assertEq(c1.mustExecCommand("get", []string{"y"}), "hey", "y kept")
+	c1.mustExecCommand("commit", nil)
+
+	_, err = c1.execCommand("call", []string{"missing"})
+	assertEq(err.Error(), `call: no script named "missing"`, "missing script")
+}
+
+func TestScripts_failPartway(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+	database.RegisterScript("half", func(tx *Transaction, args []string) (string, error) {
+		tx.Set("x", "half")
+		tx.Delete("y")
+		return "", errors.New("failed halfway")
+	})
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"y", "before"})
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("savepoint", []string{"a"})
+	c.mustExecCommand("set", []string{"z", "kept"})
+	_, err = c.execCommand("call", []string{"half"})
+	assertEq(err.Error(), "call half: failed halfway", "script fails")
+	_, err = c.execCommand("get", []string{"x"})
+	assert(errors.Is(err, ErrKeyNotFound), "x undone")
+	assertEq(c.mustExecCommand("get", []string{"y"}), "before", "y undone")
+	assertEq(len(c.tx.savepoints), 1, "the script's savepoint is released")
+	c.mustExecCommand("commit", nil)
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	defer database.Close()
+	c = database.newConnection()
+	assertEq(c.mustExecCommand("get", []string{"z"}), "kept", "z")
+	assertEq(c.mustExecCommand("get", []string{"y"}), "before", "y")
+	_, err = c.execCommand("get", []string{"x"})
+	assert(errors.Is(err, ErrKeyNotFound), "x")
+}
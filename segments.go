@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+Keys and version metadata have to stay in memory for visibility checks to
+be fast, but values needn't. With a segment store, values too large to
+inline are appended to segment files instead, Bitcask style, and the
+version keeps only where to find them: segment, offset and length, which
+fit in the space an inline value would have used. The in-memory index is
+the store itself. That lets the data outgrow memory, as long as the keys
+and versions don't.
+
+Each record in a segment is a CRC-32C followed by the value. The active
+segment is appended to until it reaches its maximum size, and then a new
+one is started. Segment files are never modified: versions removed by
+vacuum leave garbage behind in them, and compaction copies whatever is
+still live out of the segments with the most garbage into the active
+one, then deletes them.
+
+Segments are scratch space, not a durable copy of the data; the write-ahead
+log and checkpoints are that. So opening a segment store clears out any
+segments a previous run left in its directory.
+*/
+
+type segmentRef struct {
+	segment uint32
+	offset  uint64
+	length  uint32
+}
+
+func (r segmentRef) payload() payload {
+	p := payload{n: segmentPayload}
+	binary.LittleEndian.PutUint32(p.inline[0:], r.segment)
+	binary.LittleEndian.PutUint64(p.inline[4:], r.offset)
+	binary.LittleEndian.PutUint32(p.inline[12:], r.length)
+	return p
+}
+
+// withSegmentRef returns the segment payload p, moved to ref.
+func (p payload) withSegmentRef(ref segmentRef) payload {
+	moved := ref.payload()
+	moved.kind = p.kind
+	copy(moved.inline[compressedFlag:], p.inline[compressedFlag:])
+	return moved
+}
+
+func (p payload) segmentRef() segmentRef {
+	return segmentRef{
+		segment: binary.LittleEndian.Uint32(p.inline[0:]),
+		offset:  binary.LittleEndian.Uint64(p.inline[4:]),
+		length:  binary.LittleEndian.Uint32(p.inline[12:]),
+	}
+}
+
+type segmentStore struct {
+	dir     string
+	maxSize int64
+
+	mu     sync.RWMutex
+	files  map[uint32]*os.File
+	sizes  map[uint32]int64
+	active uint32
+}
+
+func openSegmentStore(dir string, maxSize int64) (*segmentStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	old, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range old {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &segmentStore{
+		dir:     dir,
+		maxSize: maxSize,
+		files:   map[uint32]*os.File{},
+		sizes:   map[uint32]int64{},
+	}
+	if err := s.rotate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// rotate starts a new active segment.
+func (s *segmentStore) rotate() error {
+	id := s.active + 1
+	f, err := os.OpenFile(filepath.Join(s.dir, fmt.Sprintf("%08d.seg", id)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	s.files[id] = f
+	s.sizes[id] = 0
+	s.active = id
+	return nil
+}
+
+func (s *segmentStore) write(value string) (segmentRef, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := int64(4 + len(value))
+	if s.sizes[s.active] > 0 && s.sizes[s.active]+record > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return segmentRef{}, err
+		}
+	}
+
+	buf := binary.LittleEndian.AppendUint32(nil, crc32.Checksum([]byte(value), castagnoli))
+	buf = append(buf, value...)
+	offset := s.sizes[s.active]
+	if _, err := s.files[s.active].WriteAt(buf, offset); err != nil {
+		return segmentRef{}, err
+	}
+	s.sizes[s.active] += record
+	return segmentRef{s.active, uint64(offset), uint32(len(value))}, nil
+}
+
+func (s *segmentStore) read(ref segmentRef) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, ok := s.files[ref.segment]
+	if !ok {
+		return "", fmt.Errorf("segment store: no segment %d", ref.segment)
+	}
+	buf := make([]byte, 4+ref.length)
+	if _, err := f.ReadAt(buf, int64(ref.offset)); err != nil {
+		return "", fmt.Errorf("segment store: %w", err)
+	}
+	if crc32.Checksum(buf[4:], castagnoli) != binary.LittleEndian.Uint32(buf) {
+		return "", fmt.Errorf("segment store: checksum mismatch in segment %d at offset %d", ref.segment, ref.offset)
+	}
+	return string(buf[4:]), nil
+}
+
+// remove deletes a segment nothing refers to any more.
+func (s *segmentStore) remove(id uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.files[id]
+	delete(s.files, id)
+	delete(s.sizes, id)
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func (s *segmentStore) sync() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var errs []error
+	for _, f := range s.files {
+		errs = append(errs, f.Sync())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *segmentStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, f := range s.files {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// UseSegmentStore keeps large values in segment files in dir, of up to
+// maxSegmentSize bytes each, rather than in memory. The database must
+// still be empty.
+func (d *Database) UseSegmentStore(dir string, maxSegmentSize int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.store.Len() > 0 {
+		return errors.New("segment store: database is not empty")
+	}
+	segments, err := openSegmentStore(dir, maxSegmentSize)
+	if err != nil {
+		return err
+	}
+	d.segments = segments
+	return nil
+}
+
+// withSegmentStore keeps large values in segments, from the start: a
+// database opened on a data directory recovers its values into them.
+func withSegmentStore(segments *segmentStore) Option {
+	return func(d *Database) {
+		d.segments = segments
+	}
+}
+
+// compactSegments rewrites the live values of every inactive segment
+// that is at least minGarbage garbage (by bytes) into the active segment,
+// and deletes those segments. It returns how many it deleted.
+func (d *Database) compactSegments(minGarbage float64) (int, error) {
+	// A frozen store's chains may still point into segments the live
+	// store no longer uses (see frozen.go), so wait for it to thaw.
+	if d.frozen > 0 {
+		return 0, nil
+	}
+	s := d.segments
+	live := map[uint32]int64{}
+	iter := d.store.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		for _, v := range iter.Value() {
+			if v.data.n == segmentPayload {
+				ref := v.data.segmentRef()
+				live[ref.segment] += int64(4 + ref.length)
+			}
+		}
+	}
+
+	s.mu.RLock()
+	compact := map[uint32]bool{}
+	for id, size := range s.sizes {
+		if id != s.active && float64(size-live[id]) >= minGarbage*float64(size) {
+			compact[id] = true
+		}
+	}
+	s.mu.RUnlock()
+	if len(compact) == 0 {
+		return 0, nil
+	}
+
+	for ok := iter.First(); ok; ok = iter.Next() {
+		versions := iter.Value()
+		for i := range versions {
+			v := &versions[i]
+			if v.data.n != segmentPayload || !compact[v.data.segmentRef().segment] {
+				continue
+			}
+			value, err := s.read(v.data.segmentRef())
+			if err != nil {
+				return 0, err
+			}
+			ref, err := s.write(value)
+			if err != nil {
+				return 0, err
+			}
+			v.data = v.data.withSegmentRef(ref)
+		}
+	}
+
+	for id := range compact {
+		if err := s.remove(id); err != nil {
+			return 0, err
+		}
+	}
+	d.logger.Debug("compacted segments", "segments", len(compact))
+	return len(compact), nil
+}
+
+// StartSegmentCompaction compacts segments that are at least minGarbage
+// garbage every interval, until the returned function is called.
+func (d *Database) StartSegmentCompaction(interval time.Duration, minGarbage float64) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.mu.Lock()
+				if d.segments != nil {
+					if _, err := d.compactSegments(minGarbage); err != nil {
+						d.logger.Error("segment compaction failed", "err", err)
+					}
+				}
+				d.mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSegmentStore(t *testing.T) {
+	dir := t.TempDir()
+	database := newDatabase()
+	assertEq(database.UseSegmentStore(dir, 256), nil, "use segment store")
+	defer database.Close()
+
+	big := func(c byte) string { return strings.Repeat(string(c), 100) }
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"small", "stays inline"})
+	c.mustExecCommand("set", []string{"x", big('a')})
+
+	reader := database.newConnection()
+	reader.mustExecCommand("begin", nil)
+	reader.tx.isolation = RepeatableReadIsolation
+
+	for _, ch := range []byte("bcdef") {
+		c.mustExecCommand("set", []string{"x", big(ch)})
+	}
+
+	assertEq(database.versions("small")[0].data.n, uint8(len("stays inline")), "small value inline")
+	assertEq(database.versions("x")[0].data.n, uint8(segmentPayload), "large value in a segment")
+	assertEq(c.mustExecCommand("get", []string{"x"}), big('f'), "latest value")
+	assertEq(reader.mustExecCommand("get", []string{"x"}), big('a'), "old snapshot still readable")
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assertEq(len(segments), 3, "segments rotated")
+
+	// Nothing is garbage yet.
+	database.mu.Lock()
+	n, err := database.compactSegments(0.5)
+	database.mu.Unlock()
+	assertEq(err, nil, "compact")
+	assertEq(n, 0, "nothing to compact")
+
+	reader.mustExecCommand("commit", nil)
+	c.mustExecCommand("vacuum", nil)
+
+	database.mu.Lock()
+	n, err = database.compactSegments(0.5)
+	database.mu.Unlock()
+	assertEq(err, nil, "compact")
+	assertEq(n, 2, "garbage segments compacted")
+
+	segments, _ = filepath.Glob(filepath.Join(dir, "*.seg"))
+	assertEq(len(segments), 1, "segments after compaction")
+	assertEq(c.mustExecCommand("get", []string{"x"}), big('f'), "value after compaction")
+}
+
+func TestSegmentStore_frozen(t *testing.T) {
+	database := newDatabase()
+	assertEq(database.UseSegmentStore(t.TempDir(), 250), nil, "use segment store")
+	defer database.Close()
+
+	c := database.newConnection()
+	for _, ch := range []byte("abcdef") {
+		c.mustExecCommand("set", []string{string(ch), strings.Repeat(string(ch), 100)})
+	}
+	database.mu.Lock()
+	frozen := database.freeze()
+	database.mu.Unlock()
+	for _, ch := range []byte("abcdef") {
+		c.mustExecCommand("delete", []string{string(ch)})
+	}
+	c.mustExecCommand("vacuum", nil)
+
+	database.mu.Lock()
+	n, err := database.compactSegments(0)
+	database.mu.Unlock()
+	assertEq(err, nil, "compact")
+	assertEq(n, 0, "nothing compacted while frozen")
+
+	var values []string
+	tx, _ := database.Begin()
+	database.scanFrozen(frozen, tx, func(key string, versions []Value, visible *Value) {
+		value, err := database.read(&versions[0])
+		assertEq(err, nil, "read frozen value")
+		values = append(values, value)
+	}, nil)
+	tx.Abort()
+	assertEq(len(values), 6, "frozen values still readable")
+	assertEq(values[5], strings.Repeat("f", 100), "frozen value")
+
+	database.mu.Lock()
+	n, err = database.compactSegments(0)
+	database.mu.Unlock()
+	assertEq(err, nil, "compact")
+	assert(n > 0, "compacted once thawed")
+}
+
+func TestSegmentStore_corruption(t *testing.T) {
+	dir := t.TempDir()
+	database := newDatabase()
+	database.UseSegmentStore(dir, 1<<20)
+	defer database.Close()
+	assertEq(database.CreateIndex("city", byCity), nil, "create index")
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", strings.Repeat("x", 100)})
+
+	path := filepath.Join(dir, "00000001.seg")
+	data, _ := os.ReadFile(path)
+	data[10] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+
+	_, err := database.segments.read(database.versions("x")[0].data.segmentRef())
+	assertEq(err.Error(), "segment store: checksum mismatch in segment 1 at offset 0", "corrupt value")
+
+	// Which fails the commands that read it, rather than the server.
+	_, err = c.execCommand("get", []string{"x"})
+	assertEq(err.Error(), "reading value: segment store: checksum mismatch in segment 1 at offset 0", "get of corrupt value")
+	_, err = c.execCommand("rename", []string{"x", "y"})
+	assert(err != nil, "rename of corrupt value")
+	// Indexing needs the value being replaced.
+	_, err = c.execCommand("set", []string{"x", "new"})
+	assert(err != nil, "replacing a corrupt indexed value")
+	_, err = c.execCommand("set", []string{"y", "new"})
+	assertEq(err, nil, "the server carries on")
+}
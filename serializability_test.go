@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+/*
+Serializability is checked the way Elle checks it, on histories of
+transactions that append to lists. Each append adds an element no other
+append adds, so a read of a list shows the whole order in which the
+key's versions were written, and which transaction wrote each. From the
+reads of every transaction that committed, the checker works out how
+they depend on each other: T2 depends on T1 if T1 wrote a version that
+T2 wrote the next of (ww), or that T2 read (wr), or if T1 read a version
+that T2 wrote the next of (rw). The history is serializable only if
+those dependencies have no cycle, and only if nothing read was written
+by a transaction that didn't commit, and no committed append was lost.
+*/
+
+// histOp is a read of a key's list, or an append to it.
+type histOp struct {
+	append bool
+	key    string
+	// The element appended, or the list read.
+	elem int
+	list []int
+}
+
+func (op histOp) String() string {
+	if op.append {
+		return fmt.Sprintf("append %s %d", op.key, op.elem)
+	}
+	return fmt.Sprintf("read %s %v", op.key, op.list)
+}
+
+// histTxn is a transaction in a history.
+type histTxn struct {
+	id        int
+	ops       []histOp
+	committed bool
+}
+
+func (t *histTxn) String() string {
+	return fmt.Sprintf("T%d%v", t.id, t.ops)
+}
+
+// history records the transactions of a concurrent workload.
+type history struct {
+	mu   sync.Mutex
+	txns []*histTxn
+	elem atomic.Int64
+}
+
+func (h *history) add(t *histTxn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t.id = len(h.txns) + 1
+	h.txns = append(h.txns, t)
+}
+
+func parseElems(s string) []int {
+	var list []int
+	for f := range strings.FieldsSeq(s) {
+		n, _ := strconv.Atoi(f)
+		list = append(list, n)
+	}
+	return list
+}
+
+func formatElems(list []int) string {
+	var b strings.Builder
+	for _, n := range list {
+		fmt.Fprint(&b, n, " ")
+	}
+	return b.String()
+}
+
+// runTxn runs a transaction of reads and appends on a few keys, and
+// records it in h.
+func (h *history) runTxn(d *Database, rng *rand.Rand, keys int) {
+	t := &histTxn{}
+	defer h.add(t)
+
+	tx, err := d.Begin()
+	if err != nil {
+		return
+	}
+	read := func(key string) ([]int, error) {
+		value, err := tx.Get(key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return nil, err
+		}
+		list := parseElems(value)
+		t.ops = append(t.ops, histOp{key: key, list: list})
+		return list, nil
+	}
+
+	for range 1 + rng.IntN(4) {
+		key := "k" + strconv.Itoa(rng.IntN(keys))
+		list, err := read(key)
+		if err != nil {
+			tx.Abort()
+			return
+		}
+		runtime.Gosched()
+		if rng.IntN(2) == 0 {
+			continue
+		}
+		elem := int(h.elem.Add(1))
+		if tx.Set(key, formatElems(append(list, elem))) != nil {
+			tx.Abort()
+			return
+		}
+		t.ops = append(t.ops, histOp{append: true, key: key, elem: elem})
+		runtime.Gosched()
+	}
+	t.committed = tx.Commit() == nil
+}
+
+// runHistory runs clients concurrent clients' transactions at level, and
+// returns their history, ending with a transaction that reads every key.
+func runHistory(level IsolationLevel, clients, txns, keys int, seed uint64) []*histTxn {
+	database := newDatabase()
+	database.defaultIsolation = level
+	h := &history{}
+	var wg sync.WaitGroup
+	for c := range clients {
+		rng := rand.New(rand.NewPCG(seed, uint64(c)))
+		wg.Go(func() {
+			for range txns {
+				h.runTxn(&database, rng, keys)
+			}
+		})
+	}
+	wg.Wait()
+
+	final := &histTxn{committed: true}
+	tx, _ := database.Begin()
+	for k := range keys {
+		key := "k" + strconv.Itoa(k)
+		value, _ := tx.Get(key)
+		final.ops = append(final.ops, histOp{key: key, list: parseElems(value)})
+	}
+	tx.Commit()
+	h.add(final)
+	return h.txns
+}
+
+// checkSerializable returns why history isn't serializable, or nil if it
+// is.
+func checkSerializable(history []*histTxn) error {
+	// Who appended each element, and each key's version order: the
+	// longest list read of it, which every other must be a prefix of.
+	writer := map[int]*histTxn{}
+	orders := map[string][]int{}
+	for _, t := range history {
+		for _, op := range t.ops {
+			if op.append {
+				writer[op.elem] = t
+			}
+		}
+	}
+	for _, t := range history {
+		if !t.committed {
+			continue
+		}
+		for _, op := range t.ops {
+			if op.append {
+				continue
+			}
+			for _, e := range op.list {
+				if w := writer[e]; w == nil || !w.committed {
+					return fmt.Errorf("%s read %d, which no committed transaction appended", t, e)
+				}
+			}
+			order := orders[op.key]
+			if len(op.list) > len(order) {
+				order, orders[op.key] = op.list, op.list
+			}
+			if !slices.Equal(op.list, order[:len(op.list)]) {
+				return fmt.Errorf("%s read %s = %v, but another read %v", t, op.key, op.list, order)
+			}
+		}
+	}
+	for _, t := range history {
+		for _, op := range t.ops {
+			if op.append && t.committed && !slices.Contains(orders[op.key], op.elem) {
+				return fmt.Errorf("%s committed, but its append of %d to %s was lost", t, op.elem, op.key)
+			}
+		}
+	}
+
+	// The dependencies between committed transactions.
+	deps := map[*histTxn]map[*histTxn]string{}
+	depend := func(from, to *histTxn, kind string) {
+		if from == to {
+			return
+		}
+		if deps[from] == nil {
+			deps[from] = map[*histTxn]string{}
+		}
+		deps[from][to] = kind
+	}
+	for key, order := range orders {
+		for i := 1; i < len(order); i++ {
+			depend(writer[order[i-1]], writer[order[i]], "ww "+key)
+		}
+	}
+	for _, t := range history {
+		if !t.committed {
+			continue
+		}
+		for _, op := range t.ops {
+			if op.append {
+				continue
+			}
+			if n := len(op.list); n > 0 {
+				depend(writer[op.list[n-1]], t, "wr "+op.key)
+			}
+			if order := orders[op.key]; len(op.list) < len(order) {
+				depend(t, writer[order[len(op.list)]], "rw "+op.key)
+			}
+		}
+	}
+	if cycle := findCycle(history, deps); cycle != nil {
+		return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// findCycle returns a cycle in deps, as the transactions and
+// dependencies along it, or nil if there is none.
+func findCycle(history []*histTxn, deps map[*histTxn]map[*histTxn]string) []string {
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := map[*histTxn]int{}
+	var path []*histTxn
+	var visit func(t *histTxn) []string
+	visit = func(t *histTxn) []string {
+		state[t] = onPath
+		path = append(path, t)
+		// In order, so the same history finds the same cycle.
+		next := make([]*histTxn, 0, len(deps[t]))
+		for u := range deps[t] {
+			next = append(next, u)
+		}
+		slices.SortFunc(next, func(a, b *histTxn) int { return a.id - b.id })
+		for _, u := range next {
+			switch state[u] {
+			case onPath:
+				start := slices.Index(path, u)
+				var cycle []string
+				for i, v := range path[start:] {
+					w := u
+					if start+i+1 < len(path) {
+						w = path[start+i+1]
+					}
+					cycle = append(cycle, fmt.Sprintf("T%d (%s)", v.id, deps[v][w]))
+				}
+				return append(cycle, fmt.Sprintf("T%d", u.id))
+			case unvisited:
+				if cycle := visit(u); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[t] = done
+		return nil
+	}
+	for _, t := range history {
+		if state[t] == unvisited {
+			if cycle := visit(t); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+func TestSerializableHistories(t *testing.T) {
+	for seed := range uint64(10) {
+		history := runHistory(SerializableIsolation, 8, 40, 4, seed)
+		if err := checkSerializable(history); err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+	}
+}
+
+func TestCheckSerializable(t *testing.T) {
+	read := func(key string, list ...int) histOp { return histOp{key: key, list: list} }
+	appendTo := func(key string, elem int) histOp { return histOp{append: true, key: key, elem: elem} }
+	txn := func(id int, committed bool, ops ...histOp) *histTxn {
+		return &histTxn{id: id, ops: ops, committed: committed}
+	}
+
+	// Transactions one after another.
+	assertEq(checkSerializable([]*histTxn{
+		txn(1, true, read("x"), appendTo("x", 1)),
+		txn(2, true, read("x", 1), appendTo("x", 2), read("y")),
+		txn(3, false, read("x", 1, 2), appendTo("x", 3)),
+		txn(4, true, read("x", 1, 2), read("y")),
+	}), nil, "serial")
+
+	// Write skew: each reads what the other writes, before it does.
+	err := checkSerializable([]*histTxn{
+		txn(1, true, read("x"), read("y"), appendTo("x", 1)),
+		txn(2, true, read("x"), read("y"), appendTo("y", 2)),
+		txn(3, true, read("x", 1), read("y", 2)),
+	})
+	assert(err != nil && strings.Contains(err.Error(), "T1 (rw y) -> T2 (rw x) -> T1"), fmt.Sprint("write skew: ", err))
+
+	// A lost update: both read x empty and append to it.
+	err = checkSerializable([]*histTxn{
+		txn(1, true, read("x"), appendTo("x", 1)),
+		txn(2, true, read("x"), appendTo("x", 2)),
+		txn(3, true, read("x", 2)),
+	})
+	assert(err != nil && strings.Contains(err.Error(), "was lost"), fmt.Sprint("lost update: ", err))
+
+	// Reading an aborted append.
+	err = checkSerializable([]*histTxn{
+		txn(1, false, read("x"), appendTo("x", 1)),
+		txn(2, true, read("x", 1)),
+	})
+	assert(err != nil && strings.Contains(err.Error(), "no committed transaction"), fmt.Sprint("aborted read: ", err))
+}
+
+func TestSnapshotIsolationHistories(t *testing.T) {
+	// Snapshot Isolation allows write skew, and the checker finds the
+	// cycle it makes.
+	for seed := range uint64(10) {
+		err := checkSerializable(runHistory(SnapshotIsolation, 8, 40, 4, seed))
+		if err != nil {
+			assert(strings.Contains(err.Error(), "rw"), fmt.Sprint("write skew: ", err))
+			return
+		}
+	}
+	t.Fatal("no write skew found")
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+The server makes the database usable from outside Go, over TCP, or over a
+unix socket when the client is on the same machine and TCP is unwanted.
+Each accepted connection gets a Connection of its own. NewServer speaks
+the line protocol described here; NewRESPServer speaks Redis's (see
+resp.go).
+
+In the line protocol, the client sends one command per line, and gets one
+response line back for each, in order. Clients may send several commands
+without waiting; responses are flushed whenever the server has caught up
+with what it has read.
+
+Words in a command are separated by spaces. A word with spaces, quotes or
+newlines in it is written as a Go double-quoted string:
+
+	set greeting "hello,\nworld"
+
+Keys and values are bytes, not text, and quoting can spell any bytes, as
+\x escapes for those that aren't printable UTF-8, so binary keys and
+values go both ways intact:
+
+	set blob "\x00\xff\x10"
+
+Lines of SQL are taken too (see sql.go).
+
+A response is one of:
+
+	OK "value"     the command succeeded; its value, quoted the same way
+	NIL            the key has no visible value
+	ERR message    the command failed
+
+"quit" closes the connection. When a client goes away, whether it quit or
+not, whatever transaction it left open is aborted.
+*/
+
+var ErrServerClosed = errors.New("server closed")
+
+type Server struct {
+	db *Database
+	// Speaks the protocol with one client until it disconnects or quits.
+	protocol func(c *Connection, r *bufio.Reader, w *bufio.Writer)
+
+	// See auth.go.
+	tlsConfig *tls.Config
+	users     map[string]User
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	handlers  sync.WaitGroup
+}
+
+// NewServer returns a server speaking the line protocol.
+func NewServer(db *Database) *Server {
+	return newServer(db, serveLines)
+}
+
+func newServer(db *Database, protocol func(*Connection, *bufio.Reader, *bufio.Writer)) *Server {
+	return &Server{
+		db:        db,
+		protocol:  protocol,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+// to it until the server is closed.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// ListenAndServeUnix listens on a unix socket at path, with permissions
+// perm, and serves connections to it until the server is closed. A socket
+// left at path by an earlier run is replaced; anything else there is an
+// error.
+func (s *Server) ListenAndServeUnix(path string, perm os.FileMode) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode().Type() == os.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		ln.Close()
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until the server is closed, when it
+// returns ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, ln)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.handlers.Add(1)
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+// Close stops accepting connections, closes the open ones, aborting their
+// transactions, and waits for them to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var errs []error
+	for ln := range s.listeners {
+		errs = append(errs, ln.Close())
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.handlers.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *Server) handle(conn net.Conn) {
+	c := s.db.newConnection()
+	c.users = s.users
+	c.remote = conn.RemoteAddr().String()
+	defer func() {
+		if err := c.Close(); err != nil {
+			s.db.logger.Warn("aborting transaction on disconnect failed", "err", err)
+		}
+		conn.Close()
+
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.handlers.Done()
+	}()
+
+	s.protocol(c, bufio.NewReader(conn), bufio.NewWriter(conn))
+}
+
+func serveLines(c *Connection, r *bufio.Reader, w *bufio.Writer) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		sql := isSQL(line)
+		var words []string
+		if !sql {
+			words, err = parseCommandLine(line)
+		}
+		switch {
+		case sql:
+			res, err := c.execSQL(line)
+			writeResponse(w, res, err)
+		case err != nil:
+			writeResponse(w, Result{}, err)
+		case len(words) == 0:
+			continue
+		case words[0] == "quit":
+			writeResponse(w, Result{}, nil)
+			w.Flush()
+			return
+		default:
+			res, err := c.execCommand(words[0], words[1:])
+			writeResponse(w, res, err)
+		}
+
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// parseCommandLine splits a line into words, unquoting quoted ones.
+func parseCommandLine(line string) ([]string, error) {
+	var words []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return words, nil
+		}
+
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			words = append(words, line[:end])
+			line = line[end:]
+			continue
+		}
+
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("unterminated or invalid quoted string")
+		}
+		word, _ := strconv.Unquote(quoted)
+		words = append(words, word)
+		line = line[len(quoted):]
+		if line != "" && line[0] != ' ' && line[0] != '\t' {
+			return nil, fmt.Errorf("missing space after quoted string")
+		}
+	}
+}
+
+func writeResponse(w *bufio.Writer, res Result, err error) {
+	w.WriteString(responseLine(res, err))
+	w.WriteByte('\n')
+}
+
+// responseLine formats a command's response, without the newline.
+func responseLine(res Result, err error) string {
+	switch {
+	case res.NotFound:
+		return "NIL"
+	case err != nil:
+		return "ERR " + strings.ReplaceAll(err.Error(), "\n", " ")
+	default:
+		return "OK " + strconv.Quote(res.Value)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func startServer(db *Database) (*Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assertEq(err, nil, "listen")
+	srv := NewServer(db)
+	go srv.Serve(ln)
+	return srv, ln.Addr().String()
+}
+
+type testClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	assertEq(err, nil, "dial")
+	return &testClient{conn, bufio.NewReader(conn)}
+}
+
+func (c *testClient) send(lines ...string) {
+	for _, line := range lines {
+		fmt.Fprintf(c.conn, "%s\n", line)
+	}
+}
+
+func (c *testClient) response() string {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	assertEq(err, nil, "read response")
+	return line[:len(line)-1]
+}
+
+func (c *testClient) do(line string) string {
+	c.send(line)
+	return c.response()
+}
+
+func TestServer(t *testing.T) {
+	database := newDatabase()
+	srv, addr := startServer(&database)
+	defer srv.Close()
+
+	c := dial(addr)
+	assertEq(c.do(`set greeting "hello,\nworld"`), `OK "hello,\nworld"`, "set")
+	assertEq(c.do("get greeting"), `OK "hello,\nworld"`, "get")
+	assertEq(c.do("get missing"), "NIL", "missing key")
+	assertEq(c.do("get"), "ERR get: wrong number of arguments", "arity")
+	assertEq(c.do("commit"), "ERR no transaction in progress", "commit outside a transaction")
+	assertEq(c.do(`set "unterminated`), "ERR unterminated or invalid quoted string", "bad quoting")
+	c.send("")
+	assertEq(c.do(`set empty ""`), `OK ""`, "blank lines are skipped")
+	c.do(`set "\xff\x00key" "\x00\xff\x10"`)
+	assertEq(c.do(`get "\xff\x00key"`), `OK "\x00\xff\x10"`, "binary keys and values")
+
+	// Pipelined.
+	c.send("begin", "set a 1", "set b 2", "commit", "mget a b")
+	var responses []string
+	for range 5 {
+		responses = append(responses, c.response())
+	}
+	assertEq(responses[4], `OK "a=1\nb=2"`, "pipelined commands")
+
+	assertEq(c.do("quit"), `OK ""`, "quit")
+	_, err := c.r.ReadString('\n')
+	assert(err != nil, "connection closed after quit")
+}
+
+func TestServer_disconnectAbortsTransaction(t *testing.T) {
+	database := newDatabase()
+	srv, addr := startServer(&database)
+	defer srv.Close()
+
+	c := dial(addr)
+	c.do("begin")
+	assertEq(c.do("set x 1"), `OK "1"`, "set in transaction")
+	c.conn.Close()
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		database.mu.Lock()
+		open := database.hasInProgress()
+		database.mu.Unlock()
+		if !open {
+			break
+		}
+		assert(time.Now().Before(deadline), "transaction aborted on disconnect")
+		time.Sleep(time.Millisecond)
+	}
+	assertEq(dial(addr).do("get x"), "NIL", "write discarded")
+}
+
+func TestServer_Close(t *testing.T) {
+	database := newDatabase()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assertEq(err, nil, "listen")
+	srv := NewServer(&database)
+	served := make(chan error)
+	go func() { served <- srv.Serve(ln) }()
+
+	c := dial(ln.Addr().String())
+	c.do("begin")
+	c.do("set x 1")
+
+	assertEq(srv.Close(), nil, "close")
+	assertEq(<-served, ErrServerClosed, "serve returns")
+	assert(!database.hasInProgress(), "open transaction aborted")
+	_, err = c.r.ReadString('\n')
+	assert(err != nil, "client disconnected")
+}
+
+func TestParseCommandLine(t *testing.T) {
+	words, err := parseCommandLine(`  set  "a key" "tab\there" plain  `)
+	assertEq(err, nil, "parse")
+	assert(slices.Equal(words, []string{"set", "a key", "tab\there", "plain"}), fmt.Sprint(words))
+
+	_, err = parseCommandLine(`set "a"b`)
+	assert(err != nil, "quoted word must end at a space")
+}
+
+func TestServer_unix(t *testing.T) {
+	database := newDatabase()
+	srv := NewServer(&database)
+	path := filepath.Join(t.TempDir(), "mvcc.sock")
+
+	// A socket left over from an earlier run.
+	stale, err := net.Listen("unix", path)
+	assertEq(err, nil, "stale listener")
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	served := make(chan error)
+	go func() { served <- srv.ListenAndServeUnix(path, 0o660) }()
+
+	var conn net.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if conn, err = net.Dial("unix", path); err == nil {
+			break
+		}
+		assert(time.Now().Before(deadline), "socket listening")
+		time.Sleep(time.Millisecond)
+	}
+	c := &testClient{conn, bufio.NewReader(conn)}
+	assertEq(c.do("set x 1"), `OK "1"`, "set over the socket")
+
+	fi, err := os.Stat(path)
+	assertEq(err, nil, "stat")
+	assertEq(fi.Mode().Perm(), os.FileMode(0o660), "socket permissions")
+
+	srv.Close()
+	assertEq(<-served, ErrServerClosed, "serve returns")
+	_, err = os.Stat(path)
+	assert(os.IsNotExist(err), "socket removed")
+
+	os.WriteFile(path, nil, 0o644)
+	assert(NewServer(&database).ListenAndServeUnix(path, 0o600) != nil, "won't replace a regular file")
+}
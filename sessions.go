@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+Frontends whose requests don't arrive on a connection of their own, like
+gRPC and HTTP, tie them together by transaction instead. Beginning opens a
+Connection and starts a transaction on it, and later requests name the
+transaction by the handle beginning returned until it commits or aborts.
+A request with no handle runs on a Connection of its own, in autocommit
+mode.
+
+Handles are random rather than the transaction's id, so that one client
+can't guess another's. With authentication required, every request
+authenticates, runs as its user, and can only name transactions its user
+began.
+
+Requests on the same transaction are run one at a time, in the order they
+arrive. Each runs in its request's context, for tracing.
+*/
+
+var ErrUnknownTransaction = errors.New("no such transaction")
+
+type txSessions struct {
+	db *Database
+	// The users requests must authenticate as, nil if they needn't.
+	users map[string]User
+
+	mu       sync.Mutex
+	byHandle map[uint64]*txSession
+}
+
+type txSession struct {
+	// Held for the duration of each request on the connection.
+	mu sync.Mutex
+	c  *Connection
+	// Who began the transaction, if requests authenticate.
+	owner string
+}
+
+func newTxSessions(db *Database) *txSessions {
+	return &txSessions{db: db, byHandle: map[uint64]*txSession{}}
+}
+
+type sessionUserKey struct{}
+
+// authenticate checks a request's credentials, if it gave any, and
+// returns its context carrying the user it authenticated as. Without
+// authentication required, it returns ctx as it is.
+func (s *txSessions) authenticate(ctx context.Context, name, password string, given bool) (context.Context, error) {
+	if s.users == nil {
+		return ctx, nil
+	}
+	if !given {
+		return nil, ErrAuthRequired
+	}
+	u, found := s.users[name]
+	// As auth does.
+	if !passwordsMatch(u.Password, password) || !found {
+		return nil, ErrBadCredentials
+	}
+	return context.WithValue(ctx, sessionUserKey{}, &u), nil
+}
+
+// connection opens a Connection for a request, as its user.
+func (s *txSessions) connection(ctx context.Context) *Connection {
+	c := s.db.newConnection()
+	c.users = s.users
+	c.user, _ = ctx.Value(sessionUserKey{}).(*User)
+	c.ctx = ctx
+	return c
+}
+
+func (s *txSessions) begin(ctx context.Context) (uint64, error) {
+	c := s.connection(ctx)
+	_, err := c.execCommand("begin", nil)
+	c.ctx = nil
+	if err != nil {
+		c.Close()
+		return 0, err
+	}
+
+	sess := &txSession{c: c}
+	if c.user != nil {
+		sess.owner = c.user.Name
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handle := s.newHandle()
+	s.byHandle[handle] = sess
+	return handle, nil
+}
+
+// newHandle returns a handle no session has, at random. The caller must
+// hold s.mu.
+func (s *txSessions) newHandle() uint64 {
+	var b [8]byte
+	for {
+		rand.Read(b[:])
+		// 53 bits, so JSON clients that parse numbers as doubles keep
+		// them whole.
+		handle := binary.LittleEndian.Uint64(b[:]) >> 11
+		if _, taken := s.byHandle[handle]; handle != 0 && !taken {
+			return handle
+		}
+	}
+}
+
+// session returns the session handle names, if the request's user began
+// it. Another user's is as unknown as one that doesn't exist.
+func (s *txSessions) session(ctx context.Context, handle uint64) (*txSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.byHandle[handle]
+	if ok && s.users != nil {
+		u, _ := ctx.Value(sessionUserKey{}).(*User)
+		ok = u != nil && u.Name == sess.owner
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownTransaction, handle)
+	}
+	return sess, nil
+}
+
+// run runs command in the transaction handle names, or on a connection of
+// its own if handle is zero.
+func (s *txSessions) run(ctx context.Context, handle uint64, command string, args ...string) (Result, error) {
+	if handle == 0 {
+		c := s.connection(ctx)
+		defer c.Close()
+		return c.execCommand(command, args)
+	}
+
+	sess, err := s.session(ctx, handle)
+	if err != nil {
+		return Result{}, err
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	// Another request may have finished it while this one waited, and
+	// the connection would run the command in a transaction of its own.
+	if sess.c.tx == nil {
+		return Result{}, fmt.Errorf("%w: %d", ErrUnknownTransaction, handle)
+	}
+
+	sess.c.ctx = ctx
+	res, err := sess.c.execCommand(command, args)
+	sess.c.ctx = nil
+	// Committed, aborted, or aborted behind its back.
+	if sess.c.tx == nil {
+		s.mu.Lock()
+		delete(s.byHandle, handle)
+		s.mu.Unlock()
+	}
+	return res, err
+}
+
+// finish commits or aborts the transaction handle names, which must
+// exist.
+func (s *txSessions) finish(ctx context.Context, handle uint64, command string) error {
+	if handle == 0 {
+		return ErrNoTransaction
+	}
+	_, err := s.run(ctx, handle, command)
+	return err
+}
+
+// authorizeScan refuses a scan of opts's range the request's user may not
+// read, as the scan command's authorization does.
+func (s *txSessions) authorizeScan(ctx context.Context, opts ScanOptions) error {
+	if s.users == nil {
+		return nil
+	}
+	u, _ := ctx.Value(sessionUserKey{}).(*User)
+	if u == nil {
+		return ErrAuthRequired
+	}
+	start, end := opts.bounds()
+	if !u.Admin && !s.db.acl.allows(u.Name, PermRead, start, end) {
+		return fmt.Errorf("%w: scan needs %s on [%q, %q)", ErrPermissionDenied, PermRead, start, end)
+	}
+	return nil
+}
+
+// with calls fn with the transaction handle names, or with a read-only
+// transaction of its own if handle is zero. It doesn't authorize what fn
+// does.
+func (s *txSessions) with(ctx context.Context, handle uint64, fn func(tx *Transaction) error) error {
+	if handle == 0 {
+		tx, err := s.db.BeginContext(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Abort()
+		return fn(tx)
+	}
+
+	sess, err := s.session(ctx, handle)
+	if err != nil {
+		return err
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.c.tx == nil {
+		return ErrNoTransaction
+	}
+	return fn(sess.c.tx)
+}
+
+// close aborts the transactions that were begun and never finished.
+func (s *txSessions) close() {
+	s.mu.Lock()
+	sessions := s.byHandle
+	s.byHandle = map[uint64]*txSession{}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.mu.Lock()
+		sess.c.Close()
+		sess.mu.Unlock()
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTxSessions_finished(t *testing.T) {
+	database := newDatabase()
+	s := newTxSessions(&database)
+	ctx := context.Background()
+	handle, err := s.begin(ctx)
+	assertEq(err, nil, "begin")
+
+	// Finished by a request that got there first, as far as one waiting
+	// for the session is concerned.
+	sess, err := s.session(ctx, handle)
+	assertEq(err, nil, "session")
+	sess.c.mustExecCommand("commit", nil)
+
+	_, err = s.run(ctx, handle, "set", "x", "1")
+	assert(errors.Is(err, ErrUnknownTransaction), "finished transaction")
+	_, err = database.newConnection().execCommand("get", []string{"x"})
+	assert(errors.Is(err, ErrKeyNotFound), "not run in a transaction of its own")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+A set holds distinct members under one key, for membership: the tags on
+a post, the users in a group. Like a list (see list.go) it is a value of
+its own type, and every change writes the whole set as a new version, so
+a transaction sees the set as it was in its snapshot, and two transactions
+adding to one set conflict, even with different members.
+
+As in Redis, adding to a missing key makes a set, and removing its last
+member deletes the key. The members are kept in order, encoded as a
+list's elements are.
+*/
+
+// members returns the members of the set held by key, in order, or none
+// if the key is missing. The caller must hold the lock.
+func (t *Transaction) members(key string) ([]string, error) {
+	value, err := t.get(key)
+	if err != nil {
+		return nil, nil
+	}
+	if value.data.kind != SetType {
+		return nil, wrongType(key, value.data.kind, SetType)
+	}
+	raw, err := t.db.readRaw(value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeList(raw), nil
+}
+
+// SAdd adds members to the set held by key, returning how many of them
+// it didn't have before.
+func (t *Transaction) SAdd(key string, members ...string) (int, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, err
+	}
+	set, err := t.members(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, m := range members {
+		if i, found := slices.BinarySearch(set, m); !found {
+			set = slices.Insert(set, i, m)
+			added++
+		}
+	}
+	t.write(key, encodeList(set), SetType, 0)
+	return added, nil
+}
+
+// SRem removes members from the set held by key, returning how many of
+// them it had.
+func (t *Transaction) SRem(key string, members ...string) (int, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, err
+	}
+	set, err := t.members(key)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, m := range members {
+		if i, found := slices.BinarySearch(set, m); found {
+			set = slices.Delete(set, i, i+1)
+			removed++
+		}
+	}
+	switch {
+	case removed == 0:
+	case len(set) == 0:
+		t.delete(key)
+	default:
+		t.write(key, encodeList(set), SetType, 0)
+	}
+	return removed, nil
+}
+
+// SMembers returns the members of the set held by key, in order.
+func (t *Transaction) SMembers(key string) ([]string, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return nil, err
+	}
+	return t.members(key)
+}
+
+// sadd key member...
+// srem key member...
+func (c *Connection) sadd(command string, args []string) (Result, error) {
+	if len(args) < 2 {
+		return Result{}, fmt.Errorf("%s: expected a key and members", command)
+	}
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	add := c.tx.SAdd
+	if command == "srem" {
+		add = c.tx.SRem
+	}
+	n, err := add(args[0], args[1:]...)
+	if err != nil {
+		return res, err
+	}
+	res.Value = strconv.Itoa(n)
+	return res, nil
+}
+
+// smembers key
+func (c *Connection) smembers(args []string) (Result, error) {
+	members, err := c.tx.SMembers(args[0])
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Keys: args[:1], TxId: c.tx.id, Value: strings.Join(members, "\n"), Values: members}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	c := database.newConnection()
+
+	assertEq(c.mustExecCommand("sadd", []string{"s", "b", "a", "b"}), "2", "sadd")
+	assertEq(c.mustExecCommand("sadd", []string{"s", "c", "a"}), "1", "one new member")
+	assertEq(c.mustExecCommand("smembers", []string{"s"}), "a\nb\nc", "smembers")
+	assertEq(c.mustExecCommand("get", []string{"s"}), `["a","b","c"]`, "as a string")
+	assertEq(c.mustExecCommand("type", []string{"s"}), "set", "type")
+	assertEq(c.mustExecCommand("smembers", []string{"missing"}), "", "missing key")
+
+	snapshot := database.newConnection()
+	snapshot.mustExecCommand("begin", nil)
+	assertEq(c.mustExecCommand("srem", []string{"s", "a", "z"}), "1", "srem")
+	assertEq(snapshot.mustExecCommand("smembers", []string{"s"}), "a\nb\nc", "snapshot")
+	snapshot.mustExecCommand("abort", nil)
+
+	// Adding different members still conflicts.
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	t1.SAdd("s", "x")
+	t2.SAdd("s", "y")
+	assertEq(t1.Commit(), nil, "first")
+	var conflict *ConflictError
+	assert(errors.As(t2.Commit(), &conflict), "second conflicts")
+
+	assertEq(c.mustExecCommand("srem", []string{"s", "b", "c", "x"}), "3", "srem the rest")
+	_, err := c.execCommand("get", []string{"s"})
+	assertEq(err, ErrKeyNotFound, "removing the last member deletes the key")
+
+	c.mustExecCommand("set", []string{"str", "x"})
+	_, err = c.execCommand("sadd", []string{"str", "x"})
+	assert(errors.Is(err, ErrWrongType), "not a set")
+}
@@ -0,0 +1,67 @@
+package main
+
+import "errors"
+
+/*
+setnx and getdel are the usual building blocks for locks and queues.
+Both read and write in a single statement, so both land in the readset
+and the writeset: two transactions racing to setnx the same lock key both
+see it free, and Snapshot Isolation lets only the first of them commit.
+*/
+
+// SetNX sets key only if it has no visible value, reporting whether it
+// did.
+func (t *Transaction) SetNX(key, value string) (bool, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return false, err
+	}
+
+	if _, err := t.get(key); err == nil {
+		return false, nil
+	}
+	t.set(key, value)
+	return true, nil
+}
+
+// GetDel deletes key, returning the value it had.
+func (t *Transaction) GetDel(key string) (string, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return "", err
+	}
+
+	value, err := t.get(key)
+	if err != nil {
+		return "", err
+	}
+	s, err := t.db.read(value)
+	if err != nil {
+		return "", err
+	}
+	t.delete(key)
+	return s, nil
+}
+
+// setnx key value
+func (c *Connection) setnx(args []string) (Result, error) {
+	res := Result{Keys: args[:1], TxId: c.tx.id, Value: "0"}
+	ok, err := c.tx.SetNX(args[0], args[1])
+	if ok {
+		res.Value = "1"
+	}
+	return res, err
+}
+
+// getdel key
+func (c *Connection) getdel(args []string) (Result, error) {
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	value, err := c.tx.GetDel(args[0])
+	res.Value = value
+	res.NotFound = errors.Is(err, ErrKeyNotFound)
+	return res, err
+}
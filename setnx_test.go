@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetNX(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c2 := database.newConnection()
+	c2.mustExecCommand("begin", nil)
+
+	// Both see the lock free...
+	assertEq(c1.mustExecCommand("setnx", []string{"lock", "c1"}), "1", "c1 setnx")
+	assertEq(c1.mustExecCommand("setnx", []string{"lock", "again"}), "0", "c1 setnx again")
+	assertEq(c2.mustExecCommand("setnx", []string{"lock", "c2"}), "1", "c2 setnx")
+
+	// ...but only one of them gets it.
+	c1.mustExecCommand("commit", nil)
+	_, err := c2.execCommand("commit", nil)
+	assertEq(err.Error(), `write-write conflict with transaction 1 on "lock"`, "c2 commit")
+
+	c3 := database.newConnection()
+	assertEq(c3.mustExecCommand("get", []string{"lock"}), "c1", "lock holder")
+}
+
+func TestGetDel(t *testing.T) {
+	database := newDatabase()
+	database.Set("job", "payload")
+
+	c := database.newConnection()
+	assertEq(c.mustExecCommand("getdel", []string{"job"}), "payload", "getdel job")
+
+	res, err := c.execCommand("getdel", []string{"job"})
+	assert(errors.Is(err, ErrKeyNotFound), "getdel job again")
+	assertEq(res.NotFound, true, "getdel job again")
+}
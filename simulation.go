@@ -0,0 +1,341 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Concurrency bugs in isolation and conflict detection show up only under
+particular interleavings, which real goroutines produce at random and
+never the same way twice. A simulation takes the scheduling out of the Go
+runtime's hands: its clients are state machines rather than goroutines,
+and a scheduler seeded with a number picks which client takes the next
+step, and how far the database's clock moves on before it, so a seed
+always produces the same run, step for step.
+
+The workload is a bank: transfers read two accounts and move money
+between them, audits read every account and the first again, and a
+client sometimes aborts instead of committing. Every value written is tagged with the
+transaction that wrote it, so each read can be checked against what the
+isolation level promises:
+
+  - every level but Read Uncommitted: no read sees another transaction's
+    write before it commits, or ever if it aborts, and a transaction
+    reads its own writes;
+  - Repeatable Read and stricter: reading a key again gives the same
+    value, unless the transaction wrote the key since;
+  - Snapshot Isolation and stricter: audits see the total the accounts
+    started with, and so do the accounts at the end, since no update can
+    be lost.
+
+The first broken promise stops the run, with the trace of every step so
+far, and the seed that reproduces it.
+*/
+
+// SimulationConfig describes a simulated run.
+type SimulationConfig struct {
+	Seed     uint64
+	Clients  int
+	Steps    int
+	Accounts int
+	// The isolation level transactions run at, and the one whose
+	// promises are checked, which is the same unless Check is set.
+	Isolation IsolationLevel
+	Check     *IsolationLevel
+}
+
+// SimulationFailure is a run that broke a promise.
+type SimulationFailure struct {
+	Seed  uint64
+	Step  int
+	Err   error
+	Trace []string
+}
+
+func (f *SimulationFailure) Error() string {
+	return fmt.Sprintf("simulation %d failed at step %d: %v", f.Seed, f.Step, f.Err)
+}
+
+func (f *SimulationFailure) Unwrap() error {
+	return f.Err
+}
+
+const simulationBalance = 100
+
+// simulation is a run in progress.
+type simulation struct {
+	cfg     SimulationConfig
+	check   IsolationLevel
+	rng     *rand.Rand
+	db      *Database
+	now     time.Time
+	clients []*simClient
+	// The tags of the transactions that have committed.
+	committed map[string]bool
+	trace     []string
+}
+
+// simClient is one client's state.
+type simClient struct {
+	name string
+	conn *Connection
+	// The steps of its transaction left to take, if it has one, and its
+	// tag, reads and writes so far.
+	ops    []simOp
+	tag    string
+	reads  map[string]string
+	writes map[string]string
+	n      int
+}
+
+type simOp struct {
+	kind string
+	key  string
+	// For transfers, what to move from key, or if negative, to it.
+	amount int
+}
+
+// Simulate runs the simulation cfg describes, returning its trace, or a
+// *SimulationFailure if it broke a promise.
+func Simulate(cfg SimulationConfig) ([]string, error) {
+	cfg.Clients = cmp.Or(cfg.Clients, 4)
+	cfg.Steps = cmp.Or(cfg.Steps, 500)
+	cfg.Accounts = max(cmp.Or(cfg.Accounts, 4), 2)
+
+	database := newDatabase()
+	s := &simulation{
+		cfg:       cfg,
+		check:     cfg.Isolation,
+		rng:       rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+		db:        &database,
+		now:       time.Unix(0, 0),
+		committed: map[string]bool{"init": true},
+	}
+	if cfg.Check != nil {
+		s.check = *cfg.Check
+	}
+	s.db.defaultIsolation = cfg.Isolation
+	s.db.now = func() time.Time { return s.now }
+
+	setup := s.db.newConnection()
+	for i := range cfg.Accounts {
+		setup.mustExecCommand("set", []string{s.account(i), simValue(simulationBalance, "init")})
+	}
+	for i := range cfg.Clients {
+		s.clients = append(s.clients, &simClient{name: "c" + strconv.Itoa(i), conn: s.db.newConnection()})
+	}
+
+	for step := range cfg.Steps {
+		s.now = s.now.Add(time.Duration(s.rng.IntN(5)) * time.Millisecond)
+		var err error
+		if s.rng.IntN(50) == 0 {
+			s.trace = append(s.trace, fmt.Sprintf("vacuum removed %d", s.db.Vacuum()))
+		} else {
+			err = s.step(s.clients[s.rng.IntN(len(s.clients))])
+		}
+		if err != nil {
+			return s.trace, &SimulationFailure{cfg.Seed, step, err, s.trace}
+		}
+	}
+	if err := s.finish(); err != nil {
+		return s.trace, &SimulationFailure{cfg.Seed, cfg.Steps, err, s.trace}
+	}
+	return s.trace, nil
+}
+
+func (s *simulation) account(i int) string {
+	return "account" + strconv.Itoa(i)
+}
+
+// simValue formats a balance written by the transaction tagged tag.
+func simValue(balance int, tag string) string {
+	return strconv.Itoa(balance) + "@" + tag
+}
+
+func parseSimValue(v string) (int, string, error) {
+	balance, tag, ok := strings.Cut(v, "@")
+	n, err := strconv.Atoi(balance)
+	if !ok || err != nil {
+		return 0, "", fmt.Errorf("bad value %q", v)
+	}
+	return n, tag, nil
+}
+
+// step takes c's next step, beginning a transaction if it has none.
+func (s *simulation) step(c *simClient) error {
+	if c.ops == nil {
+		return s.begin(c)
+	}
+	op := c.ops[0]
+	c.ops = c.ops[1:]
+	switch op.kind {
+	case "get":
+		return s.get(c, op.key)
+	case "add":
+		balance, _, err := parseSimValue(c.reads[op.key])
+		if err != nil {
+			return err
+		}
+		return s.set(c, op.key, balance-op.amount)
+	case "audit":
+		return s.audit(c)
+	}
+	return s.complete(c, op.kind)
+}
+
+// begin begins a transfer or an audit.
+func (s *simulation) begin(c *simClient) error {
+	if err := c.start(); err != nil {
+		return err
+	}
+	end := "commit"
+	if s.rng.IntN(5) == 0 {
+		end = "abort"
+	}
+	if s.rng.IntN(4) == 0 {
+		for i := range s.cfg.Accounts {
+			c.ops = append(c.ops, simOp{kind: "get", key: s.account(i)})
+		}
+		c.ops = append(c.ops, simOp{kind: "get", key: s.account(0)}, simOp{kind: "audit"}, simOp{kind: end})
+		s.trace = append(s.trace, c.tag+" begin audit")
+		return nil
+	}
+
+	from := s.rng.IntN(s.cfg.Accounts)
+	to := (from + 1 + s.rng.IntN(s.cfg.Accounts-1)) % s.cfg.Accounts
+	amount := 1 + s.rng.IntN(10)
+	a, b := s.account(from), s.account(to)
+	c.ops = []simOp{
+		{kind: "get", key: a},
+		{kind: "get", key: b},
+		{kind: "add", key: a, amount: amount},
+		// Reading a key it wrote checks it reads its own writes.
+		{kind: "get", key: a},
+		{kind: "add", key: b, amount: -amount},
+		{kind: end},
+	}
+	s.trace = append(s.trace, fmt.Sprintf("%s begin transfer %d from %s to %s", c.tag, amount, a, b))
+	return nil
+}
+
+// start begins a transaction on c's connection.
+func (c *simClient) start() error {
+	if _, err := c.conn.execCommand("begin", nil); err != nil {
+		return err
+	}
+	c.n++
+	c.tag = c.name + "t" + strconv.Itoa(c.n)
+	c.reads, c.writes = map[string]string{}, map[string]string{}
+	return nil
+}
+
+func (s *simulation) get(c *simClient, key string) error {
+	value, err := c.conn.execCommand("get", []string{key})
+	if err != nil {
+		return err
+	}
+	s.trace = append(s.trace, fmt.Sprintf("%s get %s = %s", c.tag, key, value.Value))
+	_, tag, err := parseSimValue(value.Value)
+	if err != nil {
+		return err
+	}
+
+	if written, ok := c.writes[key]; ok && s.check >= ReadCommitedIsolation {
+		if value.Value != written {
+			return fmt.Errorf("%s wrote %s = %s, then read %s", c.tag, key, written, value.Value)
+		}
+		return nil
+	}
+	if s.check >= ReadCommitedIsolation && tag != c.tag && !s.committed[tag] {
+		return fmt.Errorf("%s read %s = %s, written by a transaction that hasn't committed", c.tag, key, value.Value)
+	}
+	if read, ok := c.reads[key]; ok && s.check >= RepeatableReadIsolation && read != value.Value {
+		return fmt.Errorf("%s read %s = %s, then %s", c.tag, key, read, value.Value)
+	}
+	c.reads[key] = value.Value
+	return nil
+}
+
+func (s *simulation) set(c *simClient, key string, balance int) error {
+	value := simValue(balance, c.tag)
+	if _, err := c.conn.execCommand("set", []string{key, value}); err != nil {
+		return err
+	}
+	s.trace = append(s.trace, fmt.Sprintf("%s set %s = %s", c.tag, key, value))
+	c.writes[key] = value
+	return nil
+}
+
+// audit checks the accounts c read add up.
+func (s *simulation) audit(c *simClient) error {
+	total := 0
+	for _, v := range c.reads {
+		balance, _, err := parseSimValue(v)
+		if err != nil {
+			return err
+		}
+		total += balance
+	}
+	s.trace = append(s.trace, fmt.Sprintf("%s audit %d", c.tag, total))
+	if want := simulationBalance * s.cfg.Accounts; s.check >= SnapshotIsolation && total != want {
+		return fmt.Errorf("%s saw the accounts add up to %d, not %d", c.tag, total, want)
+	}
+	return nil
+}
+
+func (s *simulation) complete(c *simClient, command string) error {
+	_, err := c.conn.execCommand(command, nil)
+	var conflict *ConflictError
+	switch {
+	case err == nil && command == "commit":
+		s.committed[c.tag] = true
+		s.trace = append(s.trace, c.tag+" commit")
+	case err == nil:
+		s.trace = append(s.trace, c.tag+" abort")
+	case errors.As(err, &conflict):
+		s.trace = append(s.trace, c.tag+" conflict")
+	default:
+		return err
+	}
+	c.ops = nil
+	return nil
+}
+
+// finish aborts whatever is still in progress, and checks the accounts
+// still add up.
+func (s *simulation) finish() error {
+	for _, c := range s.clients {
+		if c.ops != nil {
+			if err := s.complete(c, "abort"); err != nil {
+				return err
+			}
+		}
+	}
+	c := &simClient{name: "final", conn: s.db.newConnection()}
+	if err := c.start(); err != nil {
+		return err
+	}
+	for i := range s.cfg.Accounts {
+		if err := s.get(c, s.account(i)); err != nil {
+			return err
+		}
+	}
+	return s.audit(c)
+}
+
+// parseIsolation parses an isolation level as its String method formats
+// it.
+func parseIsolation(s string) (IsolationLevel, error) {
+	for l := ReadUncommitedIsolation; l <= SerializableIsolation; l++ {
+		if l.String() == s {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown isolation level %q", s)
+}
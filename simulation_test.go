@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var simulationSeed = flag.Uint64("simulation.seed", 0, "run the simulations from this seed alone")
+
+// simulationSeeds returns the seeds to run, or the one -simulation.seed
+// asks for.
+func simulationSeeds() []uint64 {
+	if *simulationSeed != 0 {
+		return []uint64{*simulationSeed}
+	}
+	var seeds []uint64
+	for seed := range uint64(30) {
+		seeds = append(seeds, seed+1)
+	}
+	return seeds
+}
+
+func TestSimulation(t *testing.T) {
+	for level := ReadCommitedIsolation; level <= SerializableIsolation; level++ {
+		for _, seed := range simulationSeeds() {
+			trace, err := Simulate(SimulationConfig{Seed: seed, Steps: 300, Isolation: level})
+			if err != nil {
+				t.Fatalf("%s: %v\n%s", level, err, strings.Join(trace, "\n"))
+			}
+		}
+	}
+}
+
+// simulateUntilFailure returns the first run from seed 1 on that breaks
+// cfg's promises.
+func simulateUntilFailure(cfg SimulationConfig) *SimulationFailure {
+	for seed := uint64(1); seed <= 200; seed++ {
+		cfg.Seed = seed
+		var failure *SimulationFailure
+		if _, err := Simulate(cfg); errors.As(err, &failure) {
+			return failure
+		}
+	}
+	return nil
+}
+
+func TestSimulation_findsAndReproduces(t *testing.T) {
+	// Read Committed doesn't promise repeatable reads, and a simulation
+	// checking that it does finds out.
+	check := RepeatableReadIsolation
+	cfg := SimulationConfig{Isolation: ReadCommitedIsolation, Check: &check}
+	failure := simulateUntilFailure(cfg)
+	assert(failure != nil, "non-repeatable read found")
+	assert(strings.Contains(failure.Err.Error(), ", then "), "read twice: "+failure.Err.Error())
+
+	// The seed reproduces it exactly.
+	cfg.Seed = failure.Seed
+	trace, err := Simulate(cfg)
+	var again *SimulationFailure
+	assert(errors.As(err, &again), "fails again")
+	assertEq(again.Step, failure.Step, "at the same step")
+	assert(slices.Equal(trace, failure.Trace), "with the same trace")
+
+	// Repeatable Read can lose updates, so the money doesn't add up.
+	check = SnapshotIsolation
+	failure = simulateUntilFailure(SimulationConfig{Isolation: RepeatableReadIsolation, Check: &check})
+	assert(failure != nil, "lost update found")
+	assert(strings.Contains(failure.Err.Error(), "add up"), "audit: "+failure.Err.Error())
+}
+
+func TestParseIsolation(t *testing.T) {
+	for level := ReadUncommitedIsolation; level <= SerializableIsolation; level++ {
+		parsed, err := parseIsolation(level.String())
+		assertEq(err, nil, "parse")
+		assertEq(parsed, level, "round trip")
+	}
+	_, err := parseIsolation("strict")
+	assert(err != nil, "unknown")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Version chains grow behind long-running transactions: vacuum can't reclaim
+anything a snapshot still in use might read. The slow log reports each
+transaction that ran longer than a threshold, or touched more keys than a
+limit, once it completes, along with the statements it ran, so whoever
+left it open can be found.
+
+Statements run through a Connection are recorded with how long each took,
+but only while a slow log is configured. Transactions used directly
+through the API report no statements. Reports are delivered like OnCommit
+and OnRollback hooks: after the lock is released, on whichever goroutine
+completed the transaction.
+*/
+
+// Statements kept per transaction for the slow log, and bytes kept per
+// argument.
+const (
+	maxSlowStatements = 64
+	maxSlowArg        = 64
+)
+
+// SlowLog says which transactions to report, and what to report them to.
+type SlowLog struct {
+	// Transactions that ran at least this long are reported, if positive.
+	Duration time.Duration
+	// Transactions that read and wrote more keys than this between them
+	// are reported, if positive.
+	Keys int
+	// Called with every report.
+	Report func(SlowTransaction)
+}
+
+// A SlowTransaction is what the slow log reports about a transaction.
+type SlowTransaction struct {
+	Id        uint64
+	Isolation IsolationLevel
+	State     TransactionState
+	Started   time.Time
+	Duration  time.Duration
+	Reads     int
+	Writes    int
+	// The first statements the transaction ran, and how many more it ran
+	// after those.
+	Statements []SlowStatement
+	Omitted    int
+}
+
+// A SlowStatement is a statement a slow transaction ran. Long arguments
+// are cut short.
+type SlowStatement struct {
+	Command  string
+	Args     []string
+	Duration time.Duration
+}
+
+// WithSlowLog makes the database report slow transactions as s says.
+func WithSlowLog(s SlowLog) Option {
+	return func(d *Database) {
+		d.slowLog = &s
+	}
+}
+
+func (s *SlowLog) slow(t *SlowTransaction) bool {
+	return (s.Duration > 0 && t.Duration >= s.Duration) ||
+		(s.Keys > 0 && t.Reads+t.Writes > s.Keys)
+}
+
+// recordStatement adds a statement the transaction ran to what the slow
+// log would report about it.
+func (t *Transaction) recordStatement(command string, args []string, took time.Duration) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if t.state != InProgressTransaction {
+		return
+	}
+	if len(t.statements) == maxSlowStatements {
+		t.omittedStatements++
+		return
+	}
+	s := SlowStatement{Command: command, Args: make([]string, len(args)), Duration: took}
+	for i, arg := range args {
+		if len(arg) > maxSlowArg {
+			arg = arg[:maxSlowArg] + "..."
+		}
+		s.Args[i] = arg
+	}
+	t.statements = append(t.statements, s)
+}
+
+// reportIfSlow arranges for the slow log to hear about t, which has just
+// completed, if it was slow.
+func (d *Database) reportIfSlow(t *Transaction) {
+	if d.slowLog == nil || t.historical {
+		return
+	}
+	report := SlowTransaction{
+		Id:         t.id,
+		Isolation:  t.isolation,
+		State:      t.state,
+		Started:    t.started,
+		Duration:   d.clock().Sub(t.started),
+		Reads:      t.readset.Len(),
+		Writes:     t.writeset.Len(),
+		Statements: t.statements,
+		Omitted:    t.omittedStatements,
+	}
+	if !d.slowLog.slow(&report) {
+		return
+	}
+	fn := func() { d.slowLog.Report(report) }
+	if t.state == CommittedTransaction {
+		t.onCommit = append(t.onCommit, fn)
+	} else {
+		t.onRollback = append(t.onRollback, fn)
+	}
+}
+
+func (s SlowTransaction) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s tx=%d isolation=%s state=%s duration=%s reads=%d writes=%d",
+		s.Started.UTC().Format(time.RFC3339), s.Id, s.Isolation, s.State, s.Duration, s.Reads, s.Writes)
+	for _, stmt := range s.Statements {
+		fmt.Fprintf(&b, "\n\t%s", stmt.Command)
+		for _, arg := range stmt.Args {
+			fmt.Fprintf(&b, " %q", arg)
+		}
+		fmt.Fprintf(&b, " (%s)", stmt.Duration)
+	}
+	if s.Omitted > 0 {
+		fmt.Fprintf(&b, "\n\t... %d more", s.Omitted)
+	}
+	return b.String()
+}
+
+// WriteSlowLog returns a SlowLog.Report that writes reports to w, one
+// after another.
+func WriteSlowLog(w io.Writer) func(SlowTransaction) {
+	var mu sync.Mutex
+	return func(s SlowTransaction) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintln(w, s)
+	}
+}
+
+// runStatement runs a statement in the connection's transaction,
+// recording it for the slow log.
+func (c *Connection) runStatement(command string, args []string) (Result, error) {
+	if c.db.slowLog == nil {
+		return c.dispatch(command, args)
+	}
+	tx, start := c.tx, c.db.clock()
+	res, err := c.dispatch(command, args)
+	tx.recordStatement(command, args, c.db.clock().Sub(start))
+	return res, err
+}
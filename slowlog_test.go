@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowLog(t *testing.T) {
+	database := newDatabase()
+	now := time.Now()
+	database.now = func() time.Time { return now }
+	var reports []SlowTransaction
+	database.apply(WithSlowLog(SlowLog{
+		Duration: time.Minute,
+		Keys:     2,
+		Report:   func(s SlowTransaction) { reports = append(reports, s) },
+	}))
+
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"x", "hey"})
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("get", []string{"x"})
+	c.mustExecCommand("set", []string{"y", strings.Repeat("a", 100)})
+	c.mustExecCommand("commit", nil)
+	assertEq(len(reports), 0, "quick and small")
+
+	c.mustExecCommand("begin", nil)
+	id := c.tx.id
+	c.mustExecCommand("get", []string{"x"})
+	now = now.Add(time.Hour)
+	c.mustExecCommand("set", []string{"y", strings.Repeat("a", 100)})
+	c.mustExecCommand("abort", nil)
+	assertEq(len(reports), 1, "slow")
+	s := reports[0]
+	assertEq(s.Id, id, "id")
+	assertEq(s.State, AbortedTransaction, "state")
+	assertEq(s.Duration, time.Hour, "duration")
+	assertEq(len(s.Statements), 2, "statements")
+	assertEq(s.Statements[0].Command, "get", "first statement")
+	assertEq(s.Statements[1].Duration, time.Duration(0), "statements were quick")
+	assertEq(s.Statements[1].Args[1], strings.Repeat("a", maxSlowArg)+"...", "long argument cut short")
+
+	// Autocommitted statements are transactions too.
+	c.mustExecCommand("mset", []string{"a", "1", "b", "2", "c", "3"})
+	assertEq(len(reports), 2, "big")
+	assertEq(reports[1].Writes, 3, "writes")
+	assertEq(reports[1].Statements[0].Command, "mset", "statement")
+
+	lines := strings.Split(reports[1].String(), "\n")
+	assertEq(len(lines), 2, "one line per statement")
+	assertEq(lines[1], "\tmset \"a\" \"1\" \"b\" \"2\" \"c\" \"3\" (0s)", "statement line")
+}
+
+func TestSlowLog_batched(t *testing.T) {
+	database := newDatabase()
+	now := time.Now()
+	database.now = func() time.Time {
+		now = now.Add(time.Hour)
+		return now
+	}
+	var reports []SlowTransaction
+	database.apply(WithSlowLog(SlowLog{
+		Duration: time.Minute,
+		Report:   func(s SlowTransaction) { reports = append(reports, s) },
+	}))
+
+	// Batched writes are recycled, which mustn't drop their reports.
+	assertEq(database.Set("x", "hey"), nil, "set")
+	assertEq(len(reports), 1, "reported")
+	assertEq(reports[0].Writes, 1, "writes")
+}
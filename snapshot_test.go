@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExportSnapshot(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("set", []string{"b", "two\nlines"})
+	c1.mustExecCommand("set", []string{"a", "1"})
+	c1.mustExecCommand("set", []string{"gone", "soon"})
+
+	reader := database.newConnection()
+	reader.mustExecCommand("begin", nil)
+
+	// None of this is in the reader's snapshot.
+	c1.mustExecCommand("set", []string{"a", "changed"})
+	c1.mustExecCommand("delete", []string{"gone"})
+	c1.mustExecCommand("set", []string{"c", "new"})
+
+	var out strings.Builder
+	err := database.ExportSnapshot(reader.tx.id, &out)
+	assertEq(err, nil, "export")
+	assertEq(out.String(), `snapshot 4
+"a" "1"
+"b" "two\nlines"
+"gone" "soon"
+`, "export")
+
+	err = database.ExportSnapshot(100, &out)
+	assertEq(err.Error(), "no transaction 100 to read as of", "export of a missing snapshot")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+Isolation behaviors are specified in testdata/isolation, in the manner of
+PostgreSQL's isolation tester: a spec interleaves the steps of named
+sessions, each a connection of its own, and says what each step should
+respond. A line holds one or more steps separated by semicolons:
+
+	s1: begin; s2: begin
+	s1: get x => OK "1"
+	s2: commit => ERR write-write conflict...
+
+A step's expected response is as the line protocol sends it (see
+server.go), and ending it with "..." expects only that it starts so.
+Steps without one aren't checked. An isolation line starts a scenario,
+run on a fresh database at that level; the steps before the first are
+setup, run at the start of every scenario. Lines starting with # are
+comments.
+*/
+
+type specStep struct {
+	line    int
+	session string
+	command string
+	args    []string
+	want    string
+}
+
+func (s specStep) String() string {
+	return s.session + ": " + formatCommandLine(s.command, s.args)
+}
+
+type specScenario struct {
+	line  int
+	level IsolationLevel
+	steps []specStep
+}
+
+type spec struct {
+	setup     []specStep
+	scenarios []specScenario
+}
+
+func parseSpec(r io.Reader) (*spec, error) {
+	s := &spec{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, ok := strings.CutPrefix(line, "isolation "); ok {
+			level, err := parseIsolation(strings.TrimSpace(name))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			s.scenarios = append(s.scenarios, specScenario{line: n, level: level})
+			continue
+		}
+
+		for _, text := range splitSteps(line) {
+			session, rest, ok := strings.Cut(text, ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected session: command in %q", n, text)
+			}
+			commandLine, want, _ := strings.Cut(rest, "=>")
+			words, err := parseCommandLine(strings.TrimSpace(commandLine))
+			if err != nil || len(words) == 0 {
+				return nil, fmt.Errorf("line %d: bad command in %q", n, text)
+			}
+			step := specStep{line: n, session: strings.TrimSpace(session), command: words[0], args: words[1:], want: strings.TrimSpace(want)}
+			if len(s.scenarios) == 0 {
+				s.setup = append(s.setup, step)
+			} else {
+				sc := &s.scenarios[len(s.scenarios)-1]
+				sc.steps = append(sc.steps, step)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.scenarios) == 0 {
+		s.scenarios = []specScenario{{level: ReadCommitedIsolation}}
+	}
+	return s, nil
+}
+
+// splitSteps splits a line at the semicolons outside quotes.
+func splitSteps(line string) []string {
+	var steps []string
+	quoted, escaped, start := false, false, 0
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ';' && !quoted:
+			steps = append(steps, line[start:i])
+			start = i + 1
+		}
+	}
+	steps = append(steps, line[start:])
+	for i := range steps {
+		steps[i] = strings.TrimSpace(steps[i])
+	}
+	return steps
+}
+
+// run runs the setup and then sc, returning the first step that responded
+// other than expected, with what the steps before it responded.
+func (s *spec) run(sc specScenario) error {
+	database := newDatabase()
+	database.defaultIsolation = sc.level
+	sessions := map[string]*Connection{}
+	var trace []string
+	for _, step := range append(s.setup[:len(s.setup):len(s.setup)], sc.steps...) {
+		c := sessions[step.session]
+		if c == nil {
+			c = database.newConnection()
+			sessions[step.session] = c
+		}
+		got := responseLine(c.execCommand(step.command, step.args))
+		trace = append(trace, fmt.Sprintf("%s => %s", step, got))
+
+		want, prefix := strings.CutSuffix(step.want, "...")
+		if step.want != "" && (got != want && !prefix || prefix && !strings.HasPrefix(got, want)) {
+			return fmt.Errorf("line %d: %s: expected %s, got %s\n%s", step.line, step, step.want, got, strings.Join(trace, "\n"))
+		}
+	}
+	return nil
+}
+
+func TestIsolationSpecs(t *testing.T) {
+	paths, _ := filepath.Glob(filepath.Join("testdata", "isolation", "*.spec"))
+	assert(len(paths) > 0, "specs")
+	for _, path := range paths {
+		f, err := os.Open(path)
+		assertEq(err, nil, "open")
+		s, err := parseSpec(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		for _, sc := range s.scenarios {
+			t.Run(fmt.Sprintf("%s/%s", strings.TrimSuffix(filepath.Base(path), ".spec"), sc.level), func(t *testing.T) {
+				if err := s.run(sc); err != nil {
+					t.Fatalf("%s: %v", path, err)
+				}
+			})
+		}
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	s, err := parseSpec(strings.NewReader(`# A comment.
+setup: set x 1
+
+isolation snapshot
+s1: begin; s2: set x "a;b" => OK "a;b"
+s1: get x => OK "1"
+`))
+	assertEq(err, nil, "parse")
+	assertEq(len(s.setup), 1, "setup")
+	assertEq(len(s.scenarios), 1, "scenarios")
+	sc := s.scenarios[0]
+	assertEq(sc.level, SnapshotIsolation, "level")
+	assertEq(len(sc.steps), 3, "steps")
+	assertEq(sc.steps[1].String(), "s2: set x a;b", "quoted semicolon")
+	assertEq(sc.steps[1].want, `OK "a;b"`, "expected")
+	assertEq(sc.steps[2].line, 6, "line")
+	assertEq(s.run(sc), nil, "run")
+
+	sc.steps[2].want = `OK "2"`
+	err = s.run(sc)
+	assert(err != nil && strings.HasPrefix(err.Error(), `line 6: s1: get x: expected OK "2", got OK "1"`), fmt.Sprint("mismatch: ", err))
+
+	_, err = parseSpec(strings.NewReader("isolation sometimes\n"))
+	assert(err != nil, "unknown level")
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+For those who would rather write SQL, the REPL and the line protocol also
+take a small subset of it, over a single table, kv, of two text columns,
+key and value, whose rows are the keys visible to the transaction:
+
+	SELECT value FROM kv WHERE key = 'a'
+	SELECT key, value FROM kv WHERE key BETWEEN 'a' AND 'm' ORDER BY key DESC LIMIT 10
+	INSERT INTO kv (key, value) VALUES ('a', '1'), ('b', '2')
+	UPDATE kv SET value = 'x' WHERE key = 'a'
+	DELETE FROM kv WHERE key BETWEEN 'a' AND 'b'
+	BEGIN ISOLATION LEVEL SERIALIZABLE
+	COMMIT
+	ROLLBACK
+
+A SELECT may ask for *, key, value, or key, value; a WHERE clause, if
+any, picks one key or an inclusive range of them; ORDER BY orders by key,
+the only order there is. An INSERT of a key that exists fails, leaving
+the other rows unwritten too, and an UPDATE only changes rows that exist.
+BEGIN (or START TRANSACTION) takes the isolation levels by their SQL
+names, and SNAPSHOT.
+
+A line is taken for SQL if its first word is one of those keywords in
+capitals, so that "delete x" is still the command; after that, keywords
+may be in either case. Strings are in single quotes, with a quote in one
+doubled, and a number stands for its digits.
+
+Each statement is run as the commands it amounts to, as if the client had
+sent them: authorization, buckets and the recording all see those. A
+statement of several commands runs in the connection's transaction, or
+one of its own. A SELECT that finds nothing is NIL; the others answer
+with how many rows they affected.
+*/
+
+// The keywords a line of SQL starts with.
+var sqlStatements = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true,
+	"BEGIN": true, "START": true, "COMMIT": true, "ROLLBACK": true,
+}
+
+const sqlTable = "kv"
+
+// isSQL reports whether line is a statement of SQL rather than a
+// command.
+func isSQL(line string) bool {
+	first, _, _ := strings.Cut(strings.TrimLeft(line, " \t"), " ")
+	return sqlStatements[strings.TrimRight(first, ";")]
+}
+
+// sqlKeys is the keys a statement applies to: one, if exact, or those in
+// [start, end), where an empty end means no upper bound.
+type sqlKeys struct {
+	exact      bool
+	key        string
+	start, end string
+}
+
+type sqlStatement struct {
+	verb string
+	// For SELECT, which columns, and how many rows in which order.
+	columns []string
+	desc    bool
+	// Negative for no limit.
+	limit int
+	// For SELECT, UPDATE and DELETE.
+	where sqlKeys
+	// For INSERT, and the value for UPDATE.
+	rows  []KeyValue
+	value string
+	// For BEGIN, the level, in our own words, if any.
+	isolation string
+}
+
+// execSQL runs a statement of SQL on c.
+func (c *Connection) execSQL(line string) (Result, error) {
+	stmt, err := parseSQL(line)
+	if err != nil {
+		return Result{}, fmt.Errorf("sql: %w", err)
+	}
+
+	switch stmt.verb {
+	case "BEGIN":
+		var args []string
+		if stmt.isolation != "" {
+			args = []string{"isolation", stmt.isolation}
+		}
+		return c.execCommand("begin", args)
+	case "COMMIT":
+		return c.execCommand("commit", nil)
+	case "ROLLBACK":
+		return c.execCommand("abort", nil)
+	case "SELECT":
+		return c.sqlSelect(stmt)
+	}
+
+	var n int
+	err = c.atomically(func() (err error) {
+		switch stmt.verb {
+		case "INSERT":
+			n, err = c.sqlInsert(stmt)
+		case "UPDATE":
+			n, err = c.sqlUpdate(stmt)
+		case "DELETE":
+			n, err = c.sqlDelete(stmt)
+		}
+		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Value: strconv.Itoa(n)}, nil
+}
+
+func (c *Connection) sqlSelect(stmt *sqlStatement) (Result, error) {
+	var pairs []KeyValue
+	if stmt.limit == 0 {
+		return Result{NotFound: true}, nil
+	}
+	if stmt.where.exact {
+		res, err := c.execCommand("get", []string{stmt.where.key})
+		if res.NotFound {
+			return Result{NotFound: true, TxId: res.TxId}, nil
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		pairs = []KeyValue{{stmt.where.key, res.Value}}
+	} else {
+		var err error
+		if pairs, err = c.sqlScan(stmt.where, stmt.desc, stmt.limit); err != nil {
+			return Result{}, err
+		}
+	}
+	if len(pairs) == 0 {
+		return Result{NotFound: true}, nil
+	}
+
+	if len(stmt.columns) == 2 {
+		return pairsResult(0, pairs), nil
+	}
+	res := Result{}
+	for _, kv := range pairs {
+		if stmt.columns[0] == "key" {
+			res.Values = append(res.Values, kv.Key)
+		} else {
+			res.Values = append(res.Values, kv.Value)
+		}
+	}
+	res.Value = strings.Join(res.Values, "\n")
+	return res, nil
+}
+
+// sqlScan returns the pairs in keys, as the scan command does.
+func (c *Connection) sqlScan(keys sqlKeys, desc bool, limit int) ([]KeyValue, error) {
+	var args []string
+	if desc {
+		args = append(args, "--desc")
+	}
+	if limit > 0 {
+		args = append(args, "--limit", strconv.Itoa(limit))
+	}
+	res, err := c.execCommand("scan", append(args, keys.start, keys.end))
+	return res.Pairs, err
+}
+
+// sqlMatching returns the keys stmt's WHERE clause picks that exist.
+func (c *Connection) sqlMatching(stmt *sqlStatement) ([]string, error) {
+	if !stmt.where.exact {
+		pairs, err := c.sqlScan(stmt.where, false, -1)
+		keys := make([]string, len(pairs))
+		for i, kv := range pairs {
+			keys[i] = kv.Key
+		}
+		return keys, err
+	}
+	res, err := c.execCommand("exists", []string{stmt.where.key})
+	if err != nil || res.Value == "0" {
+		return nil, err
+	}
+	return []string{stmt.where.key}, nil
+}
+
+func (c *Connection) sqlInsert(stmt *sqlStatement) (int, error) {
+	// Checking every row first means a duplicate leaves nothing written,
+	// even in a transaction that carries on.
+	for _, kv := range stmt.rows {
+		res, err := c.execCommand("exists", []string{kv.Key})
+		if err != nil {
+			return 0, err
+		}
+		if res.Value != "0" {
+			return 0, fmt.Errorf("sql: duplicate key %q", kv.Key)
+		}
+	}
+	for _, kv := range stmt.rows {
+		res, err := c.execCommand("setnx", []string{kv.Key, kv.Value})
+		if err != nil {
+			return 0, err
+		}
+		if res.Value == "0" {
+			return 0, fmt.Errorf("sql: duplicate key %q", kv.Key)
+		}
+	}
+	return len(stmt.rows), nil
+}
+
+func (c *Connection) sqlUpdate(stmt *sqlStatement) (int, error) {
+	keys, err := c.sqlMatching(stmt)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if _, err := c.execCommand("set", []string{key, stmt.value}); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+func (c *Connection) sqlDelete(stmt *sqlStatement) (int, error) {
+	keys, err := c.sqlMatching(stmt)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if _, err := c.execCommand("delete", []string{key}); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+/*
+The parser works on tokens: words, which are keywords and names; strings;
+numbers; and punctuation, each character its own token.
+*/
+
+type sqlToken struct {
+	// 'w' for a word, 's' for a string, 'n' for a number, or the
+	// punctuation character; 0 at the end.
+	kind byte
+	text string
+}
+
+func lexSQL(line string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	for i := 0; i < len(line); {
+		ch := line[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+		case ch == '\'':
+			var b strings.Builder
+			for i++; ; i++ {
+				if i == len(line) {
+					return nil, errors.New("unterminated string")
+				}
+				if line[i] == '\'' {
+					if i+1 < len(line) && line[i+1] == '\'' {
+						i++
+					} else {
+						break
+					}
+				}
+				b.WriteByte(line[i])
+			}
+			i++
+			tokens = append(tokens, sqlToken{'s', b.String()})
+		case isSQLWordChar(ch):
+			start := i
+			for i < len(line) && isSQLWordChar(line[i]) {
+				i++
+			}
+			kind := byte('w')
+			if _, err := strconv.ParseInt(line[start:i], 10, 64); err == nil {
+				kind = 'n'
+			}
+			tokens = append(tokens, sqlToken{kind, line[start:i]})
+		case strings.IndexByte("(),=*;", ch) >= 0:
+			tokens = append(tokens, sqlToken{ch, string(ch)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q", ch)
+		}
+	}
+	return tokens, nil
+}
+
+func isSQLWordChar(ch byte) bool {
+	return ch == '_' || ch == '-' || '0' <= ch && ch <= '9' || 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z'
+}
+
+type sqlParser struct {
+	tokens []sqlToken
+}
+
+func (p *sqlParser) peek() sqlToken {
+	if len(p.tokens) == 0 {
+		return sqlToken{}
+	}
+	return p.tokens[0]
+}
+
+func (p *sqlParser) next() sqlToken {
+	tok := p.peek()
+	if len(p.tokens) > 0 {
+		p.tokens = p.tokens[1:]
+	}
+	return tok
+}
+
+// found describes the next token, for errors.
+func (p *sqlParser) found() string {
+	switch tok := p.peek(); tok.kind {
+	case 0:
+		return "end of statement"
+	case 's':
+		return strconv.Quote(tok.text)
+	default:
+		return tok.text
+	}
+}
+
+// keyword consumes the next token if it is word, in any case.
+func (p *sqlParser) keyword(word string) bool {
+	if tok := p.peek(); tok.kind == 'w' && strings.EqualFold(tok.text, word) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expect consumes words, in order, or fails.
+func (p *sqlParser) expect(words ...string) error {
+	for _, word := range words {
+		if !p.keyword(word) {
+			return fmt.Errorf("expected %s, found %s", word, p.found())
+		}
+	}
+	return nil
+}
+
+// punct consumes the next token if it is ch.
+func (p *sqlParser) punct(ch byte) bool {
+	if p.peek().kind == ch {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectPunct(ch byte) error {
+	if !p.punct(ch) {
+		return fmt.Errorf("expected %c, found %s", ch, p.found())
+	}
+	return nil
+}
+
+// literal consumes a string or a number.
+func (p *sqlParser) literal() (string, error) {
+	if tok := p.peek(); tok.kind == 's' || tok.kind == 'n' {
+		p.next()
+		return tok.text, nil
+	}
+	return "", fmt.Errorf("expected a string, found %s", p.found())
+}
+
+// column consumes key or value.
+func (p *sqlParser) column() (string, error) {
+	for _, name := range []string{"key", "value"} {
+		if p.keyword(name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("expected key or value, found %s", p.found())
+}
+
+func (p *sqlParser) table() error {
+	tok := p.next()
+	if tok.kind != 'w' {
+		return fmt.Errorf("expected a table, found %s", tok.text)
+	}
+	if !strings.EqualFold(tok.text, sqlTable) {
+		return fmt.Errorf("no table %q; the only one is %s", tok.text, sqlTable)
+	}
+	return nil
+}
+
+func parseSQL(line string) (*sqlStatement, error) {
+	tokens, err := lexSQL(line)
+	if err != nil {
+		return nil, err
+	}
+	p := &sqlParser{tokens: tokens}
+	stmt := &sqlStatement{verb: strings.ToUpper(p.next().text)}
+
+	switch stmt.verb {
+	case "SELECT":
+		err = p.selectStatement(stmt)
+	case "INSERT":
+		err = p.insertStatement(stmt)
+	case "UPDATE":
+		err = p.updateStatement(stmt)
+	case "DELETE":
+		err = p.deleteStatement(stmt)
+	case "BEGIN", "START":
+		err = p.beginStatement(stmt)
+	case "COMMIT", "ROLLBACK":
+		_ = p.keyword("transaction") || p.keyword("work")
+	default:
+		err = fmt.Errorf("unknown statement %s", stmt.verb)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.punct(';')
+	if p.peek().kind != 0 {
+		return nil, fmt.Errorf("unexpected %s", p.found())
+	}
+	return stmt, nil
+}
+
+// SELECT columns FROM kv [WHERE ...] [ORDER BY key [ASC|DESC]] [LIMIT n]
+func (p *sqlParser) selectStatement(stmt *sqlStatement) error {
+	stmt.limit = -1
+	if p.punct('*') {
+		stmt.columns = []string{"key", "value"}
+	} else {
+		column, err := p.column()
+		if err != nil {
+			return err
+		}
+		stmt.columns = []string{column}
+		if p.punct(',') {
+			if column, err = p.column(); err != nil {
+				return err
+			}
+			if column == stmt.columns[0] || column != "value" {
+				return errors.New("expected *, key, value, or key, value")
+			}
+			stmt.columns = append(stmt.columns, column)
+		}
+	}
+
+	if err := p.expect("from"); err != nil {
+		return err
+	}
+	if err := p.table(); err != nil {
+		return err
+	}
+	if err := p.where(stmt); err != nil {
+		return err
+	}
+
+	if p.keyword("order") {
+		if err := p.expect("by", "key"); err != nil {
+			return err
+		}
+		stmt.desc = p.keyword("desc")
+		if !stmt.desc {
+			p.keyword("asc")
+		}
+	}
+	if p.keyword("limit") {
+		tok := p.next()
+		n, err := strconv.Atoi(tok.text)
+		if tok.kind != 'n' || err != nil || n < 0 {
+			return fmt.Errorf("expected a number of rows, found %s", tok.text)
+		}
+		stmt.limit = n
+	}
+	return nil
+}
+
+// [WHERE key = 'k' | WHERE key BETWEEN 'a' AND 'b']
+func (p *sqlParser) where(stmt *sqlStatement) error {
+	if !p.keyword("where") {
+		return nil
+	}
+	if err := p.expect("key"); err != nil {
+		return err
+	}
+
+	if p.punct('=') {
+		key, err := p.literal()
+		stmt.where = sqlKeys{exact: true, key: key}
+		return err
+	}
+	if err := p.expect("between"); err != nil {
+		return errors.New("expected = or BETWEEN after WHERE key")
+	}
+	start, err := p.literal()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("and"); err != nil {
+		return err
+	}
+	last, err := p.literal()
+	if err != nil {
+		return err
+	}
+	// The range is inclusive, and the smallest key after last is last
+	// and a zero byte.
+	stmt.where = sqlKeys{start: start, end: last + "\x00"}
+	if last < start {
+		stmt.where.end = start
+	}
+	return nil
+}
+
+// INSERT INTO kv [(key, value)] VALUES ('k', 'v'), ...
+func (p *sqlParser) insertStatement(stmt *sqlStatement) error {
+	if err := p.expect("into"); err != nil {
+		return err
+	}
+	if err := p.table(); err != nil {
+		return err
+	}
+
+	valueFirst := false
+	if p.punct('(') {
+		first, err := p.column()
+		if err != nil {
+			return err
+		}
+		if err := p.expectPunct(','); err != nil {
+			return err
+		}
+		second, err := p.column()
+		if err != nil {
+			return err
+		}
+		if first == second {
+			return fmt.Errorf("column %s given twice", first)
+		}
+		if err := p.expectPunct(')'); err != nil {
+			return err
+		}
+		valueFirst = first == "value"
+	}
+
+	if err := p.expect("values"); err != nil {
+		return err
+	}
+	for {
+		var row [2]string
+		if err := p.expectPunct('('); err != nil {
+			return err
+		}
+		for i := range row {
+			if i > 0 {
+				if err := p.expectPunct(','); err != nil {
+					return err
+				}
+			}
+			var err error
+			if row[i], err = p.literal(); err != nil {
+				return err
+			}
+		}
+		if err := p.expectPunct(')'); err != nil {
+			return err
+		}
+		if valueFirst {
+			row[0], row[1] = row[1], row[0]
+		}
+		stmt.rows = append(stmt.rows, KeyValue{row[0], row[1]})
+		if !p.punct(',') {
+			return nil
+		}
+	}
+}
+
+// UPDATE kv SET value = 'v' [WHERE ...]
+func (p *sqlParser) updateStatement(stmt *sqlStatement) error {
+	if err := p.table(); err != nil {
+		return err
+	}
+	if err := p.expect("set", "value"); err != nil {
+		return err
+	}
+	if err := p.expectPunct('='); err != nil {
+		return err
+	}
+	var err error
+	if stmt.value, err = p.literal(); err != nil {
+		return err
+	}
+	return p.where(stmt)
+}
+
+// DELETE FROM kv [WHERE ...]
+func (p *sqlParser) deleteStatement(stmt *sqlStatement) error {
+	if err := p.expect("from"); err != nil {
+		return err
+	}
+	if err := p.table(); err != nil {
+		return err
+	}
+	return p.where(stmt)
+}
+
+// The isolation levels, by their names in SQL.
+var sqlIsolationLevels = map[string]IsolationLevel{
+	"read uncommitted": ReadUncommitedIsolation,
+	"read committed":   ReadCommitedIsolation,
+	"repeatable read":  RepeatableReadIsolation,
+	"snapshot":         SnapshotIsolation,
+	"serializable":     SerializableIsolation,
+}
+
+// BEGIN [TRANSACTION | WORK] [ISOLATION LEVEL level]
+// START TRANSACTION [ISOLATION LEVEL level]
+func (p *sqlParser) beginStatement(stmt *sqlStatement) error {
+	if stmt.verb == "START" {
+		if err := p.expect("transaction"); err != nil {
+			return err
+		}
+		stmt.verb = "BEGIN"
+	} else {
+		_ = p.keyword("transaction") || p.keyword("work")
+	}
+
+	if !p.keyword("isolation") {
+		return nil
+	}
+	if err := p.expect("level"); err != nil {
+		return err
+	}
+	var words []string
+	for p.peek().kind == 'w' && len(words) < 2 {
+		words = append(words, strings.ToLower(p.next().text))
+	}
+	level, ok := sqlIsolationLevels[strings.Join(words, " ")]
+	if !ok {
+		return fmt.Errorf("unknown isolation level %q", strings.Join(words, " "))
+	}
+	stmt.isolation = level.String()
+	return nil
+}
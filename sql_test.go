@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func (c *Connection) mustExecSQL(line string) string {
+	res, err := c.execSQL(line)
+	assertEq(err, nil, line)
+	return res.Value
+}
+
+func TestSQL(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+
+	assertEq(c.mustExecSQL("INSERT INTO kv (key, value) VALUES ('a', '1'), ('b', 'it''s'), ('c', 3)"), "3", "insert")
+	assertEq(c.mustExecSQL("SELECT value FROM kv WHERE key = 'b'"), "it's", "select one")
+	assertEq(c.mustExecSQL("select * from KV where KEY between 'a' and 'b';"), "a=1\nb=it's", "between is inclusive")
+	assertEq(c.mustExecSQL("SELECT key FROM kv ORDER BY key DESC LIMIT 2"), "c\nb", "order and limit")
+	assertEq(c.mustExecSQL("INSERT INTO kv (value, key) VALUES ('4', 'd')"), "1", "columns in either order")
+	assertEq(c.mustExecCommand("get", []string{"d"}), "4", "inserted")
+
+	res, err := c.execSQL("SELECT value FROM kv WHERE key = 'missing'")
+	assertEq(err, nil, "no rows")
+	assert(res.NotFound, "no rows is NIL")
+
+	// A duplicate writes none of the rows.
+	_, err = c.execSQL("INSERT INTO kv VALUES ('e', '5'), ('a', 'again')")
+	assertEq(err.Error(), `sql: duplicate key "a"`, "duplicate")
+	_, err = c.execCommand("get", []string{"e"})
+	assertEq(err, ErrKeyNotFound, "no row inserted")
+
+	assertEq(c.mustExecSQL("UPDATE kv SET value = 'x' WHERE key BETWEEN 'b' AND 'c'"), "2", "update range")
+	assertEq(c.mustExecSQL("UPDATE kv SET value = 'x' WHERE key = 'missing'"), "0", "update only what exists")
+	assertEq(c.mustExecSQL("DELETE FROM kv WHERE key = 'a'"), "1", "delete")
+	assertEq(c.mustExecSQL("DELETE FROM kv WHERE key = 'a'"), "0", "delete again")
+	assertEq(c.mustExecSQL("SELECT key, value FROM kv"), "b=x\nc=x\nd=4", "whole table")
+
+	for line, want := range map[string]string{
+		"SELECT value FROM users":                      `sql: no table "users"; the only one is kv`,
+		"SELECT value FROM kv WHERE value = 'x'":       "sql: expected key, found value",
+		"SELECT value FROM kv WHERE key = 'x":          "sql: unterminated string",
+		"SELECT value, key FROM kv":                    "sql: expected *, key, value, or key, value",
+		"UPDATE kv SET value = 'x' WHERE key LIKE 'a'": "sql: expected = or BETWEEN after WHERE key",
+		"COMMIT NOW": "sql: unexpected NOW",
+	} {
+		_, err := c.execSQL(line)
+		assertEq(err.Error(), want, line)
+	}
+}
+
+func TestSQL_transactions(t *testing.T) {
+	database := newDatabase()
+	c1 := database.newConnection()
+	c2 := database.newConnection()
+
+	c1.mustExecSQL("BEGIN ISOLATION LEVEL SERIALIZABLE")
+	assertEq(c1.tx.isolation, SerializableIsolation, "isolation")
+	c2.mustExecSQL("START TRANSACTION ISOLATION LEVEL REPEATABLE READ")
+	assertEq(c2.tx.isolation, RepeatableReadIsolation, "isolation")
+	c1.mustExecSQL("INSERT INTO kv VALUES ('a', '1')")
+	c1.mustExecSQL("COMMIT")
+
+	res, _ := c2.execSQL("SELECT value FROM kv WHERE key = 'a'")
+	assert(res.NotFound, "snapshot")
+	c2.mustExecSQL("ROLLBACK WORK")
+	assertEq(c2.tx, nil, "rolled back")
+	assertEq(c2.mustExecSQL("SELECT value FROM kv WHERE key = 'a'"), "1", "committed")
+
+	_, err := c1.execSQL("BEGIN ISOLATION LEVEL CHAOTIC")
+	assertEq(err.Error(), `sql: unknown isolation level "chaotic"`, "unknown level")
+	c1.mustExecCommand("begin", []string{"isolation", "read-committed", "priority", "high"})
+	assertEq(c1.tx.isolation, ReadCommitedIsolation, "begin isolation")
+	assertEq(c1.tx.priority, HighPriority, "and priority")
+}
+
+func TestSQL_lines(t *testing.T) {
+	assert(isSQL("SELECT * FROM kv"), "select")
+	assert(isSQL("COMMIT;"), "commit")
+	assert(!isSQL("delete x"), "the delete command")
+	assert(!isSQL("commit"), "the commit command")
+
+	database := newDatabase()
+	var out strings.Builder
+	runREPL(&database, strings.NewReader(`INSERT INTO kv VALUES ('greeting', 'hello world')
+SELECT value FROM kv WHERE key = 'greeting'
+get greeting
+DELETE FROM kv
+SELECT * FROM kv
+`), &out)
+	assertEq(out.String(), "1> 1\n1> hello world\n1> hello world\n1> 1\n1> (nil)\n1> \n", "transcript")
+
+	srv, addr := startServer(&database)
+	defer srv.Close()
+	c := dial(addr)
+	assertEq(c.do("INSERT INTO kv VALUES ('a', '1')"), `OK "1"`, "insert")
+	assertEq(c.do("SELECT value FROM kv WHERE key = 'a'"), `OK "1"`, "select")
+	assertEq(c.do("SELECT value FROM kv WHERE key = 'b'"), "NIL", "no rows")
+	assertEq(c.do("SELECT value FROM nowhere"), `ERR sql: no table "nowhere"; the only one is kv`, "error")
+}
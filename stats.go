@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+/*
+Stats gives a one-shot picture of the engine's internals, for a quick
+look without a metrics pipeline: how big the store is, how much of it
+vacuum could reclaim, what is holding the horizon back, and how often
+transactions have failed to commit. The "stats" command shows the same,
+one "name value" line each.
+
+Working out the version counts walks the whole store under the lock, so
+it is not something to poll in a tight loop.
+*/
+
+type Stats struct {
+	Keys     int
+	Versions int
+	// Versions no transaction can see any more. Vacuum removes these,
+	// less whatever the retention policy keeps.
+	DeadVersions int
+
+	ActiveTransactions int
+	// The id of the oldest in-progress transaction, whose snapshot
+	// holds vacuum back; zero if none.
+	OldestSnapshot uint64
+
+	// Bytes in the write-ahead log file, zero without one.
+	WALSize int64
+	// Estimated bytes held by versions and by transactions (see
+	// memory.go), and the budget, zero if none.
+	VersionBytes     int64
+	TransactionBytes int64
+	MaxMemory        int64
+
+	// Since the database was opened.
+	Commits   uint64
+	Aborts    uint64
+	Conflicts uint64
+}
+
+// Stats reports on the database's internals.
+func (d *Database) Stats() (Stats, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var s Stats
+	d.countVersions(&s, "", "")
+
+	s.ActiveTransactions = d.running.Len()
+	s.OldestSnapshot, _ = d.running.Min()
+	s.VersionBytes = d.versionBytes
+	s.TransactionBytes = d.transactionBytes()
+	s.MaxMemory = d.maxMemory
+
+	if wal, ok := d.wal.(*FileWAL); ok {
+		fi, err := os.Stat(wal.path)
+		if err != nil {
+			return Stats{}, err
+		}
+		s.WALSize = fi.Size()
+	}
+
+	for level := ReadUncommitedIsolation; level <= SerializableIsolation; level++ {
+		s.Commits += counterValue(d.metrics.committed.WithLabelValues(level.String()))
+		s.Aborts += counterValue(d.metrics.aborted.WithLabelValues(level.String()))
+	}
+	for _, kind := range []string{"write-write", "read-write"} {
+		s.Conflicts += counterValue(d.metrics.conflicts.WithLabelValues(kind))
+	}
+	return s, nil
+}
+
+// countVersions adds the keys in [start, end), and their versions, to s.
+// An empty end means no upper bound.
+func (d *Database) countVersions(s *Stats, start, end string) {
+	horizon := d.horizon()
+	iter := d.store.Iter()
+	for ok := iter.Seek(start); ok && (end == "" || iter.Key() < end); ok = iter.Next() {
+		s.Keys++
+		for i := range iter.Value() {
+			s.Versions++
+			if d.dead(&iter.Value()[i], horizon) {
+				s.DeadVersions++
+			}
+		}
+	}
+}
+
+func counterValue(c prometheus.Counter) uint64 {
+	var m dto.Metric
+	c.Write(&m)
+	return uint64(m.GetCounter().GetValue())
+}
+
+// stats
+func (c *Connection) stats() (Result, error) {
+	s, err := c.db.Stats()
+	if err != nil {
+		return Result{}, err
+	}
+	lines := []string{
+		fmt.Sprintf("keys %d", s.Keys),
+		fmt.Sprintf("versions %d", s.Versions),
+		fmt.Sprintf("dead_versions %d", s.DeadVersions),
+		fmt.Sprintf("active_transactions %d", s.ActiveTransactions),
+		fmt.Sprintf("oldest_snapshot %d", s.OldestSnapshot),
+		fmt.Sprintf("wal_size %d", s.WALSize),
+		fmt.Sprintf("version_bytes %d", s.VersionBytes),
+		fmt.Sprintf("transaction_bytes %d", s.TransactionBytes),
+		fmt.Sprintf("max_memory %d", s.MaxMemory),
+		fmt.Sprintf("commits %d", s.Commits),
+		fmt.Sprintf("aborts %d", s.Aborts),
+		fmt.Sprintf("conflicts %d", s.Conflicts),
+	}
+	return Result{Value: strings.Join(lines, "\n")}, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	assertEq(err, nil, "open")
+	defer database.Close()
+	database.defaultIsolation = SnapshotIsolation
+
+	database.Set("a", "1")
+	database.Set("a", "2")
+	database.Set("b", "1")
+
+	t1, _ := database.Begin()
+	t2, _ := database.Begin()
+	t1.Set("b", "2")
+	t2.Set("b", "3")
+	t1.Commit()
+	t2.Commit()
+
+	reader, _ := database.Begin()
+	defer reader.Abort()
+
+	s, err := database.Stats()
+	assertEq(err, nil, "stats")
+	assertEq(s.Keys, 2, "keys")
+	assertEq(s.Versions, 5, "versions")
+	// a=1 and b=1 were replaced, and b=3 aborted.
+	assertEq(s.DeadVersions, 3, "dead versions")
+	assertEq(s.ActiveTransactions, 1, "active")
+	assertEq(s.OldestSnapshot, reader.id, "oldest snapshot")
+	assert(s.WALSize > 0, "wal size")
+	assertEq(s.Commits, uint64(4), "commits")
+	assertEq(s.Aborts, uint64(1), "aborts")
+	assertEq(s.Conflicts, uint64(1), "conflicts")
+
+	c := database.newConnection()
+	out := c.mustExecCommand("stats", nil)
+	assert(strings.HasPrefix(out, "keys 2\nversions 5\ndead_versions 3\nactive_transactions 1\n"), "stats command")
+	assert(strings.HasSuffix(out, "\ncommits 4\naborts 1\nconflicts 1"), "counters")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+Syncing the log on every commit makes every commit durable, and costs an
+fsync each. A FileWAL can trade some of that durability for throughput:
+
+  - SyncEveryCommit (the default) syncs before each commit returns.
+  - SyncPeriodically syncs in the background every interval, so a crash
+    can lose the commits of the last interval or so.
+  - SyncNever leaves it to the operating system, so a crash of the
+    process loses nothing, but a crash of the machine can lose anything
+    not yet written back.
+
+Whatever the mode, a lost commit is lost whole: recovery never sees half
+a transaction.
+*/
+
+type SyncMode uint8
+
+const (
+	SyncEveryCommit SyncMode = iota
+	SyncPeriodically
+	SyncNever
+)
+
+func (m SyncMode) String() string {
+	switch m {
+	case SyncEveryCommit:
+		return "every-commit"
+	case SyncPeriodically:
+		return "periodically"
+	case SyncNever:
+		return "never"
+	}
+	return fmt.Sprintf("SyncMode(%d)", uint8(m))
+}
+
+func parseSyncMode(s string) (SyncMode, error) {
+	for m := SyncEveryCommit; m <= SyncNever; m++ {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sync mode %q", s)
+}
+
+// SetSyncMode changes when the log is synced. The interval only matters
+// to SyncPeriodically.
+func (w *FileWAL) SetSyncMode(mode SyncMode, interval time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopSyncer()
+	w.syncMode = mode
+	if mode == SyncPeriodically {
+		w.startSyncer(interval)
+	}
+}
+
+// Flush syncs the log whatever the mode, as on shutdown.
+func (w *FileWAL) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failed != nil {
+		return fmt.Errorf("%w: %w", ErrWALFailed, w.failed)
+	}
+	return w.sync()
+}
+
+func (w *FileWAL) startSyncer(interval time.Duration) {
+	done := make(chan struct{})
+	w.syncerDone = done
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.mu.Lock()
+				if w.failed == nil {
+					if err := w.sync(); err != nil {
+						w.logger.Error("periodic wal sync failed", "err", err)
+					}
+				}
+				w.mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (w *FileWAL) stopSyncer() {
+	if w.syncerDone != nil {
+		close(w.syncerDone)
+		w.syncerDone = nil
+	}
+}
+
+// SetSyncMode changes when the database's write-ahead log is synced.
+func (d *Database) SetSyncMode(mode SyncMode, interval time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	wal, ok := d.wal.(*FileWAL)
+	if !ok {
+		return fmt.Errorf("sync mode: database has no log file")
+	}
+	wal.SetSyncMode(mode, interval)
+	return nil
+}
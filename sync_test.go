@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetSyncMode(t *testing.T) {
+	database := newDatabase()
+	database.wal = &MemoryWAL{}
+	assertEq(database.SetSyncMode(SyncNever, 0).Error(), "sync mode: database has no log file", "memory log")
+
+	// However the log is synced, what was written survives the process.
+	for _, mode := range []SyncMode{SyncEveryCommit, SyncPeriodically, SyncNever} {
+		dir := t.TempDir()
+		database, err := NewDatabase(dir)
+		assertEq(err, nil, "open")
+		assertEq(database.SetSyncMode(mode, time.Millisecond), nil, "set sync mode")
+
+		c := database.newConnection()
+		c.mustExecCommand("set", []string{"x", mode.String()})
+		crash(database)
+
+		database, err = NewDatabase(dir)
+		assertEq(err, nil, "reopen")
+		c = database.newConnection()
+		assertEq(c.mustExecCommand("get", []string{"x"}), mode.String(), "recovered")
+		database.Close()
+	}
+}
+
+func BenchmarkCommitSync(b *testing.B) {
+	for _, mode := range []SyncMode{SyncEveryCommit, SyncPeriodically, SyncNever} {
+		b.Run(mode.String(), func(b *testing.B) {
+			database, err := NewDatabase(b.TempDir())
+			assertEq(err, nil, "open")
+			defer database.Close()
+			database.SetSyncMode(mode, 10*time.Millisecond)
+
+			for i := 0; b.Loop(); i++ {
+				tx, _ := database.Begin()
+				tx.Set("k", "hey")
+				tx.Commit()
+			}
+		})
+	}
+}
+
+func TestParseSyncMode(t *testing.T) {
+	for _, mode := range []SyncMode{SyncEveryCommit, SyncPeriodically, SyncNever} {
+		parsed, err := parseSyncMode(mode.String())
+		assertEq(err, nil, mode.String())
+		assertEq(parsed, mode, mode.String())
+	}
+	_, err := parseSyncMode("sometimes")
+	assert(err != nil, "unknown mode")
+}
@@ -0,0 +1,16 @@
+# A transaction reads another's write before it commits only under Read
+# Uncommitted, which reads the latest version even once its writer has
+# aborted.
+setup: set x 1
+
+isolation read-uncommitted
+s1: begin; s1: set x 2
+s2: get x => OK "2"
+s1: abort
+s2: get x => OK "2"
+
+isolation read-committed
+s1: begin; s1: set x 2
+s2: get x => OK "1"
+s1: commit
+s2: get x => OK "2"
@@ -0,0 +1,26 @@
+# Two transactions read a key and both write it. Up to Repeatable Read the
+# second to commit overwrites the first; Snapshot Isolation refuses it.
+setup: set x 1
+
+isolation read-committed
+s1: begin; s2: begin
+s1: get x => OK "1"; s2: get x => OK "1"
+s1: set x 2; s2: set x 3
+s1: commit => OK ""
+s2: commit => OK ""
+s3: get x => OK "3"
+
+isolation repeatable-read
+s1: begin; s2: begin
+s1: get x => OK "1"; s2: get x => OK "1"
+s1: set x 2; s2: set x 3
+s1: commit => OK ""
+s2: commit => OK ""
+
+isolation snapshot
+s1: begin; s2: begin
+s1: get x => OK "1"; s2: get x => OK "1"
+s1: set x 2; s2: set x 3
+s1: commit => OK ""
+s2: commit => ERR write-write conflict...
+s3: get x => OK "2"
@@ -0,0 +1,23 @@
+# Reading a key again in a transaction gives what has committed since
+# under Read Committed, and what it read before from Repeatable Read up.
+setup: set x 1
+
+isolation read-committed
+s1: begin
+s1: get x => OK "1"
+s2: set x 2
+s1: get x => OK "2"
+s1: commit
+
+isolation repeatable-read
+s1: begin
+s1: get x => OK "1"
+s2: set x 2
+s1: get x => OK "1"
+s1: commit
+
+isolation snapshot
+s1: begin
+s2: set x 2
+s1: get x => OK "1"
+s1: commit
@@ -0,0 +1,12 @@
+# A transaction sees its own delete, whatever others write to the key
+# meanwhile, and an aborted write doesn't bring back a committed delete.
+setup: set x 1
+
+isolation read-committed
+s1: begin
+s1: delete x
+s2: set x 2
+s1: get x => NIL
+s1: commit
+s2: begin; s2: set x 3; s3: delete x; s2: abort
+s3: get x => NIL
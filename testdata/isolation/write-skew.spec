@@ -0,0 +1,18 @@
+# Each transaction reads both keys and writes the one the other doesn't.
+# Snapshot Isolation lets both commit; Serializable refuses the second.
+setup: set x 1; setup: set y 1
+
+isolation snapshot
+s1: begin; s2: begin
+s1: get x; s1: get y; s2: get x; s2: get y
+s1: set x 0; s2: set y 0
+s1: commit => OK ""
+s2: commit => OK ""
+
+isolation serializable
+s1: begin; s2: begin
+s1: get x; s1: get y; s2: get x; s2: get y
+s1: set x 0; s2: set y 0
+s1: commit => OK ""
+s2: commit => ERR read-write conflict...
+s3: get y => OK "1"
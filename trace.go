@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+Every transaction is traced as an OpenTelemetry span, from begin to
+commit or abort, and every statement run through a Connection is a span
+of its own: inside its transaction's span, or around the transaction made
+for it when it autocommits.
+
+Transaction spans record the isolation level, how many keys were read and
+written, how many versions visibility checks walked, and the outcome,
+including the kind of conflict that refused a commit. Statement spans
+record the keys the statement touched, and in an explicit transaction the
+versions it walked.
+
+Spans go to the TracerProvider given with WithTracerProvider, or
+otherwise to otel's global one, which drops them unless the application
+has set one. Frontends that serve requests with a context of their own
+(gRPC, HTTP) run their statements in it, so a transaction begun by a
+request is that request's child, and later statements in the transaction
+link back to the requests that ran them.
+*/
+
+const tracerName = "github.com/Rohianon/mvcc"
+
+// Keys recorded on a statement span, at most.
+const maxSpanKeys = 16
+
+// WithTracerProvider makes the database trace to tp.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(d *Database) {
+		d.tracer = tp.Tracer(tracerName)
+	}
+}
+
+func defaultTracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// BeginContext is like Begin, but the transaction's span is a child of
+// whatever span ctx carries.
+func (d *Database) BeginContext(ctx context.Context) (*Transaction, error) {
+	return d.beginAt(ctx, d.defaultIsolation)
+}
+
+func (d *Database) beginAt(ctx context.Context, isolation IsolationLevel) (*Transaction, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.shutdown {
+		return nil, ErrDatabaseShutdown
+	}
+
+	t := d.newTransactionAt(ctx, isolation)
+	d.assertValidTransaction(t)
+	return t, nil
+}
+
+func (t *Transaction) startSpan(ctx context.Context) {
+	t.ctx, t.span = t.db.tracer.Start(ctx, "transaction")
+	// Spans nobody records needn't have their attributes built.
+	if t.span.IsRecording() {
+		t.span.SetAttributes(
+			attribute.Int64("mvcc.tx.id", int64(t.id)),
+			attribute.String("mvcc.isolation", t.isolation.String()),
+		)
+	}
+}
+
+// endSpan ends the transaction's span once it has reached state.
+func (t *Transaction) endSpan(state TransactionState) {
+	if t.span == nil {
+		return
+	}
+	if t.span.IsRecording() {
+		t.span.SetAttributes(
+			attribute.String("mvcc.outcome", state.String()),
+			attribute.Int("mvcc.reads", t.readset.Len()),
+			attribute.Int("mvcc.writes", t.writeset.Len()),
+			attribute.Int("mvcc.versions_scanned", t.versionsScanned),
+		)
+	}
+	t.span.End()
+	t.span = nil
+}
+
+// conflict records that a commit was refused by a conflict of the given
+// kind with winner over keys, and returns the error to refuse it with.
+func (t *Transaction) conflict(kind string, winner *Transaction, keys []string) error {
+	t.db.metrics.conflicts.WithLabelValues(kind).Inc()
+	err := &ConflictError{Kind: kind, TxId: t.id, Winner: winner.id, Keys: keys,
+		Explanation: t.explainConflict(kind, winner, keys)}
+	if t.span != nil {
+		if len(keys) > maxSpanKeys {
+			keys = keys[:maxSpanKeys]
+		}
+		t.span.SetAttributes(
+			attribute.String("mvcc.conflict", kind),
+			attribute.Int64("mvcc.conflict.winner", int64(winner.id)),
+			attribute.StringSlice("mvcc.conflict.keys", keys),
+		)
+		t.span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// context returns the context the connection's requests are served in.
+func (c *Connection) context() context.Context {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if c.db.audit != nil {
+		if who := c.identity(); who != "" {
+			ctx = WithIdentity(ctx, who)
+		}
+	}
+	return ctx
+}
+
+// startStatement starts the span for a statement, returning its context.
+func (c *Connection) startStatement(command string) (context.Context, trace.Span) {
+	ctx := c.context()
+	var opts []trace.SpanStartOption
+	if c.tx != nil && c.tx.ctx != nil {
+		// The request that runs the statement is elsewhere in the trace.
+		opts = append(opts, trace.WithLinks(trace.LinkFromContext(ctx)))
+		ctx = c.tx.ctx
+	}
+	opts = append(opts, trace.WithAttributes(attribute.String("mvcc.command", command)))
+	return c.db.tracer.Start(ctx, command, opts...)
+}
+
+func endStatement(span trace.Span, tx *Transaction, versionsBefore int, res Result, err error) {
+	keys := res.Keys
+	if len(keys) > maxSpanKeys {
+		keys = keys[:maxSpanKeys]
+	}
+	span.SetAttributes(
+		attribute.StringSlice("mvcc.keys", keys),
+		attribute.Int("mvcc.keys.count", len(res.Keys)),
+	)
+	if tx != nil {
+		span.SetAttributes(attribute.Int("mvcc.versions_scanned", tx.versionsScanned-versionsBefore))
+	}
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	database := newDatabase()
+	database.apply(WithTracerProvider(tp))
+	database.defaultIsolation = SnapshotIsolation
+
+	request, requestSpan := tp.Tracer("test").Start(context.Background(), "request")
+	c1 := database.newConnection()
+	c1.ctx = request
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "1"})
+	requestSpan.End()
+
+	c2 := database.newConnection()
+	c2.mustExecCommand("set", []string{"x", "2"})
+
+	c1.ctx = nil
+	_, err := c1.execCommand("commit", nil)
+	assert(err != nil, "conflict")
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	assertEq(len(spans["transaction"]), 2, "transaction spans")
+	assertEq(len(spans["set"]), 2, "statement spans")
+	assertEq(len(spans["begin"]), 0, "begin has no span of its own")
+
+	var tx1, tx2 sdktrace.ReadOnlySpan
+	for _, span := range spans["transaction"] {
+		if spanAttr(span, "mvcc.tx.id").AsInt64() == 1 {
+			tx1 = span
+		} else {
+			tx2 = span
+		}
+	}
+	assertEq(tx1.Parent().SpanID(), requestSpan.SpanContext().SpanID(), "transaction is the request's child")
+	assertEq(spanAttr(tx1, "mvcc.isolation").AsString(), "snapshot", "isolation")
+	assertEq(spanAttr(tx1, "mvcc.outcome").AsString(), "aborted", "outcome")
+	assertEq(spanAttr(tx1, "mvcc.conflict").AsString(), "write-write", "conflict")
+	assertEq(spanAttr(tx1, "mvcc.writes").AsInt64(), int64(1), "writes")
+	assertEq(spanAttr(tx2, "mvcc.outcome").AsString(), "committed", "autocommit outcome")
+
+	for _, span := range spans["set"] {
+		if span.Parent().SpanID() == tx1.SpanContext().SpanID() {
+			assertEq(spanAttr(span, "mvcc.keys").AsStringSlice()[0], "x", "keys touched")
+			continue
+		}
+		// An autocommitted statement wraps its transaction.
+		assertEq(tx2.Parent().SpanID(), span.SpanContext().SpanID(), "autocommit transaction inside its statement")
+	}
+	commit := spans["commit"][0]
+	assertEq(commit.Parent().SpanID(), tx1.SpanContext().SpanID(), "commit inside its transaction")
+	assertEq(commit.Status().Description, `write-write conflict with transaction 2 on "x"`, "commit failed")
+}
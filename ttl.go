@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+/*
+Expiry is MVCC data like any other. "expire key 10" doesn't touch the
+existing version: it writes a new version of the key with the same value
+and an expiry time, so transactions whose snapshot predates the expire
+keep seeing the key exactly as it was. Setting the key again writes a
+version without an expiry, which is how a set clears one.
+
+Expired versions are ignored lazily, on read. At Repeatable Read and
+stricter the expiry is judged against the time the transaction started,
+so a snapshot doesn't change under its reader just because the clock
+moved on; at the weaker levels it is judged against the current time.
+
+That alone would leave expired versions in the chains forever, looking
+alive to anything that doesn't check expiry. The optional sweeper fixes
+that by deleting expired keys from an internal transaction, which ends
+their versions like any other delete.
+*/
+
+// expired reports whether a version has expired for the transaction.
+func (t *Transaction) expired(v *Value) bool {
+	if v.expiresAt == 0 {
+		return false
+	}
+
+	now := t.db.clock()
+	if t.isolation >= RepeatableReadIsolation {
+		now = t.started
+	}
+	return now.Unix() >= int64(v.expiresAt)
+}
+
+// expiryTime rounds up to whole seconds, so keys never expire early.
+// Times past what a version can hold saturate (in 2106), and times before
+// the epoch are still expiry times, already past.
+func expiryTime(at time.Time) uint32 {
+	secs := at.Unix()
+	if at.Nanosecond() > 0 {
+		secs++
+	}
+	return uint32(min(max(secs, 1), math.MaxUint32))
+}
+
+// Expire makes key expire after ttl, reporting whether the key existed.
+// A non-positive ttl deletes the key right away.
+func (t *Transaction) Expire(key string, ttl time.Duration) (bool, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return false, err
+	}
+
+	value, err := t.get(key)
+	if err != nil {
+		return false, nil
+	}
+
+	if ttl <= 0 {
+		return true, t.delete(key)
+	}
+
+	raw, err := t.db.readRaw(value)
+	if err != nil {
+		return false, err
+	}
+	t.write(key, raw, value.data.kind, expiryTime(t.db.clock().Add(ttl)))
+	return true, nil
+}
+
+// TTL returns how long key has left to live, or false if it doesn't
+// expire.
+func (t *Transaction) TTL(key string) (time.Duration, bool, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, false, err
+	}
+
+	value, err := t.get(key)
+	if err != nil {
+		return 0, false, err
+	}
+	if value.expiresAt == 0 {
+		return 0, false, nil
+	}
+	return time.Unix(int64(value.expiresAt), 0).Sub(t.db.clock()), true, nil
+}
+
+// sweepExpired deletes every key whose visible version has expired, in a
+// transaction of its own, and returns how many it deleted.
+func (d *Database) sweepExpired() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.shutdown {
+		return 0, ErrDatabaseShutdown
+	}
+
+	t := d.newTransaction(context.Background())
+
+	var keys []string
+	iter := d.store.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		versions := iter.Value()
+		for i := len(versions) - 1; i >= 0; i-- {
+			if d.isvisible(t, versions[i]) {
+				if t.expired(&versions[i]) {
+					keys = append(keys, iter.Key())
+				}
+				break
+			}
+		}
+	}
+
+	// A plain delete refuses keys that are already expired, so end the
+	// versions directly.
+	for _, key := range keys {
+		t.endVisible(key)
+		t.writeset.Insert(key)
+		t.log(WALRecord{Type: WALDelete, Key: key})
+	}
+
+	if err := d.completeTransaction(t, CommittedTransaction); err != nil {
+		return 0, err
+	}
+	d.logger.Debug("swept expired keys", "keys", len(keys))
+	return len(keys), nil
+}
+
+// StartExpirySweeper deletes expired keys every interval until the
+// returned function is called.
+func (d *Database) StartExpirySweeper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := d.sweepExpired(); errors.Is(err, ErrDatabaseShutdown) {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// expire key seconds
+func (c *Connection) expire(args []string) (Result, error) {
+	secs, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("expire: seconds %w", ErrNotInteger)
+	}
+	// Any more and the duration would overflow.
+	if secs > math.MaxInt64/int64(time.Second) {
+		return Result{}, fmt.Errorf("expire: %d seconds is out of range", secs)
+	}
+
+	res := Result{Keys: args[:1], TxId: c.tx.id, Value: "0"}
+	ok, err := c.tx.Expire(args[0], time.Duration(max(secs, 0))*time.Second)
+	if ok {
+		res.Value = "1"
+	}
+	return res, err
+}
+
+// ttl key
+//
+// Returns the whole seconds left, or -1 if the key doesn't expire.
+func (c *Connection) ttl(args []string) (Result, error) {
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	ttl, expires, err := c.tx.TTL(args[0])
+	if err != nil {
+		res.NotFound = errors.Is(err, ErrKeyNotFound)
+		return res, err
+	}
+
+	res.Value = "-1"
+	if expires {
+		res.Value = strconv.FormatInt(int64(ttl.Round(time.Second)/time.Second), 10)
+	}
+	return res, nil
+}
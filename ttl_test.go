@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	database := newDatabase()
+	now := time.Unix(1_000_000, 0)
+	database.now = func() time.Time { return now }
+
+	database.Set("x", "hey")
+	database.Set("y", "yall")
+
+	c1 := database.newConnection()
+	assertEq(c1.mustExecCommand("ttl", []string{"x"}), "-1", "ttl before expire")
+	assertEq(c1.mustExecCommand("expire", []string{"x", "10"}), "1", "expire x")
+	assertEq(c1.mustExecCommand("expire", []string{"missing", "10"}), "0", "expire missing")
+	assertEq(c1.mustExecCommand("ttl", []string{"x"}), "10", "ttl after expire")
+
+	// A repeatable read snapshot taken now keeps seeing x after it expires.
+	database.defaultIsolation = RepeatableReadIsolation
+	snapshot := database.newConnection()
+	snapshot.mustExecCommand("begin", nil)
+	database.defaultIsolation = ReadCommitedIsolation
+
+	now = now.Add(10 * time.Second)
+
+	_, err := c1.execCommand("get", []string{"x"})
+	assert(errors.Is(err, ErrKeyNotFound), "x expired")
+	_, err = c1.execCommand("delete", []string{"x"})
+	assert(err != nil, "delete expired x")
+	assertEq(snapshot.mustExecCommand("get", []string{"x"}), "hey", "snapshot still sees x")
+
+	// Setting the key again clears the expiry.
+	c1.mustExecCommand("set", []string{"x", "back"})
+	assertEq(c1.mustExecCommand("ttl", []string{"x"}), "-1", "ttl after set")
+
+	// An expire of zero deletes straight away.
+	c1.mustExecCommand("expire", []string{"y", "0"})
+	_, err = c1.execCommand("get", []string{"y"})
+	assert(errors.Is(err, ErrKeyNotFound), "y deleted")
+}
+
+func TestExpire_far(t *testing.T) {
+	database := newDatabase()
+	now := time.Unix(1_700_000_000, 0)
+	database.now = func() time.Time { return now }
+	database.Set("x", "hey")
+
+	c := database.newConnection()
+	// Past what a version can hold, the expiry saturates rather than
+	// wrapping into the past.
+	assertEq(c.mustExecCommand("expire", []string{"x", "4000000000"}), "1", "expire")
+	assertEq(c.mustExecCommand("get", []string{"x"}), "hey", "not expired")
+	assertEq(c.mustExecCommand("ttl", []string{"x"}), fmt.Sprint(math.MaxUint32-1_700_000_000), "ttl")
+
+	_, err := c.execCommand("expire", []string{"x", "10000000000"})
+	assert(err != nil, "out of range")
+	_, err = c.execCommand("expire", []string{"x", "-10000000000"})
+	assertEq(err, nil, "a negative expiry deletes")
+	_, err = c.execCommand("get", []string{"x"})
+	assert(errors.Is(err, ErrKeyNotFound), "deleted")
+}
+
+func TestSweepExpired(t *testing.T) {
+	database := newDatabase()
+	now := time.Unix(1_000_000, 0)
+	database.now = func() time.Time { return now }
+
+	database.Set("a", "1")
+	database.Set("b", "2")
+	tx, _ := database.Begin()
+	tx.Expire("a", time.Second)
+	tx.Commit()
+
+	n, err := database.sweepExpired()
+	assertEq(err, nil, "sweep")
+	assertEq(n, 0, "nothing expired yet")
+
+	now = now.Add(time.Second)
+	n, _ = database.sweepExpired()
+	assertEq(n, 1, "a swept")
+
+	// The sweeper's delete ended the expired version like any other.
+	versions := database.versions("a")
+	assert(versions[len(versions)-1].txEndId != 0, "a's last version ended")
+
+	n, _ = database.sweepExpired()
+	assertEq(n, 0, "nothing left to sweep")
+}
@@ -0,0 +1,57 @@
+package main
+
+import "slices"
+
+/*
+A transaction at Repeatable Read or stricter needs to know which
+transactions were in progress when it began, to ignore their writes even
+once they commit. Rather than copy the set of them, it keeps a snapshot
+in the manner of PostgreSQL's: xmin, the oldest transaction in progress,
+below which every transaction had finished; xmax, the first id not yet
+handed out; and the ids in between still running, sorted. Taking one
+costs as much as there are transactions running, however many the
+database remembers, and asking about an id outside [xmin, xmax) costs
+nothing.
+*/
+
+type snapshot struct {
+	xmin    uint64
+	xmax    uint64
+	running []uint64
+}
+
+// newSnapshot returns the snapshot with the sorted ids running, taken
+// before xmax was handed out.
+func newSnapshot(xmax uint64, running []uint64) snapshot {
+	s := snapshot{xmin: xmax, xmax: xmax, running: running}
+	if len(running) > 0 {
+		s.xmin = running[0]
+	}
+	return s
+}
+
+// contains reports whether transaction id was in progress.
+func (s snapshot) contains(id uint64) bool {
+	if id < s.xmin || id >= s.xmax {
+		return false
+	}
+	_, found := slices.BinarySearch(s.running, id)
+	return found
+}
+
+// oldest returns the oldest transaction that was in progress, if any.
+func (s snapshot) oldest() (uint64, bool) {
+	if len(s.running) == 0 {
+		return 0, false
+	}
+	return s.xmin, true
+}
+
+// with returns a copy of s with id in progress too.
+func (s snapshot) with(id uint64) snapshot {
+	i, found := slices.BinarySearch(s.running, id)
+	if found {
+		return s
+	}
+	return newSnapshot(max(s.xmax, id+1), slices.Insert(slices.Clip(s.running), i, id))
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestSnapshot(t *testing.T) {
+	s := newSnapshot(10, []uint64{3, 7})
+	assertEq(s.xmin, uint64(3), "xmin")
+	for id, want := range map[uint64]bool{1: false, 3: true, 5: false, 7: true, 10: false, 12: false} {
+		assertEq(s.contains(id), want, "contains")
+	}
+	oldest, ok := s.oldest()
+	assert(ok && oldest == 3, "oldest")
+
+	s2 := s.with(5).with(10)
+	assert(s2.contains(5) && s2.contains(10), "with")
+	assertEq(s2.xmax, uint64(11), "xmax")
+	assert(!s.contains(5), "the original is unchanged")
+
+	_, ok = newSnapshot(4, nil).oldest()
+	assert(!ok, "none running")
+}
+
+func TestSnapshot_running(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = SnapshotIsolation
+	// Finished transactions the database still remembers aren't in it.
+	longHistory(&database, "x", 100)
+	c1, c2 := database.newConnection(), database.newConnection()
+	c1.mustExecCommand("begin", nil)
+	c2.mustExecCommand("begin", nil)
+
+	assert(len(c2.tx.inprogress.running) == 1 && c2.tx.inprogress.contains(c1.tx.id), "running")
+	assertEq(c2.tx.inprogress.xmax, c2.tx.id+1, "xmax")
+}
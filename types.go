@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+/*
+Values are strings unless written with a type: an int, a float, a bool,
+bytes, a list (see list.go), a hash (see hash.go), a set (see set.go),
+a sorted set (see zset.go) or a counter (see counter.go). A typed value is kept in binary, eight bytes
+big-endian for ints and floats and one for bools, with its type alongside
+(see payload.go), so numeric operations like incr work on it without
+parsing and formatting text, and blobs are kept as they are. The type
+goes to the log, checkpoints and backups along with the value.
+
+Reading a typed value as a string formats it: ints and floats in decimal,
+bools as "true" or "false", bytes as they are, and the rest as JSON.
+Checksums are of that string, since that is what clients get. Writing a
+string makes the value a string again, whatever it was before.
+*/
+
+var ErrWrongType = errors.New("value is of the wrong type")
+
+type ValueType uint8
+
+const (
+	StringType ValueType = iota
+	IntType
+	FloatType
+	BoolType
+	BytesType
+	// See list.go.
+	ListType
+	// See hash.go.
+	HashType
+	// See set.go and zset.go.
+	SetType
+	SortedSetType
+	// See counter.go.
+	CounterType
+)
+
+func (k ValueType) String() string {
+	switch k {
+	case StringType:
+		return "string"
+	case IntType:
+		return "int"
+	case FloatType:
+		return "float"
+	case BoolType:
+		return "bool"
+	case BytesType:
+		return "bytes"
+	case ListType:
+		return "list"
+	case HashType:
+		return "hash"
+	case SetType:
+		return "set"
+	case SortedSetType:
+		return "zset"
+	case CounterType:
+		return "counter"
+	}
+	return fmt.Sprintf("ValueType(%d)", uint8(k))
+}
+
+func encodeInt(n int64) string {
+	return string(binary.BigEndian.AppendUint64(nil, uint64(n)))
+}
+
+func decodeInt(raw string) int64 {
+	return int64(binary.BigEndian.Uint64([]byte(raw)))
+}
+
+func encodeFloat(f float64) string {
+	return string(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+}
+
+func decodeFloat(raw string) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64([]byte(raw)))
+}
+
+func encodeBool(b bool) string {
+	if b {
+		return "\x01"
+	}
+	return "\x00"
+}
+
+// formatValue returns the string form of a value of type kind stored as
+// raw.
+func formatValue(kind ValueType, raw string) string {
+	switch kind {
+	case IntType:
+		return strconv.FormatInt(decodeInt(raw), 10)
+	case FloatType:
+		return strconv.FormatFloat(decodeFloat(raw), 'g', -1, 64)
+	case BoolType:
+		return strconv.FormatBool(raw != "\x00")
+	case ListType:
+		return formatList(decodeList(raw))
+	case HashType:
+		return formatHash(decodeHash(raw))
+	case SetType:
+		return formatList(decodeList(raw))
+	case SortedSetType:
+		return formatSortedSet(decodeSortedSet(raw))
+	case CounterType:
+		n, _ := decodeCounter(raw)
+		return strconv.FormatInt(n, 10)
+	}
+	return raw
+}
+
+// parseValue returns the stored form of s, the string form of a value of
+// type kind, if it is one.
+func parseValue(kind ValueType, s string) (string, bool) {
+	switch kind {
+	case IntType:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return encodeInt(n), err == nil
+	case FloatType:
+		f, err := strconv.ParseFloat(s, 64)
+		return encodeFloat(f), err == nil
+	case BoolType:
+		b, err := strconv.ParseBool(s)
+		return encodeBool(b), err == nil
+	case ListType:
+		elems, err := parseList(s)
+		return encodeList(elems), err == nil
+	case HashType:
+		fields, err := parseHash(s)
+		return encodeHash(fields), err == nil
+	case SetType:
+		members, err := parseList(s)
+		slices.Sort(members)
+		return encodeList(slices.Compact(members)), err == nil
+	case SortedSetType:
+		members, err := parseSortedSet(s)
+		return encodeSortedSet(members), err == nil
+	case CounterType:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return encodeCounter(n, 0), err == nil
+	}
+	return s, true
+}
+
+func wrongType(key string, kind, want ValueType) error {
+	return fmt.Errorf("%w: %s is of type %s, not %s", ErrWrongType, key, kind, want)
+}
+
+// Type returns the type of the value of key visible to the transaction.
+func (t *Transaction) Type(key string) (ValueType, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, err
+	}
+	value, err := t.get(key)
+	if err != nil {
+		return 0, err
+	}
+	return value.data.kind, nil
+}
+
+// getTyped returns the stored form of the value of key, which must be of
+// type kind.
+func (t *Transaction) getTyped(key string, kind ValueType) (string, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return "", err
+	}
+	value, err := t.get(key)
+	if err != nil {
+		return "", err
+	}
+	if value.data.kind != kind {
+		return "", wrongType(key, value.data.kind, kind)
+	}
+	return t.db.readRaw(value)
+}
+
+// setTyped writes the stored form of a value of type kind to key.
+func (t *Transaction) setTyped(key, raw string, kind ValueType) error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return err
+	}
+	t.write(key, raw, kind, 0)
+	return nil
+}
+
+func (t *Transaction) GetInt(key string) (int64, error) {
+	raw, err := t.getTyped(key, IntType)
+	if err != nil {
+		return 0, err
+	}
+	return decodeInt(raw), nil
+}
+
+func (t *Transaction) SetInt(key string, n int64) error {
+	return t.setTyped(key, encodeInt(n), IntType)
+}
+
+func (t *Transaction) GetFloat(key string) (float64, error) {
+	raw, err := t.getTyped(key, FloatType)
+	if err != nil {
+		return 0, err
+	}
+	return decodeFloat(raw), nil
+}
+
+func (t *Transaction) SetFloat(key string, f float64) error {
+	return t.setTyped(key, encodeFloat(f), FloatType)
+}
+
+func (t *Transaction) GetBool(key string) (bool, error) {
+	raw, err := t.getTyped(key, BoolType)
+	if err != nil {
+		return false, err
+	}
+	return raw != "\x00", nil
+}
+
+func (t *Transaction) SetBool(key string, b bool) error {
+	return t.setTyped(key, encodeBool(b), BoolType)
+}
+
+func (t *Transaction) GetBytes(key string) ([]byte, error) {
+	raw, err := t.getTyped(key, BytesType)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(raw), nil
+}
+
+func (t *Transaction) SetBytes(key string, b []byte) error {
+	return t.setTyped(key, string(b), BytesType)
+}
+
+// type key
+func (c *Connection) typeOf(args []string) (Result, error) {
+	res := Result{Keys: args, TxId: c.tx.id}
+	kind, err := c.tx.Type(args[0])
+	if err != nil {
+		return res, err
+	}
+	res.Value = kind.String()
+	return res, nil
+}
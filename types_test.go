@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestTypedValues(t *testing.T) {
+	database := newDatabase()
+	database.apply(WithChecksums())
+	tx, _ := database.Begin()
+	assertEq(tx.SetInt("i", -42), nil, "set int")
+	assertEq(tx.SetFloat("f", 2.5), nil, "set float")
+	assertEq(tx.SetBool("b", true), nil, "set bool")
+	assertEq(tx.SetBytes("raw", []byte{0, 1, 0xff}), nil, "set bytes")
+	tx.Set("s", "hey")
+
+	n, err := tx.GetInt("i")
+	assertEq(err, nil, "get int")
+	assertEq(n, -42, "int")
+	f, _ := tx.GetFloat("f")
+	assertEq(f, 2.5, "float")
+	b, _ := tx.GetBool("b")
+	assertEq(b, true, "bool")
+	raw, _ := tx.GetBytes("raw")
+	assert(bytes.Equal(raw, []byte{0, 1, 0xff}), "bytes")
+
+	// As strings, they are formatted.
+	for key, want := range map[string]string{"i": "-42", "f": "2.5", "b": "true", "s": "hey"} {
+		value, checksum, _ := tx.GetWithChecksum(key)
+		assertEq(value, want, "string form of "+key)
+		assertEq(checksum, Checksum(want), "checksum of the string form of "+key)
+	}
+
+	_, err = tx.GetInt("s")
+	assert(errors.Is(err, ErrWrongType), "a string is not an int")
+	assertEq(err.Error(), "value is of the wrong type: s is of type string, not int", "message")
+	kind, _ := tx.Type("f")
+	assertEq(kind, FloatType, "type")
+
+	// incr keeps ints binary, and counts decimal strings.
+	n, _ = tx.Incr("i", 2)
+	assertEq(n, -40, "incr int")
+	tx.Set("counter", "7")
+	n, _ = tx.Incr("counter", 1)
+	assertEq(n, 8, "incr string")
+	kind, _ = tx.Type("counter")
+	assertEq(kind, IntType, "incr makes an int")
+	_, err = tx.Incr("f", 1)
+	assertEq(err, ErrNotInteger, "incr float")
+
+	// Writing a string makes it a string again.
+	tx.Set("i", "hey")
+	kind, _ = tx.Type("i")
+	assertEq(kind, StringType, "string again")
+}
+
+func TestTypedValues_commands(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"s", "hey"})
+	c.mustExecCommand("incr", []string{"n"})
+	assertEq(c.mustExecCommand("type", []string{"s"}), "string", "string")
+	assertEq(c.mustExecCommand("type", []string{"n"}), "int", "int")
+	assertEq(c.mustExecCommand("get", []string{"n"}), "1", "int as a string")
+
+	// Copies, renames and expiry keep the type.
+	c.mustExecCommand("copy", []string{"n", "m"})
+	c.mustExecCommand("rename", []string{"m", "o"})
+	c.mustExecCommand("expire", []string{"o", "100"})
+	assertEq(c.mustExecCommand("type", []string{"o"}), "int", "still an int")
+
+	_, err := c.execCommand("type", []string{"missing"})
+	assertEq(err, ErrKeyNotFound, "missing")
+}
+
+func TestTypedValues_durable(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	assertEq(err, nil, "open")
+
+	write := func(key string, n int64) {
+		tx, _ := database.Begin()
+		tx.SetInt(key, n)
+		assertEq(tx.Commit(), nil, "commit")
+	}
+	read := func(key string) int64 {
+		tx, _ := database.Begin()
+		defer tx.Abort()
+		n, err := tx.GetInt(key)
+		assertEq(err, nil, "get int "+key)
+		return n
+	}
+
+	write("logged", 1)
+	write("checkpointed", 2)
+	assertEq(database.Checkpoint(), nil, "checkpoint")
+	write("logged", 3)
+	crash(database)
+
+	database, err = NewDatabase(dir)
+	assertEq(err, nil, "reopen")
+	assertEq(read("logged"), 3, "recovered from the log")
+	assertEq(read("checkpointed"), 2, "recovered from the checkpoint")
+
+	var backup bytes.Buffer
+	assertEq(database.Backup(&backup), nil, "backup")
+	database.Close()
+	restored := newDatabase()
+	database = &restored
+	assertEq(database.Restore(&backup), nil, "restore")
+	assertEq(read("logged"), 3, "restored")
+}
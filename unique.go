@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+A unique index (see index.go) allows at most one key under each value, so
+two users can't share an email, say. Limiting the extractor to keys with a
+prefix limits the constraint to them too.
+
+Two transactions inserting the same email under different keys write
+different index entries, so they don't conflict by themselves, and
+neither can see the other's write before it commits. So uniqueness is
+checked when a transaction commits, or prepares, whatever its isolation
+level: each value it leaves a key indexed under must have no other key
+under it in what has committed, or been prepared, by then. The second of
+the two to commit fails, and is aborted, like one that conflicts.
+
+The check reads the latest committed values rather than the transaction's
+snapshot, and doesn't add them to the readset, as it's about what the
+database will hold once the transaction commits rather than anything the
+transaction saw.
+*/
+
+var ErrUniqueViolation = errors.New("unique constraint violated")
+
+// CreateUniqueIndex is CreateIndex for an index that allows at most one
+// key under each value. It fails if the keys already there break that.
+func (d *Database) CreateUniqueIndex(name string, extract Extractor) error {
+	return d.createIndex(name, index{extract: extract, unique: true})
+}
+
+func uniqueViolation(name, value, key, other string) error {
+	return fmt.Errorf("%w: %s and %s both have %s %q", ErrUniqueViolation, key, other, name, value)
+}
+
+// checkUnique checks that none of the keys t wrote share a value in a
+// unique index with a key committed, or prepared, by another transaction.
+func (d *Database) checkUnique(t *Transaction) error {
+	var unique []string
+	indexes := d.allIndexes()
+	for name, idx := range indexes {
+		if idx.unique {
+			unique = append(unique, name)
+		}
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	iter := t.writeset.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		key := iter.Key()
+		value := d.committedOrPrepared(t, key)
+		if isIndexKey(key) || value == nil {
+			continue
+		}
+		s, err := d.read(value)
+		if err != nil {
+			return err
+		}
+		for _, name := range unique {
+			v := indexes[name].extract(key, s)
+			if v == "" {
+				continue
+			}
+			other, err := d.indexedUnder(t, name, v, key)
+			if err != nil {
+				return err
+			}
+			if other != "" {
+				return uniqueViolation(name, v, key, other)
+			}
+		}
+	}
+	return nil
+}
+
+// indexedUnder returns a key other than key that index name has under
+// value, in what has committed or been prepared, if there is one.
+func (d *Database) indexedUnder(t *Transaction, name, value, key string) (string, error) {
+	idx := d.allIndexes()[name]
+	prefix := indexEntryPrefix(name, value)
+	iter := d.store.Iter()
+	for ok := iter.Seek(prefix); ok && strings.HasPrefix(iter.Key(), prefix); ok = iter.Next() {
+		other := strings.TrimPrefix(iter.Key(), prefix)
+		if other == key || d.committedOrPrepared(t, iter.Key()) == nil {
+			continue
+		}
+		// The entry may be stale.
+		v := d.committedOrPrepared(t, other)
+		if v == nil {
+			continue
+		}
+		s, err := d.read(v)
+		if err != nil {
+			return "", err
+		}
+		if idx.extract(other, s) == value {
+			return other, nil
+		}
+	}
+	return "", nil
+}
+
+// committedOrPrepared returns the newest version of key that has been
+// committed, written by t, or written by a prepared transaction, if it
+// hasn't been deleted since.
+func (d *Database) committedOrPrepared(t *Transaction, key string) *Value {
+	versions := d.versions(key)
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := &versions[i]
+		if d.visibleCommitted(t, *v) {
+			return v
+		}
+		if w, ok := d.transactions.Get(v.txStartId); ok && w.prepared != "" && v.txEndId == 0 {
+			return v
+		}
+	}
+	return nil
+}
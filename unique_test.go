@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// byEmail indexes the values of keys starting "user:" by themselves.
+func byEmail(key, value string) string {
+	if !strings.HasPrefix(key, "user:") {
+		return ""
+	}
+	return value
+}
+
+func TestUniqueIndex(t *testing.T) {
+	database := newDatabase()
+	database.defaultIsolation = ReadCommitedIsolation
+	assertEq(database.CreateUniqueIndex("email", byEmail), nil, "create")
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"user:1", "ann@example.com"})
+
+	_, err := c.execCommand("set", []string{"user:2", "ann@example.com"})
+	assert(errors.Is(err, ErrUniqueViolation), "taken")
+	_, err = c.execCommand("get", []string{"user:2"})
+	assertEq(err, ErrKeyNotFound, "and aborted")
+	c.mustExecCommand("set", []string{"other", "ann@example.com"})
+
+	// Only one of two concurrent inserts commits, even though neither saw
+	// the other.
+	first, _ := database.Begin()
+	second, _ := database.Begin()
+	first.Set("user:3", "bob@example.com")
+	second.Set("user:4", "bob@example.com")
+	assertEq(first.Commit(), nil, "first")
+	err = second.Commit()
+	assertEq(err.Error(), `unique constraint violated: user:4 and user:3 both have email "bob@example.com"`, "second")
+	assertEq(database.transactionState(second.id), AbortedTransaction, "second aborted")
+
+	// Moving a value from one key to another in a transaction is fine.
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"user:1", "ann@example.org"})
+	c.mustExecCommand("set", []string{"user:5", "ann@example.com"})
+	c.mustExecCommand("commit", nil)
+
+	// Prepared transactions hold their values.
+	prepared, _ := database.Begin()
+	prepared.Set("user:6", "cat@example.com")
+	assertEq(prepared.Prepare("g"), nil, "prepare")
+	other, _ := database.Begin()
+	other.Set("user:7", "cat@example.com")
+	assert(errors.Is(other.Commit(), ErrUniqueViolation), "held by the prepared transaction")
+	assertEq(database.RollbackPrepared("g"), nil, "rollback")
+	c.mustExecCommand("set", []string{"user:7", "cat@example.com"})
+	assertEq(c.mustExecCommand("lookup", []string{"email", "cat@example.com"}), "user:7", "lookup")
+}
+
+func TestUniqueIndex_existing(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+	c.mustExecCommand("set", []string{"user:1", "ann@example.com"})
+	c.mustExecCommand("set", []string{"user:2", "ann@example.com"})
+
+	err := database.CreateUniqueIndex("email", byEmail)
+	assert(errors.Is(err, ErrUniqueViolation), "existing duplicates")
+	_, err = c.execCommand("lookup", []string{"email", "ann@example.com"})
+	assert(errors.Is(err, ErrUnknownIndex), "not created")
+	assertEq(database.CreateIndex("email", byEmail), nil, "but a plain index can be")
+}
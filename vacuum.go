@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tidwall/btree"
+)
+
+/*
+Nothing ever removes a version from the store: deletes and overwrites only
+set txEndId. Vacuum reclaims the versions no transaction can see, now or in
+the future:
+
+  - versions written by a transaction that aborted, and
+  - versions ended by a transaction that committed before the horizon.
+
+The horizon is the oldest transaction id any in-progress transaction
+might still treat as uncommitted: the smallest of their own ids and of
+the ids that were in progress when they began. Every transaction, current
+or future, sees a commit below the horizon as a commit that happened
+before it started, so a version ended by one is invisible to all of them.
+
+Read Uncommitted is the one level that can see a version from an aborted
+transaction, if nothing has overwritten it yet. Vacuum removes those
+anyway; dirty reads of rolled-back data are not worth keeping.
+
+A delete that was rolled back leaves the transaction's id in txEndId. Vacuum
+clears it, so the aborted transaction need not be remembered.
+
+Time-travel reads as of a transaction older than the horizon may find
+versions missing once a vacuum has run, or the transaction itself gone.
+*/
+
+// horizon returns the smallest transaction id that some in-progress
+// transaction may not see as committed.
+func (d *Database) horizon() uint64 {
+	horizon := d.nextTransactionId
+	iter := d.running.Iter()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		t, _ := d.transactions.Get(iter.Key())
+		horizon = min(horizon, t.id)
+		if oldest, ok := t.inprogress.oldest(); ok {
+			horizon = min(horizon, oldest)
+		}
+	}
+	return horizon
+}
+
+// dead reports whether no transaction can see v, now or later.
+func (d *Database) dead(v *Value, horizon uint64) bool {
+	if d.transactionState(v.txStartId) == AbortedTransaction {
+		return true
+	}
+	return v.txEndId > 0 && v.txEndId < horizon &&
+		d.transactionState(v.txEndId) == CommittedTransaction
+}
+
+// Vacuum removes dead versions from the store and returns how many it
+// removed. Keys left with no versions are removed entirely.
+func (d *Database) Vacuum() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p := d.newVacuumPass()
+	d.vacuumStep(p, 0)
+	d.finishVacuum(p)
+	return p.removed
+}
+
+/*
+A pass over the store can be done in batches, releasing the lock between
+them, so a big store doesn't stall every other transaction while it is
+vacuumed. The horizon is fixed when the pass starts; it only ever moves
+forward, so an old horizon is always a safe one.
+
+Transactions below the horizon are all finished, so nothing can start
+referring to one of them part way through the pass. Once the pass has
+seen every key, the pruned aborted transactions it never found referenced
+can be forgotten.
+*/
+
+type vacuumPass struct {
+	horizon uint64
+	// Whether to ignore the retention policy (see memory.go).
+	aggressive bool
+	// The key the next step starts from, and the one the pass ends
+	// before, if it doesn't go to the end of the store.
+	next    string
+	end     string
+	removed int
+	// Transactions below the horizon that a surviving version refers to.
+	referenced btree.Set[uint64]
+}
+
+func (d *Database) newVacuumPass() *vacuumPass {
+	return &vacuumPass{horizon: d.retentionHorizon()}
+}
+
+// vacuumStep vacuums up to limit keys (every remaining key, if limit is
+// not positive) and reports whether the pass has reached the end of the
+// store.
+func (d *Database) vacuumStep(p *vacuumPass, limit int) bool {
+	var keys []string
+	var chains [][]Value
+	// The latest end of a committed version removed.
+	var ended uint64
+	done := true
+	n := 0
+	iter := d.store.Iter()
+	for ok := iter.Seek(p.next); ok && (p.end == "" || iter.Key() < p.end); ok = iter.Next() {
+		if limit > 0 && n == limit {
+			p.next = iter.Key()
+			done = false
+			break
+		}
+		n++
+
+		versions := iter.Value()
+		keepFrom := d.retainedVersions(versions)
+		if p.aggressive {
+			keepFrom = len(versions)
+		}
+		live := versions[:0]
+		if d.frozen > 0 {
+			// A frozen store may share the chain (see frozen.go).
+			live = make([]Value, 0, len(versions))
+		}
+		for i := range versions {
+			v := &versions[i]
+			if d.dead(v, p.horizon) &&
+				(i < keepFrom || d.transactionState(v.txStartId) == AbortedTransaction) {
+				if d.transactionState(v.txStartId) != AbortedTransaction {
+					ended = max(ended, v.txEndId)
+				}
+				d.versionBytes -= versionSize(v)
+				continue
+			}
+
+			// A delete that was rolled back never happened.
+			if v.txEndId > 0 && d.transactionState(v.txEndId) == AbortedTransaction {
+				v.txEndId = 0
+			}
+
+			if v.txStartId < p.horizon {
+				p.referenced.Insert(v.txStartId)
+			}
+			if v.txEndId > 0 && v.txEndId < p.horizon {
+				p.referenced.Insert(v.txEndId)
+			}
+			live = append(live, *v)
+		}
+		if d.tangled.Contains(iter.Key()) && d.untangle(live, p.horizon) {
+			d.tangled.Delete(iter.Key())
+		}
+		if len(live) == len(versions) {
+			continue
+		}
+
+		p.removed += len(versions) - len(live)
+		d.metrics.vacuumed.Add(float64(len(versions) - len(live)))
+		if d.frozen == 0 {
+			clear(versions[len(live):])
+		}
+		keys = append(keys, iter.Key())
+		chains = append(chains, live)
+	}
+
+	// Changing the tree while iterating would invalidate the iterator.
+	removed := 0
+	for i, key := range keys {
+		if len(chains[i]) == 0 {
+			d.versionBytes -= keySize(key)
+			d.store.Delete(key)
+			removed++
+		} else {
+			d.store.Set(key, chains[i])
+		}
+	}
+	d.keysRemoved(removed)
+	d.vacuumedVersion(ended)
+
+	return done
+}
+
+// finishVacuum forgets the aborted transactions a completed pass found no
+// reference to, and that no transaction in progress might restore one
+// to.
+func (d *Database) finishVacuum(p *vacuumPass) {
+	// A transaction in progress can still put back an end mark it set
+	// over another's (see endVisible).
+	running := d.running.Iter()
+	for ok := running.First(); ok; ok = running.Next() {
+		t, _ := d.transactions.Get(running.Key())
+		for _, e := range t.ended {
+			p.referenced.Insert(e.end)
+		}
+	}
+
+	var ids []uint64
+	iter := d.aborted.Iter()
+	// A frozen store's chains may refer to any of them.
+	for ok := d.frozen == 0 && iter.First(); ok && iter.Key() < p.horizon; ok = iter.Next() {
+		if !p.referenced.Contains(iter.Key()) {
+			ids = append(ids, iter.Key())
+		}
+	}
+	for _, id := range ids {
+		d.aborted.Delete(id)
+	}
+
+	d.logger.Debug("vacuumed", "versions", p.removed, "aborted transactions", len(ids), "horizon", p.horizon)
+}
+
+// vacuum
+func (c *Connection) vacuum(args []string) (Result, error) {
+	if len(args) != 0 {
+		return Result{}, fmt.Errorf("vacuum: takes no arguments")
+	}
+	return Result{Value: fmt.Sprintf("%d", c.db.Vacuum())}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestVacuum(t *testing.T) {
+	database := newDatabase()
+
+	c1 := database.newConnection()
+	c1.mustExecCommand("set", []string{"x", "one"})
+	c1.mustExecCommand("set", []string{"x", "two"})
+
+	c1.mustExecCommand("begin", nil)
+	c1.mustExecCommand("set", []string{"x", "rolled back"})
+	c1.mustExecCommand("abort", nil)
+
+	// An open Repeatable Read transaction still needs "two".
+	reader := database.newConnection()
+	reader.mustExecCommand("begin", nil)
+	reader.tx.isolation = RepeatableReadIsolation
+
+	c1.mustExecCommand("set", []string{"x", "three"})
+	c1.mustExecCommand("set", []string{"y", "gone"})
+	c1.mustExecCommand("delete", []string{"y"})
+
+	// "one" and the aborted write go; "two" is pinned by the reader.
+	res := c1.mustExecCommand("vacuum", nil)
+	assertEq(res, "2", "first vacuum")
+	assertEq(len(database.versions("x")), 2, "versions of x")
+	assertEq(reader.mustExecCommand("get", []string{"x"}), "two", "reader still sees its snapshot")
+
+	reader.mustExecCommand("commit", nil)
+
+	res = c1.mustExecCommand("vacuum", nil)
+	assertEq(res, "2", "second vacuum")
+	assertEq(len(database.versions("x")), 1, "versions of x")
+	_, ok := database.store.Get("y")
+	assert(!ok, "fully deleted key removed")
+	assertEq(c1.mustExecCommand("get", []string{"x"}), "three", "latest value survives")
+
+	res = c1.mustExecCommand("vacuum", nil)
+	assertEq(res, "0", "nothing left to vacuum")
+}
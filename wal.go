@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+/*
+The write-ahead log records what transactions did, in the order they did
+it, so that a database can be rebuilt after a crash. It is the foundation
+for durability, recovery and replication.
+
+Only transactions that write anything are logged. A transaction's begin
+record is written just before its first write, and its commit or abort
+record when it completes. A transaction is durable once its commit record
+has been appended and synced; if that fails, the transaction is aborted
+instead, and the commit returns the error. A transaction without a commit
+record in the log never happened, as far as recovery is concerned.
+
+The log is an interface so tests can use MemoryWAL. FileWAL appends
+records to a file, one frame each (see format.go):
+
+	lsn, type, txid, key, value, expiresAt[, valueType]
+
+where valueType is left out for strings.
+
+A crash can leave a partially written frame at the end of the file, which
+is dropped when the log is opened. A bad checksum anywhere else is
+corruption. Once a write or sync of the file fails, so does every append
+after it, until the log is opened again (see faults.go).
+*/
+
+var ErrCorruptWAL = errors.New("corrupt write-ahead log")
+
+type WALRecordType uint8
+
+const (
+	WALBegin WALRecordType = iota + 1
+	WALSet
+	WALDelete
+	WALCommit
+	WALAbort
+	// For savepoints, with the savepoint's name as the key.
+	WALSavepoint
+	WALRollbackTo
+	WALRelease
+	// A nested transaction committing into its parent.
+	WALMerge
+	// A transaction prepared to commit, with its global id as the key.
+	WALPrepare
+)
+
+func (t WALRecordType) String() string {
+	switch t {
+	case WALBegin:
+		return "begin"
+	case WALSet:
+		return "set"
+	case WALDelete:
+		return "delete"
+	case WALCommit:
+		return "commit"
+	case WALAbort:
+		return "abort"
+	case WALSavepoint:
+		return "savepoint"
+	case WALRollbackTo:
+		return "rollback-to"
+	case WALRelease:
+		return "release"
+	case WALMerge:
+		return "merge"
+	case WALPrepare:
+		return "prepare"
+	}
+	return fmt.Sprintf("WALRecordType(%d)", uint8(t))
+}
+
+type WALRecord struct {
+	// Sequence number, assigned by the log on append. The first record
+	// is 1.
+	LSN  uint64
+	Type WALRecordType
+	TxId uint64
+
+	// For sets and deletes. A nested transaction's begin record has its
+	// parent's id as the value.
+	Key       string
+	Value     string
+	ExpiresAt uint32
+	// The type of the value set, stored as Value (see types.go).
+	ValueType ValueType
+}
+
+type WAL interface {
+	// Append assigns rec the next LSN and adds it to the log.
+	Append(rec *WALRecord) error
+	// Sync makes everything appended so far durable.
+	Sync() error
+	// Replay calls fn with every record in the log, in order.
+	Replay(fn func(WALRecord) error) error
+	// LastLSN returns the LSN of the last record appended.
+	LastLSN() uint64
+	// Truncate drops the records up to and including LSN through.
+	Truncate(through uint64) error
+	Close() error
+}
+
+// log appends a record for t, beginning its part of the log first if
+// need be. A failure is kept on the transaction, and fails its commit.
+func (t *Transaction) log(rec WALRecord) {
+	if t.db.wal == nil || t.walErr != nil {
+		return
+	}
+
+	if t.logBegin(); t.walErr != nil {
+		return
+	}
+	t.append(rec)
+}
+
+// append appends rec to the log and ships it. It is apart from log so
+// that rec only escapes to the heap when there is a log to append it to.
+func (t *Transaction) append(rec WALRecord) {
+	rec.TxId = t.id
+	if t.walErr = t.db.wal.Append(&rec); t.walErr == nil {
+		t.db.ship(rec)
+	}
+}
+
+// logBegin appends t's begin record if it hasn't been, after its
+// parent's, since recovery needs the parent to nest t in.
+func (t *Transaction) logBegin() {
+	if t.logged || t.walErr != nil {
+		return
+	}
+
+	begin := WALRecord{Type: WALBegin, TxId: t.id}
+	if t.parent != nil {
+		if t.parent.logBegin(); t.parent.walErr != nil {
+			t.walErr = t.parent.walErr
+			return
+		}
+		begin.Value = strconv.FormatUint(t.parent.id, 10)
+	}
+	t.logged = true
+	if t.walErr = t.db.wal.Append(&begin); t.walErr == nil {
+		t.db.ship(begin)
+	}
+}
+
+// logCompletion appends t's commit or abort record. Committing also syncs
+// the log, unless t is part of a write batch's commit group, and fails if
+// any of t's records couldn't be written.
+func (d *Database) logCompletion(t *Transaction, state TransactionState) error {
+	if d.wal == nil || !t.logged {
+		return nil
+	}
+
+	if state == AbortedTransaction {
+		// Without a commit record the transaction is aborted anyway.
+		abort := WALRecord{Type: WALAbort, TxId: t.id}
+		if d.wal.Append(&abort) == nil {
+			d.ship(abort)
+		}
+		return nil
+	}
+
+	if t.walErr != nil {
+		return fmt.Errorf("write-ahead log: %w", t.walErr)
+	}
+	commit := WALRecord{Type: WALCommit, TxId: t.id, Value: strconv.FormatUint(uint64(t.committedAt), 10)}
+	if err := d.wal.Append(&commit); err != nil {
+		return fmt.Errorf("write-ahead log: %w", err)
+	}
+	if d.group != nil {
+		d.group.add(t, commit)
+		return nil
+	}
+	if err := d.wal.Sync(); err != nil {
+		return fmt.Errorf("write-ahead log: %w", err)
+	}
+	// Followers only hear of the commit once it is durable, since until
+	// then it may yet be aborted.
+	d.ship(commit)
+	return nil
+}
+
+// MemoryWAL keeps the log in memory. It survives nothing, but makes
+// what was logged easy to check.
+type MemoryWAL struct {
+	mu      sync.Mutex
+	records []WALRecord
+	lastLSN uint64
+}
+
+func (w *MemoryWAL) Append(rec *WALRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastLSN++
+	rec.LSN = w.lastLSN
+	w.records = append(w.records, *rec)
+	return nil
+}
+
+func (w *MemoryWAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastLSN
+}
+
+func (w *MemoryWAL) Truncate(through uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	i := 0
+	for i < len(w.records) && w.records[i].LSN <= through {
+		i++
+	}
+	w.records = slices.Delete(w.records, 0, i)
+	return nil
+}
+
+func (w *MemoryWAL) Sync() error  { return nil }
+func (w *MemoryWAL) Close() error { return nil }
+
+func (w *MemoryWAL) Replay(fn func(WALRecord) error) error {
+	w.mu.Lock()
+	records := append([]WALRecord(nil), w.records...)
+	w.mu.Unlock()
+
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileWAL appends the log to a file.
+type FileWAL struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	nextLSN uint64
+
+	// See sync.go.
+	syncMode   SyncMode
+	syncerDone chan struct{}
+	// Where failed periodic syncs are reported.
+	logger *slog.Logger
+
+	// Where faults are injected, if anywhere, the error the log failed
+	// with, if it has, and how much of the file is known to be synced.
+	// See faults.go.
+	faults FaultInjector
+	failed error
+	synced int64
+}
+
+// OpenFileWAL opens the log at path, creating it if need be. Appends
+// continue from the last complete record in the file.
+func OpenFileWAL(path string) (*FileWAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &FileWAL{path: path, f: f, nextLSN: 1, logger: slog.New(slog.DiscardHandler)}
+	end, err := w.scan(func(rec WALRecord) error {
+		w.nextLSN = rec.LSN + 1
+		return nil
+	})
+	if err == nil {
+		// Drop whatever a crash left after the last complete record.
+		err = f.Truncate(end)
+	}
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.synced = end
+	return w, nil
+}
+
+// name returns the log file's name, which faults are injected by.
+func (w *FileWAL) name() string {
+	return filepath.Base(w.path)
+}
+
+func (w *FileWAL) Append(rec *WALRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failed != nil {
+		return fmt.Errorf("%w: %w", ErrWALFailed, w.failed)
+	}
+	rec.LSN = w.nextLSN
+	if _, err := writeFile(w.faults, w.name(), w.f, encodeWALFrame(*rec)); err != nil {
+		// Whatever part of the frame was written is dropped when the log
+		// is next opened, but anything appended after it would be lost
+		// with it.
+		w.failed = err
+		return err
+	}
+	w.nextLSN++
+	return nil
+}
+
+func (w *FileWAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failed != nil {
+		return fmt.Errorf("%w: %w", ErrWALFailed, w.failed)
+	}
+	if w.syncMode != SyncEveryCommit {
+		return nil
+	}
+	return w.sync()
+}
+
+// sync syncs the file. If that fails, whatever was written since the
+// last sync may or may not be on disk, so it is dropped, and the log
+// fails.
+func (w *FileWAL) sync() error {
+	end, err := w.f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		err = syncFile(w.faults, w.name(), w.f)
+	}
+	if err != nil {
+		w.failed = err
+		w.f.Truncate(w.synced)
+		return err
+	}
+	w.synced = end
+	return nil
+}
+
+func (w *FileWAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopSyncer()
+	if w.syncMode == SyncPeriodically && w.failed == nil {
+		if err := w.sync(); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	return w.f.Close()
+}
+
+func (w *FileWAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.nextLSN - 1
+}
+
+// Truncate copies the records after through to a new file, which then
+// replaces the log.
+func (w *FileWAL) Truncate(through uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failed != nil {
+		return fmt.Errorf("%w: %w", ErrWALFailed, w.failed)
+	}
+
+	tmp, err := os.Create(w.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	out := bufio.NewWriter(faultWriter{w.faults, w.name(), tmp})
+	_, err = w.scan(func(rec WALRecord) error {
+		if rec.LSN <= through {
+			return nil
+		}
+		_, err := out.Write(encodeWALFrame(rec))
+		return err
+	})
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = syncFile(w.faults, w.name(), tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), w.path)
+	}
+	if err != nil {
+		tmp.Close()
+		w.f.Seek(0, io.SeekEnd)
+		return err
+	}
+
+	w.f.Close()
+	w.f = tmp
+	w.synced, err = w.f.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (w *FileWAL) Replay(fn func(WALRecord) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	offset, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	defer w.f.Seek(offset, io.SeekStart)
+
+	_, err = w.scan(fn)
+	return err
+}
+
+// scan calls fn with each complete record from the start of the file,
+// and returns the offset just past the last of them.
+func (w *FileWAL) scan(fn func(WALRecord) error) (int64, error) {
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(w.f)
+	var end int64
+	for {
+		rec, n, err := readWALFrame(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return end, nil
+		}
+		if err != nil {
+			return end, fmt.Errorf("%w at offset %d: %w", ErrCorruptWAL, end, err)
+		}
+		if err := fn(rec); err != nil {
+			return end, err
+		}
+		end += int64(n)
+	}
+}
+
+func encodeWALFrame(rec WALRecord) []byte {
+	return appendFrame(nil, encodeWALRecord(rec))
+}
+
+func encodeWALRecord(rec WALRecord) frameBody {
+	body := frameBody(nil).uvarint(rec.LSN)
+	body = append(body, byte(rec.Type))
+	body = body.uvarint(rec.TxId).string(rec.Key).string(rec.Value).uint32(rec.ExpiresAt)
+	if rec.ValueType != StringType {
+		body = append(body, byte(rec.ValueType))
+	}
+	return body
+}
+
+// readWALFrame reads one frame, returning the record and the frame's
+// size. A frame cut short returns io.ErrUnexpectedEOF.
+func readWALFrame(r io.Reader) (WALRecord, int, error) {
+	body, n, err := readFrame(r)
+	if err != nil {
+		return WALRecord{}, 0, err
+	}
+	rec, err := decodeWALRecord(body)
+	return rec, n, err
+}
+
+func decodeWALRecord(body []byte) (WALRecord, error) {
+	br := frameReader{b: body}
+	rec := WALRecord{
+		LSN:       br.uvarint(),
+		Type:      WALRecordType(br.byte()),
+		TxId:      br.uvarint(),
+		Key:       br.string(),
+		Value:     br.string(),
+		ExpiresAt: br.uint32(),
+	}
+	if len(br.b) > 0 {
+		rec.ValueType = ValueType(br.byte())
+	}
+	if err := br.done(); err != nil {
+		return WALRecord{}, err
+	}
+	return rec, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func walRecords(w WAL) []WALRecord {
+	var records []WALRecord
+	err := w.Replay(func(rec WALRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	assertEq(err, nil, "replay")
+	return records
+}
+
+func TestWAL(t *testing.T) {
+	database := newDatabase()
+	wal := &MemoryWAL{}
+	database.wal = wal
+	now := time.Unix(1000, 0)
+	database.now = func() time.Time { return now }
+
+	c := database.newConnection()
+	c.execCommand("get", []string{"x"})
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "hey"})
+	c.mustExecCommand("delete", []string{"x"})
+	c.mustExecCommand("commit", nil)
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"y", "no"})
+	c.mustExecCommand("abort", nil)
+
+	// The read-only transaction isn't logged.
+	assert(slices.Equal(walRecords(wal), []WALRecord{
+		{LSN: 1, Type: WALBegin, TxId: 2},
+		{LSN: 2, Type: WALSet, TxId: 2, Key: "x", Value: "hey"},
+		{LSN: 3, Type: WALDelete, TxId: 2, Key: "x"},
+		// The commit carries its timestamp (see hlc.go).
+		{LSN: 4, Type: WALCommit, TxId: 2, Value: strconv.FormatUint(uint64(newHLC(now, 0)), 10)},
+		{LSN: 5, Type: WALBegin, TxId: 3},
+		{LSN: 6, Type: WALSet, TxId: 3, Key: "y", Value: "no"},
+		{LSN: 7, Type: WALAbort, TxId: 3},
+	}), "records")
+}
+
+type failingWAL struct {
+	MemoryWAL
+}
+
+func (w *failingWAL) Sync() error { return errors.New("disk on fire") }
+
+func TestWAL_commitFailure(t *testing.T) {
+	database := newDatabase()
+	database.wal = &failingWAL{}
+
+	c := database.newConnection()
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("set", []string{"x", "hey"})
+	_, err := c.execCommand("commit", nil)
+	assertEq(err.Error(), "write-ahead log: disk on fire", "commit")
+
+	_, err = c.execCommand("get", []string{"x"})
+	assertEq(err, ErrKeyNotFound, "commit was aborted")
+}
+
+func TestFileWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+
+	wal, err := OpenFileWAL(path)
+	assertEq(err, nil, "open")
+	records := []WALRecord{
+		{Type: WALBegin, TxId: 1},
+		{Type: WALSet, TxId: 1, Key: "x", Value: "hey", ExpiresAt: 123},
+		{Type: WALCommit, TxId: 1},
+	}
+	for i := range records {
+		assertEq(wal.Append(&records[i]), nil, "append")
+	}
+	assertEq(wal.Close(), nil, "close")
+
+	// A torn write at the end of the file is dropped on open.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write(encodeWALFrame(WALRecord{LSN: 4, Type: WALBegin, TxId: 2})[:5])
+	f.Close()
+
+	wal, err = OpenFileWAL(path)
+	assertEq(err, nil, "reopen")
+	assert(slices.Equal(walRecords(wal), records), "records after reopen")
+
+	// Appends carry on from the last record.
+	next := WALRecord{Type: WALBegin, TxId: 2}
+	assertEq(wal.Append(&next), nil, "append after reopen")
+	assertEq(next.LSN, uint64(4), "lsn after reopen")
+	assertEq(len(walRecords(wal)), 4, "records after append")
+	wal.Close()
+
+	// Damage in the middle of the log is an error.
+	data, _ := os.ReadFile(path)
+	data[10] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+	_, err = OpenFileWAL(path)
+	assert(errors.Is(err, ErrCorruptWAL), "corrupt log")
+}
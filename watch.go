@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+A watch follows one key, or every key with a prefix, and is notified of
+every committed change to it. Monitoring use cases rarely care about every
+change though, so a watch can carry a predicate that is evaluated on
+commit, in the database, and only matching changes are queued for the
+subscriber.
+
+Queuing never blocks the committing transaction: each watch buffers its
+pending changes until the subscriber gets around to calling Next.
+
+A watch's cursor says how far through the committed changes it has got,
+so a subscriber that goes away can resume where it left off with a new
+watch, from the changes the change feed keeps (see changefeed.go). One
+that saves the cursor after handling each change gets every change at
+least once: after a crash, it may see the last one again. Cursors are
+only good for the database that issued them, and not after a restart.
+*/
+
+var (
+	ErrWatchClosed   = errors.New("watch is closed")
+	ErrInvalidCursor = errors.New("invalid watch cursor")
+)
+
+// A Predicate decides whether a change is worth notifying about.
+type Predicate func(Change) bool
+
+// ChangedTo matches changes that leave the key holding value.
+func ChangedTo(value string) Predicate {
+	return func(c Change) bool {
+		return c.NewExists && c.New == value && (!c.OldExists || c.Old != value)
+	}
+}
+
+// CrossesAbove matches changes that take a numeric value from below
+// threshold to at or above it. A key that didn't exist counts as below.
+func CrossesAbove(threshold float64) Predicate {
+	return func(c Change) bool {
+		before, beforeOk := parseNumber(c.Old, c.OldExists)
+		after, afterOk := parseNumber(c.New, c.NewExists)
+		return afterOk && after >= threshold && (!beforeOk || before < threshold)
+	}
+}
+
+// CrossesBelow matches changes that take a numeric value from at or above
+// threshold to below it. A key that didn't exist counts as above.
+func CrossesBelow(threshold float64) Predicate {
+	return func(c Change) bool {
+		before, beforeOk := parseNumber(c.Old, c.OldExists)
+		after, afterOk := parseNumber(c.New, c.NewExists)
+		return afterOk && after < threshold && (!beforeOk || before >= threshold)
+	}
+}
+
+func parseNumber(s string, exists bool) (float64, bool) {
+	if !exists {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return n, err == nil
+}
+
+type Watch struct {
+	db     *Database
+	key    string
+	prefix bool
+	pred   Predicate
+
+	mu     sync.Mutex
+	queue  []watchItem
+	ready  chan struct{}
+	closed bool
+	// Where the watch is: after the last change Next returned, or where
+	// it started.
+	cursor watchCursor
+}
+
+// watchItem is a queued change, with the place in the feed of the commit
+// that made it.
+type watchItem struct {
+	Change
+	seq uint64
+}
+
+// WatchOptions says what a watch follows, and from where.
+type WatchOptions struct {
+	Key string
+	// Follow every key starting with Key.
+	Prefix bool
+	// Which changes to notify of; nil matches every change.
+	Predicate Predicate
+	// Resume after the change the cursor was taken at, rather than with
+	// the next commit.
+	Cursor string
+}
+
+// Watch notifies of committed changes to key matching pred. A nil pred
+// matches every change.
+func (d *Database) Watch(key string, pred Predicate) *Watch {
+	w, _ := d.WatchWith(WatchOptions{Key: key, Predicate: pred})
+	return w
+}
+
+// WatchPrefix notifies of committed changes to keys starting with prefix
+// matching pred.
+func (d *Database) WatchPrefix(prefix string, pred Predicate) *Watch {
+	w, _ := d.WatchWith(WatchOptions{Key: prefix, Prefix: true, Predicate: pred})
+	return w
+}
+
+// WatchWith starts a watch as opts say. Resuming from a cursor fails with
+// ErrChangesTruncated if the changes after it are no longer kept.
+func (d *Database) WatchWith(opts WatchOptions) (*Watch, error) {
+	w := &Watch{
+		db:     d,
+		key:    opts.Key,
+		prefix: opts.Prefix,
+		pred:   opts.Predicate,
+		ready:  make(chan struct{}, 1),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w.cursor = watchCursor{Version: 1, Epoch: d.feedEpoch, Seq: d.feedNext}
+	if opts.Cursor != "" {
+		cur, err := decodeWatchCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		first := d.feedNext - uint64(len(d.feed))
+		if cur.Epoch != d.feedEpoch || cur.Seq < first || cur.Seq > d.feedNext {
+			return nil, ErrChangesTruncated
+		}
+		w.cursor = cur
+		for seq := cur.Seq; seq < d.feedNext; seq++ {
+			for _, c := range d.feed[seq-first].changes {
+				if seq == cur.Seq && cur.Started && c.Key <= cur.After {
+					continue
+				}
+				if w.matches(c) {
+					w.queue = append(w.queue, watchItem{c, seq})
+				}
+			}
+		}
+	}
+
+	if d.watches == nil {
+		d.watches = map[*Watch]struct{}{}
+	}
+	d.watches[w] = struct{}{}
+	return w, nil
+}
+
+// Next waits for the next matching change.
+func (w *Watch) Next(ctx context.Context) (Change, error) {
+	for {
+		if c, ok := w.take(); ok {
+			return c, nil
+		}
+		w.mu.Lock()
+		closed := w.closed
+		w.mu.Unlock()
+
+		if closed {
+			return Change{}, ErrWatchClosed
+		}
+
+		select {
+		case <-w.ready:
+		case <-ctx.Done():
+			return Change{}, ctx.Err()
+		}
+	}
+}
+
+// take returns the next queued change, if there is one.
+func (w *Watch) take() (Change, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.queue) == 0 {
+		return Change{}, false
+	}
+	item := w.queue[0]
+	w.queue = w.queue[1:]
+	w.cursor.Seq, w.cursor.After, w.cursor.Started = item.seq, item.Key, true
+	return item.Change, true
+}
+
+// Cursor returns an opaque cursor for resuming after the last change Next
+// returned, or if it hasn't returned any, from where the watch started.
+func (w *Watch) Cursor() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b, _ := json.Marshal(w.cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+type watchCursor struct {
+	Version int    `json:"v"`
+	Epoch   uint64 `json:"e"`
+	// The commit the watch is at, counting from the database's first
+	// commit, and if it has started on its changes, the key of the last
+	// one it got.
+	Seq     uint64 `json:"s"`
+	Started bool   `json:",omitempty"`
+	After   string `json:",omitempty"`
+}
+
+func decodeWatchCursor(cursor string) (watchCursor, error) {
+	var cur watchCursor
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return cur, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &cur); err != nil || cur.Version != 1 {
+		return cur, ErrInvalidCursor
+	}
+	return cur, nil
+}
+
+// Close stops the watch. Changes already queued are dropped.
+func (w *Watch) Close() {
+	w.db.mu.Lock()
+	delete(w.db.watches, w)
+	w.db.mu.Unlock()
+
+	w.mu.Lock()
+	w.closed = true
+	w.queue = nil
+	w.mu.Unlock()
+
+	w.signal()
+}
+
+func (w *Watch) signal() {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Watch) push(item watchItem) {
+	w.mu.Lock()
+	w.queue = append(w.queue, item)
+	w.mu.Unlock()
+
+	w.signal()
+}
+
+func (w *Watch) matches(c Change) bool {
+	if w.prefix && !strings.HasPrefix(c.Key, w.key) || !w.prefix && c.Key != w.key {
+		return false
+	}
+	return w.pred == nil || w.pred(c)
+}
+
+// notifyWatches queues changes, made by the commit at seq in the change
+// feed, for the watches they match.
+func (d *Database) notifyWatches(changes []Change, seq uint64) {
+	for _, c := range changes {
+		for w := range d.watches {
+			if w.matches(c) {
+				w.push(watchItem{c, seq})
+			}
+		}
+	}
+}
+
+// watch key [cursor]
+// watch-prefix prefix [cursor]
+//
+// Returns a cursor for the changes after those returned, then one line
+// per change since cursor, or if there is none, since the watch began: the
+// key, and for keys set, "=" and the value. Clients poll with the cursor
+// they were last given.
+func (c *Connection) watch(command string, args []string) (Result, error) {
+	opts := WatchOptions{Key: args[0], Prefix: command == "watch-prefix"}
+	if len(args) > 1 {
+		opts.Cursor = args[1]
+	}
+	w, err := c.db.WatchWith(opts)
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %w", command, err)
+	}
+	defer w.Close()
+
+	var res Result
+	var lines []string
+	for {
+		change, ok := w.take()
+		if !ok {
+			break
+		}
+		l := Lookup{Key: change.Key, Value: change.New, Found: change.NewExists}
+		res.Lookups = append(res.Lookups, l)
+		if l.Found {
+			lines = append(lines, l.Key+"="+l.Value)
+		} else {
+			lines = append(lines, l.Key)
+		}
+	}
+	res.Value = strings.Join(append([]string{w.Cursor()}, lines...), "\n")
+	return res, nil
+}
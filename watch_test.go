@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchPredicates(t *testing.T) {
+	database := newDatabase()
+
+	all := database.Watch("temp", nil)
+	hot := database.Watch("temp", CrossesAbove(100))
+	cold := database.Watch("temp", CrossesBelow(0))
+	done := database.Watch("status", ChangedTo("done"))
+	defer all.Close()
+	defer hot.Close()
+	defer cold.Close()
+	defer done.Close()
+
+	for _, temp := range []string{"20", "120", "130", "90", "-5", "-10"} {
+		database.Set("temp", temp)
+	}
+	database.Set("status", "running")
+	database.Set("status", "done")
+	database.Set("status", "done")
+
+	// Uncommitted and aborted writes never notify.
+	tx, _ := database.Begin()
+	tx.Set("temp", "500")
+	tx.Abort()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, want := range []string{"20", "120", "130", "90", "-5", "-10"} {
+		c, err := all.Next(ctx)
+		assertEq(err, nil, "next temp")
+		assertEq(c.New, want, "temp change")
+	}
+
+	c, _ := hot.Next(ctx)
+	assertEq(c.Old+"->"+c.New, "20->120", "hot change")
+
+	c, _ = cold.Next(ctx)
+	assertEq(c.Old+"->"+c.New, "90->-5", "cold change")
+
+	c, _ = done.Next(ctx)
+	assertEq(c.Old+"->"+c.New, "running->done", "done change")
+
+	// Nothing else matched.
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	for _, w := range []*Watch{all, hot, cold, done} {
+		_, err := w.Next(short)
+		assertEq(err, context.DeadlineExceeded, "no more changes")
+	}
+
+	all.Close()
+	_, err := all.Next(ctx)
+	assertEq(err, ErrWatchClosed, "closed watch")
+}
+
+func TestWatchPrefix(t *testing.T) {
+	database := newDatabase()
+	w := database.WatchPrefix("user/", nil)
+	defer w.Close()
+
+	tx, _ := database.Begin()
+	tx.Set("user/2", "bob")
+	tx.Set("user/1", "alice")
+	tx.Set("group/1", "admins")
+	tx.Commit()
+	database.Delete("user/2")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var got []string
+	for range 3 {
+		c, err := w.Next(ctx)
+		assertEq(err, nil, "next")
+		got = append(got, c.Key+"="+c.New)
+	}
+	assertEq(strings.Join(got, ","), "user/1=alice,user/2=bob,user/2=", "prefix changes in commit order")
+}
+
+func TestWatch_cursor(t *testing.T) {
+	database := newDatabase()
+	w := database.WatchPrefix("k", nil)
+	start := w.Cursor()
+
+	tx, _ := database.Begin()
+	tx.Set("k1", "a")
+	tx.Set("k2", "b")
+	tx.Commit()
+	database.Set("k3", "c")
+	database.Set("other", "x")
+
+	// The subscriber handles k1 and goes away before k2.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, _ := w.Next(ctx)
+	assertEq(c.Key, "k1", "first")
+	cursor := w.Cursor()
+	w.Close()
+
+	// Resuming picks up in the middle of the transaction.
+	w, err := database.WatchWith(WatchOptions{Key: "k", Prefix: true, Cursor: cursor})
+	assertEq(err, nil, "resume")
+	defer w.Close()
+	for _, want := range []string{"k2", "k3"} {
+		c, err := w.Next(ctx)
+		assertEq(err, nil, "next")
+		assertEq(c.Key, want, "resumed change")
+	}
+	database.Set("k4", "d")
+	c, _ = w.Next(ctx)
+	assertEq(c.Key, "k4", "then live changes")
+
+	// As does the cursor of a watch that never got anything.
+	from, err := database.WatchWith(WatchOptions{Key: "k3", Cursor: start})
+	assertEq(err, nil, "resume from start")
+	c, _ = from.Next(ctx)
+	assertEq(c.Key+"="+c.New, "k3=c", "from the start")
+	from.Close()
+
+	_, err = database.WatchWith(WatchOptions{Key: "k", Cursor: "junk"})
+	assertEq(err, ErrInvalidCursor, "bad cursor")
+	other := newDatabase()
+	_, err = other.WatchWith(WatchOptions{Key: "k", Cursor: cursor})
+	assertEq(err, ErrChangesTruncated, "another database's cursor")
+}
+
+func TestWatchCommand(t *testing.T) {
+	database := newDatabase()
+	database.apply(WithBucket("app", SnapshotIsolation))
+	c := database.newConnection()
+	c.mustExecCommand("use", []string{"app"})
+
+	cursor := c.mustExecCommand("watch-prefix", []string{"cfg/"})
+	assert(!strings.Contains(cursor, "\n"), "nothing yet, just a cursor")
+	c.mustExecCommand("set", []string{"cfg/a", "1"})
+	c.mustExecCommand("set", []string{"cfg/b", "2"})
+	c.mustExecCommand("delete", []string{"cfg/a"})
+	c.mustExecCommand("set", []string{"data", "3"})
+
+	lines := strings.Split(c.mustExecCommand("watch-prefix", []string{"cfg/", cursor}), "\n")
+	assertEq(strings.Join(lines[1:], ","), "cfg/a=1,cfg/b=2,cfg/a", "changes in the bucket")
+	lines = strings.Split(c.mustExecCommand("watch", []string{"cfg/b", cursor}), "\n")
+	assertEq(strings.Join(lines[1:], ","), "cfg/b=2", "one key")
+
+	// Polling again with the new cursor gets only what is new.
+	assertEq(c.mustExecCommand("watch", []string{"cfg/b", lines[0]}), lines[0], "nothing new")
+	c.mustExecCommand("set", []string{"cfg/b", "4"})
+	lines = strings.Split(c.mustExecCommand("watch", []string{"cfg/b", lines[0]}), "\n")
+	assertEq(strings.Join(lines[1:], ","), "cfg/b=4", "new change")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+A sorted set holds distinct members with a score each, in order of score,
+for leaderboards and priority queues. It is versioned like a set (see
+set.go): every change writes the whole sorted set as a new version. Ties
+in score are ordered by member, and zrange takes ranks, counting from the
+end if negative, as lrange does.
+
+Members are kept in order, each followed by its score as eight bytes
+big-endian, and read as a string the sorted set is a JSON array of
+[member, score] pairs.
+*/
+
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+func compareScored(a, b ScoredMember) int {
+	return cmp.Or(cmp.Compare(a.Score, b.Score), strings.Compare(a.Member, b.Member))
+}
+
+func encodeSortedSet(members []ScoredMember) string {
+	var b frameBody
+	for _, m := range members {
+		b = b.string(m.Member).string(encodeFloat(m.Score))
+	}
+	return string(b)
+}
+
+func decodeSortedSet(raw string) []ScoredMember {
+	var members []ScoredMember
+	r := frameReader{b: []byte(raw)}
+	for len(r.b) > 0 && r.err == nil {
+		member, score := r.string(), r.string()
+		if r.err == nil {
+			members = append(members, ScoredMember{member, decodeFloat(score)})
+		}
+	}
+	return members
+}
+
+func formatSortedSet(members []ScoredMember) string {
+	pairs := make([][2]any, len(members))
+	for i, m := range members {
+		pairs[i] = [2]any{m.Member, m.Score}
+	}
+	return encodeJSON(pairs)
+}
+
+func parseSortedSet(s string) ([]ScoredMember, error) {
+	var pairs [][2]any
+	if err := json.Unmarshal([]byte(s), &pairs); err != nil {
+		return nil, err
+	}
+	var members []ScoredMember
+	for _, p := range pairs {
+		member, ok := p[0].(string)
+		score, ok2 := p[1].(float64)
+		if !ok || !ok2 {
+			return nil, errors.New("expected [member, score] pairs")
+		}
+		members = append(members, ScoredMember{member, score})
+	}
+	slices.SortFunc(members, compareScored)
+	return members, nil
+}
+
+// sortedSet returns the members of the sorted set held by key, in order,
+// or none if the key is missing. The caller must hold the lock.
+func (t *Transaction) sortedSet(key string) ([]ScoredMember, error) {
+	value, err := t.get(key)
+	if err != nil {
+		return nil, nil
+	}
+	if value.data.kind != SortedSetType {
+		return nil, wrongType(key, value.data.kind, SortedSetType)
+	}
+	raw, err := t.db.readRaw(value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSortedSet(raw), nil
+}
+
+// ZAdd adds members to the sorted set held by key, or changes their
+// scores if it has them already, returning how many it didn't have.
+func (t *Transaction) ZAdd(key string, members ...ScoredMember) (int, error) {
+	for _, m := range members {
+		if math.IsNaN(m.Score) || math.IsInf(m.Score, 0) {
+			return 0, fmt.Errorf("score of %q is not a finite number", m.Member)
+		}
+	}
+
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return 0, err
+	}
+	set, err := t.sortedSet(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, m := range members {
+		if i := slices.IndexFunc(set, func(s ScoredMember) bool { return s.Member == m.Member }); i >= 0 {
+			set = slices.Delete(set, i, i+1)
+		} else {
+			added++
+		}
+		i, _ := slices.BinarySearchFunc(set, m, compareScored)
+		set = slices.Insert(set, i, m)
+	}
+	t.write(key, encodeSortedSet(set), SortedSetType, 0)
+	return added, nil
+}
+
+// ZRange returns the members of the sorted set held by key ranked from
+// start to stop, inclusive. Negative ranks count from the end, -1 being
+// the highest scoring.
+func (t *Transaction) ZRange(key string, start, stop int) ([]ScoredMember, error) {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+
+	if err := t.checkInProgress(); err != nil {
+		return nil, err
+	}
+	set, err := t.sortedSet(key)
+	if err != nil {
+		return nil, err
+	}
+	start, stop = rankRange(len(set), start, stop)
+	return set[start:stop], nil
+}
+
+// zadd key score member...
+func (c *Connection) zadd(args []string) (Result, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return Result{}, fmt.Errorf("zadd: expected a key and score member pairs")
+	}
+	members := make([]ScoredMember, len(args)/2)
+	for i := range members {
+		score, err := strconv.ParseFloat(args[1+2*i], 64)
+		if err != nil {
+			return Result{}, fmt.Errorf("zadd: bad score %q", args[1+2*i])
+		}
+		members[i] = ScoredMember{args[2+2*i], score}
+	}
+
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	n, err := c.tx.ZAdd(args[0], members...)
+	if err != nil {
+		return res, err
+	}
+	res.Value = strconv.Itoa(n)
+	return res, nil
+}
+
+// zrange key start stop [withscores]
+func (c *Connection) zrange(args []string) (Result, error) {
+	withScores := len(args) == 4
+	if withScores && !strings.EqualFold(args[3], "withscores") {
+		return Result{}, fmt.Errorf("zrange: expected withscores, not %q", args[3])
+	}
+	start, stop, err := parseRank("zrange", args[1], args[2])
+	if err != nil {
+		return Result{}, err
+	}
+	members, err := c.tx.ZRange(args[0], start, stop)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Keys: args[:1], TxId: c.tx.id}
+	lines := make([]string, len(members))
+	for i, m := range members {
+		res.Values = append(res.Values, m.Member)
+		lines[i] = m.Member
+		if withScores {
+			score := strconv.FormatFloat(m.Score, 'g', -1, 64)
+			res.Pairs = append(res.Pairs, KeyValue{m.Member, score})
+			lines[i] += "=" + score
+		}
+	}
+	res.Value = strings.Join(lines, "\n")
+	return res, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSortedSet(t *testing.T) {
+	database := newDatabase()
+	c := database.newConnection()
+
+	assertEq(c.mustExecCommand("zadd", []string{"board", "30", "cat", "10", "ann", "20", "bob"}), "3", "zadd")
+	assertEq(c.mustExecCommand("zadd", []string{"board", "40", "ann", "20", "dan"}), "1", "one new, one moved")
+	assertEq(c.mustExecCommand("zrange", []string{"board", "0", "-1"}), "bob\ndan\ncat\nann", "by score, then member")
+	assertEq(c.mustExecCommand("zrange", []string{"board", "-2", "-1", "withscores"}), "cat=30\nann=40", "top two")
+	assertEq(c.mustExecCommand("get", []string{"board"}), `[["bob",20],["dan",20],["cat",30],["ann",40]]`, "as a string")
+	assertEq(c.mustExecCommand("type", []string{"board"}), "zset", "type")
+
+	_, err := c.execCommand("zadd", []string{"board", "high", "eve"})
+	assert(err != nil, "bad score")
+	_, err = c.execCommand("zadd", []string{"board", "inf", "eve"})
+	assert(err != nil, "infinite score")
+	_, err = c.execCommand("zrange", []string{"board", "0", "1", "withvalues"})
+	assert(err != nil, "bad option")
+
+	c.mustExecCommand("begin", nil)
+	c.mustExecCommand("zadd", []string{"board", "50", "eve"})
+	c.mustExecCommand("abort", nil)
+	assertEq(c.mustExecCommand("zrange", []string{"board", "-1", "-1"}), "ann", "aborted zadd")
+
+	c.mustExecCommand("sadd", []string{"set", "x"})
+	_, err = c.execCommand("zrange", []string{"set", "0", "-1"})
+	assert(errors.Is(err, ErrWrongType), "not a sorted set")
+}